@@ -21,7 +21,10 @@ package v1
 import (
 	"encoding/json"
 
+	v3 "github.com/rancher/rke/types"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor"
 	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
 	"github.com/wutong-paas/cloud-adaptor/internal/model"
 	corev1 "k8s.io/api/core/v1"
 )
@@ -85,6 +88,14 @@ type CreateKubernetesReq struct {
 	EncodedRKEConfig string `json:"encodedRKEConfig"`
 	// custom
 	KubeConfig string `json:"kubeconfig,omitempty"`
+	// Preflight check the nodes before installing, rke only
+	Preflight bool `json:"preflight,omitempty"`
+	// SSHKeyType the type of the ssh key generated for the cluster, rke only, ed25519 if empty
+	SSHKeyType string `json:"sshKeyType,omitempty" binding:"omitempty,oneof=rsa ed25519"`
+	// Bastion the jump host to connect the nodes through, rke only
+	Bastion *v1alpha1.BastionHost `json:"bastion,omitempty"`
+	// NodeBastions the jump hosts of nodes keyed by node address, rke only
+	NodeBastions v1alpha1.NodeBastions `json:"nodeBastions,omitempty"`
 }
 
 // UpdateKubernetesReq update kubernetes req
@@ -99,6 +110,158 @@ type UpdateKubernetesReq struct {
 	ETCDNodeNum        int    `json:"etcdNodeNum,omitempty"`
 	InstanceType       string `json:"instanceType,omitempty"`
 	EncodedRKEConfig   string `json:"encodedRKEConfig"`
+	// Preflight check the nodes before updating, rke only
+	Preflight bool `json:"preflight,omitempty"`
+	// Bastion the jump host to connect the nodes through, rke only
+	Bastion *v1alpha1.BastionHost `json:"bastion,omitempty"`
+	// NodeBastions the jump hosts of nodes keyed by node address, rke only, the current ones are kept if omitted
+	NodeBastions v1alpha1.NodeBastions `json:"nodeBastions,omitempty"`
+}
+
+// UpgradeKubernetesReq upgrade the kubernetes version of cluster
+//
+//swagger:model UpgradeKubernetesReq
+type UpgradeKubernetesReq struct {
+	Provider          string `json:"provider" binding:"required"`
+	KubernetesVersion string `json:"kubernetesVersion" binding:"required"`
+	// SystemImages the system images matching the target kubernetes version
+	SystemImages    *v3.RKESystemImages     `json:"systemImages" binding:"required"`
+	UpgradeStrategy *v3.NodeUpgradeStrategy `json:"upgradeStrategy,omitempty"`
+}
+
+// EtcdSnapshotReq save an etcd snapshot, or restore cluster from the named snapshot
+//
+//swagger:model EtcdSnapshotReq
+type EtcdSnapshotReq struct {
+	Provider string `json:"provider" binding:"required"`
+	// Name the name of snapshot, generated by default when saving
+	Name string `json:"name"`
+}
+
+// ListEtcdSnapshotsReq list etcd snapshots of cluster
+//
+//swagger:model ListEtcdSnapshotsReq
+type ListEtcdSnapshotsReq struct {
+	ProviderName string `form:"provider_name" binding:"required"`
+}
+
+// EtcdSnapshotListRes etcd snapshot list
+//
+//swagger:model EtcdSnapshotListRes
+type EtcdSnapshotListRes struct {
+	Snapshots []*v1alpha1.EtcdSnapshotInfo `json:"snapshots"`
+	// BackupConfig the scheduled snapshot config, nil if it is not enabled
+	BackupConfig *v3.BackupConfig `json:"backupConfig,omitempty"`
+}
+
+// EtcdBackupConfigReq set the scheduled etcd snapshot config of cluster
+//
+//swagger:model EtcdBackupConfigReq
+type EtcdBackupConfigReq struct {
+	Provider     string           `json:"provider" binding:"required"`
+	BackupConfig *v3.BackupConfig `json:"backupConfig" binding:"required"`
+}
+
+// ListCertificatesReq list the certificates of cluster
+//
+//swagger:model ListCertificatesReq
+type ListCertificatesReq struct {
+	ProviderName string `form:"provider_name" binding:"required"`
+}
+
+// CertificateListRes certificate list
+//
+//swagger:model CertificateListRes
+type CertificateListRes struct {
+	Certificates []*v1alpha1.CertificateInfo `json:"certificates"`
+}
+
+// PreflightReq check the nodes before installing kubernetes on them
+//
+//swagger:model PreflightReq
+type PreflightReq struct {
+	Provider string `json:"provider" binding:"required"`
+	// Nodes override the nodes of the rke config
+	Nodes            v1alpha1.NodeList `json:"nodes,omitempty"`
+	EncodedRKEConfig string            `json:"encodedRKEConfig,omitempty"`
+	// Bastion the jump host to connect the nodes through
+	Bastion *v1alpha1.BastionHost `json:"bastion,omitempty"`
+	// NodeBastions the jump hosts of nodes keyed by node address
+	NodeBastions v1alpha1.NodeBastions `json:"nodeBastions,omitempty"`
+}
+
+// ListClusterStatesReq list the state versions of cluster
+//
+//swagger:model ListClusterStatesReq
+type ListClusterStatesReq struct {
+	ProviderName string `form:"provider_name" binding:"required"`
+}
+
+// ClusterStateListRes cluster state version list
+//
+//swagger:model ClusterStateListRes
+type ClusterStateListRes struct {
+	States []*v1alpha1.ClusterStateVersion `json:"states"`
+}
+
+// RollbackClusterStateReq roll back the cluster state to the given version
+//
+//swagger:model RollbackClusterStateReq
+type RollbackClusterStateReq struct {
+	Provider string `json:"provider" binding:"required"`
+	Version  int    `json:"version" binding:"required,min=1"`
+}
+
+// RotateCertificatesReq rotate the certificates of cluster
+//
+//swagger:model RotateCertificatesReq
+type RotateCertificatesReq struct {
+	Provider string `json:"provider" binding:"required"`
+	// CACertificates rotate the CA certificates, all the certificates are rotated as well
+	CACertificates bool `json:"caCertificates"`
+	// Services the services whose certificates will be rotated, all services if empty
+	Services []string `json:"services" binding:"omitempty,dive,oneof=etcd kubelet kube-apiserver kube-proxy kube-scheduler kube-controller-manager"`
+}
+
+// GetSSHKeyReq get the ssh key of cluster
+//
+//swagger:model GetSSHKeyReq
+type GetSSHKeyReq struct {
+	ProviderName string `form:"provider_name" binding:"required"`
+}
+
+// RotateSSHKeyReq rotate the ssh key of cluster
+//
+//swagger:model RotateSSHKeyReq
+type RotateSSHKeyReq struct {
+	Provider string `json:"provider" binding:"required"`
+	// KeyType the type of the new key, ed25519 if empty
+	KeyType string `json:"keyType" binding:"omitempty,oneof=rsa ed25519"`
+}
+
+// GetSecretsEncryptionReq get the secrets encryption state of cluster
+//
+//swagger:model GetSecretsEncryptionReq
+type GetSecretsEncryptionReq struct {
+	ProviderName string `form:"provider_name" binding:"required"`
+}
+
+// SecretsEncryptionReq enable the secrets encryption of cluster or rotate its key
+//
+//swagger:model SecretsEncryptionReq
+type SecretsEncryptionReq struct {
+	Provider string `json:"provider" binding:"required"`
+}
+
+// RemoveNodesReq remove nodes from cluster
+//
+//swagger:model RemoveNodesReq
+type RemoveNodesReq struct {
+	Provider string `json:"provider" binding:"required"`
+	// Nodes the addresses of the nodes to be removed
+	Nodes []string `json:"nodes" binding:"required,min=1,dive,ip"`
+	// Drain the options to drain the nodes before removed
+	Drain *v3.NodeDrainInput `json:"drain,omitempty"`
 }
 
 // CreateKubernetesRes create kubernetes res
@@ -129,6 +292,11 @@ type GetLastCreateKubernetesClusterTaskReq struct {
 //swagger:model DeleteKubernetesClusterReq
 type DeleteKubernetesClusterReq struct {
 	ProviderName string `form:"provider_name" binding:"required"`
+	// Mode destroy removes kubernetes from all nodes before the cluster is deleted, it runs as an async task.
+	Mode string `form:"mode" binding:"omitempty,oneof=destroy"`
+	// Confirm is required to destroy the cluster which the wutong region is installed on,
+	// and to delete the cluster whose cloud resources are released with it, such as ack and tke.
+	Confirm bool `form:"confirm"`
 }
 
 // GetCreateKubernetesClusterTaskRes create kubernetes res
@@ -150,6 +318,53 @@ type GetTaskEventListReq struct {
 //swagger:model TaskEventListRes
 type TaskEventListRes struct {
 	Events []*model.TaskEvent `json:"events"`
+	// Steps the plan of task with the status of each step
+	Steps []domain.TaskPlanStep `json:"steps,omitempty"`
+	// Progress the percentage of the planned steps succeeded, it is 100 only if the task succeeds
+	Progress int `json:"progress"`
+}
+
+// CancelTaskRes the result of cancelling task
+//
+//swagger:model CancelTaskRes
+type CancelTaskRes struct {
+	TaskID string `json:"taskID"`
+	// Status cancelled if the task is cancelled at once, or cancelling if it is running and will stop at the next step
+	Status string `json:"status"`
+}
+
+// TaskInfo the task with its state in the queue
+//
+//swagger:model TaskInfo
+type TaskInfo struct {
+	TaskID       string `json:"taskID"`
+	ClusterID    string `json:"clusterID"`
+	ProviderName string `json:"providerName"`
+	TaskType     string `json:"taskType"`
+	Status       string `json:"status"`
+	// QueueStatus the status of the task in the queue, pending, running, done, failed or cancelled,
+	// it is empty if the task is not in the queue
+	QueueStatus string `json:"queueStatus,omitempty"`
+	// QueuePosition the position of the pending task in the queue, starting from 1
+	QueuePosition int64 `json:"queuePosition,omitempty"`
+	// Steps the plan of task with the status of each step
+	Steps []domain.TaskPlanStep `json:"steps,omitempty"`
+	// Progress the percentage of the planned steps succeeded, it is 100 only if the task succeeds
+	Progress int `json:"progress"`
+}
+
+// CloudResourceListRes cloud resources created by adaptor
+//
+//swagger:model CloudResourceListRes
+type CloudResourceListRes struct {
+	Resources []*model.CloudResource `json:"resources"`
+}
+
+// ProviderListRes registered providers and their capabilities
+//
+//swagger:model ProviderListRes
+type ProviderListRes struct {
+	Providers []*adaptor.Provider `json:"providers"`
 }
 
 // InitWutongRegionReq init wutong region
@@ -204,6 +419,21 @@ type UpdateInitWutongTaskStatusReq struct {
 	Status string `json:"status" binding:"required"`
 }
 
+// InitNodeCmdReq the options of the node to be initialized
+//
+//swagger:model InitNodeCmdReq
+type InitNodeCmdReq struct {
+	// User the ssh user used by rke, it is created if not exists, default docker
+	User string `form:"user"`
+	// Hostname the hostname is set if not empty
+	Hostname string `form:"hostname" binding:"omitempty,hostname_rfc1123"`
+	// DockerVersion default 19.03.5
+	DockerVersion string `form:"dockerVersion"`
+	// ClusterID the rke cluster the node joins, the public key of the cluster is authorized on the node if it has one.
+	// The shared ssh key of adaptor is authorized otherwise.
+	ClusterID string `form:"clusterID"`
+}
+
 // InitNodeCmdRes init node cmd
 //
 //swagger:model InitNodeCmdRes
@@ -219,6 +449,15 @@ type GetLogContentRes struct {
 	Content string `json:"content"`
 }
 
+// CreateLogChunk a chunk of the create log of cluster
+//
+//swagger:model CreateLogChunk
+type CreateLogChunk struct {
+	// Offset the offset of the end of the chunk in the log file, the stream is resumed from it
+	Offset  int64  `json:"offset"`
+	Content string `json:"content"`
+}
+
 // GetKubeConfigRes get kubernetes cluster kubeconfig file
 //
 //swagger:model GetKubeConfigRes
@@ -262,6 +501,10 @@ type UpdateKubernetesTask struct {
 	Provider   string `json:"providerName"`
 	NodeNumber int    `json:"nodeNumber"`
 	Status     string `json:"status"`
+	// TaskType is empty for expansion node, or upgrade, etcd-snapshot, etcd-restore, rotate-certificates,
+	// enable-secrets-encryption, rotate-encryption-key, remove-nodes, destroy
+	TaskType          string `json:"taskType,omitempty"`
+	KubernetesVersion string `json:"kubernetesVersion,omitempty"`
 }
 
 // PruneUpdateRKEConfigReq -
@@ -0,0 +1,93 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package v1
+
+import (
+	"time"
+
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+)
+
+// WebhookReq create or update a webhook, the empty filters match all events
+//
+//swagger:model WebhookReq
+type WebhookReq struct {
+	Name string `json:"name" binding:"required"`
+	URL  string `json:"url" binding:"required,url"`
+	// Secret the key to sign the payloads, generated on creating if empty, and kept on updating if empty
+	Secret    string   `json:"secret,omitempty"`
+	TaskTypes []string `json:"taskTypes,omitempty"`
+	StepTypes []string `json:"stepTypes,omitempty"`
+	Statuses  []string `json:"statuses,omitempty" binding:"omitempty,dive,oneof=start success failure retry"`
+	// Enabled true by default
+	Enabled *bool `json:"enabled,omitempty"`
+}
+
+// WebhookInfo a webhook subscribing the task events
+//
+//swagger:model WebhookInfo
+type WebhookInfo struct {
+	WebhookID string   `json:"webhookID"`
+	Name      string   `json:"name"`
+	URL       string   `json:"url"`
+	TaskTypes []string `json:"taskTypes"`
+	StepTypes []string `json:"stepTypes"`
+	Statuses  []string `json:"statuses"`
+	Enabled   bool     `json:"enabled"`
+	// Secret returned only once the webhook is created with a generated secret
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createTime"`
+}
+
+// WebhookListRes -
+//
+//swagger:model WebhookListRes
+type WebhookListRes struct {
+	Webhooks []*WebhookInfo `json:"webhooks"`
+}
+
+// ListWebhookDeliveriesReq -
+//
+//swagger:model ListWebhookDeliveriesReq
+type ListWebhookDeliveriesReq struct {
+	// Limit the number of the latest deliveries, 50 by default
+	Limit int `form:"limit" binding:"omitempty,min=1,max=500"`
+}
+
+// WebhookDeliveryListRes -
+//
+//swagger:model WebhookDeliveryListRes
+type WebhookDeliveryListRes struct {
+	Deliveries []*model.WebhookDelivery `json:"deliveries"`
+}
+
+// WebhookPayload the body posted to the webhook, it is signed with the secret of the webhook by HMAC-SHA256
+// and the signature is set in the X-Wutong-Signature-256 header as sha256=<hex digest>.
+//
+//swagger:model WebhookPayload
+type WebhookPayload struct {
+	DeliveryID   string           `json:"deliveryID"`
+	WebhookID    string           `json:"webhookID"`
+	TaskID       string           `json:"taskID"`
+	TaskType     string           `json:"taskType"`
+	ClusterID    string           `json:"clusterID"`
+	ProviderName string           `json:"providerName"`
+	Event        *model.TaskEvent `json:"event"`
+	Timestamp    int64            `json:"timestamp"`
+}
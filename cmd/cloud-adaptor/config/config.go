@@ -19,8 +19,12 @@
 package config
 
 import (
+	"errors"
+	"net"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	cli "github.com/urfave/cli/v2"
@@ -37,12 +41,59 @@ type Config struct {
 	DB        *DB
 	NSQConfig *NSQConfig
 	Helm      *Helm
+	// ResourceGC the orphan cloud resources reconcile config
+	ResourceGC *ResourceGC
+	// RKEStateStore where the rke cluster states are stored, db or local
+	RKEStateStore string
+	// AdvertiseURL the url of adaptor that the nodes can reach
+	AdvertiseURL string
+	// AllowedHosts the hosts of adaptor the nodes can reach, the host requested is used only if it is allowed
+	// and AdvertiseURL is not set. The hosts of the listen address are allowed if neither of them is set.
+	AllowedHosts []string
+	// EncryptKey the secret to encrypt the sensitive data saved in the database, such as the ssh keys of clusters
+	EncryptKey string
+	// TaskScheduler the limits of the tasks running at the same time
+	TaskScheduler *TaskScheduler
+	// TaskBackend the transport of the task messages, db, channel or nsq
+	TaskBackend string
+}
+
+// The transports of the task messages.
+const (
+	// TaskBackendDB the durable queue in the database, the tasks survive the restarts of adaptor
+	TaskBackendDB = "db"
+	// TaskBackendChannel the in-memory channel, the tasks are lost once adaptor exits
+	TaskBackendChannel = "channel"
+	// TaskBackendNSQ the nsq topics, the replicas of adaptor share the tasks and the events
+	TaskBackendNSQ = "nsq"
+)
+
+// ErrEncryptKeyRequired the encrypt key is not set while the db task backend is used
+var ErrEncryptKeyRequired = errors.New("--encrypt-key is required by the db task backend")
+
+// ErrRKEStateEncryptKeyRequired the encrypt key is not set while the rke cluster states are stored in the database
+var ErrRKEStateEncryptKeyRequired = errors.New("--encrypt-key is required by the db rke state store")
+
+// UseDBTaskBackend returns whether the tasks are queued in the database, it is the default backend.
+func (c *Config) UseDBTaskBackend() bool {
+	return c.TaskBackend == "" || c.TaskBackend == TaskBackendDB
+}
+
+// UseDBRKEStateStore returns whether the rke cluster states are stored in the database, it is the default store.
+func (c *Config) UseDBRKEStateStore() bool {
+	return c.RKEStateStore == "" || c.RKEStateStore == "db"
 }
 
 //NSQConfig config
 type NSQConfig struct {
 	NsqLookupdAddress string
 	NsqdAddress       string
+	// Concurrency the max number of the messages of a topic held at the same time in one replica, including the waiting tasks
+	Concurrency int
+	// MaxAttempts the times a message is delivered before it is given up, 0 means no limit
+	MaxAttempts int
+	// RequeueDelay the delay of the requeued message, multiplied by its attempts
+	RequeueDelay time.Duration
 }
 
 // DB holds configurations for database.
@@ -54,6 +105,20 @@ type DB struct {
 	Name string
 }
 
+// ResourceGC holds configurations for orphan cloud resources reconcile.
+type ResourceGC struct {
+	Interval    time.Duration
+	AutoRelease bool
+}
+
+// TaskScheduler holds configurations for the task scheduler.
+type TaskScheduler struct {
+	// Workers the max number of the tasks running at the same time in one replica, 0 means no limit
+	Workers int
+	// ProviderWorkers the max number of the tasks of the providers running at the same time in one replica
+	ProviderWorkers map[string]int
+}
+
 // Helm holds configurations for helm.
 type Helm struct {
 	RepoFile  string
@@ -87,6 +152,51 @@ func parseIntByEnvAndCtx(ctx *cli.Context, name, envName string) int {
 	return ctx.Int(name)
 }
 
+func parseDurationByEnvAndCtx(ctx *cli.Context, name, envName string) time.Duration {
+	if os.Getenv(envName) != "" {
+		parsed, err := time.ParseDuration(os.Getenv(envName))
+		if err == nil {
+			return parsed
+		}
+	}
+	return ctx.Duration(name)
+}
+
+// parseListByEnvAndCtx parses the value like a,b
+func parseListByEnvAndCtx(ctx *cli.Context, name, envName string) []string {
+	var res []string
+	for _, item := range strings.Split(parseByEnvAndCtx(ctx, name, envName), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
+		}
+	}
+	return res
+}
+
+// parseIntMapByEnvAndCtx parses the value like rke=2,ack=1
+func parseIntMapByEnvAndCtx(ctx *cli.Context, name, envName string) map[string]int {
+	res := make(map[string]int)
+	value := parseByEnvAndCtx(ctx, name, envName)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			logrus.Warningf("ignore invalid %s %s", name, item)
+			continue
+		}
+		parsed, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil {
+			logrus.Warningf("ignore invalid %s %s", name, item)
+			continue
+		}
+		res[strings.TrimSpace(kv[0])] = parsed
+	}
+	return res
+}
+
 //GetDefaultConfig get default config
 func GetDefaultConfig(ctx *cli.Context) *Config {
 	return &Config{
@@ -96,6 +206,9 @@ func GetDefaultConfig(ctx *cli.Context) *Config {
 		NSQConfig: &NSQConfig{
 			NsqLookupdAddress: parseByEnvAndCtx(ctx, "nsq-lookupd-server", "NSQ_LOOKUPD_SERVER"),
 			NsqdAddress:       parseByEnvAndCtx(ctx, "nsqd-server", "NSQD_SERVER"),
+			Concurrency:       parseIntByEnvAndCtx(ctx, "nsq-concurrency", "NSQ_CONCURRENCY"),
+			MaxAttempts:       parseIntByEnvAndCtx(ctx, "nsq-max-attempts", "NSQ_MAX_ATTEMPTS"),
+			RequeueDelay:      parseDurationByEnvAndCtx(ctx, "nsq-requeue-delay", "NSQ_REQUEUE_DELAY"),
 		},
 		DB: &DB{
 			Host: parseByEnvAndCtx(ctx, "dbAddr", "MYSQL_HOST"),
@@ -108,15 +221,56 @@ func GetDefaultConfig(ctx *cli.Context) *Config {
 			RepoFile:  parseByEnvAndCtx(ctx, "helm-repo-file", "HELM_REPO_FILE"),
 			RepoCache: parseByEnvAndCtx(ctx, "helm-cache", "HELM_CACHE"),
 		},
+		ResourceGC: &ResourceGC{
+			Interval:    parseDurationByEnvAndCtx(ctx, "resource-gc-interval", "RESOURCE_GC_INTERVAL"),
+			AutoRelease: parseBoolByEnvAndCtx(ctx, "resource-gc-release", "RESOURCE_GC_RELEASE"),
+		},
+		RKEStateStore: parseByEnvAndCtx(ctx, "rke-state-store", "RKE_STATE_STORE"),
+		AdvertiseURL:  parseByEnvAndCtx(ctx, "advertise-url", "ADVERTISE_URL"),
+		AllowedHosts:  parseListByEnvAndCtx(ctx, "allowed-hosts", "ALLOWED_HOSTS"),
+		EncryptKey:    parseByEnvAndCtx(ctx, "encrypt-key", "ENCRYPT_KEY"),
+		TaskScheduler: &TaskScheduler{
+			Workers:         parseIntByEnvAndCtx(ctx, "task-workers", "TASK_WORKERS"),
+			ProviderWorkers: parseIntMapByEnvAndCtx(ctx, "task-provider-workers", "TASK_PROVIDER_WORKERS"),
+		},
+		TaskBackend: parseByEnvAndCtx(ctx, "task-backend", "TASK_BACKEND"),
 	}
 }
 
 //Parse parse  command
 func Parse(ctx *cli.Context) {
 	c := GetDefaultConfig(ctx)
+	if c.AdvertiseURL == "" && len(c.AllowedHosts) == 0 {
+		c.AllowedHosts = defaultAllowedHosts(parseByEnvAndCtx(ctx, "listen", "LISTEN"))
+		logrus.Infof("--advertise-url and --allowed-hosts are not set, the hosts %s are allowed to init the nodes", strings.Join(c.AllowedHosts, ","))
+	}
 	C = c
 }
 
+// defaultAllowedHosts returns the hosts of the listen address, they are the addresses of all interfaces with
+// the listen port if the adaptor listens on all of them.
+func defaultAllowedHosts(listen string) []string {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return nil
+	}
+	if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+		return []string{listen}
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		logrus.Warningf("list the interface addresses failure %s", err.Error())
+		return nil
+	}
+	var hosts []string
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			hosts = append(hosts, net.JoinHostPort(ipnet.IP.String(), port))
+		}
+	}
+	return hosts
+}
+
 // SetLogLevel Set log level
 func SetLogLevel() {
 	// Output to stdout instead of the default stderr
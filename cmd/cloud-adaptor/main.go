@@ -23,6 +23,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -31,8 +32,9 @@ import (
 	"github.com/wutong-paas/cloud-adaptor/internal/datastore"
 	"github.com/wutong-paas/cloud-adaptor/internal/handler"
 	"github.com/wutong-paas/cloud-adaptor/internal/nsqc"
+	"github.com/wutong-paas/cloud-adaptor/internal/repo"
 	"github.com/wutong-paas/cloud-adaptor/internal/task"
-	"github.com/wutong-paas/cloud-adaptor/internal/types"
+	"github.com/wutong-paas/cloud-adaptor/internal/usecase"
 
 	// Import all dependent packages in main.go for swag to generate doc.
 	// More detail: https://github.com/swaggo/swag/issues/817#issuecomment-730895033
@@ -65,6 +67,11 @@ func main() {
 				Value: false,
 				Usage: "A trigger to enable test mode.",
 			},
+			&cli.BoolFlag{
+				Name:  "isOffline",
+				Value: false,
+				Usage: "The nodes can not reach the internet, docker is downloaded from adaptor.",
+			},
 			&cli.StringFlag{
 				Name:    "logLevel",
 				Value:   "debug",
@@ -93,7 +100,22 @@ func main() {
 				Name:    "nsq-lookupd-server",
 				Aliases: []string{"lookupd"},
 				Value:   "127.0.0.1:4161",
-				Usage:   "nsq lookupd server address",
+				Usage:   "nsq lookupd server address, nsqd-server is connected directly if it is empty",
+			},
+			&cli.IntFlag{
+				Name:  "nsq-concurrency",
+				Value: 4,
+				Usage: "the max number of the messages of a topic held at the same time in one replica with the nsq task backend, including the tasks waiting for the workers and the cluster locks",
+			},
+			&cli.IntFlag{
+				Name:  "nsq-max-attempts",
+				Value: 5,
+				Usage: "the times a nsq message is delivered before it is given up, 0 means no limit",
+			},
+			&cli.DurationFlag{
+				Name:  "nsq-requeue-delay",
+				Value: 30 * time.Second,
+				Usage: "the delay of the nsq message requeued on failure, multiplied by its attempts",
 			},
 			&cli.StringFlag{
 				Name:    "listen",
@@ -102,6 +124,48 @@ func main() {
 				Usage:   "daemon server listen address",
 				EnvVars: []string{"LISTEN"},
 			},
+			&cli.DurationFlag{
+				Name:  "resource-gc-interval",
+				Value: 30 * time.Minute,
+				Usage: "the interval to find orphan cloud resources, 0 means disable",
+			},
+			&cli.BoolFlag{
+				Name:  "resource-gc-release",
+				Value: false,
+				Usage: "release the orphan cloud resources automatically",
+			},
+			&cli.StringFlag{
+				Name:  "rke-state-store",
+				Value: "db",
+				Usage: "where the rke cluster states are stored, db or local. The states are encrypted in the database by --encrypt-key, it is required by the db store",
+			},
+			&cli.StringFlag{
+				Name:  "advertise-url",
+				Usage: "the url of adaptor that the nodes can reach to download the init script, such as http://192.168.1.1:8080, the requested host is used if it is allowed by --allowed-hosts when not set",
+			},
+			&cli.StringFlag{
+				Name:  "allowed-hosts",
+				Usage: "the hosts of adaptor separated by comma, such as 192.168.1.1:8080, the requested host is used only if it is allowed and advertise-url is not set. The listen address is allowed by default, or the addresses of all interfaces with the listen port if it listens on all of them",
+			},
+			&cli.StringFlag{
+				Name:  "encrypt-key",
+				Usage: "the secret to encrypt the ssh keys of clusters, the queued tasks and the rke cluster states saved in the database, it must be the same for all replicas and is required by the db task backend and the db rke state store",
+			},
+			&cli.IntFlag{
+				Name:  "task-workers",
+				Value: 10,
+				Usage: "the max number of the tasks running at the same time in one replica, 0 means no limit",
+			},
+			&cli.StringFlag{
+				Name:  "task-provider-workers",
+				Value: "rke=2",
+				Usage: "the max number of the tasks of the providers running at the same time in one replica, such as rke=2,ack=1",
+			},
+			&cli.StringFlag{
+				Name:  "task-backend",
+				Value: "db",
+				Usage: "the transport of the tasks, db, channel or nsq. The nsq and channel tasks run in the replica receiving them, they wait for the workers and the cluster locks there as the db tasks. The running tasks touch their nsq messages, so nsqd must run with a --max-msg-timeout longer than the longest task",
+			},
 		}, dbInfoFlag...),
 		Action: run,
 	}
@@ -119,17 +183,17 @@ func run(c *cli.Context) error {
 
 	config.Parse(c)
 	config.SetLogLevel()
+	if config.C.UseDBRKEStateStore() && config.C.EncryptKey == "" {
+		// the states backed up in the database must not depend on a key kept next to them
+		return config.ErrRKEStateEncryptKeyRequired
+	}
 
 	db := datastore.NewDB()
 	if err := datastore.AutoMigrate(db); err != nil {
 		return err
 	}
 
-	createChan := make(chan types.KubernetesConfigMessage, 10)
-	initChan := make(chan types.InitWutongConfigMessage, 10)
-	updateChan := make(chan types.UpdateKubernetesConfigMessage, 10)
-
-	engine, err := initApp(ctx, db, config.C, createChan, initChan, updateChan)
+	engine, err := initApp(ctx, db, config.C)
 	if err != nil {
 		return err
 	}
@@ -150,20 +214,30 @@ func run(c *cli.Context) error {
 }
 
 func newApp(ctx context.Context,
+	config *config.Config,
 	router *handler.Router,
-	createQueue chan types.KubernetesConfigMessage,
-	initQueue chan types.InitWutongConfigMessage,
-	updateQueue chan types.UpdateKubernetesConfigMessage,
-	createHandler task.CreateKubernetesTaskHandler,
-	initHandler task.CloudInitTaskHandler,
-	cloudUpdateTaskHandler task.UpdateKubernetesTaskHandler) *gin.Engine {
+	taskBackend *nsqc.TaskBackend,
+	taskEventRepo repo.TaskEventRepository,
+	clusterUsecase *usecase.ClusterUsecase,
+	definitions *task.TaskDefinitions,
+	cloudResourceUsecase *usecase.CloudResourceUsecase,
+	webhookUsecase *usecase.WebhookUsecase) *gin.Engine {
 	engine := router.NewRouter()
 	engine.Use(gin.Recovery())
 
-	msgConsumer := nsqc.NewTaskChannelConsumer(ctx, createQueue, initQueue, updateQueue, createHandler, initHandler, cloudUpdateTaskHandler)
+	msgConsumer := taskBackend.NewConsumer(ctx, definitions.TaskRegistry, taskEventRepo, clusterUsecase)
 	go func() {
-		_ = msgConsumer.Start()
+		if err := msgConsumer.Start(); err != nil {
+			logrus.Errorf("start task consumer: %+v", err)
+		}
 	}()
 
+	// retry the failed webhook deliveries, and resume the ones left pending
+	go webhookUsecase.Run(ctx, time.Second)
+
+	if config.ResourceGC.Interval > 0 {
+		go cloudResourceUsecase.Run(ctx, config.ResourceGC.Interval, config.ResourceGC.AutoRelease)
+	}
+
 	return engine
 }
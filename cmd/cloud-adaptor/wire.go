@@ -34,7 +34,6 @@ import (
 	"github.com/wutong-paas/cloud-adaptor/internal/repo"
 	"github.com/wutong-paas/cloud-adaptor/internal/repo/dao"
 	"github.com/wutong-paas/cloud-adaptor/internal/task"
-	"github.com/wutong-paas/cloud-adaptor/internal/types"
 	"github.com/wutong-paas/cloud-adaptor/internal/usecase"
 	"gorm.io/gorm"
 )
@@ -42,10 +41,7 @@ import (
 // initApp init the application.
 func initApp(context.Context,
 	*gorm.DB,
-	*config.Config,
-	chan types.KubernetesConfigMessage,
-	chan types.InitWutongConfigMessage,
-	chan types.UpdateKubernetesConfigMessage) (*gin.Engine, error) {
+	*config.Config) (*gin.Engine, error) {
 	panic(wire.Build(handler.ProviderSet, usecase.ProviderSet, repo.ProviderSet, task.ProviderSet,
 		nsqc.ProviderSet, dao.ProviderSet, middleware.ProviderSet, newApp))
 }
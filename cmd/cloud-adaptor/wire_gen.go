@@ -11,7 +11,7 @@ import (
 	"github.com/wutong-paas/cloud-adaptor/cmd/cloud-adaptor/config"
 	"github.com/wutong-paas/cloud-adaptor/internal/handler"
 	"github.com/wutong-paas/cloud-adaptor/internal/middleware"
-	"github.com/wutong-paas/cloud-adaptor/internal/nsqc/producer"
+	"github.com/wutong-paas/cloud-adaptor/internal/nsqc"
 	"github.com/wutong-paas/cloud-adaptor/internal/repo"
 	"github.com/wutong-paas/cloud-adaptor/internal/repo/appstore"
 	"github.com/wutong-paas/cloud-adaptor/internal/repo/dao"
@@ -36,7 +36,7 @@ import (
 // Injectors from wire.go:
 
 // initApp init the application.
-func initApp(contextContext context.Context, db *gorm.DB, configConfig *config.Config, arg chan types.KubernetesConfigMessage, arg2 chan types.InitWutongConfigMessage, arg3 chan types.UpdateKubernetesConfigMessage) (*gin.Engine, error) {
+func initApp(contextContext context.Context, db *gorm.DB, configConfig *config.Config) (*gin.Engine, error) {
 	appStoreDao := dao.NewAppStoreDao(db)
 	appTemplater := appstore.NewAppTemplater()
 	storer := appstore.NewStorer(appTemplater)
@@ -44,14 +44,26 @@ func initApp(contextContext context.Context, db *gorm.DB, configConfig *config.C
 	rkeClusterRepository := repo.NewRKEClusterRepo(db)
 	customClusterRepository := repo.NewCustomClusterRepository(db)
 	middlewareMiddleware := middleware.NewMiddleware(appStoreRepo, rkeClusterRepository, customClusterRepository)
-	taskProducer := producer.NewTaskChannelProducer(arg, arg2, arg3)
+	taskQueueRepository := repo.NewTaskQueueRepo(db)
+	taskRegistry := types.NewTaskRegistry()
+	taskBackend, err := nsqc.NewTaskBackend(configConfig, taskQueueRepository, taskRegistry)
+	if err != nil {
+		return nil, err
+	}
+	taskProducer := taskBackend.Producer
 	cloudAccesskeyRepository := repo.NewCloudAccessKeyRepo(db)
 	createKubernetesTaskRepository := repo.NewCreateKubernetesTaskRepo(db)
 	initWutongTaskRepository := repo.NewInitWutongRegionTaskRepo(db)
 	updateKubernetesTaskRepository := repo.NewUpdateKubernetesTaskRepo(db)
 	taskEventRepository := repo.NewTaskEventRepo(db)
 	wutongClusterConfigRepository := repo.NewWutongClusterConfigRepo(db)
-	clusterUsecase := usecase.NewClusterUsecase(db, taskProducer, cloudAccesskeyRepository, createKubernetesTaskRepository, initWutongTaskRepository, updateKubernetesTaskRepository, taskEventRepository, wutongClusterConfigRepository, rkeClusterRepository, customClusterRepository)
+	webhookRepository := repo.NewWebhookRepo(db)
+	webhookDeliveryRepository := repo.NewWebhookDeliveryRepo(db)
+	taskRepository := repo.NewTaskRepo(db)
+	webhookUsecase := usecase.NewWebhookUsecase(webhookRepository, webhookDeliveryRepository)
+	cloudResourceRepository := repo.NewCloudResourceRepo(db)
+	clusterLocker := taskBackend.Locker
+	clusterUsecase := usecase.NewClusterUsecase(db, taskProducer, cloudAccesskeyRepository, createKubernetesTaskRepository, initWutongTaskRepository, updateKubernetesTaskRepository, taskEventRepository, wutongClusterConfigRepository, rkeClusterRepository, customClusterRepository, taskQueueRepository, taskRepository, cloudResourceRepository, clusterLocker, taskRegistry, webhookUsecase)
 	clusterHandler := handler.NewClusterHandler(clusterUsecase)
 	appStoreUsecase := usecase.NewAppStoreUsecase(appStoreRepo)
 	templateVersioner := appstore.NewTemplateVersioner(configConfig)
@@ -59,10 +71,21 @@ func initApp(contextContext context.Context, db *gorm.DB, configConfig *config.C
 	appTemplate := usecase.NewAppTemplate(templateVersionRepo)
 	appStoreHandler := handler.NewAppStoreHandler(appStoreUsecase, appTemplate)
 	systemHandler := handler.NewSystemHandler(db)
-	router := handler.NewRouter(middlewareMiddleware, clusterHandler, appStoreHandler, systemHandler)
-	createKubernetesTaskHandler := task.NewCreateKubernetesTaskHandler(clusterUsecase)
-	cloudInitTaskHandler := task.NewCloudInitTaskHandler(clusterUsecase)
-	updateKubernetesTaskHandler := task.NewCloudUpdateTaskHandler(clusterUsecase)
-	engine := newApp(contextContext, router, arg, arg2, arg3, createKubernetesTaskHandler, cloudInitTaskHandler, updateKubernetesTaskHandler)
+	cloudResourceUsecase := usecase.NewCloudResourceUsecase(cloudResourceRepository, cloudAccesskeyRepository, initWutongTaskRepository, taskEventRepository)
+	cloudResourceHandler := handler.NewCloudResourceHandler(cloudResourceUsecase)
+	webhookHandler := handler.NewWebhookHandler(webhookUsecase)
+	router := handler.NewRouter(middlewareMiddleware, clusterHandler, appStoreHandler, systemHandler, cloudResourceHandler, webhookHandler)
+	callBackEvent, err := task.NewCallBackEvent(configConfig, clusterUsecase)
+	if err != nil {
+		return nil, err
+	}
+	createKubernetesTaskHandler := task.NewCreateKubernetesTaskHandler(callBackEvent)
+	cloudInitTaskHandler := task.NewCloudInitTaskHandler(callBackEvent)
+	updateKubernetesTaskHandler := task.NewCloudUpdateTaskHandler(callBackEvent)
+	taskDefinitions, err := task.NewTaskDefinitions(taskRegistry, createKubernetesTaskHandler, cloudInitTaskHandler, updateKubernetesTaskHandler, callBackEvent)
+	if err != nil {
+		return nil, err
+	}
+	engine := newApp(contextContext, configConfig, router, taskBackend, taskEventRepository, clusterUsecase, taskDefinitions, cloudResourceUsecase, webhookUsecase)
 	return engine, nil
 }
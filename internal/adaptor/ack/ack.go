@@ -26,10 +26,13 @@ import (
 	"sync"
 	"time"
 
+	"github.com/pkg/errors"
+	"github.com/wutong-paas/cloud-adaptor/pkg/bcode"
 	"github.com/wutong-paas/cloud-adaptor/pkg/util/versionutil"
 
 	"github.com/aliyun/alibaba-cloud-sdk-go/sdk"
-	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/errors"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth/credentials"
 	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
 	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/responses"
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
@@ -37,6 +40,8 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/wutong-paas/cloud-adaptor/internal/adaptor"
 	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
+	"github.com/wutong-paas/cloud-adaptor/pkg/util/retryutil"
 	wutongv1alpha1 "github.com/wutong-paas/wutong-operator/api/v1alpha1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/version"
@@ -48,11 +53,33 @@ type ackAdaptor struct {
 	accessKeyID     string
 	accessKeySecret string
 	client          *sdk.Client
+	recorder        adaptor.ResourceRecorder
+	// completedSteps the init steps completed by the previous task
+	completedSteps map[string]bool
+}
+
+func init() {
+	adaptor.Register(&adaptor.Provider{
+		Name: "ack",
+		Capabilities: adaptor.Capabilities{
+			NeedCredentials: true,
+			CreateCluster:   true,
+			ExpansionNode:   true,
+			DeleteCluster:   true,
+			CloudDB:         true,
+			CloudNAS:        true,
+			CloudLB:         true,
+		},
+		Adaptor: (*ackAdaptor)(nil),
+		New: func(accessKeyID, accessKeySecret string) (adaptor.WutongClusterAdaptor, error) {
+			return Create(accessKeyID, accessKeySecret)
+		},
+	})
 }
 
 // Create create ack adaptor
 func Create(accessKeyID, accessKeySecret string) (adaptor.CloudAdaptor, error) {
-	client, err := sdk.NewClientWithAccessKey("", accessKeyID, accessKeySecret)
+	client, err := sdk.NewClientWithOptions("", sdkConfig(), credentials.NewAccessKeyCredential(accessKeyID, accessKeySecret))
 	if err != nil {
 		return nil, err
 	}
@@ -63,6 +90,16 @@ func Create(accessKeyID, accessKeySecret string) (adaptor.CloudAdaptor, error) {
 	}, nil
 }
 
+// sdkConfig the config of the aliyun api clients. The requests are retried by the retry policies of the steps
+// instead of the sdk, otherwise the retries of both are multiplied.
+func sdkConfig() *sdk.Config {
+	return sdk.NewConfig().WithAutoRetry(false).WithMaxRetryTime(0)
+}
+
+func (a *ackAdaptor) credential() auth.Credential {
+	return credentials.NewAccessKeyCredential(a.accessKeyID, a.accessKeySecret)
+}
+
 func (a *ackAdaptor) newRequest(method string) *requests.CommonRequest {
 	request := requests.NewCommonRequest()
 	request.Method = method
@@ -105,15 +142,18 @@ func getInstanceType(set string) []string {
 	}
 }
 
-func (a *ackAdaptor) CreateWutongKubernetes(ctx context.Context, config *v1alpha1.KubernetesClusterConfig, rollback func(step, message, status string)) *v1alpha1.Cluster {
-	rollback("AllocateResource", "", "start")
+func (a *ackAdaptor) CreateWutongKubernetes(ctx context.Context, config *v1alpha1.KubernetesClusterConfig, rollback func(step domain.TaskStep, message, status string)) *v1alpha1.Cluster {
+	rollback(domain.TaskStepAllocateResource, "", "start")
 	// select instance resource type
 	//Resource type to be selected
 	var selectInstanceType string
 	var instanceTypes = getInstanceType(config.WorkerResourceType)
 	var zoneID string
 	for _, it := range instanceTypes {
-		zones, err := a.DescribeAvailableResourceZones(config.Region, it)
+		zones, err := retryutil.Call(ctx, domain.TaskStepAllocateResource.RetryPolicy(),
+			retryutil.EventNotify(domain.TaskStepAllocateResource, rollback), func() ([]*v1alpha1.AvailableResourceZone, error) {
+				return a.DescribeAvailableResourceZones(config.Region, it)
+			})
 		if err != nil {
 			logrus.Errorf("list available zones failure %s", err.Error())
 		}
@@ -129,28 +169,30 @@ func (a *ackAdaptor) CreateWutongKubernetes(ctx context.Context, config *v1alpha
 		}
 	}
 	if selectInstanceType == "" {
-		rollback("AllocateResource", "Unable to find a suitable instance type, it may be that the region is currently sold out.", "failure")
+		rollback(domain.TaskStepAllocateResource, "Unable to find a suitable instance type, it may be that the region is currently sold out.", "failure")
 		return nil
 	}
-	rollback("AllocateResource", selectInstanceType, "success")
-	rollback("SelectZone", "", "start")
+	rollback(domain.TaskStepAllocateResource, selectInstanceType, "success")
+	rollback(domain.TaskStepSelectZone, "", "start")
 	// select zone
-	rollback("SelectZone", zoneID, "success")
+	rollback(domain.TaskStepSelectZone, zoneID, "success")
 	if config.VpcID == "" {
-		rollback("CreateVPC", "", "start")
+		rollback(domain.TaskStepCreateVPC, "", "start")
 		// create vpc
 		vpc := &v1alpha1.VPC{
 			RegionID:  config.Region,
 			VpcName:   "wutong-default-vpc",
 			CidrBlock: "10.0.0.0/8",
 		}
-		if err := a.CreateVPC(vpc); err != nil {
-			rollback("CreateVPC", err.Error(), "failure")
+		if err := domain.TaskStepCreateVPC.RetryPolicy().Do(ctx, retryutil.EventNotify(domain.TaskStepCreateVPC, rollback), func() error {
+			return a.CreateVPC(vpc)
+		}); err != nil {
+			rollback(domain.TaskStepCreateVPC, err.Error(), "failure")
 			return nil
 		}
-		rollback("CreateVPC", vpc.VpcID, "success")
+		rollback(domain.TaskStepCreateVPC, vpc.VpcID, "success")
 		config.VpcID = vpc.VpcID
-		rollback("CreateVSWitch", "", "start")
+		rollback(domain.TaskStepCreateVSWitch, "", "start")
 		// create vswitch
 		vswitch := &v1alpha1.VSwitch{
 			RegionID:    vpc.RegionID,
@@ -159,22 +201,27 @@ func (a *ackAdaptor) CreateWutongKubernetes(ctx context.Context, config *v1alpha
 			VSwitchName: "wutong-default-vswitch",
 			ZoneID:      zoneID,
 		}
-		if err := a.CreateVSwitch(vswitch); err != nil {
-			rollback("CreateVSwitch", err.Error(), "failure")
+		if err := domain.TaskStepCreateVSWitch.RetryPolicy().Do(ctx, retryutil.EventNotify(domain.TaskStepCreateVSWitch, rollback), func() error {
+			return a.CreateVSwitch(vswitch)
+		}); err != nil {
+			rollback(domain.TaskStepCreateVSWitch, err.Error(), "failure")
 			return nil
 		}
-		rollback("CreateVSWitch", vswitch.VSwitchID, "success")
+		rollback(domain.TaskStepCreateVSWitch, vswitch.VSwitchID, "success")
 		config.VSwitchID = vswitch.VSwitchID
 	}
 	config.InstanceType = selectInstanceType
 	clusterConfig := v1alpha1.GetDefaultACKCreateClusterConfig(*config)
-	rollback("CreateCluster", "", "start")
-	cluster, err := a.CreateCluster(clusterConfig)
+	rollback(domain.TaskStepCreateCluster, "", "start")
+	cluster, err := retryutil.Call(ctx, domain.TaskStepCreateCluster.RetryPolicy(),
+		retryutil.EventNotify(domain.TaskStepCreateCluster, rollback), func() (*v1alpha1.Cluster, error) {
+			return a.CreateCluster(clusterConfig)
+		})
 	if err != nil {
-		rollback("CreateCluster", err.Error(), "failure")
+		rollback(domain.TaskStepCreateCluster, err.Error(), "failure")
 		return nil
 	}
-	rollback("CreateCluster", cluster.ClusterID, "success")
+	rollback(domain.TaskStepCreateCluster, cluster.ClusterID, "success")
 	return cluster
 }
 
@@ -254,7 +301,7 @@ func (a *ackAdaptor) CreateCluster(config v1alpha1.CreateClusterConfig) (*v1alph
 	request.Content = body
 	res, err := a.doRequest(request)
 	if err != nil {
-		return nil, fmt.Errorf("create ack cluster from alibaba api failure %s", err.Error())
+		return nil, fmt.Errorf("create ack cluster from alibaba api failure %w", err)
 	}
 	if !res.IsSuccess() {
 		return nil, fmt.Errorf("create ack cluster from alibaba api failure:%s", res.String())
@@ -274,7 +321,7 @@ func (a *ackAdaptor) GetKubeConfig(clusterID string) (*v1alpha1.KubeConfig, erro
 	request.PathPattern = "/k8s/" + clusterID + "/user_config"
 	res, err := a.doRequest(request)
 	if err != nil {
-		return nil, fmt.Errorf("query kube config from alibaba api failure %s", err.Error())
+		return nil, fmt.Errorf("query kube config from alibaba api failure %w", err)
 	}
 	if !res.IsSuccess() {
 		return nil, fmt.Errorf("query kube config from alibaba api failure:%s", res.String())
@@ -294,7 +341,10 @@ func (a *ackAdaptor) DescribeCluster(clusterID string) (*v1alpha1.Cluster, error
 	request.PathPattern = "/clusters/" + clusterID
 	res, err := a.doRequest(request)
 	if err != nil {
-		return nil, fmt.Errorf("query cluster info from alibaba api failure %s", err.Error())
+		if isNotFound(err) {
+			return nil, bcode.ErrClusterNotFound
+		}
+		return nil, fmt.Errorf("query cluster info from alibaba api failure %w", err)
 	}
 	if !res.IsSuccess() {
 		return nil, fmt.Errorf("query cluster info from alibaba api failure:%s", res.String())
@@ -321,7 +371,7 @@ func (a *ackAdaptor) doRequest(request *requests.CommonRequest) (*responses.Comm
 }
 
 func (a *ackAdaptor) VPCList(regionID string) ([]*v1alpha1.VPC, error) {
-	vpcclient, err := vpc.NewClientWithAccessKey(regionID, a.accessKeyID, a.accessKeySecret)
+	vpcclient, err := vpc.NewClientWithOptions(regionID, sdkConfig(), a.credential())
 	if err != nil {
 		return nil, err
 	}
@@ -344,7 +394,7 @@ func (a *ackAdaptor) VPCList(regionID string) ([]*v1alpha1.VPC, error) {
 }
 
 func (a *ackAdaptor) DescribeVPC(regionID, vpcID string) (*v1alpha1.VPC, error) {
-	vpcclient, err := vpc.NewClientWithAccessKey(regionID, a.accessKeyID, a.accessKeySecret)
+	vpcclient, err := vpc.NewClientWithOptions(regionID, sdkConfig(), a.credential())
 	if err != nil {
 		return nil, err
 	}
@@ -415,7 +465,7 @@ func (a *ackAdaptor) CreateVPC(v *v1alpha1.VPC) error {
 	if v.RegionID == "" {
 		return fmt.Errorf("not privide region id")
 	}
-	vpcclient, err := vpc.NewClientWithAccessKey(v.RegionID, a.accessKeyID, a.accessKeySecret)
+	vpcclient, err := vpc.NewClientWithOptions(v.RegionID, sdkConfig(), a.credential())
 	if err != nil {
 		return err
 	}
@@ -467,7 +517,7 @@ func (a *ackAdaptor) CreateVSwitch(v *v1alpha1.VSwitch) error {
 	if v.RegionID == "" {
 		return fmt.Errorf("not privide region id")
 	}
-	vpcclient, err := vpc.NewClientWithAccessKey(v.RegionID, a.accessKeyID, a.accessKeySecret)
+	vpcclient, err := vpc.NewClientWithOptions(v.RegionID, sdkConfig(), a.credential())
 	if err != nil {
 		return err
 	}
@@ -480,7 +530,7 @@ func (a *ackAdaptor) CreateVSwitch(v *v1alpha1.VSwitch) error {
 	request.Description = v.Description
 	res, err := vpcclient.CreateVSwitch(request)
 	if err != nil {
-		return fmt.Errorf("create vswitch from alibaba api failure:%s", err.Error())
+		return fmt.Errorf("create vswitch from alibaba api failure:%w", err)
 	}
 	if !res.IsSuccess() {
 		return fmt.Errorf("create vswitch from alibaba api failure:%s", res.String())
@@ -514,7 +564,7 @@ func (a *ackAdaptor) CreateVSwitch(v *v1alpha1.VSwitch) error {
 }
 
 func (a *ackAdaptor) DescribeVSwitch(regionID, vswitchID string) (*v1alpha1.VSwitch, error) {
-	vpcclient, err := vpc.NewClientWithAccessKey(regionID, a.accessKeyID, a.accessKeySecret)
+	vpcclient, err := vpc.NewClientWithOptions(regionID, sdkConfig(), a.credential())
 	if err != nil {
 		return nil, err
 	}
@@ -537,7 +587,7 @@ func (a *ackAdaptor) DescribeVSwitch(regionID, vswitchID string) (*v1alpha1.VSwi
 }
 
 func (a *ackAdaptor) ListZones(regionID string) ([]*v1alpha1.Zone, error) {
-	vpcclient, err := vpc.NewClientWithAccessKey(regionID, a.accessKeyID, a.accessKeySecret)
+	vpcclient, err := vpc.NewClientWithOptions(regionID, sdkConfig(), a.credential())
 	if err != nil {
 		return nil, err
 	}
@@ -561,25 +611,20 @@ func (a *ackAdaptor) ListZones(regionID string) ([]*v1alpha1.Zone, error) {
 	return list, nil
 }
 
-func (a *ackAdaptor) DeleteVPC(regionID, vpcID string) error {
-	vpcclient, err := vpc.NewClientWithAccessKey(regionID, a.accessKeyID, a.accessKeySecret)
+func (a *ackAdaptor) DeleteVPC(ctx context.Context, regionID, vpcID string) error {
+	vpcclient, err := vpc.NewClientWithOptions(regionID, sdkConfig(), a.credential())
 	if err != nil {
 		return err
 	}
 	request := vpc.CreateDeleteVpcRequest()
 	request.Scheme = "https"
 	request.VpcId = vpcID
-	response, err := vpcclient.DeleteVpc(request)
+	// the vpc is forbidden to be deleted until its vswitches are deleted
+	response, err := retryutil.Call(ctx, cloudPolicyWithCodes("Forbbiden"), nil, func() (*vpc.DeleteVpcResponse, error) {
+		return vpcclient.DeleteVpc(request)
+	})
 	if err != nil {
-		if real, ok := err.(*errors.ServerError); ok {
-			if real.ErrorCode() == "Forbbiden" {
-				time.Sleep(time.Second * 1)
-				response, err = vpcclient.DeleteVpc(request)
-			}
-		}
-		if err != nil {
-			return fmt.Errorf("delete vpc from alibaba api failure:%s", err.Error())
-		}
+		return fmt.Errorf("delete vpc from alibaba api failure:%s", err.Error())
 	}
 	if !response.IsSuccess() {
 		return fmt.Errorf("delete vpc from alibaba api failure:%s", response.String())
@@ -587,25 +632,27 @@ func (a *ackAdaptor) DeleteVPC(regionID, vpcID string) error {
 	return nil
 }
 
-func (a *ackAdaptor) DeleteVSwitch(regionID, vswitchID string) error {
-	vpcclient, err := vpc.NewClientWithAccessKey(regionID, a.accessKeyID, a.accessKeySecret)
+// cloudPolicyWithCodes returns the policy retrying the errors of codes as well, such as the errors of the resource
+// being changed by the last operation.
+func cloudPolicyWithCodes(codes ...string) retryutil.Policy {
+	policy := retryutil.CloudAPIPolicy
+	policy.Retryable = retryutil.WithCodes(codes...)
+	return policy
+}
+
+func (a *ackAdaptor) DeleteVSwitch(ctx context.Context, regionID, vswitchID string) error {
+	vpcclient, err := vpc.NewClientWithOptions(regionID, sdkConfig(), a.credential())
 	if err != nil {
 		return err
 	}
 	request := vpc.CreateDeleteVSwitchRequest()
 	request.Scheme = "https"
 	request.VSwitchId = vswitchID
-	response, err := vpcclient.DeleteVSwitch(request)
+	response, err := retryutil.Call(ctx, cloudPolicyWithCodes("IncorrectVSwitchStatus"), nil, func() (*vpc.DeleteVSwitchResponse, error) {
+		return vpcclient.DeleteVSwitch(request)
+	})
 	if err != nil {
-		if real, ok := err.(*errors.ServerError); ok {
-			if real.ErrorCode() == "IncorrectVSwitchStatus" {
-				time.Sleep(time.Second * 1)
-				response, err = vpcclient.DeleteVSwitch(request)
-			}
-		}
-		if err != nil {
-			return fmt.Errorf("delete vswitch from alibaba api failure:%s", err.Error())
-		}
+		return fmt.Errorf("delete vswitch from alibaba api failure:%s", err.Error())
 	}
 	if !response.IsSuccess() {
 		return fmt.Errorf("delete vswitch from alibaba api failure:%s", response.String())
@@ -614,7 +661,7 @@ func (a *ackAdaptor) DeleteVSwitch(regionID, vswitchID string) error {
 }
 
 func (a *ackAdaptor) ListInstanceType(regionID string) ([]*v1alpha1.InstanceType, error) {
-	ecsclient, err := ecs.NewClientWithAccessKey(regionID, a.accessKeyID, a.accessKeySecret)
+	ecsclient, err := ecs.NewClientWithOptions(regionID, sdkConfig(), a.credential())
 	if err != nil {
 		return nil, err
 	}
@@ -641,7 +688,7 @@ func (a *ackAdaptor) ListInstanceType(regionID string) ([]*v1alpha1.InstanceType
 
 // GetECSIDByIPs get ecs id by vpcid and ips
 func (a *ackAdaptor) GetECSIDByIPs(regionID, vpcID string, ips []string) (map[string]string, error) {
-	client, err := ecs.NewClientWithAccessKey(regionID, a.accessKeyID, a.accessKeySecret)
+	client, err := ecs.NewClientWithOptions(regionID, sdkConfig(), a.credential())
 	if err != nil {
 		return nil, err
 	}
@@ -669,7 +716,7 @@ func (a *ackAdaptor) GetECSIDByIPs(regionID, vpcID string, ips []string) (map[st
 
 // SetSecurityGroup set security rule
 func (a *ackAdaptor) SetSecurityGroup(clusterID, regionID, securityGroupID string) error {
-	client, err := ecs.NewClientWithAccessKey(regionID, a.accessKeyID, a.accessKeySecret)
+	client, err := ecs.NewClientWithOptions(regionID, sdkConfig(), a.credential())
 	if err != nil {
 		return err
 	}
@@ -715,6 +762,7 @@ func (a *ackAdaptor) SetSecurityGroup(clusterID, regionID, securityGroupID strin
 					return fmt.Errorf("create security rule %s failure:%s", portRange, response.String())
 				}
 			}
+			a.record(&v1alpha1.CloudResource{ClusterID: clusterID, Type: v1alpha1.ResourceTypeSecurityGroupRule, CloudID: securityGroupID, RegionID: regionID, Spec: portRange})
 		}
 	}
 	return nil
@@ -722,7 +770,7 @@ func (a *ackAdaptor) SetSecurityGroup(clusterID, regionID, securityGroupID strin
 
 // DescribeAvailableResourceZones get support InstanceType zones
 func (a *ackAdaptor) DescribeAvailableResourceZones(regionID, InstanceType string) ([]*v1alpha1.AvailableResourceZone, error) {
-	client, err := ecs.NewClientWithAccessKey(regionID, a.accessKeyID, a.accessKeySecret)
+	client, err := ecs.NewClientWithOptions(regionID, sdkConfig(), a.credential())
 	if err != nil {
 		return nil, err
 	}
@@ -750,9 +798,9 @@ func (a *ackAdaptor) DescribeAvailableResourceZones(regionID, InstanceType strin
 }
 
 // GetWutongInitConfig get wutong init config
-func (a *ackAdaptor) GetWutongInitConfig(cluster *v1alpha1.Cluster, gateway, chaos []*wutongv1alpha1.K8sNode, rollback func(step, message, status string)) *v1alpha1.WutongInitConfig {
+func (a *ackAdaptor) GetWutongInitConfig(ctx context.Context, cluster *v1alpha1.Cluster, gateway, chaos []*wutongv1alpha1.K8sNode, rollback func(step domain.TaskStep, message, status string)) *v1alpha1.WutongInitConfig {
 
-	rollback("CreateRDS", "", "start")
+	rollback(domain.TaskStepCreateRDS, "", "start")
 	//指定pod cidr作为白名单
 	regionDB := &v1alpha1.Database{
 		Name:      "region",
@@ -765,63 +813,67 @@ func (a *ackAdaptor) GetWutongInitConfig(cluster *v1alpha1.Cluster, gateway, cha
 		Password:  cluster.ClusterID[0:16],
 		ClusterID: cluster.ClusterID,
 	}
-	if err := a.CreateDB(regionDB); err != nil {
-		rollback("CreateRDS", err.Error(), "failure")
+	if err := a.CreateDB(ctx, regionDB); err != nil {
+		rollback(domain.TaskStepCreateRDS, err.Error(), "failure")
 		return nil
 	}
-	rollback("CreateRDS", regionDB.InstanceID, "success")
+	rollback(domain.TaskStepCreateRDS, regionDB.InstanceID, "success")
 	// create nas
 	vs, err := a.DescribeVSwitch(cluster.RegionID, cluster.VSwitchID)
 	if err != nil {
 		vs, err = a.DescribeVSwitch(cluster.RegionID, cluster.VSwitchID)
 		if err != nil {
-			rollback("CreateNAS", fmt.Sprintf("found vswitch %s with cluster failure %s", cluster.VSwitchID, err.Error()), "failure")
+			rollback(domain.TaskStepCreateNAS, fmt.Sprintf("found vswitch %s with cluster failure %s", cluster.VSwitchID, err.Error()), "failure")
 			return nil
 		}
 	}
-	rollback("CreateNAS", "", "start")
+	rollback(domain.TaskStepCreateNAS, "", "start")
 	nasID, err := a.CreateNAS(cluster.ClusterID, cluster.RegionID, vs.ZoneID)
 	if err != nil {
-		rollback("CreateNAS", err.Error(), "failure")
+		rollback(domain.TaskStepCreateNAS, err.Error(), "failure")
 		return nil
 	}
-	rollback("CreateNAS", nasID, "success")
-	rollback("CreateNASMount", "", "start")
+	rollback(domain.TaskStepCreateNAS, nasID, "success")
+	rollback(domain.TaskStepCreateNASMount, "", "start")
 	nasMountDomain, err := a.CreateNASMountTarget(cluster.ClusterID, cluster.RegionID, nasID, cluster.VPCID, cluster.VSwitchID)
 	if err != nil {
-		rollback("CreateNASMount", err.Error(), "failure")
+		rollback(domain.TaskStepCreateNASMount, err.Error(), "failure")
 		return nil
 	}
-	rollback("CreateNASMount", nasMountDomain, "success")
+	rollback(domain.TaskStepCreateNASMount, nasMountDomain, "success")
 
 	// create eip and bound
-	rollback("CreateLoadBalancer", "", "start")
+	rollback(domain.TaskStepCreateLoadBalancer, "", "start")
 	slb, err := a.CreateLoadBalancer(cluster.ClusterID, cluster.RegionID)
 	if err != nil {
-		rollback("CreateLoadBalancer", err.Error(), "failure")
+		rollback(domain.TaskStepCreateLoadBalancer, err.Error(), "failure")
 		return nil
 	}
-	rollback("CreateLoadBalancer", slb.LoadBalancerID+","+slb.Address, "success")
+	rollback(domain.TaskStepCreateLoadBalancer, slb.LoadBalancerID+","+slb.Address, "success")
 
 	// slb port 443 8443 80 6060 lb to cluster gateway node
 	var gatewayIPs []string
 	for _, g := range gateway {
 		gatewayIPs = append(gatewayIPs, g.InternalIP)
 	}
-	rollback("BoundLoadBalancer", "", "start")
+	// the load balancer is bound always, it may be not the one bound by the previous task
+	rollback(domain.TaskStepBoundLoadBalancer, "", "start")
 	logrus.Infof("gateway ips is %s", gatewayIPs)
 	if err := a.BoundLoadBalancerToCluster(cluster.ClusterID, cluster.RegionID, cluster.VPCID, slb.LoadBalancerID, gatewayIPs); err != nil {
-		rollback("BoundLoadBalancer", err.Error(), "failure")
+		rollback(domain.TaskStepBoundLoadBalancer, err.Error(), "failure")
 		return nil
 	}
-	rollback("BoundLoadBalancer", "80,443,8443,6060", "success")
+	rollback(domain.TaskStepBoundLoadBalancer, "80,443,8443,6060", "success")
 
 	// set security group
-	rollback("SetSecurityGroup", "", "start")
-	if err := a.SetSecurityGroup(cluster.ClusterID, cluster.RegionID, cluster.SecurityGroupID); err != nil {
-		rollback("SetSecurityGroup", err.Error(), "failure")
+	if !a.skipCompletedStep(domain.TaskStepSetSecurityGroup, rollback) {
+		rollback(domain.TaskStepSetSecurityGroup, "", "start")
+		if err := a.SetSecurityGroup(cluster.ClusterID, cluster.RegionID, cluster.SecurityGroupID); err != nil {
+			rollback(domain.TaskStepSetSecurityGroup, err.Error(), "failure")
+			return nil
+		}
+		rollback(domain.TaskStepSetSecurityGroup, "80/80,443/443,8443/8443,6060/6060,10000/11000", "success")
 	}
-	rollback("SetSecurityGroup", "80/80,443/443,8443/8443,6060/6060,10000/11000", "success")
 	return &v1alpha1.WutongInitConfig{
 		ClusterID:      cluster.ClusterID,
 		RegionDatabase: regionDB,
@@ -832,11 +884,318 @@ func (a *ackAdaptor) GetWutongInitConfig(cluster *v1alpha1.Cluster, gateway, cha
 	}
 }
 
-// DeleteCluster delete cluster
+// SetCompletedSteps set the init steps completed by the previous task.
+// The steps that create resources are always executed, they reuse the existing resources and
+// return the information needed by the later steps. So is binding the load balancer, which reuses
+// the existing listeners, the load balancer created may be not the one bound before.
+func (a *ackAdaptor) SetCompletedSteps(steps []string) {
+	a.completedSteps = make(map[string]bool)
+	for _, step := range steps {
+		a.completedSteps[step] = true
+	}
+}
+
+func (a *ackAdaptor) skipCompletedStep(step domain.TaskStep, rollback func(step domain.TaskStep, message, status string)) bool {
+	if !a.completedSteps[string(step)] {
+		return false
+	}
+	logrus.Infof("step %s is completed by the previous task, skip it", step)
+	rollback(step, "completed by the previous task", "success")
+	return true
+}
+
+// DeleteCluster delete cluster and the cloud resources created for it, it blocks until the network of the
+// cluster is deleted. The clusters are deleted by the destroy task, see DestroyCluster.
 func (a *ackAdaptor) DeleteCluster(clusterID string) error {
+	var err error
+	a.DestroyCluster(context.Background(), &v1alpha1.DestroyCluster{Provider: "ack", ClusterID: clusterID},
+		func(step domain.TaskStep, message, status string) {
+			if status == "failure" {
+				err = fmt.Errorf("%s failure %s", step, message)
+			}
+		})
+	return err
+}
+
+// DestroyCluster delete the cluster, then the load balancer, nas and rds created by GetWutongInitConfig,
+// and the vpc and vswitches created by CreateWutongKubernetes at last. The cluster that does not exist is
+// regarded as deleted by the previous task, its resources are deleted in config.RegionID, so the task can be
+// retried after it failed to delete the resources.
+func (a *ackAdaptor) DestroyCluster(ctx context.Context, config *v1alpha1.DestroyCluster, rollback func(step domain.TaskStep, message, status string)) {
+	rollback(domain.TaskStepInitClusterConfig, "", "start")
+	cluster, err := a.DescribeCluster(config.ClusterID)
+	deleted := errors.Is(err, bcode.ErrClusterNotFound) && config.RegionID != ""
+	if deleted {
+		cluster = &v1alpha1.Cluster{ClusterID: config.ClusterID, RegionID: config.RegionID}
+	} else if err != nil {
+		rollback(domain.TaskStepInitClusterConfig, err.Error(), "failure")
+		return
+	}
+	rollback(domain.TaskStepInitClusterConfig, "", "success")
+
+	rollback(domain.TaskStepDeleteCluster, "", "start")
+	if deleted {
+		rollback(domain.TaskStepDeleteCluster, "the cluster has been deleted", "success")
+	} else {
+		// clusters created by wutong enable deletion protection by default
+		if err := a.modifyCluster(cluster.ClusterID, map[string]interface{}{"deletion_protection": false}); err != nil {
+			rollback(domain.TaskStepDeleteCluster, err.Error(), "failure")
+			return
+		}
+		request := a.newRequest("DELETE")
+		request.PathPattern = "/clusters/" + cluster.ClusterID
+		res, err := a.doRequest(request)
+		if err != nil {
+			rollback(domain.TaskStepDeleteCluster, fmt.Sprintf("delete cluster from alibaba api failure %s", err.Error()), "failure")
+			return
+		}
+		if !res.IsSuccess() {
+			rollback(domain.TaskStepDeleteCluster, fmt.Sprintf("delete cluster from alibaba api failure:%s", res.String()), "failure")
+			return
+		}
+		rollback(domain.TaskStepDeleteCluster, "", "success")
+	}
+
+	// resources created by GetWutongInitConfig
+	deletions := []struct {
+		step   domain.TaskStep
+		delete func(clusterID, regionID string) error
+	}{
+		{domain.TaskStepDeleteLoadBalancer, a.DeleteLoadBalancer},
+		{domain.TaskStepDeleteNAS, a.DeleteNAS},
+		{domain.TaskStepDeleteRDS, a.DeleteDBInstance},
+	}
+	for _, deletion := range deletions {
+		if ctx.Err() != nil {
+			return
+		}
+		rollback(deletion.step, "", "start")
+		err := deletion.step.RetryPolicy().Do(ctx, retryutil.EventNotify(deletion.step, rollback), func() error {
+			return deletion.delete(cluster.ClusterID, cluster.RegionID)
+		})
+		if err != nil {
+			rollback(deletion.step, err.Error(), "failure")
+			return
+		}
+		rollback(deletion.step, "", "success")
+	}
+
+	rollback(domain.TaskStepDeleteNetwork, "", "start")
+	if deleted {
+		// the network of the deleted cluster is the one created by CreateWutongKubernetes in the region
+		if cluster.VPCID, cluster.VSwitchID, err = a.describeDefaultNetwork(cluster.RegionID); err != nil {
+			rollback(domain.TaskStepDeleteNetwork, err.Error(), "failure")
+			return
+		}
+	}
+	if cluster.VPCID != "" {
+		if err := a.deleteClusterNetwork(ctx, cluster, rollback); err != nil {
+			rollback(domain.TaskStepDeleteNetwork, err.Error(), "failure")
+			return
+		}
+	}
+	rollback(domain.TaskStepDeleteNetwork, "", "success")
+	rollback(domain.TaskStepDestroyCluster, cluster.ClusterID, "success")
+}
+
+func (a *ackAdaptor) modifyCluster(clusterID string, params map[string]interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	request := a.newRequest("PUT")
+	request.PathPattern = "/api/v2/clusters/" + clusterID
+	request.Content = body
+	res, err := a.doRequest(request)
+	if err != nil {
+		return fmt.Errorf("modify cluster from alibaba api failure %s", err.Error())
+	}
+	if !res.IsSuccess() {
+		return fmt.Errorf("modify cluster from alibaba api failure:%s", res.String())
+	}
 	return nil
 }
 
-func (a *ackAdaptor) ExpansionNode(ctx context.Context, en *v1alpha1.ExpansionNode, rollback func(step, message, status string)) *v1alpha1.Cluster {
+// describeDefaultNetwork returns the vpc and the vswitches separated by comma created by CreateWutongKubernetes
+// in the region, the vpc is empty if it is not found.
+func (a *ackAdaptor) describeDefaultNetwork(regionID string) (string, string, error) {
+	vpcs, err := a.VPCList(regionID)
+	if err != nil {
+		return "", "", fmt.Errorf("query vpc list failure %s", err.Error())
+	}
+	var vpcID string
+	for _, v := range vpcs {
+		if v.VpcName == "wutong-default-vpc" {
+			vpcID = v.VpcID
+			break
+		}
+	}
+	if vpcID == "" {
+		return "", "", nil
+	}
+	vpcclient, err := vpc.NewClientWithOptions(regionID, sdkConfig(), a.credential())
+	if err != nil {
+		return "", "", err
+	}
+	request := vpc.CreateDescribeVSwitchesRequest()
+	request.Scheme = "https"
+	request.VpcId = vpcID
+	request.PageSize = requests.NewInteger(50)
+	response, err := vpcclient.DescribeVSwitches(request)
+	if err != nil {
+		return "", "", fmt.Errorf("query vswitches of vpc %s failure %s", vpcID, err.Error())
+	}
+	var vswitchIDs []string
+	for _, vs := range response.VSwitches.VSwitch {
+		vswitchIDs = append(vswitchIDs, vs.VSwitchId)
+	}
+	return vpcID, strings.Join(vswitchIDs, ","), nil
+}
+
+// deleteClusterNetwork delete the vpc and vswitches created by CreateWutongKubernetes.
+// A vpc shared with other clusters or created by the user is kept. The vswitches can be deleted only after
+// the nodes of the deleted cluster are released, the deletion is retried until then.
+func (a *ackAdaptor) deleteClusterNetwork(ctx context.Context, cluster *v1alpha1.Cluster, rollback func(step domain.TaskStep, message, status string)) error {
+	vpc, err := a.DescribeVPC(cluster.RegionID, cluster.VPCID)
+	if err != nil {
+		return fmt.Errorf("query vpc %s failure %s", cluster.VPCID, err.Error())
+	}
+	if vpc.VpcName != "wutong-default-vpc" {
+		logrus.Infof("vpc %s is not created by wutong, skip delete it", cluster.VPCID)
+		return nil
+	}
+	clusters, err := a.ClusterList()
+	if err != nil {
+		return fmt.Errorf("query cluster list failure %s", err.Error())
+	}
+	for _, c := range clusters {
+		if c.ClusterID != cluster.ClusterID && c.VPCID == cluster.VPCID {
+			logrus.Infof("vpc %s is used by cluster %s, skip delete it", cluster.VPCID, c.ClusterID)
+			return nil
+		}
+	}
+	var vswitchIDs []string
+	for _, id := range strings.Split(cluster.VSwitchID, ",") {
+		if id == "" {
+			continue
+		}
+		vswitch, err := a.DescribeVSwitch(cluster.RegionID, id)
+		if err != nil {
+			return fmt.Errorf("query vswitch %s failure %s", id, err.Error())
+		}
+		if vswitch.VSwitchName == "wutong-default-vswitch" {
+			vswitchIDs = append(vswitchIDs, id)
+		}
+	}
+	notify := retryutil.EventNotify(domain.TaskStepDeleteNetwork, rollback)
+	err = domain.TaskStepDeleteNetwork.RetryPolicy().Do(ctx, notify, func() error {
+		for len(vswitchIDs) > 0 {
+			if err := a.DeleteVSwitch(ctx, cluster.RegionID, vswitchIDs[0]); err != nil {
+				return fmt.Errorf("delete vswitch %s failure %s", vswitchIDs[0], err.Error())
+			}
+			vswitchIDs = vswitchIDs[1:]
+		}
+		if err := a.DeleteVPC(ctx, cluster.RegionID, cluster.VPCID); err != nil {
+			return fmt.Errorf("delete vpc %s failure %s", cluster.VPCID, err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s, vpc %s need to be deleted manually", err.Error(), cluster.VPCID)
+	}
+	logrus.Infof("network of cluster %s deleted", cluster.ClusterID)
+	return nil
+}
+
+// ExpansionNode add worker nodes to the ack cluster
+func (a *ackAdaptor) ExpansionNode(ctx context.Context, en *v1alpha1.ExpansionNode, rollback func(step domain.TaskStep, message, status string)) *v1alpha1.Cluster {
+	rollback(domain.TaskStepInitClusterConfig, "", "start")
+	if en.WorkerNodeNum <= 0 {
+		rollback(domain.TaskStepInitClusterConfig, "the number of worker nodes to be added must be greater than 0", "failure")
+		return nil
+	}
+	cluster, err := a.DescribeCluster(en.ClusterID)
+	if err != nil {
+		rollback(domain.TaskStepInitClusterConfig, err.Error(), "failure")
+		return nil
+	}
+	if cluster.State != v1alpha1.RunningState {
+		rollback(domain.TaskStepInitClusterConfig, fmt.Sprintf("cluster state is %s, can not add nodes", cluster.State), "failure")
+		return nil
+	}
+	var instanceTypes []string
+	if en.InstanceType != "" {
+		instanceTypes = append(instanceTypes, en.InstanceType)
+	}
+	for _, it := range getInstanceType(en.WorkerResourceType) {
+		if it != "" && it != en.InstanceType {
+			instanceTypes = append(instanceTypes, it)
+		}
+	}
+	var vswitchIDs []string
+	for _, id := range strings.Split(cluster.VSwitchID, ",") {
+		if id != "" {
+			vswitchIDs = append(vswitchIDs, id)
+		}
+	}
+	if len(vswitchIDs) == 0 {
+		rollback(domain.TaskStepInitClusterConfig, "can not find the vswitch of cluster", "failure")
+		return nil
+	}
+	config := v1alpha1.GetDefaultACKScaleOutConfig(en.WorkerNodeNum, instanceTypes, vswitchIDs)
+	rollback(domain.TaskStepInitClusterConfig, "", "success")
+
+	rollback(domain.TaskStepUpdateKubernetes, "", "start")
+	if err := a.ScaleOutCluster(en.ClusterID, config); err != nil {
+		rollback(domain.TaskStepUpdateKubernetes, err.Error(), "failure")
+		return nil
+	}
+	expectSize := cluster.Size + en.WorkerNodeNum
+	timer := time.NewTimer(time.Minute * 30)
+	defer timer.Stop()
+	ticker := time.NewTicker(time.Second * 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			rollback(domain.TaskStepUpdateKubernetes, "task canceled", "failure")
+			return nil
+		case <-timer.C:
+			rollback(domain.TaskStepUpdateKubernetes, "waiting for the nodes to be added timeout", "failure")
+			return nil
+		case <-ticker.C:
+		}
+		cluster, err = a.DescribeCluster(en.ClusterID)
+		if err != nil {
+			logrus.Warningf("query cluster %s failure %s", en.ClusterID, err.Error())
+			continue
+		}
+		if cluster.State == "failed" {
+			rollback(domain.TaskStepUpdateKubernetes, "scale out cluster failure, please check the cluster log in alibaba console", "failure")
+			return nil
+		}
+		if cluster.State == v1alpha1.RunningState && cluster.Size >= expectSize {
+			rollback(domain.TaskStepUpdateKubernetes, fmt.Sprintf("%d worker nodes added", en.WorkerNodeNum), "success")
+			return cluster
+		}
+	}
+}
+
+// ScaleOutCluster add worker nodes to the cluster
+func (a *ackAdaptor) ScaleOutCluster(clusterID string, config *v1alpha1.AckScaleOutConfig) error {
+	body, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	request := a.newRequest("POST")
+	request.PathPattern = "/api/v2/clusters/" + clusterID
+	request.Content = body
+	res, err := a.doRequest(request)
+	if err != nil {
+		return fmt.Errorf("scale out cluster from alibaba api failure %s", err.Error())
+	}
+	if !res.IsSuccess() {
+		return fmt.Errorf("scale out cluster from alibaba api failure:%s", res.String())
+	}
 	return nil
 }
@@ -132,7 +132,7 @@ func TestCreateDB(t *testing.T) {
 		UserName: "console",
 		Password: util.RandString(10),
 	}
-	if err := adaptor.CreateDB(db); err != nil {
+	if err := adaptor.CreateDB(context.Background(), db); err != nil {
 		t.Fatal(err)
 	}
 	t.Logf("dh addr %s:%d", db.Host, db.Port)
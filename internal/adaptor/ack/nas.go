@@ -23,6 +23,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
 	"github.com/aliyun/alibaba-cloud-sdk-go/services/nas"
 
 	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/errors"
@@ -31,7 +32,7 @@ import (
 )
 
 func (a *ackAdaptor) GetNasZone(regionID string) (string, error) {
-	client, err := nas.NewClientWithAccessKey(regionID, a.accessKeyID, a.accessKeySecret)
+	client, err := nas.NewClientWithOptions(regionID, sdkConfig(), a.credential())
 	if err != nil {
 		return "", err
 	}
@@ -56,21 +57,18 @@ func (a *ackAdaptor) GetNasZone(regionID string) (string, error) {
 // CreateNAS create nas, if zone is not found, will retry other zone
 func (a *ackAdaptor) CreateNAS(clusterID, regionID, zoneID string) (string, error) {
 	logrus.Infof("create nas in region %s zone %s", regionID, zoneID)
-	client, err := nas.NewClientWithAccessKey(regionID, a.accessKeyID, a.accessKeySecret)
+	client, err := nas.NewClientWithOptions(regionID, sdkConfig(), a.credential())
 	if err != nil {
 		return "", err
 	}
-	drequest := nas.CreateDescribeFileSystemsRequest()
-	drequest.RegionId = regionID
-	dresponse, err := client.DescribeFileSystems(drequest)
-	if err != nil && !strings.Contains(err.Error(), "The specified resource does not exist") {
+	fileSystemID, err := a.describeFileSystem(client, clusterID, regionID)
+	if err != nil {
 		return "", err
 	}
-	for _, system := range dresponse.FileSystems.FileSystem {
-		if system.Description == "wutong-region-nas_"+clusterID {
-			logrus.Infof("nas filesystem for cluster %s is exist", clusterID)
-			return system.FileSystemId, nil
-		}
+	if fileSystemID != "" {
+		logrus.Infof("nas filesystem %s for cluster %s is exist, reuse it", fileSystemID, clusterID)
+		a.record(&v1alpha1.CloudResource{ClusterID: clusterID, Type: v1alpha1.ResourceTypeNAS, CloudID: fileSystemID, RegionID: regionID})
+		return fileSystemID, nil
 	}
 	request := nas.CreateCreateFileSystemRequest()
 	request.ProtocolType = "NFS"
@@ -106,6 +104,7 @@ func (a *ackAdaptor) CreateNAS(clusterID, regionID, zoneID string) (string, erro
 	if !response.IsSuccess() {
 		return "", fmt.Errorf("create nas in region %s zone %s failure:%s", regionID, zoneID, response.String())
 	}
+	a.record(&v1alpha1.CloudResource{ClusterID: clusterID, Type: v1alpha1.ResourceTypeNAS, CloudID: response.FileSystemId, RegionID: regionID})
 	// nas status is empty, do not check nas status
 	return response.FileSystemId, nil
 	// ticker := time.NewTicker(time.Second * 3)
@@ -134,8 +133,34 @@ func (a *ackAdaptor) CreateNAS(clusterID, regionID, zoneID string) (string, erro
 	// }
 }
 
+// describeFileSystem find the nas file system of the cluster by description, returns empty if not found.
+func (a *ackAdaptor) describeFileSystem(client *nas.Client, clusterID, regionID string) (string, error) {
+	for page := 1; ; page++ {
+		request := nas.CreateDescribeFileSystemsRequest()
+		request.Scheme = "https"
+		request.RegionId = regionID
+		request.PageSize = requests.NewInteger(100)
+		request.PageNumber = requests.NewInteger(page)
+		response, err := client.DescribeFileSystems(request)
+		if err != nil {
+			if strings.Contains(err.Error(), "The specified resource does not exist") {
+				return "", nil
+			}
+			return "", err
+		}
+		for _, system := range response.FileSystems.FileSystem {
+			if system.Description == "wutong-region-nas_"+clusterID {
+				return system.FileSystemId, nil
+			}
+		}
+		if len(response.FileSystems.FileSystem) < 100 {
+			return "", nil
+		}
+	}
+}
+
 func (a *ackAdaptor) CreateNASMountTarget(clusterID, regionID, fileSystemID, VpcID, VSwitchID string) (string, error) {
-	client, err := nas.NewClientWithAccessKey(regionID, a.accessKeyID, a.accessKeySecret)
+	client, err := nas.NewClientWithOptions(regionID, sdkConfig(), a.credential())
 	if err != nil {
 		return "", err
 	}
@@ -147,26 +172,35 @@ func (a *ackAdaptor) CreateNASMountTarget(clusterID, regionID, fileSystemID, Vpc
 		logrus.Errorf("describe mount target failure %s", err.Error())
 		return "", err
 	}
+	var mountTargetDomain string
 	for _, target := range res.MountTargets.MountTarget {
 		logrus.Infof("nas %s mount %s status is %s", fileSystemID, target.MountTargetDomain, target.Status)
 		if target.Status == "Active" {
 			return target.MountTargetDomain, nil
 		}
+		// the mount target created by the previous task may still be pending
+		if target.Status == "Pending" && target.VpcId == VpcID {
+			mountTargetDomain = target.MountTargetDomain
+		}
 	}
-	request := nas.CreateCreateMountTargetRequest()
-	request.Scheme = "https"
-	request.AccessGroupName = "DEFAULT_VPC_GROUP_NAME"
-	request.FileSystemId = fileSystemID
-	request.NetworkType = "VPC"
-	request.VpcId = VpcID
-	request.VSwitchId = VSwitchID
-	response, err := client.CreateMountTarget(request)
-	if err != nil {
-		return "", fmt.Errorf("create nas mount target failure %s", err.Error())
-	}
-	if !response.IsSuccess() {
-		return "", fmt.Errorf("create nas mount target failure:%s", response.String())
+	if mountTargetDomain == "" {
+		request := nas.CreateCreateMountTargetRequest()
+		request.Scheme = "https"
+		request.AccessGroupName = "DEFAULT_VPC_GROUP_NAME"
+		request.FileSystemId = fileSystemID
+		request.NetworkType = "VPC"
+		request.VpcId = VpcID
+		request.VSwitchId = VSwitchID
+		response, err := client.CreateMountTarget(request)
+		if err != nil {
+			return "", fmt.Errorf("create nas mount target failure %s", err.Error())
+		}
+		if !response.IsSuccess() {
+			return "", fmt.Errorf("create nas mount target failure:%s", response.String())
+		}
+		mountTargetDomain = response.MountTargetDomain
 	}
+	a.record(&v1alpha1.CloudResource{ClusterID: clusterID, Type: v1alpha1.ResourceTypeNASMountTarget, CloudID: mountTargetDomain, RegionID: regionID, ParentID: fileSystemID})
 	ticker := time.NewTicker(time.Second * 3)
 	timer := time.NewTimer(time.Minute * 5)
 	defer timer.Stop()
@@ -180,7 +214,7 @@ func (a *ackAdaptor) CreateNASMountTarget(clusterID, regionID, fileSystemID, Vpc
 		req := nas.CreateDescribeMountTargetsRequest()
 		req.Scheme = "https"
 		req.FileSystemId = fileSystemID
-		req.MountTargetDomain = response.MountTargetDomain
+		req.MountTargetDomain = mountTargetDomain
 		res, err := client.DescribeMountTargets(req)
 		if err != nil {
 			logrus.Errorf("describe mount target failure %s", err.Error())
@@ -190,13 +224,13 @@ func (a *ackAdaptor) CreateNASMountTarget(clusterID, regionID, fileSystemID, Vpc
 			if target.Status == "Active" {
 				return target.MountTargetDomain, nil
 			}
-			logrus.Infof("nas %s mount %s status is %s", fileSystemID, response.MountTargetDomain, target.Status)
+			logrus.Infof("nas %s mount %s status is %s", fileSystemID, mountTargetDomain, target.Status)
 		}
 	}
 }
 
 func (a *ackAdaptor) GetNASInfo(regionID, fileSystemID string) (*v1alpha1.NasStorageInfo, error) {
-	client, _ := nas.NewClientWithAccessKey(regionID, a.accessKeyID, a.accessKeySecret)
+	client, _ := nas.NewClientWithOptions(regionID, sdkConfig(), a.credential())
 	request := nas.CreateDescribeFileSystemsRequest()
 	request.RegionId = regionID
 	request.FileSystemId = fileSystemID
@@ -226,3 +260,77 @@ func (a *ackAdaptor) GetNASInfo(regionID, fileSystemID string) (*v1alpha1.NasSto
 		Status:       nas.Status,
 	}, nil
 }
+
+// DeleteNAS delete the nas file system and its mount targets created for the cluster
+func (a *ackAdaptor) DeleteNAS(clusterID, regionID string) error {
+	client, err := nas.NewClientWithOptions(regionID, sdkConfig(), a.credential())
+	if err != nil {
+		return err
+	}
+	fileSystemID, err := a.describeFileSystem(client, clusterID, regionID)
+	if err != nil {
+		return err
+	}
+	if fileSystemID == "" {
+		logrus.Infof("nas filesystem for cluster %s is not exist", clusterID)
+		return nil
+	}
+	if err := a.deleteFileSystem(regionID, fileSystemID); err != nil {
+		return err
+	}
+	logrus.Infof("nas filesystem %s for cluster %s deleted", fileSystemID, clusterID)
+	return nil
+}
+
+// deleteFileSystem delete the nas file system and its mount targets
+func (a *ackAdaptor) deleteFileSystem(regionID, fileSystemID string) error {
+	client, err := nas.NewClientWithOptions(regionID, sdkConfig(), a.credential())
+	if err != nil {
+		return err
+	}
+	req := nas.CreateDescribeMountTargetsRequest()
+	req.Scheme = "https"
+	req.FileSystemId = fileSystemID
+	res, err := client.DescribeMountTargets(req)
+	if err != nil && !strings.Contains(err.Error(), "The specified resource does not exist") {
+		return err
+	}
+	if res != nil {
+		for _, target := range res.MountTargets.MountTarget {
+			if err := a.deleteMountTarget(client, fileSystemID, target.MountTargetDomain); err != nil {
+				return err
+			}
+		}
+	}
+	// the file system can not be deleted until the mount targets are deleted
+	ticker := time.NewTicker(time.Second * 3)
+	timer := time.NewTimer(time.Minute * 5)
+	defer timer.Stop()
+	defer ticker.Stop()
+	for {
+		request := nas.CreateDeleteFileSystemRequest()
+		request.Scheme = "https"
+		request.FileSystemId = fileSystemID
+		_, err := client.DeleteFileSystem(request)
+		if err == nil || isNotFound(err) {
+			return nil
+		}
+		logrus.Infof("delete nas filesystem %s failure %s, will retry", fileSystemID, err.Error())
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			return fmt.Errorf("delete nas filesystem %s failure %s", fileSystemID, err.Error())
+		}
+	}
+}
+
+func (a *ackAdaptor) deleteMountTarget(client *nas.Client, fileSystemID, mountTargetDomain string) error {
+	request := nas.CreateDeleteMountTargetRequest()
+	request.Scheme = "https"
+	request.FileSystemId = fileSystemID
+	request.MountTargetDomain = mountTargetDomain
+	if _, err := client.DeleteMountTarget(request); err != nil && !isNotFound(err) {
+		return fmt.Errorf("delete nas mount target %s failure %s", mountTargetDomain, err.Error())
+	}
+	return nil
+}
@@ -34,28 +34,38 @@ import (
 func (a *ackAdaptor) SupportDB() bool {
 	return true
 }
-func (a *ackAdaptor) DescribeDBInstance(clusterID, regionID, ZoneID string) (*rds.DBInstanceInDescribeDBInstances, error) {
-	ecsclient, err := rds.NewClientWithAccessKey(regionID, a.accessKeyID, a.accessKeySecret)
+
+// DescribeDBInstance find the region db instance of the cluster by description, returns nil if not found.
+func (a *ackAdaptor) DescribeDBInstance(clusterID, regionID string) (*rds.DBInstanceInDescribeDBInstances, error) {
+	ecsclient, err := rds.NewClientWithOptions(regionID, sdkConfig(), a.credential())
 	if err != nil {
 		return nil, err
 	}
-	request := rds.CreateDescribeDBInstancesRequest()
-	request.RegionId = regionID
-	request.ZoneId = ZoneID
-	res, _ := ecsclient.DescribeDBInstances(request)
-	if res != nil && len(res.Items.DBInstance) > 0 {
+	description := "wutong-region-db_" + clusterID
+	for page := 1; ; page++ {
+		request := rds.CreateDescribeDBInstancesRequest()
+		request.Scheme = "https"
+		request.RegionId = regionID
+		request.SearchKey = description
+		request.PageSize = requests.NewInteger(100)
+		request.PageNumber = requests.NewInteger(page)
+		res, err := ecsclient.DescribeDBInstances(request)
+		if err != nil {
+			return nil, fmt.Errorf("describe db instances failure %s", err.Error())
+		}
 		for _, instance := range res.Items.DBInstance {
-			logrus.Infof("db instance %s", instance.DBInstanceDescription)
-			if instance.DBInstanceDescription == "wutong-region-db_"+clusterID {
+			if instance.DBInstanceDescription == description && instance.DBInstanceStatus != "Deleting" {
 				return &instance, nil
 			}
 		}
+		if len(res.Items.DBInstance) < 100 {
+			return nil, nil
+		}
 	}
-	return nil, nil
 }
 
 func (a *ackAdaptor) DescribeDBInstanceNetInfo(regionID, instanceID string) (response *rds.DescribeDBInstanceNetInfoResponse, err error) {
-	ecsclient, err := rds.NewClientWithAccessKey(regionID, a.accessKeyID, a.accessKeySecret)
+	ecsclient, err := rds.NewClientWithOptions(regionID, sdkConfig(), a.credential())
 	if err != nil {
 		return nil, err
 	}
@@ -65,8 +75,8 @@ func (a *ackAdaptor) DescribeDBInstanceNetInfo(regionID, instanceID string) (res
 	return ecsclient.DescribeDBInstanceNetInfo(request)
 }
 
-func (a *ackAdaptor) CreateDBInstance(clusterID, regionID, ZoneID, VPCId, VSwitchID, podCIDR string) (*rds.CreateDBInstanceResponse, error) {
-	ecsclient, err := rds.NewClientWithAccessKey(regionID, a.accessKeyID, a.accessKeySecret)
+func (a *ackAdaptor) CreateDBInstance(ctx context.Context, clusterID, regionID, ZoneID, VPCId, VSwitchID, podCIDR string) (*rds.CreateDBInstanceResponse, error) {
+	ecsclient, err := rds.NewClientWithOptions(regionID, sdkConfig(), a.credential())
 	if err != nil {
 		return nil, err
 	}
@@ -96,13 +106,13 @@ func (a *ackAdaptor) CreateDBInstance(clusterID, regionID, ZoneID, VPCId, VSwitc
 	if err != nil {
 		return nil, err
 	}
-	if err := a.WaitingDBInstanceReady(context.Background(), regionID, res.DBInstanceId); err != nil {
+	if err := a.WaitingDBInstanceReady(ctx, regionID, res.DBInstanceId); err != nil {
 		return nil, err
 	}
 	return res, nil
 }
 func (a *ackAdaptor) WaitingDBInstanceReady(ctx context.Context, regionID, dbInstanceID string) error {
-	ecsclient, err := rds.NewClientWithAccessKey(regionID, a.accessKeyID, a.accessKeySecret)
+	ecsclient, err := rds.NewClientWithOptions(regionID, sdkConfig(), a.credential())
 	if err != nil {
 		return err
 	}
@@ -134,7 +144,7 @@ func (a *ackAdaptor) WaitingDBInstanceReady(ctx context.Context, regionID, dbIns
 	}
 }
 func (a *ackAdaptor) createDBAcount(regionID, instanceID, name, password string) error {
-	ecsclient, err := rds.NewClientWithAccessKey(regionID, a.accessKeyID, a.accessKeySecret)
+	ecsclient, err := rds.NewClientWithOptions(regionID, sdkConfig(), a.credential())
 	if err != nil {
 		return err
 	}
@@ -146,23 +156,29 @@ func (a *ackAdaptor) createDBAcount(regionID, instanceID, name, password string)
 	if err != nil && !strings.Contains(err.Error(), "The specified resource does not exist") {
 		return err
 	}
+	var exist bool
 	for _, account := range res.Accounts.DBInstanceAccount {
-		if account.AccountStatus == "Available" && account.AccountName == name {
+		if account.AccountName == name {
 			logrus.Infof("db account %s status is %s", name, account.AccountStatus)
-			return nil
+			if account.AccountStatus == "Available" {
+				return nil
+			}
+			exist = true
 		}
 	}
-	acount := rds.CreateCreateAccountRequest()
-	acount.DBInstanceId = instanceID
-	acount.AccountName = name
-	acount.AccountPassword = password
-	acount.AccountDescription = "create by wutong cloud"
-	response, err := ecsclient.CreateAccount(acount)
-	if err != nil {
-		return fmt.Errorf("create rds(mysql) user from alibaba api failure:%s", response.String())
-	}
-	if !response.IsSuccess() {
-		return fmt.Errorf("create rds(mysql) user from alibaba api failure:%s", response.String())
+	if !exist {
+		acount := rds.CreateCreateAccountRequest()
+		acount.DBInstanceId = instanceID
+		acount.AccountName = name
+		acount.AccountPassword = password
+		acount.AccountDescription = "create by wutong cloud"
+		response, err := ecsclient.CreateAccount(acount)
+		if err != nil {
+			return fmt.Errorf("create rds(mysql) user from alibaba api failure:%s", response.String())
+		}
+		if !response.IsSuccess() {
+			return fmt.Errorf("create rds(mysql) user from alibaba api failure:%s", response.String())
+		}
 	}
 	ticker := time.NewTicker(time.Second * 1)
 	timer := time.NewTimer(time.Minute * 3)
@@ -192,7 +208,7 @@ func (a *ackAdaptor) createDBAcount(regionID, instanceID, name, password string)
 }
 
 func (a *ackAdaptor) createDatabase(regionID, instanceID, dbName string) error {
-	ecsclient, err := rds.NewClientWithAccessKey(regionID, a.accessKeyID, a.accessKeySecret)
+	ecsclient, err := rds.NewClientWithOptions(regionID, sdkConfig(), a.credential())
 	if err != nil {
 		return err
 	}
@@ -204,23 +220,29 @@ func (a *ackAdaptor) createDatabase(regionID, instanceID, dbName string) error {
 	if err != nil && !strings.Contains(err.Error(), "The specified resource does not exist") {
 		return err
 	}
+	var exist bool
 	for _, db := range dres.Databases.Database {
-		logrus.Infof("db database %s status is %s", dbName, db.DBStatus)
-		if db.DBStatus == "Running" && db.DBName == dbName {
-			return nil
+		if db.DBName == dbName {
+			logrus.Infof("db database %s status is %s", dbName, db.DBStatus)
+			if db.DBStatus == "Running" {
+				return nil
+			}
+			exist = true
 		}
 	}
-	database := rds.CreateCreateDatabaseRequest()
-	database.DBInstanceId = instanceID
-	database.DBName = dbName
-	database.CharacterSetName = "utf8"
-	database.DBDescription = "create by wutong cloud"
-	res, err := ecsclient.CreateDatabase(database)
-	if err != nil {
-		return fmt.Errorf("create rds(mysql) database from alibaba api failure:%s", res.String())
-	}
-	if !res.IsSuccess() {
-		return fmt.Errorf("create rds(mysql) database from alibaba api failure:%s", res.String())
+	if !exist {
+		database := rds.CreateCreateDatabaseRequest()
+		database.DBInstanceId = instanceID
+		database.DBName = dbName
+		database.CharacterSetName = "utf8"
+		database.DBDescription = "create by wutong cloud"
+		res, err := ecsclient.CreateDatabase(database)
+		if err != nil {
+			return fmt.Errorf("create rds(mysql) database from alibaba api failure:%s", res.String())
+		}
+		if !res.IsSuccess() {
+			return fmt.Errorf("create rds(mysql) database from alibaba api failure:%s", res.String())
+		}
 	}
 	ticker := time.NewTicker(time.Second * 1)
 	timer := time.NewTimer(time.Minute * 3)
@@ -250,10 +272,26 @@ func (a *ackAdaptor) createDatabase(regionID, instanceID, dbName string) error {
 }
 
 func (a *ackAdaptor) createGrantAccountPrivilege(regionID, instanceID, dbName, userName string) error {
-	ecsclient, err := rds.NewClientWithAccessKey(regionID, a.accessKeyID, a.accessKeySecret)
+	ecsclient, err := rds.NewClientWithOptions(regionID, sdkConfig(), a.credential())
+	if err != nil {
+		return err
+	}
+	req := rds.CreateDescribeAccountsRequest()
+	req.Scheme = "https"
+	req.AccountName = userName
+	req.DBInstanceId = instanceID
+	res, err := ecsclient.DescribeAccounts(req)
 	if err != nil {
 		return err
 	}
+	for _, account := range res.Accounts.DBInstanceAccount {
+		for _, p := range account.DatabasePrivileges.DatabasePrivilege {
+			if p.DBName == dbName && p.AccountPrivilege == "ReadWrite" {
+				logrus.Infof("db account %s already has privilege of %s", userName, dbName)
+				return nil
+			}
+		}
+	}
 	privilege := rds.CreateGrantAccountPrivilegeRequest()
 	privilege.DBInstanceId = instanceID
 	privilege.AccountName = userName
@@ -268,18 +306,25 @@ func (a *ackAdaptor) createGrantAccountPrivilege(regionID, instanceID, dbName, u
 	}
 	return nil
 }
-func (a *ackAdaptor) CreateDB(db *v1alpha1.Database) error {
+func (a *ackAdaptor) CreateDB(ctx context.Context, db *v1alpha1.Database) error {
 	if db.RegionID == "" {
 		return fmt.Errorf("not privide region id")
 	}
 	//create instance
 	if db.InstanceID == "" {
-		instance, err := a.DescribeDBInstance(db.ClusterID, db.RegionID, db.ZoneID)
+		instance, err := a.DescribeDBInstance(db.ClusterID, db.RegionID)
 		if err != nil {
 			return fmt.Errorf("describe rds(mysql) from alibaba api failure:%s", err.Error())
 		}
 		if instance != nil {
+			logrus.Infof("db instance %s for cluster %s is exist, reuse it", instance.DBInstanceId, db.ClusterID)
+			if instance.DBInstanceStatus != "Running" {
+				if err := a.WaitingDBInstanceReady(ctx, db.RegionID, instance.DBInstanceId); err != nil {
+					return err
+				}
+			}
 			db.InstanceID = instance.DBInstanceId
+			a.record(&v1alpha1.CloudResource{ClusterID: db.ClusterID, Type: v1alpha1.ResourceTypeRDS, CloudID: db.InstanceID, RegionID: db.RegionID})
 			res, err := a.DescribeDBInstanceNetInfo(db.RegionID, instance.DBInstanceId)
 			if err != nil {
 				return fmt.Errorf("describe rds(mysql) net info from alibaba api failure:%s", err.Error())
@@ -300,11 +345,12 @@ func (a *ackAdaptor) CreateDB(db *v1alpha1.Database) error {
 				}
 			}
 		} else {
-			response, err := a.CreateDBInstance(db.ClusterID, db.RegionID, db.ZoneID, db.VPCID, db.VSwitchID, db.PodCIDR)
+			response, err := a.CreateDBInstance(ctx, db.ClusterID, db.RegionID, db.ZoneID, db.VPCID, db.VSwitchID, db.PodCIDR)
 			if err != nil {
 				return fmt.Errorf("create rds(mysql) from alibaba api failure:%s", err.Error())
 			}
 			db.InstanceID = response.DBInstanceId
+			a.record(&v1alpha1.CloudResource{ClusterID: db.ClusterID, Type: v1alpha1.ResourceTypeRDS, CloudID: db.InstanceID, RegionID: db.RegionID})
 			db.Host = response.ConnectionString
 			db.Port, _ = strconv.Atoi(response.Port)
 		}
@@ -324,3 +370,37 @@ func (a *ackAdaptor) CreateDB(db *v1alpha1.Database) error {
 	}
 	return nil
 }
+
+// DeleteDBInstance delete the region db instance created for the cluster
+func (a *ackAdaptor) DeleteDBInstance(clusterID, regionID string) error {
+	instance, err := a.DescribeDBInstance(clusterID, regionID)
+	if err != nil {
+		return err
+	}
+	if instance == nil {
+		logrus.Infof("db instance for cluster %s is not exist", clusterID)
+		return nil
+	}
+	if err := a.deleteDBInstance(regionID, instance.DBInstanceId); err != nil {
+		return err
+	}
+	logrus.Infof("db instance %s for cluster %s deleted", instance.DBInstanceId, clusterID)
+	return nil
+}
+
+func (a *ackAdaptor) deleteDBInstance(regionID, instanceID string) error {
+	client, err := rds.NewClientWithOptions(regionID, sdkConfig(), a.credential())
+	if err != nil {
+		return err
+	}
+	request := rds.CreateDeleteDBInstanceRequest()
+	request.Scheme = "https"
+	request.DBInstanceId = instanceID
+	if _, err := client.DeleteDBInstance(request); err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("delete db instance %s failure %s", instanceID, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package ack
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/errors"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/ecs"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/nas"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/slb"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+)
+
+// SetResourceRecorder set the recorder of the cloud resources created by adaptor
+func (a *ackAdaptor) SetResourceRecorder(recorder adaptor.ResourceRecorder) {
+	a.recorder = recorder
+}
+
+func (a *ackAdaptor) record(resource *v1alpha1.CloudResource) {
+	if a.recorder != nil {
+		a.recorder(resource)
+	}
+}
+
+// ReleaseResource release the cloud resource created by adaptor.
+// Resource that does not exist is regarded as released.
+func (a *ackAdaptor) ReleaseResource(ctx context.Context, resource *v1alpha1.CloudResource) error {
+	switch resource.Type {
+	case v1alpha1.ResourceTypeRDS:
+		return a.deleteDBInstance(resource.RegionID, resource.CloudID)
+	case v1alpha1.ResourceTypeNAS:
+		return a.deleteFileSystem(resource.RegionID, resource.CloudID)
+	case v1alpha1.ResourceTypeNASMountTarget:
+		client, err := nas.NewClientWithOptions(resource.RegionID, sdkConfig(), a.credential())
+		if err != nil {
+			return err
+		}
+		return a.deleteMountTarget(client, resource.ParentID, resource.CloudID)
+	case v1alpha1.ResourceTypeSLB:
+		client, err := slb.NewClientWithOptions(resource.RegionID, sdkConfig(), a.credential())
+		if err != nil {
+			return err
+		}
+		return a.deleteLoadBalancer(client, resource.RegionID, resource.CloudID)
+	case v1alpha1.ResourceTypeSecurityGroupRule:
+		return a.revokeSecurityGroupRule(resource.RegionID, resource.CloudID, resource.Spec)
+	}
+	return fmt.Errorf("resource type %s not support", resource.Type)
+}
+
+func (a *ackAdaptor) revokeSecurityGroupRule(regionID, securityGroupID, portRange string) error {
+	client, err := ecs.NewClientWithOptions(regionID, sdkConfig(), a.credential())
+	if err != nil {
+		return err
+	}
+	request := ecs.CreateRevokeSecurityGroupRequest()
+	request.Scheme = "https"
+	request.SecurityGroupId = securityGroupID
+	request.PortRange = portRange
+	request.IpProtocol = "tcp"
+	request.SourceCidrIp = "0.0.0.0/0"
+	if _, err := client.RevokeSecurityGroup(request); err != nil && !isNotFound(err) {
+		return fmt.Errorf("revoke security rule %s of %s failure %s", portRange, securityGroupID, err.Error())
+	}
+	return nil
+}
+
+// isNotFound whether the alibaba api error means the resource does not exist
+func isNotFound(err error) bool {
+	if real, ok := err.(*errors.ServerError); ok {
+		return strings.Contains(real.ErrorCode(), "NotFound") || strings.Contains(real.ErrorCode(), "NotExist")
+	}
+	return false
+}
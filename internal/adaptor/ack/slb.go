@@ -32,21 +32,27 @@ import (
 )
 
 func (a *ackAdaptor) CreateLoadBalancer(clusterID, regionID string) (*v1alpha1.LoadBalancer, error) {
-	client, err := slb.NewClientWithAccessKey(regionID, a.accessKeyID, a.accessKeySecret)
+	client, err := slb.NewClientWithOptions(regionID, sdkConfig(), a.credential())
 	if err != nil {
 		return nil, err
 	}
 	req := slb.CreateDescribeLoadBalancersRequest()
 	req.Scheme = "https"
 	req.RegionId = regionID
+	req.LoadBalancerName = "wutong-region-lb_" + clusterID
 	res, err := client.DescribeLoadBalancers(req)
 	if err != nil {
 		return nil, err
 	}
-	for _, slb := range res.LoadBalancers.LoadBalancer {
-		logrus.Infof("slb %s status is %s", slb.LoadBalancerId, slb.LoadBalancerStatus)
-		if slb.LoadBalancerStatus == "active" && slb.LoadBalancerName == "wutong-region-lb_"+clusterID {
-			return a.slbConver(slb), nil
+	for _, lb := range res.LoadBalancers.LoadBalancer {
+		logrus.Infof("slb %s status is %s", lb.LoadBalancerId, lb.LoadBalancerStatus)
+		if lb.LoadBalancerName == "wutong-region-lb_"+clusterID {
+			logrus.Infof("slb %s for cluster %s is exist, reuse it", lb.LoadBalancerId, clusterID)
+			a.record(&v1alpha1.CloudResource{ClusterID: clusterID, Type: v1alpha1.ResourceTypeSLB, CloudID: lb.LoadBalancerId, RegionID: regionID})
+			if lb.LoadBalancerStatus == "active" {
+				return a.slbConver(lb), nil
+			}
+			return a.waitingLoadBalancerActive(client, regionID, lb.LoadBalancerId)
 		}
 	}
 	request := slb.CreateCreateLoadBalancerRequest()
@@ -65,6 +71,11 @@ func (a *ackAdaptor) CreateLoadBalancer(clusterID, regionID string) (*v1alpha1.L
 	if !response.IsSuccess() {
 		return nil, fmt.Errorf("create load balance failure:%s", response.String())
 	}
+	a.record(&v1alpha1.CloudResource{ClusterID: clusterID, Type: v1alpha1.ResourceTypeSLB, CloudID: response.LoadBalancerId, RegionID: regionID})
+	return a.waitingLoadBalancerActive(client, regionID, response.LoadBalancerId)
+}
+
+func (a *ackAdaptor) waitingLoadBalancerActive(client *slb.Client, regionID, loadBalancerID string) (*v1alpha1.LoadBalancer, error) {
 	ticker := time.NewTicker(time.Second * 3)
 	timer := time.NewTimer(time.Minute * 5)
 	defer timer.Stop()
@@ -78,7 +89,7 @@ func (a *ackAdaptor) CreateLoadBalancer(clusterID, regionID string) (*v1alpha1.L
 		req := slb.CreateDescribeLoadBalancersRequest()
 		req.Scheme = "https"
 		req.RegionId = regionID
-		req.LoadBalancerId = response.LoadBalancerId
+		req.LoadBalancerId = loadBalancerID
 		res, err := client.DescribeLoadBalancers(req)
 		if err != nil {
 			return nil, err
@@ -87,7 +98,7 @@ func (a *ackAdaptor) CreateLoadBalancer(clusterID, regionID string) (*v1alpha1.L
 			if slb.LoadBalancerStatus == "active" {
 				return a.slbConver(slb), nil
 			}
-			logrus.Infof("slb %s status is %s", response.LoadBalancerId, slb.LoadBalancerStatus)
+			logrus.Infof("slb %s status is %s", loadBalancerID, slb.LoadBalancerStatus)
 		}
 	}
 }
@@ -116,7 +127,7 @@ func (a *ackAdaptor) slbConver(v slb.LoadBalancer) *v1alpha1.LoadBalancer {
 }
 
 func (a *ackAdaptor) createVServerGroup(clusterID, regionID, vpcID, loadBalancerID string, endpoints []string, port int) (string, error) {
-	client, err := slb.NewClientWithAccessKey(regionID, a.accessKeyID, a.accessKeySecret)
+	client, err := slb.NewClientWithOptions(regionID, sdkConfig(), a.credential())
 	if err != nil {
 		return "", err
 	}
@@ -184,7 +195,7 @@ func (a *ackAdaptor) BoundLoadBalancerToCluster(clusterID, regionID, vpcID, load
 }
 
 func (a *ackAdaptor) CreateLoadBalancerTCPListener(clusterID, regionID, loadBalancerID, verserGroupID string, listenPort int) error {
-	client, err := slb.NewClientWithAccessKey(regionID, a.accessKeyID, a.accessKeySecret)
+	client, err := slb.NewClientWithOptions(regionID, sdkConfig(), a.credential())
 	if err != nil {
 		return err
 	}
@@ -202,19 +213,22 @@ func (a *ackAdaptor) CreateLoadBalancerTCPListener(clusterID, regionID, loadBala
 		return nil
 	}
 
-	request := slb.CreateCreateLoadBalancerTCPListenerRequest()
-	request.Scheme = "https"
-	request.ListenerPort = requests.NewInteger(listenPort)
-	request.Bandwidth = requests.NewInteger(-1)
-	request.LoadBalancerId = loadBalancerID
-	request.BackendServerPort = requests.NewInteger(listenPort)
-	request.VServerGroupId = verserGroupID
-	response, err := client.CreateLoadBalancerTCPListener(request)
-	if err != nil {
-		return err
-	}
-	if !response.IsSuccess() {
-		return fmt.Errorf("create load balance %s tcp listener port %d failure:%s", loadBalancerID, listenPort, response.String())
+	// the listener created by the previous task only needs to be started
+	if err != nil || res == nil || res.Status == "" {
+		request := slb.CreateCreateLoadBalancerTCPListenerRequest()
+		request.Scheme = "https"
+		request.ListenerPort = requests.NewInteger(listenPort)
+		request.Bandwidth = requests.NewInteger(-1)
+		request.LoadBalancerId = loadBalancerID
+		request.BackendServerPort = requests.NewInteger(listenPort)
+		request.VServerGroupId = verserGroupID
+		response, err := client.CreateLoadBalancerTCPListener(request)
+		if err != nil {
+			return err
+		}
+		if !response.IsSuccess() {
+			return fmt.Errorf("create load balance %s tcp listener port %d failure:%s", loadBalancerID, listenPort, response.String())
+		}
 	}
 	// start listener
 	srequest := slb.CreateStartLoadBalancerListenerRequest()
@@ -226,7 +240,7 @@ func (a *ackAdaptor) CreateLoadBalancerTCPListener(clusterID, regionID, loadBala
 		return fmt.Errorf("start load balance %s tcp listenner port %d failure %s", loadBalancerID, listenPort, err.Error())
 	}
 	if !sresponse.IsSuccess() {
-		return fmt.Errorf("start load balance %s tcp listener port %d failure:%s", loadBalancerID, listenPort, response.String())
+		return fmt.Errorf("start load balance %s tcp listener port %d failure:%s", loadBalancerID, listenPort, sresponse.String())
 	}
 	// check listener status is running
 	ticker := time.NewTicker(time.Second * 3)
@@ -255,3 +269,40 @@ func (a *ackAdaptor) CreateLoadBalancerTCPListener(clusterID, regionID, loadBala
 		logrus.Infof("slb %s listener port %d status is %s", loadBalancerID, listenPort, res.Status)
 	}
 }
+
+// DeleteLoadBalancer delete the load balancer created for the cluster
+func (a *ackAdaptor) DeleteLoadBalancer(clusterID, regionID string) error {
+	client, err := slb.NewClientWithOptions(regionID, sdkConfig(), a.credential())
+	if err != nil {
+		return err
+	}
+	req := slb.CreateDescribeLoadBalancersRequest()
+	req.Scheme = "https"
+	req.RegionId = regionID
+	req.LoadBalancerName = "wutong-region-lb_" + clusterID
+	res, err := client.DescribeLoadBalancers(req)
+	if err != nil {
+		return err
+	}
+	for _, lb := range res.LoadBalancers.LoadBalancer {
+		if lb.LoadBalancerName != "wutong-region-lb_"+clusterID {
+			continue
+		}
+		if err := a.deleteLoadBalancer(client, regionID, lb.LoadBalancerId); err != nil {
+			return err
+		}
+		logrus.Infof("load balancer %s for cluster %s deleted", lb.LoadBalancerId, clusterID)
+	}
+	return nil
+}
+
+func (a *ackAdaptor) deleteLoadBalancer(client *slb.Client, regionID, loadBalancerID string) error {
+	request := slb.CreateDeleteLoadBalancerRequest()
+	request.Scheme = "https"
+	request.RegionId = regionID
+	request.LoadBalancerId = loadBalancerID
+	if _, err := client.DeleteLoadBalancer(request); err != nil && !isNotFound(err) {
+		return fmt.Errorf("delete load balancer %s failure %s", loadBalancerID, err.Error())
+	}
+	return nil
+}
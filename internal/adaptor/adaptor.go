@@ -23,6 +23,7 @@ import (
 	"fmt"
 
 	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
 	wutongv1alpha1 "github.com/wutong-paas/wutong-operator/api/v1alpha1"
 )
 
@@ -36,14 +37,14 @@ type CloudAdaptor interface {
 	WutongClusterAdaptor
 	VPCList(regionID string) ([]*v1alpha1.VPC, error)
 	CreateVPC(v *v1alpha1.VPC) error
-	DeleteVPC(regionID, vpcID string) error
+	DeleteVPC(ctx context.Context, regionID, vpcID string) error
 	DescribeVPC(regionID, vpcID string) (*v1alpha1.VPC, error)
 	CreateVSwitch(v *v1alpha1.VSwitch) error
 	DescribeVSwitch(regionID, vswitchID string) (*v1alpha1.VSwitch, error)
-	DeleteVSwitch(regionID, vswitchID string) error
+	DeleteVSwitch(ctx context.Context, regionID, vswitchID string) error
 	ListZones(regionID string) ([]*v1alpha1.Zone, error)
 	ListInstanceType(regionID string) ([]*v1alpha1.InstanceType, error)
-	CreateDB(*v1alpha1.Database) error
+	CreateDB(ctx context.Context, db *v1alpha1.Database) error
 }
 
 // KubernetesClusterAdaptor -
@@ -53,12 +54,81 @@ type KubernetesClusterAdaptor interface {
 	CreateCluster(config v1alpha1.CreateClusterConfig) (*v1alpha1.Cluster, error)
 	GetKubeConfig(clusterID string) (*v1alpha1.KubeConfig, error)
 	DeleteCluster(clusterID string) error
-	ExpansionNode(ctx context.Context, en *v1alpha1.ExpansionNode, rollback func(step, message, status string)) *v1alpha1.Cluster
+	ExpansionNode(ctx context.Context, en *v1alpha1.ExpansionNode, rollback func(step domain.TaskStep, message, status string)) *v1alpha1.Cluster
 }
 
 // WutongClusterAdaptor wutong init adaptor
 type WutongClusterAdaptor interface {
 	KubernetesClusterAdaptor
-	CreateWutongKubernetes(ctx context.Context, config *v1alpha1.KubernetesClusterConfig, rollback func(step, message, status string)) *v1alpha1.Cluster
-	GetWutongInitConfig(cluster *v1alpha1.Cluster, gateway, chaos []*wutongv1alpha1.K8sNode, rollback func(step, message, status string)) *v1alpha1.WutongInitConfig
+	CreateWutongKubernetes(ctx context.Context, config *v1alpha1.KubernetesClusterConfig, rollback func(step domain.TaskStep, message, status string)) *v1alpha1.Cluster
+	GetWutongInitConfig(ctx context.Context, cluster *v1alpha1.Cluster, gateway, chaos []*wutongv1alpha1.K8sNode, rollback func(step domain.TaskStep, message, status string)) *v1alpha1.WutongInitConfig
+}
+
+// UpgradeAdaptor the adaptor that can upgrade the kubernetes version of cluster
+type UpgradeAdaptor interface {
+	UpgradeKubernetes(ctx context.Context, config *v1alpha1.UpgradeKubernetes, rollback func(step domain.TaskStep, message, status string)) *v1alpha1.Cluster
+}
+
+// EtcdSnapshotAdaptor the adaptor that can save the etcd snapshots of cluster and restore from them
+type EtcdSnapshotAdaptor interface {
+	SaveEtcdSnapshot(ctx context.Context, config *v1alpha1.EtcdSnapshot, rollback func(step domain.TaskStep, message, status string))
+	RestoreEtcdSnapshot(ctx context.Context, config *v1alpha1.EtcdSnapshot, rollback func(step domain.TaskStep, message, status string)) *v1alpha1.Cluster
+	ListEtcdSnapshots(ctx context.Context, clusterID string) ([]*v1alpha1.EtcdSnapshotInfo, error)
+}
+
+// CertificateAdaptor the adaptor that can report the certificates of cluster and rotate them
+type CertificateAdaptor interface {
+	GetCertificates(clusterID string) ([]*v1alpha1.CertificateInfo, error)
+	RotateCertificates(ctx context.Context, config *v1alpha1.RotateCertificates, rollback func(step domain.TaskStep, message, status string)) *v1alpha1.Cluster
+}
+
+// SecretsEncryptionAdaptor the adaptor that can enable the secrets encryption of cluster and rotate its key
+type SecretsEncryptionAdaptor interface {
+	GetSecretsEncryptionState(clusterID string) (*v1alpha1.SecretsEncryptionState, error)
+	UpdateSecretsEncryption(ctx context.Context, config *v1alpha1.SecretsEncryption, rollback func(step domain.TaskStep, message, status string)) *v1alpha1.Cluster
+}
+
+// NodeRemovalAdaptor the adaptor that can remove nodes from cluster safely
+type NodeRemovalAdaptor interface {
+	RemoveNodes(ctx context.Context, config *v1alpha1.RemoveNodes, rollback func(step domain.TaskStep, message, status string)) *v1alpha1.Cluster
+}
+
+// DestroyAdaptor the adaptor that can remove kubernetes from all nodes of cluster before deleting it
+type DestroyAdaptor interface {
+	DestroyCluster(ctx context.Context, config *v1alpha1.DestroyCluster, rollback func(step domain.TaskStep, message, status string))
+}
+
+// ClusterStateAdaptor the adaptor that keeps versioned states of cluster and can roll back to them
+type ClusterStateAdaptor interface {
+	ListClusterStates(clusterID string) ([]*v1alpha1.ClusterStateVersion, error)
+	RollbackClusterState(clusterID string, version int) (*v1alpha1.ClusterStateVersion, error)
+	// RestoreClusterState writes the latest stored state of cluster into the local state dir of the replica,
+	// if the local state is missing or older than it
+	RestoreClusterState(clusterID string) error
+}
+
+// PreflightAdaptor the adaptor that can check the nodes before installing kubernetes on them
+type PreflightAdaptor interface {
+	Preflight(ctx context.Context, config *v1alpha1.Preflight) *v1alpha1.PreflightReport
+}
+
+// SSHKeyAdaptor the adaptor that connects the nodes of cluster with its own ssh key and can rotate it
+type SSHKeyAdaptor interface {
+	GetSSHKey(clusterID string) (*v1alpha1.SSHKeyInfo, error)
+	RotateSSHKey(ctx context.Context, config *v1alpha1.RotateSSHKey, rollback func(step domain.TaskStep, message, status string)) *v1alpha1.Cluster
+}
+
+// ResumableAdaptor the adaptor that can skip the init steps completed by the previous task of the cluster
+type ResumableAdaptor interface {
+	SetCompletedSteps(steps []string)
+}
+
+// ResourceRecorder record a cloud resource just created by adaptor
+type ResourceRecorder func(resource *v1alpha1.CloudResource)
+
+// CloudResourceAdaptor the adaptor that creates cloud resources outside of the kubernetes cluster,
+// such as rds, nas and slb.
+type CloudResourceAdaptor interface {
+	SetResourceRecorder(recorder ResourceRecorder)
+	ReleaseResource(ctx context.Context, resource *v1alpha1.CloudResource) error
 }
@@ -30,6 +30,7 @@ import (
 	"github.com/wutong-paas/cloud-adaptor/internal/adaptor"
 	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
 	"github.com/wutong-paas/cloud-adaptor/internal/datastore"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
 	"github.com/wutong-paas/cloud-adaptor/internal/model"
 	"github.com/wutong-paas/cloud-adaptor/internal/repo"
 	"github.com/wutong-paas/cloud-adaptor/pkg/bcode"
@@ -43,6 +44,26 @@ type customAdaptor struct {
 	Repo *repo.CustomClusterRepo
 }
 
+func init() {
+	adaptor.Register(&adaptor.Provider{
+		Name: "custom",
+		Capabilities: adaptor.Capabilities{
+			NeedCredentials: false,
+			CreateCluster:   false,
+			ExpansionNode:   false,
+			DeleteCluster:   true,
+			KubeConfig:      true,
+			CloudDB:         false,
+			CloudNAS:        false,
+			CloudLB:         false,
+		},
+		Adaptor: (*customAdaptor)(nil),
+		New: func(accessKeyID, accessKeySecret string) (adaptor.WutongClusterAdaptor, error) {
+			return Create()
+		},
+	})
+}
+
 // Create create ack adaptor
 func Create() (adaptor.WutongClusterAdaptor, error) {
 	return &customAdaptor{
@@ -152,7 +173,7 @@ func (c *customAdaptor) DeleteCluster(clusterID string) error {
 	return c.Repo.DeleteCluster(clusterID)
 }
 
-func (c *customAdaptor) GetWutongInitConfig(cluster *v1alpha1.Cluster, gateway, chaos []*wutongv1alpha1.K8sNode, rollback func(step, message, status string)) *v1alpha1.WutongInitConfig {
+func (c *customAdaptor) GetWutongInitConfig(ctx context.Context, cluster *v1alpha1.Cluster, gateway, chaos []*wutongv1alpha1.K8sNode, rollback func(step domain.TaskStep, message, status string)) *v1alpha1.WutongInitConfig {
 	return &v1alpha1.WutongInitConfig{
 		EnableHA:     cluster.Size > 3,
 		ClusterID:    cluster.ClusterID,
@@ -183,11 +204,11 @@ func (c *customAdaptor) CreateCluster(v1alpha1.CreateClusterConfig) (*v1alpha1.C
 	return nil, nil
 }
 
-func (c *customAdaptor) CreateWutongKubernetes(ctx context.Context, config *v1alpha1.KubernetesClusterConfig, rollback func(step, message, status string)) *v1alpha1.Cluster {
-	rollback("CreateCluster", "", "success")
+func (c *customAdaptor) CreateWutongKubernetes(ctx context.Context, config *v1alpha1.KubernetesClusterConfig, rollback func(step domain.TaskStep, message, status string)) *v1alpha1.Cluster {
+	rollback(domain.TaskStepCreateCluster, "", "success")
 	return nil
 }
 
-func (c *customAdaptor) ExpansionNode(ctx context.Context, en *v1alpha1.ExpansionNode, rollback func(step, message, status string)) *v1alpha1.Cluster {
+func (c *customAdaptor) ExpansionNode(ctx context.Context, en *v1alpha1.ExpansionNode, rollback func(step domain.TaskStep, message, status string)) *v1alpha1.Cluster {
 	return nil
 }
@@ -22,9 +22,11 @@ import (
 	"fmt"
 
 	"github.com/wutong-paas/cloud-adaptor/internal/adaptor"
-	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/ack"
-	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/custom"
-	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/rke"
+	// register the provider adaptors
+	_ "github.com/wutong-paas/cloud-adaptor/internal/adaptor/ack"
+	_ "github.com/wutong-paas/cloud-adaptor/internal/adaptor/custom"
+	_ "github.com/wutong-paas/cloud-adaptor/internal/adaptor/rke"
+	_ "github.com/wutong-paas/cloud-adaptor/internal/adaptor/tke"
 )
 
 //ErrorNotSupport not support adaptor
@@ -47,23 +49,21 @@ func GetCloudFactory() CloudFactory {
 }
 
 func (f *cloudFactory) GetAdaptor(adaptorType, accessKeyID, accessKeySecret string) (adaptor.CloudAdaptor, error) {
-	switch adaptorType {
-	case "ack":
-		return ack.Create(accessKeyID, accessKeySecret)
-	default:
+	ad, err := f.GetWutongClusterAdaptor(adaptorType, accessKeyID, accessKeySecret)
+	if err != nil {
+		return nil, err
+	}
+	cloudAdaptor, ok := ad.(adaptor.CloudAdaptor)
+	if !ok {
 		return nil, ErrorNotSupport
 	}
+	return cloudAdaptor, nil
 }
 
 func (f *cloudFactory) GetWutongClusterAdaptor(adaptorType, accessKeyID, accessKeySecret string) (adaptor.WutongClusterAdaptor, error) {
-	switch adaptorType {
-	case "ack":
-		return ack.Create(accessKeyID, accessKeySecret)
-	case "rke":
-		return rke.Create()
-	case "custom":
-		return custom.Create()
-	default:
+	provider, ok := adaptor.GetProvider(adaptorType)
+	if !ok {
 		return nil, ErrorNotSupport
 	}
+	return provider.New(accessKeyID, accessKeySecret)
 }
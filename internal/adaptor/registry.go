@@ -0,0 +1,144 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package adaptor
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Capabilities describes what a provider adaptor is able to do. The optional capabilities implemented by the
+// optional interfaces of adaptor are derived from the adaptor when the provider is registered.
+type Capabilities struct {
+	// NeedCredentials the adaptor requires the access key of the enterprise
+	NeedCredentials bool `json:"need_credentials"`
+	CreateCluster   bool `json:"create_cluster"`
+	ExpansionNode   bool `json:"expansion_node"`
+	DeleteCluster   bool `json:"delete_cluster"`
+	// RKEConfig the cluster is described by the rke config, it is required to create or update the cluster
+	RKEConfig bool `json:"rke_config"`
+	// KubeConfig the cluster is imported by its kubeconfig
+	KubeConfig bool `json:"kube_config"`
+	// UpgradeKubernetes the adaptor implements UpgradeAdaptor
+	UpgradeKubernetes bool `json:"upgrade_kubernetes"`
+	// EtcdSnapshot the adaptor implements EtcdSnapshotAdaptor
+	EtcdSnapshot bool `json:"etcd_snapshot"`
+	// RotateCertificates the adaptor implements CertificateAdaptor
+	RotateCertificates bool `json:"rotate_certificates"`
+	// SecretsEncryption the adaptor implements SecretsEncryptionAdaptor
+	SecretsEncryption bool `json:"secrets_encryption"`
+	// RemoveNodes the adaptor implements NodeRemovalAdaptor
+	RemoveNodes bool `json:"remove_nodes"`
+	// DestroyCluster the adaptor implements DestroyAdaptor
+	DestroyCluster bool `json:"destroy_cluster"`
+	// ClusterState the adaptor implements ClusterStateAdaptor
+	ClusterState bool `json:"cluster_state"`
+	// Preflight the adaptor implements PreflightAdaptor
+	Preflight bool `json:"preflight"`
+	// SSHKey the adaptor implements SSHKeyAdaptor
+	SSHKey   bool `json:"ssh_key"`
+	CloudDB  bool `json:"cloud_db"`
+	CloudNAS bool `json:"cloud_nas"`
+	CloudLB  bool `json:"cloud_lb"`
+}
+
+// derive sets the optional capabilities by the interfaces implemented by the adaptor
+func (c *Capabilities) derive(ad WutongClusterAdaptor) {
+	_, c.UpgradeKubernetes = ad.(UpgradeAdaptor)
+	_, c.EtcdSnapshot = ad.(EtcdSnapshotAdaptor)
+	_, c.RotateCertificates = ad.(CertificateAdaptor)
+	_, c.SecretsEncryption = ad.(SecretsEncryptionAdaptor)
+	_, c.RemoveNodes = ad.(NodeRemovalAdaptor)
+	_, c.DestroyCluster = ad.(DestroyAdaptor)
+	_, c.ClusterState = ad.(ClusterStateAdaptor)
+	_, c.Preflight = ad.(PreflightAdaptor)
+	_, c.SSHKey = ad.(SSHKeyAdaptor)
+}
+
+// Provider a registered provider adaptor
+type Provider struct {
+	Name         string       `json:"name"`
+	Capabilities Capabilities `json:"capabilities"`
+	// Adaptor the zero value of the adaptor type, such as (*rkeAdaptor)(nil). The optional capabilities are derived
+	// from the interfaces it implements.
+	Adaptor WutongClusterAdaptor `json:"-"`
+	// New create the adaptor, the access key is empty if the provider does not need credentials.
+	New func(accessKeyID, accessKeySecret string) (WutongClusterAdaptor, error) `json:"-"`
+}
+
+// registry the registered providers
+type registry struct {
+	lock      sync.RWMutex
+	providers map[string]*Provider
+}
+
+func newRegistry() *registry {
+	return &registry{providers: make(map[string]*Provider)}
+}
+
+var defaultRegistry = newRegistry()
+
+// Register registers a provider adaptor, it panics if the name is empty or registered twice.
+func Register(provider *Provider) {
+	defaultRegistry.register(provider)
+}
+
+// GetProvider get the provider by name
+func GetProvider(name string) (*Provider, bool) {
+	return defaultRegistry.get(name)
+}
+
+// ListProviders list all registered providers sorted by name
+func ListProviders() []*Provider {
+	return defaultRegistry.list()
+}
+
+func (r *registry) register(provider *Provider) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if provider == nil || provider.Name == "" || provider.New == nil || provider.Adaptor == nil {
+		panic("adaptor: register invalid provider")
+	}
+	if _, ok := r.providers[provider.Name]; ok {
+		panic(fmt.Sprintf("adaptor: provider %s registered twice", provider.Name))
+	}
+	provider.Capabilities.derive(provider.Adaptor)
+	r.providers[provider.Name] = provider
+}
+
+func (r *registry) get(name string) (*Provider, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	provider, ok := r.providers[name]
+	return provider, ok
+}
+
+func (r *registry) list() []*Provider {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	var list []*Provider
+	for _, provider := range r.providers {
+		list = append(list, provider)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
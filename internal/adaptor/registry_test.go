@@ -0,0 +1,66 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package adaptor
+
+import "testing"
+
+// fakeAdaptor implements the optional UpgradeAdaptor only
+type fakeAdaptor struct {
+	WutongClusterAdaptor
+	UpgradeAdaptor
+}
+
+func TestRegister(t *testing.T) {
+	newAdaptor := func(accessKeyID, accessKeySecret string) (WutongClusterAdaptor, error) {
+		return nil, nil
+	}
+	registry := newRegistry()
+	registry.register(&Provider{Name: "test-b", Adaptor: fakeAdaptor{}, New: newAdaptor})
+	registry.register(&Provider{Name: "test-a", Capabilities: Capabilities{NeedCredentials: true}, Adaptor: fakeAdaptor{}, New: newAdaptor})
+
+	provider, ok := registry.get("test-a")
+	if !ok || !provider.Capabilities.NeedCredentials {
+		t.Fatalf("provider test-a not found or capabilities mismatch")
+	}
+	if !provider.Capabilities.UpgradeKubernetes || provider.Capabilities.EtcdSnapshot {
+		t.Fatalf("the optional capabilities are not derived from the adaptor")
+	}
+	if _, ok := registry.get("test-c"); ok {
+		t.Fatalf("provider test-c should not be found")
+	}
+	providers := registry.list()
+	if len(providers) != 2 {
+		t.Fatalf("want 2 providers, got %d", len(providers))
+	}
+	for i := 1; i < len(providers); i++ {
+		if providers[i-1].Name >= providers[i].Name {
+			t.Fatalf("providers are not sorted by name")
+		}
+	}
+	if _, ok := GetProvider("test-a"); ok {
+		t.Fatalf("provider test-a should not be registered globally")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("register provider twice should panic")
+		}
+	}()
+	registry.register(&Provider{Name: "test-a", Adaptor: fakeAdaptor{}, New: newAdaptor})
+}
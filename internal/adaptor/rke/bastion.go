@@ -0,0 +1,201 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package rke
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/rancher/rke/hosts"
+	v3 "github.com/rancher/rke/types"
+	"github.com/sirupsen/logrus"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+	"golang.org/x/crypto/ssh"
+	"k8s.io/client-go/transport"
+)
+
+// nodeDialer connect the nodes of the rke config over ssh, through their bastion hosts if any. rke only
+// supports the bastion host of cluster, the bastion hosts of nodes win over it.
+type nodeDialer struct {
+	rkeConfig *v3.RancherKubernetesEngineConfig
+	bastions  map[string]v3.BastionHost
+}
+
+func newNodeDialer(rkeConfig *v3.RancherKubernetesEngineConfig, bastions v1alpha1.NodeBastions) *nodeDialer {
+	return &nodeDialer{rkeConfig: rkeConfig, bastions: bastions.RKEBastionHosts()}
+}
+
+// dialer the node dialer of the cluster with the stored bastion hosts
+func (r *rkeAdaptor) dialer(rkecluster *model.RKECluster, rkeConfig *v3.RancherKubernetesEngineConfig) *nodeDialer {
+	return newNodeDialer(rkeConfig, nodeBastions(rkecluster))
+}
+
+// bastion the bastion host of the node, returns false if the node is connected directly
+func (d *nodeDialer) bastion(address string) (v3.BastionHost, bool) {
+	if bastion, ok := d.bastions[address]; ok {
+		return bastion, true
+	}
+	if d.rkeConfig != nil && d.rkeConfig.BastionHost.Address != "" {
+		bastion := d.rkeConfig.BastionHost
+		if bastion.Port == "" {
+			bastion.Port = "22"
+		}
+		if bastion.SSHKey == "" && bastion.SSHKeyPath == "" {
+			bastion.SSHKeyPath = d.rkeConfig.SSHKeyPath
+		}
+		return bastion, true
+	}
+	return v3.BastionHost{}, false
+}
+
+// dial connect the node with its ssh key, the ssh rsa of adaptor is used if the node has no ssh key.
+func (d *nodeDialer) dial(node v3.RKEConfigNode) (commandRunner, func(), error) {
+	signer, err := nodeSigner(node)
+	if err != nil {
+		return nil, nil, err
+	}
+	return d.dialWithSigner(node, signer)
+}
+
+// dialWithSigner connect the node with the given ssh key, the bastion host is connected with its own key.
+func (d *nodeDialer) dialWithSigner(node v3.RKEConfigNode, signer ssh.Signer) (commandRunner, func(), error) {
+	port := node.Port
+	if port == "" {
+		port = "22"
+	}
+	address := net.JoinHostPort(node.Address, port)
+	config := &ssh.ClientConfig{
+		User:            nodeUser(node),
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         sshTimeout,
+	}
+	bastion, ok := d.bastion(node.Address)
+	if !ok {
+		client, err := ssh.Dial("tcp", address, config)
+		if err != nil {
+			return nil, nil, fmt.Errorf("connect %s@%s failure %s", config.User, address, err.Error())
+		}
+		return &sshRunner{client: client}, func() { client.Close() }, nil
+	}
+
+	bastionSigner, err := nodeSigner(v3.RKEConfigNode{SSHKey: bastion.SSHKey, SSHKeyPath: bastion.SSHKeyPath})
+	if err != nil {
+		return nil, nil, err
+	}
+	bastionAddress := net.JoinHostPort(bastion.Address, bastion.Port)
+	bastionClient, err := ssh.Dial("tcp", bastionAddress, &ssh.ClientConfig{
+		User:            nodeUser(v3.RKEConfigNode{User: bastion.User}),
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(bastionSigner)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         sshTimeout,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("connect bastion host %s@%s failure %s", bastion.User, bastionAddress, err.Error())
+	}
+	conn, err := bastionClient.Dial("tcp", address)
+	if err != nil {
+		bastionClient.Close()
+		return nil, nil, fmt.Errorf("connect %s through bastion host %s failure %s", address, bastionAddress, err.Error())
+	}
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		conn.Close()
+		bastionClient.Close()
+		return nil, nil, fmt.Errorf("connect %s@%s through bastion host %s failure %s", config.User, address, bastionAddress, err.Error())
+	}
+	client := ssh.NewClient(clientConn, chans, reqs)
+	return &sshRunner{client: client}, func() {
+		client.Close()
+		bastionClient.Close()
+	}, nil
+}
+
+// dialersOptions the dialers used by rke, the dialer factories set the bastion hosts of the nodes which
+// have their own. The kube api is requested through the bastion host of a control plane node if the
+// cluster has no bastion host, rke uses the one of cluster otherwise.
+func (d *nodeDialer) dialersOptions() hosts.DialersOptions {
+	if len(d.bastions) == 0 {
+		return hosts.DialersOptions{}
+	}
+	var k8sWrapTransport transport.WrapperFunc
+	if d.rkeConfig != nil && d.rkeConfig.BastionHost.Address == "" {
+		for _, node := range d.rkeConfig.Nodes {
+			bastion, ok := d.bastions[node.Address]
+			if !ok || !hasRole(node, "controlplane") {
+				continue
+			}
+			wrapTransport, err := hosts.BastionHostWrapTransport(bastion)
+			if err != nil {
+				logrus.Warningf("request kube api through bastion host %s failure %s", bastion.Address, err.Error())
+				break
+			}
+			k8sWrapTransport = wrapTransport
+			break
+		}
+	}
+	return hosts.GetDialerOptions(d.withBastions(hosts.SSHFactory), d.withBastions(hosts.LocalConnFactory), k8sWrapTransport)
+}
+
+// withBastions wrap the dialer factory to connect the hosts through their own bastion hosts
+func (d *nodeDialer) withBastions(factory hosts.DialerFactory) hosts.DialerFactory {
+	return func(h *hosts.Host) (func(network, address string) (net.Conn, error), error) {
+		bastion, ok := d.bastions[h.Address]
+		if !ok {
+			return factory(h)
+		}
+		host := *h
+		host.BastionHost = bastion
+		return factory(&host)
+	}
+}
+
+func hasRole(node v3.RKEConfigNode, role string) bool {
+	for _, r := range node.Role {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeBastions the stored bastion hosts of the nodes of cluster
+func nodeBastions(rkecluster *model.RKECluster) v1alpha1.NodeBastions {
+	if rkecluster == nil || rkecluster.NodeBastions == "" {
+		return nil
+	}
+	var bastions v1alpha1.NodeBastions
+	if err := json.Unmarshal([]byte(rkecluster.NodeBastions), &bastions); err != nil {
+		logrus.Warningf("parse bastion hosts of cluster %s failure %s", rkecluster.Name, err.Error())
+		return nil
+	}
+	return bastions
+}
+
+// setNodeBastions store the bastion hosts of the nodes of cluster
+func setNodeBastions(rkecluster *model.RKECluster, bastions v1alpha1.NodeBastions) {
+	if len(bastions) == 0 {
+		rkecluster.NodeBastions = ""
+		return
+	}
+	body, _ := json.Marshal(bastions)
+	rkecluster.NodeBastions = string(body)
+}
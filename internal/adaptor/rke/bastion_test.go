@@ -0,0 +1,102 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package rke
+
+import (
+	"net"
+	"testing"
+
+	"github.com/rancher/rke/hosts"
+	v3 "github.com/rancher/rke/types"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	sshutil "github.com/wutong-paas/cloud-adaptor/pkg/util/ssh"
+)
+
+func TestNodeDialerBastion(t *testing.T) {
+	rkeConfig := &v3.RancherKubernetesEngineConfig{
+		SSHKeyPath: "~/.ssh/id_rsa",
+		Nodes: []v3.RKEConfigNode{
+			{Address: "192.168.0.1", Role: []string{"controlplane", "etcd"}},
+			{Address: "192.168.0.2", Role: []string{"worker"}},
+		},
+	}
+	dialer := newNodeDialer(rkeConfig, v1alpha1.NodeBastions{"192.168.0.2": {Address: "10.0.0.2"}})
+	if _, ok := dialer.bastion("192.168.0.1"); ok {
+		t.Errorf("want node 192.168.0.1 connected directly")
+	}
+	bastion, ok := dialer.bastion("192.168.0.2")
+	if !ok || bastion.Address != "10.0.0.2" || bastion.Port != "22" || bastion.User != "root" {
+		t.Errorf("want the bastion host of node with the defaults, got %+v", bastion)
+	}
+
+	rkeConfig.BastionHost = v3.BastionHost{Address: "10.0.0.1", User: "jump"}
+	bastion, ok = dialer.bastion("192.168.0.1")
+	if !ok || bastion.Address != "10.0.0.1" || bastion.Port != "22" || bastion.SSHKeyPath != "~/.ssh/id_rsa" {
+		t.Errorf("want the bastion host of cluster with the defaults, got %+v", bastion)
+	}
+	if bastion, _ := dialer.bastion("192.168.0.2"); bastion.Address != "10.0.0.2" {
+		t.Errorf("want the bastion host of node wins, got %s", bastion.Address)
+	}
+}
+
+func TestNodeDialerDialersOptions(t *testing.T) {
+	rkeConfig := &v3.RancherKubernetesEngineConfig{
+		Nodes: []v3.RKEConfigNode{
+			{Address: "192.168.0.1", Role: []string{"controlplane", "etcd"}},
+			{Address: "192.168.0.2", Role: []string{"worker"}},
+		},
+	}
+	if options := newNodeDialer(rkeConfig, nil).dialersOptions(); options.DockerDialerFactory != nil || options.K8sWrapTransport != nil {
+		t.Errorf("want the default dialers of rke without bastion hosts of nodes")
+	}
+
+	private, _, err := sshutil.MakeSSHKeyPairByType(sshutil.KeyTypeED25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialer := &nodeDialer{rkeConfig: rkeConfig, bastions: map[string]v3.BastionHost{
+		"192.168.0.1": {Address: "10.0.0.1", Port: "22", User: "root", SSHKey: private},
+	}}
+	options := dialer.dialersOptions()
+	if options.DockerDialerFactory == nil || options.LocalConnDialerFactory == nil {
+		t.Fatalf("want the dialer factories set")
+	}
+	if options.K8sWrapTransport == nil {
+		t.Errorf("want the kube api requested through the bastion host of control plane node")
+	}
+
+	var got []string
+	factory := dialer.withBastions(func(h *hosts.Host) (func(network, address string) (net.Conn, error), error) {
+		got = append(got, h.BastionHost.Address)
+		return nil, nil
+	})
+	for _, node := range rkeConfig.Nodes {
+		if _, err := factory(&hosts.Host{RKEConfigNode: node}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(got) != 2 || got[0] != "10.0.0.1" || got[1] != "" {
+		t.Errorf("want only node 192.168.0.1 connected through bastion host, got %v", got)
+	}
+
+	rkeConfig.BastionHost = v3.BastionHost{Address: "10.0.0.3"}
+	if options := dialer.dialersOptions(); options.K8sWrapTransport != nil {
+		t.Errorf("want rke to request the kube api through the bastion host of cluster")
+	}
+}
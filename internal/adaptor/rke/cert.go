@@ -21,9 +21,12 @@ package rke
 import (
 	"context"
 	"fmt"
+	"path"
+	"sort"
 	"time"
 
 	"github.com/rancher/rke/cluster"
+	"github.com/rancher/rke/cmd"
 	"github.com/rancher/rke/hosts"
 	"github.com/rancher/rke/log"
 	"github.com/rancher/rke/pki"
@@ -31,8 +34,100 @@ import (
 	"github.com/rancher/rke/services"
 	v3 "github.com/rancher/rke/types"
 	"github.com/sirupsen/logrus"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
+	"github.com/wutong-paas/cloud-adaptor/pkg/util/retryutil"
 )
 
+// GetCertificates get the certificates of cluster from the state file
+func (r *rkeAdaptor) GetCertificates(clusterID string) ([]*v1alpha1.CertificateInfo, error) {
+	rkecluster, err := r.Repo.GetCluster(clusterID)
+	if err != nil {
+		return nil, fmt.Errorf("get cluster meta info failure %s", err.Error())
+	}
+	if err := r.restoreState(rkecluster.Name); err != nil {
+		return nil, fmt.Errorf("restore cluster %s state failure %s", rkecluster.Name, err.Error())
+	}
+	statePath := path.Join(getClusterStatPath(rkecluster.Name), "cluster.rkestate")
+	clusterState, err := cluster.ReadStateFile(context.Background(), statePath)
+	if err != nil {
+		return nil, err
+	}
+	bundle := clusterState.CurrentState.CertificatesBundle
+	if len(bundle) == 0 {
+		// the cluster has not been up successfully
+		bundle = clusterState.DesiredState.CertificatesBundle
+	}
+	return certificateInfos(bundle), nil
+}
+
+// RotateCertificates rotate the certificates of cluster, the admin kube config is refreshed after rotated.
+func (r *rkeAdaptor) RotateCertificates(ctx context.Context, config *v1alpha1.RotateCertificates, rollback func(step domain.TaskStep, message, status string)) *v1alpha1.Cluster {
+	rollback(domain.TaskStepInitClusterConfig, "", "start")
+	rkecluster, rkeConfig, filePath, err := r.loadClusterConfig(config.ClusterID)
+	if err != nil {
+		logrus.Errorf("load cluster %s config failure %s", config.ClusterID, err.Error())
+		rollback(domain.TaskStepInitClusterConfig, err.Error(), "failure")
+		return nil
+	}
+	defer r.releaseSSHKey(rkecluster.Name)
+	dialersOptions := r.dialer(rkecluster, rkeConfig).dialersOptions()
+	ctx, closeLog := openClusterLogger(ctx, path.Dir(filePath), "cert.log")
+	defer closeLog()
+
+	// the rotate config is only kept in the state file, the next cluster up will not rotate again
+	rkeConfig.RotateCertificates = &v3.RotateCertificates{
+		CACertificates: config.CACertificates,
+		Services:       config.Services,
+	}
+	flags := cluster.GetExternalFlags(false, false, false, false, "", filePath)
+	if err := cmd.ClusterInit(ctx, rkeConfig, dialersOptions, flags); err != nil {
+		rollback(domain.TaskStepInitClusterConfig, err.Error(), "failure")
+		return nil
+	}
+	rollback(domain.TaskStepInitClusterConfig, "", "success")
+
+	if cancelled(ctx) {
+		return nil
+	}
+	rollback(domain.TaskStepRotateCertificates, "", "start")
+	APIURL, _, _, _, certs, err := r.ClusterUp(ctx, dialersOptions, flags, map[string]interface{}{})
+	if err != nil {
+		rollback(domain.TaskStepRotateCertificates, err.Error(), "failure")
+		return nil
+	}
+	if kubeConfig := certs[pki.KubeAdminCertName].Config; kubeConfig != "" {
+		rkecluster.KubeConfig = kubeConfig
+	}
+	rkecluster.APIURL = APIURL
+	if err := r.Repo.Update(rkecluster); err != nil {
+		logrus.Errorf("update rke cluster %s kube config failure %s", rkecluster.Name, err.Error())
+	}
+	rollback(domain.TaskStepRotateCertificates, "", "success")
+	clu, _ := r.DescribeCluster(rkecluster.ClusterID)
+	return clu
+}
+
+func certificateInfos(bundle map[string]pki.CertificatePKI) []*v1alpha1.CertificateInfo {
+	var certs []*v1alpha1.CertificateInfo
+	for name, certPKI := range bundle {
+		// the service account token only has the key, TransformPEMToObject fills it with another certificate
+		if certPKI.CertificatePEM == "" || certPKI.Certificate == nil {
+			continue
+		}
+		certs = append(certs, &v1alpha1.CertificateInfo{
+			Name:       name,
+			CommonName: certPKI.Certificate.Subject.CommonName,
+			NotBefore:  certPKI.Certificate.NotBefore,
+			NotAfter:   certPKI.Certificate.NotAfter,
+		})
+	}
+	sort.Slice(certs, func(i, j int) bool {
+		return certs[i].Name < certs[j].Name
+	})
+	return certs
+}
+
 func rebuildClusterWithRotatedCertificates(ctx context.Context,
 	dialersOptions hosts.DialersOptions,
 	flags cluster.ExternalFlags, svcOptionData map[string]*v3.KubernetesServicesOptions) (string, string, string, string, map[string]pki.CertificatePKI, error) {
@@ -112,21 +207,18 @@ func rebuildClusterWithRotatedCertificates(ctx context.Context,
 	return APIURL, caCrt, clientCert, clientKey, kubeCluster.Certificates, nil
 }
 
+// saveStatePolicy the policy of saving the full state of cluster to kubernetes, the api server may be restarting
+var saveStatePolicy = retryutil.Policy{MaxAttempts: 3, InitialInterval: 2 * time.Second, MaxInterval: 8 * time.Second, Multiplier: 2,
+	Jitter: 0.2, Retryable: retryutil.Always}
+
 func saveClusterState(ctx context.Context, kubeCluster *cluster.Cluster, clusterState *cluster.FullState) error {
-	var err error
-	if err = kubeCluster.UpdateClusterCurrentState(ctx, clusterState); err != nil {
+	if err := kubeCluster.UpdateClusterCurrentState(ctx, clusterState); err != nil {
 		return err
 	}
 	// Attempt to store cluster full state to Kubernetes
-	for i := 1; i <= 3; i++ {
-		err = cluster.SaveFullStateToKubernetes(ctx, kubeCluster, clusterState)
-		if err != nil {
-			time.Sleep(time.Second * time.Duration(2))
-			continue
-		}
-		break
-	}
-	if err != nil {
+	if err := saveStatePolicy.Do(ctx, nil, func() error {
+		return cluster.SaveFullStateToKubernetes(ctx, kubeCluster, clusterState)
+	}); err != nil {
 		logrus.Warnf("Failed to save full cluster state to Kubernetes")
 	}
 	return nil
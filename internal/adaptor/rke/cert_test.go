@@ -0,0 +1,56 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package rke
+
+import (
+	"testing"
+
+	"github.com/rancher/rke/pki"
+	"github.com/rancher/rke/pki/cert"
+)
+
+func TestCertificateInfos(t *testing.T) {
+	key, err := cert.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := cert.NewSelfSignedCACert(cert.Config{CommonName: "kube-ca"}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := pki.TransformPEMToObject(map[string]pki.CertificatePKI{
+		pki.CACertName: {
+			CertificatePEM: string(cert.EncodeCertPEM(caCert)),
+			KeyPEM:         string(cert.EncodePrivateKeyPEM(key)),
+		},
+		pki.ServiceAccountTokenKeyName: {
+			KeyPEM: string(cert.EncodePrivateKeyPEM(key)),
+		},
+	})
+	certs := certificateInfos(bundle)
+	if len(certs) != 1 {
+		t.Fatalf("expected 1 certificate, but got %d", len(certs))
+	}
+	if certs[0].Name != pki.CACertName || certs[0].CommonName != "kube-ca" {
+		t.Errorf("unexpected certificate %s, common name %s", certs[0].Name, certs[0].CommonName)
+	}
+	if !certs[0].NotAfter.Equal(caCert.NotAfter) {
+		t.Errorf("unexpected expiration %v", certs[0].NotAfter)
+	}
+}
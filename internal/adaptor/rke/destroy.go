@@ -0,0 +1,96 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package rke
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/rancher/rke/cluster"
+	"github.com/rancher/rke/cmd"
+	"github.com/sirupsen/logrus"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
+)
+
+// DestroyCluster remove the kubernetes components from all nodes of the cluster, then delete the local state and the cluster record.
+func (r *rkeAdaptor) DestroyCluster(ctx context.Context, config *v1alpha1.DestroyCluster, rollback func(step domain.TaskStep, message, status string)) {
+	rollback(domain.TaskStepInitClusterConfig, "", "start")
+	rkecluster, err := r.Repo.GetCluster(config.ClusterID)
+	if err != nil {
+		rollback(domain.TaskStepInitClusterConfig, fmt.Sprintf("get cluster meta info failure %s", err.Error()), "failure")
+		return
+	}
+	if err := r.restoreState(rkecluster.Name); err != nil {
+		logrus.Warningf("restore cluster %s state failure %s", rkecluster.Name, err.Error())
+	}
+	clusterStatPath := getClusterStatPath(rkecluster.Name)
+	filePath := path.Join(clusterStatPath, "cluster.yml")
+	// the state file is not required, the hosts to be cleaned come from the rke config only.
+	rkeConfig, err := readRKEConfig(filePath)
+	if err != nil {
+		logrus.Errorf("load cluster %s config failure %s", rkecluster.Name, err.Error())
+		rollback(domain.TaskStepInitClusterConfig, err.Error(), "failure")
+		return
+	}
+	if err := r.useSSHKey(rkecluster.ClusterID, rkeConfig); err != nil {
+		rollback(domain.TaskStepInitClusterConfig, err.Error(), "failure")
+		return
+	}
+	if len(rkeConfig.Nodes) == 0 {
+		rollback(domain.TaskStepInitClusterConfig, fmt.Sprintf("there are no nodes in the config of cluster %s", rkecluster.Name), "failure")
+		return
+	}
+	rollback(domain.TaskStepInitClusterConfig, "", "success")
+
+	rollback(domain.TaskStepDestroyCluster, fmt.Sprintf("removing kubernetes from %d nodes", len(rkeConfig.Nodes)), "start")
+	stats := rkecluster.Stats
+	rkecluster.Stats = v1alpha1.DestroyingState
+	if err := r.Repo.Update(rkecluster); err != nil {
+		logrus.Errorf("update rke cluster %s state failure %s", rkecluster.Name, err.Error())
+	}
+	logCtx, closeLog := openClusterLogger(ctx, clusterStatPath, "destroy.log")
+	flags := cluster.GetExternalFlags(false, false, false, false, "", filePath)
+	err = cmd.ClusterRemove(logCtx, rkeConfig, r.dialer(rkecluster, rkeConfig).dialersOptions(), flags)
+	closeLog()
+	if err != nil {
+		rkecluster.Stats = stats
+		if err := r.Repo.Update(rkecluster); err != nil {
+			logrus.Errorf("update rke cluster %s state failure %s", rkecluster.Name, err.Error())
+		}
+		rollback(domain.TaskStepDestroyCluster, err.Error(), "failure")
+		return
+	}
+	if err := r.deleteStates(rkecluster.Name); err != nil {
+		logrus.Warningf("delete stored states of cluster %s failure %s", rkecluster.Name, err.Error())
+	}
+	if err := os.RemoveAll(clusterStatPath); err != nil {
+		logrus.Warningf("remove state dir %s of cluster %s failure %s", clusterStatPath, rkecluster.Name, err.Error())
+	}
+	if err := r.Repo.DeleteCluster(rkecluster.ClusterID); err != nil {
+		rollback(domain.TaskStepDestroyCluster, fmt.Sprintf("nodes are cleaned, but delete cluster record failure %s", err.Error()), "failure")
+		return
+	}
+	if err := r.KeyRepo.DeleteKeys(rkecluster.ClusterID); err != nil {
+		logrus.Warningf("delete ssh keys of cluster %s failure %s", rkecluster.Name, err.Error())
+	}
+	rollback(domain.TaskStepDestroyCluster, "", "success")
+}
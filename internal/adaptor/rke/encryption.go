@@ -21,15 +21,172 @@ package rke
 import (
 	"context"
 	"fmt"
+	"path"
 
 	"github.com/rancher/rke/cluster"
+	"github.com/rancher/rke/cmd"
 	"github.com/rancher/rke/hosts"
 	"github.com/rancher/rke/log"
 	"github.com/rancher/rke/pki"
 	"github.com/rancher/rke/pki/cert"
 	v3 "github.com/rancher/rke/types"
+	"github.com/sirupsen/logrus"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
+	apiserverconfigv1 "k8s.io/apiserver/pkg/apis/config/v1"
+	"sigs.k8s.io/yaml"
 )
 
+// GetSecretsEncryptionState get the secrets encryption state of cluster from the state file
+func (r *rkeAdaptor) GetSecretsEncryptionState(clusterID string) (*v1alpha1.SecretsEncryptionState, error) {
+	rkecluster, err := r.Repo.GetCluster(clusterID)
+	if err != nil {
+		return nil, fmt.Errorf("get cluster meta info failure %s", err.Error())
+	}
+	if err := r.restoreState(rkecluster.Name); err != nil {
+		return nil, fmt.Errorf("restore cluster %s state failure %s", rkecluster.Name, err.Error())
+	}
+	statePath := path.Join(getClusterStatPath(rkecluster.Name), "cluster.rkestate")
+	clusterState, err := cluster.ReadStateFile(context.Background(), statePath)
+	if err != nil {
+		return nil, err
+	}
+	rkeConfig := clusterState.CurrentState.RancherKubernetesEngineConfig
+	if rkeConfig == nil {
+		rkeConfig = clusterState.DesiredState.RancherKubernetesEngineConfig
+	}
+	state := &v1alpha1.SecretsEncryptionState{
+		Applied: clusterState.CurrentState.EncryptionConfig == clusterState.DesiredState.EncryptionConfig,
+	}
+	if rkeConfig != nil && rkeConfig.Services.KubeAPI.SecretsEncryptionConfig != nil {
+		state.Enabled = rkeConfig.Services.KubeAPI.SecretsEncryptionConfig.Enabled
+		state.CustomConfig = rkeConfig.Services.KubeAPI.SecretsEncryptionConfig.CustomConfig != nil
+	}
+	encryptionConfig := clusterState.CurrentState.EncryptionConfig
+	if encryptionConfig == "" {
+		encryptionConfig = clusterState.DesiredState.EncryptionConfig
+	}
+	if state.Enabled && encryptionConfig != "" {
+		state.Providers, state.KeyNames, err = parseEncryptionProviders(encryptionConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// UpdateSecretsEncryption enable the secrets encryption at rest, or rotate the encryption key.
+func (r *rkeAdaptor) UpdateSecretsEncryption(ctx context.Context, config *v1alpha1.SecretsEncryption, rollback func(step domain.TaskStep, message, status string)) *v1alpha1.Cluster {
+	rollback(domain.TaskStepInitClusterConfig, "", "start")
+	rkecluster, rkeConfig, filePath, err := r.loadClusterConfig(config.ClusterID)
+	if err != nil {
+		logrus.Errorf("load cluster %s config failure %s", config.ClusterID, err.Error())
+		rollback(domain.TaskStepInitClusterConfig, err.Error(), "failure")
+		return nil
+	}
+	defer r.releaseSSHKey(rkecluster.Name)
+	dialersOptions := r.dialer(rkecluster, rkeConfig).dialersOptions()
+	ctx, closeLog := openClusterLogger(ctx, path.Dir(filePath), "encryption.log")
+	defer closeLog()
+	flags := cluster.GetExternalFlags(false, false, false, false, "", filePath)
+
+	switch config.Action {
+	case v1alpha1.SecretsEncryptionEnable:
+		// the encryption config is kept in the config file, the next cluster up will not disable it
+		rkeConfig.Services.KubeAPI.SecretsEncryptionConfig = &v3.SecretsEncryptionConfig{Enabled: true}
+		if err := writeRKEConfig(filePath, rkeConfig); err != nil {
+			logrus.Errorf("write cluster %s config failure %s", rkecluster.Name, err.Error())
+			rollback(domain.TaskStepInitClusterConfig, err.Error(), "failure")
+			return nil
+		}
+		if err := cmd.ClusterInit(ctx, rkeConfig, dialersOptions, flags); err != nil {
+			rollback(domain.TaskStepInitClusterConfig, err.Error(), "failure")
+			return nil
+		}
+		rollback(domain.TaskStepInitClusterConfig, "", "success")
+
+		if cancelled(ctx) {
+			return nil
+		}
+		rollback(domain.TaskStepEnableSecretsEncryption, "", "start")
+		APIURL, _, _, _, configs, err := r.ClusterUp(ctx, dialersOptions, flags, map[string]interface{}{})
+		if err != nil {
+			rollback(domain.TaskStepEnableSecretsEncryption, err.Error(), "failure")
+			return nil
+		}
+		rkecluster.KubeConfig = configs[pki.KubeAdminCertName].Config
+		rkecluster.APIURL = APIURL
+		if err := r.Repo.Update(rkecluster); err != nil {
+			logrus.Errorf("update rke cluster %s kube config failure %s", rkecluster.Name, err.Error())
+		}
+		rollback(domain.TaskStepEnableSecretsEncryption, "", "success")
+	case v1alpha1.SecretsEncryptionRotateKey:
+		rollback(domain.TaskStepInitClusterConfig, "", "success")
+
+		if cancelled(ctx) {
+			return nil
+		}
+		rollback(domain.TaskStepRotateEncryptionKey, "", "start")
+		if _, _, _, _, _, err := RotateEncryptionKey(ctx, rkeConfig, dialersOptions, flags); err != nil {
+			rollback(domain.TaskStepRotateEncryptionKey, err.Error(), "failure")
+			return nil
+		}
+		rollback(domain.TaskStepRotateEncryptionKey, "", "success")
+	default:
+		rollback(domain.TaskStepInitClusterConfig, fmt.Sprintf("unknown secrets encryption action %s", config.Action), "failure")
+		return nil
+	}
+	clu, _ := r.DescribeCluster(rkecluster.ClusterID)
+	return clu
+}
+
+// parseEncryptionProviders returns the providers for secrets and the key names of the first provider.
+func parseEncryptionProviders(encryptionConfig string) ([]string, []string, error) {
+	var config apiserverconfigv1.EncryptionConfiguration
+	if err := yaml.Unmarshal([]byte(encryptionConfig), &config); err != nil {
+		return nil, nil, fmt.Errorf("parse encryption provider config failure %s", err.Error())
+	}
+	var providers, keyNames []string
+	for _, resource := range config.Resources {
+		if !containsString(resource.Resources, "secrets") {
+			continue
+		}
+		for i, provider := range resource.Providers {
+			var name string
+			var keys []apiserverconfigv1.Key
+			switch {
+			case provider.AESCBC != nil:
+				name, keys = "aescbc", provider.AESCBC.Keys
+			case provider.AESGCM != nil:
+				name, keys = "aesgcm", provider.AESGCM.Keys
+			case provider.Secretbox != nil:
+				name, keys = "secretbox", provider.Secretbox.Keys
+			case provider.KMS != nil:
+				name = "kms"
+			case provider.Identity != nil:
+				name = "identity"
+			}
+			providers = append(providers, name)
+			if i == 0 {
+				for _, key := range keys {
+					keyNames = append(keyNames, key.Name)
+				}
+			}
+		}
+		break
+	}
+	return providers, keyNames, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
 //RotateEncryptionKey -
 func RotateEncryptionKey(
 	ctx context.Context,
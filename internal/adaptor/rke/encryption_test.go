@@ -0,0 +1,55 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package rke
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseEncryptionProviders(t *testing.T) {
+	encryptionConfig := `apiVersion: apiserver.config.k8s.io/v1
+kind: EncryptionConfiguration
+resources:
+- resources:
+  - secrets
+  providers:
+  - aescbc:
+      keys:
+      - name: key-new
+        secret: c2VjcmV0LW5ldw==
+      - name: key-old
+        secret: c2VjcmV0LW9sZA==
+  - identity: {}
+`
+	providers, keyNames, err := parseEncryptionProviders(encryptionConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(providers, []string{"aescbc", "identity"}) {
+		t.Errorf("unexpected providers %v", providers)
+	}
+	if !reflect.DeepEqual(keyNames, []string{"key-new", "key-old"}) {
+		t.Errorf("unexpected key names %v", keyNames)
+	}
+
+	if _, _, err := parseEncryptionProviders("resources: invalid"); err == nil {
+		t.Errorf("expected error for the invalid config")
+	}
+}
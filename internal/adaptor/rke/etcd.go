@@ -0,0 +1,289 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package rke
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/rancher/rke/cluster"
+	"github.com/rancher/rke/cmd"
+	"github.com/rancher/rke/docker"
+	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/log"
+	"github.com/rancher/rke/pki"
+	"github.com/rancher/rke/services"
+	v3 "github.com/rancher/rke/types"
+	"github.com/sirupsen/logrus"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
+)
+
+const etcdSnapshotListContainerName = "wutong-etcd-snapshot-list"
+
+// SaveEtcdSnapshot save an etcd snapshot on all etcd hosts
+func (r *rkeAdaptor) SaveEtcdSnapshot(ctx context.Context, config *v1alpha1.EtcdSnapshot, rollback func(step domain.TaskStep, message, status string)) {
+	rollback(domain.TaskStepSaveEtcdSnapshot, "", "start")
+	rkecluster, rkeConfig, filePath, err := r.loadClusterConfig(config.ClusterID)
+	if err != nil {
+		logrus.Errorf("load cluster %s config failure %s", config.ClusterID, err.Error())
+		rollback(domain.TaskStepSaveEtcdSnapshot, err.Error(), "failure")
+		return
+	}
+	dialersOptions := r.dialer(rkecluster, rkeConfig).dialersOptions()
+	ctx, closeLog := openClusterLogger(ctx, path.Dir(filePath), "etcd.log")
+	defer closeLog()
+
+	name := config.Name
+	if name == "" {
+		name = fmt.Sprintf("%s-%s", rkecluster.Name, time.Now().Format("20060102150405"))
+	}
+	flags := cluster.GetExternalFlags(false, false, false, false, "", filePath)
+	if err := cmd.SnapshotSaveEtcdHosts(ctx, rkeConfig, dialersOptions, flags, name); err != nil {
+		rollback(domain.TaskStepSaveEtcdSnapshot, err.Error(), "failure")
+		return
+	}
+	rollback(domain.TaskStepSaveEtcdSnapshot, name, "success")
+}
+
+// RestoreEtcdSnapshot restore the cluster from the named snapshot, the cluster is rebuilt by ClusterUp after etcd restored.
+func (r *rkeAdaptor) RestoreEtcdSnapshot(ctx context.Context, config *v1alpha1.EtcdSnapshot, rollback func(step domain.TaskStep, message, status string)) *v1alpha1.Cluster {
+	rollback(domain.TaskStepInitClusterConfig, "", "start")
+	if config.Name == "" {
+		rollback(domain.TaskStepInitClusterConfig, "the snapshot name is required", "failure")
+		return nil
+	}
+	rkecluster, rkeConfig, filePath, err := r.loadClusterConfig(config.ClusterID)
+	if err != nil {
+		logrus.Errorf("load cluster %s config failure %s", config.ClusterID, err.Error())
+		rollback(domain.TaskStepInitClusterConfig, err.Error(), "failure")
+		return nil
+	}
+	defer r.releaseSSHKey(rkecluster.Name)
+	dialersOptions := r.dialer(rkecluster, rkeConfig).dialersOptions()
+	ctx, closeLog := openClusterLogger(ctx, path.Dir(filePath), "etcd.log")
+	defer closeLog()
+	flags := cluster.GetExternalFlags(false, false, false, false, "", filePath)
+	rollback(domain.TaskStepInitClusterConfig, "", "success")
+
+	rollback(domain.TaskStepRestoreEtcdSnapshot, config.Name, "start")
+	APIURL, configs, err := r.restoreEtcdSnapshot(ctx, rkeConfig, dialersOptions, flags, strings.TrimSuffix(config.Name, ".zip"))
+	if err != nil {
+		rollback(domain.TaskStepRestoreEtcdSnapshot, err.Error(), "failure")
+		return nil
+	}
+	rkecluster.KubeConfig = configs[pki.KubeAdminCertName].Config
+	rkecluster.APIURL = APIURL
+	rkecluster.Stats = v1alpha1.RunningState
+	if err := r.Repo.Update(rkecluster); err != nil {
+		logrus.Errorf("update rke cluster %s state failure %s", rkecluster.Name, err.Error())
+	}
+	rollback(domain.TaskStepRestoreEtcdSnapshot, "", "success")
+	clu, _ := r.DescribeCluster(rkecluster.ClusterID)
+	return clu
+}
+
+// restoreEtcdSnapshot is the same as the rke etcd snapshot-restore command, except the cluster is up by rkeAdaptor.ClusterUp.
+func (r *rkeAdaptor) restoreEtcdSnapshot(ctx context.Context, rkeConfig *v3.RancherKubernetesEngineConfig, dialersOptions hosts.DialersOptions,
+	flags cluster.ExternalFlags, snapshotName string) (string, map[string]pki.CertificatePKI, error) {
+	stateFilePath := cluster.GetStateFilePath(flags.ClusterFilePath, flags.ConfigDir)
+
+	// the state file in the snapshot is preferred, fallback to the local state file
+	tempCluster, err := cluster.InitClusterObject(ctx, rkeConfig, flags, "")
+	if err != nil {
+		return "", nil, err
+	}
+	if err := tempCluster.SetupDialers(ctx, dialersOptions); err != nil {
+		return "", nil, err
+	}
+	if err := tempCluster.TunnelHosts(ctx, flags); err != nil {
+		return "", nil, err
+	}
+	var rkeFullState *cluster.FullState
+	stateFile, err := tempCluster.GetStateFileFromSnapshot(ctx, snapshotName)
+	if err == nil {
+		rkeFullState, err = cluster.StringToFullState(ctx, stateFile)
+		if err != nil {
+			return "", nil, fmt.Errorf("convert the state file in snapshot %s failure %s", snapshotName, err.Error())
+		}
+	} else {
+		logrus.Infof("could not extract state file from snapshot %s, falling back to local state file: %v", snapshotName, err)
+		rkeFullState, err = cluster.ReadStateFile(ctx, stateFilePath)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	log.Infof(ctx, "Restoring etcd snapshot %s", snapshotName)
+
+	kubeCluster, err := cluster.InitClusterObject(ctx, rkeConfig, flags, rkeFullState.DesiredState.EncryptionConfig)
+	if err != nil {
+		return "", nil, err
+	}
+	rkeFullState.CurrentState = cluster.State{}
+	if err := rkeFullState.WriteStateFile(ctx, stateFilePath); err != nil {
+		return "", nil, err
+	}
+	if err := kubeCluster.SetupDialers(ctx, dialersOptions); err != nil {
+		return "", nil, err
+	}
+	if err := kubeCluster.TunnelHosts(ctx, flags); err != nil {
+		return "", nil, err
+	}
+	// if we fail after cleanup, we can't find the certs to do the download, we need to redeploy them
+	if err := kubeCluster.DeployRestoreCerts(ctx, rkeFullState.DesiredState.CertificatesBundle); err != nil {
+		return "", nil, err
+	}
+	// first download and check
+	if err := kubeCluster.PrepareBackup(ctx, snapshotName); err != nil {
+		return "", nil, err
+	}
+	log.Infof(ctx, "Cleaning old kubernetes cluster")
+	if err := kubeCluster.CleanupNodes(ctx); err != nil {
+		return "", nil, err
+	}
+	if err := kubeCluster.RestoreEtcdSnapshot(ctx, snapshotName); err != nil {
+		return "", nil, err
+	}
+
+	if err := cmd.ClusterInit(ctx, rkeConfig, dialersOptions, flags); err != nil {
+		return "", nil, err
+	}
+	APIURL, _, _, _, certs, err := r.ClusterUp(ctx, dialersOptions, flags, map[string]interface{}{})
+	if err != nil {
+		if !strings.Contains(err.Error(), "Provisioning incomplete") {
+			return "", nil, err
+		}
+		log.Warnf(ctx, err.Error())
+	}
+	if err := cluster.RestartClusterPods(ctx, kubeCluster); err != nil {
+		return "", nil, err
+	}
+	if err := kubeCluster.RemoveOldNodes(ctx); err != nil {
+		return "", nil, err
+	}
+	log.Infof(ctx, "Finished restoring snapshot [%s] on all etcd hosts", snapshotName)
+	return APIURL, certs, nil
+}
+
+// ListEtcdSnapshots list the snapshots on all etcd hosts, including the scheduled snapshots.
+func (r *rkeAdaptor) ListEtcdSnapshots(ctx context.Context, clusterID string) ([]*v1alpha1.EtcdSnapshotInfo, error) {
+	rkecluster, rkeConfig, filePath, err := r.loadClusterConfig(clusterID)
+	if err != nil {
+		return nil, err
+	}
+	dialersOptions := r.dialer(rkecluster, rkeConfig).dialersOptions()
+	ctx, closeLog := openClusterLogger(ctx, path.Dir(filePath), "etcd.log")
+	defer closeLog()
+
+	flags := cluster.GetExternalFlags(false, false, false, false, "", filePath)
+	kubeCluster, err := cluster.InitClusterObject(ctx, rkeConfig, flags, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := kubeCluster.SetupDialers(ctx, dialersOptions); err != nil {
+		return nil, err
+	}
+	if err := kubeCluster.TunnelHosts(ctx, flags); err != nil {
+		return nil, err
+	}
+
+	snapshots := make(map[string]*v1alpha1.EtcdSnapshotInfo)
+	for _, host := range kubeCluster.EtcdHosts {
+		list, err := listEtcdSnapshotsOnHost(ctx, host, kubeCluster.PrivateRegistriesMap, kubeCluster.SystemImages.Alpine)
+		if err != nil {
+			logrus.Warningf("list etcd snapshots on host %s failure %s", host.Address, err.Error())
+			continue
+		}
+		for _, snapshot := range list {
+			if exist, ok := snapshots[snapshot.Name]; ok {
+				exist.Hosts = append(exist.Hosts, host.Address)
+				continue
+			}
+			snapshot.Hosts = []string{host.Address}
+			snapshots[snapshot.Name] = snapshot
+		}
+	}
+	var re []*v1alpha1.EtcdSnapshotInfo
+	for _, snapshot := range snapshots {
+		re = append(re, snapshot)
+	}
+	sort.Slice(re, func(i, j int) bool {
+		return re[i].Created.After(re[j].Created)
+	})
+	return re, nil
+}
+
+func listEtcdSnapshotsOnHost(ctx context.Context, etcdHost *hosts.Host, prsMap map[string]v3.PrivateRegistry, alpineImage string) ([]*v1alpha1.EtcdSnapshotInfo, error) {
+	// remove the container left by the last listing
+	_ = docker.DoRemoveContainer(ctx, etcdHost.DClient, etcdSnapshotListContainerName, etcdHost.Address)
+	imageCfg := &container.Config{
+		Cmd: []string{
+			"sh", "-c", fmt.Sprintf("for f in %s*; do if [ -f \"$f\" ]; then stat -c '%%n %%s %%Y' \"$f\"; fi; done", services.EtcdSnapshotPath),
+		},
+		Image: alpineImage,
+	}
+	hostCfg := &container.HostConfig{
+		Binds: []string{
+			"/opt/rke/:/opt/rke/:z",
+		}}
+	if err := docker.DoRunContainer(ctx, etcdHost.DClient, imageCfg, hostCfg, etcdSnapshotListContainerName, etcdHost.Address, services.ETCDRole, prsMap); err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = docker.RemoveContainer(ctx, etcdHost.DClient, etcdHost.Address, etcdSnapshotListContainerName)
+	}()
+	if _, err := docker.WaitForContainer(ctx, etcdHost.DClient, etcdHost.Address, etcdSnapshotListContainerName); err != nil {
+		return nil, err
+	}
+	stderr, stdout, err := docker.GetContainerLogsStdoutStderr(ctx, etcdHost.DClient, etcdSnapshotListContainerName, "all", false)
+	if err != nil {
+		return nil, err
+	}
+	if stderr != "" {
+		return nil, fmt.Errorf("list snapshots failure: %s", stderr)
+	}
+	return parseEtcdSnapshots(stdout), nil
+}
+
+// parseEtcdSnapshots parse the output of stat, every line is `<path> <size> <modify unix time>`
+func parseEtcdSnapshots(out string) []*v1alpha1.EtcdSnapshotInfo {
+	var snapshots []*v1alpha1.EtcdSnapshotInfo
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		size, _ := strconv.ParseInt(fields[1], 10, 64)
+		created, _ := strconv.ParseInt(fields[2], 10, 64)
+		fileName := path.Base(fields[0])
+		snapshots = append(snapshots, &v1alpha1.EtcdSnapshotInfo{
+			Name:     strings.TrimSuffix(fileName, ".zip"),
+			FileName: fileName,
+			Size:     size,
+			Created:  time.Unix(created, 0),
+		})
+	}
+	return snapshots
+}
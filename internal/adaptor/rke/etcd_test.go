@@ -0,0 +1,41 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package rke
+
+import "testing"
+
+func TestParseEtcdSnapshots(t *testing.T) {
+	out := `/opt/rke/etcd-snapshots/demo-1620000000.zip 2097152 1620000000
+/opt/rke/etcd-snapshots/before-upgrade 1024 1620000100
+
+invalid line`
+	snapshots := parseEtcdSnapshots(out)
+	if len(snapshots) != 2 {
+		t.Fatalf("expected 2 snapshots, but got %d", len(snapshots))
+	}
+	if snapshots[0].Name != "demo-1620000000" || snapshots[0].FileName != "demo-1620000000.zip" {
+		t.Errorf("unexpected snapshot name %s, file name %s", snapshots[0].Name, snapshots[0].FileName)
+	}
+	if snapshots[0].Size != 2097152 || snapshots[0].Created.Unix() != 1620000000 {
+		t.Errorf("unexpected snapshot size %d, created %v", snapshots[0].Size, snapshots[0].Created)
+	}
+	if snapshots[1].Name != "before-upgrade" {
+		t.Errorf("unexpected snapshot name %s", snapshots[1].Name)
+	}
+}
@@ -0,0 +1,264 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package rke
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rancher/rke/cluster"
+	"github.com/rancher/rke/cmd"
+	"github.com/rancher/rke/hosts"
+	"github.com/rancher/rke/pki"
+	"github.com/rancher/rke/services"
+	v3 "github.com/rancher/rke/types"
+	"github.com/sirupsen/logrus"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/kubectl/pkg/drain"
+)
+
+// RemoveNodes drain the nodes, remove them from the cluster, then clean the rke containers and directories on them.
+func (r *rkeAdaptor) RemoveNodes(ctx context.Context, config *v1alpha1.RemoveNodes, rollback func(step domain.TaskStep, message, status string)) *v1alpha1.Cluster {
+	rollback(domain.TaskStepInitClusterConfig, "", "start")
+	rkecluster, rkeConfig, filePath, err := r.loadClusterConfig(config.ClusterID)
+	if err != nil {
+		logrus.Errorf("load cluster %s config failure %s", config.ClusterID, err.Error())
+		rollback(domain.TaskStepInitClusterConfig, err.Error(), "failure")
+		return nil
+	}
+	defer r.releaseSSHKey(rkecluster.Name)
+	dialersOptions := r.dialer(rkecluster, rkeConfig).dialersOptions()
+	ctx, closeLog := openClusterLogger(ctx, path.Dir(filePath), "node.log")
+	defer closeLog()
+
+	toRemove, remaining := splitNodes(rkeConfig.Nodes, config.Nodes)
+	if len(toRemove) != len(config.Nodes) {
+		rollback(domain.TaskStepInitClusterConfig, fmt.Sprintf("nodes %v are not all in the cluster", config.Nodes), "failure")
+		return nil
+	}
+	kubeClient, _, err := (&v1alpha1.KubeConfig{Config: rkecluster.KubeConfig}).GetKubeClient()
+	if err != nil {
+		rollback(domain.TaskStepInitClusterConfig, fmt.Sprintf("create kube client failure %s", err.Error()), "failure")
+		return nil
+	}
+	// the hosts to be cleaned are initialized with the current config, the ssh config of nodes are defaulted.
+	flags := cluster.GetExternalFlags(false, false, false, false, "", filePath)
+	currentCluster, err := cluster.InitClusterObject(ctx, rkeConfig.DeepCopy(), flags, "")
+	if err != nil {
+		rollback(domain.TaskStepInitClusterConfig, err.Error(), "failure")
+		return nil
+	}
+	rollback(domain.TaskStepInitClusterConfig, "", "success")
+
+	var drained []string
+	uncordon := func() {
+		for _, name := range drained {
+			if err := cordonNode(ctx, kubeClient, name, false); err != nil {
+				logrus.Warningf("uncordon node %s failure %s", name, err.Error())
+			}
+		}
+	}
+	for _, node := range toRemove {
+		step := domain.TaskStepDrainNode.ForNode(node.Address)
+		rollback(step, fmt.Sprintf("[%s] draining", node.Address), "start")
+		name := nodeName(node)
+		if err := drainNode(ctx, kubeClient, name, config.Drain); err != nil {
+			rollback(step, fmt.Sprintf("[%s] %s", node.Address, err.Error()), "failure")
+			uncordon()
+			return nil
+		}
+		drained = append(drained, name)
+		rollback(step, fmt.Sprintf("[%s] drained", node.Address), "success")
+	}
+
+	rollback(domain.TaskStepRemoveNodes, fmt.Sprintf("%v", config.Nodes), "start")
+	rkeConfig.Nodes = remaining
+	if err := writeRKEConfig(filePath, rkeConfig); err != nil {
+		logrus.Errorf("write cluster %s config failure %s", rkecluster.Name, err.Error())
+		rollback(domain.TaskStepRemoveNodes, err.Error(), "failure")
+		uncordon()
+		return nil
+	}
+	// the nodes are kept in the cluster if removing them failure
+	if err := cmd.ClusterInit(ctx, rkeConfig, dialersOptions, flags); err != nil {
+		rollback(domain.TaskStepRemoveNodes, err.Error(), "failure")
+		restoreRKEConfig(filePath)
+		uncordon()
+		return nil
+	}
+	// rke deletes the removed nodes from kubernetes and etcd members when the cluster up
+	APIURL, _, _, _, configs, err := r.ClusterUp(ctx, dialersOptions, flags, map[string]interface{}{})
+	if err != nil {
+		rollback(domain.TaskStepRemoveNodes, err.Error(), "failure")
+		restoreRKEConfig(filePath)
+		uncordon()
+		return nil
+	}
+	rkecluster.KubeConfig = configs[pki.KubeAdminCertName].Config
+	rkecluster.APIURL = APIURL
+	bastions := nodeBastions(rkecluster)
+	for _, address := range config.Nodes {
+		delete(bastions, address)
+	}
+	setNodeBastions(rkecluster, bastions)
+	if err := r.Repo.Update(rkecluster); err != nil {
+		logrus.Errorf("update rke cluster %s state failure %s", rkecluster.Name, err.Error())
+	}
+
+	var cleanFailed []string
+	for _, host := range removedHosts(currentCluster, config.Nodes) {
+		step := domain.TaskStepCleanNode.ForNode(host.Address)
+		rollback(step, fmt.Sprintf("[%s] cleaning", host.Address), "start")
+		if err := cleanHost(ctx, currentCluster, host, dialersOptions.DockerDialerFactory); err != nil {
+			cleanFailed = append(cleanFailed, host.Address)
+			rollback(step, fmt.Sprintf("[%s] %s", host.Address, err.Error()), "failure")
+			continue
+		}
+		rollback(step, fmt.Sprintf("[%s] cleaned", host.Address), "success")
+	}
+	if len(cleanFailed) > 0 {
+		rollback(domain.TaskStepRemoveNodes, fmt.Sprintf("nodes %v are removed, but cleaning %v failure", config.Nodes, cleanFailed), "failure")
+		return nil
+	}
+	rollback(domain.TaskStepRemoveNodes, "", "success")
+	clu, _ := r.DescribeCluster(rkecluster.ClusterID)
+	return clu
+}
+
+// splitNodes split the nodes into the nodes to be removed and the remaining nodes.
+func splitNodes(nodes []v3.RKEConfigNode, addresses []string) ([]v3.RKEConfigNode, []v3.RKEConfigNode) {
+	toRemove := make(map[string]bool, len(addresses))
+	for _, address := range addresses {
+		toRemove[address] = true
+	}
+	var removed, remaining []v3.RKEConfigNode
+	for _, node := range nodes {
+		if toRemove[node.Address] {
+			removed = append(removed, node)
+			continue
+		}
+		remaining = append(remaining, node)
+	}
+	return removed, remaining
+}
+
+// nodeName the kubernetes node name of rke node
+func nodeName(node v3.RKEConfigNode) string {
+	if node.HostnameOverride != "" {
+		return strings.ToLower(node.HostnameOverride)
+	}
+	return node.Address
+}
+
+func newDrainHelper(ctx context.Context, kubeClient kubernetes.Interface, input *v3.NodeDrainInput) *drain.Helper {
+	helper := &drain.Helper{
+		Ctx:                 ctx,
+		Client:              kubeClient,
+		IgnoreAllDaemonSets: true,
+		DeleteEmptyDirData:  true,
+		GracePeriodSeconds:  -1,
+		Timeout:             120 * time.Second,
+		Out:                 bytes.NewBuffer([]byte{}),
+		ErrOut:              bytes.NewBuffer([]byte{}),
+	}
+	if input != nil {
+		helper.Force = input.Force
+		if input.IgnoreDaemonSets != nil {
+			helper.IgnoreAllDaemonSets = *input.IgnoreDaemonSets
+		}
+		helper.DeleteEmptyDirData = input.DeleteLocalData
+		helper.GracePeriodSeconds = input.GracePeriod
+		if input.Timeout > 0 {
+			helper.Timeout = time.Duration(input.Timeout) * time.Second
+		}
+	}
+	return helper
+}
+
+func cordonNode(ctx context.Context, kubeClient kubernetes.Interface, name string, cordon bool) error {
+	node, err := kubeClient.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	return drain.RunCordonOrUncordon(newDrainHelper(ctx, kubeClient, nil), node, cordon)
+}
+
+// drainNode cordon and drain the node, the node not registered to kubernetes is skipped.
+func drainNode(ctx context.Context, kubeClient kubernetes.Interface, name string, input *v3.NodeDrainInput) error {
+	helper := newDrainHelper(ctx, kubeClient, input)
+	node, err := kubeClient.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if k8sErrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if err := drain.RunCordonOrUncordon(helper, node, true); err != nil {
+		return fmt.Errorf("cordon node failure %s", err.Error())
+	}
+	if err := drain.RunNodeDrain(helper, name); err != nil {
+		return fmt.Errorf("drain node failure %s", err.Error())
+	}
+	return nil
+}
+
+func removedHosts(kubeCluster *cluster.Cluster, addresses []string) []*hosts.Host {
+	var re []*hosts.Host
+	for _, host := range hosts.GetUniqueHostList(kubeCluster.EtcdHosts, kubeCluster.ControlPlaneHosts, kubeCluster.WorkerHosts) {
+		for _, address := range addresses {
+			if host.Address == address {
+				re = append(re, host)
+				break
+			}
+		}
+	}
+	return re
+}
+
+// cleanHost remove the rke containers and clean the rke directories on the host over ssh,
+// the host is connected with the ssh dialer of rke if the dialer factory is nil.
+func cleanHost(ctx context.Context, kubeCluster *cluster.Cluster, host *hosts.Host, dialerFactory hosts.DialerFactory) error {
+	if err := host.TunnelUp(ctx, dialerFactory, kubeCluster.PrefixPath, kubeCluster.Version); err != nil {
+		return fmt.Errorf("set up ssh tunnel failure %s", err.Error())
+	}
+	single := []*hosts.Host{host}
+	if err := services.RemoveWorkerPlane(ctx, single, true); err != nil {
+		return err
+	}
+	if host.IsControl {
+		if err := services.RemoveControlPlane(ctx, single, true); err != nil {
+			return err
+		}
+	}
+	externalEtcd := len(kubeCluster.Services.Etcd.ExternalURLs) > 0
+	if host.IsEtcd && !externalEtcd {
+		if err := services.RemoveEtcdPlane(ctx, single, true); err != nil {
+			return err
+		}
+	}
+	return host.CleanUpAll(ctx, kubeCluster.SystemImages.Alpine, kubeCluster.PrivateRegistriesMap, externalEtcd)
+}
@@ -0,0 +1,46 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package rke
+
+import (
+	"testing"
+
+	v3 "github.com/rancher/rke/types"
+)
+
+func TestSplitNodes(t *testing.T) {
+	nodes := []v3.RKEConfigNode{
+		{Address: "192.168.56.101", Role: []string{"controlplane", "etcd"}},
+		{Address: "192.168.56.102", Role: []string{"worker"}},
+		{Address: "192.168.56.103", Role: []string{"worker"}, HostnameOverride: "Node3"},
+	}
+	removed, remaining := splitNodes(nodes, []string{"192.168.56.103", "192.168.56.104"})
+	if len(removed) != 1 || removed[0].Address != "192.168.56.103" {
+		t.Fatalf("unexpected removed nodes %v", removed)
+	}
+	if len(remaining) != 2 {
+		t.Fatalf("expected 2 remaining nodes, but got %d", len(remaining))
+	}
+	if name := nodeName(removed[0]); name != "node3" {
+		t.Errorf("unexpected node name %s", name)
+	}
+	if name := nodeName(remaining[0]); name != "192.168.56.101" {
+		t.Errorf("unexpected node name %s", name)
+	}
+}
@@ -0,0 +1,390 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package rke
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rancher/rke/metadata"
+	v3 "github.com/rancher/rke/types"
+	"github.com/rancher/rke/util"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
+	sshutil "github.com/wutong-paas/cloud-adaptor/pkg/util/ssh"
+	"golang.org/x/crypto/ssh"
+	"k8s.io/client-go/util/homedir"
+)
+
+const (
+	defaultDockerSocket = "/var/run/docker.sock"
+	// minFreeDiskKB the free disk space required by the images and containers
+	minFreeDiskKB = 10 * 1024 * 1024
+	// maxClockSkew the max time difference between the node and the adaptor
+	maxClockSkew = 30 * time.Second
+	sshTimeout   = 10 * time.Second
+)
+
+// requiredKernelModules the kernel modules required by kubernetes and the network plugins,
+// see https://rancher.com/docs/rke/latest/en/os/#kernel-modules
+var requiredKernelModules = []string{
+	"br_netfilter", "ip6_udp_tunnel", "ip_set", "ip_set_hash_ip", "ip_set_hash_net",
+	"iptable_filter", "iptable_nat", "iptable_mangle", "iptable_raw", "nf_conntrack_netlink",
+	"nf_conntrack", "nf_defrag_ipv4", "nf_nat", "nfnetlink", "udp_tunnel", "veth", "vxlan",
+	"x_tables", "xt_addrtype", "xt_conntrack", "xt_comment", "xt_mark", "xt_multiport",
+	"xt_nat", "xt_recent", "xt_set", "xt_statistic", "xt_tcpudp",
+}
+
+// rolePorts the tcp ports listened by the kubernetes components of each role
+var rolePorts = map[string][]int{
+	"etcd":         {2379, 2380},
+	"controlplane": {6443},
+	"worker":       {80, 443},
+}
+
+// commandRunner run a shell command on the node and returns its stdout
+type commandRunner interface {
+	Run(cmd string) (string, error)
+}
+
+type sshRunner struct {
+	client *ssh.Client
+}
+
+func (s *sshRunner) Run(cmd string) (string, error) {
+	session, err := s.client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	out, err := session.CombinedOutput(cmd)
+	return strings.TrimSpace(string(out)), err
+}
+
+// Preflight check the nodes of the rke config over ssh, the nodes are checked in parallel.
+func (r *rkeAdaptor) Preflight(ctx context.Context, config *v1alpha1.Preflight) *v1alpha1.PreflightReport {
+	return runPreflight(ctx, config.RKEConfig, newNodeDialer(config.RKEConfig, config.NodeBastions).dial)
+}
+
+// preflight check the nodes as a step of the task, returns false if any check failed.
+func (r *rkeAdaptor) preflight(ctx context.Context, dialer *nodeDialer, rollback func(step domain.TaskStep, message, status string)) bool {
+	rollback(domain.TaskStepPreflight, "", "start")
+	report := runPreflight(ctx, dialer.rkeConfig, dialer.dial)
+	var failed, warnings []string
+	for _, node := range report.Nodes {
+		for _, check := range node.Checks {
+			message := fmt.Sprintf("[%s] %s: %s", node.Address, check.Name, check.Message)
+			switch check.Status {
+			case v1alpha1.PreflightFailed:
+				failed = append(failed, message)
+			case v1alpha1.PreflightWarning:
+				warnings = append(warnings, message)
+			}
+		}
+	}
+	if len(failed) > 0 {
+		rollback(domain.TaskStepPreflight, strings.Join(failed, "; "), "failure")
+		return false
+	}
+	rollback(domain.TaskStepPreflight, strings.Join(warnings, "; "), "success")
+	return true
+}
+
+func runPreflight(ctx context.Context, rkeConfig *v3.RancherKubernetesEngineConfig, dial func(node v3.RKEConfigNode) (commandRunner, func(), error)) *v1alpha1.PreflightReport {
+	dockerVersions := supportedDockerVersions(ctx, rkeConfig)
+	ignoreDockerVersion := rkeConfig.IgnoreDockerVersion != nil && *rkeConfig.IgnoreDockerVersion
+	reports := make([]*v1alpha1.NodePreflightReport, len(rkeConfig.Nodes))
+	var wg sync.WaitGroup
+	for i := range rkeConfig.Nodes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			node := rkeConfig.Nodes[i]
+			report := &v1alpha1.NodePreflightReport{Address: node.Address}
+			reports[i] = report
+			runner, closeFn, err := dial(node)
+			if err != nil {
+				report.Checks = append(report.Checks, &v1alpha1.PreflightCheck{Name: "ssh", Status: v1alpha1.PreflightFailed, Message: err.Error()})
+				return
+			}
+			defer closeFn()
+			report.Checks = append(report.Checks, &v1alpha1.PreflightCheck{Name: "ssh", Status: v1alpha1.PreflightPassed,
+				Message: fmt.Sprintf("connected as %s", nodeUser(node))})
+			checkNode(runner, node, ignoreDockerVersion, dockerVersions, report)
+		}(i)
+	}
+	wg.Wait()
+	checkHostnames(reports)
+
+	re := &v1alpha1.PreflightReport{Status: v1alpha1.PreflightPassed, Nodes: reports}
+	for _, report := range reports {
+		report.Status = reportStatus(report.Checks)
+		if report.Status == v1alpha1.PreflightFailed || re.Status == v1alpha1.PreflightPassed {
+			re.Status = report.Status
+		}
+	}
+	return re
+}
+
+func checkNode(runner commandRunner, node v3.RKEConfigNode, ignoreDockerVersion bool, dockerVersions []string, report *v1alpha1.NodePreflightReport) {
+	add := func(name, status, message string) {
+		report.Checks = append(report.Checks, &v1alpha1.PreflightCheck{Name: name, Status: status, Message: message})
+	}
+
+	// docker
+	socket := node.DockerSocket
+	if socket == "" {
+		socket = defaultDockerSocket
+	}
+	if _, err := runner.Run(fmt.Sprintf("test -S %s", socket)); err != nil {
+		add("docker", v1alpha1.PreflightFailed, fmt.Sprintf("docker socket %s not found", socket))
+	} else if version, err := runner.Run(fmt.Sprintf("docker -H unix://%s version --format '{{.Server.Version}}'", socket)); err != nil {
+		add("docker", v1alpha1.PreflightFailed, fmt.Sprintf("user %s can not access docker: %s", nodeUser(node), version))
+	} else if ok, err := isSupportedDockerVersion(version, dockerVersions); err != nil {
+		add("docker", v1alpha1.PreflightFailed, fmt.Sprintf("parse docker version %s failure %s", version, err.Error()))
+	} else if !ok && !ignoreDockerVersion {
+		add("docker", v1alpha1.PreflightFailed, fmt.Sprintf("unsupported docker version %s, supported versions are %v", version, dockerVersions))
+	} else {
+		add("docker", v1alpha1.PreflightPassed, fmt.Sprintf("docker version %s", version))
+	}
+
+	// kernel modules
+	script := fmt.Sprintf(`for m in %s; do [ -d /sys/module/$m ] || modinfo $m >/dev/null 2>&1 || /sbin/modinfo $m >/dev/null 2>&1 || grep -q "/$m.ko" /lib/modules/$(uname -r)/modules.builtin 2>/dev/null || echo $m; done`,
+		strings.Join(requiredKernelModules, " "))
+	if missing, err := runner.Run(script); err != nil {
+		add("kernel-modules", v1alpha1.PreflightWarning, fmt.Sprintf("check kernel modules failure %s", err.Error()))
+	} else if missing != "" {
+		add("kernel-modules", v1alpha1.PreflightFailed, fmt.Sprintf("kernel modules %s are missing", strings.Join(strings.Fields(missing), ",")))
+	} else {
+		add("kernel-modules", v1alpha1.PreflightPassed, "")
+	}
+
+	// swap
+	if swaps, err := runner.Run("tail -n +2 /proc/swaps"); err == nil && swaps != "" {
+		add("swap", v1alpha1.PreflightWarning, "swap is enabled, it is recommended to disable it")
+	} else {
+		add("swap", v1alpha1.PreflightPassed, "")
+	}
+
+	// ports, the ports are in use if kubernetes has been installed on the node
+	if _, err := runner.Run("test -e /etc/kubernetes/ssl/kube-ca.pem"); err == nil {
+		add("ports", v1alpha1.PreflightWarning, "kubernetes has been installed on the node, skip checking ports")
+	} else if listening, err := runner.Run("ss -Htln 2>/dev/null || netstat -tln 2>/dev/null"); err != nil {
+		add("ports", v1alpha1.PreflightWarning, fmt.Sprintf("list listening ports failure %s", err.Error()))
+	} else if inUse := portsInUse(listening, requiredPorts(node.Role)); len(inUse) > 0 {
+		add("ports", v1alpha1.PreflightFailed, fmt.Sprintf("ports %v are in use", inUse))
+	} else {
+		add("ports", v1alpha1.PreflightPassed, "")
+	}
+
+	// disk
+	if free, err := runner.Run("df -Pk /var/lib | tail -n 1 | awk '{print $4}'"); err != nil {
+		add("disk", v1alpha1.PreflightWarning, fmt.Sprintf("check free disk failure %s", err.Error()))
+	} else if kb, err := strconv.ParseInt(free, 10, 64); err != nil {
+		add("disk", v1alpha1.PreflightWarning, fmt.Sprintf("unknown free disk %s", free))
+	} else if kb < minFreeDiskKB {
+		add("disk", v1alpha1.PreflightFailed, fmt.Sprintf("free disk of /var/lib is %dMB, at least %dMB is required", kb/1024, minFreeDiskKB/1024))
+	} else {
+		add("disk", v1alpha1.PreflightPassed, fmt.Sprintf("free disk of /var/lib is %dMB", kb/1024))
+	}
+
+	// time
+	start := time.Now()
+	if out, err := runner.Run("date +%s"); err != nil {
+		add("time", v1alpha1.PreflightWarning, fmt.Sprintf("get node time failure %s", err.Error()))
+	} else if sec, err := strconv.ParseInt(out, 10, 64); err != nil {
+		add("time", v1alpha1.PreflightWarning, fmt.Sprintf("unknown node time %s", out))
+	} else if skew := clockSkew(time.Unix(sec, 0), start, time.Now()); skew > maxClockSkew {
+		add("time", v1alpha1.PreflightFailed, fmt.Sprintf("the node time differs by %s, the time should be synchronized", skew))
+	} else {
+		add("time", v1alpha1.PreflightPassed, "")
+	}
+
+	// hostname, the uniqueness is checked after all nodes are checked
+	hostname := strings.ToLower(node.HostnameOverride)
+	if hostname == "" {
+		out, err := runner.Run("hostname")
+		if err != nil {
+			add("hostname", v1alpha1.PreflightWarning, fmt.Sprintf("get hostname failure %s", err.Error()))
+			return
+		}
+		hostname = strings.ToLower(out)
+	}
+	report.Hostname = hostname
+}
+
+// checkHostnames the hostnames of nodes must be unique
+func checkHostnames(reports []*v1alpha1.NodePreflightReport) {
+	addresses := make(map[string][]string)
+	for _, report := range reports {
+		if report.Hostname != "" {
+			addresses[report.Hostname] = append(addresses[report.Hostname], report.Address)
+		}
+	}
+	for _, report := range reports {
+		if report.Hostname == "" {
+			continue
+		}
+		if same := addresses[report.Hostname]; len(same) > 1 {
+			report.Checks = append(report.Checks, &v1alpha1.PreflightCheck{Name: "hostname", Status: v1alpha1.PreflightFailed,
+				Message: fmt.Sprintf("hostname %s is used by nodes %v", report.Hostname, same)})
+			continue
+		}
+		report.Checks = append(report.Checks, &v1alpha1.PreflightCheck{Name: "hostname", Status: v1alpha1.PreflightPassed, Message: report.Hostname})
+	}
+}
+
+func reportStatus(checks []*v1alpha1.PreflightCheck) string {
+	status := v1alpha1.PreflightPassed
+	for _, check := range checks {
+		if check.Status == v1alpha1.PreflightFailed {
+			return v1alpha1.PreflightFailed
+		}
+		if check.Status == v1alpha1.PreflightWarning {
+			status = v1alpha1.PreflightWarning
+		}
+	}
+	return status
+}
+
+func requiredPorts(roles []string) []int {
+	ports := []int{10250}
+	for _, role := range roles {
+		ports = append(ports, rolePorts[role]...)
+	}
+	sort.Ints(ports)
+	return ports
+}
+
+// portsInUse returns the required ports in the listening addresses output by ss or netstat
+func portsInUse(listening string, ports []int) []int {
+	listened := make(map[int]bool)
+	for _, line := range strings.Split(listening, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		address := fields[3]
+		if port, err := strconv.Atoi(address[strings.LastIndex(address, ":")+1:]); err == nil {
+			listened[port] = true
+		}
+	}
+	var inUse []int
+	for _, port := range ports {
+		if listened[port] {
+			inUse = append(inUse, port)
+		}
+	}
+	return inUse
+}
+
+// clockSkew the difference between the node time and the adaptor time when the command was running
+func clockSkew(nodeTime, start, end time.Time) time.Duration {
+	skew := nodeTime.Sub(start.Add(end.Sub(start) / 2))
+	if skew < 0 {
+		skew = -skew
+	}
+	// the node time is accurate to the second
+	if skew < time.Second {
+		return 0
+	}
+	return skew.Truncate(time.Second)
+}
+
+// supportedDockerVersions the docker versions supported by the kubernetes version of rke config
+func supportedDockerVersions(ctx context.Context, rkeConfig *v3.RancherKubernetesEngineConfig) []string {
+	if rkeConfig.Version == "" {
+		return nil
+	}
+	semver, err := util.StrToSemVer(rkeConfig.Version)
+	if err != nil {
+		return nil
+	}
+	if len(metadata.K8sVersionToDockerVersions) == 0 {
+		if err := metadata.InitMetadata(ctx); err != nil {
+			return nil
+		}
+	}
+	return metadata.K8sVersionToDockerVersions[fmt.Sprintf("%d.%d", semver.Major, semver.Minor)]
+}
+
+// isSupportedDockerVersion compare the major and minor version, any docker version is supported if the supported versions are unknown
+func isSupportedDockerVersion(version string, supported []string) (bool, error) {
+	major, minor, err := majorMinor(version)
+	if err != nil {
+		return false, err
+	}
+	if len(supported) == 0 {
+		return true, nil
+	}
+	for _, s := range supported {
+		if smajor, sminor, err := majorMinor(s); err == nil && smajor == major && sminor == minor {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// majorMinor parse the docker version such as 19.03.15 and 20.10.x
+func majorMinor(version string) (int, int, error) {
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, fmt.Errorf("invalid version %s", version)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid version %s", version)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid version %s", version)
+	}
+	return major, minor, nil
+}
+
+func nodeUser(node v3.RKEConfigNode) string {
+	if node.User == "" {
+		return "root"
+	}
+	return node.User
+}
+
+func nodeSigner(node v3.RKEConfigNode) (ssh.Signer, error) {
+	if node.SSHKey != "" {
+		return ssh.ParsePrivateKey([]byte(node.SSHKey))
+	}
+	keyPath := strings.Replace(node.SSHKeyPath, "~", homedir.HomeDir(), 1)
+	if keyPath != "" && keyPath != sshutil.PrivateKeyPath() {
+		if _, err := os.Stat(keyPath); err == nil {
+			key, err := ioutil.ReadFile(keyPath)
+			if err != nil {
+				return nil, err
+			}
+			return ssh.ParsePrivateKey(key)
+		}
+	}
+	return sshutil.GetSigner()
+}
@@ -0,0 +1,127 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package rke
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	v3 "github.com/rancher/rke/types"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+)
+
+// fakeRunner returns the output of the first command prefix matched
+type fakeRunner map[string]string
+
+func (f fakeRunner) Run(cmd string) (string, error) {
+	for prefix, out := range f {
+		if strings.HasPrefix(cmd, prefix) {
+			if strings.HasPrefix(out, "error:") {
+				return strings.TrimPrefix(out, "error:"), fmt.Errorf("exit status 1")
+			}
+			return out, nil
+		}
+	}
+	return "", nil
+}
+
+func TestRunPreflight(t *testing.T) {
+	healthy := func(hostname string) fakeRunner {
+		return fakeRunner{
+			"test -S":    "",
+			"docker -H":  "19.03.15",
+			"for m in":   "",
+			"tail -n +2": "",
+			"test -e":    "error:",
+			"ss -Htln":   "LISTEN 0 128 0.0.0.0:22 0.0.0.0:*",
+			"df -Pk":     "52428800",
+			"date +%s":   strconv.FormatInt(time.Now().Unix(), 10),
+			"hostname":   hostname,
+		}
+	}
+	runners := map[string]fakeRunner{
+		"192.168.0.1": healthy("node1"),
+		"192.168.0.2": healthy("Node1"),
+		"192.168.0.3": {
+			"test -S":    "",
+			"docker -H":  "error:permission denied",
+			"for m in":   "br_netfilter\nvxlan",
+			"tail -n +2": "/dev/dm-1 partition 2097148 0 -2",
+			"test -e":    "error:",
+			"ss -Htln":   "LISTEN 0 128 *:6443 *:*\nLISTEN 0 128 [::]:10250 [::]:*",
+			"df -Pk":     "1024",
+			"date +%s":   strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10),
+			"hostname":   "node3",
+		},
+	}
+	rkeConfig := &v3.RancherKubernetesEngineConfig{
+		Nodes: []v3.RKEConfigNode{
+			{Address: "192.168.0.1", Role: []string{"etcd", "controlplane"}},
+			{Address: "192.168.0.2", Role: []string{"worker"}},
+			{Address: "192.168.0.3", Role: []string{"controlplane"}},
+			{Address: "192.168.0.4", Role: []string{"worker"}},
+		},
+	}
+	report := runPreflight(context.Background(), rkeConfig, func(node v3.RKEConfigNode) (commandRunner, func(), error) {
+		runner, ok := runners[node.Address]
+		if !ok {
+			return nil, nil, fmt.Errorf("connection refused")
+		}
+		return runner, func() {}, nil
+	})
+	if report.Status != v1alpha1.PreflightFailed {
+		t.Fatalf("expected failed report, but got %s", report.Status)
+	}
+	failed := func(i int) []string {
+		var names []string
+		for _, check := range report.Nodes[i].Failed() {
+			names = append(names, check.Name)
+		}
+		return names
+	}
+	if names := failed(0); strings.Join(names, ",") != "hostname" {
+		t.Errorf("unexpected failed checks of node1 %v", names)
+	}
+	if names := failed(2); strings.Join(names, ",") != "docker,kernel-modules,ports,disk,time" {
+		t.Errorf("unexpected failed checks of node3 %v", names)
+	}
+	if names := failed(3); strings.Join(names, ",") != "ssh" {
+		t.Errorf("unexpected failed checks of node4 %v", names)
+	}
+}
+
+func TestIsSupportedDockerVersion(t *testing.T) {
+	supported := []string{"1.13.x", "17.03.x", "18.06.x", "18.09.x", "19.03.x", "20.10.x"}
+	for version, want := range map[string]bool{"19.03.15": true, "20.10.7": true, "17.12.1-ce": false} {
+		ok, err := isSupportedDockerVersion(version, supported)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != want {
+			t.Errorf("docker version %s expected supported %v, but got %v", version, want, ok)
+		}
+	}
+	if _, err := isSupportedDockerVersion("unknown", nil); err == nil {
+		t.Errorf("expected error for the unknown version")
+	}
+}
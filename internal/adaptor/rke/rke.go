@@ -24,10 +24,12 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
 	"strings"
 	"sync"
 	"time"
 
+	sshutil "github.com/wutong-paas/cloud-adaptor/pkg/util/ssh"
 	"github.com/wutong-paas/cloud-adaptor/pkg/util/versionutil"
 
 	"github.com/wutong-paas/cloud-adaptor/internal/repo"
@@ -43,6 +45,7 @@ import (
 	"github.com/wutong-paas/cloud-adaptor/internal/adaptor"
 	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
 	"github.com/wutong-paas/cloud-adaptor/internal/datastore"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
 	"github.com/wutong-paas/cloud-adaptor/internal/model"
 	"github.com/wutong-paas/cloud-adaptor/pkg/bcode"
 	wutongv1alpha1 "github.com/wutong-paas/wutong-operator/api/v1alpha1"
@@ -53,12 +56,38 @@ import (
 
 type rkeAdaptor struct {
 	Repo repo.RKEClusterRepository
+	// StateStore keeps the versioned rke states of clusters
+	StateStore StateStore
+	// KeyRepo the ssh keys used to connect the nodes of clusters
+	KeyRepo repo.ClusterSSHKeyRepository
+}
+
+func init() {
+	adaptor.Register(&adaptor.Provider{
+		Name: "rke",
+		Capabilities: adaptor.Capabilities{
+			NeedCredentials: false,
+			CreateCluster:   true,
+			ExpansionNode:   true,
+			DeleteCluster:   true,
+			RKEConfig:       true,
+			CloudDB:         false,
+			CloudNAS:        false,
+			CloudLB:         false,
+		},
+		Adaptor: (*rkeAdaptor)(nil),
+		New: func(accessKeyID, accessKeySecret string) (adaptor.WutongClusterAdaptor, error) {
+			return Create()
+		},
+	})
 }
 
 // Create create ack adaptor
 func Create() (adaptor.WutongClusterAdaptor, error) {
 	return &rkeAdaptor{
-		Repo: repo.NewRKEClusterRepo(datastore.GetGDB()),
+		Repo:       repo.NewRKEClusterRepo(datastore.GetGDB()),
+		StateStore: getStateStore(),
+		KeyRepo:    repo.NewClusterSSHKeyRepo(datastore.GetGDB()),
 	}, nil
 }
 
@@ -93,13 +122,22 @@ func (r *rkeAdaptor) DeleteCluster(clusterID string) error {
 	if cluster != nil && cluster.WutongInit {
 		return bcode.ErrClusterNotAllowDelete
 	}
-	return r.Repo.DeleteCluster(clusterID)
+	if err := r.Repo.DeleteCluster(clusterID); err != nil {
+		return err
+	}
+	if cluster != nil {
+		if err := r.KeyRepo.DeleteKeys(cluster.ClusterID); err != nil {
+			logrus.Warningf("delete ssh keys of cluster %s failure %s", cluster.ClusterID, err.Error())
+		}
+	}
+	return nil
 }
 
 func (r *rkeAdaptor) GetWutongInitConfig(
+	ctx context.Context,
 	cluster *v1alpha1.Cluster,
 	gateway, chaos []*wutongv1alpha1.K8sNode,
-	rollback func(step, message, status string),
+	rollback func(step domain.TaskStep, message, status string),
 ) *v1alpha1.WutongInitConfig {
 	return &v1alpha1.WutongInitConfig{
 		EnableHA:     cluster.Size > 3,
@@ -128,22 +166,22 @@ func (r *rkeAdaptor) GetWutongInitConfig(
 	}
 }
 
-func (r *rkeAdaptor) CreateWutongKubernetes(ctx context.Context, config *v1alpha1.KubernetesClusterConfig, rollback func(step, message, status string)) *v1alpha1.Cluster {
-	rollback("InitClusterConfig", "", "start")
+func (r *rkeAdaptor) CreateWutongKubernetes(ctx context.Context, config *v1alpha1.KubernetesClusterConfig, rollback func(step domain.TaskStep, message, status string)) *v1alpha1.Cluster {
+	rollback(domain.TaskStepInitClusterConfig, "", "start")
 	rkecluster, err := r.Repo.GetCluster(config.ClusterName)
 	if err != nil {
 		logrus.Errorf("get cluster meta info failure %s", err.Error())
-		rollback("InitClusterConfig", "Get cluster meta info failure", "failure")
+		rollback(domain.TaskStepInitClusterConfig, "Get cluster meta info failure", "failure")
 		return nil
 	}
 
 	rkeConfig := config.RKEConfig
 	if rkeConfig == nil {
-		rollback("InitClusterConfig", "RKE config not found", "failure")
+		rollback(domain.TaskStepInitClusterConfig, "RKE config not found", "failure")
 		return nil
 	}
 	if len(rkeConfig.Nodes) == 0 {
-		rollback("InitClusterConfig", "Provide at least one node", "failure")
+		rollback(domain.TaskStepInitClusterConfig, "Provide at least one node", "failure")
 		return nil
 	}
 	var masterNode, etcdNode, workerNode int
@@ -159,15 +197,29 @@ func (r *rkeAdaptor) CreateWutongKubernetes(ctx context.Context, config *v1alpha
 		}
 	}
 	if workerNode == 0 {
-		rollback("InitClusterConfig", "Provide at least one compute node", "failure")
+		rollback(domain.TaskStepInitClusterConfig, "Provide at least one compute node", "failure")
 		return nil
 	}
 	if masterNode == 0 {
-		rollback("InitClusterConfig", "Provide at least one master node", "failure")
+		rollback(domain.TaskStepInitClusterConfig, "Provide at least one master node", "failure")
 		return nil
 	}
 	if etcdNode == 0 {
-		rollback("InitClusterConfig", "Provide at least one etcd node", "failure")
+		rollback(domain.TaskStepInitClusterConfig, "Provide at least one etcd node", "failure")
+		return nil
+	}
+	setNodeBastions(rkecluster, config.NodeBastions)
+	dialer := r.dialer(rkecluster, rkeConfig)
+	if config.Preflight && !r.preflight(ctx, dialer, rollback) {
+		return nil
+	}
+	// the nodes are initialized with the ssh rsa of adaptor, they are connected with the key of cluster since then.
+	// every new cluster has its own key, the shared key of adaptor is kept only by the legacy clusters.
+	keyType := config.SSHKeyType
+	if keyType == "" {
+		keyType = sshutil.KeyTypeED25519
+	}
+	if !r.ensureSSHKey(rkecluster.ClusterID, keyType, dialer, rollback) {
 		return nil
 	}
 
@@ -192,14 +244,19 @@ func (r *rkeAdaptor) CreateWutongKubernetes(ctx context.Context, config *v1alpha
 	if rkecluster.Stats == v1alpha1.InitState {
 		// clear local state data
 		os.RemoveAll(clusterStatPath)
+		// the states stored by the cluster of the same name before are outdated
+		if err := r.deleteStates(rkecluster.Name); err != nil {
+			logrus.Warningf("delete stored states of cluster %s failure %s", rkecluster.Name, err.Error())
+		}
 	}
 
 	_ = os.MkdirAll(clusterStatPath, 0755)
 
 	filePath := fmt.Sprintf("%s/cluster.yml", clusterStatPath)
+	defer r.releaseSSHKey(rkecluster.Name)
 	out, _ := yaml.Marshal(rkeConfig)
 	if err := ioutil.WriteFile(filePath, out, 0755); err != nil {
-		rollback("InitClusterConfig", err.Error(), "failure")
+		rollback(domain.TaskStepInitClusterConfig, err.Error(), "failure")
 		logrus.Errorf("write rke cluster config file failure %s", err.Error())
 		return nil
 	}
@@ -239,25 +296,28 @@ func (r *rkeAdaptor) CreateWutongKubernetes(ctx context.Context, config *v1alpha
 	}
 
 	// cluster init
-	if err := cmd.ClusterInit(ctx, rkeConfig, hosts.DialersOptions{}, flags); err != nil {
-		rollback("InitClusterConfig", err.Error(), "failure")
+	if err := cmd.ClusterInit(ctx, rkeConfig, dialer.dialersOptions(), flags); err != nil {
+		rollback(domain.TaskStepInitClusterConfig, err.Error(), "failure")
 		rkecluster.Stats = v1alpha1.InstallFailed
 		if err := r.Repo.Update(rkecluster); err != nil {
 			logrus.Errorf("update rke cluster %s state failure %s", rkecluster.Name, err.Error())
 		}
 		return nil
 	}
-	rollback("InitClusterConfig", "init cluster config success", "success")
+	rollback(domain.TaskStepInitClusterConfig, "init cluster config success", "success")
 
 	// cluster install and up
-	rollback("InstallKubernetes", "", "start")
-	APIURL, _, _, _, configs, err := r.ClusterUp(ctx, hosts.DialersOptions{}, flags, map[string]interface{}{})
+	if cancelled(ctx) {
+		return nil
+	}
+	rollback(domain.TaskStepInstallKubernetes, "", "start")
+	APIURL, _, _, _, configs, err := r.ClusterUp(ctx, dialer.dialersOptions(), flags, map[string]interface{}{})
 	if err != nil {
 		rkecluster.Stats = v1alpha1.InstallFailed
 		if err := r.Repo.Update(rkecluster); err != nil {
 			logrus.Errorf("update rke cluster %s state failure %s", rkecluster.Name, err.Error())
 		}
-		rollback("InstallKubernetes", err.Error(), "failure")
+		rollback(domain.TaskStepInstallKubernetes, err.Error(), "failure")
 		return nil
 	}
 	rkecluster.KubeConfig = configs[pki.KubeAdminCertName].Config
@@ -267,7 +327,7 @@ func (r *rkeAdaptor) CreateWutongKubernetes(ctx context.Context, config *v1alpha
 	if err := r.Repo.Update(rkecluster); err != nil {
 		logrus.Errorf("update rke cluster %s state failure %s", rkecluster.Name, err.Error())
 	}
-	rollback("InstallKubernetes", rkecluster.ClusterID, "success")
+	rollback(domain.TaskStepInstallKubernetes, rkecluster.ClusterID, "success")
 	return converClusterMeta(rkecluster)
 }
 
@@ -354,7 +414,28 @@ func (r *rkeAdaptor) CreateCluster(config v1alpha1.CreateClusterConfig) (*v1alph
 	return nil, err
 }
 
+// ClusterUp up the cluster, the state files are saved to the state store as the next version once it succeeds.
+// The private key of cluster is injected into the state file while rke is running, saveState strips it once the
+// state is saved, the callers strip it by releaseSSHKey otherwise.
 func (r *rkeAdaptor) ClusterUp(ctx context.Context, dialersOptions hosts.DialersOptions, flags cluster.ExternalFlags, data map[string]interface{}) (string, string, string, string, map[string]pki.CertificatePKI, error) {
+	if flags.ClusterFilePath == "" {
+		return r.clusterUp(ctx, dialersOptions, flags, data)
+	}
+	// the cluster state dir is named after the cluster
+	clusterName := path.Base(path.Dir(flags.ClusterFilePath))
+	if err := r.useStateSSHKey(ctx, clusterName); err != nil {
+		return "", "", "", "", nil, fmt.Errorf("inject the ssh key into the state failure %s", err.Error())
+	}
+	APIURL, caCrt, clientCert, clientKey, certs, err := r.clusterUp(ctx, dialersOptions, flags, data)
+	if err == nil {
+		if err := r.saveState(clusterName); err != nil {
+			logrus.Errorf("save state of cluster %s failure %s", clusterName, err.Error())
+		}
+	}
+	return APIURL, caCrt, clientCert, clientKey, certs, err
+}
+
+func (r *rkeAdaptor) clusterUp(ctx context.Context, dialersOptions hosts.DialersOptions, flags cluster.ExternalFlags, data map[string]interface{}) (string, string, string, string, map[string]pki.CertificatePKI, error) {
 	var APIURL, caCrt, clientCert, clientKey string
 	var reconcileCluster, restore bool
 
@@ -555,13 +636,25 @@ func (r *rkeAdaptor) GetKubeConfig(clusterID string) (*v1alpha1.KubeConfig, erro
 	return &v1alpha1.KubeConfig{Config: rkecluster.KubeConfig}, nil
 }
 
-func (r *rkeAdaptor) ExpansionNode(ctx context.Context, en *v1alpha1.ExpansionNode, rollback func(step, message, status string)) *v1alpha1.Cluster {
+func (r *rkeAdaptor) ExpansionNode(ctx context.Context, en *v1alpha1.ExpansionNode, rollback func(step domain.TaskStep, message, status string)) *v1alpha1.Cluster {
 	//Check cluster local state file, if not exist, not support expansion node
-	rollback("InitClusterConfig", "", "start")
+	rollback(domain.TaskStepInitClusterConfig, "", "start")
 	rkecluster, err := r.Repo.GetCluster(en.ClusterID)
 	if err != nil {
 		logrus.Errorf("get cluster meta info failure %s", err.Error())
-		rollback("InitClusterConfig", "Get cluster meta info failure", "failure")
+		rollback(domain.TaskStepInitClusterConfig, "Get cluster meta info failure", "failure")
+		return nil
+	}
+	if en.NodeBastions != nil {
+		setNodeBastions(rkecluster, en.NodeBastions)
+	}
+	dialer := r.dialer(rkecluster, en.RKEConfig)
+	// the new nodes authorize the key of cluster before they are checked, the existing nodes may not accept other keys.
+	if !r.ensureSSHKey(rkecluster.ClusterID, "", dialer, rollback) {
+		return nil
+	}
+	// the cluster is not changed if the nodes are not ready
+	if en.Preflight && !r.preflight(ctx, dialer, rollback) {
 		return nil
 	}
 	rkecluster.Stats = v1alpha1.InitState
@@ -574,6 +667,12 @@ func (r *rkeAdaptor) ExpansionNode(ctx context.Context, en *v1alpha1.ExpansionNo
 		configDir = os.Getenv("CONFIG_DIR")
 	}
 	clusterStatPath := fmt.Sprintf("%s/rke/%s", configDir, rkecluster.Name)
+	if err := r.restoreState(rkecluster.Name); err != nil {
+		logrus.Errorf("restore cluster %s state failure %s", rkecluster.Name, err.Error())
+		rollback(domain.TaskStepInitClusterConfig, "restore cluster state failure", "failure")
+		_ = r.Repo.Update(rkecluster)
+		return nil
+	}
 
 	_ = os.MkdirAll(clusterStatPath, 0755)
 	filePath := fmt.Sprintf("%s/cluster.yml", clusterStatPath)
@@ -585,21 +684,22 @@ func (r *rkeAdaptor) ExpansionNode(ctx context.Context, en *v1alpha1.ExpansionNo
 		_, err = os.Stat(oldClusterStatFile)
 		if err != nil {
 			logrus.Errorf("read cluster %s state file failure %s ", en.ClusterID, err.Error())
-			rollback("InitClusterConfig", "state file not exist, can not support expansion node", "failure")
+			rollback(domain.TaskStepInitClusterConfig, "state file not exist, can not support expansion node", "failure")
 			_ = r.Repo.Update(rkecluster)
 			return nil
 		}
 	}
 
 	if err := os.Rename(filePath, filePath+".bak"); err != nil {
-		rollback("InitClusterConfig", err.Error(), "failure")
+		rollback(domain.TaskStepInitClusterConfig, err.Error(), "failure")
 		logrus.Errorf("move old cluster config file failure %s", err.Error())
 		_ = r.Repo.Update(rkecluster)
 		return nil
 	}
+	defer r.releaseSSHKey(rkecluster.Name)
 	out, _ := yaml.Marshal(en.RKEConfig)
 	if err := ioutil.WriteFile(filePath, out, 0755); err != nil {
-		rollback("InitClusterConfig", err.Error(), "failure")
+		rollback(domain.TaskStepInitClusterConfig, err.Error(), "failure")
 		logrus.Errorf("write rke cluster config file failure %s", err.Error())
 		_ = os.Rename(filePath+".bak", filePath)
 		_ = r.Repo.Update(rkecluster)
@@ -622,19 +722,22 @@ func (r *rkeAdaptor) ExpansionNode(ctx context.Context, en *v1alpha1.ExpansionNo
 
 	//up cluster
 	flags := cluster.GetExternalFlags(false, false, false, false, "", filePath)
-	if err := cmd.ClusterInit(ctx, en.RKEConfig, hosts.DialersOptions{}, flags); err != nil {
+	if err := cmd.ClusterInit(ctx, en.RKEConfig, dialer.dialersOptions(), flags); err != nil {
 		_ = r.Repo.Update(rkecluster)
-		rollback("InitClusterConfig", err.Error(), "failure")
+		rollback(domain.TaskStepInitClusterConfig, err.Error(), "failure")
 		return nil
 	}
-	rollback("InitClusterConfig", "", "success")
+	rollback(domain.TaskStepInitClusterConfig, "", "success")
 
 	// cluster install and up
-	rollback("UpdateKubernetes", filePath, "start")
-	APIURL, _, _, _, configs, err := r.ClusterUp(ctx, hosts.DialersOptions{}, flags, map[string]interface{}{})
+	if cancelled(ctx) {
+		return nil
+	}
+	rollback(domain.TaskStepUpdateKubernetes, filePath, "start")
+	APIURL, _, _, _, configs, err := r.ClusterUp(ctx, dialer.dialersOptions(), flags, map[string]interface{}{})
 	if err != nil {
 		_ = r.Repo.Update(rkecluster)
-		rollback("UpdateKubernetes", err.Error(), "failure")
+		rollback(domain.TaskStepUpdateKubernetes, err.Error(), "failure")
 		return nil
 	}
 	rkecluster.KubeConfig = configs[pki.KubeAdminCertName].Config
@@ -643,7 +746,91 @@ func (r *rkeAdaptor) ExpansionNode(ctx context.Context, en *v1alpha1.ExpansionNo
 	if err := r.Repo.Update(rkecluster); err != nil {
 		logrus.Errorf("update rke cluster %s state failure %s", rkecluster.Name, err.Error())
 	}
-	rollback("UpdateKubernetes", "", "success")
+	rollback(domain.TaskStepUpdateKubernetes, "", "success")
 	clu, _ := r.DescribeCluster(rkecluster.ClusterID)
 	return clu
 }
+
+func getClusterStatPath(clusterName string) string {
+	configDir := "/tmp"
+	if os.Getenv("CONFIG_DIR") != "" {
+		configDir = os.Getenv("CONFIG_DIR")
+	}
+	return fmt.Sprintf("%s/rke/%s", configDir, clusterName)
+}
+
+// openClusterLogger the rke logs are appended to the named log file in the cluster state dir
+func openClusterLogger(ctx context.Context, clusterStatPath, name string) (context.Context, func()) {
+	logger := logrus.New()
+	logPath := path.Join(clusterStatPath, name)
+	writer, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0755)
+	if err != nil {
+		logrus.Errorf("open log file %s failure %s", logPath, err.Error())
+		return log.SetLogger(ctx, logger), func() {}
+	}
+	logger.Out = writer
+	return log.SetLogger(ctx, logger), func() { writer.Close() }
+}
+
+// loadClusterConfig load the rke config file of the cluster, returns the config file path, the state file is required.
+func (r *rkeAdaptor) loadClusterConfig(clusterID string) (*model.RKECluster, *v3.RancherKubernetesEngineConfig, string, error) {
+	rkecluster, err := r.Repo.GetCluster(clusterID)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("get cluster meta info failure %s", err.Error())
+	}
+	if err := r.restoreState(rkecluster.Name); err != nil {
+		return nil, nil, "", fmt.Errorf("restore cluster %s state failure %s", rkecluster.Name, err.Error())
+	}
+	clusterStatPath := getClusterStatPath(rkecluster.Name)
+	if _, err := os.Stat(fmt.Sprintf("%s/cluster.rkestate", clusterStatPath)); err != nil {
+		return nil, nil, "", fmt.Errorf("state file of cluster %s not exist", rkecluster.Name)
+	}
+	filePath := fmt.Sprintf("%s/cluster.yml", clusterStatPath)
+	rkeConfig, err := readRKEConfig(filePath)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	// the key may be rotated since the config file is written
+	if err := r.useSSHKey(rkecluster.ClusterID, rkeConfig); err != nil {
+		return nil, nil, "", err
+	}
+	return rkecluster, rkeConfig, filePath, nil
+}
+
+// writeRKEConfig write the rke config file, the old file is kept as cluster.yml.bak
+func writeRKEConfig(filePath string, rkeConfig *v3.RancherKubernetesEngineConfig) error {
+	if err := os.Rename(filePath, filePath+".bak"); err != nil {
+		return fmt.Errorf("move old cluster config file failure %s", err.Error())
+	}
+	out, _ := yaml.Marshal(rkeConfig)
+	if err := ioutil.WriteFile(filePath, out, 0755); err != nil {
+		_ = os.Rename(filePath+".bak", filePath)
+		return fmt.Errorf("write rke cluster config file failure %s", err.Error())
+	}
+	return nil
+}
+
+// restoreRKEConfig restore the rke config file from cluster.yml.bak written by writeRKEConfig
+func restoreRKEConfig(filePath string) {
+	if err := os.Rename(filePath+".bak", filePath); err != nil {
+		logrus.Errorf("restore cluster config file %s failure %s", filePath, err.Error())
+	}
+}
+
+func readRKEConfig(filePath string) (*v3.RancherKubernetesEngineConfig, error) {
+	bytes, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("read rke config file failure %s", err.Error())
+	}
+	var rkeConfig v3.RancherKubernetesEngineConfig
+	if err := yaml.Unmarshal(bytes, &rkeConfig); err != nil {
+		return nil, fmt.Errorf("the rke config file is incorrect %s", err.Error())
+	}
+	return &rkeConfig, nil
+}
+
+// cancelled returns true if the task is cancelled. The operations of several steps check it at the step
+// boundaries, and stop before the next step, the cancellation is recorded by the task.
+func cancelled(ctx context.Context) bool {
+	return ctx.Err() != nil
+}
@@ -27,6 +27,7 @@ import (
 
 	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
 	"github.com/wutong-paas/cloud-adaptor/internal/datastore"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
 )
 
 func TestCreateCluster(t *testing.T) {
@@ -38,7 +39,7 @@ func TestCreateCluster(t *testing.T) {
 				Roles: []string{"controlplane", "etcd", "worker"},
 			},
 		},
-	}, func(step, message, status string) {
+	}, func(step domain.TaskStep, message, status string) {
 		fmt.Printf("%s\t%s\t%s\n", step, message, status)
 	})
 	// config := v1alpha1.GetDefaultRKECreateClusterConfig([]v3.RKEConfigNode{
@@ -69,7 +70,7 @@ func TestExpansionNode(t *testing.T) {
 				Roles: []string{"controlplane", "worker"},
 			},
 		},
-	}, func(step, message, status string) {
+	}, func(step domain.TaskStep, message, status string) {
 		fmt.Printf("%s\t%s\t%s\n", step, message, status)
 	})
 }
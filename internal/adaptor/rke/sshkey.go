@@ -0,0 +1,449 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package rke
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rancher/rke/cluster"
+	v3 "github.com/rancher/rke/types"
+	"github.com/sirupsen/logrus"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+	sshutil "github.com/wutong-paas/cloud-adaptor/pkg/util/ssh"
+	"golang.org/x/crypto/ssh"
+	yaml "gopkg.in/yaml.v2"
+	"gorm.io/gorm"
+)
+
+// GetSSHKey get the public part of the ssh key used to connect the nodes of cluster
+func (r *rkeAdaptor) GetSSHKey(clusterID string) (*v1alpha1.SSHKeyInfo, error) {
+	rkecluster, err := r.Repo.GetCluster(clusterID)
+	if err != nil {
+		return nil, fmt.Errorf("get cluster meta info failure %s", err.Error())
+	}
+	key, err := r.getSSHKey(rkecluster.ClusterID)
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		return &v1alpha1.SSHKeyInfo{
+			KeyType:     key.KeyType,
+			Fingerprint: key.Fingerprint,
+			PublicKey:   key.PublicKey,
+			CreatedAt:   key.CreatedAt,
+		}, nil
+	}
+	pub, err := sshutil.GetOrMakeSSHRSA()
+	if err != nil {
+		return nil, err
+	}
+	fingerprint, err := sshutil.Fingerprint(pub)
+	if err != nil {
+		return nil, err
+	}
+	return &v1alpha1.SSHKeyInfo{
+		KeyType:     sshutil.KeyTypeRSA,
+		Fingerprint: fingerprint,
+		PublicKey:   pub,
+		Shared:      true,
+	}, nil
+}
+
+// RotateSSHKey replace the ssh key of cluster with a new one. The new key is pushed to all nodes over the
+// current key first, the current key is removed from the nodes only after the new key is active.
+func (r *rkeAdaptor) RotateSSHKey(ctx context.Context, config *v1alpha1.RotateSSHKey, rollback func(step domain.TaskStep, message, status string)) *v1alpha1.Cluster {
+	rollback(domain.TaskStepInitClusterConfig, "", "start")
+	rkecluster, rkeConfig, _, err := r.loadClusterConfig(config.ClusterID)
+	if err != nil {
+		logrus.Errorf("load cluster %s config failure %s", config.ClusterID, err.Error())
+		rollback(domain.TaskStepInitClusterConfig, err.Error(), "failure")
+		return nil
+	}
+	oldKey, err := r.getSSHKey(rkecluster.ClusterID)
+	if err != nil {
+		rollback(domain.TaskStepInitClusterConfig, err.Error(), "failure")
+		return nil
+	}
+	// the keys to be retired, the legacy clusters use the ssh rsa of adaptor or their own key files
+	oldSigners := make(map[string]ssh.Signer, len(rkeConfig.Nodes))
+	for _, node := range rkeConfig.Nodes {
+		signer, err := nodeSigner(node)
+		if err != nil {
+			rollback(domain.TaskStepInitClusterConfig, fmt.Sprintf("get the ssh key of node %s failure %s", node.Address, err.Error()), "failure")
+			return nil
+		}
+		oldSigners[node.Address] = signer
+	}
+	dialer := r.dialer(rkecluster, rkeConfig)
+	rollback(domain.TaskStepInitClusterConfig, "", "success")
+
+	if cancelled(ctx) {
+		return nil
+	}
+	rollback(domain.TaskStepPushSSHKey, "", "start")
+	newKey, err := newClusterSSHKey(rkecluster.ClusterID, config.KeyType, model.SSHKeyStatusPending)
+	if err != nil {
+		rollback(domain.TaskStepPushSSHKey, err.Error(), "failure")
+		return nil
+	}
+	if err := r.KeyRepo.Create(newKey); err != nil {
+		rollback(domain.TaskStepPushSSHKey, fmt.Sprintf("save ssh key failure %s", err.Error()), "failure")
+		return nil
+	}
+	newSigner, err := ssh.ParsePrivateKey([]byte(newKey.PrivateKey))
+	if err != nil {
+		rollback(domain.TaskStepPushSSHKey, err.Error(), "failure")
+		return nil
+	}
+	if failed := forEachNode(rkeConfig.Nodes, func(node v3.RKEConfigNode) error {
+		return pushSSHKey(node, newSigner, newKey.PublicKey, dialer.dialWithSigner)
+	}); len(failed) > 0 {
+		r.discardSSHKey(dialer, newKey)
+		rollback(domain.TaskStepPushSSHKey, "push ssh key to nodes failure: "+strings.Join(failed, "; "), "failure")
+		return nil
+	}
+	rollback(domain.TaskStepPushSSHKey, newKey.Fingerprint, "success")
+	if cancelled(ctx) {
+		r.discardSSHKey(dialer, newKey)
+		return nil
+	}
+
+	// the key is injected when the cluster config is loaded, so the cluster config takes the new key once it is active
+	rollback(domain.TaskStepUpdateClusterConfig, "", "start")
+	if err := r.KeyRepo.UpdateStatus(newKey.ID, model.SSHKeyStatusActive); err != nil {
+		r.discardSSHKey(dialer, newKey)
+		rollback(domain.TaskStepUpdateClusterConfig, fmt.Sprintf("activate ssh key failure %s", err.Error()), "failure")
+		return nil
+	}
+	if oldKey != nil {
+		if err := r.KeyRepo.UpdateStatus(oldKey.ID, model.SSHKeyStatusRetired); err != nil {
+			logrus.Warningf("retire ssh key %s failure %s", oldKey.Fingerprint, err.Error())
+		}
+	}
+	rollback(domain.TaskStepUpdateClusterConfig, "", "success")
+
+	rollback(domain.TaskStepRetireSSHKey, "", "start")
+	failed := forEachNode(rkeConfig.Nodes, func(node v3.RKEConfigNode) error {
+		return revokeSSHKey(node, newSigner, string(ssh.MarshalAuthorizedKey(oldSigners[node.Address].PublicKey())), dialer.dialWithSigner)
+	})
+	if oldKey != nil {
+		if err := r.KeyRepo.Delete(oldKey.ID); err != nil {
+			logrus.Warningf("delete ssh key %s failure %s", oldKey.Fingerprint, err.Error())
+		}
+	}
+	var message string
+	if len(failed) > 0 {
+		// the old key can not be used by adaptor anymore, it is left on the nodes to be removed manually.
+		message = "the old ssh key is not removed from nodes: " + strings.Join(failed, "; ")
+	}
+	rollback(domain.TaskStepRetireSSHKey, message, "success")
+	clu, _ := r.DescribeCluster(rkecluster.ClusterID)
+	return clu
+}
+
+// ensureSSHKey make sure all nodes authorize the ssh key of cluster, the key is pushed over the current access
+// of the node if not, then the nodes of the rke config are connected with the key. The key is generated with the
+// given type if the cluster has no key. The type is empty when updating or expanding the cluster, the legacy
+// clusters without their own key keep the shared key of adaptor then.
+func (r *rkeAdaptor) ensureSSHKey(clusterID, keyType string, dialer *nodeDialer, rollback func(step domain.TaskStep, message, status string)) bool {
+	rkeConfig := dialer.rkeConfig
+	key, err := r.getSSHKey(clusterID)
+	if err != nil {
+		rollback(domain.TaskStepInitSSHKey, err.Error(), "failure")
+		return false
+	}
+	if key == nil && keyType == "" {
+		return true
+	}
+	rollback(domain.TaskStepInitSSHKey, "", "start")
+	if key == nil {
+		key, err = newClusterSSHKey(clusterID, keyType, model.SSHKeyStatusActive)
+		if err != nil {
+			rollback(domain.TaskStepInitSSHKey, err.Error(), "failure")
+			return false
+		}
+		if err := r.KeyRepo.Create(key); err != nil {
+			rollback(domain.TaskStepInitSSHKey, fmt.Sprintf("save ssh key failure %s", err.Error()), "failure")
+			return false
+		}
+	}
+	signer, err := ssh.ParsePrivateKey([]byte(key.PrivateKey))
+	if err != nil {
+		rollback(domain.TaskStepInitSSHKey, err.Error(), "failure")
+		return false
+	}
+	if failed := forEachNode(rkeConfig.Nodes, func(node v3.RKEConfigNode) error {
+		return pushSSHKey(node, signer, key.PublicKey, dialer.dialWithSigner)
+	}); len(failed) > 0 {
+		rollback(domain.TaskStepInitSSHKey, "push ssh key to nodes failure: "+strings.Join(failed, "; "), "failure")
+		return false
+	}
+	injectSSHKey(rkeConfig, key.PrivateKey)
+	rollback(domain.TaskStepInitSSHKey, key.Fingerprint, "success")
+	return true
+}
+
+// useSSHKey connect the nodes of the rke config with the ssh key of cluster if it has one
+func (r *rkeAdaptor) useSSHKey(clusterID string, rkeConfig *v3.RancherKubernetesEngineConfig) error {
+	key, err := r.getSSHKey(clusterID)
+	if err != nil {
+		return err
+	}
+	if key != nil {
+		injectSSHKey(rkeConfig, key.PrivateKey)
+	}
+	return nil
+}
+
+// getSSHKey get the active ssh key of cluster, returns nil if the cluster has no key of its own.
+func (r *rkeAdaptor) getSSHKey(clusterID string) (*model.ClusterSSHKey, error) {
+	if r.KeyRepo == nil {
+		return nil, nil
+	}
+	key, err := r.KeyRepo.GetKey(clusterID, model.SSHKeyStatusActive)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get ssh key of cluster %s failure %s", clusterID, err.Error())
+	}
+	return key, nil
+}
+
+// discardSSHKey remove the key failed to take effect from the nodes and the database
+func (r *rkeAdaptor) discardSSHKey(dialer *nodeDialer, key *model.ClusterSSHKey) {
+	forEachNode(dialer.rkeConfig.Nodes, func(node v3.RKEConfigNode) error {
+		signer, err := nodeSigner(node)
+		if err != nil {
+			return err
+		}
+		return revokeSSHKey(node, signer, key.PublicKey, dialer.dialWithSigner)
+	})
+	if err := r.KeyRepo.Delete(key.ID); err != nil {
+		logrus.Warningf("delete ssh key %s failure %s", key.Fingerprint, err.Error())
+	}
+}
+
+func newClusterSSHKey(clusterID, keyType, status string) (*model.ClusterSSHKey, error) {
+	if keyType == "" {
+		keyType = sshutil.KeyTypeED25519
+	}
+	private, pub, err := sshutil.MakeSSHKeyPairByType(keyType)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint, err := sshutil.Fingerprint(pub)
+	if err != nil {
+		return nil, err
+	}
+	return &model.ClusterSSHKey{
+		ClusterID:   clusterID,
+		KeyType:     keyType,
+		PublicKey:   strings.TrimSpace(pub),
+		PrivateKey:  private,
+		Fingerprint: fingerprint,
+		Status:      status,
+	}, nil
+}
+
+// injectSSHKey connect the nodes with the private key instead of the key file
+func injectSSHKey(rkeConfig *v3.RancherKubernetesEngineConfig, privateKey string) {
+	for i := range rkeConfig.Nodes {
+		rkeConfig.Nodes[i].SSHKey = privateKey
+		rkeConfig.Nodes[i].SSHKeyPath = ""
+	}
+}
+
+// stripSSHKey clear the private key of cluster from the nodes, the keys of the nodes set by users are kept.
+func stripSSHKey(rkeConfig *v3.RancherKubernetesEngineConfig, privateKey string) {
+	for i := range rkeConfig.Nodes {
+		if rkeConfig.Nodes[i].SSHKey == privateKey {
+			rkeConfig.Nodes[i].SSHKey = ""
+		}
+	}
+}
+
+// updateStateSSHKey apply fn to the rke configs of the desired state and the current state in the state file
+func updateStateSSHKey(ctx context.Context, statePath string, fn func(rkeConfig *v3.RancherKubernetesEngineConfig)) error {
+	fullState, err := cluster.ReadStateFile(ctx, statePath)
+	if err != nil {
+		return err
+	}
+	for _, state := range []*cluster.State{&fullState.DesiredState, &fullState.CurrentState} {
+		if state.RancherKubernetesEngineConfig != nil {
+			fn(state.RancherKubernetesEngineConfig)
+		}
+	}
+	return fullState.WriteStateFile(ctx, statePath)
+}
+
+// clusterSSHKey get the active ssh key of the cluster named clusterName, returns nil if the cluster has no key of its own.
+func (r *rkeAdaptor) clusterSSHKey(clusterName string) (*model.ClusterSSHKey, error) {
+	if r.Repo == nil {
+		return nil, nil
+	}
+	rkecluster, err := r.Repo.GetCluster(clusterName)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get cluster meta info failure %s", err.Error())
+	}
+	return r.getSSHKey(rkecluster.ClusterID)
+}
+
+// useStateSSHKey inject the private key of cluster into the state file, rke connects the existing nodes
+// with the current state, whose key is stripped when it is saved.
+func (r *rkeAdaptor) useStateSSHKey(ctx context.Context, clusterName string) error {
+	key, err := r.clusterSSHKey(clusterName)
+	if err != nil || key == nil {
+		return err
+	}
+	statePath := path.Join(getClusterStatPath(clusterName), "cluster.rkestate")
+	return updateStateSSHKey(ctx, statePath, func(rkeConfig *v3.RancherKubernetesEngineConfig) {
+		injectSSHKey(rkeConfig, key.PrivateKey)
+	})
+}
+
+// scrubSSHKey strip the private key of cluster from the config file and the state file in the cluster state dir,
+// so that the key is kept in plaintext only while rke is running. It is injected again when the cluster is loaded.
+func (r *rkeAdaptor) scrubSSHKey(ctx context.Context, clusterName string) error {
+	key, err := r.clusterSSHKey(clusterName)
+	if err != nil || key == nil {
+		return err
+	}
+	clusterStatPath := getClusterStatPath(clusterName)
+	filePath := path.Join(clusterStatPath, "cluster.yml")
+	statePath := path.Join(clusterStatPath, "cluster.rkestate")
+	if _, err := os.Stat(statePath); err != nil {
+		// nothing is written yet
+		return nil
+	}
+	rkeConfig, err := readRKEConfig(filePath)
+	if err != nil {
+		return err
+	}
+	stripSSHKey(rkeConfig, key.PrivateKey)
+	out, _ := yaml.Marshal(rkeConfig)
+	if err := ioutil.WriteFile(filePath, out, 0755); err != nil {
+		return fmt.Errorf("write rke cluster config file failure %s", err.Error())
+	}
+	return updateStateSSHKey(ctx, statePath, func(rkeConfig *v3.RancherKubernetesEngineConfig) {
+		stripSSHKey(rkeConfig, key.PrivateKey)
+	})
+}
+
+// releaseSSHKey strip the private key of cluster from the cluster state dir once the operation exits, rke writes
+// the key into the state file along with the config, the operation may fail or be cancelled before the state is saved.
+func (r *rkeAdaptor) releaseSSHKey(clusterName string) {
+	if err := r.scrubSSHKey(context.Background(), clusterName); err != nil {
+		logrus.Warningf("strip the ssh key from the state of cluster %s failure %s", clusterName, err.Error())
+	}
+}
+
+// pushSSHKey authorize the key on the node over its current access, the node must accept the key then.
+func pushSSHKey(node v3.RKEConfigNode, signer ssh.Signer, authorizedKey string, dial func(node v3.RKEConfigNode, signer ssh.Signer) (commandRunner, func(), error)) error {
+	if sshutil.AuthorizedKeyBlob(authorizedKey) == "" {
+		return fmt.Errorf("invalid authorized key %s", authorizedKey)
+	}
+	// the key is authorized already, such as the retried installation
+	if _, closeFn, err := dial(node, signer); err == nil {
+		closeFn()
+		return nil
+	}
+	oldSigner, err := nodeSigner(node)
+	if err != nil {
+		return err
+	}
+	runner, closeFn, err := dial(node, oldSigner)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+	if out, err := runner.Run(authorizeKeyCmd(authorizedKey)); err != nil {
+		return fmt.Errorf("authorize ssh key failure %s %s", out, err.Error())
+	}
+	_, closeNew, err := dial(node, signer)
+	if err != nil {
+		return fmt.Errorf("the node does not accept the new ssh key, %s", err.Error())
+	}
+	closeNew()
+	return nil
+}
+
+// revokeSSHKey remove the key from the authorized keys of the node, the node is connected with the signer.
+func revokeSSHKey(node v3.RKEConfigNode, signer ssh.Signer, authorizedKey string, dial func(node v3.RKEConfigNode, signer ssh.Signer) (commandRunner, func(), error)) error {
+	// an empty pattern removes all keys
+	if sshutil.AuthorizedKeyBlob(authorizedKey) == "" {
+		return fmt.Errorf("invalid authorized key %s", authorizedKey)
+	}
+	runner, closeFn, err := dial(node, signer)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+	if out, err := runner.Run(revokeKeyCmd(authorizedKey)); err != nil {
+		return fmt.Errorf("revoke ssh key failure %s %s", out, err.Error())
+	}
+	return nil
+}
+
+func authorizeKeyCmd(authorizedKey string) string {
+	return fmt.Sprintf("mkdir -p ~/.ssh && chmod 700 ~/.ssh && touch ~/.ssh/authorized_keys && chmod 600 ~/.ssh/authorized_keys && "+
+		"(grep -qF '%s' ~/.ssh/authorized_keys || printf '\\n%%s\\n' '%s' >> ~/.ssh/authorized_keys)",
+		sshutil.AuthorizedKeyBlob(authorizedKey), strings.TrimSpace(authorizedKey))
+}
+
+func revokeKeyCmd(authorizedKey string) string {
+	// rewrite the file in place to keep its owner and mode
+	return fmt.Sprintf("[ ! -f ~/.ssh/authorized_keys ] || { grep -vF '%s' ~/.ssh/authorized_keys > ~/.ssh/authorized_keys.tmp; "+
+		"cat ~/.ssh/authorized_keys.tmp > ~/.ssh/authorized_keys && rm -f ~/.ssh/authorized_keys.tmp; }",
+		sshutil.AuthorizedKeyBlob(authorizedKey))
+}
+
+// forEachNode run fn on the nodes in parallel, returns the errors prefixed with the node address.
+func forEachNode(nodes []v3.RKEConfigNode, fn func(node v3.RKEConfigNode) error) []string {
+	var mu sync.Mutex
+	var failed []string
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(node v3.RKEConfigNode) {
+			defer wg.Done()
+			if err := fn(node); err != nil {
+				mu.Lock()
+				failed = append(failed, fmt.Sprintf("[%s] %s", node.Address, err.Error()))
+				mu.Unlock()
+			}
+		}(node)
+	}
+	wg.Wait()
+	sort.Strings(failed)
+	return failed
+}
@@ -0,0 +1,158 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package rke
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rancher/rke/cluster"
+	v3 "github.com/rancher/rke/types"
+	sshutil "github.com/wutong-paas/cloud-adaptor/pkg/util/ssh"
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeKeyHost authorizes the keys by their blobs like the authorized_keys file
+type fakeKeyHost struct {
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+func (f *fakeKeyHost) Run(cmd string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case strings.Contains(cmd, "grep -qF '"):
+		f.keys[quoted(cmd, "grep -qF '")] = true
+	case strings.Contains(cmd, "grep -vF '"):
+		delete(f.keys, quoted(cmd, "grep -vF '"))
+	}
+	return "", nil
+}
+
+func (f *fakeKeyHost) dial(node v3.RKEConfigNode, signer ssh.Signer) (commandRunner, func(), error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.keys[sshutil.AuthorizedKeyBlob(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))] {
+		return nil, nil, fmt.Errorf("permission denied")
+	}
+	return f, func() {}, nil
+}
+
+func quoted(cmd, prefix string) string {
+	s := cmd[strings.Index(cmd, prefix)+len(prefix):]
+	return s[:strings.Index(s, "'")]
+}
+
+func TestPushAndRevokeSSHKey(t *testing.T) {
+	oldKey, err := newClusterSSHKey("c1", sshutil.KeyTypeRSA, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := newClusterSSHKey("c1", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newKey.KeyType != sshutil.KeyTypeED25519 {
+		t.Errorf("want the default key type ed25519, got %s", newKey.KeyType)
+	}
+	newSigner, _ := ssh.ParsePrivateKey([]byte(newKey.PrivateKey))
+	host := &fakeKeyHost{keys: map[string]bool{sshutil.AuthorizedKeyBlob(oldKey.PublicKey): true}}
+	node := v3.RKEConfigNode{Address: "192.168.0.1", SSHKey: oldKey.PrivateKey}
+
+	if err := pushSSHKey(node, newSigner, newKey.PublicKey, host.dial); err != nil {
+		t.Fatal(err)
+	}
+	if len(host.keys) != 2 {
+		t.Fatalf("want both keys authorized, got %d", len(host.keys))
+	}
+	// the key authorized already is not pushed again
+	if err := pushSSHKey(v3.RKEConfigNode{Address: "192.168.0.1"}, newSigner, newKey.PublicKey, host.dial); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := revokeSSHKey(node, newSigner, oldKey.PublicKey, host.dial); err != nil {
+		t.Fatal(err)
+	}
+	if host.keys[sshutil.AuthorizedKeyBlob(oldKey.PublicKey)] || !host.keys[sshutil.AuthorizedKeyBlob(newKey.PublicKey)] {
+		t.Errorf("want only the new key authorized, got %v", host.keys)
+	}
+	if err := revokeSSHKey(node, newSigner, "", host.dial); err == nil {
+		t.Error("expect error when revoke an empty key")
+	}
+
+	rkeConfig := &v3.RancherKubernetesEngineConfig{Nodes: []v3.RKEConfigNode{node, {Address: "192.168.0.2", SSHKeyPath: "~/.ssh/id_rsa"}}}
+	injectSSHKey(rkeConfig, newKey.PrivateKey)
+	for _, n := range rkeConfig.Nodes {
+		if n.SSHKey != newKey.PrivateKey || n.SSHKeyPath != "" {
+			t.Errorf("the key of node %s is not injected", n.Address)
+		}
+	}
+}
+
+func TestStripSSHKey(t *testing.T) {
+	statePath := path.Join(t.TempDir(), "cluster.rkestate")
+	rkeConfig := &v3.RancherKubernetesEngineConfig{Nodes: []v3.RKEConfigNode{{Address: "192.168.0.1"}}}
+	fullState := &cluster.FullState{
+		DesiredState: cluster.State{RancherKubernetesEngineConfig: rkeConfig.DeepCopy()},
+		CurrentState: cluster.State{RancherKubernetesEngineConfig: rkeConfig.DeepCopy()},
+	}
+	if err := fullState.WriteStateFile(context.Background(), statePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := updateStateSSHKey(context.Background(), statePath, func(rkeConfig *v3.RancherKubernetesEngineConfig) {
+		injectSSHKey(rkeConfig, "cluster-key")
+		rkeConfig.Nodes = append(rkeConfig.Nodes, v3.RKEConfigNode{Address: "192.168.0.2", SSHKey: "user-key"})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := updateStateSSHKey(context.Background(), statePath, func(rkeConfig *v3.RancherKubernetesEngineConfig) {
+		stripSSHKey(rkeConfig, "cluster-key")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	stripped, err := cluster.ReadStateFile(context.Background(), statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, state := range []cluster.State{stripped.DesiredState, stripped.CurrentState} {
+		nodes := state.RancherKubernetesEngineConfig.Nodes
+		if len(nodes) != 2 || nodes[0].SSHKey != "" || nodes[1].SSHKey != "user-key" {
+			t.Errorf("want only the key of cluster stripped, got %+v", nodes)
+		}
+	}
+}
+
+func TestForEachNode(t *testing.T) {
+	nodes := []v3.RKEConfigNode{{Address: "192.168.0.2"}, {Address: "192.168.0.1"}, {Address: "192.168.0.3"}}
+	failed := forEachNode(nodes, func(node v3.RKEConfigNode) error {
+		if node.Address == "192.168.0.3" {
+			return nil
+		}
+		return fmt.Errorf("unreachable")
+	})
+	want := []string{"[192.168.0.1] unreachable", "[192.168.0.2] unreachable"}
+	if strings.Join(failed, ",") != strings.Join(want, ",") {
+		t.Errorf("want %v, got %v", want, failed)
+	}
+}
@@ -0,0 +1,411 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package rke
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/wutong-paas/cloud-adaptor/cmd/cloud-adaptor/config"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/internal/datastore"
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+	"github.com/wutong-paas/cloud-adaptor/internal/repo"
+	"github.com/wutong-paas/cloud-adaptor/pkg/bcode"
+	"gorm.io/gorm"
+)
+
+const (
+	// StateStoreDB the cluster states are stored in the database
+	StateStoreDB = "db"
+	// StateStoreLocal the cluster states are stored in the local CONFIG_DIR
+	StateStoreLocal = "local"
+)
+
+// errStateNotFound there is no stored state of the cluster
+var errStateNotFound = errors.New("cluster state not found")
+
+// ClusterState a version of the rke full state of cluster
+type ClusterState struct {
+	Version   int
+	CreatedAt time.Time
+	// Config the content of cluster.yml
+	Config []byte
+	// State the content of cluster.rkestate
+	State []byte
+}
+
+// StateStore stores the versioned rke states of clusters.
+type StateStore interface {
+	// Save saves the state as the next version of cluster, the version is set to the state.
+	Save(clusterName string, state *ClusterState) error
+	// Get gets the state of the given version, the latest state is returned if the version is 0.
+	Get(clusterName string, version int) (*ClusterState, error)
+	// List lists the states of cluster without the content, the latest first.
+	List(clusterName string) ([]*ClusterState, error)
+	// Delete deletes all states of cluster.
+	Delete(clusterName string) error
+}
+
+var (
+	defaultStateStore StateStore
+	stateStoreOnce    sync.Once
+)
+
+// getStateStore returns the state store configured by --rke-state-store
+func getStateStore() StateStore {
+	stateStoreOnce.Do(func() {
+		storeType := StateStoreDB
+		if config.C != nil && config.C.RKEStateStore != "" {
+			storeType = config.C.RKEStateStore
+		}
+		defaultStateStore = NewStateStore(storeType, datastore.GetGDB())
+	})
+	return defaultStateStore
+}
+
+// NewStateStore creates a state store of the given type, the local store is used if the type is unknown.
+func NewStateStore(storeType string, db *gorm.DB) StateStore {
+	if storeType == StateStoreDB && db != nil {
+		return &dbStateStore{repo: repo.NewRKEClusterStateRepo(db)}
+	}
+	return &localStateStore{}
+}
+
+type dbStateStore struct {
+	repo repo.RKEClusterStateRepository
+}
+
+func (d *dbStateStore) Save(clusterName string, state *ClusterState) error {
+	ent := &model.RKEClusterState{
+		ClusterName: clusterName,
+		Config:      string(state.Config),
+		State:       string(state.State),
+	}
+	if err := d.repo.Create(ent); err != nil {
+		return err
+	}
+	state.Version = ent.Version
+	state.CreatedAt = ent.CreatedAt
+	return nil
+}
+
+func (d *dbStateStore) Get(clusterName string, version int) (*ClusterState, error) {
+	var ent *model.RKEClusterState
+	var err error
+	if version == 0 {
+		ent, err = d.repo.GetLatestState(clusterName)
+	} else {
+		ent, err = d.repo.GetState(clusterName, version)
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errStateNotFound
+		}
+		return nil, err
+	}
+	return &ClusterState{
+		Version:   ent.Version,
+		CreatedAt: ent.CreatedAt,
+		Config:    []byte(ent.Config),
+		State:     []byte(ent.State),
+	}, nil
+}
+
+func (d *dbStateStore) List(clusterName string) ([]*ClusterState, error) {
+	list, err := d.repo.ListStates(clusterName)
+	if err != nil {
+		return nil, err
+	}
+	var states []*ClusterState
+	for _, ent := range list {
+		states = append(states, &ClusterState{Version: ent.Version, CreatedAt: ent.CreatedAt})
+	}
+	return states, nil
+}
+
+func (d *dbStateStore) Delete(clusterName string) error {
+	return d.repo.DeleteStates(clusterName)
+}
+
+// localStateStore keeps each version in the states/<version> dir under the cluster state dir
+type localStateStore struct{}
+
+func (l *localStateStore) versionsPath(clusterName string) string {
+	return path.Join(getClusterStatPath(clusterName), "states")
+}
+
+func (l *localStateStore) versions(clusterName string) ([]int, error) {
+	dirs, err := ioutil.ReadDir(l.versionsPath(clusterName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var versions []int
+	for _, dir := range dirs {
+		if version, err := strconv.Atoi(dir.Name()); err == nil && dir.IsDir() {
+			versions = append(versions, version)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+	return versions, nil
+}
+
+func (l *localStateStore) Save(clusterName string, state *ClusterState) error {
+	versions, err := l.versions(clusterName)
+	if err != nil {
+		return err
+	}
+	version := 1
+	if len(versions) > 0 {
+		version = versions[0] + 1
+	}
+	versionPath := path.Join(l.versionsPath(clusterName), strconv.Itoa(version))
+	if err := os.MkdirAll(versionPath, 0755); err != nil {
+		return err
+	}
+	if err := writeStateFiles(versionPath, state); err != nil {
+		_ = os.RemoveAll(versionPath)
+		return err
+	}
+	state.Version = version
+	state.CreatedAt = time.Now()
+	return nil
+}
+
+func (l *localStateStore) Get(clusterName string, version int) (*ClusterState, error) {
+	if version == 0 {
+		versions, err := l.versions(clusterName)
+		if err != nil {
+			return nil, err
+		}
+		if len(versions) == 0 {
+			return nil, errStateNotFound
+		}
+		version = versions[0]
+	}
+	versionPath := path.Join(l.versionsPath(clusterName), strconv.Itoa(version))
+	info, err := os.Stat(versionPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errStateNotFound
+		}
+		return nil, err
+	}
+	state, err := readStateFiles(versionPath)
+	if err != nil {
+		return nil, err
+	}
+	state.Version = version
+	state.CreatedAt = info.ModTime()
+	return state, nil
+}
+
+func (l *localStateStore) List(clusterName string) ([]*ClusterState, error) {
+	versions, err := l.versions(clusterName)
+	if err != nil {
+		return nil, err
+	}
+	var states []*ClusterState
+	for _, version := range versions {
+		state := &ClusterState{Version: version}
+		if info, err := os.Stat(path.Join(l.versionsPath(clusterName), strconv.Itoa(version))); err == nil {
+			state.CreatedAt = info.ModTime()
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+func (l *localStateStore) Delete(clusterName string) error {
+	return os.RemoveAll(l.versionsPath(clusterName))
+}
+
+func writeStateFiles(dir string, state *ClusterState) error {
+	if err := ioutil.WriteFile(path.Join(dir, "cluster.yml"), state.Config, 0755); err != nil {
+		return fmt.Errorf("write rke cluster config file failure %s", err.Error())
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "cluster.rkestate"), state.State, 0755); err != nil {
+		return fmt.Errorf("write rke cluster state file failure %s", err.Error())
+	}
+	return nil
+}
+
+// stateVersionFile records the stored version of the state files in the cluster state dir
+const stateVersionFile = "cluster.version"
+
+// readStateVersion returns the stored version of the state files in dir, 0 if the version is not recorded,
+// such as the files written before the states are stored, or -1 if there is no state file.
+func readStateVersion(dir string) int {
+	if _, err := os.Stat(path.Join(dir, "cluster.rkestate")); err != nil {
+		return -1
+	}
+	content, err := ioutil.ReadFile(path.Join(dir, stateVersionFile))
+	if err != nil {
+		return 0
+	}
+	version, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return 0
+	}
+	return version
+}
+
+func writeStateVersion(dir string, version int) error {
+	if err := ioutil.WriteFile(path.Join(dir, stateVersionFile), []byte(strconv.Itoa(version)), 0755); err != nil {
+		return fmt.Errorf("write rke cluster state version file failure %s", err.Error())
+	}
+	return nil
+}
+
+func readStateFiles(dir string) (*ClusterState, error) {
+	config, err := ioutil.ReadFile(path.Join(dir, "cluster.yml"))
+	if err != nil {
+		return nil, err
+	}
+	state, err := ioutil.ReadFile(path.Join(dir, "cluster.rkestate"))
+	if err != nil {
+		return nil, err
+	}
+	return &ClusterState{Config: config, State: state}, nil
+}
+
+// saveState saves the state files in the cluster state dir as the next version, the private key of cluster
+// is stripped from the files before.
+func (r *rkeAdaptor) saveState(clusterName string) error {
+	if err := r.scrubSSHKey(context.Background(), clusterName); err != nil {
+		return fmt.Errorf("strip the ssh key from the state failure %s", err.Error())
+	}
+	if r.StateStore == nil {
+		return nil
+	}
+	clusterStatPath := getClusterStatPath(clusterName)
+	state, err := readStateFiles(clusterStatPath)
+	if err != nil {
+		return err
+	}
+	if err := r.StateStore.Save(clusterName, state); err != nil {
+		return err
+	}
+	return writeStateVersion(clusterStatPath, state.Version)
+}
+
+// deleteStates deletes all stored states of cluster
+func (r *rkeAdaptor) deleteStates(clusterName string) error {
+	if r.StateStore == nil {
+		return nil
+	}
+	return r.StateStore.Delete(clusterName)
+}
+
+// restoreState writes the latest stored state into the cluster state dir, so that the cluster can be
+// operated by any replica. The state files are written only if they are missing or older than the latest
+// stored state. The local state files are imported if nothing is stored yet.
+func (r *rkeAdaptor) restoreState(clusterName string) error {
+	if r.StateStore == nil {
+		return nil
+	}
+	clusterStatPath := getClusterStatPath(clusterName)
+	localVersion := readStateVersion(clusterStatPath)
+	state, err := r.StateStore.Get(clusterName, 0)
+	if err != nil {
+		if err == errStateNotFound {
+			if localVersion < 0 {
+				return nil
+			}
+			return r.saveState(clusterName)
+		}
+		return err
+	}
+	if localVersion >= state.Version {
+		return nil
+	}
+	if err := os.MkdirAll(clusterStatPath, 0755); err != nil {
+		return err
+	}
+	if err := writeStateFiles(clusterStatPath, state); err != nil {
+		return err
+	}
+	return writeStateVersion(clusterStatPath, state.Version)
+}
+
+// RestoreClusterState writes the latest stored state of cluster into its local state dir, if the local state
+// is missing or older than it.
+func (r *rkeAdaptor) RestoreClusterState(clusterID string) error {
+	rkecluster, err := r.Repo.GetCluster(clusterID)
+	if err != nil {
+		return fmt.Errorf("get cluster meta info failure %s", err.Error())
+	}
+	return r.restoreState(rkecluster.Name)
+}
+
+// ListClusterStates list the stored state versions of cluster
+func (r *rkeAdaptor) ListClusterStates(clusterID string) ([]*v1alpha1.ClusterStateVersion, error) {
+	rkecluster, err := r.Repo.GetCluster(clusterID)
+	if err != nil {
+		return nil, fmt.Errorf("get cluster meta info failure %s", err.Error())
+	}
+	// the local state files of the clusters created before are imported at first
+	if err := r.restoreState(rkecluster.Name); err != nil {
+		return nil, err
+	}
+	states, err := r.StateStore.List(rkecluster.Name)
+	if err != nil {
+		return nil, err
+	}
+	var versions []*v1alpha1.ClusterStateVersion
+	for _, state := range states {
+		versions = append(versions, &v1alpha1.ClusterStateVersion{Version: state.Version, Created: state.CreatedAt})
+	}
+	return versions, nil
+}
+
+// RollbackClusterState save the given state version as the latest one, then write it into the cluster state dir.
+// The cluster is not changed until the next cluster up.
+func (r *rkeAdaptor) RollbackClusterState(clusterID string, version int) (*v1alpha1.ClusterStateVersion, error) {
+	rkecluster, err := r.Repo.GetCluster(clusterID)
+	if err != nil {
+		return nil, fmt.Errorf("get cluster meta info failure %s", err.Error())
+	}
+	state, err := r.StateStore.Get(rkecluster.Name, version)
+	if err != nil {
+		if err == errStateNotFound {
+			return nil, errors.WithStack(bcode.ErrClusterStateNotFound)
+		}
+		return nil, err
+	}
+	if err := r.StateStore.Save(rkecluster.Name, state); err != nil {
+		return nil, err
+	}
+	if err := r.restoreState(rkecluster.Name); err != nil {
+		return nil, err
+	}
+	return &v1alpha1.ClusterStateVersion{Version: state.Version, Created: state.CreatedAt}, nil
+}
@@ -0,0 +1,95 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package rke
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestLocalStateStore(t *testing.T) {
+	configDir, err := ioutil.TempDir("", "rke-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(configDir)
+	os.Setenv("CONFIG_DIR", configDir)
+	defer os.Unsetenv("CONFIG_DIR")
+
+	r := &rkeAdaptor{StateStore: &localStateStore{}}
+	clusterStatPath := getClusterStatPath("test")
+	_ = os.MkdirAll(clusterStatPath, 0755)
+	if err := writeStateFiles(clusterStatPath, &ClusterState{Config: []byte("v1"), State: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+	// the local state files are imported as the first version
+	if err := r.restoreState("test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(clusterStatPath, "cluster.yml"), []byte("v2"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.saveState("test"); err != nil {
+		t.Fatal(err)
+	}
+	states, err := r.StateStore.List("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 2 || states[0].Version != 2 {
+		t.Fatalf("unexpected states %v", states)
+	}
+
+	first, err := r.StateStore.Get("test", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.StateStore.Save("test", first); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.restoreState("test"); err != nil {
+		t.Fatal(err)
+	}
+	config, _ := ioutil.ReadFile(path.Join(clusterStatPath, "cluster.yml"))
+	if string(config) != "v1" || first.Version != 3 {
+		t.Errorf("expected rolled back to v1 as version 3, but got %s version %d", config, first.Version)
+	}
+	// the state files as new as the latest stored state are not overwritten, such as by a running task
+	if err := ioutil.WriteFile(path.Join(clusterStatPath, "cluster.yml"), []byte("v3"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.restoreState("test"); err != nil {
+		t.Fatal(err)
+	}
+	if config, _ := ioutil.ReadFile(path.Join(clusterStatPath, "cluster.yml")); string(config) != "v3" {
+		t.Errorf("expected the local state kept, but got %s", config)
+	}
+
+	if _, err := r.StateStore.Get("test", 4); err != errStateNotFound {
+		t.Errorf("expected state not found, but got %v", err)
+	}
+	if err := r.StateStore.Delete("test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.StateStore.Get("test", 0); err != errStateNotFound {
+		t.Errorf("expected state not found, but got %v", err)
+	}
+}
@@ -0,0 +1,223 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package rke
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rancher/rke/cluster"
+	"github.com/rancher/rke/cmd"
+	"github.com/rancher/rke/log"
+	"github.com/rancher/rke/pki"
+	"github.com/rancher/rke/services"
+	v3 "github.com/rancher/rke/types"
+	"github.com/sirupsen/logrus"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
+)
+
+// UpgradeKubernetes upgrade the kubernetes version of the cluster, etcd is snapshotted before upgrade.
+func (r *rkeAdaptor) UpgradeKubernetes(ctx context.Context, config *v1alpha1.UpgradeKubernetes, rollback func(step domain.TaskStep, message, status string)) *v1alpha1.Cluster {
+	rollback(domain.TaskStepInitClusterConfig, "", "start")
+	if config.SystemImages == nil || config.SystemImages.Kubernetes == "" {
+		rollback(domain.TaskStepInitClusterConfig, "the system images of the target version are required", "failure")
+		return nil
+	}
+	rkecluster, rkeConfig, filePath, err := r.loadClusterConfig(config.ClusterID)
+	if err != nil {
+		logrus.Errorf("load cluster %s config failure %s", config.ClusterID, err.Error())
+		rollback(domain.TaskStepInitClusterConfig, err.Error(), "failure")
+		return nil
+	}
+	defer r.releaseSSHKey(rkecluster.Name)
+	dialersOptions := r.dialer(rkecluster, rkeConfig).dialersOptions()
+	clusterStatPath := path.Dir(filePath)
+
+	// set upgrade log out
+	logPath := fmt.Sprintf("%s/create.log", clusterStatPath)
+	// save old log
+	_ = os.Rename(logPath, logPath+"."+time.Now().Format(time.RFC3339))
+	writer, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE, 0755)
+	if err != nil {
+		logrus.Errorf("open create cluster log file %s failure %s", logPath, err.Error())
+	}
+	logger := logrus.New()
+	if writer != nil {
+		defer writer.Close()
+		logger.Out = writer
+	}
+	progress := newUpgradeProgress(logger, rkeConfig.Nodes, rollback)
+	ctx = log.SetLogger(ctx, progress)
+	flags := cluster.GetExternalFlags(false, false, false, false, "", filePath)
+	rollback(domain.TaskStepInitClusterConfig, "", "success")
+
+	if cancelled(ctx) {
+		return nil
+	}
+	// snapshot etcd with the current config, it can be restored if the upgrade failure
+	rollback(domain.TaskStepSnapshotEtcd, "", "start")
+	snapshotName := fmt.Sprintf("%s-upgrade-%s", rkecluster.Name, time.Now().Format("20060102150405"))
+	if err := cmd.SnapshotSaveEtcdHosts(ctx, rkeConfig, dialersOptions, flags, snapshotName); err != nil {
+		rollback(domain.TaskStepSnapshotEtcd, err.Error(), "failure")
+		return nil
+	}
+	rollback(domain.TaskStepSnapshotEtcd, snapshotName, "success")
+
+	rollback(domain.TaskStepUpgradeKubernetes, config.KubernetesVersion, "start")
+	rkeConfig.Version = config.KubernetesVersion
+	rkeConfig.SystemImages = *config.SystemImages
+	if config.UpgradeStrategy != nil {
+		rkeConfig.UpgradeStrategy = config.UpgradeStrategy
+	}
+	if err := writeRKEConfig(filePath, rkeConfig); err != nil {
+		logrus.Errorf("write cluster %s config failure %s", rkecluster.Name, err.Error())
+		rollback(domain.TaskStepUpgradeKubernetes, err.Error(), "failure")
+		return nil
+	}
+	if err := cmd.ClusterInit(ctx, rkeConfig, dialersOptions, flags); err != nil {
+		rollback(domain.TaskStepUpgradeKubernetes, err.Error(), "failure")
+		restoreRKEConfig(filePath)
+		return nil
+	}
+
+	// restore the old state if upgrade failure, the nodes not upgraded keep serving
+	oldState := rkecluster.Stats
+	rkecluster.Stats = v1alpha1.UpgradingState
+	if err := r.Repo.Update(rkecluster); err != nil {
+		logrus.Errorf("update rke cluster %s state failure %s", rkecluster.Name, err.Error())
+	}
+	progress.Start()
+	APIURL, _, _, _, configs, err := r.ClusterUp(ctx, dialersOptions, flags, map[string]interface{}{})
+	if err != nil {
+		rkecluster.Stats = oldState
+		_ = r.Repo.Update(rkecluster)
+		restoreRKEConfig(filePath)
+		rollback(domain.TaskStepUpgradeKubernetes, err.Error(), "failure")
+		return nil
+	}
+	progress.Complete(config.KubernetesVersion)
+	rkecluster.KubeConfig = configs[pki.KubeAdminCertName].Config
+	rkecluster.APIURL = APIURL
+	rkecluster.KubernetesVersion = config.KubernetesVersion
+	rkecluster.Stats = v1alpha1.RunningState
+	if err := r.Repo.Update(rkecluster); err != nil {
+		logrus.Errorf("update rke cluster %s state failure %s", rkecluster.Name, err.Error())
+	}
+	rollback(domain.TaskStepUpgradeKubernetes, "", "success")
+	clu, _ := r.DescribeCluster(rkecluster.ClusterID)
+	return clu
+}
+
+// upgradeProgress writes the rke logs and reports the upgrade progress of every node as the UpgradeNode events
+// of the node, such as UpgradeNode/192.168.0.2.
+type upgradeProgress struct {
+	*logrus.Logger
+	rollback func(step domain.TaskStep, message, status string)
+
+	lock    sync.Mutex
+	started bool
+	// nodes hostname override or address to node address
+	nodes map[string]string
+	state map[string]string
+}
+
+func newUpgradeProgress(logger *logrus.Logger, nodes []v3.RKEConfigNode, rollback func(step domain.TaskStep, message, status string)) *upgradeProgress {
+	p := &upgradeProgress{
+		Logger:   logger,
+		rollback: rollback,
+		nodes:    make(map[string]string),
+		state:    make(map[string]string),
+	}
+	for _, node := range nodes {
+		p.nodes[node.Address] = node.Address
+		if node.HostnameOverride != "" {
+			p.nodes[node.HostnameOverride] = node.Address
+		}
+	}
+	return p
+}
+
+// Start starts reporting the progress of nodes
+func (p *upgradeProgress) Start() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.started = true
+}
+
+// Complete reports the nodes that have not been reported as success
+func (p *upgradeProgress) Complete(version string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	reported := make(map[string]bool)
+	for _, node := range p.nodes {
+		if reported[node] || p.state[node] == "success" {
+			continue
+		}
+		reported[node] = true
+		p.state[node] = "success"
+		p.rollback(domain.TaskStepUpgradeNode.ForNode(node), fmt.Sprintf("[%s] upgraded to %s", node, version), "success")
+	}
+	p.started = false
+}
+
+func (p *upgradeProgress) Infof(msg string, args ...interface{}) {
+	p.Logger.Infof(msg, args...)
+	p.report(fmt.Sprintf(msg, args...), args)
+}
+
+func (p *upgradeProgress) report(message string, args []interface{}) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !p.started {
+		return
+	}
+	var node string
+	for _, arg := range args {
+		if name, ok := arg.(string); ok && p.nodes[name] != "" {
+			node = p.nodes[name]
+			break
+		}
+	}
+	if node == "" || p.state[node] == "success" {
+		return
+	}
+	var status string
+	kubelet := fmt.Sprintf("service [%s] on host", services.KubeletContainerName)
+	switch {
+	case strings.Contains(message, "Upgrade not required"),
+		strings.Contains(message, kubelet) && strings.HasSuffix(message, "is healthy"):
+		status = "success"
+	case strings.Contains(message, "Upgrading"),
+		strings.Contains(message, "Start Healthcheck on "+kubelet):
+		status = "start"
+	default:
+		return
+	}
+	if p.state[node] == status {
+		return
+	}
+	p.state[node] = status
+	p.rollback(domain.TaskStepUpgradeNode.ForNode(node), fmt.Sprintf("[%s] %s", node, message), status)
+}
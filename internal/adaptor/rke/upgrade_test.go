@@ -0,0 +1,61 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package rke
+
+import (
+	"io/ioutil"
+	"testing"
+
+	v3 "github.com/rancher/rke/types"
+	"github.com/sirupsen/logrus"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
+)
+
+func TestUpgradeProgress(t *testing.T) {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	events := make(map[domain.TaskStep][]string)
+	progress := newUpgradeProgress(logger, []v3.RKEConfigNode{
+		{Address: "192.168.56.104", HostnameOverride: "node1"},
+		{Address: "192.168.56.103"},
+	}, func(step domain.TaskStep, message, status string) {
+		events[step] = append(events[step], status)
+	})
+
+	// the logs before upgrade are not reported
+	progress.Infof("[etcd] Running snapshot save once on host [%s]", "192.168.56.104")
+	if len(events) != 0 {
+		t.Fatalf("the logs before upgrade should not be reported")
+	}
+
+	progress.Start()
+	progress.Infof("Upgrading controlplane components for control host %v", "node1")
+	progress.Infof("Upgrading workerplane components for control host %v", "node1")
+	progress.Infof("[healthcheck] service [%s] on host [%s] is healthy", "kubelet", "192.168.56.104")
+	progress.Infof("[healthcheck] service [%s] on host [%s] is healthy", "kubelet", "192.168.56.104")
+	progress.Complete("v1.20.15-rke")
+
+	// the events of each node are kept apart
+	if got := events["UpgradeNode/192.168.56.104"]; len(got) != 2 || got[0] != "start" || got[1] != "success" {
+		t.Fatalf("unexpected upgrade node events %v", got)
+	}
+	if got := events["UpgradeNode/192.168.56.103"]; len(got) != 1 || got[0] != "success" {
+		t.Fatalf("unexpected upgrade node events %v", got)
+	}
+}
@@ -0,0 +1,198 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package tke
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	cdb "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/cdb/v20170320"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/pkg/util"
+)
+
+// cdb instance status, 1 is running
+const dbInstanceRunning = 1
+
+func (t *tkeAdaptor) cdbClient(regionID string) (*cdb.Client, error) {
+	if regionID == "" {
+		return nil, errRegionRequired
+	}
+	return cdb.NewClient(t.credential, regionID, t.clientProfile())
+}
+
+func dbInstanceName(clusterID string) string {
+	return "wutong-region-db_" + clusterID
+}
+
+// DescribeDBInstance describe the region db instance of the cluster
+func (t *tkeAdaptor) DescribeDBInstance(clusterID, regionID string) (*cdb.InstanceInfo, error) {
+	client, err := t.cdbClient(regionID)
+	if err != nil {
+		return nil, err
+	}
+	req := cdb.NewDescribeDBInstancesRequest()
+	req.InstanceNames = common.StringPtrs([]string{dbInstanceName(clusterID)})
+	res, err := client.DescribeDBInstances(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, ins := range res.Response.Items {
+		if toString(ins.InstanceName) == dbInstanceName(clusterID) {
+			return ins, nil
+		}
+	}
+	return nil, nil
+}
+
+// CreateDBInstance create postpaid mysql instance
+func (t *tkeAdaptor) CreateDBInstance(db *v1alpha1.Database) (string, error) {
+	client, err := t.cdbClient(db.RegionID)
+	if err != nil {
+		return "", err
+	}
+	req := cdb.NewCreateDBInstanceHourRequest()
+	req.GoodsNum = common.Int64Ptr(1)
+	req.Memory = common.Int64Ptr(1000)
+	req.Volume = common.Int64Ptr(50)
+	req.EngineVersion = common.StringPtr("5.7")
+	req.UniqVpcId = common.StringPtr(db.VPCID)
+	req.UniqSubnetId = common.StringPtr(db.VSwitchID)
+	req.Zone = common.StringPtr(db.ZoneID)
+	req.InstanceName = common.StringPtr(dbInstanceName(db.ClusterID))
+	req.ClientToken = common.StringPtr(db.ClusterID)
+	res, err := client.CreateDBInstanceHour(req)
+	if err != nil {
+		return "", err
+	}
+	if len(res.Response.InstanceIds) == 0 {
+		return "", fmt.Errorf("create db instance failure, instance id is empty")
+	}
+	return toString(res.Response.InstanceIds[0]), nil
+}
+
+// WaitingDBInstanceReady waiting db instance running, and initialized if initialized is true
+func (t *tkeAdaptor) WaitingDBInstanceReady(ctx context.Context, clusterID, regionID string, initialized bool) (*cdb.InstanceInfo, error) {
+	ticker := time.NewTicker(time.Second * 10)
+	defer ticker.Stop()
+	for {
+		ins, err := t.DescribeDBInstance(clusterID, regionID)
+		if err != nil {
+			logrus.Errorf("describe db instance failure %s", err.Error())
+		}
+		if ins != nil && toInt64(ins.Status) == dbInstanceRunning && (!initialized || toInt64(ins.InitFlag) == 1) {
+			return ins, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting db instance ready timeout")
+		case <-ticker.C:
+		}
+	}
+}
+
+// CreateDB create region db, the exist instance and account are reused.
+func (t *tkeAdaptor) CreateDB(ctx context.Context, db *v1alpha1.Database) error {
+	client, err := t.cdbClient(db.RegionID)
+	if err != nil {
+		return err
+	}
+	ins, err := t.DescribeDBInstance(db.ClusterID, db.RegionID)
+	if err != nil {
+		return err
+	}
+	if ins == nil {
+		instanceID, err := t.CreateDBInstance(db)
+		if err != nil {
+			return fmt.Errorf("create db instance failure %w", err)
+		}
+		t.record(&v1alpha1.CloudResource{ClusterID: db.ClusterID, Type: v1alpha1.ResourceTypeRDS, CloudID: instanceID, RegionID: db.RegionID})
+	} else {
+		logrus.Infof("db instance %s for cluster %s is exist, reuse it", toString(ins.InstanceId), db.ClusterID)
+		t.record(&v1alpha1.CloudResource{ClusterID: db.ClusterID, Type: v1alpha1.ResourceTypeRDS, CloudID: toString(ins.InstanceId), RegionID: db.RegionID})
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*30)
+	defer cancel()
+	ins, err = t.WaitingDBInstanceReady(ctx, db.ClusterID, db.RegionID, false)
+	if err != nil {
+		return err
+	}
+	db.InstanceID = toString(ins.InstanceId)
+	db.Host = toString(ins.Vip)
+	db.Port = int(toInt64(ins.Vport))
+	if toInt64(ins.InitFlag) != 1 {
+		req := cdb.NewInitDBInstancesRequest()
+		req.InstanceIds = common.StringPtrs([]string{db.InstanceID})
+		req.NewPassword = common.StringPtr("Wt_" + util.RandString(13))
+		req.Parameters = []*cdb.ParamInfo{
+			{Name: common.StringPtr("character_set_server"), Value: common.StringPtr("utf8mb4")},
+			{Name: common.StringPtr("lower_case_table_names"), Value: common.StringPtr("1")},
+		}
+		if _, err := client.InitDBInstances(req); err != nil {
+			return fmt.Errorf("init db instance failure %w", err)
+		}
+		if _, err := t.WaitingDBInstanceReady(ctx, db.ClusterID, db.RegionID, true); err != nil {
+			return err
+		}
+	}
+	// the password can not be read back, so it is reset when the account exists
+	db.Password = "Wt_" + util.RandString(13)
+	account := &cdb.Account{User: common.StringPtr(db.UserName), Host: common.StringPtr("%")}
+	accountsReq := cdb.NewDescribeAccountsRequest()
+	accountsReq.InstanceId = common.StringPtr(db.InstanceID)
+	accountsReq.AccountRegexp = common.StringPtr("^" + db.UserName + "$")
+	accounts, err := client.DescribeAccounts(accountsReq)
+	if err != nil {
+		return fmt.Errorf("describe db accounts failure %w", err)
+	}
+	if len(accounts.Response.Items) > 0 {
+		req := cdb.NewModifyAccountPasswordRequest()
+		req.InstanceId = common.StringPtr(db.InstanceID)
+		req.NewPassword = common.StringPtr(db.Password)
+		req.Accounts = []*cdb.Account{account}
+		if _, err := client.ModifyAccountPassword(req); err != nil {
+			return fmt.Errorf("reset db account password failure %w", err)
+		}
+	} else {
+		req := cdb.NewCreateAccountsRequest()
+		req.InstanceId = common.StringPtr(db.InstanceID)
+		req.Password = common.StringPtr(db.Password)
+		req.Accounts = []*cdb.Account{account}
+		if _, err := client.CreateAccounts(req); err != nil {
+			return fmt.Errorf("create db account failure %w", err)
+		}
+	}
+	// cdb api can not create database, so the account is granted global privileges
+	// and the region creates the database itself.
+	req := cdb.NewModifyAccountPrivilegesRequest()
+	req.InstanceId = common.StringPtr(db.InstanceID)
+	req.Accounts = []*cdb.Account{account}
+	req.GlobalPrivileges = common.StringPtrs([]string{
+		"SELECT", "INSERT", "UPDATE", "DELETE", "CREATE", "DROP", "REFERENCES", "INDEX",
+		"ALTER", "CREATE TEMPORARY TABLES", "LOCK TABLES", "EXECUTE", "CREATE VIEW", "SHOW VIEW",
+		"CREATE ROUTINE", "ALTER ROUTINE", "EVENT", "TRIGGER", "SHOW DATABASES",
+	})
+	if _, err := client.ModifyAccountPrivileges(req); err != nil {
+		return fmt.Errorf("grant db account privileges failure %w", err)
+	}
+	return nil
+}
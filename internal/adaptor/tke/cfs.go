@@ -0,0 +1,156 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package tke
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	cfs "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/cfs/v20190719"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+)
+
+func (t *tkeAdaptor) cfsClient(regionID string) (*cfs.Client, error) {
+	if regionID == "" {
+		return nil, errRegionRequired
+	}
+	return cfs.NewClient(t.credential, regionID, t.clientProfile())
+}
+
+func cfsName(clusterID string) string {
+	return "wutong-region-nas_" + clusterID
+}
+
+// CreateCFS create nfs file system in the vpc of the cluster, the exist file system is reused
+func (t *tkeAdaptor) CreateCFS(ctx context.Context, clusterID, regionID, zoneID, vpcID, subnetID string) (string, error) {
+	client, err := t.cfsClient(regionID)
+	if err != nil {
+		return "", err
+	}
+	describeReq := cfs.NewDescribeCfsFileSystemsRequest()
+	describeReq.VpcId = common.StringPtr(vpcID)
+	describeRes, err := client.DescribeCfsFileSystems(describeReq)
+	if err != nil {
+		return "", err
+	}
+	var fsID string
+	for _, fs := range describeRes.Response.FileSystems {
+		if toString(fs.FsName) == cfsName(clusterID) {
+			fsID = toString(fs.FileSystemId)
+			break
+		}
+	}
+	if fsID == "" {
+		req := cfs.NewCreateCfsFileSystemRequest()
+		req.Zone = common.StringPtr(zoneID)
+		req.NetInterface = common.StringPtr("VPC")
+		req.PGroupId = common.StringPtr("pgroupbasic")
+		req.Protocol = common.StringPtr("NFS")
+		req.StorageType = common.StringPtr("SD")
+		req.VpcId = common.StringPtr(vpcID)
+		req.SubnetId = common.StringPtr(subnetID)
+		req.FsName = common.StringPtr(cfsName(clusterID))
+		res, err := client.CreateCfsFileSystem(req)
+		if err != nil {
+			return "", err
+		}
+		fsID = toString(res.Response.FileSystemId)
+	}
+	t.record(&v1alpha1.CloudResource{ClusterID: clusterID, Type: v1alpha1.ResourceTypeNAS, CloudID: fsID, RegionID: regionID})
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*10)
+	defer cancel()
+	if err := t.waitingCFSAvailable(ctx, client, fsID); err != nil {
+		return "", err
+	}
+	return fsID, nil
+}
+
+func (t *tkeAdaptor) waitingCFSAvailable(ctx context.Context, client *cfs.Client, fsID string) error {
+	ticker := time.NewTicker(time.Second * 5)
+	defer ticker.Stop()
+	for {
+		req := cfs.NewDescribeCfsFileSystemsRequest()
+		req.FileSystemId = common.StringPtr(fsID)
+		res, err := client.DescribeCfsFileSystems(req)
+		if err != nil {
+			logrus.Errorf("describe cfs file system %s failure %s", fsID, err.Error())
+		}
+		if res != nil && len(res.Response.FileSystems) > 0 && toString(res.Response.FileSystems[0].LifeCycleState) == "available" {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting cfs file system %s available timeout", fsID)
+		case <-ticker.C:
+		}
+	}
+}
+
+// GetCFSMountTarget get the nfs server address of the file system
+func (t *tkeAdaptor) GetCFSMountTarget(regionID, fsID string) (string, error) {
+	client, err := t.cfsClient(regionID)
+	if err != nil {
+		return "", err
+	}
+	req := cfs.NewDescribeMountTargetsRequest()
+	req.FileSystemId = common.StringPtr(fsID)
+	res, err := client.DescribeMountTargets(req)
+	if err != nil {
+		return "", err
+	}
+	for _, mt := range res.Response.MountTargets {
+		if toString(mt.LifeCycleState) == "available" && toString(mt.IpAddress) != "" {
+			return toString(mt.IpAddress), nil
+		}
+	}
+	return "", fmt.Errorf("file system %s has no available mount target", fsID)
+}
+
+// GetNASInfo get cfs file system info
+func (t *tkeAdaptor) GetNASInfo(regionID, fileSystemID string) (*v1alpha1.NasStorageInfo, error) {
+	client, err := t.cfsClient(regionID)
+	if err != nil {
+		return nil, err
+	}
+	req := cfs.NewDescribeCfsFileSystemsRequest()
+	req.FileSystemId = common.StringPtr(fileSystemID)
+	res, err := client.DescribeCfsFileSystems(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Response.FileSystems) == 0 {
+		return nil, fmt.Errorf("file system %s not found", fileSystemID)
+	}
+	fs := res.Response.FileSystems[0]
+	return &v1alpha1.NasStorageInfo{
+		FileSystemID: toString(fs.FileSystemId),
+		Description:  toString(fs.FsName),
+		CreateTime:   toString(fs.CreationTime),
+		RegionID:     regionID,
+		ProtocolType: toString(fs.Protocol),
+		StorageType:  toString(fs.StorageType),
+		MeteredSize:  int64(toUint64(fs.SizeByte)),
+		ZoneID:       toString(fs.Zone),
+		Capacity:     int64(toUint64(fs.SizeLimit)),
+		Status:       toString(fs.LifeCycleState),
+	}, nil
+}
@@ -0,0 +1,206 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package tke
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	clb "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/clb/v20180317"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/pkg/util/retryutil"
+)
+
+// the ports of wutong gateway
+var gatewayPorts = []int64{80, 443, 8443, 6060}
+
+func (t *tkeAdaptor) clbClient(regionID string) (*clb.Client, error) {
+	if regionID == "" {
+		return nil, errRegionRequired
+	}
+	return clb.NewClient(t.credential, regionID, t.clientProfile())
+}
+
+func loadBalancerName(clusterID string) string {
+	return "wutong-region-lb_" + clusterID
+}
+
+// inOperatingPolicy clb operations are asynchronous, the load balancer is locked until the last operation done.
+var inOperatingPolicy = retryutil.Policy{MaxAttempts: 10, InitialInterval: time.Second, MaxInterval: 5 * time.Second, Multiplier: 2,
+	Jitter: 0.2, Retryable: retryutil.WithCodes("FailedOperation.ResourceInOperating")}
+
+func retryInOperating(ctx context.Context, do func() error) error {
+	return inOperatingPolicy.Do(ctx, nil, do)
+}
+
+func (t *tkeAdaptor) slbConver(regionID string, lb *clb.LoadBalancer) *v1alpha1.LoadBalancer {
+	re := &v1alpha1.LoadBalancer{
+		LoadBalancerID:     toString(lb.LoadBalancerId),
+		LoadBalancerName:   toString(lb.LoadBalancerName),
+		LoadBalancerStatus: fmt.Sprintf("%d", toUint64(lb.Status)),
+		AddressType:        "internet",
+		RegionID:           regionID,
+		VSwitchID:          toString(lb.SubnetId),
+		VpcID:              toString(lb.VpcId),
+		CreateTime:         toString(lb.CreateTime),
+		AddressIPVersion:   toString(lb.AddressIPVersion),
+	}
+	if len(lb.LoadBalancerVips) > 0 {
+		re.Address = toString(lb.LoadBalancerVips[0])
+	}
+	if lb.MasterZone != nil {
+		re.MasterZoneID = toString(lb.MasterZone.Zone)
+	}
+	return re
+}
+
+func (t *tkeAdaptor) describeLoadBalancer(client *clb.Client, clusterID string) (*clb.LoadBalancer, error) {
+	req := clb.NewDescribeLoadBalancersRequest()
+	req.LoadBalancerName = common.StringPtr(loadBalancerName(clusterID))
+	res, err := client.DescribeLoadBalancers(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, lb := range res.Response.LoadBalancerSet {
+		if toString(lb.LoadBalancerName) == loadBalancerName(clusterID) {
+			return lb, nil
+		}
+	}
+	return nil, nil
+}
+
+// CreateLoadBalancer create public load balancer, the exist load balancer is reused
+func (t *tkeAdaptor) CreateLoadBalancer(ctx context.Context, clusterID, regionID, vpcID string) (*v1alpha1.LoadBalancer, error) {
+	client, err := t.clbClient(regionID)
+	if err != nil {
+		return nil, err
+	}
+	lb, err := t.describeLoadBalancer(client, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	if lb == nil {
+		req := clb.NewCreateLoadBalancerRequest()
+		req.LoadBalancerType = common.StringPtr("OPEN")
+		req.LoadBalancerName = common.StringPtr(loadBalancerName(clusterID))
+		req.VpcId = common.StringPtr(vpcID)
+		res, err := client.CreateLoadBalancer(req)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range res.Response.LoadBalancerIds {
+			t.record(&v1alpha1.CloudResource{ClusterID: clusterID, Type: v1alpha1.ResourceTypeSLB, CloudID: toString(id), RegionID: regionID})
+		}
+	} else {
+		t.record(&v1alpha1.CloudResource{ClusterID: clusterID, Type: v1alpha1.ResourceTypeSLB, CloudID: toString(lb.LoadBalancerId), RegionID: regionID})
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
+	defer cancel()
+	ticker := time.NewTicker(time.Second * 3)
+	defer ticker.Stop()
+	for {
+		lb, err = t.describeLoadBalancer(client, clusterID)
+		if err != nil {
+			logrus.Errorf("describe load balancer failure %s", err.Error())
+		}
+		// status 1 means the load balancer is running
+		if lb != nil && toUint64(lb.Status) == 1 && len(lb.LoadBalancerVips) > 0 {
+			return t.slbConver(regionID, lb), nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting load balancer ready timeout")
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t *tkeAdaptor) createTCPListener(ctx context.Context, client *clb.Client, loadBalancerID string, port int64) (string, error) {
+	describeReq := clb.NewDescribeListenersRequest()
+	describeReq.LoadBalancerId = common.StringPtr(loadBalancerID)
+	describeReq.Protocol = common.StringPtr("TCP")
+	describeReq.Port = common.Int64Ptr(port)
+	describeRes, err := client.DescribeListeners(describeReq)
+	if err != nil {
+		return "", err
+	}
+	if len(describeRes.Response.Listeners) > 0 {
+		return toString(describeRes.Response.Listeners[0].ListenerId), nil
+	}
+	req := clb.NewCreateListenerRequest()
+	req.LoadBalancerId = common.StringPtr(loadBalancerID)
+	req.Ports = []*int64{common.Int64Ptr(port)}
+	req.Protocol = common.StringPtr("TCP")
+	req.ListenerNames = common.StringPtrs([]string{fmt.Sprintf("wutong-gateway-nodes-%d", port)})
+	var res *clb.CreateListenerResponse
+	if err := retryInOperating(ctx, func() error {
+		res, err = client.CreateListener(req)
+		return err
+	}); err != nil {
+		return "", err
+	}
+	if len(res.Response.ListenerIds) == 0 {
+		return "", fmt.Errorf("create listener failure, listener id is empty")
+	}
+	return toString(res.Response.ListenerIds[0]), nil
+}
+
+// BoundLoadBalancerToCluster listen gateway ports and register the gateway nodes as targets
+func (t *tkeAdaptor) BoundLoadBalancerToCluster(ctx context.Context, regionID, vpcID, loadBalancerID string, endpoints []string) error {
+	if len(endpoints) == 0 {
+		return fmt.Errorf("gateway node can not be empty")
+	}
+	client, err := t.clbClient(regionID)
+	if err != nil {
+		return err
+	}
+	instanceIDs, err := t.getInstanceIDsByIPs(regionID, vpcID, endpoints)
+	if err != nil {
+		return fmt.Errorf("query gateway instance failure %w", err)
+	}
+	for _, port := range gatewayPorts {
+		listenerID, err := t.createTCPListener(ctx, client, loadBalancerID, port)
+		if err != nil {
+			return fmt.Errorf("create listener %d failure %w", port, err)
+		}
+		req := clb.NewRegisterTargetsRequest()
+		req.LoadBalancerId = common.StringPtr(loadBalancerID)
+		req.ListenerId = common.StringPtr(listenerID)
+		for _, ip := range endpoints {
+			instanceID, ok := instanceIDs[ip]
+			if !ok {
+				return fmt.Errorf("can not find the instance of gateway node %s", ip)
+			}
+			req.Targets = append(req.Targets, &clb.Target{
+				InstanceId: common.StringPtr(instanceID),
+				Port:       common.Int64Ptr(port),
+			})
+		}
+		if err := retryInOperating(ctx, func() error {
+			_, err := client.RegisterTargets(req)
+			return err
+		}); err != nil {
+			return fmt.Errorf("register listener %d targets failure %w", port, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package tke
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	cdb "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/cdb/v20170320"
+	cfs "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/cfs/v20190719"
+	clb "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/clb/v20180317"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+)
+
+// cdb instance status, 4 is isolating and 5 is isolated
+const (
+	dbInstanceIsolating = 4
+	dbInstanceIsolated  = 5
+)
+
+// SetResourceRecorder set the recorder of the cloud resources created by adaptor
+func (t *tkeAdaptor) SetResourceRecorder(recorder adaptor.ResourceRecorder) {
+	t.recorder = recorder
+}
+
+func (t *tkeAdaptor) record(resource *v1alpha1.CloudResource) {
+	if t.recorder != nil {
+		t.recorder(resource)
+	}
+}
+
+// ReleaseResource release the cloud resource created by adaptor.
+// Resource that does not exist is regarded as released.
+func (t *tkeAdaptor) ReleaseResource(ctx context.Context, resource *v1alpha1.CloudResource) error {
+	switch resource.Type {
+	case v1alpha1.ResourceTypeRDS:
+		return t.DeleteDBInstance(ctx, resource.RegionID, resource.CloudID)
+	case v1alpha1.ResourceTypeNAS:
+		return t.DeleteCFS(ctx, resource.RegionID, resource.CloudID)
+	case v1alpha1.ResourceTypeSLB:
+		return t.DeleteLoadBalancer(ctx, resource.RegionID, resource.CloudID)
+	}
+	return fmt.Errorf("resource type %s not support", resource.Type)
+}
+
+// DeleteDBInstance isolate the postpaid mysql instance and offline it
+func (t *tkeAdaptor) DeleteDBInstance(ctx context.Context, regionID, instanceID string) error {
+	client, err := t.cdbClient(regionID)
+	if err != nil {
+		return err
+	}
+	describe := func() (*cdb.InstanceInfo, error) {
+		req := cdb.NewDescribeDBInstancesRequest()
+		req.InstanceIds = common.StringPtrs([]string{instanceID})
+		res, err := client.DescribeDBInstances(req)
+		if err != nil {
+			return nil, err
+		}
+		for _, ins := range res.Response.Items {
+			if toString(ins.InstanceId) == instanceID {
+				return ins, nil
+			}
+		}
+		return nil, nil
+	}
+	ins, err := describe()
+	if err != nil {
+		return fmt.Errorf("describe db instance %s failure %w", instanceID, err)
+	}
+	if ins == nil {
+		logrus.Infof("db instance %s does not exist, regard it as deleted", instanceID)
+		return nil
+	}
+	if toInt64(ins.Status) != dbInstanceIsolated {
+		if toInt64(ins.Status) != dbInstanceIsolating {
+			req := cdb.NewIsolateDBInstanceRequest()
+			req.InstanceId = common.StringPtr(instanceID)
+			if _, err := client.IsolateDBInstance(req); err != nil {
+				return fmt.Errorf("isolate db instance %s failure %w", instanceID, err)
+			}
+		}
+		ctx, cancel := context.WithTimeout(ctx, time.Minute*10)
+		defer cancel()
+		ticker := time.NewTicker(time.Second * 10)
+		defer ticker.Stop()
+		for {
+			ins, err = describe()
+			if err != nil {
+				logrus.Errorf("describe db instance %s failure %s", instanceID, err.Error())
+			}
+			if ins == nil && err == nil {
+				return nil
+			}
+			if ins != nil && toInt64(ins.Status) == dbInstanceIsolated {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("waiting db instance %s isolated timeout", instanceID)
+			case <-ticker.C:
+			}
+		}
+	}
+	req := cdb.NewOfflineIsolatedInstancesRequest()
+	req.InstanceIds = common.StringPtrs([]string{instanceID})
+	if _, err := client.OfflineIsolatedInstances(req); err != nil {
+		return fmt.Errorf("offline db instance %s failure %w", instanceID, err)
+	}
+	return nil
+}
+
+// DeleteCFS delete the mount targets of the file system and the file system
+func (t *tkeAdaptor) DeleteCFS(ctx context.Context, regionID, fsID string) error {
+	client, err := t.cfsClient(regionID)
+	if err != nil {
+		return err
+	}
+	// the file system that does not exist can not be described by its id
+	res, err := client.DescribeCfsFileSystems(cfs.NewDescribeCfsFileSystemsRequest())
+	if err != nil {
+		return fmt.Errorf("describe cfs file systems failure %w", err)
+	}
+	var exist bool
+	for _, fs := range res.Response.FileSystems {
+		if toString(fs.FileSystemId) == fsID {
+			exist = true
+			break
+		}
+	}
+	if !exist {
+		logrus.Infof("cfs file system %s does not exist, regard it as deleted", fsID)
+		return nil
+	}
+	listMountTargets := func() ([]*cfs.MountInfo, error) {
+		req := cfs.NewDescribeMountTargetsRequest()
+		req.FileSystemId = common.StringPtr(fsID)
+		res, err := client.DescribeMountTargets(req)
+		if err != nil {
+			return nil, err
+		}
+		return res.Response.MountTargets, nil
+	}
+	mountTargets, err := listMountTargets()
+	if err != nil {
+		return fmt.Errorf("describe mount targets of %s failure %w", fsID, err)
+	}
+	for _, mt := range mountTargets {
+		req := cfs.NewDeleteMountTargetRequest()
+		req.FileSystemId = common.StringPtr(fsID)
+		req.MountTargetId = mt.MountTargetId
+		if _, err := client.DeleteMountTarget(req); err != nil {
+			return fmt.Errorf("delete mount target %s of %s failure %w", toString(mt.MountTargetId), fsID, err)
+		}
+	}
+	// the file system can be deleted only after its mount targets are deleted
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
+	defer cancel()
+	ticker := time.NewTicker(time.Second * 5)
+	defer ticker.Stop()
+	for len(mountTargets) > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting mount targets of %s deleted timeout", fsID)
+		case <-ticker.C:
+		}
+		if mountTargets, err = listMountTargets(); err != nil {
+			logrus.Errorf("describe mount targets of %s failure %s", fsID, err.Error())
+		}
+	}
+	req := cfs.NewDeleteCfsFileSystemRequest()
+	req.FileSystemId = common.StringPtr(fsID)
+	if _, err := client.DeleteCfsFileSystem(req); err != nil {
+		return fmt.Errorf("delete cfs file system %s failure %w", fsID, err)
+	}
+	return nil
+}
+
+// DeleteLoadBalancer delete the load balancer and its listeners
+func (t *tkeAdaptor) DeleteLoadBalancer(ctx context.Context, regionID, loadBalancerID string) error {
+	client, err := t.clbClient(regionID)
+	if err != nil {
+		return err
+	}
+	describeReq := clb.NewDescribeLoadBalancersRequest()
+	describeReq.LoadBalancerIds = common.StringPtrs([]string{loadBalancerID})
+	describeRes, err := client.DescribeLoadBalancers(describeReq)
+	if err != nil {
+		return fmt.Errorf("describe load balancer %s failure %w", loadBalancerID, err)
+	}
+	if len(describeRes.Response.LoadBalancerSet) == 0 {
+		logrus.Infof("load balancer %s does not exist, regard it as deleted", loadBalancerID)
+		return nil
+	}
+	req := clb.NewDeleteLoadBalancerRequest()
+	req.LoadBalancerIds = common.StringPtrs([]string{loadBalancerID})
+	if err := retryInOperating(ctx, func() error {
+		_, err := client.DeleteLoadBalancer(req)
+		return err
+	}); err != nil {
+		return fmt.Errorf("delete load balancer %s failure %w", loadBalancerID, err)
+	}
+	return nil
+}
+
+func (t *tkeAdaptor) deleteClusterLoadBalancer(ctx context.Context, clusterID, regionID string) error {
+	client, err := t.clbClient(regionID)
+	if err != nil {
+		return err
+	}
+	lb, err := t.describeLoadBalancer(client, clusterID)
+	if err != nil {
+		return fmt.Errorf("describe load balancer of cluster %s failure %w", clusterID, err)
+	}
+	if lb == nil {
+		return nil
+	}
+	return t.DeleteLoadBalancer(ctx, regionID, toString(lb.LoadBalancerId))
+}
+
+func (t *tkeAdaptor) deleteClusterCFS(ctx context.Context, clusterID, regionID string) error {
+	client, err := t.cfsClient(regionID)
+	if err != nil {
+		return err
+	}
+	res, err := client.DescribeCfsFileSystems(cfs.NewDescribeCfsFileSystemsRequest())
+	if err != nil {
+		return fmt.Errorf("describe cfs file systems failure %w", err)
+	}
+	for _, fs := range res.Response.FileSystems {
+		if toString(fs.FsName) == cfsName(clusterID) {
+			return t.DeleteCFS(ctx, regionID, toString(fs.FileSystemId))
+		}
+	}
+	return nil
+}
+
+func (t *tkeAdaptor) deleteClusterDBInstance(ctx context.Context, clusterID, regionID string) error {
+	ins, err := t.DescribeDBInstance(clusterID, regionID)
+	if err != nil {
+		return fmt.Errorf("describe db instance of cluster %s failure %w", clusterID, err)
+	}
+	if ins == nil {
+		return nil
+	}
+	return t.DeleteDBInstance(ctx, regionID, toString(ins.InstanceId))
+}
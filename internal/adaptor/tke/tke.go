@@ -20,171 +20,790 @@ package tke
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/ghodss/yaml"
+	"github.com/sirupsen/logrus"
 	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
 	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/profile"
+	cvm "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/cvm/v20170312"
 	tke "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/tke/v20180525"
 	"github.com/wutong-paas/cloud-adaptor/internal/adaptor"
 	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/internal/datastore"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+	"github.com/wutong-paas/cloud-adaptor/internal/repo"
+	"github.com/wutong-paas/cloud-adaptor/pkg/bcode"
+	"github.com/wutong-paas/cloud-adaptor/pkg/util/retryutil"
 	wutongv1alpha1 "github.com/wutong-paas/wutong-operator/api/v1alpha1"
+	"gorm.io/gorm"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api/latest"
+	clientcmdv1 "k8s.io/client-go/tools/clientcmd/api/v1"
 )
 
 type tkeAdaptor struct {
 	accessKeyID     string
 	accessKeySecret string
-	tkeclient       *tke.Client
+	credential      *common.Credential
+	// endpoint and scheme override the tencent cloud api address of all products,
+	// they are only used to point the adaptor at a fake api server.
+	endpoint string
+	scheme   string
+	// Repo the regions of the clusters, tke api can not query cluster without region
+	Repo     repo.TKEClusterRepository
+	recorder adaptor.ResourceRecorder
 }
 
-// Create create ack adaptor
+// errRegionRequired the region of tencent cloud api is not given
+var errRegionRequired = fmt.Errorf("the region of tencent cloud is required")
+
+func init() {
+	adaptor.Register(&adaptor.Provider{
+		Name: "tke",
+		Capabilities: adaptor.Capabilities{
+			NeedCredentials: true,
+			CreateCluster:   true,
+			ExpansionNode:   true,
+			DeleteCluster:   true,
+			CloudDB:         true,
+			CloudNAS:        true,
+			CloudLB:         true,
+		},
+		Adaptor: (*tkeAdaptor)(nil),
+		New: func(accessKeyID, accessKeySecret string) (adaptor.WutongClusterAdaptor, error) {
+			return Create(accessKeyID, accessKeySecret)
+		},
+	})
+}
+
+// Create create tke adaptor
 func Create(accessKeyID, accessKeySecret string) (adaptor.CloudAdaptor, error) {
-	credential := common.NewCredential(accessKeyID, accessKeySecret)
-	client, err := tke.NewClient(credential, "ap-guangzhou", profile.NewClientProfile())
-	if err != nil {
-		return nil, err
-	}
+	ad := newAdaptor(accessKeyID, accessKeySecret, "", "")
+	ad.Repo = repo.NewTKEClusterRepo(datastore.GetGDB())
+	return ad, nil
+}
+
+func newAdaptor(accessKeyID, accessKeySecret, endpoint, scheme string) *tkeAdaptor {
 	return &tkeAdaptor{
 		accessKeyID:     accessKeyID,
 		accessKeySecret: accessKeySecret,
-		tkeclient:       client,
-	}, nil
-}
-
-func toString(s *string) string {
-	if s == nil {
-		return ""
+		credential:      common.NewCredential(accessKeyID, accessKeySecret),
+		endpoint:        endpoint,
+		scheme:          scheme,
 	}
-	return *s
 }
 
-func (t *tkeAdaptor) ClusterList() ([]*v1alpha1.Cluster, error) {
-	req := tke.NewDescribeClustersRequest()
-	res, err := t.tkeclient.DescribeClusters(req)
-	if err != nil {
-		return nil, fmt.Errorf("query cluster list from tencent api failure %s", err.Error())
+func (t *tkeAdaptor) clientProfile() *profile.ClientProfile {
+	cpf := profile.NewClientProfile()
+	if t.endpoint != "" {
+		cpf.HttpProfile.Endpoint = t.endpoint
 	}
-	var clusters []*v1alpha1.Cluster
-	fmt.Printf("%+v", *res.Response.Clusters[0])
-	for _, c := range res.Response.Clusters {
-		createTime, _ := time.Parse(time.RFC3339, toString(c.CreatedTime))
-		clusters = append(clusters, &v1alpha1.Cluster{
-			Name:           toString(c.ClusterName),
-			ClusterID:      toString(c.ClusterId),
-			Created:        v1alpha1.NewTime(createTime),
-			State:          toString(c.ClusterStatus),
-			ClusterType:    toString(c.ClusterType),
-			CurrentVersion: toString(c.ClusterVersion),
-			VPCID:          toString(c.ClusterNetworkSettings.VpcId),
-			RegionID:       "",
-			NetworkMode:    "",
-			SubnetCIDR:     "",
-			PodCIDR:        toString(c.ClusterNetworkSettings.ClusterCIDR),
-		})
+	if t.scheme != "" {
+		cpf.HttpProfile.Scheme = t.scheme
 	}
-	return clusters, nil
+	return cpf
 }
 
-func (t *tkeAdaptor) DescribeCluster(clusterID string) (*v1alpha1.Cluster, error) {
-	return nil, nil
+func (t *tkeAdaptor) tkeClient(regionID string) (*tke.Client, error) {
+	if regionID == "" {
+		return nil, errRegionRequired
+	}
+	return tke.NewClient(t.credential, regionID, t.clientProfile())
 }
 
-func (t *tkeAdaptor) CreateCluster(v1alpha1.CreateClusterConfig) (*v1alpha1.Cluster, error) {
-	return nil, nil
+func (t *tkeAdaptor) cvmClient(regionID string) (*cvm.Client, error) {
+	if regionID == "" {
+		return nil, errRegionRequired
+	}
+	return cvm.NewClient(t.credential, regionID, t.clientProfile())
 }
 
-// DeleteCluster delete cluster
-func (t *tkeAdaptor) DeleteCluster(clusterID string) error {
-	return nil
+// clusterRegion returns the region the cluster is created in
+func (t *tkeAdaptor) clusterRegion(clusterID string) (string, error) {
+	if clusterID == "" {
+		return "", fmt.Errorf("cluster id can not be empty")
+	}
+	cluster, err := t.Repo.GetCluster(clusterID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", bcode.ErrClusterNotFound
+		}
+		return "", fmt.Errorf("get the region of cluster %s failure %w", clusterID, err)
+	}
+	return cluster.RegionID, nil
 }
 
-func (t *tkeAdaptor) GetKubeConfig(clusterID string) (*v1alpha1.KubeConfig, error) {
-	return nil, nil
+func toString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
 }
 
-func (t *tkeAdaptor) VPCList(regionID string) ([]*v1alpha1.VPC, error) {
-	return nil, nil
+func toInt64(i *int64) int64 {
+	if i == nil {
+		return 0
+	}
+	return *i
 }
 
-func (t *tkeAdaptor) CreateVPC(v *v1alpha1.VPC) error {
-	return nil
+func toUint64(i *uint64) uint64 {
+	if i == nil {
+		return 0
+	}
+	return *i
 }
 
-func (t *tkeAdaptor) DeleteVPC(regionID, vpcID string) error {
-	return nil
+func getInstanceType(set string) []string {
+	types := []string{
+		"S5.LARGE8",
+		"SA2.LARGE8",
+		"S4.LARGE8",
+	}
+	if set != "" {
+		return append([]string{set}, types...)
+	}
+	return types
 }
 
-func (t *tkeAdaptor) DescribeVPC(regionID, vpcID string) (*v1alpha1.VPC, error) {
-	return nil, nil
+func converClusterState(status string) string {
+	switch status {
+	case "Running":
+		return v1alpha1.RunningState
+	case "Creating":
+		return v1alpha1.InitState
+	case "Abnormal":
+		return v1alpha1.OfflineState
+	case "Failed":
+		return v1alpha1.InstallFailed
+	}
+	return strings.ToLower(status)
 }
 
-func (t *tkeAdaptor) CreateVSwitch(v *v1alpha1.VSwitch) error {
-	return nil
+func (t *tkeAdaptor) clusterConver(regionID string, c *tke.Cluster) *v1alpha1.Cluster {
+	createTime, _ := time.Parse("2006-01-02T15:04:05Z", toString(c.CreatedTime))
+	cluster := &v1alpha1.Cluster{
+		Name:              toString(c.ClusterName),
+		ClusterID:         toString(c.ClusterId),
+		Created:           v1alpha1.NewTime(createTime),
+		State:             converClusterState(toString(c.ClusterStatus)),
+		ClusterType:       toString(c.ClusterType),
+		CurrentVersion:    toString(c.ClusterVersion),
+		KubernetesVersion: toString(c.ClusterVersion),
+		RegionID:          regionID,
+		Size:              int(toUint64(c.ClusterNodeNum)),
+		Parameters:        make(map[string]interface{}),
+	}
+	if c.ClusterNetworkSettings != nil {
+		cluster.VPCID = toString(c.ClusterNetworkSettings.VpcId)
+		cluster.PodCIDR = toString(c.ClusterNetworkSettings.ClusterCIDR)
+	}
+	if cluster.State == v1alpha1.InitState {
+		cluster.CreateLogPath = fmt.Sprintf("https://console.cloud.tencent.com/tke2/cluster/sub/list/basic/info?rid=%s&clusterId=%s", regionID, cluster.ClusterID)
+	}
+	return cluster
 }
 
-func (t *tkeAdaptor) DescribeVSwitch(regionID, vswitchID string) (*v1alpha1.VSwitch, error) {
-	return nil, nil
+// CreateWutongKubernetes create wutong kubernetes
+func (t *tkeAdaptor) CreateWutongKubernetes(ctx context.Context, config *v1alpha1.KubernetesClusterConfig, rollback func(step domain.TaskStep, message, status string)) *v1alpha1.Cluster {
+	rollback(domain.TaskStepAllocateResource, "", "start")
+	//Resource type to be selected
+	var selectInstanceType string
+	var zoneID string
+	for _, it := range getInstanceType(config.WorkerResourceType) {
+		zones, err := retryutil.Call(ctx, domain.TaskStepAllocateResource.RetryPolicy(),
+			retryutil.EventNotify(domain.TaskStepAllocateResource, rollback), func() ([]*v1alpha1.AvailableResourceZone, error) {
+				return t.DescribeAvailableResourceZones(config.Region, it)
+			})
+		if err != nil {
+			logrus.Errorf("list available zones failure %s", err.Error())
+		}
+		for _, z := range zones {
+			if z.Status == "Available" {
+				zoneID = z.ZoneID
+				selectInstanceType = it
+				break
+			}
+		}
+		if selectInstanceType != "" && zoneID != "" {
+			break
+		}
+	}
+	if selectInstanceType == "" {
+		rollback(domain.TaskStepAllocateResource, "Unable to find a suitable instance type, it may be that the region is currently sold out.", "failure")
+		return nil
+	}
+	rollback(domain.TaskStepAllocateResource, selectInstanceType, "success")
+	rollback(domain.TaskStepSelectZone, "", "start")
+	rollback(domain.TaskStepSelectZone, zoneID, "success")
+	if config.VpcID == "" {
+		rollback(domain.TaskStepCreateVPC, "", "start")
+		vpc := &v1alpha1.VPC{
+			RegionID:  config.Region,
+			VpcName:   "wutong-default-vpc",
+			CidrBlock: "10.0.0.0/16",
+		}
+		if err := domain.TaskStepCreateVPC.RetryPolicy().Do(ctx, retryutil.EventNotify(domain.TaskStepCreateVPC, rollback), func() error {
+			return t.CreateVPC(vpc)
+		}); err != nil {
+			rollback(domain.TaskStepCreateVPC, err.Error(), "failure")
+			return nil
+		}
+		rollback(domain.TaskStepCreateVPC, vpc.VpcID, "success")
+		config.VpcID = vpc.VpcID
+		rollback(domain.TaskStepCreateVSWitch, "", "start")
+		vswitch := &v1alpha1.VSwitch{
+			RegionID:    vpc.RegionID,
+			VpcID:       vpc.VpcID,
+			CidrBlock:   "10.0.0.0/18",
+			VSwitchName: "wutong-default-vswitch",
+			ZoneID:      zoneID,
+		}
+		if err := domain.TaskStepCreateVSWitch.RetryPolicy().Do(ctx, retryutil.EventNotify(domain.TaskStepCreateVSWitch, rollback), func() error {
+			return t.CreateVSwitch(vswitch)
+		}); err != nil {
+			rollback(domain.TaskStepCreateVSWitch, err.Error(), "failure")
+			return nil
+		}
+		rollback(domain.TaskStepCreateVSWitch, vswitch.VSwitchID, "success")
+		config.VSwitchID = vswitch.VSwitchID
+	}
+	config.InstanceType = selectInstanceType
+	clusterConfig := v1alpha1.GetDefaultTKECreateClusterConfig(*config)
+	clusterConfig.(*v1alpha1.TKEClusterConfig).ZoneID = zoneID
+	rollback(domain.TaskStepCreateCluster, "", "start")
+	cluster, err := retryutil.Call(ctx, domain.TaskStepCreateCluster.RetryPolicy(),
+		retryutil.EventNotify(domain.TaskStepCreateCluster, rollback), func() (*v1alpha1.Cluster, error) {
+			return t.CreateCluster(clusterConfig)
+		})
+	if err != nil {
+		rollback(domain.TaskStepCreateCluster, err.Error(), "failure")
+		return nil
+	}
+	rollback(domain.TaskStepCreateCluster, cluster.ClusterID, "success")
+	return cluster
 }
 
-func (t *tkeAdaptor) DeleteVSwitch(regionID, vswitchID string) error {
-	return nil
-}
+// clusterPageSize is the max page size of the DescribeClusters api
+const clusterPageSize = 100
 
-func (t *tkeAdaptor) ListZones(regionID string) ([]*v1alpha1.Zone, error) {
-	return nil, nil
+// ClusterList list clusters of the regions the clusters are created in
+func (t *tkeAdaptor) ClusterList() ([]*v1alpha1.Cluster, error) {
+	tkeClusters, err := t.Repo.ListCluster()
+	if err != nil {
+		return nil, fmt.Errorf("list the regions of clusters failure %w", err)
+	}
+	var clusters []*v1alpha1.Cluster
+	listed := make(map[string]bool)
+	for _, tkeCluster := range tkeClusters {
+		regionID := tkeCluster.RegionID
+		if listed[regionID] {
+			continue
+		}
+		listed[regionID] = true
+		client, err := t.tkeClient(regionID)
+		if err != nil {
+			return nil, err
+		}
+		// the api returns 20 clusters per page by default, page through all of them
+		// so that a live cluster is never reported as deleted.
+		var offset int64
+		for {
+			req := tke.NewDescribeClustersRequest()
+			req.Offset = common.Int64Ptr(offset)
+			req.Limit = common.Int64Ptr(clusterPageSize)
+			res, err := client.DescribeClusters(req)
+			if err != nil {
+				return nil, fmt.Errorf("query cluster list of region %s from tencent api failure %w", regionID, err)
+			}
+			for _, c := range res.Response.Clusters {
+				clusters = append(clusters, t.clusterConver(regionID, c))
+			}
+			offset += int64(len(res.Response.Clusters))
+			if len(res.Response.Clusters) == 0 || (res.Response.TotalCount != nil && offset >= *res.Response.TotalCount) {
+				break
+			}
+		}
+	}
+	return clusters, nil
 }
 
-func (t *tkeAdaptor) ListInstanceType(regionID string) ([]*v1alpha1.InstanceType, error) {
-	return nil, nil
+// DescribeCluster describe cluster
+func (t *tkeAdaptor) DescribeCluster(clusterID string) (*v1alpha1.Cluster, error) {
+	regionID, err := t.clusterRegion(clusterID)
+	if err != nil {
+		return nil, err
+	}
+	client, err := t.tkeClient(regionID)
+	if err != nil {
+		return nil, err
+	}
+	req := tke.NewDescribeClustersRequest()
+	req.ClusterIds = common.StringPtrs([]string{clusterID})
+	res, err := client.DescribeClusters(req)
+	if err != nil {
+		return nil, fmt.Errorf("query cluster info from tencent api failure %w", err)
+	}
+	if len(res.Response.Clusters) == 0 {
+		return nil, bcode.ErrClusterNotFound
+	}
+	cluster := t.clusterConver(regionID, res.Response.Clusters[0])
+	if cluster.State != v1alpha1.RunningState {
+		return cluster, nil
+	}
+	security, err := t.describeClusterSecurity(regionID, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	if toString(security.Response.ClusterExternalEndpoint) == "" {
+		// tke can not open the extranet endpoint while the cluster is creating,
+		// so open it the first time the running cluster is described.
+		if err := t.openExtranetEndpoint(regionID, clusterID); err != nil {
+			logrus.Warningf("open cluster %s extranet endpoint failure %s", clusterID, err.Error())
+		}
+	} else {
+		cluster.MasterURL.APIServerEndpoint = "https://" + toString(security.Response.ClusterExternalEndpoint)
+	}
+	if toString(security.Response.PgwEndpoint) != "" {
+		cluster.MasterURL.IntranetAPIServerEndpoint = "https://" + toString(security.Response.PgwEndpoint)
+	}
+	// vpc subnet and zone are not returned by cluster api, take them from the worker node
+	instance, err := t.describeClusterWorker(regionID, clusterID)
+	if err != nil {
+		logrus.Warningf("describe cluster %s worker instance failure %s", clusterID, err.Error())
+	}
+	if instance != nil {
+		if instance.VirtualPrivateCloud != nil {
+			cluster.VSwitchID = toString(instance.VirtualPrivateCloud.SubnetId)
+		}
+		if instance.Placement != nil {
+			cluster.ZoneID = toString(instance.Placement.Zone)
+		}
+		if len(instance.SecurityGroupIds) > 0 {
+			cluster.SecurityGroupID = toString(instance.SecurityGroupIds[0])
+		}
+	}
+	return cluster, nil
 }
 
-func (t *tkeAdaptor) CreateDB(*v1alpha1.Database) error {
-	return nil
+func (t *tkeAdaptor) describeClusterSecurity(regionID, clusterID string) (*tke.DescribeClusterSecurityResponse, error) {
+	client, err := t.tkeClient(regionID)
+	if err != nil {
+		return nil, err
+	}
+	req := tke.NewDescribeClusterSecurityRequest()
+	req.ClusterId = common.StringPtr(clusterID)
+	res, err := client.DescribeClusterSecurity(req)
+	if err != nil {
+		return nil, fmt.Errorf("query cluster security info from tencent api failure %w", err)
+	}
+	return res, nil
 }
-func (t *tkeAdaptor) CreateNAS(regionID, zoneID string) (string, error) {
-	return "", nil
+
+func (t *tkeAdaptor) openExtranetEndpoint(regionID, clusterID string) error {
+	client, err := t.tkeClient(regionID)
+	if err != nil {
+		return err
+	}
+	statusReq := tke.NewDescribeClusterEndpointStatusRequest()
+	statusReq.ClusterId = common.StringPtr(clusterID)
+	statusReq.IsExtranet = common.BoolPtr(true)
+	status, err := client.DescribeClusterEndpointStatus(statusReq)
+	if err != nil {
+		return err
+	}
+	if toString(status.Response.Status) != "NotFound" {
+		return nil
+	}
+	req := tke.NewCreateClusterEndpointRequest()
+	req.ClusterId = common.StringPtr(clusterID)
+	req.IsExtranet = common.BoolPtr(true)
+	_, err = client.CreateClusterEndpoint(req)
+	return err
 }
 
-func (t *tkeAdaptor) GetNasZone(regionID string) (string, error) {
-	return "", nil
+func (t *tkeAdaptor) describeClusterWorker(regionID, clusterID string) (*cvm.Instance, error) {
+	client, err := t.tkeClient(regionID)
+	if err != nil {
+		return nil, err
+	}
+	req := tke.NewDescribeClusterInstancesRequest()
+	req.ClusterId = common.StringPtr(clusterID)
+	req.InstanceRole = common.StringPtr("WORKER")
+	req.Limit = common.Int64Ptr(1)
+	res, err := client.DescribeClusterInstances(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Response.InstanceSet) == 0 {
+		return nil, nil
+	}
+	cvmclient, err := t.cvmClient(regionID)
+	if err != nil {
+		return nil, err
+	}
+	insReq := cvm.NewDescribeInstancesRequest()
+	insReq.InstanceIds = []*string{res.Response.InstanceSet[0].InstanceId}
+	insRes, err := cvmclient.DescribeInstances(insReq)
+	if err != nil {
+		return nil, err
+	}
+	if len(insRes.Response.InstanceSet) == 0 {
+		return nil, nil
+	}
+	return insRes.Response.InstanceSet[0], nil
 }
 
-func (t *tkeAdaptor) GetNASInfo(regionID, fileSystemID string) (*v1alpha1.NasStorageInfo, error) {
-	return nil, nil
+func (t *tkeAdaptor) runInstancesPara(zoneID, vpcID, subnetID string, config *v1alpha1.TKEClusterConfig, count int) string {
+	req := cvm.NewRunInstancesRequest()
+	req.Placement = &cvm.Placement{Zone: common.StringPtr(zoneID)}
+	req.InstanceChargeType = common.StringPtr("POSTPAID_BY_HOUR")
+	req.InstanceType = common.StringPtr(config.WorkerInstanceType)
+	req.SystemDisk = &cvm.SystemDisk{
+		DiskType: common.StringPtr(config.SystemDiskType),
+		DiskSize: common.Int64Ptr(config.SystemDiskSize),
+	}
+	req.DataDisks = []*cvm.DataDisk{
+		{
+			DiskType: common.StringPtr(config.DataDiskType),
+			DiskSize: common.Int64Ptr(config.DataDiskSize),
+		},
+	}
+	req.VirtualPrivateCloud = &cvm.VirtualPrivateCloud{
+		VpcId:    common.StringPtr(vpcID),
+		SubnetId: common.StringPtr(subnetID),
+	}
+	req.InternetAccessible = &cvm.InternetAccessible{
+		InternetChargeType:      common.StringPtr("TRAFFIC_POSTPAID_BY_HOUR"),
+		InternetMaxBandwidthOut: common.Int64Ptr(config.BandwidthOut),
+		PublicIpAssigned:        common.BoolPtr(true),
+	}
+	req.InstanceCount = common.Int64Ptr(int64(count))
+	req.LoginSettings = &cvm.LoginSettings{Password: common.StringPtr(config.LoginPassword)}
+	return req.ToJsonString()
 }
 
-func (t *tkeAdaptor) CreateNASMountTarget(regionID, fileSystemID, VpcID, VSwitchID string) (string, error) {
-	return "", nil
+func instanceAdvancedSettings(config *v1alpha1.TKEClusterConfig) *tke.InstanceAdvancedSettings {
+	// mount the cbs data disk as the docker graph path
+	return &tke.InstanceAdvancedSettings{
+		DockerGraphPath: common.StringPtr(config.DockerGraphPath),
+		DataDisks: []*tke.DataDisk{
+			{
+				DiskType:           common.StringPtr(config.DataDiskType),
+				FileSystem:         common.StringPtr("ext4"),
+				DiskSize:           common.Int64Ptr(config.DataDiskSize),
+				AutoFormatAndMount: common.BoolPtr(true),
+				MountTarget:        common.StringPtr(config.DockerGraphPath),
+			},
+		},
+	}
 }
 
-func (t *tkeAdaptor) CreateLoadBalancer(regionID string) (*v1alpha1.LoadBalancer, error) {
-	return nil, nil
+// CreateCluster create managed tke cluster
+func (t *tkeAdaptor) CreateCluster(config v1alpha1.CreateClusterConfig) (*v1alpha1.Cluster, error) {
+	tkeConfig, ok := config.(*v1alpha1.TKEClusterConfig)
+	if !ok {
+		return nil, fmt.Errorf("cluster config is not tke cluster config")
+	}
+	client, err := t.tkeClient(tkeConfig.RegionID)
+	if err != nil {
+		return nil, err
+	}
+	req := tke.NewCreateClusterRequest()
+	req.ClusterType = common.StringPtr("MANAGED_CLUSTER")
+	req.ClusterCIDRSettings = &tke.ClusterCIDRSettings{
+		ClusterCIDR:   common.StringPtr(tkeConfig.ClusterCIDR),
+		MaxNodePodNum: common.Uint64Ptr(tkeConfig.MaxNodePodNum),
+	}
+	if tkeConfig.ServiceCIDR != "" {
+		req.ClusterCIDRSettings.ServiceCIDR = common.StringPtr(tkeConfig.ServiceCIDR)
+	} else {
+		req.ClusterCIDRSettings.MaxClusterServiceNum = common.Uint64Ptr(tkeConfig.MaxServiceNum)
+	}
+	req.ClusterBasicSettings = &tke.ClusterBasicSettings{
+		ClusterOs:      common.StringPtr(tkeConfig.ClusterOS),
+		ClusterVersion: common.StringPtr(tkeConfig.KubernetesVersion),
+		ClusterName:    common.StringPtr(tkeConfig.Name),
+		VpcId:          common.StringPtr(tkeConfig.VPCID),
+	}
+	req.ClusterAdvancedSettings = &tke.ClusterAdvancedSettings{
+		IPVS:             common.BoolPtr(true),
+		ContainerRuntime: common.StringPtr(tkeConfig.ContainerRuntime),
+	}
+	req.RunInstancesForNode = []*tke.RunInstancesForNode{
+		{
+			NodeRole:         common.StringPtr("WORKER"),
+			RunInstancesPara: []*string{common.StringPtr(t.runInstancesPara(tkeConfig.ZoneID, tkeConfig.VPCID, tkeConfig.SubnetID, tkeConfig, tkeConfig.NumOfNodes))},
+		},
+	}
+	req.InstanceAdvancedSettings = instanceAdvancedSettings(tkeConfig)
+	res, err := client.CreateCluster(req)
+	if err != nil {
+		return nil, fmt.Errorf("create cluster from tencent api failure %w", err)
+	}
+	clusterID := toString(res.Response.ClusterId)
+	if err := t.Repo.Create(&model.TKECluster{Name: tkeConfig.Name, ClusterID: clusterID, RegionID: tkeConfig.RegionID}); err != nil {
+		return nil, fmt.Errorf("cluster %s is created in region %s, but save its region failure %w", clusterID, tkeConfig.RegionID, err)
+	}
+	return &v1alpha1.Cluster{
+		Name:              tkeConfig.Name,
+		ClusterID:         clusterID,
+		State:             v1alpha1.InitState,
+		RegionID:          tkeConfig.RegionID,
+		ZoneID:            tkeConfig.ZoneID,
+		VPCID:             tkeConfig.VPCID,
+		VSwitchID:         tkeConfig.SubnetID,
+		PodCIDR:           tkeConfig.ClusterCIDR,
+		KubernetesVersion: tkeConfig.KubernetesVersion,
+	}, nil
 }
 
-func (t *tkeAdaptor) BoundLoadBalancerToCluster(regionID, VpcID, loadBalancerID string, endpoints []string) error {
-	return nil
+// DeleteCluster delete cluster and the cloud resources created for it. The clusters are deleted by
+// the destroy task, see DestroyCluster.
+func (t *tkeAdaptor) DeleteCluster(clusterID string) error {
+	var err error
+	t.DestroyCluster(context.Background(), &v1alpha1.DestroyCluster{Provider: "tke", ClusterID: clusterID},
+		func(step domain.TaskStep, message, status string) {
+			if status == "failure" {
+				err = fmt.Errorf("%s failure %s", step, message)
+			}
+		})
+	return err
 }
 
-// init wutong region
-func (t *tkeAdaptor) InitWutongRegion(initConfig *v1alpha1.WutongInitConfig) error {
-	return nil
+// DestroyCluster delete the cluster and terminate the worker instances, then the load balancer, cfs and cdb
+// created by GetWutongInitConfig. The cluster that does not exist is regarded as deleted, so the task can be
+// retried after it failed to delete the resources.
+func (t *tkeAdaptor) DestroyCluster(ctx context.Context, config *v1alpha1.DestroyCluster, rollback func(step domain.TaskStep, message, status string)) {
+	rollback(domain.TaskStepInitClusterConfig, "", "start")
+	regionID, err := t.clusterRegion(config.ClusterID)
+	if err != nil {
+		rollback(domain.TaskStepInitClusterConfig, err.Error(), "failure")
+		return
+	}
+	rollback(domain.TaskStepInitClusterConfig, "", "success")
+
+	rollback(domain.TaskStepDeleteCluster, "", "start")
+	if err := t.deleteCluster(regionID, config.ClusterID); err != nil {
+		rollback(domain.TaskStepDeleteCluster, err.Error(), "failure")
+		return
+	}
+	rollback(domain.TaskStepDeleteCluster, "", "success")
+
+	// resources created by GetWutongInitConfig
+	deletions := []struct {
+		step   domain.TaskStep
+		delete func(ctx context.Context, clusterID, regionID string) error
+	}{
+		{domain.TaskStepDeleteLoadBalancer, t.deleteClusterLoadBalancer},
+		{domain.TaskStepDeleteNAS, t.deleteClusterCFS},
+		{domain.TaskStepDeleteRDS, t.deleteClusterDBInstance},
+	}
+	for _, deletion := range deletions {
+		if ctx.Err() != nil {
+			return
+		}
+		rollback(deletion.step, "", "start")
+		err := deletion.step.RetryPolicy().Do(ctx, retryutil.EventNotify(deletion.step, rollback), func() error {
+			return deletion.delete(ctx, config.ClusterID, regionID)
+		})
+		if err != nil {
+			rollback(deletion.step, err.Error(), "failure")
+			return
+		}
+		rollback(deletion.step, "", "success")
+	}
+	// the region of cluster is needed by the retried task until all resources are deleted
+	if err := t.Repo.DeleteCluster(config.ClusterID); err != nil {
+		rollback(domain.TaskStepDestroyCluster, err.Error(), "failure")
+		return
+	}
+	rollback(domain.TaskStepDestroyCluster, config.ClusterID, "success")
 }
 
-func (t *tkeAdaptor) SetSecurityGroup(regionID, securityGroupID string) error {
+func (t *tkeAdaptor) deleteCluster(regionID, clusterID string) error {
+	client, err := t.tkeClient(regionID)
+	if err != nil {
+		return err
+	}
+	describeReq := tke.NewDescribeClustersRequest()
+	describeReq.ClusterIds = common.StringPtrs([]string{clusterID})
+	describeRes, err := client.DescribeClusters(describeReq)
+	if err != nil {
+		return fmt.Errorf("query cluster info from tencent api failure %w", err)
+	}
+	if len(describeRes.Response.Clusters) == 0 {
+		logrus.Infof("cluster %s does not exist, regard it as deleted", clusterID)
+		return nil
+	}
+	req := tke.NewDeleteClusterRequest()
+	req.ClusterId = common.StringPtr(clusterID)
+	req.InstanceDeleteMode = common.StringPtr("terminate")
+	if _, err := client.DeleteCluster(req); err != nil {
+		return fmt.Errorf("delete cluster from tencent api failure %w", err)
+	}
 	return nil
 }
 
-func (t *tkeAdaptor) DescribeAvailableResourceZones(regionID, InstanceType string) ([]*v1alpha1.AvailableResourceZone, error) {
-	return nil, nil
+// GetKubeConfig get kube config, the server is replaced with the extranet endpoint
+func (t *tkeAdaptor) GetKubeConfig(clusterID string) (*v1alpha1.KubeConfig, error) {
+	regionID, err := t.clusterRegion(clusterID)
+	if err != nil {
+		return nil, err
+	}
+	security, err := t.describeClusterSecurity(regionID, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	if toString(security.Response.Kubeconfig) == "" {
+		return nil, fmt.Errorf("kube config of cluster %s is empty", clusterID)
+	}
+	endpoint := toString(security.Response.ClusterExternalEndpoint)
+	if endpoint == "" {
+		return nil, fmt.Errorf("cluster %s extranet endpoint is not open", clusterID)
+	}
+	config, err := clientcmd.Load([]byte(toString(security.Response.Kubeconfig)))
+	if err != nil {
+		return nil, fmt.Errorf("load kube config failure %w", err)
+	}
+	for _, cluster := range config.Clusters {
+		// the certificate of apiserver is issued to the cluster domain
+		cluster.Server = "https://" + endpoint
+		cluster.TLSServerName = toString(security.Response.Domain)
+	}
+	var v1Config clientcmdv1.Config
+	if err := latest.Scheme.Convert(config, &v1Config, nil); err != nil {
+		return nil, fmt.Errorf("convert kube config failure %w", err)
+	}
+	v1Config.APIVersion, v1Config.Kind = "v1", "Config"
+	out, err := yaml.Marshal(v1Config)
+	if err != nil {
+		return nil, fmt.Errorf("marshal kube config failure %w", err)
+	}
+	return &v1alpha1.KubeConfig{Config: string(out)}, nil
 }
 
-func (t *tkeAdaptor) CreateWutongKubernetes(ctx context.Context, config *v1alpha1.KubernetesClusterConfig, rollback func(step, message, status string)) *v1alpha1.Cluster {
-	return nil
-}
-func (t *tkeAdaptor) GetWutongInitConfig(cluster *v1alpha1.Cluster, gateway, chaos []*wutongv1alpha1.K8sNode, rollback func(step, message, status string)) *v1alpha1.WutongInitConfig {
-	return nil
+// GetWutongInitConfig get wutong init config, the resources created are reused by the retries.
+func (t *tkeAdaptor) GetWutongInitConfig(ctx context.Context, cluster *v1alpha1.Cluster, gateway, chaos []*wutongv1alpha1.K8sNode, rollback func(step domain.TaskStep, message, status string)) *v1alpha1.WutongInitConfig {
+	rollback(domain.TaskStepCreateRDS, "", "start")
+	regionDB := &v1alpha1.Database{
+		Name:      "region",
+		RegionID:  cluster.RegionID,
+		UserName:  "wutong_region",
+		VPCID:     cluster.VPCID,
+		ZoneID:    cluster.ZoneID,
+		PodCIDR:   cluster.PodCIDR,
+		VSwitchID: cluster.VSwitchID,
+		ClusterID: cluster.ClusterID,
+	}
+	if err := domain.TaskStepCreateRDS.RetryPolicy().Do(ctx, retryutil.EventNotify(domain.TaskStepCreateRDS, rollback), func() error {
+		return t.CreateDB(ctx, regionDB)
+	}); err != nil {
+		rollback(domain.TaskStepCreateRDS, err.Error(), "failure")
+		return nil
+	}
+	rollback(domain.TaskStepCreateRDS, regionDB.InstanceID, "success")
+
+	rollback(domain.TaskStepCreateNAS, "", "start")
+	fsID, err := retryutil.Call(ctx, domain.TaskStepCreateNAS.RetryPolicy(),
+		retryutil.EventNotify(domain.TaskStepCreateNAS, rollback), func() (string, error) {
+			return t.CreateCFS(ctx, cluster.ClusterID, cluster.RegionID, cluster.ZoneID, cluster.VPCID, cluster.VSwitchID)
+		})
+	if err != nil {
+		rollback(domain.TaskStepCreateNAS, err.Error(), "failure")
+		return nil
+	}
+	rollback(domain.TaskStepCreateNAS, fsID, "success")
+	rollback(domain.TaskStepCreateNASMount, "", "start")
+	mountIP, err := retryutil.Call(ctx, domain.TaskStepCreateNASMount.RetryPolicy(),
+		retryutil.EventNotify(domain.TaskStepCreateNASMount, rollback), func() (string, error) {
+			return t.GetCFSMountTarget(cluster.RegionID, fsID)
+		})
+	if err != nil {
+		rollback(domain.TaskStepCreateNASMount, err.Error(), "failure")
+		return nil
+	}
+	rollback(domain.TaskStepCreateNASMount, mountIP, "success")
+
+	rollback(domain.TaskStepCreateLoadBalancer, "", "start")
+	lb, err := retryutil.Call(ctx, domain.TaskStepCreateLoadBalancer.RetryPolicy(),
+		retryutil.EventNotify(domain.TaskStepCreateLoadBalancer, rollback), func() (*v1alpha1.LoadBalancer, error) {
+			return t.CreateLoadBalancer(ctx, cluster.ClusterID, cluster.RegionID, cluster.VPCID)
+		})
+	if err != nil {
+		rollback(domain.TaskStepCreateLoadBalancer, err.Error(), "failure")
+		return nil
+	}
+	rollback(domain.TaskStepCreateLoadBalancer, lb.LoadBalancerID+","+lb.Address, "success")
+
+	var gatewayIPs []string
+	for _, g := range gateway {
+		gatewayIPs = append(gatewayIPs, g.InternalIP)
+	}
+	rollback(domain.TaskStepBoundLoadBalancer, "", "start")
+	logrus.Infof("gateway ips is %s", gatewayIPs)
+	if err := domain.TaskStepBoundLoadBalancer.RetryPolicy().Do(ctx, retryutil.EventNotify(domain.TaskStepBoundLoadBalancer, rollback), func() error {
+		return t.BoundLoadBalancerToCluster(ctx, cluster.RegionID, cluster.VPCID, lb.LoadBalancerID, gatewayIPs)
+	}); err != nil {
+		rollback(domain.TaskStepBoundLoadBalancer, err.Error(), "failure")
+		return nil
+	}
+	rollback(domain.TaskStepBoundLoadBalancer, "80,443,8443,6060", "success")
+	return &v1alpha1.WutongInitConfig{
+		ClusterID:      cluster.ClusterID,
+		RegionDatabase: regionDB,
+		NFSServer:      mountIP,
+		GatewayNodes:   gateway,
+		ChaosNodes:     chaos,
+		EIPs:           []string{lb.Address},
+	}
 }
 
-func (t *tkeAdaptor) ExpansionNode(ctx context.Context, en *v1alpha1.ExpansionNode, rollback func(step, message, status string)) *v1alpha1.Cluster {
-	return nil
+// ExpansionNode add worker nodes to the cluster
+func (t *tkeAdaptor) ExpansionNode(ctx context.Context, en *v1alpha1.ExpansionNode, rollback func(step domain.TaskStep, message, status string)) *v1alpha1.Cluster {
+	rollback(domain.TaskStepInitClusterConfig, "", "start")
+	if en.WorkerNodeNum <= 0 {
+		rollback(domain.TaskStepInitClusterConfig, "worker node num must be greater than 0", "failure")
+		return nil
+	}
+	cluster, err := t.DescribeCluster(en.ClusterID)
+	if err != nil {
+		rollback(domain.TaskStepInitClusterConfig, err.Error(), "failure")
+		return nil
+	}
+	if cluster.VSwitchID == "" || cluster.ZoneID == "" {
+		rollback(domain.TaskStepInitClusterConfig, "can not find the subnet of the cluster worker nodes", "failure")
+		return nil
+	}
+	config := v1alpha1.GetDefaultTKECreateClusterConfig(v1alpha1.KubernetesClusterConfig{
+		InstanceType:  en.InstanceType,
+		WorkerNodeNum: en.WorkerNodeNum,
+	}).(*v1alpha1.TKEClusterConfig)
+	if config.WorkerInstanceType == "" {
+		config.WorkerInstanceType = getInstanceType(en.WorkerResourceType)[0]
+	}
+	rollback(domain.TaskStepInitClusterConfig, "", "success")
+
+	rollback(domain.TaskStepUpdateKubernetes, config.WorkerInstanceType, "start")
+	client, err := t.tkeClient(cluster.RegionID)
+	if err != nil {
+		rollback(domain.TaskStepUpdateKubernetes, err.Error(), "failure")
+		return nil
+	}
+	req := tke.NewCreateClusterInstancesRequest()
+	req.ClusterId = common.StringPtr(en.ClusterID)
+	req.RunInstancePara = common.StringPtr(t.runInstancesPara(cluster.ZoneID, cluster.VPCID, cluster.VSwitchID, config, en.WorkerNodeNum))
+	req.InstanceAdvancedSettings = instanceAdvancedSettings(config)
+	res, err := client.CreateClusterInstances(req)
+	if err != nil {
+		rollback(domain.TaskStepUpdateKubernetes, fmt.Sprintf("create cluster instances from tencent api failure %s", err.Error()), "failure")
+		return nil
+	}
+	rollback(domain.TaskStepUpdateKubernetes, strings.Join(common.StringValues(res.Response.InstanceIdSet), ","), "success")
+	return cluster
 }
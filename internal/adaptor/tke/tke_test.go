@@ -19,20 +19,496 @@
 package tke
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+	"github.com/wutong-paas/cloud-adaptor/pkg/bcode"
+	wutongv1alpha1 "github.com/wutong-paas/wutong-operator/api/v1alpha1"
+	"gorm.io/gorm"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 var testAccess = ""
 var testSecret = ""
 
+var testKubeConfig = `apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    certificate-authority-data: ""
+    server: https://cls-test.ccs.tencent-cloud.com
+  name: cls-test
+contexts:
+- context:
+    cluster: cls-test
+    user: admin
+  name: cls-test-context-default
+current-context: cls-test-context-default
+users:
+- name: admin
+  user:
+    token: test-token
+`
+
+// fakeTencentAPI fake tencent cloud api server, it responses by the X-TC-Action header
+type fakeTencentAPI struct {
+	lock      sync.Mutex
+	responses map[string]interface{}
+	actions   []string
+	// regions the region of each action called
+	regions map[string]string
+}
+
+func (f *fakeTencentAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	action := r.Header.Get("X-TC-Action")
+	f.lock.Lock()
+	f.actions = append(f.actions, action)
+	f.regions[action] = r.Header.Get("X-TC-Region")
+	response, ok := f.responses[action]
+	f.lock.Unlock()
+	if !ok {
+		response = map[string]interface{}{
+			"Error": map[string]string{"Code": "InvalidAction", "Message": fmt.Sprintf("action %s not found", action)},
+		}
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"Response": response})
+}
+
+func (f *fakeTencentAPI) called(action string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, a := range f.actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// fakeClusterRepo the regions of clusters in memory
+type fakeClusterRepo struct {
+	clusters map[string]*model.TKECluster
+}
+
+func (f *fakeClusterRepo) Create(cluster *model.TKECluster) error {
+	f.clusters[cluster.ClusterID] = cluster
+	return nil
+}
+
+func (f *fakeClusterRepo) GetCluster(clusterID string) (*model.TKECluster, error) {
+	cluster, ok := f.clusters[clusterID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return cluster, nil
+}
+
+func (f *fakeClusterRepo) ListCluster() ([]*model.TKECluster, error) {
+	var list []*model.TKECluster
+	for _, cluster := range f.clusters {
+		list = append(list, cluster)
+	}
+	return list, nil
+}
+
+func (f *fakeClusterRepo) DeleteCluster(clusterID string) error {
+	delete(f.clusters, clusterID)
+	return nil
+}
+
+// testRegion the region of the test cluster cls-test
+const testRegion = "ap-shanghai"
+
+func newTestAdaptor(t *testing.T, responses map[string]interface{}) (*tkeAdaptor, *fakeTencentAPI) {
+	api := &fakeTencentAPI{responses: responses, regions: make(map[string]string)}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	adaptor := newAdaptor(testAccess, testSecret, strings.TrimPrefix(server.URL, "http://"), "HTTP")
+	adaptor.Repo = &fakeClusterRepo{clusters: map[string]*model.TKECluster{
+		"cls-test": {ClusterID: "cls-test", RegionID: testRegion},
+	}}
+	return adaptor, api
+}
+
+func (f *fakeTencentAPI) region(action string) string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.regions[action]
+}
+
+var runningCluster = map[string]interface{}{
+	"Clusters": []map[string]interface{}{
+		{
+			"ClusterId":      "cls-test",
+			"ClusterName":    "wutong-cluster",
+			"ClusterVersion": "1.20.6",
+			"ClusterType":    "MANAGED_CLUSTER",
+			"ClusterStatus":  "Running",
+			"ClusterNodeNum": 2,
+			"CreatedTime":    "2021-06-01T08:00:00Z",
+			"ClusterNetworkSettings": map[string]interface{}{
+				"ClusterCIDR": "172.20.0.0/16",
+				"VpcId":       "vpc-test",
+			},
+		},
+	},
+	"TotalCount": 1,
+}
+
+var clusterSecurity = map[string]interface{}{
+	"ClusterExternalEndpoint": "1.1.1.1",
+	"PgwEndpoint":             "10.0.0.100",
+	"Domain":                  "cls-test.ccs.tencent-cloud.com",
+	"Kubeconfig":              testKubeConfig,
+}
+
+var clusterWorker = map[string]interface{}{
+	"InstanceSet": []map[string]interface{}{
+		{"InstanceId": "ins-worker", "InstanceRole": "WORKER", "LanIP": "10.0.0.10"},
+	},
+	"TotalCount": 1,
+}
+
+var cvmInstances = map[string]interface{}{
+	"InstanceSet": []map[string]interface{}{
+		{
+			"InstanceId":         "ins-worker",
+			"Placement":          map[string]interface{}{"Zone": "ap-guangzhou-3"},
+			"PrivateIpAddresses": []string{"10.0.0.10"},
+			"SecurityGroupIds":   []string{"sg-test"},
+			"VirtualPrivateCloud": map[string]interface{}{
+				"VpcId":    "vpc-test",
+				"SubnetId": "subnet-test",
+			},
+		},
+	},
+	"TotalCount": 1,
+}
+
+func TestClusterList(t *testing.T) {
+	adaptor, _ := newTestAdaptor(t, map[string]interface{}{
+		"DescribeClusters": runningCluster,
+	})
+	clusters, err := adaptor.ClusterList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 1 {
+		t.Fatalf("expect 1 cluster, actual %d", len(clusters))
+	}
+	if clusters[0].ClusterID != "cls-test" || clusters[0].State != v1alpha1.RunningState || clusters[0].VPCID != "vpc-test" ||
+		clusters[0].RegionID != testRegion {
+		t.Fatalf("unexpected cluster %+v", clusters[0])
+	}
+}
+
 func TestDescribeCluster(t *testing.T) {
-	adaptor, err := Create(testAccess, testSecret)
+	adaptor, api := newTestAdaptor(t, map[string]interface{}{
+		"DescribeClusters":         runningCluster,
+		"DescribeClusterSecurity":  clusterSecurity,
+		"DescribeClusterInstances": clusterWorker,
+		"DescribeInstances":        cvmInstances,
+	})
+	cluster, err := adaptor.DescribeCluster("cls-test")
 	if err != nil {
 		t.Fatal(err)
 	}
-	clusters, err := adaptor.ClusterList()
+	for _, action := range []string{"DescribeClusters", "DescribeClusterSecurity", "DescribeInstances"} {
+		if region := api.region(action); region != testRegion {
+			t.Fatalf("expect %s called in region %s, actual %s", action, testRegion, region)
+		}
+	}
+	if cluster.RegionID != testRegion {
+		t.Fatalf("expect cluster region %s, actual %s", testRegion, cluster.RegionID)
+	}
+	if cluster.MasterURL.APIServerEndpoint != "https://1.1.1.1" {
+		t.Fatalf("expect api server endpoint https://1.1.1.1, actual %s", cluster.MasterURL.APIServerEndpoint)
+	}
+	if cluster.VSwitchID != "subnet-test" || cluster.ZoneID != "ap-guangzhou-3" || cluster.SecurityGroupID != "sg-test" {
+		t.Fatalf("unexpected cluster %+v", cluster)
+	}
+}
+
+func TestDescribeClusterOpenExtranetEndpoint(t *testing.T) {
+	adaptor, api := newTestAdaptor(t, map[string]interface{}{
+		"DescribeClusters":              runningCluster,
+		"DescribeClusterSecurity":       map[string]interface{}{"Domain": "cls-test.ccs.tencent-cloud.com"},
+		"DescribeClusterEndpointStatus": map[string]interface{}{"Status": "NotFound"},
+		"CreateClusterEndpoint":         map[string]interface{}{},
+		"DescribeClusterInstances":      clusterWorker,
+		"DescribeInstances":             cvmInstances,
+	})
+	cluster, err := adaptor.DescribeCluster("cls-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cluster.MasterURL.APIServerEndpoint != "" {
+		t.Fatalf("expect empty api server endpoint, actual %s", cluster.MasterURL.APIServerEndpoint)
+	}
+	if !api.called("CreateClusterEndpoint") {
+		t.Fatal("expect the extranet endpoint is opened")
+	}
+}
+
+func TestDescribeClusterNotFound(t *testing.T) {
+	adaptor, _ := newTestAdaptor(t, map[string]interface{}{
+		"DescribeClusters": map[string]interface{}{"Clusters": []interface{}{}, "TotalCount": 0},
+	})
+	if _, err := adaptor.DescribeCluster("cls-test"); err == nil {
+		t.Fatal("expect cluster not found error")
+	}
+	// the cluster not created by adaptor
+	if _, err := adaptor.DescribeCluster("cls-unknown"); !errors.Is(err, bcode.ErrClusterNotFound) {
+		t.Fatalf("expect cluster not found error, actual %v", err)
+	}
+}
+
+func TestGetKubeConfig(t *testing.T) {
+	adaptor, _ := newTestAdaptor(t, map[string]interface{}{
+		"DescribeClusterSecurity": clusterSecurity,
+	})
+	kubeConfig, err := adaptor.GetKubeConfig("cls-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := clientcmd.Load([]byte(kubeConfig.Config))
 	if err != nil {
 		t.Fatal(err)
 	}
-	t.Log(clusters)
+	cluster := config.Clusters["cls-test"]
+	if cluster.Server != "https://1.1.1.1" || cluster.TLSServerName != "cls-test.ccs.tencent-cloud.com" {
+		t.Fatalf("unexpected kube config cluster %+v", cluster)
+	}
+}
+
+func TestCreateVPC(t *testing.T) {
+	tests := []struct {
+		name   string
+		exists []map[string]interface{}
+		vpcID  string
+	}{
+		{
+			name:  "create new vpc",
+			vpcID: "vpc-new",
+		},
+		{
+			name: "reuse exist vpc",
+			exists: []map[string]interface{}{
+				{"VpcId": "vpc-exist", "VpcName": "wutong-default-vpc", "CidrBlock": "10.0.0.0/16"},
+			},
+			vpcID: "vpc-exist",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			adaptor, _ := newTestAdaptor(t, map[string]interface{}{
+				"DescribeVpcs": map[string]interface{}{"VpcSet": tc.exists, "TotalCount": len(tc.exists)},
+				"CreateVpc":    map[string]interface{}{"Vpc": map[string]interface{}{"VpcId": "vpc-new"}},
+			})
+			vpc := &v1alpha1.VPC{RegionID: "ap-guangzhou", VpcName: "wutong-default-vpc", CidrBlock: "10.0.0.0/16"}
+			if err := adaptor.CreateVPC(vpc); err != nil {
+				t.Fatal(err)
+			}
+			if vpc.VpcID != tc.vpcID {
+				t.Fatalf("expect vpc %s, actual %s", tc.vpcID, vpc.VpcID)
+			}
+		})
+	}
+}
+
+type stepRecorder struct {
+	steps []string
+}
+
+func (s *stepRecorder) rollback(step domain.TaskStep, message, status string) {
+	s.steps = append(s.steps, string(step)+":"+status)
+}
+
+func (s *stepRecorder) failure() string {
+	for _, step := range s.steps {
+		if strings.HasSuffix(step, ":failure") {
+			return step
+		}
+	}
+	return ""
+}
+
+func TestCreateWutongKubernetes(t *testing.T) {
+	adaptor, api := newTestAdaptor(t, map[string]interface{}{
+		"DescribeZoneInstanceConfigInfos": map[string]interface{}{
+			"InstanceTypeQuotaSet": []map[string]interface{}{
+				{"Zone": "ap-guangzhou-3", "InstanceType": "S5.LARGE8", "Status": "SELL"},
+			},
+		},
+		"DescribeVpcs":    map[string]interface{}{"VpcSet": []interface{}{}, "TotalCount": 0},
+		"CreateVpc":       map[string]interface{}{"Vpc": map[string]interface{}{"VpcId": "vpc-new"}},
+		"DescribeSubnets": map[string]interface{}{"SubnetSet": []interface{}{}, "TotalCount": 0},
+		"CreateSubnet":    map[string]interface{}{"Subnet": map[string]interface{}{"SubnetId": "subnet-new"}},
+		"CreateCluster":   map[string]interface{}{"ClusterId": "cls-new"},
+	})
+	recorder := &stepRecorder{}
+	cluster := adaptor.CreateWutongKubernetes(context.TODO(), &v1alpha1.KubernetesClusterConfig{
+		ClusterName:   "wutong-cluster",
+		Region:        "ap-guangzhou",
+		WorkerNodeNum: 2,
+	}, recorder.rollback)
+	if failure := recorder.failure(); failure != "" {
+		t.Fatalf("step %s, steps: %v", failure, recorder.steps)
+	}
+	if cluster == nil || cluster.ClusterID != "cls-new" || cluster.VPCID != "vpc-new" || cluster.VSwitchID != "subnet-new" {
+		t.Fatalf("unexpected cluster %+v", cluster)
+	}
+	if region := api.region("CreateCluster"); region != "ap-guangzhou" {
+		t.Fatalf("expect cluster created in region ap-guangzhou, actual %s", region)
+	}
+	if regionID, err := adaptor.clusterRegion("cls-new"); err != nil || regionID != "ap-guangzhou" {
+		t.Fatalf("expect the region of cluster saved, actual %s %v", regionID, err)
+	}
+}
+
+func TestGetWutongInitConfig(t *testing.T) {
+	adaptor, api := newTestAdaptor(t, map[string]interface{}{
+		"DescribeDBInstances": map[string]interface{}{
+			"Items": []map[string]interface{}{
+				{"InstanceId": "cdb-test", "InstanceName": "wutong-region-db_cls-test", "Status": 1, "InitFlag": 1, "Vip": "10.0.0.20", "Vport": 3306},
+			},
+			"TotalCount": 1,
+		},
+		"DescribeAccounts":        map[string]interface{}{"Items": []interface{}{}, "TotalCount": 0},
+		"CreateAccounts":          map[string]interface{}{"AsyncRequestId": "async"},
+		"ModifyAccountPrivileges": map[string]interface{}{"AsyncRequestId": "async"},
+		"DescribeCfsFileSystems": map[string]interface{}{
+			"FileSystems": []map[string]interface{}{
+				{"FileSystemId": "cfs-test", "FsName": "wutong-region-nas_cls-test", "LifeCycleState": "available"},
+			},
+			"TotalCount": 1,
+		},
+		"DescribeMountTargets": map[string]interface{}{
+			"MountTargets": []map[string]interface{}{
+				{"FileSystemId": "cfs-test", "IpAddress": "10.0.0.30", "LifeCycleState": "available"},
+			},
+		},
+		"DescribeLoadBalancers": map[string]interface{}{
+			"LoadBalancerSet": []map[string]interface{}{
+				{"LoadBalancerId": "lb-test", "LoadBalancerName": "wutong-region-lb_cls-test", "Status": 1, "LoadBalancerVips": []string{"2.2.2.2"}},
+			},
+			"TotalCount": 1,
+		},
+		"DescribeInstances": cvmInstances,
+		"DescribeListeners": map[string]interface{}{"Listeners": []interface{}{}},
+		"CreateListener":    map[string]interface{}{"ListenerIds": []string{"lbl-test"}},
+		"RegisterTargets":   map[string]interface{}{},
+	})
+	recorder := &stepRecorder{}
+	var resources []string
+	adaptor.SetResourceRecorder(func(resource *v1alpha1.CloudResource) {
+		resources = append(resources, string(resource.Type)+":"+resource.CloudID)
+	})
+	cluster := &v1alpha1.Cluster{
+		ClusterID: "cls-test",
+		RegionID:  "ap-guangzhou",
+		ZoneID:    "ap-guangzhou-3",
+		VPCID:     "vpc-test",
+		VSwitchID: "subnet-test",
+	}
+	gateway := []*wutongv1alpha1.K8sNode{{Name: "10.0.0.10", InternalIP: "10.0.0.10"}}
+	initConfig := adaptor.GetWutongInitConfig(context.Background(), cluster, gateway, gateway, recorder.rollback)
+	if failure := recorder.failure(); failure != "" {
+		t.Fatalf("step %s, steps: %v", failure, recorder.steps)
+	}
+	if initConfig.RegionDatabase.Host != "10.0.0.20" || initConfig.RegionDatabase.Password == "" {
+		t.Fatalf("unexpected region database %+v", initConfig.RegionDatabase)
+	}
+	if initConfig.NFSServer != "10.0.0.30" {
+		t.Fatalf("expect nfs server 10.0.0.30, actual %s", initConfig.NFSServer)
+	}
+	if len(initConfig.EIPs) != 1 || initConfig.EIPs[0] != "2.2.2.2" {
+		t.Fatalf("unexpected eips %v", initConfig.EIPs)
+	}
+	if api.called("CreateDBInstanceHour") || api.called("CreateCfsFileSystem") || api.called("CreateLoadBalancer") {
+		t.Fatal("exist resources should be reused")
+	}
+	if strings.Join(resources, ",") != "rds:cdb-test,nas:cfs-test,slb:lb-test" {
+		t.Fatalf("unexpected recorded resources %v", resources)
+	}
+}
+
+func TestDestroyCluster(t *testing.T) {
+	adaptor, api := newTestAdaptor(t, map[string]interface{}{
+		"DescribeClusters": runningCluster,
+		"DeleteCluster":    map[string]interface{}{},
+		"DescribeLoadBalancers": map[string]interface{}{
+			"LoadBalancerSet": []map[string]interface{}{
+				{"LoadBalancerId": "lb-test", "LoadBalancerName": "wutong-region-lb_cls-test", "Status": 1},
+			},
+			"TotalCount": 1,
+		},
+		"DeleteLoadBalancer": map[string]interface{}{},
+		"DescribeCfsFileSystems": map[string]interface{}{
+			"FileSystems": []map[string]interface{}{
+				{"FileSystemId": "cfs-test", "FsName": "wutong-region-nas_cls-test", "LifeCycleState": "available"},
+			},
+			"TotalCount": 1,
+		},
+		"DescribeMountTargets": map[string]interface{}{"MountTargets": []interface{}{}},
+		"DeleteCfsFileSystem":  map[string]interface{}{},
+		"DescribeDBInstances": map[string]interface{}{
+			"Items": []map[string]interface{}{
+				{"InstanceId": "cdb-test", "InstanceName": "wutong-region-db_cls-test", "Status": dbInstanceIsolated},
+			},
+			"TotalCount": 1,
+		},
+		"OfflineIsolatedInstances": map[string]interface{}{},
+	})
+	recorder := &stepRecorder{}
+	adaptor.DestroyCluster(context.TODO(), &v1alpha1.DestroyCluster{Provider: "tke", ClusterID: "cls-test"}, recorder.rollback)
+	if failure := recorder.failure(); failure != "" {
+		t.Fatalf("step %s, steps: %v", failure, recorder.steps)
+	}
+	for _, action := range []string{"DeleteCluster", "DeleteLoadBalancer", "DeleteCfsFileSystem", "OfflineIsolatedInstances"} {
+		if !api.called(action) {
+			t.Fatalf("expect %s called", action)
+		}
+	}
+	if _, err := adaptor.Repo.GetCluster("cls-test"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatal("expect the region of cluster deleted")
+	}
+}
+
+func TestExpansionNode(t *testing.T) {
+	adaptor, api := newTestAdaptor(t, map[string]interface{}{
+		"DescribeClusters":         runningCluster,
+		"DescribeClusterSecurity":  clusterSecurity,
+		"DescribeClusterInstances": clusterWorker,
+		"DescribeInstances":        cvmInstances,
+		"CreateClusterInstances":   map[string]interface{}{"InstanceIdSet": []string{"ins-new"}},
+	})
+	recorder := &stepRecorder{}
+	cluster := adaptor.ExpansionNode(context.TODO(), &v1alpha1.ExpansionNode{
+		ClusterID:     "cls-test",
+		WorkerNodeNum: 1,
+		InstanceType:  "S5.LARGE8",
+	}, recorder.rollback)
+	if failure := recorder.failure(); failure != "" {
+		t.Fatalf("step %s, steps: %v", failure, recorder.steps)
+	}
+	if cluster == nil {
+		t.Fatal("expect cluster, actual nil")
+	}
+	if recorder.steps[len(recorder.steps)-1] != "UpdateKubernetes:success" {
+		t.Fatalf("unexpected steps %v", recorder.steps)
+	}
+	if region := api.region("CreateClusterInstances"); region != testRegion {
+		t.Fatalf("expect nodes created in region %s, actual %s", testRegion, region)
+	}
 }
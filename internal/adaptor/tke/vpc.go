@@ -0,0 +1,321 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package tke
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	cvm "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/cvm/v20170312"
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+)
+
+func (t *tkeAdaptor) vpcClient(regionID string) (*vpc.Client, error) {
+	if regionID == "" {
+		return nil, errRegionRequired
+	}
+	return vpc.NewClient(t.credential, regionID, t.clientProfile())
+}
+
+func (t *tkeAdaptor) vpcConver(regionID string, v *vpc.Vpc) *v1alpha1.VPC {
+	re := &v1alpha1.VPC{
+		VpcID:         toString(v.VpcId),
+		RegionID:      regionID,
+		Status:        "Available",
+		VpcName:       toString(v.VpcName),
+		CreationTime:  toString(v.CreatedTime),
+		CidrBlock:     toString(v.CidrBlock),
+		Ipv6CidrBlock: toString(v.Ipv6CidrBlock),
+		IsDefault:     v.IsDefault != nil && *v.IsDefault,
+	}
+	for _, tag := range v.TagSet {
+		re.Tags = append(re.Tags, v1alpha1.Tag{Key: toString(tag.Key), Value: toString(tag.Value)})
+	}
+	return re
+}
+
+func (t *tkeAdaptor) subnetConver(regionID string, s *vpc.Subnet) *v1alpha1.VSwitch {
+	re := &v1alpha1.VSwitch{
+		VpcID:                   toString(s.VpcId),
+		RegionID:                regionID,
+		VSwitchID:               toString(s.SubnetId),
+		Status:                  "Available",
+		CidrBlock:               toString(s.CidrBlock),
+		Ipv6CidrBlock:           toString(s.Ipv6CidrBlock),
+		ZoneID:                  toString(s.Zone),
+		AvailableIPAddressCount: int64(toUint64(s.AvailableIpAddressCount)),
+		VSwitchName:             toString(s.SubnetName),
+		CreationTime:            toString(s.CreatedTime),
+		IsDefault:               s.IsDefault != nil && *s.IsDefault,
+		NetworkACLID:            toString(s.NetworkAclId),
+	}
+	for _, tag := range s.TagSet {
+		re.Tags = append(re.Tags, v1alpha1.Tag{Key: toString(tag.Key), Value: toString(tag.Value)})
+	}
+	return re
+}
+
+// VPCList list vpc
+func (t *tkeAdaptor) VPCList(regionID string) ([]*v1alpha1.VPC, error) {
+	client, err := t.vpcClient(regionID)
+	if err != nil {
+		return nil, err
+	}
+	req := vpc.NewDescribeVpcsRequest()
+	req.Limit = common.StringPtr("100")
+	res, err := client.DescribeVpcs(req)
+	if err != nil {
+		return nil, err
+	}
+	var vpcs []*v1alpha1.VPC
+	for _, v := range res.Response.VpcSet {
+		vpcs = append(vpcs, t.vpcConver(regionID, v))
+	}
+	return vpcs, nil
+}
+
+// DescribeVPC describe vpc
+func (t *tkeAdaptor) DescribeVPC(regionID, vpcID string) (*v1alpha1.VPC, error) {
+	client, err := t.vpcClient(regionID)
+	if err != nil {
+		return nil, err
+	}
+	req := vpc.NewDescribeVpcsRequest()
+	req.VpcIds = common.StringPtrs([]string{vpcID})
+	res, err := client.DescribeVpcs(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Response.VpcSet) == 0 {
+		return nil, fmt.Errorf("vpc %s not found", vpcID)
+	}
+	return t.vpcConver(regionID, res.Response.VpcSet[0]), nil
+}
+
+// CreateVPC create vpc, an exist vpc with the same name is reused
+func (t *tkeAdaptor) CreateVPC(v *v1alpha1.VPC) error {
+	client, err := t.vpcClient(v.RegionID)
+	if err != nil {
+		return err
+	}
+	describeReq := vpc.NewDescribeVpcsRequest()
+	describeReq.Filters = []*vpc.Filter{
+		{Name: common.StringPtr("vpc-name"), Values: common.StringPtrs([]string{v.VpcName})},
+	}
+	describeRes, err := client.DescribeVpcs(describeReq)
+	if err != nil {
+		return err
+	}
+	for _, exist := range describeRes.Response.VpcSet {
+		if toString(exist.VpcName) == v.VpcName && toString(exist.CidrBlock) == v.CidrBlock {
+			v.VpcID = toString(exist.VpcId)
+			return nil
+		}
+	}
+	req := vpc.NewCreateVpcRequest()
+	req.VpcName = common.StringPtr(v.VpcName)
+	req.CidrBlock = common.StringPtr(v.CidrBlock)
+	res, err := client.CreateVpc(req)
+	if err != nil {
+		return err
+	}
+	v.VpcID = toString(res.Response.Vpc.VpcId)
+	return nil
+}
+
+// DeleteVPC delete vpc
+func (t *tkeAdaptor) DeleteVPC(ctx context.Context, regionID, vpcID string) error {
+	client, err := t.vpcClient(regionID)
+	if err != nil {
+		return err
+	}
+	req := vpc.NewDeleteVpcRequest()
+	req.VpcId = common.StringPtr(vpcID)
+	_, err = client.DeleteVpc(req)
+	return err
+}
+
+// CreateVSwitch create subnet, an exist subnet with the same name is reused
+func (t *tkeAdaptor) CreateVSwitch(v *v1alpha1.VSwitch) error {
+	client, err := t.vpcClient(v.RegionID)
+	if err != nil {
+		return err
+	}
+	describeReq := vpc.NewDescribeSubnetsRequest()
+	describeReq.Filters = []*vpc.Filter{
+		{Name: common.StringPtr("vpc-id"), Values: common.StringPtrs([]string{v.VpcID})},
+	}
+	describeRes, err := client.DescribeSubnets(describeReq)
+	if err != nil {
+		return err
+	}
+	for _, exist := range describeRes.Response.SubnetSet {
+		if toString(exist.SubnetName) == v.VSwitchName && toString(exist.Zone) == v.ZoneID {
+			v.VSwitchID = toString(exist.SubnetId)
+			return nil
+		}
+	}
+	req := vpc.NewCreateSubnetRequest()
+	req.VpcId = common.StringPtr(v.VpcID)
+	req.SubnetName = common.StringPtr(v.VSwitchName)
+	req.CidrBlock = common.StringPtr(v.CidrBlock)
+	req.Zone = common.StringPtr(v.ZoneID)
+	res, err := client.CreateSubnet(req)
+	if err != nil {
+		return err
+	}
+	v.VSwitchID = toString(res.Response.Subnet.SubnetId)
+	return nil
+}
+
+// DescribeVSwitch describe subnet
+func (t *tkeAdaptor) DescribeVSwitch(regionID, vswitchID string) (*v1alpha1.VSwitch, error) {
+	client, err := t.vpcClient(regionID)
+	if err != nil {
+		return nil, err
+	}
+	req := vpc.NewDescribeSubnetsRequest()
+	req.SubnetIds = common.StringPtrs([]string{vswitchID})
+	res, err := client.DescribeSubnets(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Response.SubnetSet) == 0 {
+		return nil, fmt.Errorf("subnet %s not found", vswitchID)
+	}
+	return t.subnetConver(regionID, res.Response.SubnetSet[0]), nil
+}
+
+// DeleteVSwitch delete subnet
+func (t *tkeAdaptor) DeleteVSwitch(ctx context.Context, regionID, vswitchID string) error {
+	client, err := t.vpcClient(regionID)
+	if err != nil {
+		return err
+	}
+	req := vpc.NewDeleteSubnetRequest()
+	req.SubnetId = common.StringPtr(vswitchID)
+	_, err = client.DeleteSubnet(req)
+	return err
+}
+
+// ListZones list available zones
+func (t *tkeAdaptor) ListZones(regionID string) ([]*v1alpha1.Zone, error) {
+	client, err := t.cvmClient(regionID)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.DescribeZones(cvm.NewDescribeZonesRequest())
+	if err != nil {
+		return nil, err
+	}
+	var zones []*v1alpha1.Zone
+	for _, z := range res.Response.ZoneSet {
+		if toString(z.ZoneState) != "AVAILABLE" {
+			continue
+		}
+		zones = append(zones, &v1alpha1.Zone{ZoneID: toString(z.Zone), LocalName: toString(z.ZoneName)})
+	}
+	return zones, nil
+}
+
+// ListInstanceType list instance types
+func (t *tkeAdaptor) ListInstanceType(regionID string) ([]*v1alpha1.InstanceType, error) {
+	client, err := t.cvmClient(regionID)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.DescribeInstanceTypeConfigs(cvm.NewDescribeInstanceTypeConfigsRequest())
+	if err != nil {
+		return nil, err
+	}
+	var types []*v1alpha1.InstanceType
+	// the same instance type is returned once for each zone
+	var exists = make(map[string]struct{})
+	for _, it := range res.Response.InstanceTypeConfigSet {
+		if _, ok := exists[toString(it.InstanceType)]; ok {
+			continue
+		}
+		exists[toString(it.InstanceType)] = struct{}{}
+		types = append(types, &v1alpha1.InstanceType{
+			InstanceTypeID:     toString(it.InstanceType),
+			CPUCoreCount:       int(toInt64(it.CPU)),
+			MemorySize:         float64(toInt64(it.Memory)),
+			InstanceTypeFamily: toString(it.InstanceFamily),
+		})
+	}
+	return types, nil
+}
+
+// DescribeAvailableResourceZones describe the zones which sell the postpaid instance type
+func (t *tkeAdaptor) DescribeAvailableResourceZones(regionID, instanceType string) ([]*v1alpha1.AvailableResourceZone, error) {
+	client, err := t.cvmClient(regionID)
+	if err != nil {
+		return nil, err
+	}
+	req := cvm.NewDescribeZoneInstanceConfigInfosRequest()
+	req.Filters = []*cvm.Filter{
+		{Name: common.StringPtr("instance-type"), Values: common.StringPtrs([]string{instanceType})},
+		{Name: common.StringPtr("instance-charge-type"), Values: common.StringPtrs([]string{"POSTPAID_BY_HOUR"})},
+	}
+	res, err := client.DescribeZoneInstanceConfigInfos(req)
+	if err != nil {
+		return nil, err
+	}
+	var zones []*v1alpha1.AvailableResourceZone
+	for _, item := range res.Response.InstanceTypeQuotaSet {
+		zone := &v1alpha1.AvailableResourceZone{
+			Status:         "SoldOut",
+			StatusCategory: toString(item.SoldOutReason),
+			ZoneID:         toString(item.Zone),
+		}
+		if strings.ToUpper(toString(item.Status)) == "SELL" {
+			zone.Status = "Available"
+		}
+		zones = append(zones, zone)
+	}
+	return zones, nil
+}
+
+// getInstanceIDsByIPs get cvm instance ids by private ips
+func (t *tkeAdaptor) getInstanceIDsByIPs(regionID, vpcID string, ips []string) (map[string]string, error) {
+	client, err := t.cvmClient(regionID)
+	if err != nil {
+		return nil, err
+	}
+	req := cvm.NewDescribeInstancesRequest()
+	req.Filters = []*cvm.Filter{
+		{Name: common.StringPtr("vpc-id"), Values: common.StringPtrs([]string{vpcID})},
+		{Name: common.StringPtr("private-ip-address"), Values: common.StringPtrs(ips)},
+	}
+	req.Limit = common.Int64Ptr(100)
+	res, err := client.DescribeInstances(req)
+	if err != nil {
+		return nil, err
+	}
+	var re = make(map[string]string)
+	for _, ins := range res.Response.InstanceSet {
+		for _, ip := range ins.PrivateIpAddresses {
+			re[toString(ip)] = toString(ins.InstanceId)
+		}
+	}
+	return re, nil
+}
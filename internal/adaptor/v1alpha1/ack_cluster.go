@@ -22,6 +22,8 @@ import (
 	"net"
 	"os"
 	"strings"
+
+	"github.com/wutong-paas/cloud-adaptor/pkg/util/cryptoutil"
 )
 
 //GetDefaultACKCreateClusterConfig get create ack cluster default config
@@ -107,6 +109,27 @@ func GetDefaultACKCreateClusterConfig(config KubernetesClusterConfig) CreateClus
 		CPUPolicy:                "none",
 		VPCID:                    config.VpcID,
 		VSwitchIDs:               []string{config.VSwitchID},
-		LoginPassword:            "RootPassword123!",
+		LoginPassword:            cryptoutil.RandomPassword(12),
+	}
+}
+
+//GetDefaultACKScaleOutConfig get scale out ack cluster default config
+func GetDefaultACKScaleOutConfig(count int, instanceTypes, vswitchIDs []string) *AckScaleOutConfig {
+	return &AckScaleOutConfig{
+		Count:                    count,
+		WorkerInstanceTypes:      instanceTypes,
+		VSwitchIDs:               vswitchIDs,
+		WorkerSystemDiskCategory: "cloud_efficiency",
+		WorkerSystemDiskSize:     120,
+		WorkerDataDisks: []WorkerDataDisk{
+			{
+				Category:  "cloud_efficiency",
+				Size:      "200",
+				Encrypted: "false",
+			},
+		},
+		WorkerInstanceChargeType: "PostPaid",
+		CloudMonitorFlags:        true,
+		LoginPassword:            cryptoutil.RandomPassword(12),
 	}
 }
@@ -0,0 +1,118 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package v1alpha1
+
+import (
+	"strconv"
+
+	v3 "github.com/rancher/rke/types"
+	"github.com/wutong-paas/cloud-adaptor/pkg/bcode"
+)
+
+// BastionHost the jump host through which the nodes are connected over ssh,
+// it authenticates the ssh key of the adaptor.
+type BastionHost struct {
+	Address string `json:"address"`
+	// Port the ssh port of bastion host, 22 by default
+	Port int `json:"port,omitempty"`
+	// User the ssh user of bastion host, root by default
+	User string `json:"user,omitempty"`
+}
+
+// Validate validate the bastion host
+func (b *BastionHost) Validate() error {
+	if b.Address == "" || b.Port < 0 || b.Port > 65535 {
+		return bcode.ErrInvalidBastionHost
+	}
+	return nil
+}
+
+// RKEBastionHost convert to the bastion host of rke
+func (b *BastionHost) RKEBastionHost() v3.BastionHost {
+	port := "22"
+	if b.Port != 0 {
+		port = strconv.Itoa(b.Port)
+	}
+	user := "root"
+	if b.User != "" {
+		user = b.User
+	}
+	return v3.BastionHost{
+		Address:    b.Address,
+		Port:       port,
+		User:       user,
+		SSHKeyPath: "~/.ssh/id_rsa",
+	}
+}
+
+// NodeBastions the bastion hosts of nodes keyed by node address, the nodes
+// not in it are connected through the bastion host of cluster if any.
+type NodeBastions map[string]BastionHost
+
+// Validate validate the bastion hosts
+func (n NodeBastions) Validate() error {
+	for _, bastion := range n {
+		if err := bastion.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Merge returns the bastion hosts of both, the other one wins on conflicts
+func (n NodeBastions) Merge(other NodeBastions) NodeBastions {
+	if len(n) == 0 && len(other) == 0 {
+		return nil
+	}
+	bastions := make(NodeBastions, len(n)+len(other))
+	for address, bastion := range n {
+		bastions[address] = bastion
+	}
+	for address, bastion := range other {
+		bastions[address] = bastion
+	}
+	return bastions
+}
+
+// RKEBastionHosts convert to the bastion hosts of rke
+func (n NodeBastions) RKEBastionHosts() map[string]v3.BastionHost {
+	if len(n) == 0 {
+		return nil
+	}
+	bastions := make(map[string]v3.BastionHost, len(n))
+	for address, bastion := range n {
+		bastions[address] = bastion.RKEBastionHost()
+	}
+	return bastions
+}
+
+// Bastions the bastion hosts of the nodes
+func (n NodeList) Bastions() NodeBastions {
+	var bastions NodeBastions
+	for _, node := range n {
+		if node.Bastion == nil {
+			continue
+		}
+		if bastions == nil {
+			bastions = make(NodeBastions)
+		}
+		bastions[node.IP] = *node.Bastion
+	}
+	return bastions
+}
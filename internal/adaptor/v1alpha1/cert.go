@@ -0,0 +1,49 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package v1alpha1
+
+import "time"
+
+// RotateCertificates rotate the certificates of the cluster
+type RotateCertificates struct {
+	Provider  string `json:"provider"`
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+	ClusterID string `json:"clusterID"`
+	// CACertificates rotate the CA certificates, all the certificates are rotated as well
+	CACertificates bool `json:"caCertificates"`
+	// Services the services whose certificates will be rotated, all services if empty
+	Services []string `json:"services,omitempty"`
+}
+
+// Target returns the provider and the cluster of the operation, they are empty if c is nil
+func (c *RotateCertificates) Target() (provider, clusterID string) {
+	if c == nil {
+		return "", ""
+	}
+	return c.Provider, c.ClusterID
+}
+
+// CertificateInfo the certificate used by the cluster components
+type CertificateInfo struct {
+	Name       string    `json:"name"`
+	CommonName string    `json:"commonName"`
+	NotBefore  time.Time `json:"notBefore"`
+	NotAfter   time.Time `json:"notAfter"`
+}
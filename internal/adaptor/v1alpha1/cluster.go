@@ -90,6 +90,12 @@ var InstallingState = "installing"
 // InitState -
 var InitState = "initial"
 
+// UpgradingState upgrading kubernetes
+var UpgradingState = "upgrading"
+
+// DestroyingState removing kubernetes from all nodes
+var DestroyingState = "destroying"
+
 // InstallFailed 安装失败
 var InstallFailed = "failed"
 
@@ -192,6 +198,7 @@ type KubernetesClusterConfig struct {
 	SecretKey          string                            `json:"secret_key"`
 	Provider           string                            `json:"provider_name"`
 	ClusterName        string                            `json:"name"`
+	ClusterID          string                            `json:"clusterID,omitempty"`
 	Region             string                            `json:"region,omitempty"`
 	ClusterCIDR        string                            `json:"clusterCIDR,omitempty"`
 	ServiceCIDR        string                            `json:"serviceCIDR,omitempty"`
@@ -208,6 +215,14 @@ type KubernetesClusterConfig struct {
 	InstanceType       string                            `json:"instanceType,omitempty"`
 	DockerVersion      string                            `json:"dockerVersion,omitempty"`
 	KubernetesVersion  string                            `json:"kubernetesVersion,omitempty"`
+	// Preflight check the nodes before installing, the installation stops if any check failed
+	Preflight bool `json:"preflight,omitempty"`
+	// SSHKeyType the type of the ssh key generated for the cluster, rsa or ed25519
+	SSHKeyType string `json:"sshKeyType,omitempty"`
+	// Bastion the jump host to connect the nodes through
+	Bastion *BastionHost `json:"bastion,omitempty"`
+	// NodeBastions the jump hosts of the nodes which are not connected through the cluster one
+	NodeBastions NodeBastions `json:"nodeBastions,omitempty"`
 }
 
 // NodeList node list
@@ -254,6 +269,8 @@ type ConfigNode struct {
 	SSHPort          int      `json:"sshPort,omitempty"`
 	DockerSocketPath string   `json:"dockerSocketPath,omitempty"`
 	Roles            []string `json:"roles,omitempty"`
+	// Bastion the jump host to connect the node through
+	Bastion *BastionHost `json:"bastion,omitempty"`
 }
 
 // ClusterType 集群类型
@@ -297,6 +314,19 @@ type AckClusterConfig struct {
 	CPUPolicy                string           `json:"cpu_policy,omitempty"`
 }
 
+// AckScaleOutConfig ack scale out cluster config
+type AckScaleOutConfig struct {
+	Count                    int              `json:"count,omitempty"`
+	WorkerInstanceTypes      []string         `json:"worker_instance_types,omitempty"`
+	VSwitchIDs               []string         `json:"vswitch_ids,omitempty"`
+	WorkerSystemDiskCategory string           `json:"worker_system_disk_category,omitempty"`
+	WorkerSystemDiskSize     int              `json:"worker_system_disk_size,omitempty"`
+	WorkerDataDisks          []WorkerDataDisk `json:"worker_data_disks,omitempty"`
+	WorkerInstanceChargeType string           `json:"worker_instance_charge_type,omitempty"`
+	CloudMonitorFlags        bool             `json:"cloud_monitor_flags,omitempty"`
+	LoginPassword            string           `json:"login_password,omitempty"`
+}
+
 // Addon 选装addon
 type Addon struct {
 	Name    string `json:"name,omitempty"`
@@ -462,6 +492,8 @@ type WutongInitConfig struct {
 	GatewayNodes    []*wutongv1alpha1.K8sNode
 	ChaosNodes      []*wutongv1alpha1.K8sNode
 	EIPs            []string
+	// NFSServer the address of an external nfs server, such as tencent cfs
+	NFSServer string
 }
 
 // NasStorageInfo nas storage info
@@ -533,4 +565,36 @@ type ExpansionNode struct {
 	InstanceType       string                            `json:"instanceType,omitempty"`
 	DockerVersion      string                            `json:"dockerVersion,omitempty"`
 	RKEConfig          *v3.RancherKubernetesEngineConfig `json:"rkeConfig"`
+	// Preflight check the nodes before updating, the update stops if any check failed
+	Preflight bool `json:"preflight,omitempty"`
+	// NodeBastions the jump hosts of the nodes, the stored ones are kept if nil
+	NodeBastions NodeBastions `json:"nodeBastions,omitempty"`
+}
+
+// Target returns the provider and the cluster of the operation, they are empty if c is nil
+func (c *ExpansionNode) Target() (provider, clusterID string) {
+	if c == nil {
+		return "", ""
+	}
+	return c.Provider, c.ClusterID
+}
+
+// UpgradeKubernetes upgrade the kubernetes version of cluster
+type UpgradeKubernetes struct {
+	Provider          string `json:"provider"`
+	AccessKey         string `json:"accessKey"`
+	SecretKey         string `json:"secretKey"`
+	ClusterID         string `json:"clusterID"`
+	KubernetesVersion string `json:"kubernetesVersion"`
+	// SystemImages the system images matching the target kubernetes version
+	SystemImages    *v3.RKESystemImages     `json:"systemImages,omitempty"`
+	UpgradeStrategy *v3.NodeUpgradeStrategy `json:"upgradeStrategy,omitempty"`
+}
+
+// Target returns the provider and the cluster of the operation, they are empty if c is nil
+func (c *UpgradeKubernetes) Target() (provider, clusterID string) {
+	if c == nil {
+		return "", ""
+	}
+	return c.Provider, c.ClusterID
 }
@@ -0,0 +1,58 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package v1alpha1
+
+// SecretsEncryptionAction the operation on the secrets encryption of cluster
+type SecretsEncryptionAction string
+
+// SecretsEncryptionEnable enable secrets encryption at rest
+var SecretsEncryptionEnable SecretsEncryptionAction = "enable"
+
+// SecretsEncryptionRotateKey rotate the secrets encryption key
+var SecretsEncryptionRotateKey SecretsEncryptionAction = "rotate-key"
+
+// SecretsEncryption enable the secrets encryption of cluster or rotate its key
+type SecretsEncryption struct {
+	Provider  string                  `json:"provider"`
+	AccessKey string                  `json:"accessKey"`
+	SecretKey string                  `json:"secretKey"`
+	ClusterID string                  `json:"clusterID"`
+	Action    SecretsEncryptionAction `json:"action"`
+}
+
+// Target returns the provider and the cluster of the operation, they are empty if c is nil
+func (c *SecretsEncryption) Target() (provider, clusterID string) {
+	if c == nil {
+		return "", ""
+	}
+	return c.Provider, c.ClusterID
+}
+
+// SecretsEncryptionState the state of secrets encryption provider of cluster
+type SecretsEncryptionState struct {
+	Enabled bool `json:"enabled"`
+	// CustomConfig the encryption provider is configured by user, its key can not be rotated
+	CustomConfig bool `json:"customConfig"`
+	// Providers the encryption providers of secrets in order, the first one encrypts the new secrets
+	Providers []string `json:"providers"`
+	// KeyNames the key names of the first provider, the first one is the active key
+	KeyNames []string `json:"keyNames"`
+	// Applied the desired encryption config has been applied to the cluster
+	Applied bool `json:"applied"`
+}
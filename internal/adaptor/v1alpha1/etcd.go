@@ -0,0 +1,49 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package v1alpha1
+
+import "time"
+
+// EtcdSnapshot save an etcd snapshot of the cluster or restore the cluster from it
+type EtcdSnapshot struct {
+	Provider  string `json:"provider"`
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+	ClusterID string `json:"clusterID"`
+	// Name the snapshot name without the file extension
+	Name string `json:"name"`
+}
+
+// Target returns the provider and the cluster of the operation, they are empty if c is nil
+func (c *EtcdSnapshot) Target() (provider, clusterID string) {
+	if c == nil {
+		return "", ""
+	}
+	return c.Provider, c.ClusterID
+}
+
+// EtcdSnapshotInfo the etcd snapshot saved on the etcd hosts
+type EtcdSnapshotInfo struct {
+	Name     string    `json:"name"`
+	FileName string    `json:"fileName"`
+	Size     int64     `json:"size"`
+	Created  time.Time `json:"created"`
+	// Hosts the etcd hosts that hold the snapshot
+	Hosts []string `json:"hosts"`
+}
@@ -0,0 +1,60 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package v1alpha1
+
+import v3 "github.com/rancher/rke/types"
+
+// DestroyCluster remove kubernetes from all nodes of the cluster and delete the cluster
+type DestroyCluster struct {
+	Provider  string `json:"provider"`
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+	ClusterID string `json:"clusterID"`
+	// RegionID the region the cluster is created in, the cloud resources of the cluster deleted
+	// by the previous task are deleted in it.
+	RegionID string `json:"regionID,omitempty"`
+}
+
+// Target returns the provider and the cluster of the operation, they are empty if c is nil
+func (c *DestroyCluster) Target() (provider, clusterID string) {
+	if c == nil {
+		return "", ""
+	}
+	return c.Provider, c.ClusterID
+}
+
+// RemoveNodes remove nodes from the cluster
+type RemoveNodes struct {
+	Provider  string `json:"provider"`
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+	ClusterID string `json:"clusterID"`
+	// Nodes the addresses of the nodes to be removed
+	Nodes []string `json:"nodes"`
+	// Drain the options to drain the nodes before removed, the default options are used if it is nil
+	Drain *v3.NodeDrainInput `json:"drain,omitempty"`
+}
+
+// Target returns the provider and the cluster of the operation, they are empty if c is nil
+func (c *RemoveNodes) Target() (provider, clusterID string) {
+	if c == nil {
+		return "", ""
+	}
+	return c.Provider, c.ClusterID
+}
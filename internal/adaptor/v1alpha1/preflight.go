@@ -0,0 +1,68 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package v1alpha1
+
+import v3 "github.com/rancher/rke/types"
+
+// the status of preflight checks
+var (
+	PreflightPassed  = "passed"
+	PreflightWarning = "warning"
+	PreflightFailed  = "failed"
+)
+
+// Preflight check the nodes of the rke config before installing kubernetes on them
+type Preflight struct {
+	Provider  string                            `json:"provider"`
+	RKEConfig *v3.RancherKubernetesEngineConfig `json:"rkeConfig"`
+	// NodeBastions the jump hosts of the nodes
+	NodeBastions NodeBastions `json:"nodeBastions,omitempty"`
+}
+
+// PreflightCheck the result of a check on the node
+type PreflightCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// NodePreflightReport the results of all checks on the node
+type NodePreflightReport struct {
+	Address  string            `json:"address"`
+	Hostname string            `json:"hostname,omitempty"`
+	Status   string            `json:"status"`
+	Checks   []*PreflightCheck `json:"checks"`
+}
+
+// PreflightReport the preflight report of all nodes
+type PreflightReport struct {
+	Status string                 `json:"status"`
+	Nodes  []*NodePreflightReport `json:"nodes"`
+}
+
+// Failed returns the failed checks of the node
+func (n *NodePreflightReport) Failed() []*PreflightCheck {
+	var failed []*PreflightCheck
+	for _, check := range n.Checks {
+		if check.Status == PreflightFailed {
+			failed = append(failed, check)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,48 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package v1alpha1
+
+// CloudResourceType the type of cloud resource created by adaptor
+type CloudResourceType string
+
+var (
+	// ResourceTypeRDS rds(mysql) instance
+	ResourceTypeRDS CloudResourceType = "rds"
+	// ResourceTypeNAS nas file system
+	ResourceTypeNAS CloudResourceType = "nas"
+	// ResourceTypeNASMountTarget nas mount target
+	ResourceTypeNASMountTarget CloudResourceType = "nas_mount_target"
+	// ResourceTypeSLB load balancer
+	ResourceTypeSLB CloudResourceType = "slb"
+	// ResourceTypeSecurityGroupRule ingress rule added to the cluster security group
+	ResourceTypeSecurityGroupRule CloudResourceType = "security_group_rule"
+)
+
+// CloudResource a cloud resource created by adaptor outside of the kubernetes cluster
+type CloudResource struct {
+	ClusterID string            `json:"clusterID"`
+	Type      CloudResourceType `json:"type"`
+	// CloudID the id of resource in cloud provider
+	CloudID  string `json:"cloudID"`
+	RegionID string `json:"regionID"`
+	// ParentID the id of resource that this resource belongs to, such as the file system of a mount target
+	ParentID string `json:"parentID,omitempty"`
+	// Spec extra attribute of the resource, such as the port range of a security group rule
+	Spec string `json:"spec,omitempty"`
+}
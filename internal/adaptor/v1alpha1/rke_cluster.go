@@ -201,5 +201,8 @@ func GetDefaultRKECreateClusterConfig(config KubernetesClusterConfig) CreateClus
 			Provider: "none",
 		},
 	}
+	if config.Bastion != nil {
+		rkeConfig.BastionHost = config.Bastion.RKEBastionHost()
+	}
 	return rkeConfig
 }
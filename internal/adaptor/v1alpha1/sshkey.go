@@ -0,0 +1,49 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package v1alpha1
+
+import "time"
+
+// RotateSSHKey rotate the ssh key used to connect the nodes of the cluster
+type RotateSSHKey struct {
+	Provider  string `json:"provider"`
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+	ClusterID string `json:"clusterID"`
+	// KeyType the type of the new key, rsa or ed25519
+	KeyType string `json:"keyType"`
+}
+
+// Target returns the provider and the cluster of the operation, they are empty if c is nil
+func (c *RotateSSHKey) Target() (provider, clusterID string) {
+	if c == nil {
+		return "", ""
+	}
+	return c.Provider, c.ClusterID
+}
+
+// SSHKeyInfo the public part of the ssh key used to connect the nodes of the cluster
+type SSHKeyInfo struct {
+	KeyType     string `json:"keyType"`
+	Fingerprint string `json:"fingerprint"`
+	PublicKey   string `json:"publicKey"`
+	// Shared the cluster has no key of its own, the ssh key of adaptor shared by all clusters is used
+	Shared    bool      `json:"shared"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+}
@@ -0,0 +1,104 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package v1alpha1
+
+import (
+	"net"
+	"os"
+
+	"github.com/wutong-paas/cloud-adaptor/pkg/util/cryptoutil"
+)
+
+// TKEClusterConfig tke create cluster config
+type TKEClusterConfig struct {
+	Name              string `json:"name,omitempty"`
+	RegionID          string `json:"region_id,omitempty"`
+	ZoneID            string `json:"zone_id,omitempty"`
+	VPCID             string `json:"vpc_id,omitempty"`
+	SubnetID          string `json:"subnet_id,omitempty"`
+	KubernetesVersion string `json:"kubernetes_version,omitempty"`
+	ClusterOS         string `json:"cluster_os,omitempty"`
+	ContainerRuntime  string `json:"container_runtime,omitempty"`
+	ClusterCIDR       string `json:"cluster_cidr,omitempty"`
+	ServiceCIDR       string `json:"service_cidr,omitempty"`
+	MaxNodePodNum     uint64 `json:"max_node_pod_num,omitempty"`
+	MaxServiceNum     uint64 `json:"max_service_num,omitempty"`
+	// worker nodes are created as postpaid cvm instances with cbs disks
+	WorkerInstanceType string `json:"worker_instance_type,omitempty"`
+	NumOfNodes         int    `json:"num_of_nodes,omitempty"`
+	SystemDiskType     string `json:"system_disk_type,omitempty"`
+	SystemDiskSize     int64  `json:"system_disk_size,omitempty"`
+	DataDiskType       string `json:"data_disk_type,omitempty"`
+	DataDiskSize       int64  `json:"data_disk_size,omitempty"`
+	DockerGraphPath    string `json:"docker_graph_path,omitempty"`
+	BandwidthOut       int64  `json:"bandwidth_out,omitempty"`
+	LoginPassword      string `json:"login_password,omitempty"`
+}
+
+// GetDefaultTKECreateClusterConfig get create tke cluster default config
+func GetDefaultTKECreateClusterConfig(config KubernetesClusterConfig) CreateClusterConfig {
+	kubernetesVersion := os.Getenv("DEFAULT_TKE_VERSION")
+	if kubernetesVersion == "" {
+		kubernetesVersion = "1.20.6"
+	}
+	if config.KubernetesVersion != "" {
+		kubernetesVersion = config.KubernetesVersion
+	}
+	// tke allocates the service cidr from the tail of the cluster cidr
+	// when the service cidr is not specified.
+	podIPRange := "172.20.0.0/16"
+	var serviceClusterIPRange string
+	if config.ClusterCIDR != "" {
+		if _, _, err := net.ParseCIDR(config.ClusterCIDR); err == nil {
+			podIPRange = config.ClusterCIDR
+		}
+	}
+	if config.ServiceCIDR != "" {
+		if _, _, err := net.ParseCIDR(config.ServiceCIDR); err == nil {
+			serviceClusterIPRange = config.ServiceCIDR
+		}
+	}
+	return &TKEClusterConfig{
+		Name:               config.ClusterName,
+		RegionID:           config.Region,
+		VPCID:              config.VpcID,
+		SubnetID:           config.VSwitchID,
+		KubernetesVersion:  kubernetesVersion,
+		ClusterOS:          "centos7.6.0_x64",
+		ContainerRuntime:   "docker",
+		ClusterCIDR:        podIPRange,
+		ServiceCIDR:        serviceClusterIPRange,
+		MaxNodePodNum:      64,
+		MaxServiceNum:      1024,
+		WorkerInstanceType: config.InstanceType,
+		NumOfNodes: func() int {
+			if config.WorkerNodeNum < 2 {
+				return 2
+			}
+			return config.WorkerNodeNum
+		}(),
+		SystemDiskType:  "CLOUD_PREMIUM",
+		SystemDiskSize:  50,
+		DataDiskType:    "CLOUD_PREMIUM",
+		DataDiskSize:    200,
+		DockerGraphPath: "/var/lib/docker",
+		BandwidthOut:    10,
+		LoginPassword:   cryptoutil.RandomPassword(12),
+	}
+}
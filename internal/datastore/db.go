@@ -19,6 +19,7 @@
 package datastore
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -26,6 +27,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/wutong-paas/cloud-adaptor/cmd/cloud-adaptor/config"
 	"github.com/wutong-paas/cloud-adaptor/internal/model"
+	"github.com/wutong-paas/cloud-adaptor/pkg/util/retryutil"
 	gmysql "gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/schema"
@@ -33,6 +35,10 @@ import (
 
 var gdb *gorm.DB
 
+// dbConnectPolicy the policy of connecting to the database, the database may start later than the adaptor
+var dbConnectPolicy = retryutil.Policy{MaxAttempts: 10, InitialInterval: time.Second, MaxInterval: 5 * time.Second, Multiplier: 2,
+	Jitter: 0.2, Retryable: retryutil.Always}
+
 // NewDB creates a new gorm.DB
 func NewDB() *gorm.DB {
 	var db *gorm.DB
@@ -55,19 +61,14 @@ func NewDB() *gorm.DB {
 		Timeout:              time.Second * 5,
 	}
 
-	retry := 10
-	for retry > 0 {
+	_ = dbConnectPolicy.Do(context.Background(), nil, func() error {
 		var err error
-
 		db, err = gorm.Open(gmysql.Open(mySQLConfig.FormatDSN()), gormCfg)
 		if err != nil {
-			logrus.Errorf("open db connection failure %s, will retry", err.Error())
-			time.Sleep(time.Second * 3)
-			retry--
-			continue
+			logrus.Errorf("open db connection failure %s", err.Error())
 		}
-		break
-	}
+		return err
+	})
 	gdb = db
 	return db
 }
@@ -84,11 +85,20 @@ func AutoMigrate(db *gorm.DB) error {
 		"CreateKubernetesTask": model.CreateKubernetesTask{},
 		"InitWutongTask":       model.InitWutongTask{},
 		"RKECluster":           model.RKECluster{},
+		"RKEClusterState":      model.RKEClusterState{},
+		"ClusterSSHKey":        model.ClusterSSHKey{},
 		"CustomCluster":        model.CustomCluster{},
+		"TKECluster":           model.TKECluster{},
 		"UpdateKubernetesTask": model.UpdateKubernetesTask{},
 		"WutongClusterConfig":  model.WutongClusterConfig{},
 		"AppStore":             model.AppStore{},
 		"TaskEvent":            model.TaskEvent{},
+		"CloudResource":        model.CloudResource{},
+		"Webhook":              model.Webhook{},
+		"WebhookDelivery":      model.WebhookDelivery{},
+		"QueuedTask":           model.QueuedTask{},
+		"Task":                 model.Task{},
+		"ClusterLock":          model.ClusterLock{},
 	}
 
 	for name, mod := range models {
@@ -21,11 +21,21 @@ package domain
 // ClusterTaskType -
 type ClusterTaskType string
 
-// ClusterTaskType -
+// ClusterTaskType the type of the task operating the cluster. The types of the operations are the names of
+// their task definitions and are saved as the task types of their tasks.
 var (
-	ClusterTaskTypeInitWutong       ClusterTaskType = "init-wutong"
-	ClusterTaskTypeCreateKubernetes ClusterTaskType = "create-kubernetes"
-	ClusterTaskTypeUpdateKubernetes ClusterTaskType = "update-kubernetes"
+	ClusterTaskTypeInitWutong              ClusterTaskType = "init-wutong"
+	ClusterTaskTypeCreateKubernetes        ClusterTaskType = "create-kubernetes"
+	ClusterTaskTypeUpdateKubernetes        ClusterTaskType = "update-kubernetes"
+	ClusterTaskTypeUpgradeKubernetes       ClusterTaskType = "upgrade"
+	ClusterTaskTypeEtcdSnapshot            ClusterTaskType = "etcd-snapshot"
+	ClusterTaskTypeEtcdRestore             ClusterTaskType = "etcd-restore"
+	ClusterTaskTypeRotateCertificates      ClusterTaskType = "rotate-certificates"
+	ClusterTaskTypeEnableSecretsEncryption ClusterTaskType = "enable-secrets-encryption"
+	ClusterTaskTypeRotateEncryptionKey     ClusterTaskType = "rotate-encryption-key"
+	ClusterTaskTypeRemoveNodes             ClusterTaskType = "remove-nodes"
+	ClusterTaskTypeDestroyCluster          ClusterTaskType = "destroy"
+	ClusterTaskTypeRotateSSHKey            ClusterTaskType = "rotate-ssh-key"
 )
 
 // Cluster -
@@ -42,4 +52,5 @@ type ClusterTask struct {
 	ProviderName string          `json:"providerName"`
 	TaskID       string          `json:"taskID"`
 	TaskType     ClusterTaskType `json:"taskType"`
+	Status       string          `json:"status"`
 }
@@ -0,0 +1,404 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package domain
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wutong-paas/cloud-adaptor/pkg/util/retryutil"
+)
+
+// TaskStep the step of task, it is the step type of task events
+type TaskStep string
+
+// TaskStep -
+const (
+	TaskStepInit                     TaskStep = "Init"
+	TaskStepPreflight                TaskStep = "Preflight"
+	TaskStepInitClusterConfig        TaskStep = "InitClusterConfig"
+	TaskStepInstallKubernetes        TaskStep = "InstallKubernetes"
+	TaskStepAllocateResource         TaskStep = "AllocateResource"
+	TaskStepSelectZone               TaskStep = "SelectZone"
+	TaskStepCreateVPC                TaskStep = "CreateVPC"
+	TaskStepCreateVSWitch            TaskStep = "CreateVSWitch"
+	TaskStepCreateCluster            TaskStep = "CreateCluster"
+	TaskStepCheckCluster             TaskStep = "CheckCluster"
+	TaskStepInitWutongRegionOperator TaskStep = "InitWutongRegionOperator"
+	TaskStepInitWutongRegionImageHub TaskStep = "InitWutongRegionImageHub"
+	TaskStepInitWutongRegionPackage  TaskStep = "InitWutongRegionPackage"
+	TaskStepInitWutongRegionConfig   TaskStep = "InitWutongRegionConfig"
+	TaskStepInitWutongRegion         TaskStep = "InitWutongRegion"
+	TaskStepUpdateKubernetes         TaskStep = "UpdateKubernetes"
+	TaskStepSnapshotEtcd             TaskStep = "SnapshotEtcd"
+	TaskStepUpgradeNode              TaskStep = "UpgradeNode"
+	TaskStepUpgradeKubernetes        TaskStep = "UpgradeKubernetes"
+	TaskStepSaveEtcdSnapshot         TaskStep = "SaveEtcdSnapshot"
+	TaskStepRestoreEtcdSnapshot      TaskStep = "RestoreEtcdSnapshot"
+	TaskStepRotateCertificates       TaskStep = "RotateCertificates"
+	TaskStepEnableSecretsEncryption  TaskStep = "EnableSecretsEncryption"
+	TaskStepRotateEncryptionKey      TaskStep = "RotateEncryptionKey"
+	TaskStepDrainNode                TaskStep = "DrainNode"
+	TaskStepCleanNode                TaskStep = "CleanNode"
+	TaskStepRemoveNodes              TaskStep = "RemoveNodes"
+	TaskStepDestroyCluster           TaskStep = "DestroyCluster"
+	TaskStepInitSSHKey               TaskStep = "InitSSHKey"
+	TaskStepPushSSHKey               TaskStep = "PushSSHKey"
+	TaskStepUpdateClusterConfig      TaskStep = "UpdateClusterConfig"
+	TaskStepRetireSSHKey             TaskStep = "RetireSSHKey"
+	TaskStepCreateNAS                TaskStep = "CreateNAS"
+	TaskStepCreateNASMount           TaskStep = "CreateNASMount"
+	TaskStepCreateRDS                TaskStep = "CreateRDS"
+	TaskStepCreateLoadBalancer       TaskStep = "CreateLoadBalancer"
+	TaskStepBoundLoadBalancer        TaskStep = "BoundLoadBalancer"
+	TaskStepSetSecurityGroup         TaskStep = "SetSecurityGroup"
+	TaskStepDeleteCluster            TaskStep = "DeleteCluster"
+	TaskStepDeleteLoadBalancer       TaskStep = "DeleteLoadBalancer"
+	TaskStepDeleteNAS                TaskStep = "DeleteNAS"
+	TaskStepDeleteRDS                TaskStep = "DeleteRDS"
+	TaskStepDeleteNetwork            TaskStep = "DeleteNetwork"
+	// TaskStepCreateTask the step of the event recorded when a task is accepted by the worker
+	TaskStepCreateTask TaskStep = "CreateTask"
+	// TaskStepCancelled the step of the event recorded when a task is cancelled
+	TaskStepCancelled TaskStep = "Cancelled"
+	// TaskStepInterrupted the step of the event recorded when the worker running a task is lost
+	TaskStepInterrupted TaskStep = "Interrupted"
+)
+
+// ForNode returns the step of the node, such as UpgradeNode/192.168.0.2, the events of the nodes are kept apart.
+func (s TaskStep) ForNode(address string) TaskStep {
+	return s + "/" + TaskStep(address)
+}
+
+// Base returns the step without the node, see ForNode.
+func (s TaskStep) Base() TaskStep {
+	if i := strings.Index(string(s), "/"); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// TaskStatus the status of task
+type TaskStatus string
+
+// TaskStatus -
+const (
+	TaskStatusStart TaskStatus = "start"
+	// TaskStatusComplete the task succeeded
+	TaskStatusComplete TaskStatus = "complete"
+	// TaskStatusFailed a step of the task failed
+	TaskStatusFailed TaskStatus = "failed"
+	// TaskStatusInited the wutong region is initialized
+	TaskStatusInited TaskStatus = "inited"
+	// TaskStatusCancelled the task is cancelled
+	TaskStatusCancelled TaskStatus = "cancelled"
+)
+
+// TerminalTaskStatuses the statuses of the finished tasks of all types, the status is not changed by the later
+// events. TaskStatusComplete includes the failed tasks saved before TaskStatusFailed was added.
+var TerminalTaskStatuses = []TaskStatus{TaskStatusComplete, TaskStatusFailed, TaskStatusInited, TaskStatusCancelled}
+
+// Terminal returns true if s is one of TerminalTaskStatuses
+func (s TaskStatus) Terminal() bool {
+	for _, status := range TerminalTaskStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// TerminalTaskStatusValues returns TerminalTaskStatuses as strings, such as to query the unfinished tasks.
+func TerminalTaskStatusValues() []string {
+	values := make([]string, 0, len(TerminalTaskStatuses))
+	for _, status := range TerminalTaskStatuses {
+		values = append(values, string(status))
+	}
+	return values
+}
+
+// The status of task events
+const (
+	TaskEventStart   = "start"
+	TaskEventSuccess = "success"
+	TaskEventFailure = "failure"
+	// TaskEventRetry the operation of the step failed and is retried
+	TaskEventRetry = retryutil.EventRetry
+)
+
+// TaskStepEvent the event of a step of task
+type TaskStepEvent struct {
+	Step   TaskStep
+	Status string
+}
+
+// TaskPlanStep the step in the plan of task with its status, the status is pending if the step does not start.
+type TaskPlanStep struct {
+	Step   TaskStep `json:"step"`
+	Status string   `json:"status"`
+}
+
+// TaskLifecycle the state machine of task. The task starts with TaskStatusStart, it moves to SuccessStatus once
+// one of SuccessSteps succeeds, to FailureStatus once any step fails, or to TaskStatusCancelled once it is cancelled.
+// These states are terminal, the status is not changed by the later events, so are TerminalTaskStatuses.
+type TaskLifecycle struct {
+	// Plans the steps of task in order by provider, the plan of the empty provider is the default.
+	// The optional steps are not in the plan.
+	Plans map[string][]TaskStep
+	// OptionalSteps the steps the task may run besides the plans, such as the steps of each node.
+	OptionalSteps []TaskStep
+	SuccessSteps  []TaskStep
+	SuccessStatus TaskStatus
+	FailureStatus TaskStatus
+}
+
+// Terminal returns true if the task is finished in status
+func (l *TaskLifecycle) Terminal(status TaskStatus) bool {
+	return status == l.SuccessStatus || status == l.FailureStatus || status.Terminal()
+}
+
+// commonSteps the steps of the events recorded for all tasks
+var commonSteps = []TaskStep{TaskStepCreateTask, TaskStepCancelled, TaskStepInterrupted}
+
+// Next returns the status of task after the event of step, it returns the current status if the event does not
+// change it. It returns an error if the step is not a step of the task, or the task is not running.
+func (l *TaskLifecycle) Next(current TaskStatus, step TaskStep, eventStatus string) (TaskStatus, error) {
+	if !l.HasStep(step) {
+		return current, fmt.Errorf("step %s is not a step of the task", step)
+	}
+	if l.Terminal(current) {
+		return current, fmt.Errorf("the task is finished in status %s", current)
+	}
+	// the status of the tasks saved without status
+	if current == "" {
+		current = TaskStatusStart
+	}
+	if current != TaskStatusStart {
+		return current, fmt.Errorf("unknown task status %s", current)
+	}
+	switch eventStatus {
+	case TaskEventStart, TaskEventRetry:
+	case TaskEventSuccess:
+		if l.isSuccessStep(step) {
+			return l.SuccessStatus, nil
+		}
+	case TaskEventFailure:
+		if step == TaskStepCancelled {
+			return TaskStatusCancelled, nil
+		}
+		return l.FailureStatus, nil
+	default:
+		return current, fmt.Errorf("unknown event status %s", eventStatus)
+	}
+	return current, nil
+}
+
+// HasStep returns true if step is in one of the plans, the optional steps or the steps of all tasks.
+// The step of a node is matched by its base step.
+func (l *TaskLifecycle) HasStep(step TaskStep) bool {
+	step = step.Base()
+	if containsStep(commonSteps, step) || containsStep(l.OptionalSteps, step) {
+		return true
+	}
+	for _, plan := range l.Plans {
+		if containsStep(plan, step) {
+			return true
+		}
+	}
+	return false
+}
+
+// Replay returns the status of task after the events in order, the events rejected by Next are ignored.
+func (l *TaskLifecycle) Replay(events []TaskStepEvent) TaskStatus {
+	status := TaskStatusStart
+	for _, event := range events {
+		if next, err := l.Next(status, event.Step, event.Status); err == nil {
+			status = next
+		}
+	}
+	return status
+}
+
+// Plan returns the steps of task run by provider
+func (l *TaskLifecycle) Plan(provider string) []TaskStep {
+	if plan, ok := l.Plans[provider]; ok {
+		return plan
+	}
+	return l.Plans[""]
+}
+
+// Progress returns the plan of task with the status of each step, and the percentage of the steps succeeded.
+// The progress is 100 only if the task succeeds.
+func (l *TaskLifecycle) Progress(provider string, events []TaskStepEvent) ([]TaskPlanStep, int) {
+	var succeeded bool
+	stepStatus := make(map[TaskStep]string)
+	for _, event := range events {
+		stepStatus[event.Step] = event.Status
+		if event.Status == TaskEventSuccess && l.isSuccessStep(event.Step) {
+			succeeded = true
+		}
+	}
+	plan := l.Plan(provider)
+	steps := make([]TaskPlanStep, 0, len(plan))
+	var done int
+	for _, step := range plan {
+		status := stepStatus[step]
+		if status == "" {
+			status = "pending"
+		}
+		if status == TaskEventSuccess {
+			done++
+		}
+		steps = append(steps, TaskPlanStep{Step: step, Status: status})
+	}
+	if succeeded {
+		return steps, 100
+	}
+	if len(plan) == 0 {
+		return steps, 0
+	}
+	progress := done * 100 / len(plan)
+	if progress > 99 {
+		progress = 99
+	}
+	return steps, progress
+}
+
+func (l *TaskLifecycle) isSuccessStep(step TaskStep) bool {
+	return containsStep(l.SuccessSteps, step)
+}
+
+func containsStep(steps []TaskStep, step TaskStep) bool {
+	for _, s := range steps {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
+
+// updateLifecycle returns the lifecycle of the task updating the rke cluster
+func updateLifecycle(successStep TaskStep, steps ...TaskStep) *TaskLifecycle {
+	plan := append([]TaskStep{TaskStepInit, TaskStepInitClusterConfig}, steps...)
+	return &TaskLifecycle{
+		Plans:         map[string][]TaskStep{"": append(plan, successStep)},
+		OptionalSteps: []TaskStep{TaskStepPreflight, TaskStepInitSSHKey},
+		SuccessSteps:  []TaskStep{successStep},
+		SuccessStatus: TaskStatusComplete,
+		FailureStatus: TaskStatusFailed,
+	}
+}
+
+// withPlan adds the plan of provider to the lifecycle, the success step is the last one of the default plan
+func (l *TaskLifecycle) withPlan(provider string, steps ...TaskStep) *TaskLifecycle {
+	plan := l.Plans[""]
+	l.Plans[provider] = append(append([]TaskStep{TaskStepInit, TaskStepInitClusterConfig}, steps...), plan[len(plan)-1])
+	return l
+}
+
+// withOptionalSteps adds the optional steps to the lifecycle
+func (l *TaskLifecycle) withOptionalSteps(steps ...TaskStep) *TaskLifecycle {
+	l.OptionalSteps = append(l.OptionalSteps, steps...)
+	return l
+}
+
+var taskLifecycles = map[ClusterTaskType]*TaskLifecycle{
+	ClusterTaskTypeCreateKubernetes: {
+		Plans: map[string][]TaskStep{
+			"":       {TaskStepInit, TaskStepInitClusterConfig, TaskStepInstallKubernetes},
+			"custom": {TaskStepInit, TaskStepCreateCluster},
+			"ack": {TaskStepInit, TaskStepAllocateResource, TaskStepSelectZone, TaskStepCreateVPC, TaskStepCreateVSWitch,
+				TaskStepCreateCluster},
+			"tke": {TaskStepInit, TaskStepAllocateResource, TaskStepSelectZone, TaskStepCreateVPC, TaskStepCreateVSWitch,
+				TaskStepCreateCluster},
+		},
+		OptionalSteps: []TaskStep{TaskStepPreflight, TaskStepInitSSHKey},
+		SuccessSteps:  []TaskStep{TaskStepCreateCluster, TaskStepInstallKubernetes},
+		SuccessStatus: TaskStatusComplete,
+		FailureStatus: TaskStatusFailed,
+	},
+	ClusterTaskTypeInitWutong: {
+		Plans: map[string][]TaskStep{
+			"": {TaskStepInit, TaskStepCheckCluster, TaskStepInitWutongRegionOperator, TaskStepInitWutongRegionImageHub,
+				TaskStepInitWutongRegionPackage, TaskStepInitWutongRegion},
+		},
+		// the config step is skipped if the region is initialized, the cloud resources are created by the provider
+		OptionalSteps: []TaskStep{TaskStepInitWutongRegionConfig, TaskStepCreateLoadBalancer, TaskStepBoundLoadBalancer,
+			TaskStepCreateNAS, TaskStepCreateNASMount, TaskStepCreateRDS, TaskStepSetSecurityGroup},
+		SuccessSteps:  []TaskStep{TaskStepInitWutongRegion},
+		SuccessStatus: TaskStatusInited,
+		FailureStatus: TaskStatusFailed,
+	},
+	ClusterTaskTypeUpdateKubernetes: updateLifecycle(TaskStepUpdateKubernetes),
+	ClusterTaskTypeUpgradeKubernetes: updateLifecycle(TaskStepUpgradeKubernetes, TaskStepSnapshotEtcd).
+		withOptionalSteps(TaskStepUpgradeNode),
+	ClusterTaskTypeEtcdSnapshot: {
+		Plans:         map[string][]TaskStep{"": {TaskStepInit, TaskStepSaveEtcdSnapshot}},
+		SuccessSteps:  []TaskStep{TaskStepSaveEtcdSnapshot},
+		SuccessStatus: TaskStatusComplete,
+		FailureStatus: TaskStatusFailed,
+	},
+	ClusterTaskTypeEtcdRestore:             updateLifecycle(TaskStepRestoreEtcdSnapshot),
+	ClusterTaskTypeRotateCertificates:      updateLifecycle(TaskStepRotateCertificates),
+	ClusterTaskTypeEnableSecretsEncryption: updateLifecycle(TaskStepEnableSecretsEncryption),
+	ClusterTaskTypeRotateEncryptionKey:     updateLifecycle(TaskStepRotateEncryptionKey),
+	ClusterTaskTypeRemoveNodes:             updateLifecycle(TaskStepRemoveNodes).withOptionalSteps(TaskStepDrainNode, TaskStepCleanNode),
+	ClusterTaskTypeRotateSSHKey:            updateLifecycle(TaskStepRetireSSHKey, TaskStepPushSSHKey, TaskStepUpdateClusterConfig),
+	// the ack and tke clusters are deleted by the cloud provider, the cloud resources of them are deleted then
+	ClusterTaskTypeDestroyCluster: updateLifecycle(TaskStepDestroyCluster).withPlan("ack", TaskStepDeleteCluster,
+		TaskStepDeleteLoadBalancer, TaskStepDeleteNAS, TaskStepDeleteRDS, TaskStepDeleteNetwork).withPlan("tke",
+		TaskStepDeleteCluster, TaskStepDeleteLoadBalancer, TaskStepDeleteNAS, TaskStepDeleteRDS),
+}
+
+// Lifecycle returns the lifecycle of the built-in task type, or nil if the type is defined by a task definition.
+func (t ClusterTaskType) Lifecycle() *TaskLifecycle {
+	return taskLifecycles[t]
+}
+
+// stepRetryPolicies the retry policies of the operations in steps, the steps not listed use retryutil.KubeAPIPolicy
+var stepRetryPolicies = map[TaskStep]retryutil.Policy{
+	TaskStepAllocateResource: retryutil.CloudAPIPolicy,
+	TaskStepSelectZone:       retryutil.CloudAPIPolicy,
+	TaskStepCheckCluster:     retryutil.CloudAPIPolicy,
+	TaskStepCreateVPC:        retryutil.CloudCreatePolicy,
+	TaskStepCreateVSWitch:    retryutil.CloudCreatePolicy,
+	TaskStepCreateCluster:    retryutil.CloudCreatePolicy,
+	// the resources of wutong region are reused if they exist
+	TaskStepCreateRDS:          retryutil.CloudCreatePolicy,
+	TaskStepCreateNAS:          retryutil.CloudCreatePolicy,
+	TaskStepCreateNASMount:     retryutil.CloudAPIPolicy,
+	TaskStepCreateLoadBalancer: retryutil.CloudCreatePolicy,
+	TaskStepBoundLoadBalancer:  retryutil.CloudAPIPolicy,
+	// the deletions are idempotent, the resources that do not exist are regarded as deleted
+	TaskStepDeleteLoadBalancer: retryutil.CloudAPIPolicy,
+	TaskStepDeleteNAS:          retryutil.CloudAPIPolicy,
+	TaskStepDeleteRDS:          retryutil.CloudAPIPolicy,
+	// the network can be deleted only after the nodes of the deleted cluster are released, it takes minutes
+	TaskStepDeleteNetwork: {MaxAttempts: 60, InitialInterval: 30 * time.Second, MaxInterval: 30 * time.Second,
+		Multiplier: 1, Retryable: retryutil.Always},
+}
+
+// RetryPolicy returns the retry policy of the operations in step
+func (s TaskStep) RetryPolicy() retryutil.Policy {
+	if policy, ok := stepRetryPolicies[s]; ok {
+		return policy
+	}
+	return retryutil.KubeAPIPolicy
+}
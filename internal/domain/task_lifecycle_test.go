@@ -0,0 +1,168 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package domain
+
+import "testing"
+
+func TestTaskLifecycleNext(t *testing.T) {
+	lifecycle := ClusterTaskTypeInitWutong.Lifecycle()
+	tests := []struct {
+		name    string
+		current TaskStatus
+		step    TaskStep
+		status  string
+		want    TaskStatus
+		wantErr bool
+	}{
+		{name: "step started", current: TaskStatusStart, step: TaskStepCheckCluster, status: TaskEventStart, want: TaskStatusStart},
+		{name: "step succeeded", current: TaskStatusStart, step: TaskStepCheckCluster, status: TaskEventSuccess, want: TaskStatusStart},
+		{name: "step retried", current: TaskStatusStart, step: TaskStepCheckCluster, status: TaskEventRetry, want: TaskStatusStart},
+		{name: "optional step succeeded", current: TaskStatusStart, step: TaskStepCreateNAS, status: TaskEventSuccess, want: TaskStatusStart},
+		{name: "success step succeeded", current: TaskStatusStart, step: TaskStepInitWutongRegion, status: TaskEventSuccess, want: TaskStatusInited},
+		{name: "step failed", current: TaskStatusStart, step: TaskStepCheckCluster, status: TaskEventFailure, want: TaskStatusFailed},
+		{name: "task not created", current: TaskStatusStart, step: TaskStepCreateTask, status: TaskEventFailure, want: TaskStatusFailed},
+		{name: "cancelled", current: TaskStatusStart, step: TaskStepCancelled, status: TaskEventFailure, want: TaskStatusCancelled},
+		{name: "task without status", current: "", step: TaskStepInitWutongRegion, status: TaskEventSuccess, want: TaskStatusInited},
+		{name: "unknown step", current: TaskStatusStart, step: TaskStepInstallKubernetes, status: TaskEventFailure, want: TaskStatusStart, wantErr: true},
+		{name: "unknown event status", current: TaskStatusStart, step: TaskStepCheckCluster, status: "unknown", want: TaskStatusStart, wantErr: true},
+		{name: "unknown task status", current: "unknown", step: TaskStepCheckCluster, status: TaskEventFailure, want: "unknown", wantErr: true},
+		{name: "failed task", current: TaskStatusFailed, step: TaskStepInitWutongRegion, status: TaskEventSuccess, want: TaskStatusFailed, wantErr: true},
+		{name: "task failed before the failed status", current: TaskStatusComplete, step: TaskStepInitWutongRegion, status: TaskEventSuccess, want: TaskStatusComplete, wantErr: true},
+		{name: "cancelled task", current: TaskStatusCancelled, step: TaskStepInitWutongRegion, status: TaskEventFailure, want: TaskStatusCancelled, wantErr: true},
+	}
+	for _, tc := range tests {
+		got, err := lifecycle.Next(tc.current, tc.step, tc.status)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: want error %v, got %v", tc.name, tc.wantErr, err)
+		}
+		if got != tc.want {
+			t.Errorf("%s: want status %s, got %s", tc.name, tc.want, got)
+		}
+	}
+
+	// the other update steps do not complete the task
+	rotate := ClusterTaskTypeRotateEncryptionKey.Lifecycle()
+	if got, err := rotate.Next(TaskStatusStart, TaskStepRotateEncryptionKey, TaskEventStart); err != nil || got != TaskStatusStart {
+		t.Errorf("want rotate encryption key task running, got %s %v", got, err)
+	}
+	if _, err := rotate.Next(TaskStatusStart, TaskStepEnableSecretsEncryption, TaskEventSuccess); err == nil {
+		t.Errorf("want the step of the other update rejected")
+	}
+
+	// the steps of nodes
+	upgrade := ClusterTaskTypeUpgradeKubernetes.Lifecycle()
+	if got, err := upgrade.Next(TaskStatusStart, TaskStepUpgradeNode.ForNode("192.168.0.2"), TaskEventSuccess); err != nil || got != TaskStatusStart {
+		t.Errorf("want the step of node accepted, got %s %v", got, err)
+	}
+	if _, err := ClusterTaskTypeRemoveNodes.Lifecycle().Next(TaskStatusStart, TaskStepUpgradeNode.ForNode("192.168.0.2"), TaskEventSuccess); err == nil {
+		t.Errorf("want the upgrade step of node rejected by the remove nodes task")
+	}
+}
+
+func TestTaskLifecycleReplay(t *testing.T) {
+	lifecycle := ClusterTaskTypeCreateKubernetes.Lifecycle()
+	status := lifecycle.Replay([]TaskStepEvent{
+		{Step: TaskStepInit, Status: TaskEventSuccess},
+		{Step: "Unknown", Status: TaskEventFailure},
+		{Step: TaskStepInstallKubernetes, Status: TaskEventSuccess},
+		{Step: TaskStepInstallKubernetes, Status: TaskEventFailure},
+	})
+	if status != TaskStatusComplete {
+		t.Errorf("want the unknown step and the events after the task is finished ignored, got %s", status)
+	}
+	status = lifecycle.Replay([]TaskStepEvent{
+		{Step: TaskStepInit, Status: TaskEventSuccess},
+		{Step: TaskStepCancelled, Status: TaskEventFailure},
+		{Step: TaskStepInstallKubernetes, Status: TaskEventSuccess},
+	})
+	if status != TaskStatusCancelled {
+		t.Errorf("want the task cancelled, got %s", status)
+	}
+}
+
+func TestTaskLifecycleProgress(t *testing.T) {
+	lifecycle := ClusterTaskTypeCreateKubernetes.Lifecycle()
+	events := []TaskStepEvent{
+		{Step: TaskStepInit, Status: TaskEventSuccess},
+		{Step: TaskStepAllocateResource, Status: TaskEventSuccess},
+		{Step: TaskStepSelectZone, Status: TaskEventStart},
+	}
+	steps, progress := lifecycle.Progress("ack", events)
+	if len(steps) != 6 || steps[2].Status != TaskEventStart || steps[3].Status != "pending" {
+		t.Fatalf("want the ack plan with the status of steps, got %+v", steps)
+	}
+	if progress != 33 {
+		t.Errorf("want progress 33, got %d", progress)
+	}
+
+	// the task succeeds without the optional steps
+	events = append(events, TaskStepEvent{Step: TaskStepCreateCluster, Status: TaskEventSuccess})
+	if _, progress := lifecycle.Progress("ack", events); progress != 100 {
+		t.Errorf("want progress 100 once the task succeeds, got %d", progress)
+	}
+	if status := lifecycle.Replay(events); status != TaskStatusComplete {
+		t.Errorf("want the task complete, got %s", status)
+	}
+
+	// the default plan
+	steps, progress = lifecycle.Progress("rke", []TaskStepEvent{{Step: TaskStepInit, Status: TaskEventFailure}})
+	if len(steps) != 3 || steps[2].Step != TaskStepInstallKubernetes || progress != 0 {
+		t.Errorf("want the default plan without progress, got %+v %d", steps, progress)
+	}
+}
+
+func TestDestroyClusterPlan(t *testing.T) {
+	lifecycle := ClusterTaskTypeDestroyCluster.Lifecycle()
+	plan := lifecycle.Plan("ack")
+	if len(plan) != 8 || plan[2] != TaskStepDeleteCluster || plan[7] != TaskStepDestroyCluster {
+		t.Fatalf("want the ack plan deleting the cloud resources, got %v", plan)
+	}
+	if plan := lifecycle.Plan("rke"); len(plan) != 3 || plan[2] != TaskStepDestroyCluster {
+		t.Errorf("want the default plan, got %v", plan)
+	}
+	// the cluster is destroyed only if the network is deleted
+	events := []TaskStepEvent{
+		{Step: TaskStepDeleteCluster, Status: TaskEventSuccess},
+		{Step: TaskStepDeleteNetwork, Status: TaskEventRetry},
+	}
+	if status := lifecycle.Replay(events); status != TaskStatusStart {
+		t.Errorf("want the task running, got %s", status)
+	}
+}
+
+func TestTaskStepForNode(t *testing.T) {
+	step := TaskStepUpgradeNode.ForNode("192.168.0.2")
+	if step != "UpgradeNode/192.168.0.2" {
+		t.Fatalf("unexpected step of node %s", step)
+	}
+	if step.Base() != TaskStepUpgradeNode || TaskStepUpgradeNode.Base() != TaskStepUpgradeNode {
+		t.Fatalf("unexpected base step %s", step.Base())
+	}
+}
+
+func TestTaskStatusTerminal(t *testing.T) {
+	for _, status := range TerminalTaskStatuses {
+		if !status.Terminal() {
+			t.Errorf("want status %s terminal", status)
+		}
+	}
+	if TaskStatusStart.Terminal() || TaskStatus("").Terminal() {
+		t.Errorf("want the running task not terminal")
+	}
+}
@@ -0,0 +1,96 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	v1 "github.com/wutong-paas/cloud-adaptor/api/cloud-adaptor/v1"
+	"github.com/wutong-paas/cloud-adaptor/internal/usecase"
+	"github.com/wutong-paas/cloud-adaptor/pkg/util/ginutil"
+)
+
+// CloudResourceHandler -
+type CloudResourceHandler struct {
+	cloudResource *usecase.CloudResourceUsecase
+}
+
+// NewCloudResourceHandler -
+func NewCloudResourceHandler(cloudResourceUsecase *usecase.CloudResourceUsecase) *CloudResourceHandler {
+	return &CloudResourceHandler{
+		cloudResource: cloudResourceUsecase,
+	}
+}
+
+// ListClusterResources returns the cloud resources created for the cluster.
+//
+// @Summary returns the cloud resources created for the cluster.
+// @Tags cluster
+// @ID listClusterResources
+// @Accept  json
+// @Produce  json
+// @Param clusterID path string true "the cluster id"
+// @Success 200 {object} v1.CloudResourceListRes
+// @Failure 500 {object} ginutil.Result
+// @Router /api/v1/kclusters/{clusterID}/cloud-resources [get]
+func (h *CloudResourceHandler) ListClusterResources(ctx *gin.Context) {
+	resources, err := h.cloudResource.ListByClusterID(ctx.Param("clusterID"))
+	if err != nil {
+		ginutil.JSON(ctx, nil, err)
+		return
+	}
+	ginutil.JSON(ctx, v1.CloudResourceListRes{Resources: resources}, nil)
+}
+
+// ListOrphanResources finds and returns the orphan cloud resources, whose cluster or init task failed.
+//
+// @Summary finds and returns the orphan cloud resources.
+// @Tags cluster
+// @ID listOrphanResources
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} v1.CloudResourceListRes
+// @Failure 500 {object} ginutil.Result
+// @Router /api/v1/cloud-resources/orphans [get]
+func (h *CloudResourceHandler) ListOrphanResources(ctx *gin.Context) {
+	resources, err := h.cloudResource.Reconcile(ctx.Request.Context())
+	if err != nil {
+		ginutil.JSON(ctx, nil, err)
+		return
+	}
+	ginutil.JSON(ctx, v1.CloudResourceListRes{Resources: resources}, nil)
+}
+
+// ReleaseOrphanResources releases the orphan cloud resources.
+//
+// @Summary releases the orphan cloud resources.
+// @Tags cluster
+// @ID releaseOrphanResources
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} v1.CloudResourceListRes
+// @Failure 500 {object} ginutil.Result
+// @Router /api/v1/cloud-resources/orphans/release [post]
+func (h *CloudResourceHandler) ReleaseOrphanResources(ctx *gin.Context) {
+	resources, err := h.cloudResource.ReleaseOrphans(ctx.Request.Context())
+	if err != nil {
+		ginutil.JSON(ctx, nil, err)
+		return
+	}
+	ginutil.JSON(ctx, v1.CloudResourceListRes{Resources: resources}, nil)
+}
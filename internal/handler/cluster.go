@@ -19,14 +19,20 @@
 package handler
 
 import (
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ghodss/yaml"
+	"github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	v1 "github.com/wutong-paas/cloud-adaptor/api/cloud-adaptor/v1"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor"
 	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
 	"github.com/wutong-paas/cloud-adaptor/internal/usecase"
 	"github.com/wutong-paas/cloud-adaptor/pkg/bcode"
@@ -47,6 +53,20 @@ func NewClusterHandler(clusterUsecase *usecase.ClusterUsecase) *ClusterHandler {
 	}
 }
 
+// ListProviders returns the registered providers and their capabilities.
+//
+// @Summary returns the registered providers and their capabilities.
+// @Tags cluster
+// @ID listProviders
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} v1.ProviderListRes
+// @Failure 500 {object} ginutil.Result
+// @Router /api/v1/providers [get]
+func (e *ClusterHandler) ListProviders(ctx *gin.Context) {
+	ginutil.JSON(ctx, v1.ProviderListRes{Providers: e.cluster.ListProviders()}, nil)
+}
+
 // ListKubernetesClusters returns the information of .
 //
 // swagger:route GET /enterprise-server/api/v1/kclusters cloud kcluster
@@ -100,17 +120,18 @@ func (e *ClusterHandler) AddKubernetesCluster(ctx *gin.Context) {
 		ginutil.Error(ctx, err)
 		return
 	}
-	if req.Provider == "rke" {
-		if req.EncodedRKEConfig == "" {
-			ginutil.JSON(ctx, nil, bcode.ErrIncorrectRKEConfig)
-			return
-		}
+	provider, ok := adaptor.GetProvider(req.Provider)
+	if !ok {
+		ginutil.JSON(ctx, nil, bcode.ErrorProviderNotSupport)
+		return
 	}
-	if req.Provider == "custom" {
-		if req.KubeConfig == "" {
-			ginutil.JSON(ctx, nil, bcode.ErrKubeConfigCannotEmpty)
-			return
-		}
+	if provider.Capabilities.RKEConfig && req.EncodedRKEConfig == "" {
+		ginutil.JSON(ctx, nil, bcode.ErrIncorrectRKEConfig)
+		return
+	}
+	if provider.Capabilities.KubeConfig && req.KubeConfig == "" {
+		ginutil.JSON(ctx, nil, bcode.ErrKubeConfigCannotEmpty)
+		return
 	}
 	task, err := e.cluster.CreateKubernetesCluster(req)
 	if err != nil {
@@ -137,11 +158,21 @@ func (e *ClusterHandler) UpdateKubernetesCluster(ctx *gin.Context) {
 		ginutil.Error(ctx, err)
 		return
 	}
-	if req.Provider == "rke" {
+	provider, ok := adaptor.GetProvider(req.Provider)
+	if !ok {
+		ginutil.Error(ctx, bcode.ErrorProviderNotSupport)
+		return
+	}
+	if provider.Capabilities.RKEConfig {
 		if req.EncodedRKEConfig == "" {
 			ginutil.Error(ctx, errors.WithMessage(bcode.ErrIncorrectRKEConfig, "rke config is required"))
 			return
 		}
+	} else {
+		if req.WorkerNodeNum <= 0 {
+			ginutil.Error(ctx, errors.WithMessage(bcode.BadRequest, "workerNum must be greater than 0"))
+			return
+		}
 	}
 	task, err := e.cluster.UpdateKubernetesCluster(req)
 	if err != nil {
@@ -151,6 +182,426 @@ func (e *ClusterHandler) UpdateKubernetesCluster(ctx *gin.Context) {
 	ginutil.JSONv2(ctx, task)
 }
 
+// UpgradeKubernetesCluster upgrades the kubernetes version of the cluster.
+//
+// @Summary upgrades the kubernetes version of the cluster.
+// @Tags cluster
+// @ID upgradeKubernetesCluster
+// @Accept  json
+// @Produce  json
+// @Param clusterID path string true "the cluster id"
+// @Param upgradeKubernetesReq body v1.UpgradeKubernetesReq true "."
+// @Success 200 {object} v1.UpdateKubernetesTask
+// @Failure 500 {object} ginutil.Result
+// @Router /api/v1/kclusters/{clusterID}/upgrade [post]
+func (e *ClusterHandler) UpgradeKubernetesCluster(ctx *gin.Context) {
+	var req v1.UpgradeKubernetesReq
+	if err := ginutil.ShouldBindJSON(ctx, &req); err != nil {
+		ginutil.Error(ctx, err)
+		return
+	}
+	task, err := e.cluster.UpgradeKubernetesCluster(ctx.Param("clusterID"), req)
+	if err != nil {
+		ginutil.JSONv2(ctx, task, err)
+		return
+	}
+	ginutil.JSONv2(ctx, task)
+}
+
+// ListEtcdSnapshots lists the etcd snapshots of the cluster.
+//
+// @Summary lists the etcd snapshots of the cluster.
+// @Tags cluster
+// @ID listEtcdSnapshots
+// @Accept  json
+// @Produce  json
+// @Param clusterID path string true "the cluster id"
+// @Param provider_name query string true "the provider name"
+// @Success 200 {object} v1.EtcdSnapshotListRes
+// @Failure 500 {object} ginutil.Result
+// @Router /api/v1/kclusters/{clusterID}/etcd-snapshots [get]
+func (e *ClusterHandler) ListEtcdSnapshots(ctx *gin.Context) {
+	var req v1.ListEtcdSnapshotsReq
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		logrus.Errorf("bind query param failure %s", err.Error())
+		ginutil.JSON(ctx, nil, bcode.BadRequest)
+		return
+	}
+	re, err := e.cluster.ListEtcdSnapshots(ctx.Request.Context(), ctx.Param("clusterID"), req.ProviderName)
+	if err != nil {
+		ginutil.JSON(ctx, nil, err)
+		return
+	}
+	ginutil.JSON(ctx, re, nil)
+}
+
+// SaveEtcdSnapshot saves an etcd snapshot of the cluster.
+//
+// @Summary saves an etcd snapshot of the cluster.
+// @Tags cluster
+// @ID saveEtcdSnapshot
+// @Accept  json
+// @Produce  json
+// @Param clusterID path string true "the cluster id"
+// @Param etcdSnapshotReq body v1.EtcdSnapshotReq true "."
+// @Success 200 {object} v1.UpdateKubernetesTask
+// @Failure 500 {object} ginutil.Result
+// @Router /api/v1/kclusters/{clusterID}/etcd-snapshots [post]
+func (e *ClusterHandler) SaveEtcdSnapshot(ctx *gin.Context) {
+	var req v1.EtcdSnapshotReq
+	if err := ginutil.ShouldBindJSON(ctx, &req); err != nil {
+		ginutil.Error(ctx, err)
+		return
+	}
+	task, err := e.cluster.SaveEtcdSnapshot(ctx.Param("clusterID"), req)
+	if err != nil {
+		ginutil.JSONv2(ctx, task, err)
+		return
+	}
+	ginutil.JSONv2(ctx, task)
+}
+
+// RestoreEtcdSnapshot restores the cluster from the named etcd snapshot.
+//
+// @Summary restores the cluster from the named etcd snapshot.
+// @Tags cluster
+// @ID restoreEtcdSnapshot
+// @Accept  json
+// @Produce  json
+// @Param clusterID path string true "the cluster id"
+// @Param etcdSnapshotReq body v1.EtcdSnapshotReq true "."
+// @Success 200 {object} v1.UpdateKubernetesTask
+// @Failure 500 {object} ginutil.Result
+// @Router /api/v1/kclusters/{clusterID}/etcd-snapshots/restore [post]
+func (e *ClusterHandler) RestoreEtcdSnapshot(ctx *gin.Context) {
+	var req v1.EtcdSnapshotReq
+	if err := ginutil.ShouldBindJSON(ctx, &req); err != nil {
+		ginutil.Error(ctx, err)
+		return
+	}
+	task, err := e.cluster.RestoreEtcdSnapshot(ctx.Param("clusterID"), req)
+	if err != nil {
+		ginutil.JSONv2(ctx, task, err)
+		return
+	}
+	ginutil.JSONv2(ctx, task)
+}
+
+// SetEtcdBackupConfig sets the scheduled etcd snapshot config of the cluster.
+//
+// @Summary sets the scheduled etcd snapshot config of the cluster.
+// @Tags cluster
+// @ID setEtcdBackupConfig
+// @Accept  json
+// @Produce  json
+// @Param clusterID path string true "the cluster id"
+// @Param etcdBackupConfigReq body v1.EtcdBackupConfigReq true "."
+// @Success 200 {object} v1.UpdateKubernetesTask
+// @Failure 500 {object} ginutil.Result
+// @Router /api/v1/kclusters/{clusterID}/etcd-backup-config [put]
+func (e *ClusterHandler) SetEtcdBackupConfig(ctx *gin.Context) {
+	var req v1.EtcdBackupConfigReq
+	if err := ginutil.ShouldBindJSON(ctx, &req); err != nil {
+		ginutil.Error(ctx, err)
+		return
+	}
+	task, err := e.cluster.SetEtcdBackupConfig(ctx.Param("clusterID"), req)
+	if err != nil {
+		ginutil.JSONv2(ctx, task, err)
+		return
+	}
+	ginutil.JSONv2(ctx, task)
+}
+
+// ListCertificates lists the certificates of the cluster and their expiration.
+//
+// @Summary lists the certificates of the cluster and their expiration.
+// @Tags cluster
+// @ID listCertificates
+// @Accept  json
+// @Produce  json
+// @Param clusterID path string true "the cluster id"
+// @Param provider_name query string true "the provider name"
+// @Success 200 {object} v1.CertificateListRes
+// @Failure 500 {object} ginutil.Result
+// @Router /api/v1/kclusters/{clusterID}/certificates [get]
+func (e *ClusterHandler) ListCertificates(ctx *gin.Context) {
+	var req v1.ListCertificatesReq
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		logrus.Errorf("bind query param failure %s", err.Error())
+		ginutil.JSON(ctx, nil, bcode.BadRequest)
+		return
+	}
+	re, err := e.cluster.GetCertificates(ctx.Param("clusterID"), req.ProviderName)
+	if err != nil {
+		ginutil.JSON(ctx, nil, err)
+		return
+	}
+	ginutil.JSON(ctx, re, nil)
+}
+
+// Preflight checks the nodes over ssh before installing kubernetes on them.
+//
+// @Summary checks the nodes over ssh before installing kubernetes on them.
+// @Tags cluster
+// @ID preflight
+// @Accept  json
+// @Produce  json
+// @Param preflightReq body v1.PreflightReq true "."
+// @Success 200 {object} v1alpha1.PreflightReport
+// @Failure 500 {object} ginutil.Result
+// @Router /api/v1/kclusters/preflight [post]
+func (e *ClusterHandler) Preflight(ctx *gin.Context) {
+	var req v1.PreflightReq
+	if err := ginutil.ShouldBindJSON(ctx, &req); err != nil {
+		ginutil.Error(ctx, err)
+		return
+	}
+	report, err := e.cluster.Preflight(ctx.Request.Context(), req)
+	if err != nil {
+		ginutil.JSONv2(ctx, report, err)
+		return
+	}
+	ginutil.JSONv2(ctx, report)
+}
+
+// ListClusterStates lists the stored state versions of the cluster.
+//
+// @Summary lists the stored state versions of the cluster.
+// @Tags cluster
+// @ID listClusterStates
+// @Accept  json
+// @Produce  json
+// @Param clusterID path string true "the cluster id"
+// @Param provider_name query string true "the provider name"
+// @Success 200 {object} v1.ClusterStateListRes
+// @Failure 500 {object} ginutil.Result
+// @Router /api/v1/kclusters/{clusterID}/states [get]
+func (e *ClusterHandler) ListClusterStates(ctx *gin.Context) {
+	var req v1.ListClusterStatesReq
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		logrus.Errorf("bind query param failure %s", err.Error())
+		ginutil.JSON(ctx, nil, bcode.BadRequest)
+		return
+	}
+	re, err := e.cluster.ListClusterStates(ctx.Param("clusterID"), req.ProviderName)
+	if err != nil {
+		ginutil.JSON(ctx, nil, err)
+		return
+	}
+	ginutil.JSON(ctx, re, nil)
+}
+
+// RollbackClusterState rolls back the state of the cluster to the given version.
+//
+// @Summary rolls back the state of the cluster to the given version.
+// @Tags cluster
+// @ID rollbackClusterState
+// @Accept  json
+// @Produce  json
+// @Param clusterID path string true "the cluster id"
+// @Param rollbackClusterStateReq body v1.RollbackClusterStateReq true "."
+// @Success 200 {object} v1alpha1.ClusterStateVersion
+// @Failure 500 {object} ginutil.Result
+// @Router /api/v1/kclusters/{clusterID}/states/rollback [post]
+func (e *ClusterHandler) RollbackClusterState(ctx *gin.Context) {
+	var req v1.RollbackClusterStateReq
+	if err := ginutil.ShouldBindJSON(ctx, &req); err != nil {
+		ginutil.Error(ctx, err)
+		return
+	}
+	state, err := e.cluster.RollbackClusterState(ctx.Param("clusterID"), req)
+	if err != nil {
+		ginutil.JSONv2(ctx, state, err)
+		return
+	}
+	ginutil.JSONv2(ctx, state)
+}
+
+// RotateCertificates rotates the certificates of the cluster.
+//
+// @Summary rotates the certificates of the cluster.
+// @Tags cluster
+// @ID rotateCertificates
+// @Accept  json
+// @Produce  json
+// @Param clusterID path string true "the cluster id"
+// @Param rotateCertificatesReq body v1.RotateCertificatesReq true "."
+// @Success 200 {object} v1.UpdateKubernetesTask
+// @Failure 500 {object} ginutil.Result
+// @Router /api/v1/kclusters/{clusterID}/certificates/rotate [post]
+func (e *ClusterHandler) RotateCertificates(ctx *gin.Context) {
+	var req v1.RotateCertificatesReq
+	if err := ginutil.ShouldBindJSON(ctx, &req); err != nil {
+		ginutil.Error(ctx, err)
+		return
+	}
+	task, err := e.cluster.RotateCertificates(ctx.Param("clusterID"), req)
+	if err != nil {
+		ginutil.JSONv2(ctx, task, err)
+		return
+	}
+	ginutil.JSONv2(ctx, task)
+}
+
+// GetSSHKey returns the public part of the ssh key used to connect the nodes of the cluster.
+//
+// @Summary returns the public part of the ssh key used to connect the nodes of the cluster.
+// @Tags cluster
+// @ID getSSHKey
+// @Accept  json
+// @Produce  json
+// @Param clusterID path string true "the cluster id"
+// @Param provider_name query string true "the provider name"
+// @Success 200 {object} v1alpha1.SSHKeyInfo
+// @Failure 500 {object} ginutil.Result
+// @Router /api/v1/kclusters/{clusterID}/ssh-key [get]
+func (e *ClusterHandler) GetSSHKey(ctx *gin.Context) {
+	var req v1.GetSSHKeyReq
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		logrus.Errorf("bind query param failure %s", err.Error())
+		ginutil.JSON(ctx, nil, bcode.BadRequest)
+		return
+	}
+	re, err := e.cluster.GetSSHKey(ctx.Param("clusterID"), req.ProviderName)
+	if err != nil {
+		ginutil.JSON(ctx, nil, err)
+		return
+	}
+	ginutil.JSON(ctx, re, nil)
+}
+
+// RotateSSHKey rotates the ssh key used to connect the nodes of the cluster.
+//
+// @Summary rotates the ssh key used to connect the nodes of the cluster.
+// @Tags cluster
+// @ID rotateSSHKey
+// @Accept  json
+// @Produce  json
+// @Param clusterID path string true "the cluster id"
+// @Param rotateSSHKeyReq body v1.RotateSSHKeyReq true "."
+// @Success 200 {object} v1.UpdateKubernetesTask
+// @Failure 500 {object} ginutil.Result
+// @Router /api/v1/kclusters/{clusterID}/ssh-key/rotate [post]
+func (e *ClusterHandler) RotateSSHKey(ctx *gin.Context) {
+	var req v1.RotateSSHKeyReq
+	if err := ginutil.ShouldBindJSON(ctx, &req); err != nil {
+		ginutil.Error(ctx, err)
+		return
+	}
+	task, err := e.cluster.RotateSSHKey(ctx.Param("clusterID"), req)
+	if err != nil {
+		ginutil.JSONv2(ctx, task, err)
+		return
+	}
+	ginutil.JSONv2(ctx, task)
+}
+
+// GetSecretsEncryptionState returns the secrets encryption state of the cluster.
+//
+// @Summary returns the secrets encryption state of the cluster.
+// @Tags cluster
+// @ID getSecretsEncryptionState
+// @Accept  json
+// @Produce  json
+// @Param clusterID path string true "the cluster id"
+// @Param provider_name query string true "the provider name"
+// @Success 200 {object} v1alpha1.SecretsEncryptionState
+// @Failure 500 {object} ginutil.Result
+// @Router /api/v1/kclusters/{clusterID}/secrets-encryption [get]
+func (e *ClusterHandler) GetSecretsEncryptionState(ctx *gin.Context) {
+	var req v1.GetSecretsEncryptionReq
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		logrus.Errorf("bind query param failure %s", err.Error())
+		ginutil.JSON(ctx, nil, bcode.BadRequest)
+		return
+	}
+	re, err := e.cluster.GetSecretsEncryptionState(ctx.Param("clusterID"), req.ProviderName)
+	if err != nil {
+		ginutil.JSON(ctx, nil, err)
+		return
+	}
+	ginutil.JSON(ctx, re, nil)
+}
+
+// EnableSecretsEncryption enables the secrets encryption at rest of the cluster.
+//
+// @Summary enables the secrets encryption at rest of the cluster.
+// @Tags cluster
+// @ID enableSecretsEncryption
+// @Accept  json
+// @Produce  json
+// @Param clusterID path string true "the cluster id"
+// @Param secretsEncryptionReq body v1.SecretsEncryptionReq true "."
+// @Success 200 {object} v1.UpdateKubernetesTask
+// @Failure 500 {object} ginutil.Result
+// @Router /api/v1/kclusters/{clusterID}/secrets-encryption/enable [post]
+func (e *ClusterHandler) EnableSecretsEncryption(ctx *gin.Context) {
+	var req v1.SecretsEncryptionReq
+	if err := ginutil.ShouldBindJSON(ctx, &req); err != nil {
+		ginutil.Error(ctx, err)
+		return
+	}
+	task, err := e.cluster.EnableSecretsEncryption(ctx.Param("clusterID"), req)
+	if err != nil {
+		ginutil.JSONv2(ctx, task, err)
+		return
+	}
+	ginutil.JSONv2(ctx, task)
+}
+
+// RotateEncryptionKey rotates the secrets encryption key of the cluster.
+//
+// @Summary rotates the secrets encryption key of the cluster.
+// @Tags cluster
+// @ID rotateEncryptionKey
+// @Accept  json
+// @Produce  json
+// @Param clusterID path string true "the cluster id"
+// @Param secretsEncryptionReq body v1.SecretsEncryptionReq true "."
+// @Success 200 {object} v1.UpdateKubernetesTask
+// @Failure 500 {object} ginutil.Result
+// @Router /api/v1/kclusters/{clusterID}/secrets-encryption/rotate-key [post]
+func (e *ClusterHandler) RotateEncryptionKey(ctx *gin.Context) {
+	var req v1.SecretsEncryptionReq
+	if err := ginutil.ShouldBindJSON(ctx, &req); err != nil {
+		ginutil.Error(ctx, err)
+		return
+	}
+	task, err := e.cluster.RotateEncryptionKey(ctx.Param("clusterID"), req)
+	if err != nil {
+		ginutil.JSONv2(ctx, task, err)
+		return
+	}
+	ginutil.JSONv2(ctx, task)
+}
+
+// RemoveNodes removes nodes from the cluster.
+//
+// @Summary removes nodes from the cluster.
+// @Tags cluster
+// @ID removeNodes
+// @Accept  json
+// @Produce  json
+// @Param clusterID path string true "the cluster id"
+// @Param removeNodesReq body v1.RemoveNodesReq true "."
+// @Success 200 {object} v1.UpdateKubernetesTask
+// @Failure 500 {object} ginutil.Result
+// @Router /api/v1/kclusters/{clusterID}/nodes/remove [post]
+func (e *ClusterHandler) RemoveNodes(ctx *gin.Context) {
+	var req v1.RemoveNodesReq
+	if err := ginutil.ShouldBindJSON(ctx, &req); err != nil {
+		ginutil.Error(ctx, err)
+		return
+	}
+	task, err := e.cluster.RemoveNodes(ctx.Param("clusterID"), req)
+	if err != nil {
+		ginutil.JSONv2(ctx, task, err)
+		return
+	}
+	ginutil.JSONv2(ctx, task)
+}
+
 // GetUpdateKubernetesTask returns the information of the cluster.
 //
 // @Summary  returns the information of the cluster.
@@ -198,12 +649,22 @@ func (e *ClusterHandler) DeleteKubernetesCluster(ctx *gin.Context) {
 		return
 	}
 	clusterID := ctx.Param("clusterID")
-	err := e.cluster.DeleteKubernetesCluster(clusterID, req.ProviderName)
+	if req.Mode == "destroy" {
+		task, err := e.cluster.DestroyKubernetesCluster(clusterID, req)
+		if err != nil {
+			ginutil.JSONv2(ctx, task, err)
+			return
+		}
+		ginutil.JSONv2(ctx, task)
+		return
+	}
+	// the destroy task is returned if the cluster is deleted by it
+	task, err := e.cluster.DeleteKubernetesCluster(clusterID, req)
 	if err != nil {
 		ginutil.JSON(ctx, nil, err)
 		return
 	}
-	ginutil.JSON(ctx, nil, nil)
+	ginutil.JSON(ctx, task, nil)
 }
 
 // GetLastAddKubernetesClusterTask returns the information of .
@@ -281,12 +742,89 @@ func (e *ClusterHandler) GetAddKubernetesClusterTask(ctx *gin.Context) {
 // 500: body:Reponse
 func (e *ClusterHandler) GetTaskEventList(ctx *gin.Context) {
 	taskID := ctx.Param("taskID")
-	events, err := e.cluster.ListTaskEvent(taskID)
+	res, err := e.cluster.GetTaskEvents(taskID)
+	ginutil.JSON(ctx, res, err)
+}
+
+// CancelTask cancels the task.
+//
+// @Summary cancels the task, a running task stops at the next step.
+// @Tags cluster
+// @ID cancelTask
+// @Accept  json
+// @Produce  json
+// @Param taskID path string true "the task id"
+// @Success 200 {object} v1.CancelTaskRes
+// @Failure 404 {object} ginutil.Result "7029, cluster task not found"
+// @Failure 409 {object} ginutil.Result "7050, the task is not running"
+// @Router /api/v1/tasks/{taskID}/cancel [post]
+func (e *ClusterHandler) CancelTask(ctx *gin.Context) {
+	res, err := e.cluster.CancelTask(ctx.Param("taskID"))
+	if err != nil {
+		ginutil.Error(ctx, err)
+		return
+	}
+	ginutil.JSONv2(ctx, res)
+}
+
+// GetTask returns the task.
+//
+// @Summary returns the task with its status and its position in the queue.
+// @Tags cluster
+// @ID getTask
+// @Accept  json
+// @Produce  json
+// @Param taskID path string true "the task id"
+// @Success 200 {object} v1.TaskInfo
+// @Failure 404 {object} ginutil.Result "7029, cluster task not found"
+// @Router /api/v1/tasks/{taskID} [get]
+func (e *ClusterHandler) GetTask(ctx *gin.Context) {
+	res, err := e.cluster.GetTask(ctx.Param("taskID"))
+	if err != nil {
+		ginutil.Error(ctx, err)
+		return
+	}
+	ginutil.JSONv2(ctx, res)
+}
+
+// StreamTaskEvents streams the events of task as server-sent events.
+//
+// @Summary streams the events of task as server-sent events, an event is sent again every time it is updated.
+// @Description The id of sse event is the update time of task event in unix nanoseconds, the stream resumes from the Last-Event-ID header.
+// @Tags cluster
+// @ID streamTaskEvents
+// @Produce  text/event-stream
+// @Param taskID path string true "the task id"
+// @Param Last-Event-ID header string false "the id of the last event received"
+// @Success 200 {object} model.TaskEvent
+// @Failure 404 {object} ginutil.Result
+// @Router /api/v1/tasks/{taskID}/events/stream [get]
+func (e *ClusterHandler) StreamTaskEvents(ctx *gin.Context) {
+	var since time.Time
+	if lastEventID := ctx.GetHeader("Last-Event-ID"); lastEventID != "" {
+		nano, err := strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil {
+			ginutil.JSON(ctx, nil, errors.Wrap(bcode.BadRequest, "invalid Last-Event-ID"))
+			return
+		}
+		since = time.Unix(0, nano)
+	}
+	events, err := e.cluster.WatchTaskEvents(ctx.Request.Context(), ctx.Param("taskID"), since)
 	if err != nil {
 		ginutil.JSON(ctx, nil, err)
 		return
 	}
-	ginutil.JSON(ctx, v1.TaskEventListRes{Events: events}, nil)
+	streamSSE(ctx, func(heartbeat <-chan time.Time) (*sse.Event, bool) {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return nil, false
+			}
+			return &sse.Event{Id: strconv.FormatInt(event.UpdatedAt.UnixNano(), 10), Event: "task_event", Data: event}, true
+		case <-heartbeat:
+			return nil, true
+		}
+	})
 }
 
 // AddAccessKey add access keys
@@ -482,10 +1020,76 @@ func (e *ClusterHandler) UpdateInitWutongTaskStatus(ctx *gin.Context) {
 // Responses:
 // 200: body:InitNodeCmdRes
 func (e *ClusterHandler) GetInitNodeCmd(c *gin.Context) {
-	res, err := e.cluster.GetInitNodeCmd(c.Request.Context())
+	var req v1.InitNodeCmdReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		logrus.Errorf("bind query param failure %s", err.Error())
+		ginutil.JSON(c, nil, bcode.BadRequest)
+		return
+	}
+	res, err := e.cluster.GetInitNodeCmd(c.Request.Context(), req, requestBaseURL(c))
 	ginutil.JSONv2(c, res, err)
 }
 
+// GetInitNodeScript returns the script to initialize the node.
+//
+// @Summary returns the script to initialize the node.
+// @Tags cluster
+// @ID getInitNodeScript
+// @Produce  plain
+// @Param user query string false "the ssh user used by rke"
+// @Param hostname query string false "the hostname of the node"
+// @Param dockerVersion query string false "the docker version"
+// @Param clusterID query string false "the rke cluster the node joins, its public key is authorized on the node"
+// @Success 200 {string} string
+// @Failure 500 {object} ginutil.Result
+// @Router /api/v1/init_node_script [get]
+func (e *ClusterHandler) GetInitNodeScript(c *gin.Context) {
+	var req v1.InitNodeCmdReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		logrus.Errorf("bind query param failure %s", err.Error())
+		ginutil.JSON(c, nil, bcode.BadRequest)
+		return
+	}
+	script, err := e.cluster.GetInitNodeScript(c.Request.Context(), req, requestBaseURL(c))
+	if err != nil {
+		ginutil.JSON(c, nil, err)
+		return
+	}
+	c.Data(http.StatusOK, "text/x-shellscript; charset=utf-8", script)
+}
+
+// GetOfflineDockerPackage returns the docker package for the offline nodes.
+//
+// @Summary returns the docker package for the offline nodes.
+// @Tags cluster
+// @ID getOfflineDockerPackage
+// @Produce  octet-stream
+// @Param file path string true "the package name, such as docker-19.03.5.tgz"
+// @Success 200 {file} file
+// @Failure 500 {object} ginutil.Result
+// @Router /api/v1/init_node_script/{file} [get]
+func (e *ClusterHandler) GetOfflineDockerPackage(c *gin.Context) {
+	dockerVersion := strings.TrimSuffix(strings.TrimPrefix(c.Param("file"), "docker-"), ".tgz")
+	packagePath, err := e.cluster.GetOfflineDockerPackage(dockerVersion)
+	if err != nil {
+		ginutil.JSON(c, nil, err)
+		return
+	}
+	c.File(packagePath)
+}
+
+// requestBaseURL the url of adaptor requested by the client, its host is checked before use as it can be forged
+func requestBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return fmt.Sprintf("%s://%s", scheme, c.Request.Host)
+}
+
 // GetLogContent get rke create kubernetes log
 //
 // swagger:route GET /enterprise-server/api/v1kclusters/{clusterID}/create_log cloud init
@@ -511,6 +1115,50 @@ func (e *ClusterHandler) GetLogContent(ctx *gin.Context) {
 	ginutil.JSON(ctx, v1.GetLogContentRes{Content: string(content)}, nil)
 }
 
+// StreamCreateLog follows the create log of rke cluster as server-sent events.
+//
+// @Summary follows the create log of rke cluster as server-sent events.
+// @Description The id of sse event is the offset of the end of log chunk, the stream resumes from the Last-Event-ID header or the offset.
+// @Tags cluster
+// @ID streamCreateLog
+// @Produce  text/event-stream
+// @Param clusterID path string true "the cluster id"
+// @Param offset query int false "the offset in the log file to start from"
+// @Param Last-Event-ID header string false "the id of the last event received"
+// @Success 200 {object} v1.CreateLogChunk
+// @Failure 404 {object} ginutil.Result
+// @Router /api/v1/kclusters/{clusterID}/createlog/stream [get]
+func (e *ClusterHandler) StreamCreateLog(ctx *gin.Context) {
+	offset := ctx.Query("offset")
+	if lastEventID := ctx.GetHeader("Last-Event-ID"); lastEventID != "" {
+		offset = lastEventID
+	}
+	var start int64
+	if offset != "" {
+		var err error
+		if start, err = strconv.ParseInt(offset, 10, 64); err != nil {
+			ginutil.JSON(ctx, nil, errors.Wrap(bcode.BadRequest, "invalid offset"))
+			return
+		}
+	}
+	chunks, err := e.cluster.WatchCreateLog(ctx.Request.Context(), ctx.Param("clusterID"), start)
+	if err != nil {
+		ginutil.JSON(ctx, nil, err)
+		return
+	}
+	streamSSE(ctx, func(heartbeat <-chan time.Time) (*sse.Event, bool) {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				return nil, false
+			}
+			return &sse.Event{Id: strconv.FormatInt(chunk.Offset, 10), Event: "log", Data: chunk}, true
+		case <-heartbeat:
+			return nil, true
+		}
+	})
+}
+
 // ReInstallKubernetesCluster retry install rke cluster .
 //
 // swagger:route GET /enterprise-server/api/v1kclusters/{clusterID}/reinstall cloud kcluster
@@ -23,4 +23,4 @@ import (
 )
 
 // ProviderSet is handler providers.
-var ProviderSet = wire.NewSet(NewRouter, NewClusterHandler, NewAppStoreHandler, NewSystemHandler)
+var ProviderSet = wire.NewSet(NewRouter, NewClusterHandler, NewAppStoreHandler, NewSystemHandler, NewCloudResourceHandler, NewWebhookHandler)
@@ -31,10 +31,12 @@ import (
 
 // Router -
 type Router struct {
-	middleware *middleware.Middleware
-	cluster    *ClusterHandler
-	system     *SystemHandler
-	appStore   *AppStoreHandler
+	middleware    *middleware.Middleware
+	cluster       *ClusterHandler
+	system        *SystemHandler
+	appStore      *AppStoreHandler
+	cloudResource *CloudResourceHandler
+	webhook       *WebhookHandler
 }
 
 // NewRouter creates a new router.
@@ -43,12 +45,16 @@ func NewRouter(
 	cluster *ClusterHandler,
 	appStore *AppStoreHandler,
 	system *SystemHandler,
+	cloudResource *CloudResourceHandler,
+	webhook *WebhookHandler,
 ) *Router {
 	return &Router{
-		middleware: middleware,
-		cluster:    cluster,
-		appStore:   appStore,
-		system:     system,
+		middleware:    middleware,
+		cluster:       cluster,
+		appStore:      appStore,
+		system:        system,
+		cloudResource: cloudResource,
+		webhook:       webhook,
 	}
 }
 
@@ -81,6 +87,9 @@ func (r *Router) NewRouter() *gin.Engine {
 	apiv1.GET("/backup", r.system.Backup)
 	apiv1.POST("/recover", r.system.Recover)
 	apiv1.GET("/init_node_cmd", r.cluster.GetInitNodeCmd)
+	apiv1.GET("/init_node_script", r.cluster.GetInitNodeScript)
+	apiv1.GET("/init_node_script/:file", r.cluster.GetOfflineDockerPackage)
+	apiv1.GET("/providers", r.cluster.ListProviders)
 	// cluster
 	apiv1.GET("/kclusters", r.cluster.ListKubernetesClusters)
 	apiv1.POST("/kclusters", r.cluster.AddKubernetesCluster)
@@ -88,23 +97,47 @@ func (r *Router) NewRouter() *gin.Engine {
 	apiv1.DELETE("/kclusters/:clusterID", r.cluster.DeleteKubernetesCluster)
 	apiv1.POST("/kclusters/:clusterID/reinstall", r.cluster.ReInstallKubernetesCluster)
 	apiv1.GET("/kclusters/:clusterID/createlog", r.cluster.GetLogContent)
+	apiv1.GET("/kclusters/:clusterID/createlog/stream", r.cluster.StreamCreateLog)
 	apiv1.GET("/kclusters/:clusterID/kubeconfig", r.cluster.GetKubeConfig)
 	apiv1.GET("/kclusters/:clusterID/wutongcluster", r.cluster.GetWutongClusterConfig)
 	apiv1.PUT("/kclusters/:clusterID/wutongcluster", r.cluster.SetWutongClusterConfig)
 	apiv1.POST("/kclusters/:clusterID/uninstall", r.cluster.UninstallRegion)
 	apiv1.POST("/kclusters/prune-update-rkeconfig", r.cluster.pruneUpdateRKEConfig)
+	apiv1.POST("/kclusters/preflight", r.cluster.Preflight)
 
 	clusterv1 := apiv1.Group("/kclusters/:clusterID")
 	{
 		clusterv1.GET("/wutong-components", r.cluster.listWutongComponents)
 		clusterv1.GET("/wutong-components/:podName/events", r.cluster.listPodEvents)
+		clusterv1.GET("/cloud-resources", r.cloudResource.ListClusterResources)
+		clusterv1.POST("/upgrade", r.cluster.UpgradeKubernetesCluster)
+		clusterv1.GET("/etcd-snapshots", r.cluster.ListEtcdSnapshots)
+		clusterv1.POST("/etcd-snapshots", r.cluster.SaveEtcdSnapshot)
+		clusterv1.POST("/etcd-snapshots/restore", r.cluster.RestoreEtcdSnapshot)
+		clusterv1.PUT("/etcd-backup-config", r.cluster.SetEtcdBackupConfig)
+		clusterv1.GET("/certificates", r.cluster.ListCertificates)
+		clusterv1.POST("/certificates/rotate", r.cluster.RotateCertificates)
+		clusterv1.GET("/secrets-encryption", r.cluster.GetSecretsEncryptionState)
+		clusterv1.POST("/secrets-encryption/enable", r.cluster.EnableSecretsEncryption)
+		clusterv1.POST("/secrets-encryption/rotate-key", r.cluster.RotateEncryptionKey)
+		clusterv1.POST("/nodes/remove", r.cluster.RemoveNodes)
+		clusterv1.GET("/states", r.cluster.ListClusterStates)
+		clusterv1.POST("/states/rollback", r.cluster.RollbackClusterState)
+		clusterv1.GET("/ssh-key", r.cluster.GetSSHKey)
+		clusterv1.POST("/ssh-key/rotate", r.cluster.RotateSSHKey)
 	}
 
+	apiv1.GET("/cloud-resources/orphans", r.cloudResource.ListOrphanResources)
+	apiv1.POST("/cloud-resources/orphans/release", r.cloudResource.ReleaseOrphanResources)
+
 	apiv1.POST("/accesskey", r.cluster.AddAccessKey)
 	apiv1.GET("/accesskey", r.cluster.GetAccessKey)
 	apiv1.GET("/last-ck-task", r.cluster.GetLastAddKubernetesClusterTask)
 	apiv1.GET("/ck-task/:taskID", r.cluster.GetAddKubernetesClusterTask)
+	apiv1.GET("/tasks/:taskID", r.cluster.GetTask)
 	apiv1.GET("/tasks/:taskID/events", r.cluster.GetTaskEventList)
+	apiv1.GET("/tasks/:taskID/events/stream", r.cluster.StreamTaskEvents)
+	apiv1.POST("/tasks/:taskID/cancel", r.cluster.CancelTask)
 	apiv1.GET("/init-task/:clusterID", r.cluster.GetInitWutongTask)
 	apiv1.GET("/init-tasks", r.cluster.GetRunningInitWutongTask)
 	apiv1.POST("/init-cluster", r.cluster.CreateInitWutongTask)
@@ -113,6 +146,14 @@ func (r *Router) NewRouter() *gin.Engine {
 	apiv1.POST("/update-cluster", r.cluster.UpdateKubernetesCluster)
 	apiv1.GET("/update-cluster/:clusterID", r.cluster.GetUpdateKubernetesTask)
 
+	// webhook
+	apiv1.POST("/webhooks", r.webhook.Create)
+	apiv1.GET("/webhooks", r.webhook.List)
+	apiv1.GET("/webhooks/:webhookID", r.webhook.Get)
+	apiv1.PUT("/webhooks/:webhookID", r.webhook.Update)
+	apiv1.DELETE("/webhooks/:webhookID", r.webhook.Delete)
+	apiv1.GET("/webhooks/:webhookID/deliveries", r.webhook.ListDeliveries)
+
 	// app store
 	appstoresv1 := apiv1.Group("/appstores")
 	appstoresv1.POST("", r.appStore.Create)
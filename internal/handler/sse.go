@@ -0,0 +1,57 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package handler
+
+import (
+	"io"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// sseHeartbeatInterval the interval to send a comment to keep the stream alive through the proxies
+const sseHeartbeatInterval = 15 * time.Second
+
+// streamSSE write the events returned by next to the client as server-sent events, until next returns
+// false or the client is gone. next blocks until an event is ready or the heartbeat ticks, it returns
+// a nil event on heartbeat.
+func streamSSE(ctx *gin.Context, next func(heartbeat <-chan time.Time) (*sse.Event, bool)) {
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	// disable the response buffering of nginx
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.Status(200)
+	ctx.Writer.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+	ctx.Stream(func(w io.Writer) bool {
+		event, ok := next(heartbeat.C)
+		if !ok {
+			return false
+		}
+		if event == nil {
+			_, err := io.WriteString(w, ": ping\n\n")
+			return err == nil
+		}
+		return sse.Encode(w, *event) == nil
+	})
+}
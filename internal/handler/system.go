@@ -66,6 +66,23 @@ func (s SystemHandler) Backup(ctx *gin.Context) {
 	s.db.Model(&model.RKECluster{}).Scan(&result.RKEClusters)
 	s.db.Model(&model.WutongClusterConfig{}).Scan(&result.WutongClusterConfigs)
 	s.db.Model(&model.AppStore{}).Scan(&result.AppStores)
+	s.db.Model(&model.CloudResource{}).Scan(&result.CloudResources)
+	var sshKeys []model.ClusterSSHKey
+	s.db.Model(&model.ClusterSSHKey{}).Scan(&sshKeys)
+	for _, key := range sshKeys {
+		result.ClusterSSHKeys = append(result.ClusterSSHKeys, model.BackupClusterSSHKey{ClusterSSHKey: key, EncryptedPrivateKey: key.PrivateKey})
+	}
+	s.db.Model(&model.TKECluster{}).Scan(&result.TKEClusters)
+	var webhooks []model.Webhook
+	s.db.Model(&model.Webhook{}).Scan(&webhooks)
+	for _, webhook := range webhooks {
+		result.Webhooks = append(result.Webhooks, model.BackupWebhook{Webhook: webhook, EncryptedSecret: webhook.Secret})
+	}
+	var rkeStates []model.RKEClusterState
+	s.db.Model(&model.RKEClusterState{}).Scan(&rkeStates)
+	for _, state := range rkeStates {
+		result.RKEClusterStates = append(result.RKEClusterStates, model.BackupRKEClusterState{RKEClusterState: state, ClusterConfig: state.Config, ClusterState: state.State})
+	}
 	data, err := json.Marshal(result)
 	if err != nil {
 		ginutil.JSON(ctx, nil, err)
@@ -94,6 +111,14 @@ func (s SystemHandler) Backup(ctx *gin.Context) {
 		ginutil.JSON(ctx, nil, err)
 		return
 	}
+	//backup the generated encrypt key, the private keys of clusters and the secrets of webhooks can not be decrypted without it
+	if _, err := os.Stat(path.Join(configDir, "encrypt.key")); err == nil {
+		if err := exec.Command("cp", path.Join(configDir, "encrypt.key"), backupTmpPath).Run(); err != nil {
+			logrus.Errorf("write backup encrypt key failure %s", err.Error())
+			ginutil.JSON(ctx, nil, err)
+			return
+		}
+	}
 	//backup ssh key
 	if _, err := os.Stat(path.Join(homedir.HomeDir(), ".ssh", "id_rsa")); err == nil {
 		tarSSHPackgeFile := path.Join(backupTmpPath, "ssh.tar.gz")
@@ -181,6 +206,19 @@ func (s SystemHandler) Recover(c *gin.Context) {
 		}
 		logrus.Infof("recover rke backup data success")
 	}
+	// recover the generated encrypt key, it is used after cloud adaptor restarts
+	keyPath := path.Join(recoverPath, "encrypt.key")
+	if _, err := os.Stat(keyPath); err == nil {
+		configDir := "/tmp"
+		if os.Getenv("CONFIG_DIR") != "" {
+			configDir = os.Getenv("CONFIG_DIR")
+		}
+		if err := exec.Command("cp", keyPath, path.Join(configDir, "encrypt.key")).Run(); err != nil {
+			logrus.Errorf("recover encrypt key failure %s", err.Error())
+		} else {
+			logrus.Infof("recover encrypt key success, restart cloud adaptor to use it")
+		}
+	}
 	// recover ssh data
 	sshPath := path.Join(recoverPath, "ssh.tar.gz")
 	sshFile, err := os.Stat(sshPath)
@@ -244,6 +282,21 @@ func (s SystemHandler) Recover(c *gin.Context) {
 				if err := tx.Where("1 = 1").Delete(&model.AppStore{}).Error; err != nil {
 					return err
 				}
+				if err := tx.Where("1 = 1").Delete(&model.CloudResource{}).Error; err != nil {
+					return err
+				}
+				if err := tx.Where("1 = 1").Delete(&model.ClusterSSHKey{}).Error; err != nil {
+					return err
+				}
+				if err := tx.Where("1 = 1").Delete(&model.RKEClusterState{}).Error; err != nil {
+					return err
+				}
+				if err := tx.Where("1 = 1").Delete(&model.TKECluster{}).Error; err != nil {
+					return err
+				}
+				if err := tx.Where("1 = 1").Delete(&model.Webhook{}).Error; err != nil {
+					return err
+				}
 
 				for _, accessKey := range data.CloudAccessKeys {
 					if err := tx.Create(&accessKey).Error; err != nil {
@@ -291,6 +344,37 @@ func (s SystemHandler) Recover(c *gin.Context) {
 						return fmt.Errorf("recover appStores failure %s", err.Error())
 					}
 				}
+				for _, resource := range data.CloudResources {
+					if err := tx.Create(&resource).Error; err != nil {
+						return fmt.Errorf("recover cloudResources failure %s", err.Error())
+					}
+				}
+				for _, key := range data.ClusterSSHKeys {
+					sshKey := key.ClusterSSHKey
+					sshKey.PrivateKey = key.EncryptedPrivateKey
+					if err := tx.Create(&sshKey).Error; err != nil {
+						return fmt.Errorf("recover clusterSSHKeys failure %s", err.Error())
+					}
+				}
+				for _, state := range data.RKEClusterStates {
+					rkeState := state.RKEClusterState
+					rkeState.Config, rkeState.State = state.ClusterConfig, state.ClusterState
+					if err := tx.Create(&rkeState).Error; err != nil {
+						return fmt.Errorf("recover rkeClusterStates failure %s", err.Error())
+					}
+				}
+				for _, tkeCluster := range data.TKEClusters {
+					if err := tx.Create(&tkeCluster).Error; err != nil {
+						return fmt.Errorf("recover tkeClusters failure %s", err.Error())
+					}
+				}
+				for _, webhook := range data.Webhooks {
+					hook := webhook.Webhook
+					hook.Secret = webhook.EncryptedSecret
+					if err := tx.Create(&hook).Error; err != nil {
+						return fmt.Errorf("recover webhooks failure %s", err.Error())
+					}
+				}
 				logrus.Infof("recover db backup data success")
 				return nil
 			}(); err != nil {
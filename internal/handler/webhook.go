@@ -0,0 +1,174 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	v1 "github.com/wutong-paas/cloud-adaptor/api/cloud-adaptor/v1"
+	"github.com/wutong-paas/cloud-adaptor/internal/usecase"
+	"github.com/wutong-paas/cloud-adaptor/pkg/bcode"
+	"github.com/wutong-paas/cloud-adaptor/pkg/util/ginutil"
+)
+
+// WebhookHandler -
+type WebhookHandler struct {
+	webhook *usecase.WebhookUsecase
+}
+
+// NewWebhookHandler -
+func NewWebhookHandler(webhookUsecase *usecase.WebhookUsecase) *WebhookHandler {
+	return &WebhookHandler{
+		webhook: webhookUsecase,
+	}
+}
+
+// Create creates a webhook for the task events.
+//
+// @Summary creates a webhook for the task events.
+// @Tags webhook
+// @ID createWebhook
+// @Accept  json
+// @Produce  json
+// @Param webhookReq body v1.WebhookReq true "."
+// @Success 200 {object} v1.WebhookInfo
+// @Failure 500 {object} ginutil.Result
+// @Router /api/v1/webhooks [post]
+func (w *WebhookHandler) Create(ctx *gin.Context) {
+	var req v1.WebhookReq
+	if err := ginutil.ShouldBindJSON(ctx, &req); err != nil {
+		ginutil.Error(ctx, err)
+		return
+	}
+	webhook, err := w.webhook.CreateWebhook(&req)
+	if err != nil {
+		ginutil.Error(ctx, err)
+		return
+	}
+	ginutil.JSONv2(ctx, webhook)
+}
+
+// List returns a list of webhooks.
+//
+// @Summary returns a list of webhooks.
+// @Tags webhook
+// @ID listWebhooks
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} v1.WebhookListRes
+// @Failure 500 {object} ginutil.Result
+// @Router /api/v1/webhooks [get]
+func (w *WebhookHandler) List(ctx *gin.Context) {
+	webhooks, err := w.webhook.ListWebhooks()
+	if err != nil {
+		ginutil.Error(ctx, err)
+		return
+	}
+	ginutil.JSONv2(ctx, &v1.WebhookListRes{Webhooks: webhooks})
+}
+
+// Get returns the webhook.
+//
+// @Summary returns the webhook.
+// @Tags webhook
+// @ID getWebhook
+// @Accept  json
+// @Produce  json
+// @Param webhookID path string true "the webhook id"
+// @Success 200 {object} v1.WebhookInfo
+// @Failure 404 {object} ginutil.Result "7049, webhook not found"
+// @Router /api/v1/webhooks/{webhookID} [get]
+func (w *WebhookHandler) Get(ctx *gin.Context) {
+	webhook, err := w.webhook.GetWebhook(ctx.Param("webhookID"))
+	if err != nil {
+		ginutil.Error(ctx, err)
+		return
+	}
+	ginutil.JSONv2(ctx, webhook)
+}
+
+// Update updates the webhook.
+//
+// @Summary updates the webhook, the secret is kept if it is empty.
+// @Tags webhook
+// @ID updateWebhook
+// @Accept  json
+// @Produce  json
+// @Param webhookID path string true "the webhook id"
+// @Param webhookReq body v1.WebhookReq true "."
+// @Success 200 {object} v1.WebhookInfo
+// @Failure 404 {object} ginutil.Result "7049, webhook not found"
+// @Router /api/v1/webhooks/{webhookID} [put]
+func (w *WebhookHandler) Update(ctx *gin.Context) {
+	var req v1.WebhookReq
+	if err := ginutil.ShouldBindJSON(ctx, &req); err != nil {
+		ginutil.Error(ctx, err)
+		return
+	}
+	webhook, err := w.webhook.UpdateWebhook(ctx.Param("webhookID"), &req)
+	if err != nil {
+		ginutil.Error(ctx, err)
+		return
+	}
+	ginutil.JSONv2(ctx, webhook)
+}
+
+// Delete deletes the webhook and its delivery logs.
+//
+// @Summary deletes the webhook and its delivery logs.
+// @Tags webhook
+// @ID deleteWebhook
+// @Accept  json
+// @Produce  json
+// @Param webhookID path string true "the webhook id"
+// @Success 200
+// @Failure 404 {object} ginutil.Result "7049, webhook not found"
+// @Router /api/v1/webhooks/{webhookID} [delete]
+func (w *WebhookHandler) Delete(ctx *gin.Context) {
+	if err := w.webhook.DeleteWebhook(ctx.Param("webhookID")); err != nil {
+		ginutil.Error(ctx, err)
+		return
+	}
+	ginutil.JSONv2(ctx, nil)
+}
+
+// ListDeliveries returns the latest deliveries of the webhook.
+//
+// @Summary returns the latest deliveries of the webhook.
+// @Tags webhook
+// @ID listWebhookDeliveries
+// @Accept  json
+// @Produce  json
+// @Param webhookID path string true "the webhook id"
+// @Param limit query int false "the number of the latest deliveries"
+// @Success 200 {object} v1.WebhookDeliveryListRes
+// @Failure 404 {object} ginutil.Result "7049, webhook not found"
+// @Router /api/v1/webhooks/{webhookID}/deliveries [get]
+func (w *WebhookHandler) ListDeliveries(ctx *gin.Context) {
+	var req v1.ListWebhookDeliveriesReq
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ginutil.Error(ctx, bcode.NewBadRequest(err.Error()))
+		return
+	}
+	deliveries, err := w.webhook.ListDeliveries(ctx.Param("webhookID"), req.Limit)
+	if err != nil {
+		ginutil.Error(ctx, err)
+		return
+	}
+	ginutil.JSONv2(ctx, &v1.WebhookDeliveryListRes{Deliveries: deliveries})
+}
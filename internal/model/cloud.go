@@ -48,6 +48,16 @@ type InitWutongTask struct {
 	Status    string `gorm:"column:status" json:"status"`
 }
 
+// Task the task of a kind registered by task definition, TaskType is the name of the definition.
+type Task struct {
+	Model
+	TaskID    string `gorm:"column:task_id;uniqueIndex;type:varchar(64)" json:"taskID"`
+	TaskType  string `gorm:"column:task_type;type:varchar(64)" json:"taskType"`
+	ClusterID string `gorm:"column:cluster_id;index;type:varchar(64)" json:"clusterID"`
+	Provider  string `gorm:"column:provider_name" json:"providerName"`
+	Status    string `gorm:"column:status" json:"status"`
+}
+
 // UpdateKubernetesTask -
 type UpdateKubernetesTask struct {
 	Model
@@ -58,6 +68,10 @@ type UpdateKubernetesTask struct {
 	Provider   string `gorm:"column:provider_name" json:"providerName"`
 	NodeNumber int    `gorm:"column:node_number" json:"nodeNumber"`
 	Status     string `gorm:"column:status" json:"status"`
+	// TaskType is empty for expansion node. The tasks of the other operations are saved as Task now,
+	// their types are kept for the tasks saved before.
+	TaskType          string `gorm:"column:task_type" json:"taskType"`
+	KubernetesVersion string `gorm:"column:kubernetes_version" json:"kubernetesVersion"`
 }
 
 // TaskEvent task event
@@ -71,15 +85,64 @@ type TaskEvent struct {
 	Reason   string `gorm:"column:reason" json:"reason"`
 }
 
+// CloudResource the cloud resource created by adaptor outside of the kubernetes cluster, such as rds, nas and slb
+type CloudResource struct {
+	Model
+	TaskID    string `gorm:"column:task_id" json:"taskID"`
+	Provider  string `gorm:"column:provider_name" json:"providerName"`
+	ClusterID string `gorm:"column:cluster_id;index;type:varchar(64)" json:"clusterID"`
+	Type      string `gorm:"column:type;uniqueIndex:cloud_resource;type:varchar(32)" json:"type"`
+	CloudID   string `gorm:"column:cloud_id;uniqueIndex:cloud_resource;type:varchar(128)" json:"cloudID"`
+	Spec      string `gorm:"column:spec;uniqueIndex:cloud_resource;type:varchar(64)" json:"spec"`
+	RegionID  string `gorm:"column:region_id" json:"regionID"`
+	ParentID  string `gorm:"column:parent_id" json:"parentID"`
+	State     string `gorm:"column:state" json:"state"`
+	Message   string `gorm:"column:message;size:512" json:"message"`
+}
+
+var (
+	// CloudResourceStateCreated the resource is created and in use
+	CloudResourceStateCreated = "created"
+	// CloudResourceStateOrphan the cluster or the task of the resource failed, it can be released
+	CloudResourceStateOrphan = "orphan"
+	// CloudResourceStateReleased the resource is released
+	CloudResourceStateReleased = "released"
+)
+
 // BackupListModelData list all model data
 type BackupListModelData struct {
-	CloudAccessKeys       []CloudAccessKey       `json:"cloud_access_keys"`
-	CreateKubernetesTasks []CreateKubernetesTask `json:"create_kubernetes_tasks"`
-	InitWutongTasks       []InitWutongTask       `json:"init_wutong_tasks"`
-	TaskEvents            []TaskEvent            `json:"task_events"`
-	UpdateKubernetesTasks []UpdateKubernetesTask `json:"update_kubernetes_tasks"`
-	CustomClusters        []CustomCluster        `json:"custom_clusters"`
-	RKEClusters           []RKECluster           `json:"rke_clusters"`
-	WutongClusterConfigs  []WutongClusterConfig  `json:"wutong_cluster_configs"`
-	AppStores             []AppStore             `json:"app_stores"`
+	CloudAccessKeys       []CloudAccessKey        `json:"cloud_access_keys"`
+	CreateKubernetesTasks []CreateKubernetesTask  `json:"create_kubernetes_tasks"`
+	InitWutongTasks       []InitWutongTask        `json:"init_wutong_tasks"`
+	TaskEvents            []TaskEvent             `json:"task_events"`
+	UpdateKubernetesTasks []UpdateKubernetesTask  `json:"update_kubernetes_tasks"`
+	CustomClusters        []CustomCluster         `json:"custom_clusters"`
+	RKEClusters           []RKECluster            `json:"rke_clusters"`
+	WutongClusterConfigs  []WutongClusterConfig   `json:"wutong_cluster_configs"`
+	AppStores             []AppStore              `json:"app_stores"`
+	CloudResources        []CloudResource         `json:"cloud_resources"`
+	ClusterSSHKeys        []BackupClusterSSHKey   `json:"cluster_ssh_keys"`
+	RKEClusterStates      []BackupRKEClusterState `json:"rke_cluster_states"`
+	TKEClusters           []TKECluster            `json:"tke_clusters"`
+	// Webhooks the subscriptions of task events, the delivery logs are not backed up
+	Webhooks []BackupWebhook `json:"webhooks"`
+}
+
+// BackupClusterSSHKey the cluster ssh key in backup, the private key is kept encrypted by the encrypt key.
+type BackupClusterSSHKey struct {
+	ClusterSSHKey
+	EncryptedPrivateKey string `json:"encrypted_private_key"`
+}
+
+// BackupRKEClusterState the state of rke cluster in backup, the config and the state are kept encrypted by the encrypt key.
+type BackupRKEClusterState struct {
+	RKEClusterState
+	ClusterConfig string `json:"cluster_config"`
+	ClusterState  string `json:"cluster_state"`
+}
+
+// BackupWebhook the webhook in backup, the secret is kept encrypted by the encrypt key.
+type BackupWebhook struct {
+	Webhook
+	EncryptedSecret string `json:"encrypted_secret"`
 }
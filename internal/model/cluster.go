@@ -35,8 +35,43 @@ type RKECluster struct {
 	NodeList  string `gorm:"column:nodeList;type:text" json:"nodeList,omitempty"`
 	Stats     string `gorm:"column:stats" json:"stats,omitempty"`
 	RKEConfig string `gorm:"column:rkeConfig"`
+	// NodeBastions the bastion hosts of nodes in json, keyed by node address
+	NodeBastions string `gorm:"column:nodeBastions;type:text" json:"nodeBastions,omitempty"`
 }
 
+// RKEClusterState a version of the rke full state of cluster, it is saved after each cluster up.
+// The config and the state are encrypted, they contain the certificates and the keys of cluster.
+type RKEClusterState struct {
+	Model
+	ClusterName string `gorm:"column:clusterName;size:255;uniqueIndex:idx_cluster_state_version" json:"clusterName,omitempty"`
+	Version     int    `gorm:"column:version;uniqueIndex:idx_cluster_state_version" json:"version,omitempty"`
+	// Config the content of cluster.yml
+	Config string `gorm:"column:config;type:longtext" json:"-"`
+	// State the content of cluster.rkestate
+	State string `gorm:"column:state;type:longtext" json:"-"`
+}
+
+// ClusterSSHKey the ssh key pair used to connect the nodes of cluster, the private key is encrypted.
+type ClusterSSHKey struct {
+	Model
+	ClusterID   string `gorm:"column:clusterID;size:64;index" json:"clusterID,omitempty"`
+	KeyType     string `gorm:"column:keyType" json:"keyType,omitempty"`
+	PublicKey   string `gorm:"column:publicKey;type:text" json:"publicKey,omitempty"`
+	PrivateKey  string `gorm:"column:privateKey;type:text" json:"-"`
+	Fingerprint string `gorm:"column:fingerprint" json:"fingerprint,omitempty"`
+	// Status pending, active or retired
+	Status string `gorm:"column:status" json:"status,omitempty"`
+}
+
+var (
+	// SSHKeyStatusPending the key is being pushed to the nodes
+	SSHKeyStatusPending = "pending"
+	// SSHKeyStatusActive the key is used to connect the nodes
+	SSHKeyStatusActive = "active"
+	// SSHKeyStatusRetired the key is replaced and being removed from the nodes
+	SSHKeyStatusRetired = "retired"
+)
+
 // CustomCluster custom cluster
 type CustomCluster struct {
 	Model
@@ -46,6 +81,14 @@ type CustomCluster struct {
 	EIP        string `gorm:"column:eip" json:"eip,omitempty"`
 }
 
+// TKECluster the tke cluster created by adaptor, the tencent cloud api queries the cluster in its region.
+type TKECluster struct {
+	Model
+	Name      string `gorm:"column:name" json:"name,omitempty"`
+	ClusterID string `gorm:"column:clusterID;uniqueIndex;type:varchar(64)" json:"clusterID,omitempty"`
+	RegionID  string `gorm:"column:regionID" json:"regionID,omitempty"`
+}
+
 // WutongClusterConfig wutong cluster config
 type WutongClusterConfig struct {
 	Model
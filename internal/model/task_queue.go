@@ -0,0 +1,66 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import "time"
+
+// QueuedTask a task message in the durable task queue. A worker claims it with a lease, and renews the lease
+// by heartbeats until the task completes, so the task is found orphaned once the lease expires.
+type QueuedTask struct {
+	Model
+	TaskID string `gorm:"column:task_id;uniqueIndex;type:varchar(64)" json:"taskID"`
+	// Topic the topic of the message, such as cloud-create, cloud-init and cloud-update
+	Topic        string `gorm:"column:topic;type:varchar(32)" json:"topic"`
+	ProviderName string `gorm:"column:provider_name;type:varchar(32)" json:"providerName"`
+	ClusterID    string `gorm:"column:cluster_id;type:varchar(64)" json:"clusterID"`
+	// Exclusive the task changes the cluster, it holds the lock of cluster while running
+	Exclusive bool `gorm:"column:exclusive" json:"exclusive"`
+	// Payload the json message of task, it is encrypted.
+	Payload string `gorm:"column:payload;type:longtext" json:"-"`
+	// Status pending, running, done or failed
+	Status string `gorm:"column:status;index;type:varchar(16)" json:"status"`
+	// Owner the worker holding the lease
+	Owner          string     `gorm:"column:owner" json:"owner"`
+	LeaseExpiresAt *time.Time `gorm:"column:lease_expires_at" json:"leaseExpiresAt"`
+	// Attempts the number of times the task is claimed
+	Attempts int `gorm:"column:attempts" json:"attempts"`
+	// CancelRequested the running task is cancelled by its worker at the next heartbeat
+	CancelRequested bool `gorm:"column:cancel_requested" json:"cancelRequested"`
+}
+
+// ClusterLock the lock held by the running task changing the cluster, so only one of them runs at a time.
+type ClusterLock struct {
+	Model
+	ClusterID string `gorm:"column:cluster_id;uniqueIndex;type:varchar(64)" json:"clusterID"`
+	TaskID    string `gorm:"column:task_id;index;type:varchar(64)" json:"taskID"`
+	Owner     string `gorm:"column:owner" json:"owner"`
+}
+
+var (
+	// QueuedTaskPending the task is waiting to be claimed
+	QueuedTaskPending = "pending"
+	// QueuedTaskRunning the task is claimed by a worker
+	QueuedTaskRunning = "running"
+	// QueuedTaskDone the worker completed the task, the result of task is recorded by its events
+	QueuedTaskDone = "done"
+	// QueuedTaskFailed the task is orphaned and can not be resumed
+	QueuedTaskFailed = "failed"
+	// QueuedTaskCancelled the task is cancelled
+	QueuedTaskCancelled = "cancelled"
+)
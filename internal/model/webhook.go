@@ -0,0 +1,67 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package model
+
+import "time"
+
+// Webhook a subscription of the task events, the events matching the filters are posted to the url.
+type Webhook struct {
+	Model
+	WebhookID string `gorm:"column:webhook_id;uniqueIndex;type:varchar(64)" json:"webhookID"`
+	Name      string `gorm:"column:name" json:"name"`
+	URL       string `gorm:"column:url;type:text" json:"url"`
+	// Secret the key to sign the payloads with HMAC-SHA256, it is encrypted.
+	Secret string `gorm:"column:secret;type:text" json:"-"`
+	// TaskTypes, StepTypes and Statuses the filters of events separated by comma, empty matches all.
+	TaskTypes string `gorm:"column:task_types;type:text" json:"taskTypes"`
+	StepTypes string `gorm:"column:step_types;type:text" json:"stepTypes"`
+	Statuses  string `gorm:"column:statuses" json:"statuses"`
+	Enabled   bool   `gorm:"column:enabled" json:"enabled"`
+}
+
+// WebhookDelivery the delivery log of a task event to a webhook
+type WebhookDelivery struct {
+	Model
+	DeliveryID  string `gorm:"column:delivery_id;type:varchar(64)" json:"deliveryID"`
+	WebhookID   string `gorm:"column:webhook_id;index;type:varchar(64)" json:"webhookID"`
+	TaskID      string `gorm:"column:task_id" json:"taskID"`
+	EventID     string `gorm:"column:event_id" json:"eventID"`
+	StepType    string `gorm:"column:step_type" json:"stepType"`
+	EventStatus string `gorm:"column:event_status" json:"eventStatus"`
+	// Status pending, success or failure
+	Status string `gorm:"column:status" json:"status"`
+	// Attempts the number of attempts made to deliver the event
+	Attempts     int    `gorm:"column:attempts" json:"attempts"`
+	ResponseCode int    `gorm:"column:response_code" json:"responseCode"`
+	Error        string `gorm:"column:error;size:512" json:"error"`
+	// Payload the body posted to the webhook, it is kept to resume the pending delivery.
+	Payload string `gorm:"column:payload;type:text" json:"-"`
+	// NextAttemptAt when the pending delivery is attempted next, it is pushed back while the delivery is being
+	// attempted, so that the delivery is resumed by any replica once the attempt is lost.
+	NextAttemptAt *time.Time `gorm:"column:next_attempt_at;index" json:"nextAttemptAt,omitempty"`
+}
+
+var (
+	// WebhookDeliveryPending the event is being delivered, or waiting for the next retry
+	WebhookDeliveryPending = "pending"
+	// WebhookDeliverySuccess the event is accepted by the webhook
+	WebhookDeliverySuccess = "success"
+	// WebhookDeliveryFailure all attempts to deliver the event failed
+	WebhookDeliveryFailure = "failure"
+)
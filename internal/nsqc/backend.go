@@ -0,0 +1,91 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package nsqc
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/wutong-paas/cloud-adaptor/cmd/cloud-adaptor/config"
+	"github.com/wutong-paas/cloud-adaptor/internal/nsqc/producer"
+	"github.com/wutong-paas/cloud-adaptor/internal/repo"
+	"github.com/wutong-paas/cloud-adaptor/internal/types"
+)
+
+// TaskBackend the producer and the consumer of the tasks on the transport selected by config.TaskBackend.
+type TaskBackend struct {
+	Producer producer.TaskProducer
+	// Locker the locks of the clusters changed by the running tasks, shared by the consumer and the usecases
+	Locker types.ClusterLocker
+
+	backend   string
+	config    *config.Config
+	taskQueue repo.TaskQueueRepository
+	queue     chan types.TaskMessage
+}
+
+// NewTaskBackend creates the producer of the task backend in config.
+func NewTaskBackend(conf *config.Config, taskQueue repo.TaskQueueRepository, registry *types.TaskRegistry) (*TaskBackend, error) {
+	b := &TaskBackend{
+		backend:   conf.TaskBackend,
+		config:    conf,
+		taskQueue: taskQueue,
+	}
+	switch b.backend {
+	case "", config.TaskBackendDB:
+		if conf.EncryptKey == "" {
+			// the payloads of the queued tasks must be readable by all replicas
+			return nil, config.ErrEncryptKeyRequired
+		}
+		b.backend = config.TaskBackendDB
+		b.Producer = producer.NewTaskDBProducer(taskQueue, registry)
+		b.Locker = taskQueue
+	case config.TaskBackendChannel:
+		b.queue = make(chan types.TaskMessage)
+		b.Producer = producer.NewTaskChannelProducer(b.queue)
+		b.Locker = newMemoryClusterLocker()
+	case config.TaskBackendNSQ:
+		p, err := producer.NewTaskProducer(conf.NSQConfig.NsqdAddress, registry)
+		if err != nil {
+			return nil, err
+		}
+		b.Producer = p
+		b.Locker = taskQueue
+	default:
+		return nil, fmt.Errorf("unknown task backend %s", b.backend)
+	}
+	return b, nil
+}
+
+// NewConsumer creates the consumer of the tasks sent by the producer of the backend, the definitions of the tasks
+// must be registered to registry before.
+func (b *TaskBackend) NewConsumer(ctx context.Context,
+	registry *types.TaskRegistry,
+	taskEventRepo repo.TaskEventRepository,
+	events TaskEventRecorder,
+) TaskConsumer {
+	switch b.backend {
+	case config.TaskBackendChannel:
+		return NewTaskChannelConsumer(ctx, b.config.TaskScheduler, b.Locker, b.queue, registry, taskEventRepo, events)
+	case config.TaskBackendNSQ:
+		return NewTaskConsumer(ctx, b.config.NSQConfig, b.config.TaskScheduler, b.Locker, registry, taskEventRepo, events)
+	default:
+		return NewTaskDBConsumer(ctx, b.config.TaskScheduler, registry, b.taskQueue, taskEventRepo, events)
+	}
+}
@@ -20,40 +20,48 @@ package nsqc
 
 import (
 	"context"
+	"sync"
+	"time"
 
+	"github.com/sirupsen/logrus"
+	"github.com/wutong-paas/cloud-adaptor/cmd/cloud-adaptor/config"
+	"github.com/wutong-paas/cloud-adaptor/internal/repo"
 	"github.com/wutong-paas/cloud-adaptor/internal/task"
 	"github.com/wutong-paas/cloud-adaptor/internal/types"
 )
 
-// Consumer -
+// taskChannelConsumer runs the tasks sent to the in-memory channel. The tasks wait for the free workers, and for
+// the other task changing the same cluster, as the tasks of the durable queue. Each task runs with its own context,
+// it is cancelled once the task is requested to be cancelled.
 type taskChannelConsumer struct {
-	ctx                         context.Context
-	createQueue                 chan types.KubernetesConfigMessage
-	initQueue                   chan types.InitWutongConfigMessage
-	updateQueue                 chan types.UpdateKubernetesConfigMessage
-	createKubernetesTaskHandler task.CreateKubernetesTaskHandler
-	cloudInitTaskHandler        task.CloudInitTaskHandler
-	cloudUpdateTaskHandler      task.UpdateKubernetesTaskHandler
+	ctx           context.Context
+	queue         chan types.TaskMessage
+	registry      *types.TaskRegistry
+	taskEventRepo repo.TaskEventRepository
+	events        TaskEventRecorder
+	scheduler     *taskScheduler
+
+	cancelInterval time.Duration
 }
 
 // NewTaskChannelConsumer creates a new consumer.
 func NewTaskChannelConsumer(
 	ctx context.Context,
-	createQueue chan types.KubernetesConfigMessage,
-	initQueue chan types.InitWutongConfigMessage,
-	updateQueue chan types.UpdateKubernetesConfigMessage,
-	createHandler task.CreateKubernetesTaskHandler,
-	initHandler task.CloudInitTaskHandler,
-	cloudUpdateTaskHandler task.UpdateKubernetesTaskHandler,
+	scheduler *config.TaskScheduler,
+	locker types.ClusterLocker,
+	queue chan types.TaskMessage,
+	registry *types.TaskRegistry,
+	taskEventRepo repo.TaskEventRepository,
+	events TaskEventRecorder,
 ) TaskConsumer {
 	return &taskChannelConsumer{
-		ctx:                         ctx,
-		createQueue:                 createQueue,
-		initQueue:                   initQueue,
-		updateQueue:                 updateQueue,
-		createKubernetesTaskHandler: createHandler,
-		cloudInitTaskHandler:        initHandler,
-		cloudUpdateTaskHandler:      cloudUpdateTaskHandler,
+		ctx:            ctx,
+		queue:          queue,
+		registry:       registry,
+		taskEventRepo:  taskEventRepo,
+		events:         events,
+		scheduler:      newTaskScheduler(scheduler, locker),
+		cancelInterval: taskHeartbeatInterval,
 	}
 }
 
@@ -63,12 +71,74 @@ func (c *taskChannelConsumer) Start() error {
 		select {
 		case <-c.ctx.Done():
 			return nil
-		case createMsg := <-c.createQueue:
-			_ = c.createKubernetesTaskHandler.HandleMsg(c.ctx, createMsg)
-		case initMsg := <-c.initQueue:
-			_ = c.cloudInitTaskHandler.HandleMsg(c.ctx, initMsg)
-		case updateMsg := <-c.updateQueue:
-			_ = c.cloudUpdateTaskHandler.HandleMsg(c.ctx, updateMsg)
+		case msg := <-c.queue:
+			def, ok := c.registry.Get(msg.Name)
+			if !ok {
+				logrus.Errorf("task %s is not registered", msg.Name)
+				continue
+			}
+			go c.run(def, msg.Payload)
 		}
 	}
 }
+
+// run runs the task once it is scheduled, until its handler calls Done, and cancels it once it is requested to be cancelled.
+func (c *taskChannelConsumer) run(def *types.TaskDefinition, payload types.TaskPayload) {
+	taskID := payload.GetTaskID()
+	scheduled, err := c.scheduler.schedule(c.ctx.Done(), payload, func() bool {
+		return !cancelRequested(c.taskEventRepo, taskID)
+	})
+	if err != nil {
+		logrus.Errorf("schedule task %s failure %s", taskID, err.Error())
+		return
+	}
+	if scheduled {
+		defer c.release(payload)
+	} else if c.ctx.Err() != nil {
+		// the tasks in memory are lost once adaptor exits
+		return
+	}
+	events, err := c.taskEventRepo.ListEvent(taskID)
+	if err != nil {
+		logrus.Errorf("list the events of task %s failure %s", taskID, err.Error())
+		return
+	}
+	if cancelledBeforeRun(c.events, events, taskID) || !scheduled {
+		logrus.Infof("task %s is cancelled, skip it", taskID)
+		return
+	}
+
+	ctx, cancel := context.WithCancelCause(c.ctx)
+	defer cancel(nil)
+	done := make(chan struct{})
+	var once sync.Once
+	ctx = task.WithDone(ctx, func() {
+		once.Do(func() { close(done) })
+	})
+	if err := def.Handler.HandleTask(ctx, payload); err != nil {
+		logrus.Errorf("handle task %s failure %s", taskID, err.Error())
+		return
+	}
+	ticker := time.NewTicker(c.cancelInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-c.ctx.Done():
+			// the tasks in memory are lost once adaptor exits
+			return
+		case <-ticker.C:
+			if ctx.Err() == nil && cancelRequested(c.taskEventRepo, taskID) {
+				logrus.Infof("cancel task %s", taskID)
+				cancel(task.ErrCancelled)
+			}
+		}
+	}
+}
+
+func (c *taskChannelConsumer) release(payload types.TaskPayload) {
+	if err := c.scheduler.done(payload); err != nil {
+		logrus.Warningf("release task %s failure %s", payload.GetTaskID(), err.Error())
+	}
+}
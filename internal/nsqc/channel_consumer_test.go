@@ -0,0 +1,138 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package nsqc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/wutong-paas/cloud-adaptor/cmd/cloud-adaptor/config"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+	"github.com/wutong-paas/cloud-adaptor/internal/task"
+	"github.com/wutong-paas/cloud-adaptor/internal/types"
+	"github.com/wutong-paas/cloud-adaptor/internal/usecase"
+	"github.com/wutong-paas/cloud-adaptor/pkg/util/constants"
+)
+
+func newTestChannelConsumer(ctx context.Context, queue chan types.TaskMessage, eventRepo *fakeTaskEventRepo,
+	recorder *fakeTaskEventRecorder, handler *fakeTaskHandler) *taskChannelConsumer {
+	return newTestChannelConsumerWithScheduler(ctx, nil, queue, eventRepo, recorder, handler)
+}
+
+func newTestChannelConsumerWithScheduler(ctx context.Context, scheduler *config.TaskScheduler, queue chan types.TaskMessage,
+	eventRepo *fakeTaskEventRepo, recorder *fakeTaskEventRecorder, handler *fakeTaskHandler) *taskChannelConsumer {
+	registry := types.NewTaskRegistry()
+	if _, err := task.NewTaskDefinitions(registry, handler, fakeInitHandler{handler}, fakeUpdateHandler{handler}, nil); err != nil {
+		panic(err)
+	}
+	c := NewTaskChannelConsumer(ctx, scheduler, newMemoryClusterLocker(), queue, registry, eventRepo, recorder).(*taskChannelConsumer)
+	c.cancelInterval = 20 * time.Millisecond
+	c.scheduler.pollInterval = 10 * time.Millisecond
+	return c
+}
+
+func TestTaskChannelConsumerCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue := make(chan types.TaskMessage)
+	eventRepo := &fakeTaskEventRepo{}
+	recorder := &fakeTaskEventRecorder{}
+	handler := &fakeTaskHandler{release: make(chan struct{})}
+	c := newTestChannelConsumer(ctx, queue, eventRepo, recorder, handler)
+	go func() {
+		if err := c.Start(); err != nil {
+			t.Error(err)
+		}
+	}()
+	send := func(taskID string) {
+		queue <- types.TaskMessage{Name: constants.CloudCreate,
+			Payload: &types.KubernetesConfigMessage{TaskID: taskID, KubernetesConfig: &v1alpha1.KubernetesClusterConfig{Provider: "rke"}}}
+	}
+
+	// the task requested to be cancelled before it runs is skipped, and it is recorded cancelled
+	eventRepo.add(&model.TaskEvent{TaskID: "pending", StepType: usecase.TaskCancelledStep, Status: "start"})
+	send("pending")
+	send("running")
+	waitFor(t, "task running handled", func() bool { return handler.handledTimes("running") == 1 })
+	waitFor(t, "task pending recorded cancelled", func() bool { return recorder.find("pending", usecase.TaskCancelledStep) != nil })
+	if times := handler.handledTimes("pending"); times != 0 {
+		t.Fatalf("want the cancelled task skipped, got %d", times)
+	}
+
+	// the running task is cancelled through its context
+	eventRepo.add(&model.TaskEvent{TaskID: "running", StepType: usecase.TaskCancelledStep, Status: "start"})
+	waitFor(t, "task running cancelled", func() bool {
+		handler.lock.Lock()
+		defer handler.lock.Unlock()
+		return len(handler.causes) == 1
+	})
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	if !errors.Is(handler.causes[0], task.ErrCancelled) {
+		t.Fatalf("want the task context cancelled by ErrCancelled, got %v", handler.causes[0])
+	}
+}
+
+func TestTaskChannelConsumerSchedule(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue := make(chan types.TaskMessage)
+	handler := &fakeTaskHandler{release: make(chan struct{})}
+	scheduler := &config.TaskScheduler{Workers: 3, ProviderWorkers: map[string]int{"rke": 1}}
+	c := newTestChannelConsumerWithScheduler(ctx, scheduler, queue, &fakeTaskEventRepo{}, &fakeTaskEventRecorder{}, handler)
+	go func() {
+		if err := c.Start(); err != nil {
+			t.Error(err)
+		}
+	}()
+	send := func(taskID, provider, clusterID string) {
+		queue <- types.TaskMessage{Name: constants.CloudCreate, Payload: &types.KubernetesConfigMessage{TaskID: taskID,
+			KubernetesConfig: &v1alpha1.KubernetesClusterConfig{Provider: provider, ClusterID: clusterID}}}
+	}
+	handled := func(taskID string) {
+		waitFor(t, "task "+taskID+" handled", func() bool { return handler.handledTimes(taskID) == 1 })
+	}
+
+	send("rke-1", "rke", "c1")
+	handled("rke-1")
+	send("ack-1", "ack", "c3")
+	handled("ack-1")
+	// the workers of rke are used up
+	send("rke-2", "rke", "c2")
+	// the task changing the cluster c3 waits for ack-1
+	send("ack-2", "ack", "c3")
+	send("ack-3", "ack", "c4")
+	handled("ack-3")
+	// the workers of the replica are used up
+	send("ack-4", "ack", "c5")
+	time.Sleep(100 * time.Millisecond)
+	for _, taskID := range []string{"rke-2", "ack-2", "ack-4"} {
+		if times := handler.handledTimes(taskID); times != 0 {
+			t.Errorf("want task %s waiting, got handled %d times", taskID, times)
+		}
+	}
+
+	close(handler.release)
+	for _, taskID := range []string{"rke-2", "ack-2", "ack-4"} {
+		handled(taskID)
+	}
+}
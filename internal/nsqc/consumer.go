@@ -19,85 +19,332 @@
 package nsqc
 
 import (
-	"os"
-	"os/signal"
-	"syscall"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/nsqio/go-nsq"
-	"github.com/prometheus/common/log"
+	"github.com/sirupsen/logrus"
+	v1 "github.com/wutong-paas/cloud-adaptor/api/cloud-adaptor/v1"
 	"github.com/wutong-paas/cloud-adaptor/cmd/cloud-adaptor/config"
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+	"github.com/wutong-paas/cloud-adaptor/internal/repo"
 	"github.com/wutong-paas/cloud-adaptor/internal/task"
+	"github.com/wutong-paas/cloud-adaptor/internal/types"
+	"github.com/wutong-paas/cloud-adaptor/internal/usecase"
 	"github.com/wutong-paas/cloud-adaptor/pkg/util/constants"
 )
 
+// nsqChannel the channel shared by the replicas of adaptor, each message is handled by one of them.
+const nsqChannel = "default"
+
 // TaskConsumer task producer
 type TaskConsumer interface {
 	Start() error
 }
 
-// taskConsumer -
+// taskConsumer consumes the topics of all the task definitions and the topic of the task events on nsq.
+// A task message is held until the task completes, and it is touched so that nsqd does not redeliver it,
+// so the concurrency limits the tasks of a topic running at the same time in one replica. The message is
+// requeued if the task fails to start, and the redelivered task is resumed as the orphaned task of the
+// durable queue: the task cancelled is skipped, the task interrupted halfway is resumed if its definition
+// can resume it, otherwise it is marked failed.
+// The tasks wait for the free workers of the replica and of their providers, and the task changing a cluster waits
+// for the lock of the cluster in the database, as the tasks of the durable queue. The messages of the waiting tasks
+// are held and touched too, they are counted by the concurrency of their topics.
 type taskConsumer struct {
-	config                      *config.Config
-	createKubernetesTaskHandler task.CreateKubernetesTaskHandler
-	cloudInitTaskHandler        task.CloudInitTaskHandler
+	ctx           context.Context
+	config        *config.NSQConfig
+	registry      *types.TaskRegistry
+	taskEventRepo repo.TaskEventRepository
+	events        TaskEventRecorder
+	scheduler     *taskScheduler
+	// closed the subscriptions are closed, the running tasks requeue their messages
+	closed chan struct{}
+
+	touchInterval time.Duration
 }
 
-// NewTaskConsumer creates a new consumer.
-func NewTaskConsumer(config *config.Config, createHandler task.CreateKubernetesTaskHandler, initHandler task.CloudInitTaskHandler) TaskConsumer {
+// NewTaskConsumer creates a new consumer, it subscribes the topics of all the task definitions and the topic of the task events.
+func NewTaskConsumer(ctx context.Context,
+	config *config.NSQConfig,
+	scheduler *config.TaskScheduler,
+	locker types.ClusterLocker,
+	registry *types.TaskRegistry,
+	taskEventRepo repo.TaskEventRepository,
+	events TaskEventRecorder,
+) TaskConsumer {
 	return &taskConsumer{
-		config:                      config,
-		createKubernetesTaskHandler: createHandler,
-		cloudInitTaskHandler:        initHandler,
+		ctx:           ctx,
+		config:        config,
+		registry:      registry,
+		taskEventRepo: taskEventRepo,
+		events:        events,
+		scheduler:     newTaskScheduler(scheduler, locker),
+		closed:        make(chan struct{}),
+		touchInterval: taskHeartbeatInterval,
 	}
 }
 
+// Start subscribes the topics, and consumes them until ctx is done.
 func (c *taskConsumer) Start() error {
-	config := nsq.NewConfig()
-	//cloud init handler
-	initConsumer, err := nsq.NewConsumer(constants.CloudInit, "default", config)
+	var consumers []*nsq.Consumer
+	defer func() {
+		// Gracefully stop the consumers, nsqd sends no more messages once the subscriptions are closed,
+		// then the running tasks requeue their messages for the other replicas.
+		for _, consumer := range consumers {
+			consumer.Stop()
+		}
+		close(c.closed)
+		for _, consumer := range consumers {
+			<-consumer.StopChan
+		}
+	}()
+	concurrency := c.config.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	for _, def := range c.registry.List() {
+		consumer, err := c.subscribe(def.Name, concurrency, &taskHandler{consumer: c, name: def.Name})
+		if err != nil {
+			return err
+		}
+		consumers = append(consumers, consumer)
+	}
+	// the events of a task are saved one by one, so that they are in order
+	consumer, err := c.subscribe(constants.CloudTaskEvent, 1, &eventHandler{events: c.events})
 	if err != nil {
 		return err
 	}
+	consumers = append(consumers, consumer)
+	logrus.Infof("task consumer start success")
+	<-c.ctx.Done()
+	return nil
+}
 
-	// Set the Handler for messages received by this Consumer. Can be called multiple times.
-	// See also AddConcurrentHandlers.
-	initConsumer.AddHandler(c.cloudInitTaskHandler)
+func (c *taskConsumer) subscribe(topic string, concurrency int, handler nsq.Handler) (*nsq.Consumer, error) {
+	cfg := nsq.NewConfig()
+	cfg.MaxInFlight = concurrency
+	cfg.MaxAttempts = uint16(c.config.MaxAttempts)
+	cfg.DefaultRequeueDelay = c.config.RequeueDelay
+	// a failed message must not hold back the others
+	cfg.MaxBackoffDuration = 0
+	consumer, err := nsq.NewConsumer(topic, nsqChannel, cfg)
+	if err != nil {
+		return nil, err
+	}
+	consumer.AddConcurrentHandlers(handler, concurrency)
+	if c.config.NsqLookupdAddress != "" {
+		err = consumer.ConnectToNSQLookupd(c.config.NsqLookupdAddress)
+	} else {
+		err = consumer.ConnectToNSQD(c.config.NsqdAddress)
+	}
+	if err != nil {
+		consumer.Stop()
+		return nil, fmt.Errorf("subscribe %s: %v", topic, err)
+	}
+	return consumer, nil
+}
 
-	// Use nsqlookupd to discover nsqd instances.
-	// See also ConnectToNSQD, ConnectToNSQDs, ConnectToNSQLookupds.
-	err = initConsumer.ConnectToNSQLookupd(c.config.NSQConfig.NsqLookupdAddress)
+// prepare returns false if the task of the message must not run. The task cancelled while waiting is skipped.
+// The redelivered task interrupted halfway is resumed from the steps not succeeded, or it is marked failed.
+func (c *taskConsumer) prepare(def *types.TaskDefinition, payload types.TaskPayload, attempts uint16) (bool, error) {
+	taskID := payload.GetTaskID()
+	events, err := c.taskEventRepo.ListEvent(taskID)
 	if err != nil {
-		return err
+		return false, err
+	}
+	if cancelledBeforeRun(c.events, events, taskID) {
+		logrus.Infof("task %s is cancelled, skip it", taskID)
+		return false, nil
 	}
-	defer initConsumer.Stop()
+	if attempts <= 1 || len(events) == 0 {
+		return true, nil
+	}
+	if def.Resume == nil || !def.Resume(payload, succeededSteps(events)) {
+		logrus.Warningf("task %s is interrupted, and it can not be resumed", taskID)
+		recordInterrupted(c.events, taskID, "the task was interrupted because the adaptor exited, check the cluster and retry it")
+		return false, nil
+	}
+	logrus.Infof("resume interrupted task %s, attempts %d", taskID, attempts)
+	return true, nil
+}
 
-	createConsumer, err := nsq.NewConsumer(constants.CloudCreate, "default", config)
+// cancelRequested returns whether the task is requested to be cancelled. The tasks not in the durable queue
+// are requested to be cancelled by the Cancelled event in the start status, it turns to failure once they stop.
+func cancelRequested(taskEventRepo repo.TaskEventRepository, taskID string) bool {
+	events, err := taskEventRepo.ListEvent(taskID)
 	if err != nil {
-		return err
+		logrus.Warningf("list the events of task %s failure %s", taskID, err.Error())
+		return false
+	}
+	for _, event := range events {
+		if event.StepType == usecase.TaskCancelledStep {
+			return true
+		}
+	}
+	return false
+}
+
+// cancelledBeforeRun returns true if the task is cancelled before it runs. The task requested to be cancelled
+// while waiting is recorded cancelled, since its handler never runs to record it.
+func cancelledBeforeRun(recorder TaskEventRecorder, events []*model.TaskEvent, taskID string) bool {
+	for _, event := range events {
+		if event.StepType != usecase.TaskCancelledStep {
+			continue
+		}
+		if event.Status != "failure" {
+			recordCancelled(recorder, taskID)
+		}
+		return true
 	}
-	// Set the Handler for messages received by this Consumer. Can be called multiple times.
-	// See also AddConcurrentHandlers.
-	createConsumer.AddHandler(c.createKubernetesTaskHandler)
+	return false
+}
+
+// taskHandler handles the messages of the topic of task definition name.
+// Returning a non-nil error will automatically send a REQ command to NSQ to re-queue the message.
+type taskHandler struct {
+	consumer *taskConsumer
+	name     string
+}
 
-	// Use nsqlookupd to discover nsqd instances.
-	// See also ConnectToNSQD, ConnectToNSQDs, ConnectToNSQLookupds.
-	err = createConsumer.ConnectToNSQLookupd(c.config.NSQConfig.NsqLookupdAddress)
+// HandleMessage runs the task of message, and holds the message until the task completes.
+func (h *taskHandler) HandleMessage(m *nsq.Message) error {
+	c := h.consumer
+	if len(m.Body) == 0 {
+		// Returning nil will automatically send a FIN command to NSQ to mark the message as processed.
+		return nil
+	}
+	def, payload, err := c.registry.Decode(h.name, m.Body)
+	if err != nil {
+		logrus.Errorf("decode %s message failure %s", h.name, err.Error())
+		return nil
+	}
+	taskID := payload.GetTaskID()
+	if c.ctx.Err() != nil {
+		// the message is delivered just before the subscription is closed
+		m.RequeueWithoutBackoff(0)
+		return nil
+	}
+	ok, err := c.prepare(def, payload, m.Attempts)
 	if err != nil {
+		logrus.Errorf("prepare task %s failure %s", taskID, err.Error())
+		return err
+	}
+	if !ok {
+		// the lock of cluster held by the interrupted task
+		c.unlock(payload)
+		return nil
+	}
+	scheduled, err := c.scheduler.schedule(c.closed, payload, func() bool {
+		m.Touch()
+		return !cancelRequested(c.taskEventRepo, taskID)
+	})
+	if err != nil {
+		logrus.Errorf("schedule task %s failure %s", taskID, err.Error())
+		return err
+	}
+	if !scheduled {
+		select {
+		case <-c.closed:
+			m.RequeueWithoutBackoff(0)
+		default:
+			logrus.Infof("task %s is cancelled while waiting, skip it", taskID)
+			recordCancelled(c.events, taskID)
+		}
+		return nil
+	}
+	defer c.release(payload)
+
+	ctx, cancel := context.WithCancelCause(c.ctx)
+	defer cancel(nil)
+	done := make(chan struct{})
+	var once sync.Once
+	ctx = task.WithDone(ctx, func() {
+		once.Do(func() { close(done) })
+	})
+	if err := def.Handler.HandleTask(ctx, payload); err != nil {
+		logrus.Errorf("handle task %s failure %s, attempts %d", taskID, err.Error(), m.Attempts)
 		return err
 	}
+	touch := time.NewTicker(c.touchInterval)
+	defer touch.Stop()
+	for {
+		select {
+		case <-done:
+			if c.ctx.Err() == nil {
+				logrus.Infof("task %s is done", taskID)
+				return nil
+			}
+			// the task stopped because the adaptor exits
+			<-c.closed
+			m.RequeueWithoutBackoff(0)
+			return nil
+		case <-c.closed:
+			// the task is redelivered to the other replicas, and it is resumed or marked failed there
+			m.RequeueWithoutBackoff(0)
+			return nil
+		case <-touch.C:
+			m.Touch()
+			if ctx.Err() == nil && cancelRequested(c.taskEventRepo, taskID) {
+				logrus.Infof("cancel task %s", taskID)
+				cancel(task.ErrCancelled)
+			}
+		}
+	}
+}
+
+// LogFailedMessage marks the task failed once its message is given up.
+func (h *taskHandler) LogFailedMessage(m *nsq.Message) {
+	_, payload, err := h.consumer.registry.Decode(h.name, m.Body)
+	if err != nil {
+		logrus.Errorf("give up %s message %s", h.name, string(m.ID[:]))
+		return
+	}
+	taskID := payload.GetTaskID()
+	logrus.Errorf("give up task %s after %d attempts", taskID, m.Attempts-1)
+	recordInterrupted(h.consumer.events, taskID, fmt.Sprintf("the task failed to start after %d attempts", m.Attempts-1))
+	h.consumer.unlock(payload)
+}
+
+// release releases the worker and the lock of cluster taken by the task.
+func (c *taskConsumer) release(payload types.TaskPayload) {
+	if err := c.scheduler.done(payload); err != nil {
+		logrus.Warningf("release task %s failure %s", payload.GetTaskID(), err.Error())
+	}
+}
+
+func (c *taskConsumer) unlock(payload types.TaskPayload) {
+	if err := c.scheduler.unlock(payload); err != nil {
+		logrus.Warningf("unlock the cluster of task %s failure %s", payload.GetTaskID(), err.Error())
+	}
+}
 
-	// Gracefully stop the consumer.
-	defer createConsumer.Stop()
-	term := make(chan os.Signal, 1)
-	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
-	select {
-	case v := <-initConsumer.StopChan:
-		log.Errorf("Received initConsumer stop signal %d, exiting gracefully...", v)
-	case v := <-createConsumer.StopChan:
-		log.Errorf("Received createConsumer stop signal %d, exiting gracefully...", v)
-	case <-term:
-		log.Warn("Received SIGTERM, exiting gracefully...")
+// eventHandler saves the task events published by the replicas running the tasks.
+type eventHandler struct {
+	events TaskEventRecorder
+}
+
+// HandleMessage saves the event, the message is requeued if it fails.
+func (h *eventHandler) HandleMessage(m *nsq.Message) error {
+	var em v1.EventMessage
+	if err := json.Unmarshal(m.Body, &em); err != nil {
+		logrus.Errorf("decode event message failure %s", err.Error())
+		return nil
+	}
+	if em.Message == nil {
+		return nil
+	}
+	if _, err := h.events.CreateTaskEvent(&em); err != nil {
+		logrus.Errorf("save the event of task %s failure %s", em.TaskID, err.Error())
+		return err
 	}
-	log.Info("See you next time!")
 	return nil
 }
+
+// LogFailedMessage logs the event given up.
+func (h *eventHandler) LogFailedMessage(m *nsq.Message) {
+	logrus.Errorf("give up event message %s", string(m.Body))
+}
@@ -0,0 +1,318 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package nsqc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v1 "github.com/wutong-paas/cloud-adaptor/api/cloud-adaptor/v1"
+	"github.com/wutong-paas/cloud-adaptor/cmd/cloud-adaptor/config"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+	"github.com/wutong-paas/cloud-adaptor/internal/nsqc/producer"
+	"github.com/wutong-paas/cloud-adaptor/internal/task"
+	"github.com/wutong-paas/cloud-adaptor/internal/types"
+	"github.com/wutong-paas/cloud-adaptor/internal/usecase"
+	"github.com/wutong-paas/cloud-adaptor/pkg/util/constants"
+)
+
+func newTestNSQConsumer(ctx context.Context, nsqd *testNSQD, eventRepo *fakeTaskEventRepo, recorder *fakeTaskEventRecorder,
+	handler *fakeTaskHandler) *taskConsumer {
+	return newTestNSQConsumerWithLocker(ctx, nsqd, &fakeTaskQueue{}, eventRepo, recorder, handler)
+}
+
+func newTestNSQConsumerWithLocker(ctx context.Context, nsqd *testNSQD, locker types.ClusterLocker, eventRepo *fakeTaskEventRepo,
+	recorder *fakeTaskEventRecorder, handler *fakeTaskHandler) *taskConsumer {
+	registry := types.NewTaskRegistry()
+	if _, err := task.NewTaskDefinitions(registry, handler, fakeInitHandler{handler}, fakeUpdateHandler{handler}, nil); err != nil {
+		panic(err)
+	}
+	c := NewTaskConsumer(ctx, &config.NSQConfig{
+		NsqdAddress:  nsqd.addr(),
+		Concurrency:  2,
+		MaxAttempts:  3,
+		RequeueDelay: 10 * time.Millisecond,
+	}, nil, locker, registry, eventRepo, recorder).(*taskConsumer)
+	c.touchInterval = 20 * time.Millisecond
+	c.scheduler.pollInterval = 10 * time.Millisecond
+	return c
+}
+
+func startTestNSQConsumer(t *testing.T, c *taskConsumer) <-chan struct{} {
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if err := c.Start(); err != nil {
+			t.Error(err)
+		}
+	}()
+	return stopped
+}
+
+func sendTestTask(t *testing.T, nsqd *testNSQD, registry *types.TaskRegistry, name string, payload types.TaskPayload) {
+	p, err := producer.NewTaskProducer(nsqd.addr(), registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+	if err := p.SendTask(name, payload); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitFor(t *testing.T, desc string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting %s", desc)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (f *fakeTaskHandler) handledTimes(taskID string) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var times int
+	for _, handled := range f.handled {
+		if handled == taskID {
+			times++
+		}
+	}
+	return times
+}
+
+func (f *fakeTaskEventRecorder) find(taskID, stepType string) *v1.EventMessage {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, em := range f.events {
+		if em.TaskID == taskID && em.Message.StepType == stepType {
+			return em
+		}
+	}
+	return nil
+}
+
+func TestTaskConsumerRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// nsqd buffers the messages sent to the consumers for 250ms, the timeout counts from then
+	nsqd := newTestNSQD(t, 500*time.Millisecond)
+	handler := &fakeTaskHandler{release: make(chan struct{})}
+	c := newTestNSQConsumer(ctx, nsqd, &fakeTaskEventRepo{}, &fakeTaskEventRecorder{}, handler)
+	stopped := startTestNSQConsumer(t, c)
+
+	sendTestTask(t, nsqd, c.registry, constants.CloudCreate,
+		&types.KubernetesConfigMessage{TaskID: "t1", KubernetesConfig: &v1alpha1.KubernetesClusterConfig{Provider: "rke"}})
+	waitFor(t, "task t1 handled", func() bool { return handler.handledTimes("t1") == 1 })
+
+	// the message is touched while the task is running, so it is not redelivered
+	time.Sleep(5 * nsqd.msgTimeout)
+	if times := handler.handledTimes("t1"); times != 1 {
+		t.Fatalf("want the task handled once, got %d", times)
+	}
+	if depth := nsqd.depth(constants.CloudCreate, nsqChannel); depth != 1 {
+		t.Fatalf("want the message held by the running task, got depth %d", depth)
+	}
+	close(handler.release)
+	waitFor(t, "message finished", func() bool { return nsqd.depth(constants.CloudCreate, nsqChannel) == 0 })
+	cancel()
+	<-stopped
+}
+
+func TestTaskConsumerRequeue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nsqd := newTestNSQD(t, time.Second)
+	handler := &fakeTaskHandler{release: make(chan struct{})}
+	recorder := &fakeTaskEventRecorder{}
+	c := newTestNSQConsumer(ctx, nsqd, &fakeTaskEventRepo{}, recorder, handler)
+	var attempts int32
+	err := c.registry.Register(&types.TaskDefinition{
+		Name:       "uninstall",
+		Schema:     `{"type": "object", "required": ["task_id", "cluster_id"]}`,
+		NewPayload: func() types.TaskPayload { return &fakeUninstallPayload{} },
+		Handler: types.TaskHandlerFunc(func(ctx context.Context, payload types.TaskPayload) error {
+			atomic.AddInt32(&attempts, 1)
+			return errors.New("the cluster is not ready")
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopped := startTestNSQConsumer(t, c)
+
+	sendTestTask(t, nsqd, c.registry, "uninstall", &fakeUninstallPayload{TaskID: "u1", ClusterID: "c1"})
+	waitFor(t, "task u1 given up", func() bool { return recorder.find("u1", interruptedStep) != nil })
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Fatalf("want the task started 3 times, got %d", got)
+	}
+	if em := recorder.find("u1", interruptedStep); !strings.Contains(em.Message.Message, "3 attempts") {
+		t.Fatalf("unexpected event %+v", em.Message)
+	}
+	waitFor(t, "message finished", func() bool { return nsqd.depth("uninstall", nsqChannel) == 0 })
+	cancel()
+	<-stopped
+}
+
+func TestTaskConsumerResume(t *testing.T) {
+	nsqd := newTestNSQD(t, time.Second)
+	eventRepo := &fakeTaskEventRepo{}
+	recorder := &fakeTaskEventRecorder{}
+	handler := &fakeTaskHandler{release: make(chan struct{})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newTestNSQConsumer(ctx, nsqd, eventRepo, recorder, handler)
+	stopped := startTestNSQConsumer(t, c)
+	sendTestTask(t, nsqd, c.registry, constants.CloudCreate,
+		&types.KubernetesConfigMessage{TaskID: "create", KubernetesConfig: &v1alpha1.KubernetesClusterConfig{Provider: "rke"}})
+	sendTestTask(t, nsqd, c.registry, constants.CloudInit,
+		&types.InitWutongConfigMessage{TaskID: "init", InitWutongConfig: &types.InitWutongConfig{ClusterID: "c1", Provider: "rke"}})
+	waitFor(t, "tasks handled", func() bool { return handler.handledTimes("create") == 1 && handler.handledTimes("init") == 1 })
+	eventRepo.add(&model.TaskEvent{TaskID: "create", StepType: "Init", Status: "success"})
+	eventRepo.add(&model.TaskEvent{TaskID: "init", StepType: "CheckCluster", Status: "success"})
+
+	// the replica exits, and the messages are requeued for the other replicas
+	cancel()
+	<-stopped
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	other := &fakeTaskHandler{release: make(chan struct{})}
+	close(other.release)
+	c = newTestNSQConsumer(ctx, nsqd, eventRepo, recorder, other)
+	stopped = startTestNSQConsumer(t, c)
+	waitFor(t, "init resumed", func() bool { return other.handledTimes("init") == 1 })
+	waitFor(t, "create marked failed", func() bool { return recorder.find("create", interruptedStep) != nil })
+	if times := other.handledTimes("create"); times != 0 {
+		t.Fatalf("want the interrupted create task not run again, got %d", times)
+	}
+	waitFor(t, "messages finished", func() bool {
+		return nsqd.depth(constants.CloudCreate, nsqChannel) == 0 && nsqd.depth(constants.CloudInit, nsqChannel) == 0
+	})
+	cancel()
+	<-stopped
+}
+
+func TestTaskConsumerCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nsqd := newTestNSQD(t, time.Second)
+	eventRepo := &fakeTaskEventRepo{}
+	handler := &fakeTaskHandler{release: make(chan struct{})}
+	recorder := &fakeTaskEventRecorder{}
+	c := newTestNSQConsumer(ctx, nsqd, eventRepo, recorder, handler)
+	stopped := startTestNSQConsumer(t, c)
+
+	// the task requested to be cancelled while waiting is skipped, and it is recorded cancelled
+	eventRepo.add(&model.TaskEvent{TaskID: "pending", StepType: usecase.TaskCancelledStep, Status: "start"})
+	sendTestTask(t, nsqd, c.registry, constants.CloudCreate,
+		&types.KubernetesConfigMessage{TaskID: "pending", KubernetesConfig: &v1alpha1.KubernetesClusterConfig{Provider: "rke"}})
+	sendTestTask(t, nsqd, c.registry, constants.CloudCreate,
+		&types.KubernetesConfigMessage{TaskID: "running", KubernetesConfig: &v1alpha1.KubernetesClusterConfig{Provider: "rke"}})
+	waitFor(t, "task running handled", func() bool { return handler.handledTimes("running") == 1 })
+
+	eventRepo.add(&model.TaskEvent{TaskID: "running", StepType: usecase.TaskCancelledStep, Status: "start"})
+	waitFor(t, "message finished", func() bool { return nsqd.depth(constants.CloudCreate, nsqChannel) == 0 })
+	handler.lock.Lock()
+	if len(handler.causes) != 1 || !errors.Is(handler.causes[0], task.ErrCancelled) {
+		t.Errorf("want the running task cancelled, got %v", handler.causes)
+	}
+	handler.lock.Unlock()
+	if times := handler.handledTimes("pending"); times != 0 {
+		t.Fatalf("want the cancelled task skipped, got %d", times)
+	}
+	if em := recorder.find("pending", usecase.TaskCancelledStep); em == nil || em.Message.Status != "failure" {
+		t.Fatalf("want the skipped task recorded cancelled, got %v", em)
+	}
+	cancel()
+	<-stopped
+}
+
+func TestTaskConsumerEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nsqd := newTestNSQD(t, time.Second)
+	recorder := &fakeTaskEventRecorder{}
+	c := newTestNSQConsumer(ctx, nsqd, &fakeTaskEventRepo{}, recorder, &fakeTaskHandler{})
+	stopped := startTestNSQConsumer(t, c)
+
+	conf := &config.Config{TaskBackend: config.TaskBackendNSQ, NSQConfig: &config.NSQConfig{NsqdAddress: nsqd.addr()}}
+	callback, err := task.NewCallBackEvent(conf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []string{"Init", "CreateCluster"} {
+		if err := callback.HandleEvent(v1.EventMessage{TaskID: "t1", Message: &v1.Message{StepType: step, Status: "success"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "events saved", func() bool {
+		return recorder.find("t1", "Init") != nil && recorder.find("t1", "CreateCluster") != nil
+	})
+	recorder.lock.Lock()
+	if first := recorder.events[0].Message.StepType; first != "Init" {
+		t.Errorf("want the events saved in order, got %s first", first)
+	}
+	recorder.lock.Unlock()
+	cancel()
+	<-stopped
+}
+
+func TestTaskConsumerClusterLock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nsqd := newTestNSQD(t, time.Second)
+	queue := &fakeTaskQueue{}
+	handler := &fakeTaskHandler{release: make(chan struct{})}
+	c := newTestNSQConsumerWithLocker(ctx, nsqd, queue, &fakeTaskEventRepo{}, &fakeTaskEventRecorder{}, handler)
+	stopped := startTestNSQConsumer(t, c)
+
+	send := func(taskID, clusterID string) {
+		sendTestTask(t, nsqd, c.registry, constants.CloudCreate,
+			&types.KubernetesConfigMessage{TaskID: taskID, KubernetesConfig: &v1alpha1.KubernetesClusterConfig{Provider: "rke", ClusterID: clusterID}})
+	}
+	send("t1", "c1")
+	waitFor(t, "task t1 handled", func() bool { return handler.handledTimes("t1") == 1 })
+	// t2 waits for t1 changing the cluster c1, and the lock of cluster c2 is held by another replica
+	queue.LockCluster("c2", "other", "other-replica")
+	send("t2", "c1")
+	send("t3", "c2")
+	time.Sleep(100 * time.Millisecond)
+	if handler.handledTimes("t2") != 0 || handler.handledTimes("t3") != 0 {
+		t.Fatal("want the tasks waiting for the locks of clusters")
+	}
+
+	close(handler.release)
+	waitFor(t, "task t2 handled", func() bool { return handler.handledTimes("t2") == 1 })
+	queue.UnlockCluster("other")
+	waitFor(t, "task t3 handled", func() bool { return handler.handledTimes("t3") == 1 })
+	waitFor(t, "cluster locks released", func() bool {
+		queue.lock.Lock()
+		defer queue.lock.Unlock()
+		return len(queue.clusterLocks) == 0
+	})
+	cancel()
+	<-stopped
+}
@@ -0,0 +1,330 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package nsqc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	v1 "github.com/wutong-paas/cloud-adaptor/api/cloud-adaptor/v1"
+	"github.com/wutong-paas/cloud-adaptor/cmd/cloud-adaptor/config"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+	"github.com/wutong-paas/cloud-adaptor/internal/repo"
+	"github.com/wutong-paas/cloud-adaptor/internal/task"
+	"github.com/wutong-paas/cloud-adaptor/internal/types"
+	"github.com/wutong-paas/cloud-adaptor/internal/usecase"
+)
+
+const (
+	// taskLease the lease of a claimed task, the task is orphaned if its lease is not renewed in time.
+	taskLease             = time.Minute
+	taskHeartbeatInterval = 15 * time.Second
+	taskPollInterval      = time.Second
+	// maxTaskAttempts the times a task can be claimed, so that a task crashing the adaptor is not resumed forever.
+	maxTaskAttempts = 3
+	// interruptedStep the step of the event recorded for the orphaned tasks that are not resumed
+	interruptedStep = string(domain.TaskStepInterrupted)
+)
+
+// TaskEventRecorder records the events of tasks, and sets the status of tasks by the events.
+type TaskEventRecorder interface {
+	CreateTaskEvent(em *v1.EventMessage) (*model.TaskEvent, error)
+}
+
+// taskDBConsumer consumes the tasks in the durable queue. The tasks are claimed with leases renewed by heartbeats,
+// and the orphaned tasks whose leases are expired are resumed or marked failed.
+// The tasks are claimed only if the workers of the replica and of their providers are not used up, and the task
+// changing a cluster is claimed only if no other task is changing the cluster, the rest wait in the queue.
+type taskDBConsumer struct {
+	ctx           context.Context
+	owner         string
+	registry      *types.TaskRegistry
+	queue         repo.TaskQueueRepository
+	taskEventRepo repo.TaskEventRepository
+	events        TaskEventRecorder
+
+	lease             time.Duration
+	heartbeatInterval time.Duration
+	pollInterval      time.Duration
+
+	// scheduler counts the workers, the cluster locks are taken along with the claims
+	scheduler *taskScheduler
+}
+
+// NewTaskDBConsumer creates a new consumer of the durable queue.
+func NewTaskDBConsumer(
+	ctx context.Context,
+	scheduler *config.TaskScheduler,
+	registry *types.TaskRegistry,
+	queue repo.TaskQueueRepository,
+	taskEventRepo repo.TaskEventRepository,
+	events TaskEventRecorder,
+) TaskConsumer {
+	s := newTaskScheduler(scheduler, nil)
+	return &taskDBConsumer{
+		ctx:               ctx,
+		owner:             s.owner,
+		registry:          registry,
+		queue:             queue,
+		taskEventRepo:     taskEventRepo,
+		events:            events,
+		lease:             taskLease,
+		heartbeatInterval: taskHeartbeatInterval,
+		pollInterval:      taskPollInterval,
+		scheduler:         s,
+	}
+}
+
+// Start recovers the orphaned tasks, then claims and runs the pending tasks until ctx is done.
+func (d *taskDBConsumer) Start() error {
+	d.recoverOrphans()
+	poll := time.NewTicker(d.pollInterval)
+	defer poll.Stop()
+	// the leases held by the previous process of adaptor expire after a restart
+	recoverTicker := time.NewTicker(d.lease / 2)
+	defer recoverTicker.Stop()
+	for {
+		select {
+		case <-d.ctx.Done():
+			return nil
+		case <-poll.C:
+			d.claimTasks()
+		case <-recoverTicker.C:
+			d.recoverOrphans()
+		}
+	}
+}
+
+func (d *taskDBConsumer) claimTasks() {
+	for {
+		excludeProviders, ok := d.scheduler.available()
+		if !ok {
+			return
+		}
+		qt, err := d.queue.Claim(d.owner, d.lease, excludeProviders...)
+		if err != nil {
+			logrus.Errorf("claim task failure %s", err.Error())
+			return
+		}
+		if qt == nil {
+			return
+		}
+		logrus.Infof("claim task %s of %s, attempts %d", qt.TaskID, qt.Topic, qt.Attempts)
+		d.scheduler.acquire(qt.ProviderName)
+		go func() {
+			defer d.scheduler.release(qt.ProviderName)
+			d.run(qt)
+		}()
+	}
+}
+
+// run runs the task and renews its lease until the task completes. The task is canceled if its lease is lost,
+// or it is requested to be cancelled.
+func (d *taskDBConsumer) run(qt *model.QueuedTask) {
+	ctx, cancel := context.WithCancelCause(d.ctx)
+	defer cancel(nil)
+	done := make(chan struct{})
+	var once sync.Once
+	ctx = task.WithDone(ctx, func() {
+		once.Do(func() { close(done) })
+	})
+	if err := d.handle(ctx, qt); err != nil {
+		logrus.Errorf("handle task %s failure %s", qt.TaskID, err.Error())
+		d.recordInterrupted(qt.TaskID, fmt.Sprintf("the message of task is invalid: %s", err.Error()))
+		d.finish(qt, model.QueuedTaskFailed)
+		return
+	}
+	heartbeat := time.NewTicker(d.heartbeatInterval)
+	defer heartbeat.Stop()
+	status := model.QueuedTaskDone
+	for {
+		select {
+		case <-done:
+			d.finish(qt, status)
+			return
+		case <-d.ctx.Done():
+			// the lease expires, and the task is recovered after the adaptor restarts
+			return
+		case <-heartbeat.C:
+			if err := d.queue.Heartbeat(qt.TaskID, d.owner, d.lease); err != nil {
+				if errors.Is(err, repo.ErrTaskCancelRequested) {
+					if status != model.QueuedTaskCancelled {
+						logrus.Infof("cancel task %s", qt.TaskID)
+						status = model.QueuedTaskCancelled
+						cancel(task.ErrCancelled)
+					}
+					continue
+				}
+				if errors.Is(err, repo.ErrTaskLeaseLost) {
+					logrus.Warningf("the lease of task %s is lost, cancel it", qt.TaskID)
+					return
+				}
+				logrus.Warningf("renew the lease of task %s failure %s", qt.TaskID, err.Error())
+			}
+		}
+	}
+}
+
+func (d *taskDBConsumer) handle(ctx context.Context, qt *model.QueuedTask) error {
+	def, payload, err := d.registry.Decode(qt.Topic, []byte(qt.Payload))
+	if err != nil {
+		return err
+	}
+	return def.Handler.HandleTask(ctx, payload)
+}
+
+func (d *taskDBConsumer) finish(qt *model.QueuedTask, status string) {
+	if err := d.queue.Finish(qt.TaskID, d.owner, status); err != nil {
+		logrus.Warningf("set task %s %s failure %s", qt.TaskID, status, err.Error())
+		return
+	}
+	logrus.Infof("task %s is %s", qt.TaskID, status)
+}
+
+// recoverOrphans finds the tasks whose leases are expired because their workers crashed. The tasks whose
+// definitions can resume them, such as init, are resumed from the steps not succeeded. The other tasks are
+// marked failed with an event explaining why, because running them again is not safe once they are interrupted halfway.
+func (d *taskDBConsumer) recoverOrphans() {
+	tasks, undecryptable, err := d.queue.ListExpired()
+	if err != nil {
+		logrus.Errorf("list orphaned tasks failure %s", err.Error())
+		return
+	}
+	for _, qt := range undecryptable {
+		d.failOrphan(qt, "the payload of the task can not be decrypted, check --encrypt-key is the same for all replicas and retry it")
+	}
+	for _, qt := range tasks {
+		status := model.QueuedTaskFailed
+		reason := "the task was interrupted because the adaptor exited, check the cluster and retry it"
+		if qt.CancelRequested {
+			status = model.QueuedTaskCancelled
+		} else if def, ok := d.registry.Get(qt.Topic); ok && def.Resume != nil {
+			if qt.Attempts >= maxTaskAttempts {
+				reason = fmt.Sprintf("the task was interrupted %d times, give up resuming it", qt.Attempts)
+			} else if payload, err := d.resumePayload(qt); err != nil {
+				logrus.Warningf("resume task %s failure %s", qt.TaskID, err.Error())
+			} else {
+				qt.Payload = payload
+				status = model.QueuedTaskPending
+			}
+		}
+		if status == model.QueuedTaskFailed {
+			d.failOrphan(qt, reason)
+			continue
+		}
+		ok, err := d.queue.Recover(qt, status)
+		if err != nil {
+			logrus.Errorf("recover orphaned task %s failure %s", qt.TaskID, err.Error())
+			continue
+		}
+		if !ok {
+			// recovered by others, or the lease is renewed
+			continue
+		}
+		if status == model.QueuedTaskPending {
+			logrus.Infof("resume orphaned task %s", qt.TaskID)
+			continue
+		}
+		recordCancelled(d.events, qt.TaskID)
+	}
+}
+
+// failOrphan marks the orphaned task failed with an event explaining why.
+func (d *taskDBConsumer) failOrphan(qt *model.QueuedTask, reason string) {
+	ok, err := d.queue.Recover(qt, model.QueuedTaskFailed)
+	if err != nil {
+		logrus.Errorf("recover orphaned task %s failure %s", qt.TaskID, err.Error())
+		return
+	}
+	if !ok {
+		// recovered by others, or the lease is renewed
+		return
+	}
+	logrus.Warningf("orphaned task %s is failed: %s", qt.TaskID, reason)
+	d.recordInterrupted(qt.TaskID, reason)
+}
+
+// resumePayload returns the payload of task skipping the steps succeeded.
+func (d *taskDBConsumer) resumePayload(qt *model.QueuedTask) (string, error) {
+	def, payload, err := d.registry.Decode(qt.Topic, []byte(qt.Payload))
+	if err != nil {
+		return "", err
+	}
+	events, err := d.taskEventRepo.ListEvent(qt.TaskID)
+	if err != nil {
+		return "", err
+	}
+	if !def.Resume(payload, succeededSteps(events)) {
+		return "", fmt.Errorf("task %s can not be resumed", qt.TaskID)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+func recordCancelled(events TaskEventRecorder, taskID string) {
+	_, err := events.CreateTaskEvent(&v1.EventMessage{
+		TaskID: taskID,
+		Message: &v1.Message{
+			StepType: usecase.TaskCancelledStep,
+			Message:  "the task is cancelled",
+			Status:   "failure",
+		},
+	})
+	if err != nil {
+		logrus.Errorf("record task %s cancelled failure %s", taskID, err.Error())
+	}
+}
+
+func (d *taskDBConsumer) recordInterrupted(taskID, reason string) {
+	recordInterrupted(d.events, taskID, reason)
+}
+
+// succeededSteps returns the steps of the success events.
+func succeededSteps(events []*model.TaskEvent) []string {
+	var succeeded []string
+	for _, event := range events {
+		if event.Status == "success" {
+			succeeded = append(succeeded, event.StepType)
+		}
+	}
+	return succeeded
+}
+
+func recordInterrupted(events TaskEventRecorder, taskID, reason string) {
+	_, err := events.CreateTaskEvent(&v1.EventMessage{
+		TaskID: taskID,
+		Message: &v1.Message{
+			StepType: interruptedStep,
+			Message:  reason,
+			Status:   "failure",
+		},
+	})
+	if err != nil {
+		logrus.Errorf("record the interrupted event of task %s failure %s", taskID, err.Error())
+	}
+}
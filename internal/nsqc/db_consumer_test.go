@@ -0,0 +1,576 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package nsqc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	nsq "github.com/nsqio/go-nsq"
+	v1 "github.com/wutong-paas/cloud-adaptor/api/cloud-adaptor/v1"
+	"github.com/wutong-paas/cloud-adaptor/cmd/cloud-adaptor/config"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+	"github.com/wutong-paas/cloud-adaptor/internal/repo"
+	"github.com/wutong-paas/cloud-adaptor/internal/task"
+	"github.com/wutong-paas/cloud-adaptor/internal/types"
+	"github.com/wutong-paas/cloud-adaptor/internal/usecase"
+	"github.com/wutong-paas/cloud-adaptor/pkg/util/constants"
+	"gorm.io/gorm"
+)
+
+type fakeTaskQueue struct {
+	lock  sync.Mutex
+	tasks []*model.QueuedTask
+	// clusterLocks the cluster locks held by the tasks
+	clusterLocks map[string]string
+	// undecryptable the tasks whose payloads can not be decrypted
+	undecryptable map[string]bool
+}
+
+func (f *fakeTaskQueue) Enqueue(qt *model.QueuedTask) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	ent := *qt
+	ent.Status = model.QueuedTaskPending
+	f.tasks = append(f.tasks, &ent)
+	return nil
+}
+
+func (f *fakeTaskQueue) Claim(owner string, lease time.Duration, excludeProviders ...string) (*model.QueuedTask, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	excluded := make(map[string]bool)
+	for _, provider := range excludeProviders {
+		excluded[provider] = true
+	}
+	for _, qt := range f.tasks {
+		if qt.Status == model.QueuedTaskPending && !excluded[qt.ProviderName] {
+			if qt.Exclusive {
+				if _, ok := f.clusterLocks[qt.ClusterID]; ok {
+					continue
+				}
+				if f.clusterLocks == nil {
+					f.clusterLocks = make(map[string]string)
+				}
+				f.clusterLocks[qt.ClusterID] = qt.TaskID
+			}
+			expiresAt := time.Now().Add(lease)
+			qt.Status, qt.Owner, qt.LeaseExpiresAt = model.QueuedTaskRunning, owner, &expiresAt
+			qt.Attempts++
+			claimed := *qt
+			return &claimed, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeTaskQueue) unlockCluster(taskID string) {
+	for clusterID, holder := range f.clusterLocks {
+		if holder == taskID {
+			delete(f.clusterLocks, clusterID)
+		}
+	}
+}
+
+func (f *fakeTaskQueue) LockCluster(clusterID, taskID, owner string) (bool, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if holder, ok := f.clusterLocks[clusterID]; ok && holder != taskID {
+		return false, nil
+	}
+	if f.clusterLocks == nil {
+		f.clusterLocks = make(map[string]string)
+	}
+	f.clusterLocks[clusterID] = taskID
+	return true, nil
+}
+
+func (f *fakeTaskQueue) UnlockCluster(taskID string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.unlockCluster(taskID)
+	return nil
+}
+
+func (f *fakeTaskQueue) get(taskID string) *model.QueuedTask {
+	for _, qt := range f.tasks {
+		if qt.TaskID == taskID {
+			return qt
+		}
+	}
+	return nil
+}
+
+func (f *fakeTaskQueue) Heartbeat(taskID, owner string, lease time.Duration) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	qt := f.get(taskID)
+	if qt == nil || qt.Owner != owner || qt.Status != model.QueuedTaskRunning {
+		return repo.ErrTaskLeaseLost
+	}
+	expiresAt := time.Now().Add(lease)
+	qt.LeaseExpiresAt = &expiresAt
+	if qt.CancelRequested {
+		return repo.ErrTaskCancelRequested
+	}
+	return nil
+}
+
+func (f *fakeTaskQueue) Finish(taskID, owner, status string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	qt := f.get(taskID)
+	if qt == nil || qt.Owner != owner || qt.Status != model.QueuedTaskRunning {
+		return repo.ErrTaskLeaseLost
+	}
+	qt.Status, qt.LeaseExpiresAt = status, nil
+	f.unlockCluster(taskID)
+	return nil
+}
+
+func (f *fakeTaskQueue) ListExpired() (tasks, undecryptable []*model.QueuedTask, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, qt := range f.tasks {
+		if qt.Status == model.QueuedTaskRunning && qt.LeaseExpiresAt.Before(time.Now()) {
+			expired := *qt
+			if f.undecryptable[qt.TaskID] {
+				expired.Payload = ""
+				undecryptable = append(undecryptable, &expired)
+				continue
+			}
+			tasks = append(tasks, &expired)
+		}
+	}
+	return tasks, undecryptable, nil
+}
+
+func (f *fakeTaskQueue) Recover(qt *model.QueuedTask, status string) (bool, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	old := f.get(qt.TaskID)
+	if old == nil || old.Owner != qt.Owner || old.Status != model.QueuedTaskRunning || !old.LeaseExpiresAt.Before(time.Now()) {
+		return false, nil
+	}
+	old.Status, old.Owner, old.LeaseExpiresAt, old.Payload = status, "", nil, qt.Payload
+	f.unlockCluster(qt.TaskID)
+	return true, nil
+}
+
+func (f *fakeTaskQueue) Get(taskID string) (*model.QueuedTask, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	qt := f.get(taskID)
+	if qt == nil {
+		return nil, nil
+	}
+	res := *qt
+	return &res, nil
+}
+
+func (f *fakeTaskQueue) Position(qt *model.QueuedTask) (int64, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	qt = f.get(qt.TaskID)
+	var position int64 = 1
+	for _, pending := range f.tasks {
+		if pending.TaskID == qt.TaskID {
+			break
+		}
+		if pending.Status != model.QueuedTaskPending {
+			continue
+		}
+		_, locked := f.clusterLocks[pending.ClusterID]
+		claimable := !pending.Exclusive || !locked
+		if pending.ProviderName == qt.ProviderName && claimable ||
+			qt.Exclusive && pending.Exclusive && pending.ClusterID == qt.ClusterID {
+			position++
+		}
+	}
+	return position, nil
+}
+
+func (f *fakeTaskQueue) Cancel(taskID string) (*model.QueuedTask, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	qt := f.get(taskID)
+	if qt == nil {
+		return nil, nil
+	}
+	switch qt.Status {
+	case model.QueuedTaskPending:
+		qt.Status = model.QueuedTaskCancelled
+	case model.QueuedTaskRunning:
+		qt.CancelRequested = true
+	}
+	cancelled := *qt
+	return &cancelled, nil
+}
+
+func (f *fakeTaskQueue) task(taskID string) model.QueuedTask {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return *f.get(taskID)
+}
+
+type fakeTaskEventRepo struct {
+	lock   sync.Mutex
+	events []*model.TaskEvent
+}
+
+func (f *fakeTaskEventRepo) add(event *model.TaskEvent) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.events = append(f.events, event)
+}
+
+func (f *fakeTaskEventRepo) Transaction(tx *gorm.DB) repo.TaskEventRepository { return f }
+
+func (f *fakeTaskEventRepo) Create(ent *model.TaskEvent) error { return nil }
+
+func (f *fakeTaskEventRepo) ListEvent(taskID string) ([]*model.TaskEvent, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var events []*model.TaskEvent
+	for _, event := range f.events {
+		if event.TaskID == taskID {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (f *fakeTaskEventRepo) ListEventSince(taskID string, since time.Time) ([]*model.TaskEvent, error) {
+	return nil, nil
+}
+
+func (f *fakeTaskEventRepo) UpdateStatusInBatch(eventIDs []string, status string) error { return nil }
+
+type fakeTaskEventRecorder struct {
+	lock   sync.Mutex
+	events []*v1.EventMessage
+}
+
+func (f *fakeTaskEventRecorder) CreateTaskEvent(em *v1.EventMessage) (*model.TaskEvent, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.events = append(f.events, em)
+	return &model.TaskEvent{TaskID: em.TaskID}, nil
+}
+
+// fakeTaskHandler runs the tasks until they are released
+type fakeTaskHandler struct {
+	release chan struct{}
+	lock    sync.Mutex
+	handled []string
+	causes  []error
+}
+
+func (f *fakeTaskHandler) run(ctx context.Context, taskID string) error {
+	f.lock.Lock()
+	f.handled = append(f.handled, taskID)
+	f.lock.Unlock()
+	go func() {
+		select {
+		case <-f.release:
+		case <-ctx.Done():
+			f.lock.Lock()
+			f.causes = append(f.causes, context.Cause(ctx))
+			f.lock.Unlock()
+		}
+		task.Done(ctx)
+	}()
+	return nil
+}
+
+func (f *fakeTaskHandler) HandleMessage(m *nsq.Message) error { return nil }
+
+func (f *fakeTaskHandler) HandleMsg(ctx context.Context, msg types.KubernetesConfigMessage) error {
+	return f.run(ctx, msg.TaskID)
+}
+
+type fakeInitHandler struct{ *fakeTaskHandler }
+
+func (f fakeInitHandler) HandleMsg(ctx context.Context, msg types.InitWutongConfigMessage) error {
+	return f.run(ctx, msg.TaskID)
+}
+
+type fakeUpdateHandler struct{ *fakeTaskHandler }
+
+func (f fakeUpdateHandler) HandleMsg(ctx context.Context, msg types.UpdateKubernetesConfigMessage) error {
+	return f.run(ctx, msg.TaskID)
+}
+
+func newTestDBConsumer(ctx context.Context, queue *fakeTaskQueue, eventRepo *fakeTaskEventRepo, recorder *fakeTaskEventRecorder, handler *fakeTaskHandler) *taskDBConsumer {
+	return newTestDBConsumerWithScheduler(ctx, nil, queue, eventRepo, recorder, handler)
+}
+
+func newTestDBConsumerWithScheduler(ctx context.Context, scheduler *config.TaskScheduler, queue *fakeTaskQueue, eventRepo *fakeTaskEventRepo,
+	recorder *fakeTaskEventRecorder, handler *fakeTaskHandler) *taskDBConsumer {
+	registry := types.NewTaskRegistry()
+	if _, err := task.NewTaskDefinitions(registry, handler, fakeInitHandler{handler}, fakeUpdateHandler{handler}, nil); err != nil {
+		panic(err)
+	}
+	d := NewTaskDBConsumer(ctx, scheduler, registry, queue, eventRepo, recorder).(*taskDBConsumer)
+	d.lease = 200 * time.Millisecond
+	d.heartbeatInterval = 20 * time.Millisecond
+	d.pollInterval = 10 * time.Millisecond
+	return d
+}
+
+func waitTaskStatus(t *testing.T, queue *fakeTaskQueue, taskID, status string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for queue.task(taskID).Status != status {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting task %s %s, got %s", taskID, status, queue.task(taskID).Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTaskDBConsumerRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue := &fakeTaskQueue{}
+	handler := &fakeTaskHandler{release: make(chan struct{})}
+	d := newTestDBConsumer(ctx, queue, &fakeTaskEventRepo{}, &fakeTaskEventRecorder{}, handler)
+	go d.Start()
+
+	body, _ := json.Marshal(types.KubernetesConfigMessage{TaskID: "t1", KubernetesConfig: &v1alpha1.KubernetesClusterConfig{Provider: "rke"}})
+	queue.Enqueue(&model.QueuedTask{TaskID: "t1", Topic: constants.CloudCreate, Payload: string(body)})
+	waitTaskStatus(t, queue, "t1", model.QueuedTaskRunning)
+
+	// the lease is renewed while the task is running
+	time.Sleep(3 * d.lease)
+	if qt := queue.task("t1"); qt.Status != model.QueuedTaskRunning || qt.Attempts != 1 {
+		t.Fatalf("want the task running with the lease renewed, got %+v", qt)
+	}
+	close(handler.release)
+	waitTaskStatus(t, queue, "t1", model.QueuedTaskDone)
+}
+
+func TestTaskDBConsumerRecoverOrphans(t *testing.T) {
+	expired := time.Now().Add(-time.Minute)
+	initBody, _ := json.Marshal(types.InitWutongConfigMessage{TaskID: "init", InitWutongConfig: &types.InitWutongConfig{ClusterID: "c1", Provider: "rke"}})
+	createBody, _ := json.Marshal(types.KubernetesConfigMessage{TaskID: "create", KubernetesConfig: &v1alpha1.KubernetesClusterConfig{Provider: "rke"}})
+	queue := &fakeTaskQueue{tasks: []*model.QueuedTask{
+		{TaskID: "init", Topic: constants.CloudInit, Payload: string(initBody), Status: model.QueuedTaskRunning, Owner: "crashed", LeaseExpiresAt: &expired, Attempts: 1},
+		{TaskID: "init-crash-loop", Topic: constants.CloudInit, Payload: string(initBody), Status: model.QueuedTaskRunning, Owner: "crashed", LeaseExpiresAt: &expired, Attempts: maxTaskAttempts},
+		{TaskID: "create", Topic: constants.CloudCreate, Payload: string(createBody), Status: model.QueuedTaskRunning, Owner: "crashed", LeaseExpiresAt: &expired, Attempts: 1},
+		{TaskID: "cancelled", Topic: constants.CloudInit, Payload: string(initBody), Status: model.QueuedTaskRunning, Owner: "crashed", LeaseExpiresAt: &expired, Attempts: 1, CancelRequested: true},
+		{TaskID: "undecryptable", Topic: constants.CloudInit, Payload: "garbage", Status: model.QueuedTaskRunning, Owner: "crashed", LeaseExpiresAt: &expired, Attempts: 1},
+	}, undecryptable: map[string]bool{"undecryptable": true}}
+	eventRepo := &fakeTaskEventRepo{events: []*model.TaskEvent{
+		{TaskID: "init", StepType: "CreateNamespace", Status: "success"},
+		{TaskID: "init", StepType: "InstallOperator", Status: "start"},
+	}}
+	recorder := &fakeTaskEventRecorder{}
+	d := newTestDBConsumer(context.Background(), queue, eventRepo, recorder, &fakeTaskHandler{})
+	d.recoverOrphans()
+
+	resumed := queue.task("init")
+	if resumed.Status != model.QueuedTaskPending || resumed.Owner != "" {
+		t.Fatalf("want the init task resumed, got %+v", resumed)
+	}
+	var msg types.InitWutongConfigMessage
+	if err := json.Unmarshal([]byte(resumed.Payload), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if steps := msg.InitWutongConfig.CompletedSteps; len(steps) != 1 || steps[0] != "CreateNamespace" {
+		t.Fatalf("want the succeeded steps skipped, got %v", steps)
+	}
+	for _, taskID := range []string{"init-crash-loop", "create", "undecryptable"} {
+		if status := queue.task(taskID).Status; status != model.QueuedTaskFailed {
+			t.Errorf("want task %s failed, got %s", taskID, status)
+		}
+	}
+	if len(recorder.events) != 4 {
+		t.Fatalf("want the interrupted events of the failed tasks, got %d", len(recorder.events))
+	}
+	if status := queue.task("cancelled").Status; status != model.QueuedTaskCancelled {
+		t.Errorf("want the task requested to be cancelled cancelled, got %s", status)
+	}
+	steps := make(map[string]string)
+	for _, em := range recorder.events {
+		if em.Message.Status != "failure" || em.Message.Message == "" {
+			t.Errorf("unexpected event %+v", em.Message)
+		}
+		steps[em.TaskID] = em.Message.StepType
+	}
+	want := map[string]string{"init-crash-loop": interruptedStep, "create": interruptedStep, "undecryptable": interruptedStep, "cancelled": usecase.TaskCancelledStep}
+	for taskID, step := range want {
+		if steps[taskID] != step {
+			t.Errorf("want the %s event of task %s, got %q", step, taskID, steps[taskID])
+		}
+	}
+}
+
+func TestTaskDBConsumerCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue := &fakeTaskQueue{}
+	handler := &fakeTaskHandler{release: make(chan struct{})}
+	d := newTestDBConsumer(ctx, queue, &fakeTaskEventRepo{}, &fakeTaskEventRecorder{}, handler)
+
+	body, _ := json.Marshal(types.UpdateKubernetesConfigMessage{TaskID: "pending", Config: &v1alpha1.ExpansionNode{}})
+	queue.Enqueue(&model.QueuedTask{TaskID: "pending", Topic: constants.CloudUpdate, Payload: string(body)})
+	if qt, _ := queue.Cancel("pending"); qt.Status != model.QueuedTaskCancelled {
+		t.Fatalf("want the pending task cancelled at once, got %s", qt.Status)
+	}
+
+	go d.Start()
+	body, _ = json.Marshal(types.UpdateKubernetesConfigMessage{TaskID: "running", Config: &v1alpha1.ExpansionNode{}})
+	queue.Enqueue(&model.QueuedTask{TaskID: "running", Topic: constants.CloudUpdate, Payload: string(body)})
+	waitTaskStatus(t, queue, "running", model.QueuedTaskRunning)
+	if qt, _ := queue.Cancel("running"); !qt.CancelRequested {
+		t.Fatal("want the running task requested to be cancelled")
+	}
+	waitTaskStatus(t, queue, "running", model.QueuedTaskCancelled)
+
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	if len(handler.handled) != 1 || handler.handled[0] != "running" {
+		t.Fatalf("want only the running task handled, got %v", handler.handled)
+	}
+	if len(handler.causes) != 1 || !errors.Is(handler.causes[0], task.ErrCancelled) {
+		t.Fatalf("want the task context cancelled by ErrCancelled, got %v", handler.causes)
+	}
+}
+
+func TestTaskDBConsumerSchedule(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue := &fakeTaskQueue{}
+	handler := &fakeTaskHandler{release: make(chan struct{})}
+	scheduler := &config.TaskScheduler{Workers: 3, ProviderWorkers: map[string]int{"rke": 1}}
+	d := newTestDBConsumerWithScheduler(ctx, scheduler, queue, &fakeTaskEventRepo{}, &fakeTaskEventRecorder{}, handler)
+
+	enqueue := func(taskID, provider, clusterID string, exclusive bool) {
+		body, _ := json.Marshal(types.UpdateKubernetesConfigMessage{TaskID: taskID, Config: &v1alpha1.ExpansionNode{}})
+		queue.Enqueue(&model.QueuedTask{TaskID: taskID, Topic: constants.CloudUpdate, Payload: string(body),
+			ProviderName: provider, ClusterID: clusterID, Exclusive: exclusive})
+	}
+	enqueue("rke-1", "rke", "c1", true)
+	enqueue("rke-2", "rke", "c2", true)
+	enqueue("ack-1", "ack", "c3", true)
+	// the task changing the cluster c3 waits for ack-1
+	enqueue("ack-2", "ack", "c3", true)
+	// the task not changing the cluster runs along with ack-1
+	enqueue("ack-snapshot", "ack", "c3", false)
+	enqueue("ack-3", "ack", "c4", true)
+	enqueue("ack-4", "ack", "c4", true)
+	d.claimTasks()
+
+	want := map[string]string{
+		"rke-1":        model.QueuedTaskRunning,
+		"rke-2":        model.QueuedTaskPending,
+		"ack-1":        model.QueuedTaskRunning,
+		"ack-2":        model.QueuedTaskPending,
+		"ack-snapshot": model.QueuedTaskRunning,
+		"ack-3":        model.QueuedTaskPending,
+		"ack-4":        model.QueuedTaskPending,
+	}
+	for taskID, status := range want {
+		if got := queue.task(taskID).Status; got != status {
+			t.Errorf("want task %s %s, got %s", taskID, status, got)
+		}
+	}
+	// the tasks wait behind the claimable tasks of the same provider and the tasks changing the same cluster
+	positions := map[string]int64{"rke-2": 1, "ack-2": 1, "ack-3": 1, "ack-4": 2}
+	for taskID, want := range positions {
+		if position, _ := queue.Position(&model.QueuedTask{TaskID: taskID}); position != want {
+			t.Errorf("want the position of %s %d, got %d", taskID, want, position)
+		}
+	}
+
+	close(handler.release)
+	go d.Start()
+	for taskID := range want {
+		waitTaskStatus(t, queue, taskID, model.QueuedTaskDone)
+	}
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	if len(queue.clusterLocks) != 0 {
+		t.Errorf("want the cluster locks released, got %v", queue.clusterLocks)
+	}
+}
+
+type fakeUninstallPayload struct {
+	TaskID    string `json:"task_id"`
+	ClusterID string `json:"cluster_id"`
+}
+
+func (f *fakeUninstallPayload) GetTaskID() string { return f.TaskID }
+
+func (f *fakeUninstallPayload) Target() (string, string) { return "rke", f.ClusterID }
+
+func (f *fakeUninstallPayload) Mutating() bool { return true }
+
+func TestTaskDBConsumerDefinition(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue := &fakeTaskQueue{}
+	handler := &fakeTaskHandler{release: make(chan struct{})}
+	close(handler.release)
+	recorder := &fakeTaskEventRecorder{}
+	d := newTestDBConsumer(ctx, queue, &fakeTaskEventRepo{}, recorder, handler)
+	// a new kind of task plugs in by its definition
+	err := d.registry.Register(&types.TaskDefinition{
+		Name:       "uninstall",
+		Schema:     `{"type": "object", "required": ["task_id", "cluster_id"], "properties": {"cluster_id": {"type": "string", "minLength": 1}}}`,
+		NewPayload: func() types.TaskPayload { return &fakeUninstallPayload{} },
+		Handler: types.TaskHandlerFunc(func(ctx context.Context, payload types.TaskPayload) error {
+			return handler.run(ctx, payload.GetTaskID())
+		}),
+		Lifecycle: &domain.TaskLifecycle{
+			Plans:         map[string][]domain.TaskStep{"": {"Uninstall"}},
+			SuccessSteps:  []domain.TaskStep{"Uninstall"},
+			SuccessStatus: domain.TaskStatusComplete,
+			FailureStatus: domain.TaskStatusFailed,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go d.Start()
+
+	queue.Enqueue(&model.QueuedTask{TaskID: "uninstall", Topic: "uninstall", Payload: `{"task_id": "uninstall", "cluster_id": "c1"}`})
+	queue.Enqueue(&model.QueuedTask{TaskID: "invalid", Topic: "uninstall", Payload: `{"task_id": "invalid", "cluster_id": ""}`})
+	queue.Enqueue(&model.QueuedTask{TaskID: "unknown", Topic: "unknown", Payload: `{}`})
+	waitTaskStatus(t, queue, "uninstall", model.QueuedTaskDone)
+	waitTaskStatus(t, queue, "invalid", model.QueuedTaskFailed)
+	waitTaskStatus(t, queue, "unknown", model.QueuedTaskFailed)
+
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	if len(handler.handled) != 1 || handler.handled[0] != "uninstall" {
+		t.Fatalf("want only the valid task handled, got %v", handler.handled)
+	}
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	if len(recorder.events) != 2 {
+		t.Fatalf("want the interrupted events of the invalid tasks, got %d", len(recorder.events))
+	}
+}
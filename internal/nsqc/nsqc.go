@@ -20,8 +20,8 @@ package nsqc
 
 import (
 	"github.com/google/wire"
-	"github.com/wutong-paas/cloud-adaptor/internal/nsqc/producer"
+	"github.com/wutong-paas/cloud-adaptor/internal/types"
 )
 
 // ProviderSet is mq providers.
-var ProviderSet = wire.NewSet(producer.NewTaskChannelProducer)
+var ProviderSet = wire.NewSet(NewTaskBackend, wire.FieldsOf(new(*TaskBackend), "Producer", "Locker"), types.NewTaskRegistry)
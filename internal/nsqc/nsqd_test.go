@@ -0,0 +1,74 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package nsqc
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/nsqio/nsq/nsqd"
+)
+
+// testNSQD a nsqd running in the test process, listening on a random port with its data in a temp dir.
+type testNSQD struct {
+	nsqd       *nsqd.NSQD
+	msgTimeout time.Duration
+}
+
+func newTestNSQD(t *testing.T, msgTimeout time.Duration) *testNSQD {
+	opts := nsqd.NewOptions()
+	opts.TCPAddress = "127.0.0.1:0"
+	opts.HTTPAddress = "127.0.0.1:0"
+	opts.HTTPSAddress = "127.0.0.1:0"
+	opts.DataPath = t.TempDir()
+	opts.MsgTimeout = msgTimeout
+	// the channels created after nsqd starts are scanned for the requeued and the timed out messages once refreshed
+	opts.QueueScanRefreshInterval = 100 * time.Millisecond
+	opts.Logger = log.New(io.Discard, "", 0)
+	n, err := nsqd.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		if err := n.Main(); err != nil {
+			t.Errorf("run nsqd failure %s", err.Error())
+		}
+	}()
+	t.Cleanup(n.Exit)
+	return &testNSQD{nsqd: n, msgTimeout: msgTimeout}
+}
+
+func (d *testNSQD) addr() string {
+	return d.nsqd.RealTCPAddr().String()
+}
+
+// depth returns the number of the messages of channel queued, deferred or in flight, or the messages of topic
+// if the channel does not exist yet.
+func (d *testNSQD) depth(topicName, channelName string) int {
+	stats := d.nsqd.GetStats(topicName, channelName, false)
+	for _, topic := range stats.Topics {
+		for _, ch := range topic.Channels {
+			return int(ch.Depth) + ch.InFlightCount + ch.DeferredCount
+		}
+		return int(topic.Depth)
+	}
+	return 0
+}
@@ -20,24 +20,17 @@ package producer
 
 import (
 	"github.com/wutong-paas/cloud-adaptor/internal/types"
-	"github.com/wutong-paas/cloud-adaptor/pkg/util/constants"
 )
 
 //TaskProducer task producer
 type taskChannelProducer struct {
-	createQueue chan types.KubernetesConfigMessage
-	initQueue   chan types.InitWutongConfigMessage
-	updateQueue chan types.UpdateKubernetesConfigMessage
+	queue chan types.TaskMessage
 }
 
 //NewTaskChannelProducer new task channel producer
-func NewTaskChannelProducer(createQueue chan types.KubernetesConfigMessage,
-	initQueue chan types.InitWutongConfigMessage,
-	updateQueue chan types.UpdateKubernetesConfigMessage) TaskProducer {
+func NewTaskChannelProducer(queue chan types.TaskMessage) TaskProducer {
 	return &taskChannelProducer{
-		createQueue: createQueue,
-		initQueue:   initQueue,
-		updateQueue: updateQueue,
+		queue: queue,
 	}
 }
 
@@ -46,33 +39,12 @@ func (c *taskChannelProducer) Start() error {
 	return nil
 }
 
-//SendTask send task
-func (c *taskChannelProducer) sendTask(topicName string, taskConfig interface{}) error {
-	if topicName == constants.CloudCreate {
-		c.createQueue <- taskConfig.(types.KubernetesConfigMessage)
-	}
-	if topicName == constants.CloudInit {
-		c.initQueue <- taskConfig.(types.InitWutongConfigMessage)
-	}
-	if topicName == constants.CloudUpdate {
-		c.updateQueue <- taskConfig.(types.UpdateKubernetesConfigMessage)
-	}
+// SendTask send the task message to the channel
+func (c *taskChannelProducer) SendTask(name string, payload types.TaskPayload) error {
+	c.queue <- types.TaskMessage{Name: name, Payload: payload}
 	return nil
 }
 
-//SendCreateKuerbetesTask send create kubernetes task
-func (c *taskChannelProducer) SendCreateKuerbetesTask(config types.KubernetesConfigMessage) error {
-	return c.sendTask(constants.CloudCreate, config)
-}
-
-//SendInitWutongRegionTask send init wutong region task
-func (c *taskChannelProducer) SendInitWutongRegionTask(config types.InitWutongConfigMessage) error {
-	return c.sendTask(constants.CloudInit, config)
-}
-func (c *taskChannelProducer) SendUpdateKuerbetesTask(config types.UpdateKubernetesConfigMessage) error {
-	return c.sendTask(constants.CloudUpdate, config)
-}
-
 //Stop stop
 func (c *taskChannelProducer) Stop() {
 
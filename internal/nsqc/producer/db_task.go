@@ -0,0 +1,68 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package producer
+
+import (
+	"encoding/json"
+
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+	"github.com/wutong-paas/cloud-adaptor/internal/repo"
+	"github.com/wutong-paas/cloud-adaptor/internal/types"
+)
+
+// taskDBProducer saves the tasks to the durable queue in database, so they survive the restarts of adaptor.
+type taskDBProducer struct {
+	queue    repo.TaskQueueRepository
+	registry *types.TaskRegistry
+}
+
+// NewTaskDBProducer new task producer on the durable queue
+func NewTaskDBProducer(queue repo.TaskQueueRepository, registry *types.TaskRegistry) TaskProducer {
+	return &taskDBProducer{queue: queue, registry: registry}
+}
+
+// Start start
+func (d *taskDBProducer) Start() error {
+	return nil
+}
+
+// SendTask validates the payload of task against the schema of its definition, and saves it to the queue.
+func (d *taskDBProducer) SendTask(name string, payload types.TaskPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if err := d.registry.Validate(name, body); err != nil {
+		return err
+	}
+	provider, clusterID := payload.Target()
+	return d.queue.Enqueue(&model.QueuedTask{
+		TaskID:       payload.GetTaskID(),
+		Topic:        name,
+		ProviderName: provider,
+		ClusterID:    clusterID,
+		Exclusive:    payload.Mutating() && clusterID != "",
+		Payload:      string(body),
+	})
+}
+
+// Stop stop
+func (d *taskDBProducer) Stop() {
+
+}
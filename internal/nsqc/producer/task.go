@@ -24,39 +24,36 @@ import (
 
 	nsq "github.com/nsqio/go-nsq"
 	"github.com/sirupsen/logrus"
-	"github.com/wutong-paas/cloud-adaptor/cmd/cloud-adaptor/config"
 	"github.com/wutong-paas/cloud-adaptor/internal/types"
-	"github.com/wutong-paas/cloud-adaptor/pkg/util/constants"
 )
 
 //TaskProducer task producer
 type TaskProducer interface {
 	Start() error
-	SendCreateKuerbetesTask(config types.KubernetesConfigMessage) error
-	SendUpdateKuerbetesTask(config types.UpdateKubernetesConfigMessage) error
-	SendInitWutongRegionTask(config types.InitWutongConfigMessage) error
+	// SendTask sends the task message of the task definition name
+	SendTask(name string, payload types.TaskPayload) error
 	Stop()
 }
 
 //TaskProducer task producer
 type taskProducer struct {
 	taskProducer *nsq.Producer
+	registry     *types.TaskRegistry
 }
 
-//NewTaskProducer new task producer
-func NewTaskProducer() TaskProducer {
-	return &taskProducer{}
+// NewTaskProducer creates a producer publishing the tasks to the topics of their definitions on nsqd address.
+func NewTaskProducer(address string, registry *types.TaskRegistry) (TaskProducer, error) {
+	producer, err := nsq.NewProducer(address, nsq.NewConfig())
+	if err != nil {
+		return nil, err
+	}
+	return &taskProducer{taskProducer: producer, registry: registry}, nil
 }
 
 //Start start
 func (m *taskProducer) Start() error {
-	c := nsq.NewConfig()
-	taskProducer, err := nsq.NewProducer(config.C.NSQConfig.NsqdAddress, c)
-	if err != nil {
-		return err
-	}
 	for {
-		if err := taskProducer.Ping(); err != nil {
+		if err := m.taskProducer.Ping(); err != nil {
 			logrus.Errorf("ping nsqd server failure %s", err.Error())
 			time.Sleep(time.Second * 3)
 			continue
@@ -64,32 +61,21 @@ func (m *taskProducer) Start() error {
 		logrus.Infof("ping nsqd server success")
 		break
 	}
-	m.taskProducer = taskProducer
 	logrus.Infof("task producer start success")
 	return nil
 }
 
-//SendTask send task
-func (m *taskProducer) sendTask(topicName string, taskConfig interface{}) error {
-	body, err := json.Marshal(taskConfig)
+// SendTask validates the payload of task against the schema of its definition, and publishes it to the topic of
+// the definition name.
+func (m *taskProducer) SendTask(name string, payload types.TaskPayload) error {
+	body, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
-	return m.taskProducer.Publish(topicName, body)
-}
-
-//SendCreateKuerbetesTask send create kubernetes task
-func (m *taskProducer) SendCreateKuerbetesTask(config types.KubernetesConfigMessage) error {
-	return m.sendTask(constants.CloudCreate, config)
-}
-
-//SendInitWutongRegionTask send init wutong region task
-func (m *taskProducer) SendInitWutongRegionTask(config types.InitWutongConfigMessage) error {
-	return m.sendTask(constants.CloudInit, config)
-}
-
-func (m *taskProducer) SendUpdateKuerbetesTask(config types.UpdateKubernetesConfigMessage) error {
-	return m.sendTask(constants.CloudUpdate, config)
+	if err := m.registry.Validate(name, body); err != nil {
+		return err
+	}
+	return m.taskProducer.Publish(name, body)
 }
 
 //Stop stop
@@ -0,0 +1,206 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package nsqc
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/wutong-paas/cloud-adaptor/cmd/cloud-adaptor/config"
+	"github.com/wutong-paas/cloud-adaptor/internal/types"
+	"github.com/wutong-paas/cloud-adaptor/pkg/util/uuidutil"
+)
+
+// taskScheduler limits the tasks running at the same time in one replica, by the workers of the replica
+// and of the providers. The tasks changing a cluster run one by one if the scheduler has a cluster locker.
+type taskScheduler struct {
+	owner  string
+	locker types.ClusterLocker
+
+	workers         int
+	providerWorkers map[string]int
+	mu              sync.Mutex
+	running         int
+	providerRunning map[string]int
+	// freed is closed once a task releases its worker
+	freed chan struct{}
+
+	pollInterval time.Duration
+}
+
+func newTaskScheduler(conf *config.TaskScheduler, locker types.ClusterLocker) *taskScheduler {
+	if conf == nil {
+		conf = &config.TaskScheduler{}
+	}
+	return &taskScheduler{
+		owner:           newOwner(),
+		locker:          locker,
+		workers:         conf.Workers,
+		providerWorkers: conf.ProviderWorkers,
+		providerRunning: make(map[string]int),
+		freed:           make(chan struct{}),
+		pollInterval:    taskPollInterval,
+	}
+}
+
+// newOwner returns the name identifying the replica holding the tasks and the cluster locks.
+func newOwner() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%s", hostname, uuidutil.NewUUID()[:8])
+}
+
+// available returns the providers whose workers are used up, and false if the workers of the replica are used up.
+func (s *taskScheduler) available() ([]string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.workers > 0 && s.running >= s.workers {
+		return nil, false
+	}
+	var excludeProviders []string
+	for provider, workers := range s.providerWorkers {
+		if s.providerRunning[provider] >= workers {
+			excludeProviders = append(excludeProviders, provider)
+		}
+	}
+	return excludeProviders, true
+}
+
+// tryAcquire takes a worker if the workers of the replica and of provider are not used up.
+func (s *taskScheduler) tryAcquire(provider string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.workers > 0 && s.running >= s.workers {
+		return false
+	}
+	if workers, ok := s.providerWorkers[provider]; ok && s.providerRunning[provider] >= workers {
+		return false
+	}
+	s.running++
+	s.providerRunning[provider]++
+	return true
+}
+
+func (s *taskScheduler) acquire(provider string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running++
+	s.providerRunning[provider]++
+}
+
+func (s *taskScheduler) release(provider string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running--
+	s.providerRunning[provider]--
+	close(s.freed)
+	s.freed = make(chan struct{})
+}
+
+func (s *taskScheduler) freedChan() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.freed
+}
+
+// schedule waits until the task of payload can run, it takes a worker and the lock of the cluster changed by the task.
+// It returns false if stop is closed or wait returns false before the task runs. wait is called in each poll
+// while the task waits, such as to touch its message, and to give up the task cancelled.
+func (s *taskScheduler) schedule(stop <-chan struct{}, payload types.TaskPayload, wait func() bool) (bool, error) {
+	provider, clusterID := payload.Target()
+	exclusive := s.locker != nil && clusterID != "" && payload.Mutating()
+	poll := time.NewTicker(s.pollInterval)
+	defer poll.Stop()
+	for {
+		freed := s.freedChan()
+		if s.tryAcquire(provider) {
+			if !exclusive {
+				return true, nil
+			}
+			locked, err := s.locker.LockCluster(clusterID, payload.GetTaskID(), s.owner)
+			if locked {
+				return true, nil
+			}
+			s.release(provider)
+			if err != nil {
+				return false, err
+			}
+			// another task is changing the cluster
+		}
+		select {
+		case <-stop:
+			return false, nil
+		case <-freed:
+		case <-poll.C:
+			// the cluster locks are released by the other replicas
+			if wait != nil && !wait() {
+				return false, nil
+			}
+		}
+	}
+}
+
+// done releases the worker and the lock of cluster taken by schedule.
+func (s *taskScheduler) done(payload types.TaskPayload) error {
+	provider, _ := payload.Target()
+	s.release(provider)
+	return s.unlock(payload)
+}
+
+// unlock releases the lock of cluster held by the task, such as the lock left by the replica exited.
+func (s *taskScheduler) unlock(payload types.TaskPayload) error {
+	if s.locker == nil {
+		return nil
+	}
+	return s.locker.UnlockCluster(payload.GetTaskID())
+}
+
+// memoryClusterLocker locks the clusters in one replica, for the tasks that are lost once the adaptor exits.
+type memoryClusterLocker struct {
+	mu    sync.Mutex
+	locks map[string]string
+}
+
+func newMemoryClusterLocker() *memoryClusterLocker {
+	return &memoryClusterLocker{locks: make(map[string]string)}
+}
+
+// LockCluster -
+func (m *memoryClusterLocker) LockCluster(clusterID, taskID, owner string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if holder, ok := m.locks[clusterID]; ok && holder != taskID {
+		return false, nil
+	}
+	m.locks[clusterID] = taskID
+	return true, nil
+}
+
+// UnlockCluster -
+func (m *memoryClusterLocker) UnlockCluster(taskID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for clusterID, holder := range m.locks {
+		if holder == taskID {
+			delete(m.locks, clusterID)
+		}
+	}
+	return nil
+}
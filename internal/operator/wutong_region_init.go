@@ -31,11 +31,11 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
 	"github.com/wutong-paas/cloud-adaptor/internal/repo"
+	"github.com/wutong-paas/cloud-adaptor/pkg/util/retryutil"
 	"github.com/wutong-paas/cloud-adaptor/version"
 	wutongv1alpha1 "github.com/wutong-paas/wutong-operator/api/v1alpha1"
 	"github.com/wutong-paas/wutong-operator/util/commonutil"
 	"github.com/wutong-paas/wutong-operator/util/constants"
-	"github.com/wutong-paas/wutong-operator/util/retryutil"
 	"github.com/wutong-paas/wutong-operator/util/suffixdomain"
 	"github.com/wutong-paas/wutong-operator/util/wtutil"
 	"gorm.io/gorm"
@@ -52,6 +52,10 @@ import (
 
 var helmPath = "/usr/local/bin/helm"
 
+// suffixHostPolicy the policy of generating the suffix http host, the errors of the suffix domain service are retried as well
+var suffixHostPolicy = retryutil.Policy{MaxAttempts: 3, InitialInterval: time.Second, MaxInterval: 4 * time.Second, Multiplier: 2,
+	Jitter: 0.2, Retryable: retryutil.Always}
+
 func init() {
 	if os.Getenv("HELM_PATH") != "" {
 		helmPath = os.Getenv("HELM_PATH")
@@ -64,6 +68,7 @@ type WutongRegionInit struct {
 	namespace               string
 	wutongClusterConfigRepo repo.WutongClusterConfigRepository
 	wutongCluster           *wutongv1alpha1.WutongCluster
+	retryNotify             retryutil.Notify
 }
 
 // NewWutongRegionInit new
@@ -103,6 +108,11 @@ func NewWutongRegionInit(kubeconfig v1alpha1.KubeConfig, wutongClusterConfigRepo
 	return res
 }
 
+// SetRetryNotify sets the notify of the retries of kubernetes API calls, so that the retries can be reported as task events.
+func (r *WutongRegionInit) SetRetryNotify(notify retryutil.Notify) {
+	r.retryNotify = notify
+}
+
 // InitWutongRegion init wutong region
 func (r *WutongRegionInit) InitWutongRegion(initConfig *v1alpha1.WutongInitConfig) error {
 	clusterID := initConfig.ClusterID
@@ -120,16 +130,16 @@ func (r *WutongRegionInit) InitWutongRegion(initConfig *v1alpha1.WutongInitConfi
 	}
 	cn := &v1.Namespace{}
 	cn.Name = r.namespace
-	if err := func() error {
+	if err := retryutil.KubeAPIPolicy.Do(context.Background(), r.retryNotify, func() error {
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
 		defer cancel()
-		_, err = client.CoreV1().Namespaces().Create(ctx, cn, metav1.CreateOptions{})
+		_, err := client.CoreV1().Namespaces().Create(ctx, cn, metav1.CreateOptions{})
 		if err != nil && !k8sErrors.IsAlreadyExists(err) {
-			return fmt.Errorf("create namespace failure %s", err.Error())
+			return err
 		}
 		return nil
-	}(); err != nil {
-		return err
+	}); err != nil {
+		return fmt.Errorf("create namespace failure %s", err.Error())
 	}
 
 	// helm create wutong operator chart
@@ -259,6 +269,19 @@ func (r *WutongRegionInit) createWutongCR(kubeClient *kubernetes.Clientset, clie
 			},
 		}
 	}
+	if initConfig.NFSServer != "" {
+		cluster.Spec.WutongVolumeSpecRWX = &wutongv1alpha1.WutongVolumeSpec{
+			CSIPlugin: &wutongv1alpha1.CSIPluginSource{
+				NFS: &wutongv1alpha1.NFSCSIPluginSource{},
+			},
+			StorageClassParameters: &wutongv1alpha1.StorageClassParameters{
+				Parameters: map[string]string{
+					"server": initConfig.NFSServer,
+					"path":   "/",
+				},
+			},
+		}
+	}
 	// handle volume spec
 	if cluster.Spec.WutongVolumeSpecRWX != nil {
 		if cluster.Spec.WutongVolumeSpecRWX.CSIPlugin != nil {
@@ -299,14 +322,12 @@ func (r *WutongRegionInit) createWutongCR(kubeClient *kubernetes.Clientset, clie
 			ip = initConfig.EIPs[0]
 		}
 		if ip != "" {
-			err := retryutil.Retry(1*time.Second, 3, func() (bool, error) {
-				domain, err := r.genSuffixHTTPHost(kubeClient, ip)
-				if err != nil {
-					return false, err
-				}
-				cluster.Spec.SuffixHTTPHost = domain
-				return true, nil
+			domain, err := retryutil.Call(context.Background(), suffixHostPolicy, r.retryNotify, func() (string, error) {
+				return r.genSuffixHTTPHost(kubeClient, ip)
 			})
+			if err == nil {
+				cluster.Spec.SuffixHTTPHost = domain
+			}
 			if err != nil {
 				logrus.Warningf("generate suffix http host: %v", err)
 				cluster.Spec.SuffixHTTPHost = constants.DefHTTPDomainSuffix
@@ -22,6 +22,7 @@ import (
 	"fmt"
 
 	"github.com/pkg/errors"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
 	"github.com/wutong-paas/cloud-adaptor/internal/model"
 	"github.com/wutong-paas/cloud-adaptor/pkg/bcode"
 	"github.com/wutong-paas/cloud-adaptor/pkg/util/uuidutil"
@@ -95,7 +96,7 @@ func (c *InitWutongRegionTaskRepo) GetTask(taskID string) (*model.InitWutongTask
 // GetTaskRunningLists get not complete tasks
 func (c *InitWutongRegionTaskRepo) GetTaskRunningLists() ([]*model.InitWutongTask, error) {
 	var list []*model.InitWutongTask
-	if err := c.DB.Where("status != ?", "complete").Find(&list).Error; err != nil {
+	if err := c.DB.Where("status not in ?", domain.TerminalTaskStatusValues()).Find(&list).Error; err != nil {
 		return nil, err
 	}
 	return list, nil
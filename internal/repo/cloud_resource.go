@@ -0,0 +1,105 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package repo
+
+import (
+	"github.com/pkg/errors"
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+	"gorm.io/gorm"
+)
+
+// CloudResourceRepo -
+type CloudResourceRepo struct {
+	DB *gorm.DB `inject:""`
+}
+
+// NewCloudResourceRepo -
+func NewCloudResourceRepo(db *gorm.DB) CloudResourceRepository {
+	return &CloudResourceRepo{DB: db}
+}
+
+// Transaction -
+func (c *CloudResourceRepo) Transaction(tx *gorm.DB) CloudResourceRepository {
+	return &CloudResourceRepo{DB: tx}
+}
+
+// Create create a cloud resource record.
+// The adaptor may record the same resource again when a task is retried, the record is updated in that case.
+func (c *CloudResourceRepo) Create(ent *model.CloudResource) error {
+	if len(ent.Message) > 512 {
+		ent.Message = ent.Message[:512]
+	}
+	if ent.State == "" {
+		ent.State = model.CloudResourceStateCreated
+	}
+	var old model.CloudResource
+	if err := c.DB.Where("type=? and cloud_id=? and spec=?", ent.Type, ent.CloudID, ent.Spec).Take(&old).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return errors.Wrap(c.DB.Save(ent).Error, "create cloud resource")
+		}
+		return errors.Wrap(err, "get cloud resource")
+	}
+	old.TaskID = ent.TaskID
+	old.Provider = ent.Provider
+	old.ClusterID = ent.ClusterID
+	old.RegionID = ent.RegionID
+	old.ParentID = ent.ParentID
+	old.State = ent.State
+	old.Message = ent.Message
+	*ent = old
+	return errors.Wrap(c.DB.Save(ent).Error, "update cloud resource")
+}
+
+// ListByClusterID list the cloud resources of the cluster
+func (c *CloudResourceRepo) ListByClusterID(clusterID string) ([]*model.CloudResource, error) {
+	var list []*model.CloudResource
+	if err := c.DB.Where("cluster_id=?", clusterID).Order("id").Find(&list).Error; err != nil {
+		return nil, errors.Wrap(err, "list cloud resources")
+	}
+	return list, nil
+}
+
+// ListByState list the cloud resources in the given states
+func (c *CloudResourceRepo) ListByState(states ...string) ([]*model.CloudResource, error) {
+	var list []*model.CloudResource
+	if err := c.DB.Where("state in (?)", states).Order("id").Find(&list).Error; err != nil {
+		return nil, errors.Wrap(err, "list cloud resources")
+	}
+	return list, nil
+}
+
+// UpdateState update the state of cloud resource
+func (c *CloudResourceRepo) UpdateState(id uint, state, message string) error {
+	if len(message) > 512 {
+		message = message[:512]
+	}
+	err := c.DB.Model(&model.CloudResource{}).Where("id=?", id).
+		Updates(map[string]interface{}{"state": state, "message": message}).Error
+	return errors.Wrap(err, "update cloud resource state")
+}
+
+// UpdateStateByClusterID update the state of the cloud resources of the cluster, all types if types is empty
+func (c *CloudResourceRepo) UpdateStateByClusterID(clusterID, state string, types ...string) error {
+	db := c.DB.Model(&model.CloudResource{}).Where("cluster_id=? and state<>?", clusterID, model.CloudResourceStateReleased)
+	if len(types) > 0 {
+		db = db.Where("type in (?)", types)
+	}
+	err := db.Update("state", state).Error
+	return errors.Wrap(err, "update cloud resource state")
+}
@@ -19,6 +19,8 @@
 package repo
 
 import (
+	"time"
+
 	"github.com/pkg/errors"
 	"github.com/wutong-paas/cloud-adaptor/internal/model"
 	"github.com/wutong-paas/cloud-adaptor/pkg/util/uuidutil"
@@ -64,7 +66,10 @@ func (t *TaskEventRepo) Create(te *model.TaskEvent) error {
 		old.Message = te.Message
 		old.Status = te.Status
 		old.Reason = te.Reason
-		return t.DB.Save(&old).Error
+		if err := t.DB.Save(&old).Error; err != nil {
+			return err
+		}
+		*te = old
 	}
 	return nil
 }
@@ -78,6 +83,15 @@ func (t *TaskEventRepo) ListEvent(taskID string) ([]*model.TaskEvent, error) {
 	return list, nil
 }
 
+// ListEventSince list the events of task updated after the given time
+func (t *TaskEventRepo) ListEventSince(taskID string, since time.Time) ([]*model.TaskEvent, error) {
+	var list []*model.TaskEvent
+	if err := t.DB.Where("task_id=? and updated_at>?", taskID, since).Order("updated_at").Find(&list).Error; err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return list, nil
+}
+
 // UpdateStatusInBatch -
 func (t *TaskEventRepo) UpdateStatusInBatch(eventIDs []string, status string) error {
 	if err := t.DB.Where("event_id in (?)", eventIDs).Updates(&model.TaskEvent{Status: status}).Error; err != nil {
@@ -0,0 +1,150 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package repo
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wutong-paas/cloud-adaptor/cmd/cloud-adaptor/config"
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+	"github.com/wutong-paas/cloud-adaptor/pkg/util/cryptoutil"
+	"gorm.io/gorm"
+)
+
+var (
+	encryptKey     []byte
+	encryptKeyErr  error
+	encryptKeyOnce sync.Once
+)
+
+// getEncryptKey returns the key to encrypt the secrets saved in the database, it is derived from --encrypt-key.
+// The key is required by the db task backend, because the queued tasks are taken over by the other replicas, and by
+// the db rke state store, because the states must not depend on a key kept next to them.
+// Otherwise a random key is generated in CONFIG_DIR if it is not set, the CONFIG_DIR must be shared by all replicas then.
+func getEncryptKey() ([]byte, error) {
+	encryptKeyOnce.Do(func() {
+		if config.C != nil && config.C.EncryptKey != "" {
+			encryptKey = cryptoutil.DeriveKey(config.C.EncryptKey)
+			return
+		}
+		if config.C != nil && config.C.UseDBTaskBackend() {
+			encryptKeyErr = config.ErrEncryptKeyRequired
+			return
+		}
+		if config.C != nil && config.C.UseDBRKEStateStore() {
+			encryptKeyErr = config.ErrRKEStateEncryptKeyRequired
+			return
+		}
+		configDir := os.Getenv("CONFIG_DIR")
+		if configDir == "" {
+			configDir = "/tmp"
+		}
+		keyPath := path.Join(configDir, "encrypt.key")
+		secret, err := ioutil.ReadFile(keyPath)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				encryptKeyErr = fmt.Errorf("read encrypt key file failure %s", err.Error())
+				return
+			}
+			random := make([]byte, 32)
+			if _, err := rand.Read(random); err != nil {
+				encryptKeyErr = err
+				return
+			}
+			secret = []byte(hex.EncodeToString(random))
+			if err := ioutil.WriteFile(keyPath, secret, 0600); err != nil {
+				encryptKeyErr = fmt.Errorf("write encrypt key file failure %s", err.Error())
+				return
+			}
+			logrus.Warningf("encrypt key is not set, generate one in %s", keyPath)
+		}
+		encryptKey = cryptoutil.DeriveKey(strings.TrimSpace(string(secret)))
+	})
+	return encryptKey, encryptKeyErr
+}
+
+// ClusterSSHKeyRepo -
+type ClusterSSHKeyRepo struct {
+	DB *gorm.DB `inject:""`
+}
+
+// NewClusterSSHKeyRepo creates a new ClusterSSHKeyRepository.
+func NewClusterSSHKeyRepo(db *gorm.DB) ClusterSSHKeyRepository {
+	return &ClusterSSHKeyRepo{DB: db}
+}
+
+// Create save the key with the private key encrypted, the key passed in is not changed.
+func (t *ClusterSSHKeyRepo) Create(key *model.ClusterSSHKey) error {
+	encryptKey, err := getEncryptKey()
+	if err != nil {
+		return err
+	}
+	privateKey, err := cryptoutil.Encrypt(encryptKey, key.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("encrypt private key failure %s", err.Error())
+	}
+	ent := *key
+	ent.PrivateKey = privateKey
+	if err := t.DB.Create(&ent).Error; err != nil {
+		return err
+	}
+	key.Model = ent.Model
+	return nil
+}
+
+// UpdateStatus -
+func (t *ClusterSSHKeyRepo) UpdateStatus(id uint, status string) error {
+	return t.DB.Model(&model.ClusterSSHKey{}).Where("id=?", id).Update("status", status).Error
+}
+
+// GetKey get the latest key of cluster in the status, the private key is decrypted.
+func (t *ClusterSSHKeyRepo) GetKey(clusterID, status string) (*model.ClusterSSHKey, error) {
+	var key model.ClusterSSHKey
+	if err := t.DB.Where("clusterID=? and status=?", clusterID, status).Order("id desc").Take(&key).Error; err != nil {
+		return nil, err
+	}
+	encryptKey, err := getEncryptKey()
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := cryptoutil.Decrypt(encryptKey, key.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt private key failure %s", err.Error())
+	}
+	key.PrivateKey = privateKey
+	return &key, nil
+}
+
+// Delete -
+func (t *ClusterSSHKeyRepo) Delete(id uint) error {
+	return t.DB.Where("id=?", id).Delete(&model.ClusterSSHKey{}).Error
+}
+
+// DeleteKeys delete all keys of cluster
+func (t *ClusterSSHKeyRepo) DeleteKeys(clusterID string) error {
+	return t.DB.Where("clusterID=?", clusterID).Delete(&model.ClusterSSHKey{}).Error
+}
@@ -30,6 +30,11 @@ var ProviderSet = wire.NewSet(
 	NewInitWutongRegionTaskRepo,
 	NewUpdateKubernetesTaskRepo,
 	NewTaskEventRepo,
+	NewTaskQueueRepo,
+	NewTaskRepo,
+	NewCloudResourceRepo,
+	NewWebhookRepo,
+	NewWebhookDeliveryRepo,
 	NewWutongClusterConfigRepo,
 	NewAppStoreRepo,
 	NewRKEClusterRepo,
@@ -19,6 +19,8 @@
 package repo
 
 import (
+	"time"
+
 	"github.com/wutong-paas/cloud-adaptor/internal/model"
 	"gorm.io/gorm"
 )
@@ -66,9 +68,70 @@ type TaskEventRepository interface {
 	Transaction(tx *gorm.DB) TaskEventRepository
 	Create(ent *model.TaskEvent) error
 	ListEvent(taskID string) ([]*model.TaskEvent, error)
+	// ListEventSince list the events of task updated after the given time
+	ListEventSince(taskID string, since time.Time) ([]*model.TaskEvent, error)
 	UpdateStatusInBatch(eventIDs []string, status string) error
 }
 
+// TaskQueueRepository the durable queue of the task messages, the payloads are encrypted when saved
+// and decrypted when read.
+type TaskQueueRepository interface {
+	Enqueue(task *model.QueuedTask) error
+	Claim(owner string, lease time.Duration, excludeProviders ...string) (*model.QueuedTask, error)
+	Heartbeat(taskID, owner string, lease time.Duration) error
+	Finish(taskID, owner, status string) error
+	ListExpired() (expired, undecryptable []*model.QueuedTask, err error)
+	Recover(task *model.QueuedTask, status string) (bool, error)
+	Cancel(taskID string) (*model.QueuedTask, error)
+	Get(taskID string) (*model.QueuedTask, error)
+	Position(task *model.QueuedTask) (int64, error)
+	LockCluster(clusterID, taskID, owner string) (bool, error)
+	UnlockCluster(taskID string) error
+}
+
+// TaskRepository the tasks of the kinds registered by task definitions
+type TaskRepository interface {
+	Transaction(tx *gorm.DB) TaskRepository
+	Create(ent *model.Task) error
+	GetTask(taskID string) (*model.Task, error)
+	GetLastTask(clusterID string) (*model.Task, error)
+	ListUnfinished(clusterID string, finishedStatus []string) ([]*model.Task, error)
+	UpdateStatus(taskID string, status string) error
+}
+
+// CloudResourceRepository cloud resources created by adaptor
+type CloudResourceRepository interface {
+	Transaction(tx *gorm.DB) CloudResourceRepository
+	Create(ent *model.CloudResource) error
+	ListByClusterID(clusterID string) ([]*model.CloudResource, error)
+	ListByState(states ...string) ([]*model.CloudResource, error)
+	UpdateState(id uint, state, message string) error
+	UpdateStateByClusterID(clusterID, state string, types ...string) error
+}
+
+// WebhookRepository the webhooks subscribing the task events, the secrets are decrypted when they are read.
+type WebhookRepository interface {
+	Create(ent *model.Webhook) error
+	Update(ent *model.Webhook) error
+	Get(webhookID string) (*model.Webhook, error)
+	List() ([]*model.Webhook, error)
+	ListEnabled() ([]*model.Webhook, error)
+	Delete(webhookID string) error
+}
+
+// WebhookDeliveryRepository the delivery logs of webhooks
+type WebhookDeliveryRepository interface {
+	Create(ent *model.WebhookDelivery) error
+	Update(ent *model.WebhookDelivery) error
+	// List list the latest deliveries of the webhook
+	List(webhookID string, limit int) ([]*model.WebhookDelivery, error)
+	DeleteByWebhookID(webhookID string) error
+	// ListDue list the pending deliveries whose next attempts are due
+	ListDue(limit int) ([]*model.WebhookDelivery, error)
+	// Claim push back the next attempt of the due delivery to until, it returns false if the delivery is claimed by others.
+	Claim(delivery *model.WebhookDelivery, until time.Time) (bool, error)
+}
+
 // WutongClusterConfigRepository -
 type WutongClusterConfigRepository interface {
 	Create(ent *model.WutongClusterConfig) error
@@ -84,6 +147,33 @@ type RKEClusterRepository interface {
 	DeleteCluster(name string) error
 }
 
+// RKEClusterStateRepository the versioned rke states of clusters
+type RKEClusterStateRepository interface {
+	Create(state *model.RKEClusterState) error
+	GetState(clusterName string, version int) (*model.RKEClusterState, error)
+	GetLatestState(clusterName string) (*model.RKEClusterState, error)
+	ListStates(clusterName string) ([]*model.RKEClusterState, error)
+	DeleteStates(clusterName string) error
+}
+
+// ClusterSSHKeyRepository the ssh key pairs of clusters, the private keys are encrypted when saved
+// and decrypted when read.
+type ClusterSSHKeyRepository interface {
+	Create(key *model.ClusterSSHKey) error
+	UpdateStatus(id uint, status string) error
+	GetKey(clusterID, status string) (*model.ClusterSSHKey, error)
+	Delete(id uint) error
+	DeleteKeys(clusterID string) error
+}
+
+// TKEClusterRepository the regions of the tke clusters
+type TKEClusterRepository interface {
+	Create(cluster *model.TKECluster) error
+	GetCluster(clusterID string) (*model.TKECluster, error)
+	ListCluster() ([]*model.TKECluster, error)
+	DeleteCluster(clusterID string) error
+}
+
 // CustomClusterRepository -
 type CustomClusterRepository interface {
 	Create(cluster *model.CustomCluster) error
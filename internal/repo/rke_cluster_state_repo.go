@@ -0,0 +1,121 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package repo
+
+import (
+	"fmt"
+
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+	"github.com/wutong-paas/cloud-adaptor/pkg/util/cryptoutil"
+	"gorm.io/gorm"
+)
+
+// RKEClusterStateRepo -
+type RKEClusterStateRepo struct {
+	DB *gorm.DB `inject:""`
+}
+
+// NewRKEClusterStateRepo creates a new RKEClusterStateRepository.
+func NewRKEClusterStateRepo(db *gorm.DB) RKEClusterStateRepository {
+	return &RKEClusterStateRepo{DB: db}
+}
+
+// Create save the state as the next version of the cluster, the version is set to the state.
+// The config and the state are encrypted, they contain the certificates and the keys of cluster.
+func (t *RKEClusterStateRepo) Create(state *model.RKEClusterState) error {
+	encryptKey, err := getEncryptKey()
+	if err != nil {
+		return err
+	}
+	ent := *state
+	if ent.Config, err = cryptoutil.Encrypt(encryptKey, state.Config); err != nil {
+		return fmt.Errorf("encrypt cluster config failure %s", err.Error())
+	}
+	if ent.State, err = cryptoutil.Encrypt(encryptKey, state.State); err != nil {
+		return fmt.Errorf("encrypt cluster state failure %s", err.Error())
+	}
+	// the version is unique for each cluster, retry when another replica takes the same version.
+	for retry := 0; retry < 3; retry++ {
+		err = t.DB.Transaction(func(tx *gorm.DB) error {
+			var latest int
+			if err := tx.Model(&model.RKEClusterState{}).Where("clusterName=?", ent.ClusterName).
+				Select("COALESCE(MAX(version), 0)").Scan(&latest).Error; err != nil {
+				return err
+			}
+			ent.ID = 0
+			ent.Version = latest + 1
+			return tx.Create(&ent).Error
+		})
+		if err == nil {
+			state.Model, state.Version = ent.Model, ent.Version
+			return nil
+		}
+		if !isDuplicateEntry(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// GetState get the version of the state of cluster, the config and the state are decrypted.
+func (t *RKEClusterStateRepo) GetState(clusterName string, version int) (*model.RKEClusterState, error) {
+	var state model.RKEClusterState
+	if err := t.DB.Where("clusterName=? and version=?", clusterName, version).Take(&state).Error; err != nil {
+		return nil, err
+	}
+	return decryptState(&state)
+}
+
+// GetLatestState get the latest state of cluster, the config and the state are decrypted.
+func (t *RKEClusterStateRepo) GetLatestState(clusterName string) (*model.RKEClusterState, error) {
+	var state model.RKEClusterState
+	if err := t.DB.Where("clusterName=?", clusterName).Order("version desc").Take(&state).Error; err != nil {
+		return nil, err
+	}
+	return decryptState(&state)
+}
+
+func decryptState(state *model.RKEClusterState) (*model.RKEClusterState, error) {
+	encryptKey, err := getEncryptKey()
+	if err != nil {
+		return nil, err
+	}
+	if state.Config, err = cryptoutil.Decrypt(encryptKey, state.Config); err != nil {
+		return nil, fmt.Errorf("decrypt config of cluster %s failure %s", state.ClusterName, err.Error())
+	}
+	if state.State, err = cryptoutil.Decrypt(encryptKey, state.State); err != nil {
+		return nil, fmt.Errorf("decrypt state of cluster %s failure %s", state.ClusterName, err.Error())
+	}
+	return state, nil
+}
+
+// ListStates list the states of cluster without the content, the latest first.
+func (t *RKEClusterStateRepo) ListStates(clusterName string) ([]*model.RKEClusterState, error) {
+	var list []*model.RKEClusterState
+	if err := t.DB.Select("id", "created_at", "updated_at", "clusterName", "version").
+		Where("clusterName=?", clusterName).Order("version desc").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// DeleteStates delete all states of cluster
+func (t *RKEClusterStateRepo) DeleteStates(clusterName string) error {
+	return t.DB.Where("clusterName=?", clusterName).Delete(&model.RKEClusterState{}).Error
+}
@@ -0,0 +1,345 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package repo
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+	"github.com/wutong-paas/cloud-adaptor/pkg/util/cryptoutil"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrTaskLeaseLost the lease of task is expired and taken over, or the task is finished by others
+	ErrTaskLeaseLost = errors.New("task lease lost")
+	// ErrTaskCancelRequested the task is requested to be cancelled
+	ErrTaskCancelRequested = errors.New("task cancel requested")
+
+	errTaskClaimed = errors.New("task claimed by others")
+)
+
+// TaskQueueRepo -
+type TaskQueueRepo struct {
+	DB *gorm.DB `inject:""`
+}
+
+// NewTaskQueueRepo -
+func NewTaskQueueRepo(db *gorm.DB) TaskQueueRepository {
+	return &TaskQueueRepo{DB: db}
+}
+
+// Enqueue add a pending task to the queue
+func (t *TaskQueueRepo) Enqueue(task *model.QueuedTask) error {
+	payload, err := encryptPayload(task.Payload)
+	if err != nil {
+		return err
+	}
+	ent := *task
+	ent.Payload = payload
+	ent.Status = model.QueuedTaskPending
+	if err := t.DB.Create(&ent).Error; err != nil {
+		return errors.Wrap(err, "enqueue task")
+	}
+	task.ID, task.Status = ent.ID, ent.Status
+	return nil
+}
+
+// claimBatch the number of the pending tasks tried in one claim
+const claimBatch = 20
+
+// Claim claim the oldest pending task with a lease, skipping the tasks of the excluded providers and the
+// exclusive tasks of the locked clusters. The exclusive task takes the lock of its cluster along with the claim.
+// It returns nil if there is no task to claim.
+func (t *TaskQueueRepo) Claim(owner string, lease time.Duration, excludeProviders ...string) (*model.QueuedTask, error) {
+	query := t.DB.Where("status=?", model.QueuedTaskPending)
+	if len(excludeProviders) > 0 {
+		query = query.Where("provider_name not in ?", excludeProviders)
+	}
+	var tasks []*model.QueuedTask
+	if err := query.Order("id").Limit(claimBatch).Find(&tasks).Error; err != nil {
+		return nil, errors.Wrap(err, "list pending tasks")
+	}
+	for _, task := range tasks {
+		claimed, err := t.claim(task, owner, lease)
+		if err != nil {
+			return nil, err
+		}
+		if !claimed {
+			continue
+		}
+		if err := decryptPayload(task); err != nil {
+			return nil, err
+		}
+		return task, nil
+	}
+	return nil, nil
+}
+
+func (t *TaskQueueRepo) claim(task *model.QueuedTask, owner string, lease time.Duration) (bool, error) {
+	expiresAt := time.Now().Add(lease)
+	var claimed bool
+	err := t.DB.Transaction(func(tx *gorm.DB) error {
+		if task.Exclusive {
+			lock := &model.ClusterLock{ClusterID: task.ClusterID, TaskID: task.TaskID, Owner: owner}
+			if err := tx.Create(lock).Error; err != nil {
+				if isDuplicateEntry(err) {
+					// another task of the cluster is running
+					return nil
+				}
+				return errors.Wrap(err, "lock cluster")
+			}
+		}
+		res := tx.Model(&model.QueuedTask{}).Where("id=? and status=?", task.ID, model.QueuedTaskPending).Updates(map[string]interface{}{
+			"status":           model.QueuedTaskRunning,
+			"owner":            owner,
+			"lease_expires_at": expiresAt,
+			"attempts":         gorm.Expr("attempts + 1"),
+		})
+		if res.Error != nil {
+			return errors.Wrap(res.Error, "claim task")
+		}
+		if res.RowsAffected == 0 {
+			// claimed by others at the same time, rollback the lock
+			return errTaskClaimed
+		}
+		claimed = true
+		return nil
+	})
+	if err != nil && err != errTaskClaimed {
+		return false, err
+	}
+	if !claimed {
+		return false, nil
+	}
+	task.Status, task.Owner, task.LeaseExpiresAt = model.QueuedTaskRunning, owner, &expiresAt
+	task.Attempts++
+	return true, nil
+}
+
+// Heartbeat renew the lease of the running task, it returns ErrTaskLeaseLost if the task is not held by owner,
+// or ErrTaskCancelRequested if the task is requested to be cancelled, the lease is renewed still.
+func (t *TaskQueueRepo) Heartbeat(taskID, owner string, lease time.Duration) error {
+	res := t.DB.Model(&model.QueuedTask{}).Where("task_id=? and owner=? and status=?", taskID, owner, model.QueuedTaskRunning).
+		Update("lease_expires_at", time.Now().Add(lease))
+	if res.Error != nil {
+		return errors.Wrap(res.Error, "renew task lease")
+	}
+	if res.RowsAffected == 0 {
+		return ErrTaskLeaseLost
+	}
+	var task model.QueuedTask
+	if err := t.DB.Select("cancel_requested").Where("task_id=?", taskID).Take(&task).Error; err != nil {
+		return errors.Wrap(err, "get task")
+	}
+	if task.CancelRequested {
+		return ErrTaskCancelRequested
+	}
+	return nil
+}
+
+// Finish set the status of the running task held by owner
+func (t *TaskQueueRepo) Finish(taskID, owner, status string) error {
+	res := t.DB.Model(&model.QueuedTask{}).Where("task_id=? and owner=? and status=?", taskID, owner, model.QueuedTaskRunning).
+		Updates(map[string]interface{}{"status": status, "lease_expires_at": nil})
+	if res.Error != nil {
+		return errors.Wrap(res.Error, "finish task")
+	}
+	if res.RowsAffected == 0 {
+		return ErrTaskLeaseLost
+	}
+	return t.unlockCluster(taskID)
+}
+
+// LockCluster lock the cluster for the task not in the queue, such as the tasks sent by nsq. It returns false if
+// the cluster is locked by another task, the lock held by the same task is taken over, such as the redelivered task.
+func (t *TaskQueueRepo) LockCluster(clusterID, taskID, owner string) (bool, error) {
+	lock := &model.ClusterLock{ClusterID: clusterID, TaskID: taskID, Owner: owner}
+	err := t.DB.Create(lock).Error
+	if err == nil {
+		return true, nil
+	}
+	if !isDuplicateEntry(err) {
+		return false, errors.Wrap(err, "lock cluster")
+	}
+	var held model.ClusterLock
+	if err := t.DB.Where("cluster_id=?", clusterID).Take(&held).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			// unlocked just now, try it in the next poll
+			return false, nil
+		}
+		return false, errors.Wrap(err, "get cluster lock")
+	}
+	if held.TaskID != taskID {
+		return false, nil
+	}
+	if err := t.DB.Model(&held).Update("owner", owner).Error; err != nil {
+		return false, errors.Wrap(err, "take over cluster lock")
+	}
+	return true, nil
+}
+
+// UnlockCluster release the lock of cluster held by the task
+func (t *TaskQueueRepo) UnlockCluster(taskID string) error {
+	return t.unlockCluster(taskID)
+}
+
+func (t *TaskQueueRepo) unlockCluster(taskID string) error {
+	if err := t.DB.Where("task_id=?", taskID).Delete(&model.ClusterLock{}).Error; err != nil {
+		return errors.Wrap(err, "unlock cluster")
+	}
+	return nil
+}
+
+// ListExpired list the running tasks whose lease is expired. The tasks whose payloads can not be decrypted are
+// returned in undecryptable with the payloads cleared, instead of failing the others.
+func (t *TaskQueueRepo) ListExpired() (expired, undecryptable []*model.QueuedTask, err error) {
+	var tasks []*model.QueuedTask
+	if err := t.DB.Where("status=? and lease_expires_at<?", model.QueuedTaskRunning, time.Now()).Order("id").Find(&tasks).Error; err != nil {
+		return nil, nil, errors.Wrap(err, "list expired tasks")
+	}
+	for _, task := range tasks {
+		if err := decryptPayload(task); err != nil {
+			logrus.Warningf("skip expired task %s: %s", task.TaskID, err.Error())
+			task.Payload = ""
+			undecryptable = append(undecryptable, task)
+			continue
+		}
+		expired = append(expired, task)
+	}
+	return expired, undecryptable, nil
+}
+
+// Recover set the status and the payload of the expired task, it returns false if the task is recovered
+// by others or its lease is renewed.
+func (t *TaskQueueRepo) Recover(task *model.QueuedTask, status string) (bool, error) {
+	payload, err := encryptPayload(task.Payload)
+	if err != nil {
+		return false, err
+	}
+	res := t.DB.Model(&model.QueuedTask{}).
+		Where("task_id=? and owner=? and status=? and lease_expires_at<?", task.TaskID, task.Owner, model.QueuedTaskRunning, time.Now()).
+		Updates(map[string]interface{}{"status": status, "owner": "", "lease_expires_at": nil, "payload": payload})
+	if res.Error != nil {
+		return false, errors.Wrap(res.Error, "recover task")
+	}
+	if res.RowsAffected == 0 {
+		return false, nil
+	}
+	return true, t.unlockCluster(task.TaskID)
+}
+
+// Get get the task in the queue without the payload, it returns nil if the task is not in the queue.
+func (t *TaskQueueRepo) Get(taskID string) (*model.QueuedTask, error) {
+	var task model.QueuedTask
+	if err := t.DB.Omit("payload").Where("task_id=?", taskID).Take(&task).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "get task")
+	}
+	return &task, nil
+}
+
+// Position returns the position of the pending task among the tasks it waits behind, starting from 1. They are the
+// older pending tasks of the same provider that can be claimed, and the older tasks changing the same cluster if the
+// task changes it. The tasks of the other providers and the tasks waiting for the locked clusters are not counted.
+func (t *TaskQueueRepo) Position(task *model.QueuedTask) (int64, error) {
+	lockedClusters := t.DB.Model(&model.ClusterLock{}).Select("cluster_id")
+	waitBehind := t.DB.Where("provider_name=? and (exclusive=? or cluster_id not in (?))", task.ProviderName, false, lockedClusters)
+	if task.Exclusive {
+		waitBehind = waitBehind.Or("cluster_id=? and exclusive=?", task.ClusterID, true)
+	}
+	var count int64
+	if err := t.DB.Model(&model.QueuedTask{}).Where("status=? and id<?", model.QueuedTaskPending, task.ID).
+		Where(waitBehind).Count(&count).Error; err != nil {
+		return 0, errors.Wrap(err, "count pending tasks")
+	}
+	return count + 1, nil
+}
+
+// Cancel cancel the pending task, or request the worker to cancel the running task. It returns nil if the task
+// is not in the queue.
+func (t *TaskQueueRepo) Cancel(taskID string) (*model.QueuedTask, error) {
+	var task model.QueuedTask
+	if err := t.DB.Select("id", "task_id", "topic", "status", "owner", "attempts", "cancel_requested").
+		Where("task_id=?", taskID).Take(&task).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "get task")
+	}
+	if task.Status == model.QueuedTaskPending {
+		res := t.DB.Model(&model.QueuedTask{}).Where("id=? and status=?", task.ID, model.QueuedTaskPending).
+			Update("status", model.QueuedTaskCancelled)
+		if res.Error != nil {
+			return nil, errors.Wrap(res.Error, "cancel task")
+		}
+		if res.RowsAffected == 1 {
+			task.Status = model.QueuedTaskCancelled
+			return &task, nil
+		}
+		// claimed at the same time
+		task.Status = model.QueuedTaskRunning
+	}
+	if task.Status == model.QueuedTaskRunning {
+		res := t.DB.Model(&model.QueuedTask{}).Where("id=? and status=?", task.ID, model.QueuedTaskRunning).
+			Update("cancel_requested", true)
+		if res.Error != nil {
+			return nil, errors.Wrap(res.Error, "request to cancel task")
+		}
+		if res.RowsAffected == 1 {
+			task.CancelRequested = true
+			return &task, nil
+		}
+		// finished at the same time
+		if err := t.DB.Select("status").Where("id=?", task.ID).Take(&task).Error; err != nil {
+			return nil, errors.Wrap(err, "get task")
+		}
+	}
+	return &task, nil
+}
+
+func encryptPayload(payload string) (string, error) {
+	encryptKey, err := getEncryptKey()
+	if err != nil {
+		return "", err
+	}
+	encrypted, err := cryptoutil.Encrypt(encryptKey, payload)
+	if err != nil {
+		return "", fmt.Errorf("encrypt task payload failure %s", err.Error())
+	}
+	return encrypted, nil
+}
+
+func decryptPayload(task *model.QueuedTask) error {
+	encryptKey, err := getEncryptKey()
+	if err != nil {
+		return err
+	}
+	payload, err := cryptoutil.Decrypt(encryptKey, task.Payload)
+	if err != nil {
+		return fmt.Errorf("decrypt payload of task %s failure %s", task.TaskID, err.Error())
+	}
+	task.Payload = payload
+	return nil
+}
@@ -0,0 +1,83 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package repo
+
+import (
+	"github.com/pkg/errors"
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+	"gorm.io/gorm"
+)
+
+// TaskRepo -
+type TaskRepo struct {
+	DB *gorm.DB `inject:""`
+}
+
+// NewTaskRepo -
+func NewTaskRepo(db *gorm.DB) TaskRepository {
+	return &TaskRepo{DB: db}
+}
+
+// Transaction -
+func (t *TaskRepo) Transaction(tx *gorm.DB) TaskRepository {
+	return &TaskRepo{DB: tx}
+}
+
+// Create create a task
+func (t *TaskRepo) Create(ent *model.Task) error {
+	if err := t.DB.Create(ent).Error; err != nil {
+		return errors.Wrap(err, "create task")
+	}
+	return nil
+}
+
+// GetTask get task
+func (t *TaskRepo) GetTask(taskID string) (*model.Task, error) {
+	var task model.Task
+	if err := t.DB.Where("task_id=?", taskID).Take(&task).Error; err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// GetLastTask get the last task of cluster
+func (t *TaskRepo) GetLastTask(clusterID string) (*model.Task, error) {
+	var task model.Task
+	if err := t.DB.Where("cluster_id=?", clusterID).Last(&task).Error; err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// ListUnfinished list the tasks of cluster not in the finished status
+func (t *TaskRepo) ListUnfinished(clusterID string, finishedStatus []string) ([]*model.Task, error) {
+	var tasks []*model.Task
+	if err := t.DB.Where("cluster_id=? and status not in ?", clusterID, finishedStatus).Find(&tasks).Error; err != nil {
+		return nil, errors.Wrap(err, "list unfinished tasks")
+	}
+	return tasks, nil
+}
+
+// UpdateStatus update status
+func (t *TaskRepo) UpdateStatus(taskID string, status string) error {
+	if err := t.DB.Model(&model.Task{}).Where("task_id=?", taskID).Update("status", status).Error; err != nil {
+		return errors.Wrap(err, "update task status")
+	}
+	return nil
+}
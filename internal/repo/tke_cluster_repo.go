@@ -0,0 +1,71 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package repo
+
+import (
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+	"gorm.io/gorm"
+)
+
+// TKEClusterRepo -
+type TKEClusterRepo struct {
+	DB *gorm.DB `inject:""`
+}
+
+// NewTKEClusterRepo creates a new TKEClusterRepository.
+func NewTKEClusterRepo(db *gorm.DB) TKEClusterRepository {
+	return &TKEClusterRepo{DB: db}
+}
+
+// Create saves the cluster, the region of the exist cluster is updated.
+func (t *TKEClusterRepo) Create(cluster *model.TKECluster) error {
+	var old model.TKECluster
+	if err := t.DB.Where("clusterID = ?", cluster.ClusterID).Take(&old).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return t.DB.Create(cluster).Error
+		}
+		return err
+	}
+	cluster.ID = old.ID
+	cluster.CreatedAt = old.CreatedAt
+	return t.DB.Save(cluster).Error
+}
+
+// GetCluster -
+func (t *TKEClusterRepo) GetCluster(clusterID string) (*model.TKECluster, error) {
+	var cluster model.TKECluster
+	if err := t.DB.Where("clusterID = ?", clusterID).Take(&cluster).Error; err != nil {
+		return nil, err
+	}
+	return &cluster, nil
+}
+
+// ListCluster -
+func (t *TKEClusterRepo) ListCluster() ([]*model.TKECluster, error) {
+	var list []*model.TKECluster
+	if err := t.DB.Order("created_at desc").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// DeleteCluster -
+func (t *TKEClusterRepo) DeleteCluster(clusterID string) error {
+	return t.DB.Where("clusterID = ?", clusterID).Delete(&model.TKECluster{}).Error
+}
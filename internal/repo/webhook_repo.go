@@ -0,0 +1,199 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package repo
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+	"github.com/wutong-paas/cloud-adaptor/pkg/bcode"
+	"github.com/wutong-paas/cloud-adaptor/pkg/util/cryptoutil"
+	"gorm.io/gorm"
+)
+
+// WebhookRepo -
+type WebhookRepo struct {
+	DB *gorm.DB `inject:""`
+}
+
+// NewWebhookRepo creates a new WebhookRepository.
+func NewWebhookRepo(db *gorm.DB) WebhookRepository {
+	return &WebhookRepo{DB: db}
+}
+
+// Create save the webhook with the secret encrypted, the webhook passed in is not changed.
+func (w *WebhookRepo) Create(ent *model.Webhook) error {
+	webhook, err := encryptWebhook(ent)
+	if err != nil {
+		return err
+	}
+	if err := w.DB.Create(webhook).Error; err != nil {
+		return errors.Wrap(err, "create webhook")
+	}
+	ent.Model = webhook.Model
+	return nil
+}
+
+// Update update the webhook with the secret encrypted, the webhook passed in is not changed.
+func (w *WebhookRepo) Update(ent *model.Webhook) error {
+	webhook, err := encryptWebhook(ent)
+	if err != nil {
+		return err
+	}
+	if err := w.DB.Save(webhook).Error; err != nil {
+		return errors.Wrap(err, "update webhook")
+	}
+	ent.Model = webhook.Model
+	return nil
+}
+
+// Get -
+func (w *WebhookRepo) Get(webhookID string) (*model.Webhook, error) {
+	var webhook model.Webhook
+	if err := w.DB.Where("webhook_id=?", webhookID).Take(&webhook).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.WithStack(bcode.ErrWebhookNotFound)
+		}
+		return nil, errors.Wrap(err, "get webhook")
+	}
+	if err := decryptWebhook(&webhook); err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// List -
+func (w *WebhookRepo) List() ([]*model.Webhook, error) {
+	return w.list(w.DB)
+}
+
+// ListEnabled list the enabled webhooks
+func (w *WebhookRepo) ListEnabled() ([]*model.Webhook, error) {
+	return w.list(w.DB.Where("enabled=?", true))
+}
+
+func (w *WebhookRepo) list(db *gorm.DB) ([]*model.Webhook, error) {
+	var list []*model.Webhook
+	if err := db.Order("id").Find(&list).Error; err != nil {
+		return nil, errors.Wrap(err, "list webhooks")
+	}
+	for _, webhook := range list {
+		if err := decryptWebhook(webhook); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// Delete -
+func (w *WebhookRepo) Delete(webhookID string) error {
+	return errors.Wrap(w.DB.Where("webhook_id=?", webhookID).Delete(&model.Webhook{}).Error, "delete webhook")
+}
+
+func encryptWebhook(ent *model.Webhook) (*model.Webhook, error) {
+	encryptKey, err := getEncryptKey()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := cryptoutil.Encrypt(encryptKey, ent.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt webhook secret failure %s", err.Error())
+	}
+	webhook := *ent
+	webhook.Secret = secret
+	return &webhook, nil
+}
+
+func decryptWebhook(webhook *model.Webhook) error {
+	encryptKey, err := getEncryptKey()
+	if err != nil {
+		return err
+	}
+	secret, err := cryptoutil.Decrypt(encryptKey, webhook.Secret)
+	if err != nil {
+		return fmt.Errorf("decrypt secret of webhook %s failure %s", webhook.WebhookID, err.Error())
+	}
+	webhook.Secret = secret
+	return nil
+}
+
+// WebhookDeliveryRepo -
+type WebhookDeliveryRepo struct {
+	DB *gorm.DB `inject:""`
+}
+
+// NewWebhookDeliveryRepo creates a new WebhookDeliveryRepository.
+func NewWebhookDeliveryRepo(db *gorm.DB) WebhookDeliveryRepository {
+	return &WebhookDeliveryRepo{DB: db}
+}
+
+// Create -
+func (w *WebhookDeliveryRepo) Create(ent *model.WebhookDelivery) error {
+	return errors.Wrap(w.DB.Create(ent).Error, "create webhook delivery")
+}
+
+// Update -
+func (w *WebhookDeliveryRepo) Update(ent *model.WebhookDelivery) error {
+	if len(ent.Error) > 512 {
+		ent.Error = ent.Error[:512]
+	}
+	return errors.Wrap(w.DB.Save(ent).Error, "update webhook delivery")
+}
+
+// List list the latest deliveries of the webhook
+func (w *WebhookDeliveryRepo) List(webhookID string, limit int) ([]*model.WebhookDelivery, error) {
+	var list []*model.WebhookDelivery
+	if err := w.DB.Where("webhook_id=?", webhookID).Order("id desc").Limit(limit).Find(&list).Error; err != nil {
+		return nil, errors.Wrap(err, "list webhook deliveries")
+	}
+	return list, nil
+}
+
+// DeleteByWebhookID -
+func (w *WebhookDeliveryRepo) DeleteByWebhookID(webhookID string) error {
+	err := w.DB.Where("webhook_id=?", webhookID).Delete(&model.WebhookDelivery{}).Error
+	return errors.Wrap(err, "delete webhook deliveries")
+}
+
+// ListDue list the pending deliveries whose next attempts are due, the oldest first
+func (w *WebhookDeliveryRepo) ListDue(limit int) ([]*model.WebhookDelivery, error) {
+	var list []*model.WebhookDelivery
+	if err := w.DB.Where("status=? and next_attempt_at<=?", model.WebhookDeliveryPending, time.Now()).
+		Order("id").Limit(limit).Find(&list).Error; err != nil {
+		return nil, errors.Wrap(err, "list due webhook deliveries")
+	}
+	return list, nil
+}
+
+// Claim push back the next attempt of the due delivery to until
+func (w *WebhookDeliveryRepo) Claim(delivery *model.WebhookDelivery, until time.Time) (bool, error) {
+	res := w.DB.Model(&model.WebhookDelivery{}).
+		Where("id=? and status=? and next_attempt_at=?", delivery.ID, model.WebhookDeliveryPending, delivery.NextAttemptAt).
+		Update("next_attempt_at", until)
+	if res.Error != nil {
+		return false, errors.Wrap(res.Error, "claim webhook delivery")
+	}
+	if res.RowsAffected == 0 {
+		return false, nil
+	}
+	delivery.NextAttemptAt = &until
+	return true, nil
+}
@@ -0,0 +1,84 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package task
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	v1 "github.com/wutong-paas/cloud-adaptor/api/cloud-adaptor/v1"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/factory"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
+)
+
+// adaptorTask runs one operation with the wutong cluster adaptor of the provider,
+// the adaptor must implement A, e.g. adaptor.UpgradeAdaptor.
+type adaptorTask[A any] struct {
+	provider  string
+	accessKey string
+	secretKey string
+	// operation the name of the operation, used in the failure message
+	operation string
+	run       func(ctx context.Context, ad A, rollback func(step domain.TaskStep, message, status string))
+	result    chan v1.Message
+}
+
+func newAdaptorTask[A any](provider, accessKey, secretKey, operation string,
+	run func(ctx context.Context, ad A, rollback func(step domain.TaskStep, message, status string))) Task {
+	return &adaptorTask[A]{
+		provider:  provider,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		operation: operation,
+		run:       run,
+		result:    make(chan v1.Message, 10),
+	}
+}
+
+func (c *adaptorTask[A]) rollback(step domain.TaskStep, message, status string) {
+	if status == "failure" {
+		logrus.Errorf("%s failure, Message: %s", step, message)
+	}
+	c.result <- v1.Message{StepType: string(step), Message: message, Status: status}
+}
+
+// Run run
+func (c *adaptorTask[A]) Run(ctx context.Context) {
+	defer c.rollback(closeStep, "", "")
+	c.rollback(domain.TaskStepInit, "", "start")
+	// create adaptor
+	ad, err := factory.GetCloudFactory().GetWutongClusterAdaptor(c.provider, c.accessKey, c.secretKey)
+	if err != nil {
+		c.rollback(domain.TaskStepInit, fmt.Sprintf("create cloud adaptor failure %s", err.Error()), "failure")
+		return
+	}
+	operationAdaptor, ok := ad.(A)
+	if !ok {
+		c.rollback(domain.TaskStepInit, fmt.Sprintf("provider %s can not support %s", c.provider, c.operation), "failure")
+		return
+	}
+	c.rollback(domain.TaskStepInit, "cloud adaptor create success", "success")
+	c.run(ctx, operationAdaptor, c.rollback)
+}
+
+// GetChan get message chan
+func (c *adaptorTask[A]) GetChan() chan v1.Message {
+	return c.result
+}
@@ -19,32 +19,72 @@
 package task
 
 import (
+	"context"
+	"errors"
+	"time"
+
 	"github.com/nsqio/go-nsq"
 	"github.com/sirupsen/logrus"
 	v1 "github.com/wutong-paas/cloud-adaptor/api/cloud-adaptor/v1"
+	"github.com/wutong-paas/cloud-adaptor/cmd/cloud-adaptor/config"
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
 	"github.com/wutong-paas/cloud-adaptor/internal/usecase"
+	"github.com/wutong-paas/cloud-adaptor/pkg/util/constants"
+	"github.com/wutong-paas/cloud-adaptor/pkg/util/retryutil"
 )
 
+// eventPublishPolicy the policy of publishing the events, the events are lost if the nsqd is unavailable
+var eventPublishPolicy = retryutil.Policy{MaxAttempts: 3, InitialInterval: 100 * time.Millisecond, MaxInterval: time.Second,
+	Multiplier: 2, Jitter: 0.2, Retryable: retryutil.Always}
+
+// TaskEventRecorder saves the events of tasks, it is implemented by usecase.ClusterUsecase.
+type TaskEventRecorder interface {
+	CreateTaskEvent(em *v1.EventMessage) (*model.TaskEvent, error)
+	RecordTaskCancelled(taskID string) error
+}
+
 //CallBackEvent callback event
 type CallBackEvent struct {
 	eventProducer  *nsq.Producer
 	TopicName      string
-	ClusterUsecase *usecase.ClusterUsecase
+	ClusterUsecase TaskEventRecorder
+}
+
+// NewCallBackEvent creates the callback of the task events. The events are published to nsq with the nsq task backend,
+// and the replica consuming them saves them, otherwise they are saved at once.
+func NewCallBackEvent(conf *config.Config, clusterUsecase *usecase.ClusterUsecase) (*CallBackEvent, error) {
+	c := &CallBackEvent{TopicName: constants.CloudTaskEvent, ClusterUsecase: clusterUsecase}
+	if conf.TaskBackend != config.TaskBackendNSQ {
+		return c, nil
+	}
+	producer, err := nsq.NewProducer(conf.NSQConfig.NsqdAddress, nsq.NewConfig())
+	if err != nil {
+		return nil, err
+	}
+	c.eventProducer = producer
+	return c, nil
 }
 
 //Event send event
 func (c *CallBackEvent) Event(e v1.EventMessage) error {
-	if err := c.eventProducer.Publish(c.TopicName, e.Body()); err != nil {
-		if err := c.eventProducer.Publish(c.TopicName, e.Body()); err != nil {
-			return err
-		}
+	if err := eventPublishPolicy.Do(context.Background(), nil, func() error {
+		return c.eventProducer.Publish(c.TopicName, e.Body())
+	}); err != nil {
+		return err
 	}
 	logrus.Infof("send a task %s event %+v", e.TaskID, e.Message)
 	return nil
 }
 
-// HandleEvent -
+// HandleEvent publishes the event to nsq if the event producer is set, the event is saved at once if it fails.
 func (c *CallBackEvent) HandleEvent(msg v1.EventMessage) error {
+	if c.eventProducer != nil {
+		err := c.Event(msg)
+		if err == nil {
+			return nil
+		}
+		logrus.Warningf("publish the event of task %s failure %s, save it at once", msg.TaskID, err.Error())
+	}
 	if _, err := c.ClusterUsecase.CreateTaskEvent(&msg); err != nil {
 		logrus.Errorf("save event message failure %s", err.Error())
 		// return err, retry
@@ -54,3 +94,15 @@ func (c *CallBackEvent) HandleEvent(msg v1.EventMessage) error {
 	}
 	return nil
 }
+
+// HandleCancelled records the task cancelled if its context is cancelled by ErrCancelled.
+func (c *CallBackEvent) HandleCancelled(ctx context.Context, taskID string) {
+	if !errors.Is(context.Cause(ctx), ErrCancelled) {
+		return
+	}
+	if err := c.ClusterUsecase.RecordTaskCancelled(taskID); err != nil {
+		logrus.Errorf("record task %s cancelled failure %s", taskID, err.Error())
+		return
+	}
+	logrus.Infof("task %s is cancelled", taskID)
+}
@@ -0,0 +1,95 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package task
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	v1 "github.com/wutong-paas/cloud-adaptor/api/cloud-adaptor/v1"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
+)
+
+// clusterTaskRunner runs the tasks operating the clusters, each task runs once.
+type clusterTaskRunner struct {
+	eventHandler *CallBackEvent
+	// handledTask the tasks running or complete, handle is called by the workers of the consumer concurrently
+	handledTask sync.Map
+}
+
+func newClusterTaskRunner(eventHandler *CallBackEvent) *clusterTaskRunner {
+	return &clusterTaskRunner{eventHandler: eventHandler}
+}
+
+// handle creates the task by newTask and runs it asynchronously, the task is ignored if it is running or complete.
+func (h *clusterTaskRunner) handle(ctx context.Context, taskID string, newTask func() (Task, error)) error {
+	if _, exist := h.handledTask.LoadOrStore(taskID, "running"); exist {
+		logrus.Infof("task %s is running or complete,ignore", taskID)
+		Done(ctx)
+		return nil
+	}
+	task, err := newTask()
+	if err != nil {
+		logrus.Errorf("create task failure %s", err.Error())
+		h.handledTask.Delete(taskID)
+		_ = h.eventHandler.HandleEvent(v1.EventMessage{
+			TaskID: taskID,
+			Message: &v1.Message{
+				StepType: string(domain.TaskStepCreateTask),
+				Message:  err.Error(),
+				Status:   "failure",
+			},
+		})
+		Done(ctx)
+		return nil
+	}
+	// Asynchronous execution to prevent message consumption from taking too long.
+	go h.run(ctx, taskID, task)
+	return nil
+}
+
+func (h *clusterTaskRunner) run(ctx context.Context, taskID string, task Task) {
+	defer Done(ctx)
+	defer func() {
+		h.handledTask.Store(taskID, "complete")
+	}()
+	defer func() {
+		if err := recover(); err != nil {
+			debug.PrintStack()
+		}
+	}()
+	closeChan := make(chan struct{})
+	go func() {
+		defer close(closeChan)
+		for message := range task.GetChan() {
+			if message.StepType == string(closeStep) {
+				return
+			}
+			message := message
+			_ = h.eventHandler.HandleEvent(v1.EventMessage{TaskID: taskID, Message: &message})
+		}
+	}()
+	task.Run(ctx)
+	//waiting message handle complete
+	<-closeChan
+	h.eventHandler.HandleCancelled(ctx, taskID)
+	logrus.Infof("cluster task %s handle success", taskID)
+}
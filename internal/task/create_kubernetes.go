@@ -29,9 +29,8 @@ import (
 	v1 "github.com/wutong-paas/cloud-adaptor/api/cloud-adaptor/v1"
 	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/factory"
 	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
 	"github.com/wutong-paas/cloud-adaptor/internal/types"
-	"github.com/wutong-paas/cloud-adaptor/internal/usecase"
-	"github.com/wutong-paas/cloud-adaptor/pkg/util/constants"
 )
 
 // CreateKubernetesCluster create cluster
@@ -40,24 +39,27 @@ type CreateKubernetesCluster struct {
 	result chan v1.Message
 }
 
-func (c *CreateKubernetesCluster) rollback(step, message, status string) {
+func (c *CreateKubernetesCluster) rollback(step domain.TaskStep, message, status string) {
 	if status == "failure" {
 		logrus.Errorf("%s failure, Message: %s", step, message)
 	}
-	c.result <- v1.Message{StepType: step, Message: message, Status: status}
+	c.result <- v1.Message{StepType: string(step), Message: message, Status: status}
 }
 
 // Run run
 func (c *CreateKubernetesCluster) Run(ctx context.Context) {
-	defer c.rollback("Close", "", "")
-	c.rollback("Init", "", "start")
+	defer c.rollback(closeStep, "", "")
+	c.rollback(domain.TaskStepInit, "", "start")
 	// create adaptor
 	adaptor, err := factory.GetCloudFactory().GetWutongClusterAdaptor(c.config.Provider, c.config.AccessKey, c.config.SecretKey)
 	if err != nil {
-		c.rollback("Init", fmt.Sprintf("create cloud adaptor failure %s", err.Error()), "failure")
+		c.rollback(domain.TaskStepInit, fmt.Sprintf("create cloud adaptor failure %s", err.Error()), "failure")
+		return
+	}
+	c.rollback(domain.TaskStepInit, "cloud adaptor create success", "success")
+	if ctx.Err() != nil {
 		return
 	}
-	c.rollback("Init", "cloud adaptor create success", "success")
 	// create cluster
 	adaptor.CreateWutongKubernetes(ctx, c.config, c.rollback)
 }
@@ -73,25 +75,23 @@ type createKubernetesTaskHandler struct {
 }
 
 // NewCreateKubernetesTaskHandler -
-func NewCreateKubernetesTaskHandler(clusterUsecase *usecase.ClusterUsecase) CreateKubernetesTaskHandler {
+func NewCreateKubernetesTaskHandler(eventHandler *CallBackEvent) CreateKubernetesTaskHandler {
 	return &createKubernetesTaskHandler{
-		eventHandler: &CallBackEvent{
-			TopicName:      constants.CloudCreate,
-			ClusterUsecase: clusterUsecase,
-		},
+		eventHandler: eventHandler,
 	}
 }
 
 // HandleMsg -
 func (h *createKubernetesTaskHandler) HandleMsg(ctx context.Context, createConfig types.KubernetesConfigMessage) error {
-	initTask, err := CreateTask(CreateKubernetesTask, createConfig.KubernetesConfig)
+	initTask, err := CreateTask(domain.ClusterTaskTypeCreateKubernetes, createConfig.KubernetesConfig)
 	if err != nil {
 		logrus.Errorf("create task failure %s", err.Error())
 		_ = h.eventHandler.HandleEvent(createConfig.GetEvent(&v1.Message{
-			StepType: "CreateTask",
+			StepType: string(domain.TaskStepCreateTask),
 			Message:  err.Error(),
 			Status:   "failure",
 		}))
+		Done(ctx)
 		return nil
 	}
 	go h.run(ctx, initTask, createConfig)
@@ -118,6 +118,7 @@ func (h *createKubernetesTaskHandler) HandleMessage(m *nsq.Message) error {
 }
 
 func (h *createKubernetesTaskHandler) run(ctx context.Context, initTask Task, createConfig types.KubernetesConfigMessage) {
+	defer Done(ctx)
 	closeChan := make(chan struct{})
 	defer func() {
 		if err := recover(); err != nil {
@@ -127,7 +128,7 @@ func (h *createKubernetesTaskHandler) run(ctx context.Context, initTask Task, cr
 	go func() {
 		defer close(closeChan)
 		for message := range initTask.GetChan() {
-			if message.StepType == "Close" {
+			if message.StepType == string(closeStep) {
 				return
 			}
 			_ = h.eventHandler.HandleEvent(createConfig.GetEvent(&message))
@@ -136,5 +137,6 @@ func (h *createKubernetesTaskHandler) run(ctx context.Context, initTask Task, cr
 	initTask.Run(ctx)
 	//waiting message handle complete
 	<-closeChan
+	h.eventHandler.HandleCancelled(ctx, createConfig.TaskID)
 	logrus.Infof("create kubernetes task %s handle success", createConfig.TaskID)
 }
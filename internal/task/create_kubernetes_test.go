@@ -24,10 +24,12 @@ import (
 	"testing"
 
 	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
+	"github.com/wutong-paas/cloud-adaptor/pkg/util/retryutil"
 )
 
 func TestCreateKubernetesCluster(t *testing.T) {
-	task, err := CreateTask(CreateKubernetesTask, &v1alpha1.KubernetesClusterConfig{
+	task, err := CreateTask(domain.ClusterTaskTypeCreateKubernetes, &v1alpha1.KubernetesClusterConfig{
 		ClusterName:        "wutong-cluster",
 		WorkerResourceType: "ecs.g5.large",
 		WorkerNodeNum:      2,
@@ -45,11 +47,11 @@ func TestCreateKubernetesCluster(t *testing.T) {
 			fmt.Println(message)
 		}
 	}()
-	task.Run(context.TODO())
+	task.Run(retryutil.WithoutDelay(context.TODO()))
 }
 
 func TestRKECreateKubernetesCluster(t *testing.T) {
-	task, err := CreateTask(CreateKubernetesTask, &v1alpha1.KubernetesClusterConfig{
+	task, err := CreateTask(domain.ClusterTaskTypeCreateKubernetes, &v1alpha1.KubernetesClusterConfig{
 		ClusterName: "wutong-cluster",
 		Nodes: []v1alpha1.ConfigNode{
 			{
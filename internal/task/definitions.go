@@ -0,0 +1,212 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package task
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
+	"github.com/wutong-paas/cloud-adaptor/internal/types"
+	"github.com/wutong-paas/cloud-adaptor/pkg/util/constants"
+)
+
+// TaskDefinitions the registry with the definitions of tasks registered
+type TaskDefinitions struct {
+	*types.TaskRegistry
+}
+
+// NewTaskDefinitions registers the definitions of the tasks to registry. A new kind of task is plugged in
+// by registering its definition here.
+func NewTaskDefinitions(registry *types.TaskRegistry,
+	createHandler CreateKubernetesTaskHandler,
+	initHandler CloudInitTaskHandler,
+	cloudUpdateTaskHandler UpdateKubernetesTaskHandler,
+	eventHandler *CallBackEvent) (*TaskDefinitions, error) {
+	defs := []*types.TaskDefinition{
+		createKubernetesDefinition(createHandler),
+		initWutongDefinition(initHandler),
+		updateKubernetesDefinition(cloudUpdateTaskHandler),
+	}
+	defs = append(defs, clusterOperationDefinitions(newClusterTaskRunner(eventHandler))...)
+	for _, def := range defs {
+		if err := registry.Register(def); err != nil {
+			return nil, err
+		}
+	}
+	return &TaskDefinitions{TaskRegistry: registry}, nil
+}
+
+func createKubernetesDefinition(handler CreateKubernetesTaskHandler) *types.TaskDefinition {
+	return &types.TaskDefinition{
+		Name: constants.CloudCreate,
+		Schema: `{
+			"type": "object",
+			"required": ["task_id", "kubernetes_config"],
+			"properties": {
+				"task_id": {"type": "string", "minLength": 1},
+				"kubernetes_config": {
+					"type": "object",
+					"required": ["provider_name"],
+					"properties": {"provider_name": {"type": "string"}}
+				}
+			}
+		}`,
+		NewPayload: func() types.TaskPayload { return &types.KubernetesConfigMessage{} },
+		Handler: types.TaskHandlerFunc(func(ctx context.Context, payload types.TaskPayload) error {
+			msg, ok := payload.(*types.KubernetesConfigMessage)
+			if !ok {
+				return fmt.Errorf("payload must be *types.KubernetesConfigMessage")
+			}
+			return handler.HandleMsg(ctx, *msg)
+		}),
+	}
+}
+
+func initWutongDefinition(handler CloudInitTaskHandler) *types.TaskDefinition {
+	return &types.TaskDefinition{
+		Name: constants.CloudInit,
+		Schema: `{
+			"type": "object",
+			"required": ["task_id", "init_wutong_config"],
+			"properties": {
+				"task_id": {"type": "string", "minLength": 1},
+				"init_wutong_config": {
+					"type": "object",
+					"required": ["cluster_id", "provider"],
+					"properties": {
+						"cluster_id": {"type": "string"},
+						"provider": {"type": "string"},
+						"completed_steps": {"type": "array", "items": {"type": "string"}}
+					}
+				}
+			}
+		}`,
+		NewPayload: func() types.TaskPayload { return &types.InitWutongConfigMessage{} },
+		Handler: types.TaskHandlerFunc(func(ctx context.Context, payload types.TaskPayload) error {
+			msg, ok := payload.(*types.InitWutongConfigMessage)
+			if !ok {
+				return fmt.Errorf("payload must be *types.InitWutongConfigMessage")
+			}
+			return handler.HandleMsg(ctx, *msg)
+		}),
+		// the steps of init are idempotent, the interrupted task is resumed from the steps not succeeded
+		Resume: func(payload types.TaskPayload, succeededSteps []string) bool {
+			msg, ok := payload.(*types.InitWutongConfigMessage)
+			if !ok || msg.InitWutongConfig == nil {
+				return false
+			}
+			completed := make(map[string]bool)
+			for _, step := range msg.InitWutongConfig.CompletedSteps {
+				completed[step] = true
+			}
+			for _, step := range succeededSteps {
+				if !completed[step] {
+					completed[step] = true
+					msg.InitWutongConfig.CompletedSteps = append(msg.InitWutongConfig.CompletedSteps, step)
+				}
+			}
+			return true
+		},
+	}
+}
+
+func updateKubernetesDefinition(handler UpdateKubernetesTaskHandler) *types.TaskDefinition {
+	return &types.TaskDefinition{
+		Name: constants.CloudUpdate,
+		Schema: `{
+			"type": "object",
+			"required": ["task_id", "config"],
+			"properties": {
+				"task_id": {"type": "string", "minLength": 1},
+				"config": {"type": "object"}
+			}
+		}`,
+		NewPayload: func() types.TaskPayload { return &types.UpdateKubernetesConfigMessage{} },
+		Handler: types.TaskHandlerFunc(func(ctx context.Context, payload types.TaskPayload) error {
+			msg, ok := payload.(*types.UpdateKubernetesConfigMessage)
+			if !ok {
+				return fmt.Errorf("payload must be *types.UpdateKubernetesConfigMessage")
+			}
+			return handler.HandleMsg(ctx, *msg)
+		}),
+	}
+}
+
+// clusterOperationDefinitions returns the definitions of the operations on the clusters, each operation is a kind
+// of task with its own payload. The name of definition is the task type of the operation.
+func clusterOperationDefinitions(runner *clusterTaskRunner) []*types.TaskDefinition {
+	return []*types.TaskDefinition{
+		clusterOperationDefinition[*v1alpha1.UpgradeKubernetes](domain.ClusterTaskTypeUpgradeKubernetes, runner,
+			func() types.TaskPayload { return &types.ClusterTaskMessage[*v1alpha1.UpgradeKubernetes]{} }),
+		clusterOperationDefinition[*v1alpha1.EtcdSnapshot](domain.ClusterTaskTypeEtcdSnapshot, runner,
+			func() types.TaskPayload { return &types.EtcdSnapshotMessage{} }),
+		clusterOperationDefinition[*v1alpha1.EtcdSnapshot](domain.ClusterTaskTypeEtcdRestore, runner,
+			func() types.TaskPayload { return &types.ClusterTaskMessage[*v1alpha1.EtcdSnapshot]{} }),
+		clusterOperationDefinition[*v1alpha1.RotateCertificates](domain.ClusterTaskTypeRotateCertificates, runner,
+			func() types.TaskPayload { return &types.ClusterTaskMessage[*v1alpha1.RotateCertificates]{} }),
+		clusterOperationDefinition[*v1alpha1.SecretsEncryption](domain.ClusterTaskTypeEnableSecretsEncryption, runner,
+			func() types.TaskPayload { return &types.ClusterTaskMessage[*v1alpha1.SecretsEncryption]{} }),
+		clusterOperationDefinition[*v1alpha1.SecretsEncryption](domain.ClusterTaskTypeRotateEncryptionKey, runner,
+			func() types.TaskPayload { return &types.ClusterTaskMessage[*v1alpha1.SecretsEncryption]{} }),
+		clusterOperationDefinition[*v1alpha1.RemoveNodes](domain.ClusterTaskTypeRemoveNodes, runner,
+			func() types.TaskPayload { return &types.ClusterTaskMessage[*v1alpha1.RemoveNodes]{} }),
+		clusterOperationDefinition[*v1alpha1.DestroyCluster](domain.ClusterTaskTypeDestroyCluster, runner,
+			func() types.TaskPayload { return &types.ClusterTaskMessage[*v1alpha1.DestroyCluster]{} }),
+		clusterOperationDefinition[*v1alpha1.RotateSSHKey](domain.ClusterTaskTypeRotateSSHKey, runner,
+			func() types.TaskPayload { return &types.ClusterTaskMessage[*v1alpha1.RotateSSHKey]{} }),
+	}
+}
+
+// clusterOperationDefinition returns the definition of the task running the operation with config C by the runner
+// of taskType, the name of definition is taskType and the status of task follows the lifecycle of taskType.
+func clusterOperationDefinition[C types.ClusterOperation](taskType domain.ClusterTaskType, runner *clusterTaskRunner,
+	newPayload func() types.TaskPayload) *types.TaskDefinition {
+	name := string(taskType)
+	return &types.TaskDefinition{
+		Name: name,
+		Schema: `{
+			"type": "object",
+			"required": ["task_id", "config"],
+			"properties": {
+				"task_id": {"type": "string", "minLength": 1},
+				"config": {
+					"type": "object",
+					"required": ["provider", "clusterID"],
+					"properties": {
+						"provider": {"type": "string", "minLength": 1},
+						"clusterID": {"type": "string", "minLength": 1}
+					}
+				}
+			}
+		}`,
+		NewPayload: newPayload,
+		Handler: types.TaskHandlerFunc(func(ctx context.Context, payload types.TaskPayload) error {
+			msg, ok := payload.(interface{ GetConfig() C })
+			if !ok {
+				return fmt.Errorf("payload of task %s must have the config %T", name, *new(C))
+			}
+			return runner.handle(ctx, payload.GetTaskID(), func() (Task, error) {
+				return CreateTask(taskType, msg.GetConfig())
+			})
+		}),
+		Lifecycle: taskType.Lifecycle(),
+	}
+}
@@ -0,0 +1,69 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package task
+
+import (
+	"testing"
+
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
+	"github.com/wutong-paas/cloud-adaptor/internal/types"
+)
+
+func TestClusterOperationDefinitions(t *testing.T) {
+	registry := types.NewTaskRegistry()
+	if _, err := NewTaskDefinitions(registry, nil, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	def, payload, err := registry.Decode(string(domain.ClusterTaskTypeUpgradeKubernetes),
+		[]byte(`{"task_id":"t1","config":{"provider":"rke","clusterID":"c1","kubernetesVersion":"v1.24.4"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if def.Lifecycle == nil {
+		t.Fatalf("the operation %s has no lifecycle", def.Name)
+	}
+	msg, ok := payload.(*types.ClusterTaskMessage[*v1alpha1.UpgradeKubernetes])
+	if !ok {
+		t.Fatalf("unexpected payload %T", payload)
+	}
+	if msg.GetTaskID() != "t1" || msg.Config.KubernetesVersion != "v1.24.4" {
+		t.Fatalf("unexpected payload %+v", msg)
+	}
+	if provider, clusterID := msg.Target(); provider != "rke" || clusterID != "c1" {
+		t.Fatalf("unexpected target %s/%s", provider, clusterID)
+	}
+	if !msg.Mutating() {
+		t.Fatal("upgrade must change the cluster")
+	}
+
+	_, payload, err = registry.Decode(string(domain.ClusterTaskTypeEtcdSnapshot),
+		[]byte(`{"task_id":"t2","config":{"provider":"rke","clusterID":"c1"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.Mutating() {
+		t.Fatal("saving an etcd snapshot must not change the cluster")
+	}
+
+	if _, _, err := registry.Decode(string(domain.ClusterTaskTypeDestroyCluster), []byte(`{"task_id":"t3","config":{"provider":"rke"}}`)); err == nil {
+		t.Fatal("the payload without cluster must be invalid")
+	}
+}
@@ -20,6 +20,7 @@ package task
 
 import (
 	"context"
+	"errors"
 
 	nsq "github.com/nsqio/go-nsq"
 	"github.com/wutong-paas/cloud-adaptor/internal/types"
@@ -42,3 +43,21 @@ type UpdateKubernetesTaskHandler interface {
 	HandleMsg(ctx context.Context, createConfig types.UpdateKubernetesConfigMessage) error
 	HandleMessage(m *nsq.Message) error
 }
+
+// ErrCancelled the cause of the context of a cancelled task
+var ErrCancelled = errors.New("task cancelled")
+
+type doneKey struct{}
+
+// WithDone returns a copy of ctx carrying done. The handlers call done once the task started by HandleMsg
+// completes, or the message is ignored, so that the consumer knows when to release the task.
+func WithDone(ctx context.Context, done func()) context.Context {
+	return context.WithValue(ctx, doneKey{}, done)
+}
+
+// Done calls the done func carried by ctx, if any.
+func Done(ctx context.Context) {
+	if done, ok := ctx.Value(doneKey{}).(func()); ok {
+		done()
+	}
+}
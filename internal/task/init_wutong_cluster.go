@@ -21,21 +21,26 @@ package task
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"runtime/debug"
+	"sync"
 	"time"
 
 	"github.com/nsqio/go-nsq"
 	"github.com/rancher/rke/k8s"
 	"github.com/sirupsen/logrus"
 	apiv1 "github.com/wutong-paas/cloud-adaptor/api/cloud-adaptor/v1"
+	cloudadaptor "github.com/wutong-paas/cloud-adaptor/internal/adaptor"
 	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/factory"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
 	"github.com/wutong-paas/cloud-adaptor/internal/datastore"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
 	"github.com/wutong-paas/cloud-adaptor/internal/operator"
 	"github.com/wutong-paas/cloud-adaptor/internal/repo"
 	"github.com/wutong-paas/cloud-adaptor/internal/types"
-	"github.com/wutong-paas/cloud-adaptor/internal/usecase"
-	"github.com/wutong-paas/cloud-adaptor/pkg/util/constants"
+	"github.com/wutong-paas/cloud-adaptor/pkg/util/retryutil"
 	"github.com/wutong-paas/cloud-adaptor/pkg/util/versionutil"
 	"github.com/wutong-paas/cloud-adaptor/version"
 	wutongv1alpha1 "github.com/wutong-paas/wutong-operator/api/v1alpha1"
@@ -50,102 +55,117 @@ type InitWutongCluster struct {
 	result chan apiv1.Message
 }
 
-func (c *InitWutongCluster) rollback(step, message, status string) {
+func (c *InitWutongCluster) rollback(step domain.TaskStep, message, status string) {
 	if status == "failure" {
 		logrus.Errorf("%s failure, Message: %s", step, message)
 	}
-	c.result <- apiv1.Message{StepType: step, Message: message, Status: status}
+	c.result <- apiv1.Message{StepType: string(step), Message: message, Status: status}
 }
 
 // Run run take time 214.10s
 func (c *InitWutongCluster) Run(ctx context.Context) {
-	defer c.rollback("Close", "", "")
-	c.rollback("Init", "", "start")
+	defer c.rollback(closeStep, "", "")
+	c.rollback(domain.TaskStepInit, "", "start")
 	// create adaptor
 	adaptor, err := factory.GetCloudFactory().GetWutongClusterAdaptor(c.config.Provider, c.config.AccessKey, c.config.SecretKey)
 	if err != nil {
-		c.rollback("Init", fmt.Sprintf("create cloud adaptor failure %s", err.Error()), "failure")
+		c.rollback(domain.TaskStepInit, fmt.Sprintf("create cloud adaptor failure %s", err.Error()), "failure")
 		return
 	}
 
-	c.rollback("Init", "cloud adaptor create success", "success")
-	c.rollback("CheckCluster", "", "start")
+	if ra, ok := adaptor.(cloudadaptor.CloudResourceAdaptor); ok {
+		ra.SetResourceRecorder(c.recordResource)
+	}
+	if ra, ok := adaptor.(cloudadaptor.ResumableAdaptor); ok {
+		ra.SetCompletedSteps(c.config.CompletedSteps)
+	}
+
+	c.rollback(domain.TaskStepInit, "cloud adaptor create success", "success")
+	if ctx.Err() != nil {
+		return
+	}
+	c.rollback(domain.TaskStepCheckCluster, "", "start")
+	checkPolicy := domain.TaskStepCheckCluster.RetryPolicy()
+	checkNotify := retryutil.EventNotify(domain.TaskStepCheckCluster, c.rollback)
 	// get kubernetes cluster info
-	cluster, err := adaptor.DescribeCluster(c.config.ClusterID)
+	cluster, err := retryutil.Call(ctx, checkPolicy, checkNotify, func() (*v1alpha1.Cluster, error) {
+		return adaptor.DescribeCluster(c.config.ClusterID)
+	})
 	if err != nil {
-		cluster, err = adaptor.DescribeCluster(c.config.ClusterID)
-		if err != nil {
-			c.rollback("CheckCluster", err.Error(), "failure")
-			return
-		}
+		c.rollback(domain.TaskStepCheckCluster, err.Error(), "failure")
+		return
 	}
 	// check cluster status
 	if cluster.State != "running" {
-		c.rollback("CheckCluster", fmt.Sprintf("cluster status is %s,not support init wutong", cluster.State), "failure")
+		c.rollback(domain.TaskStepCheckCluster, fmt.Sprintf("cluster status is %s,not support init wutong", cluster.State), "failure")
 		return
 	}
 	// check cluster version
 	if !versionutil.CheckVersion(cluster.KubernetesVersion) {
-		c.rollback("CheckCluster", fmt.Sprintf("current cluster version is %s, init wutong support kubernetes version is 1.19.x-1.26.x", cluster.KubernetesVersion), "failure")
+		c.rollback(domain.TaskStepCheckCluster, fmt.Sprintf("current cluster version is %s, init wutong support kubernetes version is 1.19.x-1.26.x", cluster.KubernetesVersion), "failure")
 		return
 	}
 	// check cluster connection status
 	logrus.Infof("init kubernetes url %s", cluster.MasterURL)
 	if cluster.MasterURL.APIServerEndpoint == "" {
-		c.rollback("CheckCluster", "cluster api not open eip,not support init wutong", "failure")
+		c.rollback(domain.TaskStepCheckCluster, "cluster api not open eip,not support init wutong", "failure")
 		return
 	}
 
-	kubeConfig, err := adaptor.GetKubeConfig(c.config.ClusterID)
+	kubeConfig, err := retryutil.Call(ctx, checkPolicy, checkNotify, func() (*v1alpha1.KubeConfig, error) {
+		return adaptor.GetKubeConfig(c.config.ClusterID)
+	})
 	if err != nil {
-		kubeConfig, err = adaptor.GetKubeConfig(c.config.ClusterID)
-		if err != nil {
-			c.rollback("CheckCluster", fmt.Sprintf("get kube config failure %s", err.Error()), "failure")
-			return
-		}
+		c.rollback(domain.TaskStepCheckCluster, fmt.Sprintf("get kube config failure %s", err.Error()), "failure")
+		return
 	}
 
 	// check cluster not init wutong
 	coreClient, _, err := kubeConfig.GetKubeClient()
 	if err != nil {
-		c.rollback("CheckCluster", fmt.Sprintf("get kube config failure %s", err.Error()), "failure")
+		c.rollback(domain.TaskStepCheckCluster, fmt.Sprintf("get kube config failure %s", err.Error()), "failure")
 		return
 	}
 
 	// get cluster node lists
-	getctx, cancel := context.WithTimeout(ctx, time.Second*10)
-	nodes, err := coreClient.CoreV1().Nodes().List(getctx, metav1.ListOptions{})
+	nodes, err := retryutil.Call(ctx, retryutil.KubeAPIPolicy, checkNotify, func() (*v1.NodeList, error) {
+		getctx, cancel := context.WithTimeout(ctx, time.Second*10)
+		defer cancel()
+		return coreClient.CoreV1().Nodes().List(getctx, metav1.ListOptions{})
+	})
 	if err != nil {
-		nodes, err = coreClient.CoreV1().Nodes().List(getctx, metav1.ListOptions{})
-		cancel()
-		if err != nil {
-			logrus.Errorf("get kubernetes cluster node failure %s", err.Error())
-			c.rollback("CheckCluster", "cluster node list can not found, please check cluster public access and account authorization", "failure")
-			return
-		}
-	} else {
-		cancel()
+		logrus.Errorf("get kubernetes cluster node failure %s", err.Error())
+		c.rollback(domain.TaskStepCheckCluster, "cluster node list can not found, please check cluster public access and account authorization", "failure")
+		return
 	}
 	if len(nodes.Items) == 0 {
-		c.rollback("CheckCluster", "node num is 0, can not init wutong", "failure")
+		c.rollback(domain.TaskStepCheckCluster, "node num is 0, can not init wutong", "failure")
 		return
 	}
-	c.rollback("CheckCluster", c.config.ClusterID, "success")
+	c.rollback(domain.TaskStepCheckCluster, c.config.ClusterID, "success")
 
 	// select gateway and chaos node
 	gatewayNodes, chaosNodes := c.GetWutongGatewayNodeAndChaosNodes(nodes.Items)
-	initConfig := adaptor.GetWutongInitConfig(cluster, gatewayNodes, chaosNodes, c.rollback)
+	initConfig := adaptor.GetWutongInitConfig(ctx, cluster, gatewayNodes, chaosNodes, c.rollback)
+	if initConfig == nil {
+		// the failure step has been reported by adaptor
+		return
+	}
 	initConfig.WutongVersion = version.WutongRegionVersion
+	if ctx.Err() != nil {
+		return
+	}
 	// init wutong
-	c.rollback("InitWutongRegionOperator", "", "start")
+	c.rollback(domain.TaskStepInitWutongRegionOperator, "", "start")
 	if len(initConfig.EIPs) == 0 {
-		c.rollback("InitWutongRegionOperator", "can not select eip", "failure")
+		c.rollback(domain.TaskStepInitWutongRegionOperator, "can not select eip", "failure")
 		return
 	}
 
 	rri := operator.NewWutongRegionInit(*kubeConfig, repo.NewWutongClusterConfigRepo(datastore.GetGDB()), initConfig)
+	rri.SetRetryNotify(retryutil.EventNotify(domain.TaskStepInitWutongRegionOperator, c.rollback))
 	if err := rri.InitWutongRegion(initConfig); err != nil {
-		c.rollback("InitWutongRegionOperator", err.Error(), "failure")
+		c.rollback(domain.TaskStepInitWutongRegionOperator, err.Error(), "failure")
 		return
 	}
 	ticker := time.NewTicker(time.Second * 5)
@@ -157,17 +177,21 @@ func (c *InitWutongCluster) Run(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
-			c.rollback("InitWutongRegion", "context cancel", "failure")
+			if errors.Is(context.Cause(ctx), ErrCancelled) {
+				// the wutong region is left as it is, it can be uninstalled or initialized again
+				return
+			}
+			c.rollback(domain.TaskStepInitWutongRegion, "context cancel", "failure")
 			return
 		case <-ticker.C:
 		case <-timer.C:
-			c.rollback("InitWutongRegion", "waiting wutong region ready timeout", "failure")
+			c.rollback(domain.TaskStepInitWutongRegion, "waiting wutong region ready timeout", "failure")
 			return
 		}
 		status, err := rri.GetWutongRegionStatus(initConfig.ClusterID)
 		if err != nil {
 			if k8sErrors.IsNotFound(err) {
-				c.rollback("InitWutongRegion", err.Error(), "failure")
+				c.rollback(domain.TaskStepInitWutongRegion, err.Error(), "failure")
 				return
 			}
 			logrus.Errorf("get wutong region status failure %s", err.Error())
@@ -176,15 +200,15 @@ func (c *InitWutongCluster) Run(ctx context.Context) {
 			continue
 		}
 		if status.OperatorReady && !operatorMessage {
-			c.rollback("InitWutongRegionOperator", "", "success")
-			c.rollback("InitWutongRegionImageHub", "", "start")
+			c.rollback(domain.TaskStepInitWutongRegionOperator, "", "success")
+			c.rollback(domain.TaskStepInitWutongRegionImageHub, "", "start")
 			operatorMessage = true
 			continue
 		}
 
 		if idx, condition := status.WutongCluster.Status.GetCondition(wutongv1alpha1.WutongClusterConditionTypeImageRepository); !imageHubMessage && idx != -1 && condition.Status == v1.ConditionTrue {
-			c.rollback("InitWutongRegionImageHub", "", "success")
-			c.rollback("InitWutongRegionPackage", "", "start")
+			c.rollback(domain.TaskStepInitWutongRegionImageHub, "", "success")
+			c.rollback(domain.TaskStepInitWutongRegionPackage, "", "start")
 			imageHubMessage = true
 			continue
 		}
@@ -200,8 +224,8 @@ func (c *InitWutongCluster) Run(ctx context.Context) {
 
 		for _, con := range status.WutongPackage.Status.Conditions {
 			if con.Type == wutongv1alpha1.Ready && con.Status == wutongv1alpha1.Completed && !packageMessage {
-				c.rollback("InitWutongRegionPackage", "", "success")
-				c.rollback("InitWutongRegionConfig", "", "start")
+				c.rollback(domain.TaskStepInitWutongRegionPackage, "", "success")
+				c.rollback(domain.TaskStepInitWutongRegionConfig, "", "start")
 				packageMessage = true
 			}
 			continue
@@ -216,7 +240,24 @@ func (c *InitWutongCluster) Run(ctx context.Context) {
 			break
 		}
 	}
-	c.rollback("InitWutongRegion", cluster.ClusterID, "success")
+	c.rollback(domain.TaskStepInitWutongRegion, cluster.ClusterID, "success")
+}
+
+// recordResource save the cloud resource created by adaptor, so that it can be released when init failed
+func (c *InitWutongCluster) recordResource(resource *v1alpha1.CloudResource) {
+	ent := &model.CloudResource{
+		TaskID:    c.config.TaskID,
+		Provider:  c.config.Provider,
+		ClusterID: resource.ClusterID,
+		Type:      string(resource.Type),
+		CloudID:   resource.CloudID,
+		Spec:      resource.Spec,
+		RegionID:  resource.RegionID,
+		ParentID:  resource.ParentID,
+	}
+	if err := repo.NewCloudResourceRepo(datastore.GetGDB()).Create(ent); err != nil {
+		logrus.Errorf("save cloud resource %s %s failure %s", ent.Type, ent.CloudID, err.Error())
+	}
 }
 
 // GetWutongGatewayNodeAndChaosNodes get gateway nodes
@@ -289,37 +330,42 @@ func getK8sNode(node v1.Node) *wutongv1alpha1.K8sNode {
 // cloudInitTaskHandler cloud init task handler
 type cloudInitTaskHandler struct {
 	eventHandler *CallBackEvent
-	handledTask  map[string]string
+	// handledTask the tasks running or complete, HandleMsg is called by the workers of the consumer concurrently
+	handledTask sync.Map
 }
 
 // NewCloudInitTaskHandler -
-func NewCloudInitTaskHandler(clusterUsecase *usecase.ClusterUsecase) CloudInitTaskHandler {
+func NewCloudInitTaskHandler(eventHandler *CallBackEvent) CloudInitTaskHandler {
 	return &cloudInitTaskHandler{
-		eventHandler: &CallBackEvent{TopicName: constants.CloudInit, ClusterUsecase: clusterUsecase},
-		handledTask:  make(map[string]string),
+		eventHandler: eventHandler,
 	}
 }
 
 // HandleMsg -
 func (h *cloudInitTaskHandler) HandleMsg(ctx context.Context, initConfig types.InitWutongConfigMessage) error {
-	if _, exist := h.handledTask[initConfig.TaskID]; exist {
+	if _, exist := h.handledTask.LoadOrStore(initConfig.TaskID, "running"); exist {
 		logrus.Infof("task %s is running or complete,ignore", initConfig.TaskID)
+		Done(ctx)
 		return nil
 	}
-	initTask, err := CreateTask(InitWutongClusterTask, initConfig.InitWutongConfig)
+	if initConfig.InitWutongConfig != nil {
+		initConfig.InitWutongConfig.TaskID = initConfig.TaskID
+	}
+	initTask, err := CreateTask(domain.ClusterTaskTypeInitWutong, initConfig.InitWutongConfig)
 	if err != nil {
 		logrus.Errorf("create task failure %s", err.Error())
+		h.handledTask.Delete(initConfig.TaskID)
 		_ = h.eventHandler.HandleEvent(initConfig.GetEvent(&apiv1.Message{
-			StepType: "CreateTask",
+			StepType: string(domain.TaskStepCreateTask),
 			Message:  err.Error(),
 			Status:   "failure",
 		}))
+		Done(ctx)
 		return nil
 	}
 	// Asynchronous execution to prevent message consumption from taking too long.
 	// Idempotent consumption of messages is not currently supported
 	go h.run(ctx, initTask, initConfig)
-	h.handledTask[initConfig.TaskID] = "running"
 	return nil
 }
 
@@ -343,8 +389,9 @@ func (h *cloudInitTaskHandler) HandleMessage(m *nsq.Message) error {
 }
 
 func (h *cloudInitTaskHandler) run(ctx context.Context, initTask Task, initConfig types.InitWutongConfigMessage) {
+	defer Done(ctx)
 	defer func() {
-		h.handledTask[initConfig.TaskID] = "complete"
+		h.handledTask.Store(initConfig.TaskID, "complete")
 	}()
 	defer func() {
 		if err := recover(); err != nil {
@@ -355,7 +402,7 @@ func (h *cloudInitTaskHandler) run(ctx context.Context, initTask Task, initConfi
 	go func() {
 		defer close(closeChan)
 		for message := range initTask.GetChan() {
-			if message.StepType == "Close" {
+			if message.StepType == string(closeStep) {
 				return
 			}
 			_ = h.eventHandler.HandleEvent(initConfig.GetEvent(&message))
@@ -364,5 +411,6 @@ func (h *cloudInitTaskHandler) run(ctx context.Context, initTask Task, initConfi
 	initTask.Run(ctx)
 	//waiting message handle complete
 	<-closeChan
+	h.eventHandler.HandleCancelled(ctx, initConfig.TaskID)
 	logrus.Infof("init wutong region task %s handle success", initConfig.TaskID)
 }
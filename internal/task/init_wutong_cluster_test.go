@@ -23,11 +23,12 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
 	"github.com/wutong-paas/cloud-adaptor/internal/types"
 )
 
 func TestInitWutongCluster(t *testing.T) {
-	task, err := CreateTask(InitWutongClusterTask, &types.InitWutongConfig{
+	task, err := CreateTask(domain.ClusterTaskTypeInitWutong, &types.InitWutongConfig{
 		Provider:  "ack",
 		SecretKey: "hBsW4mlp35xQlqvqvm5Izmbt2UFR6E",
 		AccessKey: "LTAI4FtxHG8A8h328zBBNMtw",
@@ -46,7 +47,7 @@ func TestInitWutongCluster(t *testing.T) {
 }
 
 func TestInitRKEWutongCluster(t *testing.T) {
-	task, err := CreateTask(InitWutongClusterTask, &types.InitWutongConfig{
+	task, err := CreateTask(domain.ClusterTaskTypeInitWutong, &types.InitWutongConfig{
 		Provider:  "rke",
 		ClusterID: "c9b4516adec504a458bdf9a6891fc74fe",
 	})
@@ -24,12 +24,17 @@ import (
 
 	"github.com/google/wire"
 	v1 "github.com/wutong-paas/cloud-adaptor/api/cloud-adaptor/v1"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor"
 	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
 	"github.com/wutong-paas/cloud-adaptor/internal/types"
 )
 
 // ProviderSet is task providers.
-var ProviderSet = wire.NewSet(NewCreateKubernetesTaskHandler, NewCloudInitTaskHandler, NewCloudUpdateTaskHandler)
+var ProviderSet = wire.NewSet(NewCallBackEvent, NewCreateKubernetesTaskHandler, NewCloudInitTaskHandler, NewCloudUpdateTaskHandler, NewTaskDefinitions)
+
+// closeStep the step of the last message of task, it closes the events of task and is not saved.
+const closeStep domain.TaskStep = "Close"
 
 //Task Asynchronous tasks
 type Task interface {
@@ -37,39 +42,92 @@ type Task interface {
 	GetChan() chan v1.Message
 }
 
-//Type task type
-type Type string
+// taskFactory creates the task with its config
+type taskFactory func(config interface{}) (Task, error)
 
-//CreateKubernetesTask create kubernetes task
-var CreateKubernetesTask Type = "create_kubernetes"
+var taskFactories = make(map[domain.ClusterTaskType]taskFactory)
 
-//UpdateKubernetesTask update kubernetes task
-var UpdateKubernetesTask Type = "update_kubernetes"
+// RegisterTaskType registers the task type, the config of the task type must be *C.
+func RegisterTaskType[C any](taskType domain.ClusterTaskType, newTask func(config *C) Task) {
+	taskFactories[taskType] = func(config interface{}) (Task, error) {
+		cconfig, ok := config.(*C)
+		if !ok {
+			return nil, fmt.Errorf("config must be %T", cconfig)
+		}
+		return newTask(cconfig), nil
+	}
+}
 
-//InitWutongClusterTask init wutong cluster task
-var InitWutongClusterTask Type = "init_wutong_cluster"
+func init() {
+	RegisterTaskType(domain.ClusterTaskTypeCreateKubernetes, func(config *v1alpha1.KubernetesClusterConfig) Task {
+		return &CreateKubernetesCluster{result: make(chan v1.Message, 10), config: config}
+	})
+	RegisterTaskType(domain.ClusterTaskTypeInitWutong, func(config *types.InitWutongConfig) Task {
+		return &InitWutongCluster{result: make(chan v1.Message, 10), config: config}
+	})
+	RegisterTaskType(domain.ClusterTaskTypeUpdateKubernetes, func(config *v1alpha1.ExpansionNode) Task {
+		return newAdaptorTask(config.Provider, config.AccessKey, config.SecretKey, "expansion node",
+			func(ctx context.Context, ad adaptor.WutongClusterAdaptor, rollback func(step domain.TaskStep, message, status string)) {
+				ad.ExpansionNode(ctx, config, rollback)
+			})
+	})
+	RegisterTaskType(domain.ClusterTaskTypeUpgradeKubernetes, func(config *v1alpha1.UpgradeKubernetes) Task {
+		return newAdaptorTask(config.Provider, config.AccessKey, config.SecretKey, "upgrade kubernetes",
+			func(ctx context.Context, ad adaptor.UpgradeAdaptor, rollback func(step domain.TaskStep, message, status string)) {
+				ad.UpgradeKubernetes(ctx, config, rollback)
+			})
+	})
+	RegisterTaskType(domain.ClusterTaskTypeEtcdSnapshot, func(config *v1alpha1.EtcdSnapshot) Task {
+		return newAdaptorTask(config.Provider, config.AccessKey, config.SecretKey, "etcd snapshot",
+			func(ctx context.Context, ad adaptor.EtcdSnapshotAdaptor, rollback func(step domain.TaskStep, message, status string)) {
+				ad.SaveEtcdSnapshot(ctx, config, rollback)
+			})
+	})
+	RegisterTaskType(domain.ClusterTaskTypeEtcdRestore, func(config *v1alpha1.EtcdSnapshot) Task {
+		return newAdaptorTask(config.Provider, config.AccessKey, config.SecretKey, "etcd snapshot",
+			func(ctx context.Context, ad adaptor.EtcdSnapshotAdaptor, rollback func(step domain.TaskStep, message, status string)) {
+				ad.RestoreEtcdSnapshot(ctx, config, rollback)
+			})
+	})
+	RegisterTaskType(domain.ClusterTaskTypeRotateCertificates, func(config *v1alpha1.RotateCertificates) Task {
+		return newAdaptorTask(config.Provider, config.AccessKey, config.SecretKey, "rotate certificates",
+			func(ctx context.Context, ad adaptor.CertificateAdaptor, rollback func(step domain.TaskStep, message, status string)) {
+				ad.RotateCertificates(ctx, config, rollback)
+			})
+	})
+	newSecretsEncryptionTask := func(config *v1alpha1.SecretsEncryption) Task {
+		return newAdaptorTask(config.Provider, config.AccessKey, config.SecretKey, "secrets encryption",
+			func(ctx context.Context, ad adaptor.SecretsEncryptionAdaptor, rollback func(step domain.TaskStep, message, status string)) {
+				ad.UpdateSecretsEncryption(ctx, config, rollback)
+			})
+	}
+	RegisterTaskType(domain.ClusterTaskTypeEnableSecretsEncryption, newSecretsEncryptionTask)
+	RegisterTaskType(domain.ClusterTaskTypeRotateEncryptionKey, newSecretsEncryptionTask)
+	RegisterTaskType(domain.ClusterTaskTypeRemoveNodes, func(config *v1alpha1.RemoveNodes) Task {
+		return newAdaptorTask(config.Provider, config.AccessKey, config.SecretKey, "remove nodes",
+			func(ctx context.Context, ad adaptor.NodeRemovalAdaptor, rollback func(step domain.TaskStep, message, status string)) {
+				ad.RemoveNodes(ctx, config, rollback)
+			})
+	})
+	RegisterTaskType(domain.ClusterTaskTypeDestroyCluster, func(config *v1alpha1.DestroyCluster) Task {
+		return newAdaptorTask(config.Provider, config.AccessKey, config.SecretKey, "destroy cluster",
+			func(ctx context.Context, ad adaptor.DestroyAdaptor, rollback func(step domain.TaskStep, message, status string)) {
+				ad.DestroyCluster(ctx, config, rollback)
+			})
+	})
+	RegisterTaskType(domain.ClusterTaskTypeRotateSSHKey, func(config *v1alpha1.RotateSSHKey) Task {
+		return newAdaptorTask(config.Provider, config.AccessKey, config.SecretKey, "rotate ssh key",
+			func(ctx context.Context, ad adaptor.SSHKeyAdaptor, rollback func(step domain.TaskStep, message, status string)) {
+				ad.RotateSSHKey(ctx, config, rollback)
+			})
+	})
+}
 
 //CreateTask create task
-func CreateTask(taskType Type, config interface{}) (Task, error) {
-	switch taskType {
-	case CreateKubernetesTask:
-		cconfig, ok := config.(*v1alpha1.KubernetesClusterConfig)
-		if !ok {
-			return nil, fmt.Errorf("config must be *v1alpha1.KubernetesClusterConfig")
-		}
-		return &CreateKubernetesCluster{result: make(chan v1.Message, 10), config: cconfig}, nil
-	case InitWutongClusterTask:
-		cconfig, ok := config.(*types.InitWutongConfig)
-		if !ok {
-			return nil, fmt.Errorf("config must be *InitWutongConfig")
-		}
-		return &InitWutongCluster{result: make(chan v1.Message, 10), config: cconfig}, nil
-	case UpdateKubernetesTask:
-		cconfig, ok := config.(*v1alpha1.ExpansionNode)
-		if !ok {
-			return nil, fmt.Errorf("config must be *v1alpha1.ExpansionNode")
-		}
-		return &UpdateKubernetesCluster{result: make(chan v1.Message, 10), config: cconfig}, nil
+func CreateTask(taskType domain.ClusterTaskType, config interface{}) (Task, error) {
+	factory, ok := taskFactories[taskType]
+	if !ok {
+		return nil, fmt.Errorf("task type not support")
 	}
-	return nil, fmt.Errorf("task type not support")
+	return factory(config)
 }
@@ -21,86 +21,29 @@ package task
 import (
 	"context"
 	"encoding/json"
-	"fmt"
-	"runtime/debug"
 
 	"github.com/nsqio/go-nsq"
 	"github.com/sirupsen/logrus"
-	v1 "github.com/wutong-paas/cloud-adaptor/api/cloud-adaptor/v1"
-	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/factory"
-	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
 	"github.com/wutong-paas/cloud-adaptor/internal/types"
-	"github.com/wutong-paas/cloud-adaptor/internal/usecase"
-	"github.com/wutong-paas/cloud-adaptor/pkg/util/constants"
 )
 
-// UpdateKubernetesCluster update cluster
-type UpdateKubernetesCluster struct {
-	config *v1alpha1.ExpansionNode
-	result chan v1.Message
-}
-
-func (c *UpdateKubernetesCluster) rollback(step, message, status string) {
-	if status == "failure" {
-		logrus.Errorf("%s failure, Message: %s", step, message)
-	}
-	c.result <- v1.Message{StepType: step, Message: message, Status: status}
-}
-
-// Run run
-func (c *UpdateKubernetesCluster) Run(ctx context.Context) {
-	defer c.rollback("Close", "", "")
-	c.rollback("Init", "", "start")
-	// create adaptor
-	adaptor, err := factory.GetCloudFactory().GetWutongClusterAdaptor(c.config.Provider, c.config.AccessKey, c.config.SecretKey)
-	if err != nil {
-		c.rollback("Init", fmt.Sprintf("create cloud adaptor failure %s", err.Error()), "failure")
-		return
-	}
-	c.rollback("Init", "cloud adaptor create success", "success")
-	// update cluster
-	adaptor.ExpansionNode(ctx, c.config, c.rollback)
-}
-
-// GetChan get message chan
-func (c *UpdateKubernetesCluster) GetChan() chan v1.Message {
-	return c.result
-}
-
 type cloudUpdateTaskHandler struct {
-	eventHandler *CallBackEvent
-	handledTask  map[string]string
+	*clusterTaskRunner
 }
 
 // NewCloudUpdateTaskHandler -
-func NewCloudUpdateTaskHandler(clusterUsecase *usecase.ClusterUsecase) UpdateKubernetesTaskHandler {
+func NewCloudUpdateTaskHandler(eventHandler *CallBackEvent) UpdateKubernetesTaskHandler {
 	return &cloudUpdateTaskHandler{
-		eventHandler: &CallBackEvent{TopicName: constants.CloudInit, ClusterUsecase: clusterUsecase},
-		handledTask:  make(map[string]string),
+		clusterTaskRunner: newClusterTaskRunner(eventHandler),
 	}
 }
 
 // HandleMsg -
 func (h *cloudUpdateTaskHandler) HandleMsg(ctx context.Context, config types.UpdateKubernetesConfigMessage) error {
-	if _, exist := h.handledTask[config.TaskID]; exist {
-		logrus.Infof("task %s is running or complete,ignore", config.TaskID)
-		return nil
-	}
-	initTask, err := CreateTask(UpdateKubernetesTask, config.Config)
-	if err != nil {
-		logrus.Errorf("create task failure %s", err.Error())
-		_ = h.eventHandler.HandleEvent(config.GetEvent(&v1.Message{
-			StepType: "CreateTask",
-			Message:  err.Error(),
-			Status:   "failure",
-		}))
-		return nil
-	}
-	// Asynchronous execution to prevent message consumption from taking too long.
-	// Idempotent consumption of messages is not currently supported
-	go h.run(ctx, initTask, config)
-	h.handledTask[config.TaskID] = "running"
-	return nil
+	return h.handle(ctx, config.TaskID, func() (Task, error) {
+		return CreateTask(domain.ClusterTaskTypeUpdateKubernetes, config.Config)
+	})
 }
 
 // HandleMessage implements the Handler interface.
@@ -121,28 +64,3 @@ func (h *cloudUpdateTaskHandler) HandleMessage(m *nsq.Message) error {
 	}
 	return nil
 }
-
-func (h *cloudUpdateTaskHandler) run(ctx context.Context, initTask Task, initConfig types.UpdateKubernetesConfigMessage) {
-	defer func() {
-		h.handledTask[initConfig.TaskID] = "complete"
-	}()
-	defer func() {
-		if err := recover(); err != nil {
-			debug.PrintStack()
-		}
-	}()
-	closeChan := make(chan struct{})
-	go func() {
-		defer close(closeChan)
-		for message := range initTask.GetChan() {
-			if message.StepType == "Close" {
-				return
-			}
-			_ = h.eventHandler.HandleEvent(initConfig.GetEvent(&message))
-		}
-	}()
-	initTask.Run(ctx)
-	//waiting message handle complete
-	<-closeChan
-	logrus.Infof("update kubernetes task %s handle success", initConfig.TaskID)
-}
@@ -0,0 +1,91 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package task
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	v1 "github.com/wutong-paas/cloud-adaptor/api/cloud-adaptor/v1"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+	"github.com/wutong-paas/cloud-adaptor/internal/types"
+)
+
+type fakeEventRecorder struct {
+	lock   sync.Mutex
+	events []*v1.EventMessage
+}
+
+func (f *fakeEventRecorder) CreateTaskEvent(em *v1.EventMessage) (*model.TaskEvent, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	// the message is reused by the caller
+	message := *em.Message
+	f.events = append(f.events, &v1.EventMessage{TaskID: em.TaskID, Message: &message})
+	return &model.TaskEvent{TaskID: em.TaskID}, nil
+}
+
+func (f *fakeEventRecorder) RecordTaskCancelled(taskID string) error { return nil }
+
+func (f *fakeEventRecorder) count(stepType, status string) map[string]int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	res := make(map[string]int)
+	for _, em := range f.events {
+		if em.Message.StepType == stepType && em.Message.Status == status {
+			res[em.TaskID]++
+		}
+	}
+	return res
+}
+
+// the workers of the consumer call HandleMsg concurrently, a task delivered twice runs once
+func TestCloudUpdateTaskHandlerConcurrent(t *testing.T) {
+	recorder := &fakeEventRecorder{}
+	handler := NewCloudUpdateTaskHandler(&CallBackEvent{ClusterUsecase: recorder})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		taskID := fmt.Sprintf("t%d", i/2)
+		wg.Add(1)
+		go func() {
+			var once sync.Once
+			ctx := WithDone(context.Background(), func() { once.Do(wg.Done) })
+			err := handler.HandleMsg(ctx, types.UpdateKubernetesConfigMessage{
+				TaskID: taskID,
+				Config: &v1alpha1.ExpansionNode{Provider: "unknown", ClusterID: "c1"},
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	failed := recorder.count("Init", "failure")
+	if len(failed) != 4 {
+		t.Fatalf("want 4 tasks run, got %v", failed)
+	}
+	for taskID, times := range failed {
+		if times != 1 {
+			t.Errorf("want task %s run once, got %d", taskID, times)
+		}
+	}
+}
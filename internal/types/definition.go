@@ -0,0 +1,174 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// TaskPayload the payload of a task message
+type TaskPayload interface {
+	GetTaskID() string
+	// Target returns the provider and the cluster of the task
+	Target() (provider, clusterID string)
+	// Mutating returns false if the task does not change the cluster, it can run along with the other tasks of the cluster.
+	Mutating() bool
+}
+
+// ClusterLocker locks the clusters changed by the running tasks, so only one of them runs at a time.
+type ClusterLocker interface {
+	// LockCluster returns false if the cluster is locked by another task, the lock held by the same task is taken over.
+	LockCluster(clusterID, taskID, owner string) (bool, error)
+	UnlockCluster(taskID string) error
+}
+
+// TaskMessage the message of task, Name is the name of its definition
+type TaskMessage struct {
+	Name    string
+	Payload TaskPayload
+}
+
+// TaskHandler handles the task messages. HandleTask starts the task and returns, the task calls task.Done(ctx) once it completes.
+type TaskHandler interface {
+	HandleTask(ctx context.Context, payload TaskPayload) error
+}
+
+// TaskHandlerFunc adapts a func to TaskHandler
+type TaskHandlerFunc func(ctx context.Context, payload TaskPayload) error
+
+// HandleTask calls f(ctx, payload)
+func (f TaskHandlerFunc) HandleTask(ctx context.Context, payload TaskPayload) error {
+	return f(ctx, payload)
+}
+
+// TaskDefinition defines a kind of long-running task. A new kind of task is plugged in by registering its definition,
+// the queue, the consumer and the task events handle it by the definition.
+type TaskDefinition struct {
+	// Name the name of task, it is the topic of the task messages as well
+	Name string
+	// Schema the JSON schema of payload, the payload is validated when it is sent and received
+	Schema string
+	// NewPayload returns a pointer to the zero payload to unmarshal the message into
+	NewPayload func() TaskPayload
+	Handler    TaskHandler
+	// Lifecycle the state machine of the status of task, the task is created by ClusterUsecase.CreateTask with it.
+	// It is nil for the definitions of create, init and update, the tasks of them are saved to their own tables
+	// and follow the lifecycles of their domain.ClusterTaskType.
+	Lifecycle *domain.TaskLifecycle
+	// Resume sets the payload of the interrupted task to skip the succeeded steps, and returns false if the task
+	// can not be resumed. The interrupted task is failed if Resume is nil.
+	Resume func(payload TaskPayload, succeededSteps []string) bool
+}
+
+// TaskRegistry the registry of task definitions
+type TaskRegistry struct {
+	lock    sync.RWMutex
+	defs    map[string]*TaskDefinition
+	schemas map[string]*gojsonschema.Schema
+}
+
+// NewTaskRegistry creates an empty registry
+func NewTaskRegistry() *TaskRegistry {
+	return &TaskRegistry{
+		defs:    make(map[string]*TaskDefinition),
+		schemas: make(map[string]*gojsonschema.Schema),
+	}
+}
+
+// Register registers the definition, the name of definition must be unique.
+func (r *TaskRegistry) Register(def *TaskDefinition) error {
+	if def.Name == "" || def.NewPayload == nil || def.Handler == nil {
+		return fmt.Errorf("task definition %q must have a name, a payload and a handler", def.Name)
+	}
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewStringLoader(def.Schema))
+	if err != nil {
+		return fmt.Errorf("invalid schema of task %s: %v", def.Name, err)
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.defs[def.Name]; ok {
+		return fmt.Errorf("task %s is registered", def.Name)
+	}
+	r.defs[def.Name] = def
+	r.schemas[def.Name] = schema
+	return nil
+}
+
+// Get returns the definition of name
+func (r *TaskRegistry) Get(name string) (*TaskDefinition, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	def, ok := r.defs[name]
+	return def, ok
+}
+
+// List returns the definitions sorted by name
+func (r *TaskRegistry) List() []*TaskDefinition {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	defs := make([]*TaskDefinition, 0, len(r.defs))
+	for _, def := range r.defs {
+		defs = append(defs, def)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Name < defs[j].Name })
+	return defs
+}
+
+// Validate validates the payload of task name against its schema
+func (r *TaskRegistry) Validate(name string, body []byte) error {
+	r.lock.RLock()
+	schema, ok := r.schemas[name]
+	r.lock.RUnlock()
+	if !ok {
+		return fmt.Errorf("task %s is not registered", name)
+	}
+	res, err := schema.Validate(gojsonschema.NewBytesLoader(body))
+	if err != nil {
+		return fmt.Errorf("validate payload of task %s: %v", name, err)
+	}
+	if !res.Valid() {
+		var reasons []string
+		for _, e := range res.Errors() {
+			reasons = append(reasons, e.String())
+		}
+		return fmt.Errorf("invalid payload of task %s: %s", name, strings.Join(reasons, "; "))
+	}
+	return nil
+}
+
+// Decode validates the payload of task name, and unmarshals it.
+func (r *TaskRegistry) Decode(name string, body []byte) (*TaskDefinition, TaskPayload, error) {
+	if err := r.Validate(name, body); err != nil {
+		return nil, nil, err
+	}
+	def, _ := r.Get(name)
+	payload := def.NewPayload()
+	if err := json.Unmarshal(body, payload); err != nil {
+		return nil, nil, fmt.Errorf("unmarshal payload of task %s: %v", name, err)
+	}
+	return def, payload, nil
+}
@@ -29,6 +29,9 @@ type InitWutongConfig struct {
 	AccessKey string `json:"access_key"`
 	SecretKey string `json:"secret_key"`
 	Provider  string `json:"provider"`
+	TaskID    string `json:"task_id,omitempty"`
+	// CompletedSteps the steps completed by the previous task, they are skipped when retry
+	CompletedSteps []string `json:"completed_steps,omitempty"`
 }
 
 // KubernetesConfigMessage nsq message
@@ -43,6 +46,22 @@ type UpdateKubernetesConfigMessage struct {
 	Config *v1alpha1.ExpansionNode `json:"config,omitempty"`
 }
 
+// ClusterOperation the config of the operation on a cluster, Target must accept the nil config
+type ClusterOperation interface {
+	Target() (provider, clusterID string)
+}
+
+// ClusterTaskMessage the message of the task running the operation C on a cluster
+type ClusterTaskMessage[C ClusterOperation] struct {
+	TaskID string `json:"task_id,omitempty"`
+	Config C      `json:"config"`
+}
+
+// EtcdSnapshotMessage the message of the task saving an etcd snapshot of cluster
+type EtcdSnapshotMessage struct {
+	ClusterTaskMessage[*v1alpha1.EtcdSnapshot]
+}
+
 // InitWutongConfigMessage nsq message
 type InitWutongConfigMessage struct {
 	TaskID           string            `json:"task_id,omitempty"`
@@ -72,3 +91,79 @@ func (i UpdateKubernetesConfigMessage) GetEvent(m *v1.Message) v1.EventMessage {
 		Message: m,
 	}
 }
+
+// GetTaskID returns the id of task
+func (i InitWutongConfigMessage) GetTaskID() string {
+	return i.TaskID
+}
+
+// GetTaskID returns the id of task
+func (i KubernetesConfigMessage) GetTaskID() string {
+	return i.TaskID
+}
+
+// GetTaskID returns the id of task
+func (i UpdateKubernetesConfigMessage) GetTaskID() string {
+	return i.TaskID
+}
+
+// Mutating returns true, initializing wutong changes the cluster
+func (i InitWutongConfigMessage) Mutating() bool {
+	return true
+}
+
+// Mutating returns true, creating the cluster changes it
+func (i KubernetesConfigMessage) Mutating() bool {
+	return true
+}
+
+// Target returns the provider and the cluster of the task
+func (i InitWutongConfigMessage) Target() (provider, clusterID string) {
+	if i.InitWutongConfig == nil {
+		return "", ""
+	}
+	return i.InitWutongConfig.Provider, i.InitWutongConfig.ClusterID
+}
+
+// Target returns the provider and the cluster of the task
+func (i KubernetesConfigMessage) Target() (provider, clusterID string) {
+	if i.KubernetesConfig == nil {
+		return "", ""
+	}
+	return i.KubernetesConfig.Provider, i.KubernetesConfig.ClusterID
+}
+
+// Target returns the provider and the cluster of the task
+func (i UpdateKubernetesConfigMessage) Target() (provider, clusterID string) {
+	return i.Config.Target()
+}
+
+// Mutating returns true, expanding the nodes changes the cluster
+func (i UpdateKubernetesConfigMessage) Mutating() bool {
+	return true
+}
+
+// GetTaskID returns the id of task
+func (i ClusterTaskMessage[C]) GetTaskID() string {
+	return i.TaskID
+}
+
+// GetConfig returns the config of the operation
+func (i ClusterTaskMessage[C]) GetConfig() C {
+	return i.Config
+}
+
+// Target returns the provider and the cluster of the task
+func (i ClusterTaskMessage[C]) Target() (provider, clusterID string) {
+	return i.Config.Target()
+}
+
+// Mutating returns true, the operations change the cluster except saving an etcd snapshot
+func (i ClusterTaskMessage[C]) Mutating() bool {
+	return true
+}
+
+// Mutating returns false, saving an etcd snapshot can run along with the other tasks of the cluster.
+func (i EtcdSnapshotMessage) Mutating() bool {
+	return false
+}
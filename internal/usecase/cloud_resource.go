@@ -0,0 +1,368 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package usecase
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/factory"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+	"github.com/wutong-paas/cloud-adaptor/internal/operator"
+	"github.com/wutong-paas/cloud-adaptor/internal/repo"
+	"github.com/wutong-paas/cloud-adaptor/pkg/bcode"
+	wutongv1alpha1 "github.com/wutong-paas/wutong-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// releaseOrder the resources depended by others are released later
+var releaseOrder = map[string]int{
+	string(v1alpha1.ResourceTypeNASMountTarget):    0,
+	string(v1alpha1.ResourceTypeSecurityGroupRule): 1,
+	string(v1alpha1.ResourceTypeSLB):               2,
+	string(v1alpha1.ResourceTypeNAS):               3,
+	string(v1alpha1.ResourceTypeRDS):               4,
+}
+
+// CloudResourceUsecase manage the cloud resources created by adaptors
+type CloudResourceUsecase struct {
+	cloudResourceRepo  repo.CloudResourceRepository
+	cloudAccessKeyRepo repo.CloudAccesskeyRepository
+	initWutongTaskRepo repo.InitWutongTaskRepository
+	taskEventRepo      repo.TaskEventRepository
+	// regionStatus returns the status of the wutong region in the cluster
+	regionStatus func(provider, clusterID string) (*v1alpha1.WutongRegionStatus, error)
+	// clusterIDs returns the clusters listed by the provider, nil if they can not be listed
+	clusterIDs func(provider string) map[string]bool
+	// clusterNotFound returns true only if the provider confirms the cluster does not exist
+	clusterNotFound func(provider, clusterID string) bool
+	// lock make sure only one reconcile or release is running
+	lock sync.Mutex
+}
+
+// NewCloudResourceUsecase -
+func NewCloudResourceUsecase(cloudResourceRepo repo.CloudResourceRepository,
+	cloudAccessKeyRepo repo.CloudAccesskeyRepository,
+	initWutongTaskRepo repo.InitWutongTaskRepository,
+	taskEventRepo repo.TaskEventRepository) *CloudResourceUsecase {
+	c := &CloudResourceUsecase{
+		cloudResourceRepo:  cloudResourceRepo,
+		cloudAccessKeyRepo: cloudAccessKeyRepo,
+		initWutongTaskRepo: initWutongTaskRepo,
+		taskEventRepo:      taskEventRepo,
+	}
+	c.regionStatus = c.getWutongRegionStatus
+	c.clusterIDs = c.listClusterIDs
+	c.clusterNotFound = c.describeClusterNotFound
+	return c
+}
+
+// ListByClusterID list the cloud resources created for the cluster
+func (c *CloudResourceUsecase) ListByClusterID(clusterID string) ([]*model.CloudResource, error) {
+	return c.cloudResourceRepo.ListByClusterID(clusterID)
+}
+
+// Run reconcile the cloud resources periodically.
+// The orphans are released only if autoRelease is true, otherwise they are marked and wait for manual release.
+func (c *CloudResourceUsecase) Run(ctx context.Context, interval time.Duration, autoRelease bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		orphans, err := c.Reconcile(ctx)
+		if err != nil {
+			logrus.Errorf("reconcile cloud resources failure %s", err.Error())
+			continue
+		}
+		if len(orphans) == 0 {
+			continue
+		}
+		logrus.Warningf("found %d orphan cloud resources", len(orphans))
+		if autoRelease {
+			if _, err := c.ReleaseOrphans(ctx); err != nil {
+				logrus.Errorf("release orphan cloud resources failure %s", err.Error())
+			}
+		}
+	}
+}
+
+// Reconcile find the orphan resources and mark them. A resource is orphan if
+// its cluster does not exist any more, or the last init task of its cluster failed.
+// The cluster missing from the cluster list does not exist only if describing it says so,
+// the list may be incomplete or not cover the region of the cluster.
+// The resources are never orphan while the last init task of the cluster is running.
+func (c *CloudResourceUsecase) Reconcile(ctx context.Context) ([]*model.CloudResource, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	resources, err := c.cloudResourceRepo.ListByState(model.CloudResourceStateCreated, model.CloudResourceStateOrphan)
+	if err != nil {
+		return nil, err
+	}
+	// the clusters of each provider, nil if the cluster list can not be got
+	clusters := make(map[string]map[string]bool)
+	// the clusters missing from the list which are confirmed not found
+	notFound := make(map[string]bool)
+	var orphans []*model.CloudResource
+	for _, resource := range resources {
+		if _, ok := clusters[resource.Provider]; !ok {
+			clusters[resource.Provider] = c.clusterIDs(resource.Provider)
+		}
+		if ids := clusters[resource.Provider]; ids != nil && !ids[resource.ClusterID] {
+			if _, ok := notFound[resource.ClusterID]; !ok {
+				notFound[resource.ClusterID] = c.clusterNotFound(resource.Provider, resource.ClusterID)
+			}
+		}
+		initTaskState := c.lastInitTaskState(resource.Provider, resource.ClusterID)
+		var reason string
+		switch {
+		case initTaskState == initTaskStateRunning:
+			// the resources may be reused by the running task, even if the cluster can not be found for now.
+		case notFound[resource.ClusterID]:
+			reason = "the cluster does not exist"
+		case initTaskState == initTaskStateFailed:
+			reason = "the init task of the cluster failed"
+		}
+		if reason == "" {
+			if resource.State == model.CloudResourceStateOrphan {
+				// a new task is running or the cluster comes back
+				if err := c.cloudResourceRepo.UpdateState(resource.ID, model.CloudResourceStateCreated, ""); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if resource.State != model.CloudResourceStateOrphan {
+			if err := c.cloudResourceRepo.UpdateState(resource.ID, model.CloudResourceStateOrphan, reason); err != nil {
+				return nil, err
+			}
+			resource.State = model.CloudResourceStateOrphan
+			resource.Message = reason
+		}
+		orphans = append(orphans, resource)
+	}
+	return orphans, nil
+}
+
+// ReleaseOrphans release the resources marked as orphan by Reconcile
+func (c *CloudResourceUsecase) ReleaseOrphans(ctx context.Context) ([]*model.CloudResource, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	orphans, err := c.cloudResourceRepo.ListByState(model.CloudResourceStateOrphan)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(orphans, func(i, j int) bool {
+		return releaseOrder[orphans[i].Type] < releaseOrder[orphans[j].Type]
+	})
+	adaptors := make(map[string]adaptor.CloudResourceAdaptor)
+	// the init task of the cluster may be retried since the resources are marked,
+	// the resources are in use again if a new init task is running.
+	initTaskStates := make(map[string]string)
+	for _, resource := range orphans {
+		if _, ok := initTaskStates[resource.ClusterID]; !ok {
+			initTaskStates[resource.ClusterID] = c.lastInitTaskState(resource.Provider, resource.ClusterID)
+		}
+		if initTaskStates[resource.ClusterID] == initTaskStateRunning {
+			logrus.Infof("the init task of cluster %s is running, skip releasing cloud resource %s %s", resource.ClusterID, resource.Type, resource.CloudID)
+			resource.State = model.CloudResourceStateCreated
+			resource.Message = ""
+			if err := c.cloudResourceRepo.UpdateState(resource.ID, resource.State, resource.Message); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		ad, ok := adaptors[resource.Provider]
+		if !ok {
+			ad, err = c.getResourceAdaptor(resource.Provider)
+			if err != nil {
+				logrus.Errorf("create adaptor of %s failure %s", resource.Provider, err.Error())
+			}
+			adaptors[resource.Provider] = ad
+		}
+		if ad == nil {
+			resource.Message = "the provider does not support release cloud resource"
+		} else if err := ad.ReleaseResource(ctx, &v1alpha1.CloudResource{
+			ClusterID: resource.ClusterID,
+			Type:      v1alpha1.CloudResourceType(resource.Type),
+			CloudID:   resource.CloudID,
+			RegionID:  resource.RegionID,
+			ParentID:  resource.ParentID,
+			Spec:      resource.Spec,
+		}); err != nil {
+			logrus.Errorf("release cloud resource %s %s failure %s", resource.Type, resource.CloudID, err.Error())
+			resource.Message = err.Error()
+		} else {
+			logrus.Infof("cloud resource %s %s of cluster %s released", resource.Type, resource.CloudID, resource.ClusterID)
+			resource.State = model.CloudResourceStateReleased
+			resource.Message = ""
+		}
+		if err := c.cloudResourceRepo.UpdateState(resource.ID, resource.State, resource.Message); err != nil {
+			return nil, err
+		}
+	}
+	return orphans, nil
+}
+
+func (c *CloudResourceUsecase) getClusterAdaptor(provider string) (adaptor.WutongClusterAdaptor, error) {
+	accessKey, err := c.cloudAccessKeyRepo.GetByProvider(provider)
+	if err != nil {
+		return nil, bcode.ErrorNotFoundAccessKey
+	}
+	ad, err := factory.GetCloudFactory().GetWutongClusterAdaptor(provider, accessKey.AccessKey, accessKey.SecretKey)
+	if err != nil {
+		return nil, bcode.ErrorProviderNotSupport
+	}
+	return ad, nil
+}
+
+func (c *CloudResourceUsecase) getResourceAdaptor(provider string) (adaptor.CloudResourceAdaptor, error) {
+	ad, err := c.getClusterAdaptor(provider)
+	if err != nil {
+		return nil, err
+	}
+	ra, ok := ad.(adaptor.CloudResourceAdaptor)
+	if !ok {
+		return nil, nil
+	}
+	return ra, nil
+}
+
+// listClusterIDs returns nil if the clusters of the provider can not be listed
+func (c *CloudResourceUsecase) listClusterIDs(provider string) map[string]bool {
+	accessKey, err := c.cloudAccessKeyRepo.GetByProvider(provider)
+	if err != nil {
+		logrus.Warningf("get access key of %s failure %s", provider, err.Error())
+		return nil
+	}
+	ad, err := factory.GetCloudFactory().GetWutongClusterAdaptor(provider, accessKey.AccessKey, accessKey.SecretKey)
+	if err != nil {
+		logrus.Warningf("create adaptor of %s failure %s", provider, err.Error())
+		return nil
+	}
+	clusters, err := ad.ClusterList()
+	if err != nil {
+		logrus.Warningf("list clusters of %s failure %s", provider, err.Error())
+		return nil
+	}
+	ids := make(map[string]bool)
+	for _, cluster := range clusters {
+		ids[cluster.ClusterID] = true
+	}
+	return ids
+}
+
+// describeClusterNotFound describe the cluster missing from the cluster list,
+// any error other than ErrClusterNotFound means the cluster may still exist.
+func (c *CloudResourceUsecase) describeClusterNotFound(provider, clusterID string) bool {
+	ad, err := c.getClusterAdaptor(provider)
+	if err != nil {
+		logrus.Warningf("create adaptor of %s failure %s", provider, err.Error())
+		return false
+	}
+	if _, err := ad.DescribeCluster(clusterID); err != nil {
+		if errors.Is(err, bcode.ErrClusterNotFound) {
+			return true
+		}
+		logrus.Warningf("describe cluster %s of %s failure %s", clusterID, provider, err.Error())
+	}
+	return false
+}
+
+const (
+	initTaskStateNone      = ""
+	initTaskStateRunning   = "running"
+	initTaskStateFailed    = "failed"
+	initTaskStateSucceeded = "succeeded"
+)
+
+// lastInitTaskState returns the state of the last init task of the cluster. The task is running
+// until the wutong region is initialized, any step fails or it is cancelled.
+// The failed steps may succeed in the cluster later, such as the wutong region comes up after
+// InitWutongRegion timed out, so the task failed only if the wutong region is not created in the cluster.
+func (c *CloudResourceUsecase) lastInitTaskState(provider, clusterID string) string {
+	task, err := c.initWutongTaskRepo.GetTaskByClusterID(provider, clusterID)
+	if err != nil {
+		if !errors.Is(err, bcode.ErrInitWutongTaskNotFound) {
+			logrus.Warningf("get init task of cluster %s failure %s", clusterID, err.Error())
+		}
+		return initTaskStateNone
+	}
+	events, err := c.taskEventRepo.ListEvent(task.TaskID)
+	if err != nil {
+		logrus.Warningf("list events of task %s failure %s", task.TaskID, err.Error())
+		// the state is unknown, keep the resources
+		return initTaskStateRunning
+	}
+	var failed bool
+	for _, event := range events {
+		if domain.TaskStep(event.StepType) == domain.TaskStepInitWutongRegion && event.Status == domain.TaskEventSuccess {
+			return initTaskStateSucceeded
+		}
+		if event.Status == domain.TaskEventFailure {
+			failed = true
+		}
+	}
+	if failed {
+		return c.checkWutongRegion(provider, clusterID)
+	}
+	return initTaskStateRunning
+}
+
+// checkWutongRegion returns the state of the failed init task by the wutong region in the cluster.
+// The state is running if the region is created but not running yet, or its status is unknown.
+func (c *CloudResourceUsecase) checkWutongRegion(provider, clusterID string) string {
+	status, err := c.regionStatus(provider, clusterID)
+	if err != nil {
+		if k8sErrors.IsNotFound(errors.Cause(err)) {
+			return initTaskStateFailed
+		}
+		logrus.Warningf("get wutong region status of cluster %s failure %s", clusterID, err.Error())
+		return initTaskStateRunning
+	}
+	if idx, condition := status.WutongCluster.Status.GetCondition(wutongv1alpha1.WutongClusterConditionTypeRunning); idx != -1 && condition.Status == corev1.ConditionTrue {
+		return initTaskStateSucceeded
+	}
+	return initTaskStateRunning
+}
+
+func (c *CloudResourceUsecase) getWutongRegionStatus(provider, clusterID string) (*v1alpha1.WutongRegionStatus, error) {
+	ad, err := c.getClusterAdaptor(provider)
+	if err != nil {
+		return nil, err
+	}
+	kubeConfig, err := ad.GetKubeConfig(clusterID)
+	if err != nil {
+		return nil, errors.Wrap(err, "get kube config")
+	}
+	return operator.NewWutongRegionInit(*kubeConfig, nil, nil).GetWutongRegionStatus(clusterID)
+}
@@ -0,0 +1,295 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	v1 "github.com/wutong-paas/cloud-adaptor/api/cloud-adaptor/v1"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+	"github.com/wutong-paas/cloud-adaptor/internal/repo"
+	"github.com/wutong-paas/cloud-adaptor/pkg/bcode"
+	wutongv1alpha1 "github.com/wutong-paas/wutong-operator/api/v1alpha1"
+	"gorm.io/gorm"
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+type fakeCloudResourceRepo struct {
+	repo.CloudResourceRepository
+	resources []*model.CloudResource
+}
+
+func (f *fakeCloudResourceRepo) ListByState(states ...string) ([]*model.CloudResource, error) {
+	var resources []*model.CloudResource
+	for _, resource := range f.resources {
+		for _, state := range states {
+			if resource.State == state {
+				copied := *resource
+				resources = append(resources, &copied)
+			}
+		}
+	}
+	return resources, nil
+}
+
+func (f *fakeCloudResourceRepo) Transaction(tx *gorm.DB) repo.CloudResourceRepository {
+	return f
+}
+
+func (f *fakeCloudResourceRepo) UpdateStateByClusterID(clusterID, state string, types ...string) error {
+	for _, resource := range f.resources {
+		if resource.ClusterID != clusterID {
+			continue
+		}
+		for _, resourceType := range types {
+			if resource.Type == resourceType {
+				resource.State = state
+			}
+		}
+		if len(types) == 0 {
+			resource.State = state
+		}
+	}
+	return nil
+}
+
+func (f *fakeCloudResourceRepo) UpdateState(id uint, state, message string) error {
+	for _, resource := range f.resources {
+		if resource.ID == id {
+			resource.State = state
+			resource.Message = message
+		}
+	}
+	return nil
+}
+
+type fakeCloudAccessKeyRepo struct {
+	repo.CloudAccesskeyRepository
+}
+
+func (f *fakeCloudAccessKeyRepo) GetByProvider(providerName string) (*model.CloudAccessKey, error) {
+	return nil, errors.New("not found")
+}
+
+type fakeInitWutongTaskRepo struct {
+	repo.InitWutongTaskRepository
+	tasks map[string]*model.InitWutongTask
+}
+
+func (f *fakeInitWutongTaskRepo) GetTaskByClusterID(providerName, clusterID string) (*model.InitWutongTask, error) {
+	task, ok := f.tasks[clusterID]
+	if !ok {
+		return nil, bcode.ErrInitWutongTaskNotFound
+	}
+	return task, nil
+}
+
+func (f *fakeInitWutongTaskRepo) GetTask(taskID string) (*model.InitWutongTask, error) {
+	return nil, gorm.ErrRecordNotFound
+}
+
+type fakeCreateKubernetesTaskRepo struct {
+	repo.CreateKubernetesTaskRepository
+}
+
+func (f *fakeCreateKubernetesTaskRepo) GetTask(taskID string) (*model.CreateKubernetesTask, error) {
+	return nil, gorm.ErrRecordNotFound
+}
+
+type fakeUpdateKubernetesTaskRepo struct {
+	repo.UpdateKubernetesTaskRepository
+}
+
+func (f *fakeUpdateKubernetesTaskRepo) GetTask(taskID string) (*model.UpdateKubernetesTask, error) {
+	return nil, gorm.ErrRecordNotFound
+}
+
+type fakeTaskRepo struct {
+	repo.TaskRepository
+	tasks map[string]*model.Task
+}
+
+func (f *fakeTaskRepo) GetTask(taskID string) (*model.Task, error) {
+	task, ok := f.tasks[taskID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return task, nil
+}
+
+type fakeTaskEventRepo struct {
+	repo.TaskEventRepository
+	events map[string][]*model.TaskEvent
+}
+
+func (f *fakeTaskEventRepo) ListEvent(taskID string) ([]*model.TaskEvent, error) {
+	return f.events[taskID], nil
+}
+
+func TestCloudResourceOrphans(t *testing.T) {
+	resources := &fakeCloudResourceRepo{resources: []*model.CloudResource{
+		{Model: model.Model{ID: 1}, TaskID: "failed", ClusterID: "c1", Provider: "ack", State: model.CloudResourceStateCreated},
+		{Model: model.Model{ID: 2}, TaskID: "failed", ClusterID: "c2", Provider: "ack", State: model.CloudResourceStateCreated},
+		{Model: model.Model{ID: 3}, TaskID: "succeeded", ClusterID: "c3", Provider: "ack", State: model.CloudResourceStateCreated},
+		{Model: model.Model{ID: 4}, TaskID: "timeout", ClusterID: "c4", Provider: "ack", State: model.CloudResourceStateCreated},
+	}}
+	tasks := &fakeInitWutongTaskRepo{tasks: map[string]*model.InitWutongTask{
+		"c1": {TaskID: "failed", ClusterID: "c1"},
+		"c2": {TaskID: "failed", ClusterID: "c2"},
+		"c3": {TaskID: "succeeded", ClusterID: "c3"},
+		"c4": {TaskID: "timeout", ClusterID: "c4"},
+	}}
+	events := &fakeTaskEventRepo{events: map[string][]*model.TaskEvent{
+		"failed":    {{TaskID: "failed", StepType: string(domain.TaskStepCreateRDS), Status: domain.TaskEventFailure}},
+		"succeeded": {{TaskID: "succeeded", StepType: string(domain.TaskStepInitWutongRegion), Status: domain.TaskEventSuccess}},
+		"timeout":   {{TaskID: "timeout", StepType: string(domain.TaskStepInitWutongRegion), Status: domain.TaskEventFailure}},
+	}}
+	c := NewCloudResourceUsecase(resources, &fakeCloudAccessKeyRepo{}, tasks, events)
+	// the wutong region of c4 comes up after the init task timed out
+	c.regionStatus = func(provider, clusterID string) (*v1alpha1.WutongRegionStatus, error) {
+		if clusterID != "c4" {
+			return nil, k8sErrors.NewNotFound(schema.GroupResource{Resource: "wutongclusters"}, "wutongcluster")
+		}
+		cluster := &wutongv1alpha1.WutongCluster{}
+		cluster.Status.UpdateCondition(&wutongv1alpha1.WutongClusterCondition{
+			Type:   wutongv1alpha1.WutongClusterConditionTypeRunning,
+			Status: corev1.ConditionTrue,
+		})
+		return &v1alpha1.WutongRegionStatus{WutongCluster: cluster}, nil
+	}
+
+	orphans, err := c.Reconcile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 2 {
+		t.Fatalf("want 2 orphans, got %d", len(orphans))
+	}
+
+	// the init task of c2 is retried after the resources are marked
+	tasks.tasks["c2"] = &model.InitWutongTask{TaskID: "retry", ClusterID: "c2"}
+	if _, err := c.ReleaseOrphans(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := map[uint]string{
+		1: model.CloudResourceStateOrphan,
+		2: model.CloudResourceStateCreated,
+		3: model.CloudResourceStateCreated,
+		4: model.CloudResourceStateCreated,
+	}
+	for _, resource := range resources.resources {
+		if resource.State != want[resource.ID] {
+			t.Errorf("resource %d: want state %s, got %s", resource.ID, want[resource.ID], resource.State)
+		}
+	}
+
+	// the resources are kept while the retried task is running
+	orphans, err = c.Reconcile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 1 || orphans[0].ID != 1 {
+		t.Fatalf("want resource 1 orphan, got %v", orphans)
+	}
+}
+
+func TestCloudResourceOrphansOfMissingClusters(t *testing.T) {
+	resources := &fakeCloudResourceRepo{resources: []*model.CloudResource{
+		{Model: model.Model{ID: 1}, ClusterID: "listed", Provider: "tke", State: model.CloudResourceStateCreated},
+		{Model: model.Model{ID: 2}, ClusterID: "unlisted", Provider: "tke", State: model.CloudResourceStateCreated},
+		{Model: model.Model{ID: 3}, ClusterID: "deleted", Provider: "tke", State: model.CloudResourceStateCreated},
+	}}
+	c := NewCloudResourceUsecase(resources, &fakeCloudAccessKeyRepo{}, &fakeInitWutongTaskRepo{}, &fakeTaskEventRepo{})
+	// the cluster list is incomplete, unlisted is not in it but still exists
+	c.clusterIDs = func(provider string) map[string]bool {
+		return map[string]bool{"listed": true}
+	}
+	var described []string
+	c.clusterNotFound = func(provider, clusterID string) bool {
+		described = append(described, clusterID)
+		return clusterID == "deleted"
+	}
+
+	orphans, err := c.Reconcile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 1 || orphans[0].ID != 3 {
+		t.Fatalf("want resource 3 orphan, got %v", orphans)
+	}
+	if len(described) != 2 {
+		t.Fatalf("want the missing clusters described once each, got %v", described)
+	}
+}
+
+func TestReleaseDestroyedCloudResources(t *testing.T) {
+	resources := &fakeCloudResourceRepo{resources: []*model.CloudResource{
+		{Model: model.Model{ID: 1}, ClusterID: "c1", Type: string(v1alpha1.ResourceTypeSLB), State: model.CloudResourceStateCreated},
+		{Model: model.Model{ID: 2}, ClusterID: "c1", Type: string(v1alpha1.ResourceTypeRDS), State: model.CloudResourceStateCreated},
+		{Model: model.Model{ID: 3}, ClusterID: "c2", Type: string(v1alpha1.ResourceTypeSLB), State: model.CloudResourceStateCreated},
+	}}
+	c := NewClusterUsecase(nil, nil, nil, &fakeCreateKubernetesTaskRepo{}, &fakeInitWutongTaskRepo{}, &fakeUpdateKubernetesTaskRepo{},
+		nil, nil, nil, nil, nil, &fakeTaskRepo{tasks: map[string]*model.Task{
+			"destroy": {TaskID: "destroy", ClusterID: "c1", TaskType: string(domain.ClusterTaskTypeDestroyCluster)},
+			"upgrade": {TaskID: "upgrade", ClusterID: "c2", TaskType: string(domain.ClusterTaskTypeUpgradeKubernetes)},
+		}}, resources, nil, nil, nil)
+
+	event := func(taskID string, step domain.TaskStep, status string) *v1.EventMessage {
+		return &v1.EventMessage{TaskID: taskID, Message: &v1.Message{StepType: string(step), Status: status}}
+	}
+	release := func(em *v1.EventMessage) error {
+		task, err := c.getTask(em.TaskID)
+		if err != nil {
+			return err
+		}
+		return c.releaseCloudResources(nil, task, em)
+	}
+	for _, em := range []*v1.EventMessage{
+		event("destroy", domain.TaskStepDeleteLoadBalancer, domain.TaskEventSuccess),
+		event("destroy", domain.TaskStepDeleteRDS, domain.TaskEventFailure),
+		event("upgrade", domain.TaskStepDestroyCluster, domain.TaskEventSuccess),
+	} {
+		if err := release(em); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := map[uint]string{
+		1: model.CloudResourceStateReleased,
+		2: model.CloudResourceStateCreated,
+		3: model.CloudResourceStateCreated,
+	}
+	for _, resource := range resources.resources {
+		if resource.State != want[resource.ID] {
+			t.Errorf("resource %d: want state %s, got %s", resource.ID, want[resource.ID], resource.State)
+		}
+	}
+
+	if err := release(event("destroy", domain.TaskStepDestroyCluster, domain.TaskEventSuccess)); err != nil {
+		t.Fatal(err)
+	}
+	if resources.resources[1].State != model.CloudResourceStateReleased {
+		t.Errorf("want all the resources of the destroyed cluster released, got %s", resources.resources[1].State)
+	}
+}
@@ -48,6 +48,7 @@ import (
 	"github.com/wutong-paas/cloud-adaptor/pkg/util/constants"
 	"github.com/wutong-paas/cloud-adaptor/pkg/util/md5util"
 	"github.com/wutong-paas/cloud-adaptor/pkg/util/uuidutil"
+	"github.com/wutong-paas/cloud-adaptor/pkg/util/versionutil"
 	wutongv1alpha1 "github.com/wutong-paas/wutong-operator/api/v1alpha1"
 	"github.com/wutong-paas/wutong-operator/util/wtutil"
 	"gopkg.in/yaml.v2"
@@ -56,6 +57,7 @@ import (
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/version"
 	"k8s.io/client-go/kubernetes"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -72,6 +74,13 @@ type ClusterUsecase struct {
 	WutongClusterConfigRepo  repo.WutongClusterConfigRepository
 	rkeClusterRepo           repo.RKEClusterRepository
 	customClusterRepo        repo.CustomClusterRepository
+	taskQueue                repo.TaskQueueRepository
+	taskRepo                 repo.TaskRepository
+	cloudResourceRepo        repo.CloudResourceRepository
+	clusterLocker            types.ClusterLocker
+	taskRegistry             *types.TaskRegistry
+	taskEvents               *taskEventBroker
+	webhooks                 *WebhookUsecase
 }
 
 // NewClusterUsecase new cluster usecase
@@ -85,6 +94,12 @@ func NewClusterUsecase(db *gorm.DB,
 	WutongClusterConfigRepo repo.WutongClusterConfigRepository,
 	rkeClusterRepo repo.RKEClusterRepository,
 	customClusterRepo repo.CustomClusterRepository,
+	taskQueue repo.TaskQueueRepository,
+	taskRepo repo.TaskRepository,
+	cloudResourceRepo repo.CloudResourceRepository,
+	clusterLocker types.ClusterLocker,
+	taskRegistry *types.TaskRegistry,
+	webhooks *WebhookUsecase,
 ) *ClusterUsecase {
 	return &ClusterUsecase{
 		DB:                       db,
@@ -97,27 +112,58 @@ func NewClusterUsecase(db *gorm.DB,
 		WutongClusterConfigRepo:  WutongClusterConfigRepo,
 		rkeClusterRepo:           rkeClusterRepo,
 		customClusterRepo:        customClusterRepo,
+		taskQueue:                taskQueue,
+		taskRepo:                 taskRepo,
+		cloudResourceRepo:        cloudResourceRepo,
+		clusterLocker:            clusterLocker,
+		taskRegistry:             taskRegistry,
+		taskEvents:               newTaskEventBroker(),
+		webhooks:                 webhooks,
 	}
 }
 
+// ListProviders list the registered providers
+func (c *ClusterUsecase) ListProviders() []*adaptor.Provider {
+	return adaptor.ListProviders()
+}
+
+// getProviderAccessKey get the access key of the provider, returns nil if the provider does not need credentials.
+func (c *ClusterUsecase) getProviderAccessKey(providerName string) (*model.CloudAccessKey, error) {
+	provider, ok := adaptor.GetProvider(providerName)
+	if !ok {
+		return nil, bcode.ErrorProviderNotSupport
+	}
+	if !provider.Capabilities.NeedCredentials {
+		return nil, nil
+	}
+	accessKey, err := c.CloudAccessKeyRepo.GetByProvider(providerName)
+	if err != nil {
+		return nil, bcode.ErrorNotFoundAccessKey
+	}
+	return accessKey, nil
+}
+
+func (c *ClusterUsecase) getWutongClusterAdaptor(providerName string) (adaptor.WutongClusterAdaptor, error) {
+	accessKey, err := c.getProviderAccessKey(providerName)
+	if err != nil {
+		return nil, err
+	}
+	var accessKeyID, accessKeySecret string
+	if accessKey != nil {
+		accessKeyID, accessKeySecret = accessKey.AccessKey, accessKey.SecretKey
+	}
+	ad, err := factory.GetCloudFactory().GetWutongClusterAdaptor(providerName, accessKeyID, accessKeySecret)
+	if err != nil {
+		return nil, bcode.ErrorProviderNotSupport
+	}
+	return ad, nil
+}
+
 // ListKubernetesCluster list kubernetes cluster
 func (c *ClusterUsecase) ListKubernetesCluster(re v1.ListKubernetesCluster) ([]*v1alpha1.Cluster, error) {
-	var ad adaptor.WutongClusterAdaptor
-	var err error
-	if re.ProviderName != "rke" && re.ProviderName != "custom" {
-		accessKey, err := c.CloudAccessKeyRepo.GetByProvider(re.ProviderName)
-		if err != nil {
-			return nil, bcode.ErrorNotFoundAccessKey
-		}
-		ad, err = factory.GetCloudFactory().GetWutongClusterAdaptor(re.ProviderName, accessKey.AccessKey, accessKey.SecretKey)
-		if err != nil {
-			return nil, bcode.ErrorProviderNotSupport
-		}
-	} else {
-		ad, err = factory.GetCloudFactory().GetWutongClusterAdaptor(re.ProviderName, "", "")
-		if err != nil {
-			return nil, bcode.ErrorProviderNotSupport
-		}
+	ad, err := c.getWutongClusterAdaptor(re.ProviderName)
+	if err != nil {
+		return nil, err
 	}
 	clusters, err := ad.ClusterList()
 	if err != nil {
@@ -166,16 +212,7 @@ func (c *ClusterUsecase) CreateKubernetesCluster(req v1.CreateKubernetesReq) (*m
 
 	var rkeConfig v3.RancherKubernetesEngineConfig
 	if req.Provider == "rke" {
-		rkeCluster := &model.RKECluster{
-			Name:      req.Name,
-			Stats:     v1alpha1.InitState,
-			ClusterID: clusterID,
-		}
-		// Only the request to successfully create the rke cluster can send the task
-		if err := c.rkeClusterRepo.Create(rkeCluster); err != nil {
-			return nil, err
-		}
-
+		// the config is validated before the cluster is created, a bad request leaves no cluster behind
 		decRKEConfig, err := base64.StdEncoding.DecodeString(req.EncodedRKEConfig)
 		if err != nil {
 			return nil, errors.Wrap(bcode.ErrIncorrectRKEConfig, "decode encoded rke config")
@@ -191,16 +228,25 @@ func (c *ClusterUsecase) CreateKubernetesCluster(req v1.CreateKubernetesReq) (*m
 		if err := nodeList.Validate(); err != nil {
 			return nil, err
 		}
-	}
+		if err := c.setBastions(&rkeConfig, req.Bastion, req.NodeBastions); err != nil {
+			return nil, err
+		}
 
-	var accessKey *model.CloudAccessKey
-	var err error
-	if req.Provider != "rke" && req.Provider != "custom" {
-		accessKey, err = c.CloudAccessKeyRepo.GetByProvider(req.Provider)
-		if err != nil {
-			return nil, bcode.ErrorNotFoundAccessKey
+		rkeCluster := &model.RKECluster{
+			Name:      req.Name,
+			Stats:     v1alpha1.InitState,
+			ClusterID: clusterID,
+		}
+		// Only the request to successfully create the rke cluster can send the task
+		if err := c.rkeClusterRepo.Create(rkeCluster); err != nil {
+			return nil, err
 		}
 	}
+
+	accessKey, err := c.getProviderAccessKey(req.Provider)
+	if err != nil {
+		return nil, err
+	}
 	newTask := &model.CreateKubernetesTask{
 		Name:               req.Name,
 		Provider:           req.Provider,
@@ -218,17 +264,21 @@ func (c *ClusterUsecase) CreateKubernetesCluster(req v1.CreateKubernetesReq) (*m
 		TaskID: newTask.TaskID,
 		KubernetesConfig: &v1alpha1.KubernetesClusterConfig{
 			ClusterName:        newTask.Name,
+			ClusterID:          newTask.ClusterID,
 			WorkerResourceType: newTask.WorkerResourceType,
 			WorkerNodeNum:      newTask.WorkerNum,
 			Provider:           newTask.Provider,
 			Region:             newTask.Region,
 			RKEConfig:          &rkeConfig,
+			Preflight:          req.Preflight,
+			SSHKeyType:         req.SSHKeyType,
+			NodeBastions:       req.NodeBastions,
 		}}
 	if accessKey != nil {
 		taskReq.KubernetesConfig.AccessKey = accessKey.AccessKey
 		taskReq.KubernetesConfig.SecretKey = accessKey.SecretKey
 	}
-	if err := c.TaskProducer.SendCreateKuerbetesTask(taskReq); err != nil {
+	if err := c.TaskProducer.SendTask(constants.CloudCreate, taskReq); err != nil {
 		logrus.Errorf("send create kubernetes task failure %s", err.Error())
 	} else {
 		if err := c.CreateKubernetesTaskRepo.UpdateStatus(newTask.TaskID, "start"); err != nil {
@@ -301,7 +351,7 @@ func (c *ClusterUsecase) InitWutongRegion(ctx context.Context, req v1.InitWutong
 	if err != nil && !errors.Is(err, bcode.ErrInitWutongTaskNotFound) {
 		return nil, err
 	}
-	if oldTask != nil && !req.Retry {
+	if oldTask != nil && !req.Retry && oldTask.Status != TaskStatusCancelled {
 		return oldTask, bcode.ErrorLastTaskNotComplete
 	}
 
@@ -309,12 +359,9 @@ func (c *ClusterUsecase) InitWutongRegion(ctx context.Context, req v1.InitWutong
 		return nil, err
 	}
 
-	var accessKey *model.CloudAccessKey
-	if req.Provider != "rke" && req.Provider != "custom" {
-		accessKey, err = c.CloudAccessKeyRepo.GetByProvider(req.Provider)
-		if err != nil {
-			return nil, bcode.ErrorNotFoundAccessKey
-		}
+	accessKey, err := c.getProviderAccessKey(req.Provider)
+	if err != nil {
+		return nil, err
 	}
 	newTask := &model.InitWutongTask{
 		TaskID:    uuidutil.NewUUID(),
@@ -336,7 +383,19 @@ func (c *ClusterUsecase) InitWutongRegion(ctx context.Context, req v1.InitWutong
 		initTask.InitWutongConfig.AccessKey = accessKey.AccessKey
 		initTask.InitWutongConfig.SecretKey = accessKey.SecretKey
 	}
-	if err := c.TaskProducer.SendInitWutongRegionTask(initTask); err != nil {
+	if oldTask != nil && req.Retry {
+		// resume from the first step that has not succeeded in the previous task
+		events, err := c.TaskEventRepo.ListEvent(oldTask.TaskID)
+		if err != nil {
+			logrus.Warningf("list events of task %s failure %s", oldTask.TaskID, err.Error())
+		}
+		for _, event := range events {
+			if event.Status == "success" {
+				initTask.InitWutongConfig.CompletedSteps = append(initTask.InitWutongConfig.CompletedSteps, event.StepType)
+			}
+		}
+	}
+	if err := c.TaskProducer.SendTask(constants.CloudInit, initTask); err != nil {
 		logrus.Errorf("send init wutong region task failure %s", err.Error())
 	} else {
 		if err := c.InitWutongTaskRepo.UpdateStatus(newTask.TaskID, "start"); err != nil {
@@ -347,66 +406,598 @@ func (c *ClusterUsecase) InitWutongRegion(ctx context.Context, req v1.InitWutong
 	return newTask, nil
 }
 
-// UpdateKubernetesCluster -
-func (c *ClusterUsecase) UpdateKubernetesCluster(req v1.UpdateKubernetesReq) (*v1.UpdateKubernetesTask, error) {
-	if c.TaskProducer == nil {
-		logrus.Errorf("TaskProducer is nil")
-		return nil, bcode.ServerErr
+// UpdateKubernetesCluster -
+func (c *ClusterUsecase) UpdateKubernetesCluster(req v1.UpdateKubernetesReq) (*v1.UpdateKubernetesTask, error) {
+	if c.TaskProducer == nil {
+		logrus.Errorf("TaskProducer is nil")
+		return nil, bcode.ServerErr
+	}
+	if provider, ok := adaptor.GetProvider(req.Provider); !ok || !provider.Capabilities.ExpansionNode {
+		return nil, bcode.ErrNotSupportUpdateKubernetes
+	}
+
+	en := &v1alpha1.ExpansionNode{
+		Provider:  req.Provider,
+		ClusterID: req.ClusterID,
+	}
+	var nodeNumber int
+	if req.Provider == "rke" {
+		decodedRkeConfig, err := base64.StdEncoding.DecodeString(req.EncodedRKEConfig)
+		if err != nil {
+			logrus.Errorf("decode encoded rke config: %v", err)
+			return nil, errors.Wrap(bcode.ErrIncorrectRKEConfig, "decode encoded rke config")
+		}
+		var rkeConfig v3.RancherKubernetesEngineConfig
+		if err := yaml.Unmarshal(decodedRkeConfig, &rkeConfig); err != nil {
+			logrus.Errorf("unmarshal rke config: %v", err)
+			return nil, errors.Wrap(bcode.ErrIncorrectRKEConfig, "unmarshal rke config")
+		}
+		if err := c.setBastions(&rkeConfig, req.Bastion, req.NodeBastions); err != nil {
+			return nil, err
+		}
+		en.RKEConfig = &rkeConfig
+		en.Preflight = req.Preflight
+		en.NodeBastions = req.NodeBastions
+		nodeNumber = len(rkeConfig.Nodes)
+	} else {
+		if req.WorkerNodeNum <= 0 {
+			return nil, errors.WithMessage(bcode.BadRequest, "the number of worker nodes to be added must be greater than 0")
+		}
+		accessKey, err := c.getProviderAccessKey(req.Provider)
+		if err != nil {
+			return nil, err
+		}
+		if accessKey != nil {
+			en.AccessKey = accessKey.AccessKey
+			en.SecretKey = accessKey.SecretKey
+		}
+		en.WorkerNodeNum = req.WorkerNodeNum
+		en.WorkerResourceType = req.WorkerResourceType
+		en.InstanceType = req.InstanceType
+		nodeNumber = req.WorkerNodeNum
+	}
+
+	// check if the last task is complete
+	version, err := c.isLastTaskComplete(req.ClusterID)
+	if err != nil {
+		return nil, err
+	}
+
+	newTask := &model.UpdateKubernetesTask{
+		TaskID:     uuidutil.NewUUID(),
+		Provider:   req.Provider,
+		ClusterID:  req.ClusterID,
+		NodeNumber: nodeNumber,
+		Version:    version + 1, // optimistic lock
+	}
+	if err := c.UpdateKubernetesTaskRepo.Create(newTask); err != nil {
+		return nil, errors.Wrap(err, "save update kubernetes task failure")
+	}
+
+	//send task
+	taskReq := types.UpdateKubernetesConfigMessage{
+		TaskID: newTask.TaskID,
+		Config: en,
+	}
+	if err := c.TaskProducer.SendTask(constants.CloudUpdate, taskReq); err != nil {
+		logrus.Errorf("send create kubernetes task failure %s", err.Error())
+	} else {
+		if err := c.UpdateKubernetesTaskRepo.UpdateStatus(newTask.TaskID, string(domain.TaskStatusStart)); err != nil {
+			logrus.Errorf("update task status failure %s", err.Error())
+		}
+	}
+	logrus.Infof("send create kubernetes task %s to queue", newTask.TaskID)
+	return &v1.UpdateKubernetesTask{
+		TaskID:     newTask.TaskID,
+		Provider:   newTask.Provider,
+		ClusterID:  newTask.ClusterID,
+		NodeNumber: newTask.NodeNumber,
+	}, nil
+}
+
+// UpgradeKubernetesCluster upgrade the kubernetes version of cluster
+func (c *ClusterUsecase) UpgradeKubernetesCluster(clusterID string, req v1.UpgradeKubernetesReq) (*v1.UpdateKubernetesTask, error) {
+	if provider, ok := adaptor.GetProvider(req.Provider); !ok || !provider.Capabilities.UpgradeKubernetes {
+		return nil, bcode.ErrNotSupportUpgradeKubernetes
+	}
+	cluster, err := c.GetCluster(req.Provider, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkUpgradeVersion(cluster.CurrentVersion, req.KubernetesVersion); err != nil {
+		return nil, err
+	}
+	accessKey, err := c.getProviderAccessKey(req.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	upgrade := &v1alpha1.UpgradeKubernetes{
+		Provider:          req.Provider,
+		ClusterID:         clusterID,
+		KubernetesVersion: req.KubernetesVersion,
+		SystemImages:      req.SystemImages,
+		UpgradeStrategy:   req.UpgradeStrategy,
+	}
+	if accessKey != nil {
+		upgrade.AccessKey = accessKey.AccessKey
+		upgrade.SecretKey = accessKey.SecretKey
+	}
+	task, err := c.sendClusterTask(string(domain.ClusterTaskTypeUpgradeKubernetes), &types.ClusterTaskMessage[*v1alpha1.UpgradeKubernetes]{
+		TaskID: uuidutil.NewUUID(),
+		Config: upgrade,
+	}, cluster.Size)
+	if err != nil {
+		return nil, err
+	}
+	task.KubernetesVersion = req.KubernetesVersion
+	return task, nil
+}
+
+// checkUpgradeVersion the target version must be supported and newer than the current version.
+func checkUpgradeVersion(current, target string) error {
+	targetVersion, err := version.ParseGeneric(target)
+	if err != nil {
+		return errors.WithMessage(bcode.ErrKubernetesVersionNotUpgradable, err.Error())
+	}
+	if !versionutil.CheckVersion(target) {
+		return errors.WithMessagef(bcode.ErrKubernetesVersionNotUpgradable, "the version %s is not supported", target)
+	}
+	currentVersion, err := version.ParseGeneric(current)
+	if err != nil {
+		// the current version is unknown, let rke check it
+		return nil
+	}
+	if !currentVersion.LessThan(targetVersion) {
+		return errors.WithMessagef(bcode.ErrKubernetesVersionNotUpgradable, "the version %s is not newer than the current version %s", target, current)
+	}
+	return nil
+}
+
+// SaveEtcdSnapshot save an etcd snapshot of cluster
+func (c *ClusterUsecase) SaveEtcdSnapshot(clusterID string, req v1.EtcdSnapshotReq) (*v1.UpdateKubernetesTask, error) {
+	snapshot, err := c.newEtcdSnapshot(clusterID, req)
+	if err != nil {
+		return nil, err
+	}
+	return c.sendClusterTask(string(domain.ClusterTaskTypeEtcdSnapshot), &types.EtcdSnapshotMessage{
+		ClusterTaskMessage: types.ClusterTaskMessage[*v1alpha1.EtcdSnapshot]{
+			TaskID: uuidutil.NewUUID(),
+			Config: snapshot,
+		},
+	}, 0)
+}
+
+// RestoreEtcdSnapshot restore cluster from the named etcd snapshot
+func (c *ClusterUsecase) RestoreEtcdSnapshot(clusterID string, req v1.EtcdSnapshotReq) (*v1.UpdateKubernetesTask, error) {
+	if req.Name == "" {
+		return nil, errors.WithMessage(bcode.BadRequest, "the name of snapshot is required")
+	}
+	snapshot, err := c.newEtcdSnapshot(clusterID, req)
+	if err != nil {
+		return nil, err
+	}
+	return c.sendClusterTask(string(domain.ClusterTaskTypeEtcdRestore), &types.ClusterTaskMessage[*v1alpha1.EtcdSnapshot]{
+		TaskID: uuidutil.NewUUID(),
+		Config: snapshot,
+	}, 0)
+}
+
+// ListEtcdSnapshots list the etcd snapshots of cluster
+func (c *ClusterUsecase) ListEtcdSnapshots(ctx context.Context, clusterID, providerName string) (*v1.EtcdSnapshotListRes, error) {
+	if provider, ok := adaptor.GetProvider(providerName); !ok || !provider.Capabilities.EtcdSnapshot {
+		return nil, bcode.ErrNotSupportEtcdSnapshot
+	}
+	ad, err := c.getWutongClusterAdaptor(providerName)
+	if err != nil {
+		return nil, err
+	}
+	snapshotAdaptor, ok := ad.(adaptor.EtcdSnapshotAdaptor)
+	if !ok {
+		return nil, bcode.ErrNotSupportEtcdSnapshot
+	}
+	snapshots, err := snapshotAdaptor.ListEtcdSnapshots(ctx, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	re := &v1.EtcdSnapshotListRes{Snapshots: snapshots}
+	if providerName == "rke" {
+		cluster, err := c.rkeClusterRepo.GetCluster(clusterID)
+		if err != nil {
+			return nil, err
+		}
+		rkeConfig, err := c.getRKEConfig(cluster)
+		if err != nil {
+			return nil, err
+		}
+		if rkeConfig != nil {
+			re.BackupConfig = rkeConfig.Services.Etcd.BackupConfig
+		}
+	}
+	return re, nil
+}
+
+// SetEtcdBackupConfig set the scheduled etcd snapshot config of cluster.
+// The config is applied by updating the cluster with the new rke config.
+func (c *ClusterUsecase) SetEtcdBackupConfig(clusterID string, req v1.EtcdBackupConfigReq) (*v1.UpdateKubernetesTask, error) {
+	if provider, ok := adaptor.GetProvider(req.Provider); !ok || !provider.Capabilities.EtcdSnapshot {
+		return nil, bcode.ErrNotSupportEtcdSnapshot
+	}
+	cluster, err := c.rkeClusterRepo.GetCluster(clusterID)
+	if err != nil {
+		return nil, err
+	}
+	rkeConfig, err := c.getRKEConfig(cluster)
+	if err != nil {
+		return nil, err
+	}
+	if rkeConfig == nil {
+		return nil, bcode.ErrRKEConfigLost
+	}
+	rkeConfig.Services.Etcd.BackupConfig = req.BackupConfig
+	encoded, err := yaml.Marshal(rkeConfig)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return c.UpdateKubernetesCluster(v1.UpdateKubernetesReq{
+		Provider:         req.Provider,
+		ClusterID:        clusterID,
+		EncodedRKEConfig: base64.StdEncoding.EncodeToString(encoded),
+	})
+}
+
+func (c *ClusterUsecase) newEtcdSnapshot(clusterID string, req v1.EtcdSnapshotReq) (*v1alpha1.EtcdSnapshot, error) {
+	if provider, ok := adaptor.GetProvider(req.Provider); !ok || !provider.Capabilities.EtcdSnapshot {
+		return nil, bcode.ErrNotSupportEtcdSnapshot
+	}
+	accessKey, err := c.getProviderAccessKey(req.Provider)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &v1alpha1.EtcdSnapshot{
+		Provider:  req.Provider,
+		ClusterID: clusterID,
+		Name:      req.Name,
+	}
+	if accessKey != nil {
+		snapshot.AccessKey = accessKey.AccessKey
+		snapshot.SecretKey = accessKey.SecretKey
+	}
+	return snapshot, nil
+}
+
+// GetCertificates get the certificates of cluster and their expiration
+func (c *ClusterUsecase) GetCertificates(clusterID, providerName string) (*v1.CertificateListRes, error) {
+	certAdaptor, err := c.getCertificateAdaptor(providerName)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := certAdaptor.GetCertificates(clusterID)
+	if err != nil {
+		return nil, err
+	}
+	return &v1.CertificateListRes{Certificates: certs}, nil
+}
+
+// RotateCertificates rotate all certificates or the certificates of the given services
+func (c *ClusterUsecase) RotateCertificates(clusterID string, req v1.RotateCertificatesReq) (*v1.UpdateKubernetesTask, error) {
+	if _, err := c.getCertificateAdaptor(req.Provider); err != nil {
+		return nil, err
+	}
+	accessKey, err := c.getProviderAccessKey(req.Provider)
+	if err != nil {
+		return nil, err
+	}
+	rotate := &v1alpha1.RotateCertificates{
+		Provider:       req.Provider,
+		ClusterID:      clusterID,
+		CACertificates: req.CACertificates,
+		Services:       req.Services,
+	}
+	if accessKey != nil {
+		rotate.AccessKey = accessKey.AccessKey
+		rotate.SecretKey = accessKey.SecretKey
+	}
+	return c.sendClusterTask(string(domain.ClusterTaskTypeRotateCertificates), &types.ClusterTaskMessage[*v1alpha1.RotateCertificates]{
+		TaskID: uuidutil.NewUUID(),
+		Config: rotate,
+	}, 0)
+}
+
+func (c *ClusterUsecase) getCertificateAdaptor(providerName string) (adaptor.CertificateAdaptor, error) {
+	if provider, ok := adaptor.GetProvider(providerName); !ok || !provider.Capabilities.RotateCertificates {
+		return nil, bcode.ErrNotSupportRotateCertificates
+	}
+	ad, err := c.getWutongClusterAdaptor(providerName)
+	if err != nil {
+		return nil, err
+	}
+	certAdaptor, ok := ad.(adaptor.CertificateAdaptor)
+	if !ok {
+		return nil, bcode.ErrNotSupportRotateCertificates
+	}
+	return certAdaptor, nil
+}
+
+// Preflight check the nodes of the rke config, or the given nodes, over ssh
+func (c *ClusterUsecase) Preflight(ctx context.Context, req v1.PreflightReq) (*v1alpha1.PreflightReport, error) {
+	if provider, ok := adaptor.GetProvider(req.Provider); !ok || !provider.Capabilities.Preflight {
+		return nil, bcode.ErrNotSupportPreflight
+	}
+	ad, err := c.getWutongClusterAdaptor(req.Provider)
+	if err != nil {
+		return nil, err
+	}
+	preflightAdaptor, ok := ad.(adaptor.PreflightAdaptor)
+	if !ok {
+		return nil, bcode.ErrNotSupportPreflight
+	}
+	var rkeConfig v3.RancherKubernetesEngineConfig
+	if req.EncodedRKEConfig != "" {
+		decodedRKEConfig, err := base64.StdEncoding.DecodeString(req.EncodedRKEConfig)
+		if err != nil {
+			return nil, errors.Wrapf(bcode.ErrIncorrectRKEConfig, "decode encoded rke config: %v", err)
+		}
+		if err := yaml.Unmarshal(decodedRKEConfig, &rkeConfig); err != nil {
+			return nil, errors.Wrapf(bcode.ErrIncorrectRKEConfig, "unmarshal rke config: %v", err)
+		}
+	}
+	if len(req.Nodes) > 0 {
+		rkeConfig.Nodes = c.nodeListToRKEConfigNodes(req.Nodes)
+	}
+	if len(rkeConfig.Nodes) == 0 {
+		return nil, bcode.ErrClusterNodeEmpty
+	}
+	nodeBastions := req.Nodes.Bastions().Merge(req.NodeBastions)
+	if err := c.setBastions(&rkeConfig, req.Bastion, nodeBastions); err != nil {
+		return nil, err
+	}
+	return preflightAdaptor.Preflight(ctx, &v1alpha1.Preflight{
+		Provider:     req.Provider,
+		RKEConfig:    &rkeConfig,
+		NodeBastions: nodeBastions,
+	}), nil
+}
+
+// setBastions validate the bastion hosts, and set the one of cluster to the rke config
+func (c *ClusterUsecase) setBastions(rkeConfig *v3.RancherKubernetesEngineConfig, bastion *v1alpha1.BastionHost, nodeBastions v1alpha1.NodeBastions) error {
+	if bastion != nil {
+		if err := bastion.Validate(); err != nil {
+			return err
+		}
+		rkeConfig.BastionHost = bastion.RKEBastionHost()
+	}
+	return nodeBastions.Validate()
+}
+
+// ListClusterStates list the stored state versions of cluster
+func (c *ClusterUsecase) ListClusterStates(clusterID, providerName string) (*v1.ClusterStateListRes, error) {
+	stateAdaptor, err := c.getClusterStateAdaptor(providerName)
+	if err != nil {
+		return nil, err
+	}
+	states, err := stateAdaptor.ListClusterStates(clusterID)
+	if err != nil {
+		return nil, err
+	}
+	return &v1.ClusterStateListRes{States: states}, nil
+}
+
+// RollbackClusterState roll back the cluster state to the given version, it takes effect on the next cluster update.
+func (c *ClusterUsecase) RollbackClusterState(clusterID string, req v1.RollbackClusterStateReq) (*v1alpha1.ClusterStateVersion, error) {
+	stateAdaptor, err := c.getClusterStateAdaptor(req.Provider)
+	if err != nil {
+		return nil, err
+	}
+	// the state can not be changed while the cluster is being updated
+	if _, err := c.isLastTaskComplete(clusterID); err != nil {
+		return nil, err
+	}
+	return stateAdaptor.RollbackClusterState(clusterID, req.Version)
+}
+
+func (c *ClusterUsecase) getClusterStateAdaptor(providerName string) (adaptor.ClusterStateAdaptor, error) {
+	if provider, ok := adaptor.GetProvider(providerName); !ok || !provider.Capabilities.ClusterState {
+		return nil, bcode.ErrNotSupportClusterState
+	}
+	ad, err := c.getWutongClusterAdaptor(providerName)
+	if err != nil {
+		return nil, err
+	}
+	stateAdaptor, ok := ad.(adaptor.ClusterStateAdaptor)
+	if !ok {
+		return nil, bcode.ErrNotSupportClusterState
+	}
+	return stateAdaptor, nil
+}
+
+// GetSSHKey get the public part of the ssh key used to connect the nodes of cluster
+func (c *ClusterUsecase) GetSSHKey(clusterID, providerName string) (*v1alpha1.SSHKeyInfo, error) {
+	keyAdaptor, err := c.getSSHKeyAdaptor(providerName)
+	if err != nil {
+		return nil, err
+	}
+	return keyAdaptor.GetSSHKey(clusterID)
+}
+
+// RotateSSHKey replace the ssh key of cluster with a new one
+func (c *ClusterUsecase) RotateSSHKey(clusterID string, req v1.RotateSSHKeyReq) (*v1.UpdateKubernetesTask, error) {
+	if _, err := c.getSSHKeyAdaptor(req.Provider); err != nil {
+		return nil, err
+	}
+	accessKey, err := c.getProviderAccessKey(req.Provider)
+	if err != nil {
+		return nil, err
+	}
+	rotate := &v1alpha1.RotateSSHKey{
+		Provider:  req.Provider,
+		ClusterID: clusterID,
+		KeyType:   req.KeyType,
+	}
+	if accessKey != nil {
+		rotate.AccessKey = accessKey.AccessKey
+		rotate.SecretKey = accessKey.SecretKey
+	}
+	return c.sendClusterTask(string(domain.ClusterTaskTypeRotateSSHKey), &types.ClusterTaskMessage[*v1alpha1.RotateSSHKey]{
+		TaskID: uuidutil.NewUUID(),
+		Config: rotate,
+	}, 0)
+}
+
+func (c *ClusterUsecase) getSSHKeyAdaptor(providerName string) (adaptor.SSHKeyAdaptor, error) {
+	if provider, ok := adaptor.GetProvider(providerName); !ok || !provider.Capabilities.SSHKey {
+		return nil, bcode.ErrNotSupportSSHKey
+	}
+	ad, err := c.getWutongClusterAdaptor(providerName)
+	if err != nil {
+		return nil, err
+	}
+	keyAdaptor, ok := ad.(adaptor.SSHKeyAdaptor)
+	if !ok {
+		return nil, bcode.ErrNotSupportSSHKey
+	}
+	return keyAdaptor, nil
+}
+
+// GetSecretsEncryptionState get the secrets encryption state of cluster
+func (c *ClusterUsecase) GetSecretsEncryptionState(clusterID, providerName string) (*v1alpha1.SecretsEncryptionState, error) {
+	encryptionAdaptor, err := c.getSecretsEncryptionAdaptor(providerName)
+	if err != nil {
+		return nil, err
+	}
+	return encryptionAdaptor.GetSecretsEncryptionState(clusterID)
+}
+
+// EnableSecretsEncryption enable the secrets encryption at rest
+func (c *ClusterUsecase) EnableSecretsEncryption(clusterID string, req v1.SecretsEncryptionReq) (*v1.UpdateKubernetesTask, error) {
+	state, err := c.GetSecretsEncryptionState(clusterID, req.Provider)
+	if err != nil {
+		return nil, err
+	}
+	if state.Enabled {
+		return nil, bcode.ErrSecretsEncryptionEnabled
+	}
+	return c.sendSecretsEncryptionTask(clusterID, req.Provider, v1alpha1.SecretsEncryptionEnable, string(domain.ClusterTaskTypeEnableSecretsEncryption))
+}
+
+// RotateEncryptionKey rotate the secrets encryption key
+func (c *ClusterUsecase) RotateEncryptionKey(clusterID string, req v1.SecretsEncryptionReq) (*v1.UpdateKubernetesTask, error) {
+	state, err := c.GetSecretsEncryptionState(clusterID, req.Provider)
+	if err != nil {
+		return nil, err
+	}
+	if !state.Enabled {
+		return nil, bcode.ErrSecretsEncryptionNotEnabled
+	}
+	if state.CustomConfig {
+		return nil, bcode.ErrSecretsEncryptionCustomConfig
+	}
+	return c.sendSecretsEncryptionTask(clusterID, req.Provider, v1alpha1.SecretsEncryptionRotateKey, string(domain.ClusterTaskTypeRotateEncryptionKey))
+}
+
+func (c *ClusterUsecase) sendSecretsEncryptionTask(clusterID, providerName string, action v1alpha1.SecretsEncryptionAction, taskType string) (*v1.UpdateKubernetesTask, error) {
+	accessKey, err := c.getProviderAccessKey(providerName)
+	if err != nil {
+		return nil, err
+	}
+	encryption := &v1alpha1.SecretsEncryption{
+		Provider:  providerName,
+		ClusterID: clusterID,
+		Action:    action,
+	}
+	if accessKey != nil {
+		encryption.AccessKey = accessKey.AccessKey
+		encryption.SecretKey = accessKey.SecretKey
+	}
+	return c.sendClusterTask(taskType, &types.ClusterTaskMessage[*v1alpha1.SecretsEncryption]{
+		TaskID: uuidutil.NewUUID(),
+		Config: encryption,
+	}, 0)
+}
+
+func (c *ClusterUsecase) getSecretsEncryptionAdaptor(providerName string) (adaptor.SecretsEncryptionAdaptor, error) {
+	if provider, ok := adaptor.GetProvider(providerName); !ok || !provider.Capabilities.SecretsEncryption {
+		return nil, bcode.ErrNotSupportSecretsEncryption
 	}
-	if req.Provider != "rke" {
-		return nil, bcode.ErrNotSupportUpdateKubernetes
+	ad, err := c.getWutongClusterAdaptor(providerName)
+	if err != nil {
+		return nil, err
 	}
+	encryptionAdaptor, ok := ad.(adaptor.SecretsEncryptionAdaptor)
+	if !ok {
+		return nil, bcode.ErrNotSupportSecretsEncryption
+	}
+	return encryptionAdaptor, nil
+}
 
-	decodedRkeConfig, err := base64.StdEncoding.DecodeString(req.EncodedRKEConfig)
+// RemoveNodes remove nodes from cluster, the remaining nodes must be a valid cluster.
+func (c *ClusterUsecase) RemoveNodes(clusterID string, req v1.RemoveNodesReq) (*v1.UpdateKubernetesTask, error) {
+	if provider, ok := adaptor.GetProvider(req.Provider); !ok || !provider.Capabilities.RemoveNodes {
+		return nil, bcode.ErrNotSupportRemoveNodes
+	}
+	cluster, err := c.rkeClusterRepo.GetCluster(clusterID)
 	if err != nil {
-		logrus.Errorf("decode encoded rke config: %v", err)
-		return nil, errors.Wrap(bcode.ErrIncorrectRKEConfig, "decode encoded rke config")
+		return nil, err
 	}
-	var rkeConfig v3.RancherKubernetesEngineConfig
-	if err := yaml.Unmarshal(decodedRkeConfig, &rkeConfig); err != nil {
-		logrus.Errorf("unmarshal rke config: %v", err)
-		return nil, errors.Wrap(bcode.ErrIncorrectRKEConfig, "unmarshal rke config")
+	rkeConfig, err := c.getRKEConfig(cluster)
+	if err != nil {
+		return nil, err
 	}
-
-	// check if the last task is complete
-	version, err := c.isLastTaskComplete(req.ClusterID)
+	if rkeConfig == nil {
+		return nil, bcode.ErrRKEConfigLost
+	}
+	nodeList, err := c.rkeConfigToNodeList(rkeConfig)
+	if err != nil {
+		return nil, err
+	}
+	remaining, err := remainingNodes(nodeList, req.Nodes)
 	if err != nil {
 		return nil, err
 	}
+	if err := remaining.Validate(); err != nil {
+		return nil, err
+	}
 
-	newTask := &model.UpdateKubernetesTask{
-		TaskID:     uuidutil.NewUUID(),
-		Provider:   req.Provider,
-		ClusterID:  req.ClusterID,
-		NodeNumber: len(rkeConfig.Nodes),
-		Version:    version + 1, // optimistic lock
+	accessKey, err := c.getProviderAccessKey(req.Provider)
+	if err != nil {
+		return nil, err
 	}
-	if err := c.UpdateKubernetesTaskRepo.Create(newTask); err != nil {
-		return nil, errors.Wrap(err, "save update kubernetes task failure")
+	removeNodes := &v1alpha1.RemoveNodes{
+		Provider:  req.Provider,
+		ClusterID: clusterID,
+		Nodes:     req.Nodes,
+		Drain:     req.Drain,
+	}
+	if accessKey != nil {
+		removeNodes.AccessKey = accessKey.AccessKey
+		removeNodes.SecretKey = accessKey.SecretKey
 	}
+	return c.sendClusterTask(string(domain.ClusterTaskTypeRemoveNodes), &types.ClusterTaskMessage[*v1alpha1.RemoveNodes]{
+		TaskID: uuidutil.NewUUID(),
+		Config: removeNodes,
+	}, len(remaining))
+}
 
-	//send task
-	taskReq := types.UpdateKubernetesConfigMessage{
-		TaskID: newTask.TaskID,
-		Config: &v1alpha1.ExpansionNode{
-			Provider:  req.Provider,
-			ClusterID: req.ClusterID,
-			RKEConfig: &rkeConfig,
-		}}
-	if err := c.TaskProducer.SendUpdateKuerbetesTask(taskReq); err != nil {
-		logrus.Errorf("send create kubernetes task failure %s", err.Error())
-	} else {
-		if err := c.CreateKubernetesTaskRepo.UpdateStatus(newTask.TaskID, "start"); err != nil {
-			logrus.Errorf("update task status failure %s", err.Error())
+// remainingNodes returns the nodes remaining after the given nodes removed, all given nodes must be in the list.
+func remainingNodes(nodeList v1alpha1.NodeList, addresses []string) (v1alpha1.NodeList, error) {
+	exists := make(map[string]bool, len(nodeList))
+	for _, node := range nodeList {
+		exists[node.IP] = true
+	}
+	toRemove := make(map[string]bool, len(addresses))
+	for _, address := range addresses {
+		if !exists[address] {
+			return nil, errors.WithMessagef(bcode.ErrClusterNodeNotFound, "node %s not found", address)
 		}
+		toRemove[address] = true
 	}
-	logrus.Infof("send create kubernetes task %s to queue", newTask.TaskID)
-	return &v1.UpdateKubernetesTask{
-		TaskID:     newTask.TaskID,
-		Provider:   newTask.Provider,
-		ClusterID:  newTask.ClusterID,
-		NodeNumber: newTask.NodeNumber,
-	}, nil
+	var remaining v1alpha1.NodeList
+	for _, node := range nodeList {
+		if !toRemove[node.IP] {
+			remaining = append(remaining, node)
+		}
+	}
+	return remaining, nil
 }
 
 func (c *ClusterUsecase) isLastTaskComplete(clusterID string) (int, error) {
@@ -415,7 +1006,7 @@ func (c *ClusterUsecase) isLastTaskComplete(clusterID string) (int, error) {
 	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 		return 0, err
 	}
-	if updateTask != nil && updateTask.Status != "complete" {
+	if updateTask != nil && !isTaskFinished(updateTask.Status) {
 		return 0, errors.WithStack(bcode.ErrLastKubernetesTaskNotComplete)
 	}
 
@@ -424,7 +1015,16 @@ func (c *ClusterUsecase) isLastTaskComplete(clusterID string) (int, error) {
 	if err != nil && !errors.Is(err, bcode.ErrLastTaskNotFound) {
 		return 0, err
 	}
-	if createTask != nil && createTask.Status != "complete" {
+	if createTask != nil && !isTaskFinished(createTask.Status) {
+		return 0, errors.WithStack(bcode.ErrLastKubernetesTaskNotComplete)
+	}
+
+	// check if the tasks of the operations complete
+	tasks, err := c.taskRepo.ListUnfinished(clusterID, domain.TerminalTaskStatusValues())
+	if err != nil {
+		return 0, err
+	}
+	if len(tasks) > 0 {
 		return 0, errors.WithStack(bcode.ErrLastKubernetesTaskNotComplete)
 	}
 
@@ -509,6 +1109,14 @@ func (c *ClusterUsecase) getUpdateKubernetesTask(name, clusterID string) (interf
 	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
 		return nil, err
 	}
+	// the last task of the operations, such as upgrade
+	operation, err := c.taskRepo.GetLastTask(clusterID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if operation != nil && (update == nil || operation.CreatedAt.After(update.CreatedAt)) {
+		return operation, nil
+	}
 	if update != nil {
 		return update, nil
 	}
@@ -521,7 +1129,37 @@ func (c *ClusterUsecase) getUpdateKubernetesTask(name, clusterID string) (interf
 	return create, nil
 }
 
+// restoreClusterState restores the state dir of cluster unless a task is changing the cluster, the running task
+// owns the state dir then. The cluster is locked by the lock of the tasks while the state files are written.
+func (c *ClusterUsecase) restoreClusterState(cluster *model.RKECluster) {
+	stateAdaptor, err := c.getClusterStateAdaptor("rke")
+	if err != nil {
+		return
+	}
+	if c.clusterLocker != nil {
+		lockID := "restore-state-" + uuidutil.NewUUID()
+		locked, err := c.clusterLocker.LockCluster(cluster.ClusterID, lockID, lockID)
+		if err != nil {
+			logrus.Warningf("lock cluster %s to restore the state failure %s", cluster.Name, err.Error())
+			return
+		}
+		if !locked {
+			return
+		}
+		defer func() {
+			if err := c.clusterLocker.UnlockCluster(lockID); err != nil {
+				logrus.Warningf("unlock cluster %s failure %s", cluster.Name, err.Error())
+			}
+		}()
+	}
+	if err := stateAdaptor.RestoreClusterState(cluster.ClusterID); err != nil {
+		logrus.Warningf("restore cluster %s state failure %s", cluster.Name, err.Error())
+	}
+}
+
 func (c *ClusterUsecase) getRKEConfig(cluster *model.RKECluster) (*v3.RancherKubernetesEngineConfig, error) {
+	// the state dir may be lost or outdated on this replica
+	c.restoreClusterState(cluster)
 	configDir := os.Getenv("CONFIG_DIR")
 	if configDir == "" {
 		configDir = "/tmp"
@@ -547,6 +1185,10 @@ func (c *ClusterUsecase) getRKEConfig(cluster *model.RKECluster) (*v3.RancherKub
 	if err = yaml.Unmarshal(bytes, &rkeConfig); err != nil {
 		return nil, errors.WithStack(bcode.ErrIncorrectRKEConfig)
 	}
+	// the private key of cluster never leaves the adaptor, it is injected again by the adaptor.
+	for i := range rkeConfig.Nodes {
+		rkeConfig.Nodes[i].SSHKey = ""
+	}
 
 	return &rkeConfig, nil
 }
@@ -602,6 +1244,11 @@ func (c *ClusterUsecase) CreateTaskEvent(em *v1.EventMessage) (*model.TaskEvent,
 	if em.Message == nil {
 		return nil, fmt.Errorf("message is nil")
 	}
+	// the task is nil if the event is not of a cluster task
+	task, err := c.getTask(em.TaskID)
+	if err != nil && !errors.Is(err, bcode.ErrClusterTaskNotFound) {
+		return nil, err
+	}
 	ctx := c.DB.Begin()
 	ent := &model.TaskEvent{
 		TaskID:   em.TaskID,
@@ -616,39 +1263,14 @@ func (c *ClusterUsecase) CreateTaskEvent(em *v1.EventMessage) (*model.TaskEvent,
 		return nil, err
 	}
 
-	createKubernetesTaskRepo := c.CreateKubernetesTaskRepo.Transaction(ctx)
-	if (em.Message.StepType == "CreateCluster" || em.Message.StepType == "InstallKubernetes") && em.Message.Status == "success" {
-		if ckErr := createKubernetesTaskRepo.UpdateStatus(em.TaskID, "complete"); ckErr != nil && ckErr != gorm.ErrRecordNotFound {
-			ctx.Rollback()
-			return nil, ckErr
-		}
-		logrus.Infof("set create kubernetes task %s status is complete", em.TaskID)
-	}
-	initWutongTaskRepo := c.InitWutongTaskRepo.Transaction(ctx)
-	if em.Message.StepType == "InitWutongRegion" && em.Message.Status == "success" {
-		if err := initWutongTaskRepo.UpdateStatus(em.TaskID, "inited"); err != nil && err != gorm.ErrRecordNotFound {
-			ctx.Rollback()
-			return nil, err
-		}
-		logrus.Infof("set init task %s status is inited", em.TaskID)
-	}
-	if em.Message.StepType == "UpdateKubernetes" && em.Message.Status == "success" {
-		if err := c.UpdateKubernetesTaskRepo.Transaction(ctx).UpdateStatus(em.TaskID, "complete"); err != nil && err != gorm.ErrRecordNotFound {
-			ctx.Rollback()
-			return nil, err
-		}
-		logrus.Infof("set init task %s status is inited", em.TaskID)
+	if err := c.transitTaskStatus(ctx, task, em); err != nil {
+		ctx.Rollback()
+		return nil, err
 	}
-	if em.Message.Status == "failure" {
-		if initErr := initWutongTaskRepo.UpdateStatus(em.TaskID, "complete"); initErr != nil && initErr != gorm.ErrRecordNotFound {
-			ctx.Rollback()
-			return nil, initErr
-		}
 
-		if ckErr := createKubernetesTaskRepo.UpdateStatus(em.TaskID, "complete"); ckErr != nil && ckErr != gorm.ErrRecordNotFound {
-			ctx.Rollback()
-			return nil, ckErr
-		}
+	if err := c.releaseCloudResources(ctx, task, em); err != nil {
+		ctx.Rollback()
+		return nil, err
 	}
 
 	if err := ctx.Commit().Error; err != nil {
@@ -656,9 +1278,131 @@ func (c *ClusterUsecase) CreateTaskEvent(em *v1.EventMessage) (*model.TaskEvent,
 		return nil, err
 	}
 	logrus.Infof("save task %s event %s status %s to db", em.TaskID, em.Message.StepType, em.Message.Status)
+	// the event is not saved if it succeeded before
+	if ent.ID != 0 {
+		c.taskEvents.publish(ent)
+		if c.webhooks != nil {
+			event := *ent
+			go c.webhooks.Dispatch(&event, c.getTask)
+		}
+	}
 	return ent, nil
 }
 
+// transitTaskStatus moves the task to the next status of its lifecycle by the event.
+// The status is kept if the event is rejected by the lifecycle.
+func (c *ClusterUsecase) transitTaskStatus(tx *gorm.DB, task *domain.ClusterTask, em *v1.EventMessage) error {
+	if task == nil {
+		return nil
+	}
+	lifecycle := c.taskLifecycle(task.TaskType)
+	if lifecycle == nil {
+		return nil
+	}
+	current := domain.TaskStatus(task.Status)
+	if lifecycle.Terminal(current) {
+		// the events after the task is finished are kept, such as the logs of the cancelled task
+		return nil
+	}
+	next, err := lifecycle.Next(current, domain.TaskStep(em.Message.StepType), em.Message.Status)
+	if err != nil {
+		// the event is saved anyway, only the status of task is kept
+		logrus.Warningf("the event %s %s of %s task %s is rejected by its lifecycle: %v",
+			em.Message.StepType, em.Message.Status, task.TaskType, task.TaskID, err)
+		return nil
+	}
+	if next == current {
+		return nil
+	}
+	return c.saveTaskStatus(tx, task, next)
+}
+
+// releasedResourceTypes the types of the cloud resources deleted by the steps of the destroy task,
+// all the resources of cluster are deleted once the task succeeded.
+var releasedResourceTypes = map[domain.TaskStep][]string{
+	// the security group of cluster is deleted with the cluster
+	domain.TaskStepDeleteCluster:      {string(v1alpha1.ResourceTypeSecurityGroupRule)},
+	domain.TaskStepDeleteLoadBalancer: {string(v1alpha1.ResourceTypeSLB)},
+	domain.TaskStepDeleteNAS:          {string(v1alpha1.ResourceTypeNAS), string(v1alpha1.ResourceTypeNASMountTarget)},
+	domain.TaskStepDeleteRDS:          {string(v1alpha1.ResourceTypeRDS)},
+	domain.TaskStepDestroyCluster:     nil,
+}
+
+// releaseCloudResources marks the cloud resources of the destroyed cluster released once they are deleted by
+// the step of the destroy task, so that they are not released by the reconciler again.
+func (c *ClusterUsecase) releaseCloudResources(tx *gorm.DB, task *domain.ClusterTask, em *v1.EventMessage) error {
+	resourceTypes, ok := releasedResourceTypes[domain.TaskStep(em.Message.StepType)]
+	if !ok || em.Message.Status != domain.TaskEventSuccess {
+		return nil
+	}
+	if task == nil || task.TaskType != domain.ClusterTaskTypeDestroyCluster {
+		return nil
+	}
+	return c.cloudResourceRepo.Transaction(tx).UpdateStateByClusterID(task.ClusterID, model.CloudResourceStateReleased, resourceTypes...)
+}
+
+// saveTaskStatus saves the status of task to the table of its type. It is the only place the status of task
+// is changed by the events.
+func (c *ClusterUsecase) saveTaskStatus(tx *gorm.DB, task *domain.ClusterTask, status domain.TaskStatus) error {
+	var updateStatus func(taskID, status string) error
+	switch {
+	case task.TaskType == domain.ClusterTaskTypeInitWutong:
+		updateStatus = c.InitWutongTaskRepo.Transaction(tx).UpdateStatus
+	case task.TaskType == domain.ClusterTaskTypeCreateKubernetes:
+		updateStatus = c.CreateKubernetesTaskRepo.Transaction(tx).UpdateStatus
+	case task.TaskType == domain.ClusterTaskTypeUpdateKubernetes:
+		updateStatus = c.UpdateKubernetesTaskRepo.Transaction(tx).UpdateStatus
+	default:
+		updateStatus = c.taskRepo.Transaction(tx).UpdateStatus
+	}
+	if err := updateStatus(task.TaskID, string(status)); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	logrus.Infof("set %s task %s status is %s", task.TaskType, task.TaskID, status)
+	return nil
+}
+
+// taskLifecycle returns the lifecycle of the built-in task type, or of the task definition.
+func (c *ClusterUsecase) taskLifecycle(taskType domain.ClusterTaskType) *domain.TaskLifecycle {
+	if lifecycle := taskType.Lifecycle(); lifecycle != nil {
+		return lifecycle
+	}
+	if c.taskRegistry == nil {
+		return nil
+	}
+	if def, ok := c.taskRegistry.Get(string(taskType)); ok {
+		return def.Lifecycle
+	}
+	return nil
+}
+
+// isTaskTerminal returns true if the task is finished in status, the cluster is ready for a new task then.
+func (c *ClusterUsecase) isTaskTerminal(taskType domain.ClusterTaskType, status string) bool {
+	lifecycle := c.taskLifecycle(taskType)
+	if lifecycle == nil {
+		return isTaskFinished(status)
+	}
+	return lifecycle.Terminal(domain.TaskStatus(status))
+}
+
+func hasFailedEvent(events []*model.TaskEvent) bool {
+	for _, event := range events {
+		if event.Status == domain.TaskEventFailure {
+			return true
+		}
+	}
+	return false
+}
+
+// taskStepEvents converts the task events to the events of lifecycle
+func taskStepEvents(events []*model.TaskEvent) []domain.TaskStepEvent {
+	stepEvents := make([]domain.TaskStepEvent, 0, len(events))
+	for _, event := range events {
+		stepEvents = append(stepEvents, domain.TaskStepEvent{Step: domain.TaskStep(event.StepType), Status: event.Status})
+	}
+	return stepEvents
+}
+
 func (c *ClusterUsecase) reasonFromMessage(message string) string {
 	if strings.Contains(message, fmt.Sprintf("namespace %s because it is being terminated", constants.Namespace)) {
 		return "NamespaceBeingTerminated"
@@ -675,55 +1419,69 @@ func (c *ClusterUsecase) ListTaskEvent(taskID string) ([]*model.TaskEvent, error
 		}
 		return nil, err
 	}
+	return c.listTaskEvent(task)
+}
 
-	events, err := c.TaskEventRepo.ListEvent(taskID)
+// GetTaskEvents returns the events of task, with the plan of task and its progress.
+func (c *ClusterUsecase) GetTaskEvents(taskID string) (*v1.TaskEventListRes, error) {
+	task, err := c.getTask(taskID)
 	if err != nil {
+		if errors.Is(err, bcode.ErrClusterTaskNotFound) {
+			return &v1.TaskEventListRes{}, nil
+		}
 		return nil, err
 	}
+	events, err := c.listTaskEvent(task)
+	if err != nil {
+		return nil, err
+	}
+	res := &v1.TaskEventListRes{Events: events}
+	res.Steps, res.Progress = c.taskProgress(task, events)
+	return res, nil
+}
 
-	needSync := false
-	for i := range events {
-		event := events[i]
-		if (event.StepType == "CreateCluster" || event.StepType == "InstallKubernetes") && event.Status == "success" {
-			if ckErr := c.CreateKubernetesTaskRepo.UpdateStatus(event.TaskID, "complete"); ckErr != nil && ckErr != gorm.ErrRecordNotFound {
-				logrus.Errorf("set create kubernetes task %s status failure %s", event.TaskID, err.Error())
-			}
-			logrus.Infof("set create kubernetes task %s status is complete", event.TaskID)
-		}
-		if event.StepType == "InitWutongRegion" && event.Status == "success" {
-			if err := c.InitWutongTaskRepo.UpdateStatus(event.TaskID, "inited"); err != nil && err != gorm.ErrRecordNotFound {
-				logrus.Errorf("set init wutong task %s status failure %s", event.TaskID, err.Error())
-			}
-			logrus.Infof("set init task %s status is inited", event.TaskID)
-		}
-		if event.StepType == "UpdateKubernetes" && event.Status == "success" {
-			if err := c.UpdateKubernetesTaskRepo.UpdateStatus(event.TaskID, "complete"); err != nil && err != gorm.ErrRecordNotFound {
-				logrus.Errorf("set init wutong task %s status failure %s", event.TaskID, err.Error())
-			}
-			logrus.Infof("set init task %s status is inited", event.TaskID)
-		}
-		if event.Status == "failure" {
-			needSync = true
-			if initErr := c.InitWutongTaskRepo.UpdateStatus(event.TaskID, "complete"); initErr != nil && initErr != gorm.ErrRecordNotFound {
-				logrus.Errorf("set init wutong task %s status failure %s", event.TaskID, err.Error())
-			}
-
-			if ckErr := c.CreateKubernetesTaskRepo.UpdateStatus(event.TaskID, "complete"); ckErr != nil && ckErr != gorm.ErrRecordNotFound {
-				logrus.Errorf("set create kubernetes task %s status failure %s", event.TaskID, err.Error())
-			}
+// taskProgress returns the plan of task with the status of each step, and the progress of task.
+func (c *ClusterUsecase) taskProgress(task *domain.ClusterTask, events []*model.TaskEvent) ([]domain.TaskPlanStep, int) {
+	lifecycle := c.taskLifecycle(task.TaskType)
+	if lifecycle == nil {
+		return nil, 0
+	}
+	return lifecycle.Progress(task.ProviderName, taskStepEvents(events))
+}
 
-			if ckErr := c.UpdateKubernetesTaskRepo.UpdateStatus(event.TaskID, "complete"); ckErr != nil && ckErr != gorm.ErrRecordNotFound {
-				logrus.Errorf("set update kubernetes task %s status failure %s", event.TaskID, err.Error())
-			}
-		}
+func (c *ClusterUsecase) listTaskEvent(task *domain.ClusterTask) ([]*model.TaskEvent, error) {
+	taskID := task.TaskID
+	events, err := c.TaskEventRepo.ListEvent(taskID)
+	if err != nil {
+		return nil, err
 	}
 
-	if needSync {
+	lifecycle := c.taskLifecycle(task.TaskType)
+	if lifecycle == nil {
+		return events, nil
+	}
+	// the status of task is repaired by replaying the events if the task is not finished,
+	// or if the failed steps succeeded in the cluster later
+	repair := !lifecycle.Terminal(domain.TaskStatus(task.Status))
+	if hasFailedEvent(events) {
 		if err := c.syncTaskEvents(task, events); err != nil {
 			logrus.Errorf("sync task events: %v", err)
+		} else {
+			if events, err = c.TaskEventRepo.ListEvent(taskID); err != nil {
+				return nil, err
+			}
+			repair = true
+		}
+	}
+	if !repair {
+		return events, nil
+	}
+	status := lifecycle.Replay(taskStepEvents(events))
+	if status != domain.TaskStatus(task.Status) && lifecycle.Terminal(status) {
+		if err := c.saveTaskStatus(c.DB, task, status); err != nil {
+			logrus.Errorf("repair the status of task %s: %v", taskID, err)
 		}
 	}
-
 	return events, nil
 }
 
@@ -762,6 +1520,24 @@ func (c *ClusterUsecase) getTask(taskID string) (*domain.ClusterTask, error) {
 		taskType = domain.ClusterTaskTypeUpdateKubernetes
 	}
 
+	// the task of a kind registered by task definition
+	if source == nil {
+		generic, err := c.taskRepo.GetTask(taskID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if generic != nil {
+			// the task type of generic task is not mapped to the domain type
+			source = &domain.ClusterTask{
+				ClusterID:    generic.ClusterID,
+				ProviderName: generic.Provider,
+				TaskID:       generic.TaskID,
+				Status:       generic.Status,
+			}
+			taskType = domain.ClusterTaskType(generic.TaskType)
+		}
+	}
+
 	if source == nil {
 		return nil, bcode.ErrClusterTaskNotFound
 	}
@@ -841,22 +1617,9 @@ func (c *ClusterUsecase) GetTaskRunningLists() ([]*model.InitWutongTask, error)
 
 // GetKubeConfig get kube config file
 func (c *ClusterUsecase) GetKubeConfig(clusterID, providerName string) (string, error) {
-	var ad adaptor.WutongClusterAdaptor
-	var err error
-	if providerName != "rke" && providerName != "custom" {
-		accessKey, err := c.CloudAccessKeyRepo.GetByProvider(providerName)
-		if err != nil {
-			return "", bcode.ErrorNotFoundAccessKey
-		}
-		ad, err = factory.GetCloudFactory().GetWutongClusterAdaptor(providerName, accessKey.AccessKey, accessKey.SecretKey)
-		if err != nil {
-			return "", bcode.ErrorProviderNotSupport
-		}
-	} else {
-		ad, err = factory.GetCloudFactory().GetWutongClusterAdaptor(providerName, "", "")
-		if err != nil {
-			return "", bcode.ErrorProviderNotSupport
-		}
+	ad, err := c.getWutongClusterAdaptor(providerName)
+	if err != nil {
+		return "", err
 	}
 	kube, err := ad.GetKubeConfig(clusterID)
 	if err != nil {
@@ -867,22 +1630,9 @@ func (c *ClusterUsecase) GetKubeConfig(clusterID, providerName string) (string,
 
 // GetRegionConfig get region config
 func (c *ClusterUsecase) GetRegionConfig(clusterID, providerName string) (map[string]string, error) {
-	var ad adaptor.WutongClusterAdaptor
-	var err error
-	if providerName != "rke" && providerName != "custom" {
-		accessKey, err := c.CloudAccessKeyRepo.GetByProvider(providerName)
-		if err != nil {
-			return nil, bcode.ErrorNotFoundAccessKey
-		}
-		ad, err = factory.GetCloudFactory().GetWutongClusterAdaptor(providerName, accessKey.AccessKey, accessKey.SecretKey)
-		if err != nil {
-			return nil, bcode.ErrorProviderNotSupport
-		}
-	} else {
-		ad, err = factory.GetCloudFactory().GetWutongClusterAdaptor(providerName, "", "")
-		if err != nil {
-			return nil, bcode.ErrorProviderNotSupport
-		}
+	ad, err := c.getWutongClusterAdaptor(providerName)
+	if err != nil {
+		return nil, err
 	}
 	kubeConfig, err := ad.GetKubeConfig(clusterID)
 	if err != nil {
@@ -928,46 +1678,68 @@ func (c *ClusterUsecase) UpdateInitWutongTaskStatus(taskID, status string) (*mod
 	return task, nil
 }
 
-// DeleteKubernetesCluster delete provider
-func (c *ClusterUsecase) DeleteKubernetesCluster(clusterID, providerName string) error {
-	var ad adaptor.WutongClusterAdaptor
-	var err error
-	if providerName != "rke" && providerName != "custom" {
-		accessKey, err := c.CloudAccessKeyRepo.GetByProvider(providerName)
-		if err != nil {
-			return bcode.ErrorNotFoundAccessKey
-		}
-		ad, err = factory.GetCloudFactory().GetWutongClusterAdaptor(providerName, accessKey.AccessKey, accessKey.SecretKey)
-		if err != nil {
-			return bcode.ErrorProviderNotSupport
-		}
-	} else {
-		ad, err = factory.GetCloudFactory().GetWutongClusterAdaptor(providerName, "", "")
-		if err != nil {
-			return bcode.ErrorProviderNotSupport
+// DeleteKubernetesCluster delete provider. The cluster of the provider creating the cloud resources for it is deleted
+// by the destroy task, as releasing the resources takes minutes, the task is returned then. The deletion must be
+// confirmed, the cloud resources can not be recovered once released.
+func (c *ClusterUsecase) DeleteKubernetesCluster(clusterID string, req v1.DeleteKubernetesClusterReq) (*v1.UpdateKubernetesTask, error) {
+	ad, err := c.getWutongClusterAdaptor(req.ProviderName)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := ad.(adaptor.CloudResourceAdaptor); ok {
+		if !req.Confirm {
+			return nil, bcode.ErrDeleteClusterNotConfirmed
 		}
+		return c.sendDestroyTask(clusterID, req.ProviderName)
+	}
+	return nil, ad.DeleteCluster(clusterID)
+}
+
+// DestroyKubernetesCluster removes kubernetes from all nodes of the cluster, then deletes the cluster.
+func (c *ClusterUsecase) DestroyKubernetesCluster(clusterID string, req v1.DeleteKubernetesClusterReq) (*v1.UpdateKubernetesTask, error) {
+	if provider, ok := adaptor.GetProvider(req.ProviderName); !ok || !provider.Capabilities.DestroyCluster {
+		return nil, bcode.ErrNotSupportDestroyCluster
+	}
+	cluster, err := c.GetCluster(req.ProviderName, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	// the wutong region is running on the cluster, it can not be destroyed by accident.
+	if cluster.WutongInit && !req.Confirm {
+		return nil, bcode.ErrDestroyClusterNotConfirmed
+	}
+
+	return c.sendDestroyTask(clusterID, req.ProviderName)
+}
+
+func (c *ClusterUsecase) sendDestroyTask(clusterID, providerName string) (*v1.UpdateKubernetesTask, error) {
+	accessKey, err := c.getProviderAccessKey(providerName)
+	if err != nil {
+		return nil, err
 	}
-	return ad.DeleteCluster(clusterID)
+	destroy := &v1alpha1.DestroyCluster{
+		Provider:  providerName,
+		ClusterID: clusterID,
+	}
+	// the cluster may be deleted by the previous destroy task, which failed to delete its resources
+	if createTask, err := c.CreateKubernetesTaskRepo.GetLatestOneByClusterID(clusterID); err == nil {
+		destroy.RegionID = createTask.Region
+	}
+	if accessKey != nil {
+		destroy.AccessKey = accessKey.AccessKey
+		destroy.SecretKey = accessKey.SecretKey
+	}
+	return c.sendClusterTask(string(domain.ClusterTaskTypeDestroyCluster), &types.ClusterTaskMessage[*v1alpha1.DestroyCluster]{
+		TaskID: uuidutil.NewUUID(),
+		Config: destroy,
+	}, 0)
 }
 
 // GetCluster get cluster
 func (c *ClusterUsecase) GetCluster(providerName, clusterID string) (*v1alpha1.Cluster, error) {
-	var ad adaptor.WutongClusterAdaptor
-	var err error
-	if providerName != "rke" && providerName != "custom" {
-		accessKey, err := c.CloudAccessKeyRepo.GetByProvider(providerName)
-		if err != nil {
-			return nil, bcode.ErrorNotFoundAccessKey
-		}
-		ad, err = factory.GetCloudFactory().GetWutongClusterAdaptor(providerName, accessKey.AccessKey, accessKey.SecretKey)
-		if err != nil {
-			return nil, bcode.ErrorProviderNotSupport
-		}
-	} else {
-		ad, err = factory.GetCloudFactory().GetWutongClusterAdaptor(providerName, "", "")
-		if err != nil {
-			return nil, bcode.ErrorProviderNotSupport
-		}
+	ad, err := c.getWutongClusterAdaptor(providerName)
+	if err != nil {
+		return nil, err
 	}
 	return ad.DescribeCluster(clusterID)
 }
@@ -1018,10 +1790,11 @@ func (c *ClusterUsecase) InstallCluster(clusterID string) (*model.CreateKubernet
 		TaskID: newTask.TaskID,
 		KubernetesConfig: &v1alpha1.KubernetesClusterConfig{
 			ClusterName: newTask.Name,
+			ClusterID:   newTask.ClusterID,
 			Provider:    newTask.Provider,
 			RKEConfig:   rkeConfig,
 		}}
-	if err := c.TaskProducer.SendCreateKuerbetesTask(taskReq); err != nil {
+	if err := c.TaskProducer.SendTask(constants.CloudCreate, taskReq); err != nil {
 		logrus.Errorf("send create kubernetes task failure %s", err.Error())
 	} else {
 		if err := c.CreateKubernetesTaskRepo.UpdateStatus(newTask.TaskID, "start"); err != nil {
@@ -1066,22 +1839,9 @@ func (c *ClusterUsecase) UninstallWutongRegion(clusterID, provider string) error
 		logrus.Info("uninstall wutong region is disable")
 		return nil
 	}
-	var ad adaptor.WutongClusterAdaptor
-	var err error
-	if provider != "rke" && provider != "custom" {
-		accessKey, err := c.CloudAccessKeyRepo.GetByProvider(provider)
-		if err != nil {
-			return bcode.ErrorNotFoundAccessKey
-		}
-		ad, err = factory.GetCloudFactory().GetWutongClusterAdaptor(provider, accessKey.AccessKey, accessKey.SecretKey)
-		if err != nil {
-			return bcode.ErrorProviderNotSupport
-		}
-	} else {
-		ad, err = factory.GetCloudFactory().GetWutongClusterAdaptor(provider, "", "")
-		if err != nil {
-			return bcode.ErrorProviderNotSupport
-		}
+	ad, err := c.getWutongClusterAdaptor(provider)
+	if err != nil {
+		return err
 	}
 	kubeconfig, err := ad.GetKubeConfig(clusterID)
 	if err != nil {
@@ -1133,6 +1893,12 @@ func (c *ClusterUsecase) PruneUpdateRKEConfig(req *v1.PruneUpdateRKEConfigReq) (
 	if err != nil {
 		return nil, err
 	}
+	bastions := req.Nodes.Bastions()
+	for i := range nodeList {
+		if bastion, ok := bastions[nodeList[i].IP]; ok {
+			nodeList[i].Bastion = &bastion
+		}
+	}
 
 	return &v1.PruneUpdateRKEConfigResp{
 		Nodes:            nodeList,
@@ -1167,26 +1933,6 @@ func (c *ClusterUsecase) nodeListToRKEConfigNodes(nodeList v1alpha1.NodeList) []
 	return nodes
 }
 
-// GetInitNodeCmd -
-func (c *ClusterUsecase) GetInitNodeCmd(ctx context.Context) (*v1.InitNodeCmdRes, error) {
-	// TODO: not implement
-	return nil, nil
-	// pub, err := ssh.GetOrMakeSSHRSA()
-	// if err != nil {
-	// 	return nil, errors.Wrap(err, "get or create ssh rsa")
-	// }
-
-	// if config.C.IsOffline {
-	// 	return &v1.InitNodeCmdRes{
-	// 		Cmd:       fmt.Sprintf(`export SSH_RSA="%s" && ./init_node_offline.sh`, pub),
-	// 		IsOffline: true,
-	// 	}, nil
-	// }
-	// return &v1.InitNodeCmdRes{
-	// 	Cmd: fmt.Sprintf(`export SSH_RSA="%s"&&curl http://sh.wutong.com/init_node_5.4 | bash`, pub),
-	// }, nil
-}
-
 // ListWutongComponents -
 func (c *ClusterUsecase) ListWutongComponents(ctx context.Context, clusterID, providerName string) ([]*v1.WutongComponent, error) {
 	kubeConfig, err := c.GetKubeConfig(clusterID, providerName)
@@ -1316,7 +2062,7 @@ func (c *ClusterUsecase) listPodEvents(ctx context.Context, kubeClient kubernete
 }
 
 func (c *ClusterUsecase) syncTaskEvents(task *domain.ClusterTask, events []*model.TaskEvent) error {
-	if task.TaskType != domain.ClusterTaskTypeInitWutong || (task.ProviderName != "rke" && task.ProviderName != "custom") {
+	if task.TaskType != domain.ClusterTaskTypeInitWutong || !withoutCredentials(task.ProviderName) {
 		return nil
 	}
 
@@ -1334,14 +2080,14 @@ func (c *ClusterUsecase) syncTaskEvents(task *domain.ClusterTask, events []*mode
 	var updates []string
 	// update InitWutongRegionOperator event
 	if status.OperatorReady {
-		event := c.getEvent("InitWutongRegionOperator", events)
+		event := c.getEvent(domain.TaskStepInitWutongRegionOperator, events)
 		if event != nil {
 			updates = append(updates, event.EventID)
 		}
 	}
 	// update InitWutongRegionImageHub event
 	if idx, condition := status.WutongCluster.Status.GetCondition(wutongv1alpha1.WutongClusterConditionTypeImageRepository); idx != -1 && condition.Status == corev1.ConditionTrue {
-		event := c.getEvent("InitWutongRegionImageHub", events)
+		event := c.getEvent(domain.TaskStepInitWutongRegionImageHub, events)
 		if event != nil {
 			updates = append(updates, event.EventID)
 		}
@@ -1349,7 +2095,7 @@ func (c *ClusterUsecase) syncTaskEvents(task *domain.ClusterTask, events []*mode
 	// update InitWutongRegionPackage event
 	for _, con := range status.WutongPackage.Status.Conditions {
 		if con.Type == wutongv1alpha1.Ready && con.Status == wutongv1alpha1.Completed {
-			event := c.getEvent("InitWutongRegionPackage", events)
+			event := c.getEvent(domain.TaskStepInitWutongRegionPackage, events)
 			if event != nil {
 				updates = append(updates, event.EventID)
 			}
@@ -1358,26 +2104,33 @@ func (c *ClusterUsecase) syncTaskEvents(task *domain.ClusterTask, events []*mode
 	// update InitWutongRegion event
 	idx, condition := status.WutongCluster.Status.GetCondition(wutongv1alpha1.WutongClusterConditionTypeRunning)
 	if idx != -1 && condition.Status == corev1.ConditionTrue {
-		event := c.getEvent("InitWutongRegion", events)
+		event := c.getEvent(domain.TaskStepInitWutongRegion, events)
 		if event != nil {
 			updates = append(updates, event.EventID)
 		}
 	}
 
-	return c.TaskEventRepo.UpdateStatusInBatch(updates, "success")
+	return c.TaskEventRepo.UpdateStatusInBatch(updates, domain.TaskEventSuccess)
 }
 
-func (c *ClusterUsecase) getEvent(stepType string, events []*model.TaskEvent) *model.TaskEvent {
+func (c *ClusterUsecase) getEvent(step domain.TaskStep, events []*model.TaskEvent) *model.TaskEvent {
 	for _, event := range events {
-		if event.StepType == stepType {
+		if domain.TaskStep(event.StepType) == step {
 			return event
 		}
 	}
 	return nil
 }
 
+// withoutCredentials returns true if the provider does not require the access key, the kubeconfig of its clusters
+// is kept by adaptor, so the status of the init task is read from the cluster.
+func withoutCredentials(providerName string) bool {
+	provider, ok := adaptor.GetProvider(providerName)
+	return ok && !provider.Capabilities.NeedCredentials
+}
+
 func (c *ClusterUsecase) getTaskClusterStatus(task *model.InitWutongTask) (string, error) {
-	if task.Provider != "rke" && task.Provider != "custom" {
+	if !withoutCredentials(task.Provider) {
 		return "", nil
 	}
 
@@ -0,0 +1,71 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package usecase
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	v1 "github.com/wutong-paas/cloud-adaptor/api/cloud-adaptor/v1"
+	"github.com/wutong-paas/cloud-adaptor/internal/adaptor/v1alpha1"
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+	"github.com/wutong-paas/cloud-adaptor/internal/nsqc/producer"
+	"github.com/wutong-paas/cloud-adaptor/internal/repo"
+	"github.com/wutong-paas/cloud-adaptor/pkg/bcode"
+)
+
+type fakeRKEClusterRepo struct {
+	repo.RKEClusterRepository
+	created []*model.RKECluster
+}
+
+func (f *fakeRKEClusterRepo) Create(cluster *model.RKECluster) error {
+	f.created = append(f.created, cluster)
+	return nil
+}
+
+type fakeTaskProducer struct {
+	producer.TaskProducer
+}
+
+func TestCreateKubernetesClusterInvalidBastion(t *testing.T) {
+	rkeClusters := &fakeRKEClusterRepo{}
+	c := &ClusterUsecase{TaskProducer: &fakeTaskProducer{}, rkeClusterRepo: rkeClusters}
+	rkeConfig := `
+nodes:
+- address: 192.168.1.1
+  port: "22"
+  user: root
+  role: [controlplane, etcd, worker]
+`
+	_, err := c.CreateKubernetesCluster(v1.CreateKubernetesReq{
+		Name:             "test",
+		Provider:         "rke",
+		EncodedRKEConfig: base64.StdEncoding.EncodeToString([]byte(rkeConfig)),
+		Bastion:          &v1alpha1.BastionHost{Port: 22},
+	})
+	if !errors.Is(err, bcode.ErrInvalidBastionHost) {
+		t.Fatalf("want invalid bastion host, got %v", err)
+	}
+	// the bad request leaves no cluster stuck in the init state
+	if len(rkeClusters.created) != 0 {
+		t.Errorf("want no rke cluster created, got %d", len(rkeClusters.created))
+	}
+}
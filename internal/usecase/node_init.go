@@ -0,0 +1,239 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	v1 "github.com/wutong-paas/cloud-adaptor/api/cloud-adaptor/v1"
+	"github.com/wutong-paas/cloud-adaptor/cmd/cloud-adaptor/config"
+	"github.com/wutong-paas/cloud-adaptor/pkg/bcode"
+	"github.com/wutong-paas/cloud-adaptor/pkg/util/constants"
+	"github.com/wutong-paas/cloud-adaptor/pkg/util/ssh"
+	"github.com/wutong-paas/cloud-adaptor/script"
+)
+
+const (
+	defaultDockerUser    = "docker"
+	defaultDockerVersion = "19.03.5"
+	onlineDockerURL      = "https://wutong-pkg.oss-cn-shanghai.aliyuncs.com/offline/docker/docker-%s.tgz"
+)
+
+// initNodeScriptPath the path of the init node script served by adaptor
+var initNodeScriptPath = path.Join("/", constants.Service, "api/v1/init_node_script")
+
+//go:embed templates/init_node.sh.tmpl
+var nodeTemplates embed.FS
+
+var initNodeTemplate = template.Must(template.New("init_node.sh.tmpl").
+	Funcs(template.FuncMap{"join": strings.Join, "quote": shellQuote}).
+	ParseFS(nodeTemplates, "templates/init_node.sh.tmpl"))
+
+var (
+	nodeUserRegexp      = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+	dockerVersionRegexp = regexp.MustCompile(`^[0-9]+\.[0-9]+\.[0-9]+$`)
+	// hostnameRegexp the hostname of RFC 1123
+	hostnameRegexp  = regexp.MustCompile(`^[a-zA-Z0-9]([-a-zA-Z0-9]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([-a-zA-Z0-9]{0,61}[a-zA-Z0-9])?)*$`)
+	clusterIDRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+)
+
+// initNodeModules the kernel modules loaded on boot
+var initNodeModules = []string{"br_netfilter", "overlay", "nf_conntrack", "ip_vs", "ip_vs_rr", "ip_vs_wrr", "ip_vs_sh"}
+
+// initNodeSysctls the sysctls required by kubernetes
+var initNodeSysctls = []string{
+	"net.bridge.bridge-nf-call-iptables = 1",
+	"net.bridge.bridge-nf-call-ip6tables = 1",
+	"net.ipv4.ip_forward = 1",
+	"vm.swappiness = 0",
+	"vm.max_map_count = 262144",
+	"fs.inotify.max_user_watches = 524288",
+	"fs.file-max = 1000000",
+}
+
+type initNodeValues struct {
+	SSHPublicKey  string
+	User          string
+	Hostname      string
+	DockerVersion string
+	DockerURL     string
+	Offline       bool
+	KernelModules []string
+	Sysctls       []string
+	InstallDocker string
+}
+
+// GetInitNodeCmd returns the command to run on the node, the command downloads the init script from adaptor and runs it.
+func (c *ClusterUsecase) GetInitNodeCmd(ctx context.Context, req v1.InitNodeCmdReq, baseURL string) (*v1.InitNodeCmdRes, error) {
+	if err := validateInitNodeReq(&req); err != nil {
+		return nil, err
+	}
+	query := url.Values{}
+	query.Set("user", req.User)
+	query.Set("dockerVersion", req.DockerVersion)
+	if req.Hostname != "" {
+		query.Set("hostname", req.Hostname)
+	}
+	if req.ClusterID != "" {
+		query.Set("clusterID", req.ClusterID)
+	}
+	baseURL, err := initNodeBaseURL(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	scriptURL := fmt.Sprintf("%s%s?%s", baseURL, initNodeScriptPath, query.Encode())
+	return &v1.InitNodeCmdRes{
+		Cmd:       fmt.Sprintf("curl -sfL '%s' | bash", scriptURL),
+		IsOffline: isOffline(),
+	}, nil
+}
+
+// GetInitNodeScript renders the init script of the node, docker is downloaded from adaptor if offline.
+func (c *ClusterUsecase) GetInitNodeScript(ctx context.Context, req v1.InitNodeCmdReq, baseURL string) ([]byte, error) {
+	if err := validateInitNodeReq(&req); err != nil {
+		return nil, err
+	}
+	baseURL, err := initNodeBaseURL(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := c.initNodePublicKey(req.ClusterID)
+	if err != nil {
+		return nil, err
+	}
+	values := initNodeValues{
+		SSHPublicKey:  pub,
+		User:          req.User,
+		Hostname:      req.Hostname,
+		DockerVersion: req.DockerVersion,
+		DockerURL:     fmt.Sprintf(onlineDockerURL, req.DockerVersion),
+		Offline:       isOffline(),
+		KernelModules: initNodeModules,
+		Sysctls:       initNodeSysctls,
+		InstallDocker: script.InstallDocker,
+	}
+	if values.Offline {
+		values.DockerURL = fmt.Sprintf("%s%s/docker-%s.tgz", baseURL, initNodeScriptPath, req.DockerVersion)
+	}
+	var buf bytes.Buffer
+	if err := initNodeTemplate.Execute(&buf, values); err != nil {
+		return nil, errors.Wrap(err, "render init node script")
+	}
+	return buf.Bytes(), nil
+}
+
+// initNodePublicKey returns the public key authorized on the node. It is the key of the cluster if the cluster has
+// one, the shared ssh rsa of adaptor is used for the new clusters, whose keys are pushed over it when they are created.
+func (c *ClusterUsecase) initNodePublicKey(clusterID string) (string, error) {
+	if clusterID == "" {
+		pub, err := ssh.GetOrMakeSSHRSA()
+		if err != nil {
+			return "", errors.Wrap(err, "get or create ssh rsa")
+		}
+		return strings.TrimSpace(pub), nil
+	}
+	key, err := c.GetSSHKey(clusterID, "rke")
+	if err != nil {
+		return "", errors.Wrapf(err, "get ssh key of cluster %s", clusterID)
+	}
+	return strings.TrimSpace(key.PublicKey), nil
+}
+
+// GetOfflineDockerPackage returns the path of the docker package served to the nodes offline,
+// the packages are placed in $CONFIG_DIR/offline/docker.
+func (c *ClusterUsecase) GetOfflineDockerPackage(dockerVersion string) (string, error) {
+	if !dockerVersionRegexp.MatchString(dockerVersion) {
+		return "", errors.WithMessage(bcode.BadRequest, "invalid docker version")
+	}
+	configDir := "/tmp"
+	if os.Getenv("CONFIG_DIR") != "" {
+		configDir = os.Getenv("CONFIG_DIR")
+	}
+	packagePath := path.Join(configDir, "offline", "docker", fmt.Sprintf("docker-%s.tgz", dockerVersion))
+	if _, err := os.Stat(packagePath); err != nil {
+		return "", errors.WithStack(bcode.ErrDockerPackageNotFound)
+	}
+	return packagePath, nil
+}
+
+func validateInitNodeReq(req *v1.InitNodeCmdReq) error {
+	if req.User == "" {
+		req.User = defaultDockerUser
+	}
+	if req.DockerVersion == "" {
+		req.DockerVersion = defaultDockerVersion
+	}
+	if !nodeUserRegexp.MatchString(req.User) {
+		return errors.WithMessage(bcode.BadRequest, "invalid user name")
+	}
+	if !dockerVersionRegexp.MatchString(req.DockerVersion) {
+		return errors.WithMessage(bcode.BadRequest, "invalid docker version")
+	}
+	if req.Hostname != "" && (len(req.Hostname) > 253 || !hostnameRegexp.MatchString(req.Hostname)) {
+		return errors.WithMessage(bcode.BadRequest, "invalid hostname")
+	}
+	if req.ClusterID != "" && !clusterIDRegexp.MatchString(req.ClusterID) {
+		return errors.WithMessage(bcode.BadRequest, "invalid cluster id")
+	}
+	return nil
+}
+
+// initNodeBaseURL the advertise url of adaptor takes precedence over the url requested. The host of the request
+// can be forged, so the url requested is used only if its host is allowed.
+func initNodeBaseURL(baseURL string) (string, error) {
+	if config.C != nil && config.C.AdvertiseURL != "" {
+		return strings.TrimSuffix(config.C.AdvertiseURL, "/"), nil
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || !isAllowedHost(u.Host) {
+		return "", errors.WithStack(bcode.ErrInitNodeHostNotAllowed)
+	}
+	return u.Scheme + "://" + u.Host, nil
+}
+
+func isAllowedHost(host string) bool {
+	if config.C == nil {
+		return false
+	}
+	for _, allowed := range config.C.AllowedHosts {
+		if strings.EqualFold(host, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// shellQuote quotes s as a single word of shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func isOffline() bool {
+	return config.C != nil && config.C.IsOffline
+}
@@ -0,0 +1,88 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package usecase
+
+import (
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	v1 "github.com/wutong-paas/cloud-adaptor/api/cloud-adaptor/v1"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+	"github.com/wutong-paas/cloud-adaptor/internal/types"
+	"github.com/wutong-paas/cloud-adaptor/pkg/bcode"
+)
+
+// CreateTask creates the task of the kind registered by the task definition name, and sends it to the queue.
+// The task changing a cluster is not created until the other tasks of the cluster are finished.
+func (c *ClusterUsecase) CreateTask(name string, payload types.TaskPayload) (*model.Task, error) {
+	// the status of task is tracked by the lifecycle of its definition
+	def, ok := c.taskRegistry.Get(name)
+	if !ok || def.Lifecycle == nil {
+		return nil, errors.WithStack(bcode.ErrTaskTypeNotSupported)
+	}
+	if payload.GetTaskID() == "" {
+		return nil, errors.New("the task id of payload is empty")
+	}
+	if c.TaskProducer == nil {
+		logrus.Errorf("TaskProducer is nil")
+		return nil, bcode.ServerErr
+	}
+	provider, clusterID := payload.Target()
+	if payload.Mutating() && clusterID != "" {
+		if _, err := c.isLastTaskComplete(clusterID); err != nil {
+			return nil, err
+		}
+	}
+
+	task := &model.Task{
+		TaskID:    payload.GetTaskID(),
+		TaskType:  name,
+		ClusterID: clusterID,
+		Provider:  provider,
+		Status:    string(domain.TaskStatusStart),
+	}
+	if err := c.taskRepo.Create(task); err != nil {
+		return nil, err
+	}
+	if err := c.TaskProducer.SendTask(name, payload); err != nil {
+		logrus.Errorf("send %s task %s failure %s", name, task.TaskID, err.Error())
+		if err := c.taskRepo.UpdateStatus(task.TaskID, string(def.Lifecycle.FailureStatus)); err != nil {
+			logrus.Errorf("update task status failure %s", err.Error())
+		}
+		return nil, errors.Wrap(err, "send task")
+	}
+	return task, nil
+}
+
+// sendClusterTask creates the task of the operation name on the cluster, see CreateTask.
+func (c *ClusterUsecase) sendClusterTask(name string, payload types.TaskPayload, nodeNumber int) (*v1.UpdateKubernetesTask, error) {
+	task, err := c.CreateTask(name, payload)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Infof("send %s task %s to queue", name, task.TaskID)
+	return &v1.UpdateKubernetesTask{
+		TaskID:     task.TaskID,
+		Provider:   task.Provider,
+		ClusterID:  task.ClusterID,
+		NodeNumber: nodeNumber,
+		Status:     task.Status,
+		TaskType:   task.TaskType,
+	}, nil
+}
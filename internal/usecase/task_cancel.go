@@ -0,0 +1,169 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package usecase
+
+import (
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	v1 "github.com/wutong-paas/cloud-adaptor/api/cloud-adaptor/v1"
+	"github.com/wutong-paas/cloud-adaptor/cmd/cloud-adaptor/config"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+	"github.com/wutong-paas/cloud-adaptor/pkg/bcode"
+)
+
+const (
+	// TaskCancelledStep the step of the event recorded when a task is cancelled
+	TaskCancelledStep = string(domain.TaskStepCancelled)
+	// TaskStatusCancelled the status of the cancelled task
+	TaskStatusCancelled = string(domain.TaskStatusCancelled)
+	// TaskStatusCancelling the status of the running task requested to be cancelled
+	TaskStatusCancelling = "cancelling"
+)
+
+// isTaskFinished returns true if the task is finished, the cluster is ready for a new task then.
+func isTaskFinished(status string) bool {
+	return domain.TaskStatus(status).Terminal()
+}
+
+// CancelTask cancel the task. A pending task is cancelled at once. A running task is cancelled by its worker
+// at the next heartbeat, and it stops at the next step boundary. The tasks of the channel and nsq backends
+// are not in the queue, they are cancelled through their contexts by the consumers running them.
+func (c *ClusterUsecase) CancelTask(taskID string) (*v1.CancelTaskRes, error) {
+	task, err := c.getTask(taskID)
+	if err != nil {
+		return nil, err
+	}
+	if c.isTaskTerminal(task.TaskType, task.Status) {
+		return nil, errors.WithStack(bcode.ErrTaskNotRunning)
+	}
+	queued, err := c.taskQueue.Cancel(taskID)
+	if err != nil {
+		return nil, err
+	}
+	if queued != nil {
+		switch {
+		case queued.CancelRequested:
+			logrus.Infof("request to cancel the running task %s", taskID)
+			return &v1.CancelTaskRes{TaskID: taskID, Status: TaskStatusCancelling}, nil
+		case queued.Status != model.QueuedTaskCancelled:
+			// the task finished just now
+			return nil, errors.WithStack(bcode.ErrTaskNotRunning)
+		}
+	}
+	if queued == nil && cancelByTaskContext() {
+		if err := c.requestTaskCancel(taskID); err != nil {
+			return nil, err
+		}
+		logrus.Infof("request to cancel the task %s", taskID)
+		return &v1.CancelTaskRes{TaskID: taskID, Status: TaskStatusCancelling}, nil
+	}
+	// the task is pending, or it is not in the queue, such as the tasks stuck before the queue is durable
+	if err := c.RecordTaskCancelled(taskID); err != nil {
+		return nil, err
+	}
+	return &v1.CancelTaskRes{TaskID: taskID, Status: TaskStatusCancelled}, nil
+}
+
+// GetTask returns the task with its state in the queue. The pending task waits for the free workers,
+// or for the other task changing the same cluster.
+func (c *ClusterUsecase) GetTask(taskID string) (*v1.TaskInfo, error) {
+	task, err := c.getTask(taskID)
+	if err != nil {
+		return nil, err
+	}
+	info := &v1.TaskInfo{
+		TaskID:       task.TaskID,
+		ClusterID:    task.ClusterID,
+		ProviderName: task.ProviderName,
+		TaskType:     string(task.TaskType),
+		Status:       task.Status,
+	}
+	events, err := c.TaskEventRepo.ListEvent(taskID)
+	if err != nil {
+		return nil, err
+	}
+	info.Steps, info.Progress = c.taskProgress(task, events)
+	running := !c.isTaskTerminal(task.TaskType, task.Status)
+	if running && cancelRequested(events) {
+		info.Status = TaskStatusCancelling
+	}
+	queued, err := c.taskQueue.Get(taskID)
+	if err != nil {
+		return nil, err
+	}
+	if queued == nil {
+		return info, nil
+	}
+	if running && queued.CancelRequested {
+		info.Status = TaskStatusCancelling
+	}
+	info.QueueStatus = queued.Status
+	if queued.Status == model.QueuedTaskPending {
+		position, err := c.taskQueue.Position(queued)
+		if err != nil {
+			return nil, err
+		}
+		info.QueuePosition = position
+	}
+	return info, nil
+}
+
+// cancelByTaskContext returns true if the tasks are sent by the channel or nsq backend, they are cancelled
+// by the consumers running them instead of the queue.
+func cancelByTaskContext() bool {
+	return config.C != nil && (config.C.TaskBackend == config.TaskBackendChannel || config.C.TaskBackend == config.TaskBackendNSQ)
+}
+
+// requestTaskCancel records the Cancelled event of task in the start status, the consumer running the task
+// cancels its context once it finds the event, and the event turns to failure once the task stops.
+func (c *ClusterUsecase) requestTaskCancel(taskID string) error {
+	_, err := c.CreateTaskEvent(&v1.EventMessage{
+		TaskID: taskID,
+		Message: &v1.Message{
+			StepType: TaskCancelledStep,
+			Message:  "the task is requested to be cancelled",
+			Status:   "start",
+		},
+	})
+	return err
+}
+
+// cancelRequested returns true if the task is requested to be cancelled by requestTaskCancel
+func cancelRequested(events []*model.TaskEvent) bool {
+	for _, event := range events {
+		if event.StepType == TaskCancelledStep && event.Status == "start" {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordTaskCancelled records the Cancelled event of task, and sets the task cancelled.
+func (c *ClusterUsecase) RecordTaskCancelled(taskID string) error {
+	_, err := c.CreateTaskEvent(&v1.EventMessage{
+		TaskID: taskID,
+		Message: &v1.Message{
+			StepType: TaskCancelledStep,
+			Message:  "the task is cancelled",
+			Status:   "failure",
+		},
+	})
+	return err
+}
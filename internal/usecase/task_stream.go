@@ -0,0 +1,256 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	v1 "github.com/wutong-paas/cloud-adaptor/api/cloud-adaptor/v1"
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+	"github.com/wutong-paas/cloud-adaptor/pkg/bcode"
+)
+
+const (
+	// taskEventSyncInterval the interval to sync the events from the database, so that the events
+	// saved by the other instances of adaptor or missed by the slow streams are sent as well.
+	taskEventSyncInterval = 10 * time.Second
+	// taskEventSyncOverlap the events updated within the overlap before the last synced event are listed again,
+	// so that the events committed late or saved by the instances with the clock behind are not missed.
+	taskEventSyncOverlap = 30 * time.Second
+	// logTailInterval the interval to check the log file for the new content
+	logTailInterval = time.Second
+	// logChunkSize the max size of a log chunk
+	logChunkSize = 32 * 1024
+)
+
+// taskEventBroker fan out the saved task events to the streams of the task
+type taskEventBroker struct {
+	lock        sync.Mutex
+	subscribers map[string]map[chan *model.TaskEvent]struct{}
+}
+
+func newTaskEventBroker() *taskEventBroker {
+	return &taskEventBroker{subscribers: make(map[string]map[chan *model.TaskEvent]struct{})}
+}
+
+// subscribe returns the channel of the events of task, and the func to cancel the subscription
+func (b *taskEventBroker) subscribe(taskID string) (<-chan *model.TaskEvent, func()) {
+	ch := make(chan *model.TaskEvent, 16)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.subscribers[taskID] == nil {
+		b.subscribers[taskID] = make(map[chan *model.TaskEvent]struct{})
+	}
+	b.subscribers[taskID][ch] = struct{}{}
+	return ch, func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		delete(b.subscribers[taskID], ch)
+		if len(b.subscribers[taskID]) == 0 {
+			delete(b.subscribers, taskID)
+		}
+	}
+}
+
+// publish send the event to the subscribers of the task, the event is dropped for the subscribers
+// whose channel is full, they catch up from the database later.
+func (b *taskEventBroker) publish(event *model.TaskEvent) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for ch := range b.subscribers[event.TaskID] {
+		e := *event
+		select {
+		case ch <- &e:
+		default:
+		}
+	}
+}
+
+// WatchTaskEvents watch the events of task. The events updated since the given time are sent first, then
+// the events are sent once they are saved, an event is sent again every time it is updated. The channel
+// is closed once the context is done.
+func (c *ClusterUsecase) WatchTaskEvents(ctx context.Context, taskID string, since time.Time) (<-chan *model.TaskEvent, error) {
+	if _, err := c.getTask(taskID); err != nil {
+		return nil, err
+	}
+	// subscribe before listing the saved events, the events saved in the meantime are not missed then.
+	events, unsubscribe := c.taskEvents.subscribe(taskID)
+	out := make(chan *model.TaskEvent)
+	go func() {
+		defer close(out)
+		defer unsubscribe()
+		// the update time of the events sent, the events are sent only if they are updated since then.
+		sent := make(map[string]time.Time)
+		send := func(event *model.TaskEvent) bool {
+			if last, ok := sent[event.EventID]; ok && !event.UpdatedAt.After(last) {
+				return true
+			}
+			sent[event.EventID] = event.UpdatedAt
+			select {
+			case out <- event:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		// synced the update time of the latest event listed from the database. It is not advanced by the events
+		// published, because the events saved before them may not be committed yet, or saved by the other instances.
+		synced := since
+		syncEvents := func(from time.Time) bool {
+			list, err := c.TaskEventRepo.ListEventSince(taskID, from)
+			if err != nil {
+				logrus.Warningf("list events of task %s failure %s", taskID, err.Error())
+				return true
+			}
+			for _, event := range list {
+				if event.UpdatedAt.After(synced) {
+					synced = event.UpdatedAt
+				}
+				if !send(event) {
+					return false
+				}
+			}
+			return true
+		}
+
+		// the events before since are sent to the client already
+		if !syncEvents(since) {
+			return
+		}
+		ticker := time.NewTicker(taskEventSyncInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-events:
+				if !send(event) {
+					return
+				}
+			case <-ticker.C:
+				from := synced.Add(-taskEventSyncOverlap)
+				if from.Before(since) {
+					from = since
+				}
+				if !syncEvents(from) {
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+// WatchCreateLog follow the create log of rke cluster from the given offset, each chunk carries the offset
+// of its end to resume from. The log is followed from the beginning again once it is replaced by the next
+// update of cluster. The channel is closed once the context is done.
+func (c *ClusterUsecase) WatchCreateLog(ctx context.Context, clusterID string, offset int64) (<-chan *v1.CreateLogChunk, error) {
+	cluster, err := c.rkeClusterRepo.GetCluster(clusterID)
+	if err != nil {
+		return nil, err
+	}
+	if cluster.CreateLogPath == "" {
+		return nil, errors.WithStack(bcode.ErrCreateLogNotFound)
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	out := make(chan *v1.CreateLogChunk)
+	go func() {
+		defer close(out)
+		tailer := &logTailer{path: cluster.CreateLogPath, offset: offset}
+		ticker := time.NewTicker(logTailInterval)
+		defer ticker.Stop()
+		for {
+			for {
+				chunk, err := tailer.next()
+				if err != nil {
+					if !os.IsNotExist(err) {
+						logrus.Warningf("read create log of cluster %s failure %s", clusterID, err.Error())
+					}
+					break
+				}
+				if chunk == nil {
+					break
+				}
+				select {
+				case out <- chunk:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return out, nil
+}
+
+// logTailer read the content appended to the log file chunk by chunk
+type logTailer struct {
+	path   string
+	offset int64
+	file   os.FileInfo
+}
+
+// next returns the next chunk of the log, or nil if there is no new content.
+func (l *logTailer) next() (*v1.CreateLogChunk, error) {
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return nil, err
+	}
+	// the log is replaced or truncated, read it from the beginning
+	if (l.file != nil && !os.SameFile(l.file, info)) || info.Size() < l.offset {
+		l.offset = 0
+	}
+	l.file = info
+	if info.Size() == l.offset {
+		return nil, nil
+	}
+
+	f, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf := make([]byte, logChunkSize)
+	n, err := f.ReadAt(buf, l.offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	buf = buf[:n]
+	// cut the full chunk at the end of a line, unless the line is longer than the chunk
+	if n == logChunkSize {
+		if i := bytes.LastIndexByte(buf, '\n'); i >= 0 {
+			buf = buf[:i+1]
+		}
+	}
+	l.offset += int64(len(buf))
+	return &v1.CreateLogChunk{Offset: l.offset, Content: string(buf)}, nil
+}
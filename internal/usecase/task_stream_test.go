@@ -0,0 +1,108 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package usecase
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+)
+
+func TestLogTailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logtail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "create.log")
+
+	tailer := &logTailer{path: path}
+	if _, err := tailer.next(); !os.IsNotExist(err) {
+		t.Fatalf("want not exist error before the log is created, got %v", err)
+	}
+	if err := ioutil.WriteFile(path, []byte("line 1\nline 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	chunk, err := tailer.next()
+	if err != nil || chunk == nil || chunk.Content != "line 1\nline 2\n" || chunk.Offset != 14 {
+		t.Fatalf("want the whole log, got %+v %v", chunk, err)
+	}
+	if chunk, err := tailer.next(); err != nil || chunk != nil {
+		t.Fatalf("want no chunk without new content, got %+v %v", chunk, err)
+	}
+
+	// resume from the offset
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("line 3\n")
+	f.Close()
+	resumed := &logTailer{path: path, offset: 14}
+	if chunk, _ := resumed.next(); chunk == nil || chunk.Content != "line 3\n" || chunk.Offset != 21 {
+		t.Fatalf("want the appended content, got %+v", chunk)
+	}
+
+	// the full chunk is cut at the end of a line
+	long := strings.Repeat("x", logChunkSize-10) + "\n" + strings.Repeat("y", 20) + "\n"
+	if err := ioutil.WriteFile(path+".new", []byte(long), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the log is replaced by the next update of cluster
+	if err := os.Rename(path+".new", path); err != nil {
+		t.Fatal(err)
+	}
+	chunk, _ = resumed.next()
+	if chunk == nil || chunk.Offset != logChunkSize-9 || !strings.HasSuffix(chunk.Content, "x\n") {
+		t.Fatalf("want the replaced log read from the beginning and cut at the end of line, got offset %v", chunk)
+	}
+	if chunk, _ := resumed.next(); chunk == nil || chunk.Content != strings.Repeat("y", 20)+"\n" {
+		t.Fatalf("want the rest of the log, got %+v", chunk)
+	}
+}
+
+func TestTaskEventBroker(t *testing.T) {
+	broker := newTaskEventBroker()
+	events, unsubscribe := broker.subscribe("t1")
+	others, unsubscribeOthers := broker.subscribe("t2")
+	defer unsubscribeOthers()
+
+	broker.publish(&model.TaskEvent{TaskID: "t1", StepType: "InstallKubernetes"})
+	if event := <-events; event.StepType != "InstallKubernetes" {
+		t.Errorf("want the event of task, got %+v", event)
+	}
+	select {
+	case event := <-others:
+		t.Errorf("want no event of the other task, got %+v", event)
+	default:
+	}
+
+	// the slow subscriber does not block the publisher
+	for i := 0; i < cap(events)+1; i++ {
+		broker.publish(&model.TaskEvent{TaskID: "t1"})
+	}
+	unsubscribe()
+	if _, ok := broker.subscribers["t1"]; ok {
+		t.Errorf("want the subscribers of task removed")
+	}
+}
@@ -25,4 +25,6 @@ var ProviderSet = wire.NewSet(
 	NewClusterUsecase,
 	NewAppStoreUsecase,
 	NewAppTemplate,
+	NewCloudResourceUsecase,
+	NewWebhookUsecase,
 )
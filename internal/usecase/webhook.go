@@ -0,0 +1,365 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	v1 "github.com/wutong-paas/cloud-adaptor/api/cloud-adaptor/v1"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+	"github.com/wutong-paas/cloud-adaptor/internal/repo"
+	"github.com/wutong-paas/cloud-adaptor/pkg/util/uuidutil"
+)
+
+const (
+	// WebhookSignatureHeader the header of the HMAC-SHA256 signature of the payload
+	WebhookSignatureHeader = "X-Wutong-Signature-256"
+	// WebhookDeliveryHeader the header of the delivery id
+	WebhookDeliveryHeader = "X-Wutong-Delivery"
+	// WebhookEventHeader the header of the event type
+	WebhookEventHeader = "X-Wutong-Event"
+
+	webhookTimeout       = 10 * time.Second
+	defaultDeliveryLimit = 50
+	// webhookDeliveryLease how long an attempt holds the delivery, the delivery is retried by others after it
+	webhookDeliveryLease = 3 * webhookTimeout
+	// webhookRetryBatch the max number of the due deliveries retried in one round
+	webhookRetryBatch = 100
+)
+
+// defaultWebhookBackoff the delays before the retries of a delivery
+var defaultWebhookBackoff = []time.Duration{time.Second, 5 * time.Second, 30 * time.Second, 2 * time.Minute}
+
+// WebhookUsecase manage the webhooks, and deliver the task events to them
+type WebhookUsecase struct {
+	webhookRepo  repo.WebhookRepository
+	deliveryRepo repo.WebhookDeliveryRepository
+	client       *http.Client
+	backoff      []time.Duration
+}
+
+// NewWebhookUsecase new webhook usecase
+func NewWebhookUsecase(webhookRepo repo.WebhookRepository, deliveryRepo repo.WebhookDeliveryRepository) *WebhookUsecase {
+	return &WebhookUsecase{
+		webhookRepo:  webhookRepo,
+		deliveryRepo: deliveryRepo,
+		client:       &http.Client{Timeout: webhookTimeout},
+		backoff:      defaultWebhookBackoff,
+	}
+}
+
+// CreateWebhook create a webhook, a secret is generated if it is not given
+func (w *WebhookUsecase) CreateWebhook(req *v1.WebhookReq) (*v1.WebhookInfo, error) {
+	webhook := &model.Webhook{WebhookID: uuidutil.NewUUID(), Enabled: true}
+	setWebhook(webhook, req)
+	generated := req.Secret == ""
+	if generated {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, errors.Wrap(err, "generate webhook secret")
+		}
+		webhook.Secret = hex.EncodeToString(secret)
+	}
+	if err := w.webhookRepo.Create(webhook); err != nil {
+		return nil, err
+	}
+	info := webhookInfo(webhook)
+	if generated {
+		info.Secret = webhook.Secret
+	}
+	return info, nil
+}
+
+// UpdateWebhook update the webhook, the secret is kept if it is not given
+func (w *WebhookUsecase) UpdateWebhook(webhookID string, req *v1.WebhookReq) (*v1.WebhookInfo, error) {
+	webhook, err := w.webhookRepo.Get(webhookID)
+	if err != nil {
+		return nil, err
+	}
+	setWebhook(webhook, req)
+	if err := w.webhookRepo.Update(webhook); err != nil {
+		return nil, err
+	}
+	return webhookInfo(webhook), nil
+}
+
+// GetWebhook -
+func (w *WebhookUsecase) GetWebhook(webhookID string) (*v1.WebhookInfo, error) {
+	webhook, err := w.webhookRepo.Get(webhookID)
+	if err != nil {
+		return nil, err
+	}
+	return webhookInfo(webhook), nil
+}
+
+// ListWebhooks -
+func (w *WebhookUsecase) ListWebhooks() ([]*v1.WebhookInfo, error) {
+	webhooks, err := w.webhookRepo.List()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]*v1.WebhookInfo, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		infos = append(infos, webhookInfo(webhook))
+	}
+	return infos, nil
+}
+
+// DeleteWebhook delete the webhook and its delivery logs
+func (w *WebhookUsecase) DeleteWebhook(webhookID string) error {
+	if _, err := w.webhookRepo.Get(webhookID); err != nil {
+		return err
+	}
+	if err := w.webhookRepo.Delete(webhookID); err != nil {
+		return err
+	}
+	return w.deliveryRepo.DeleteByWebhookID(webhookID)
+}
+
+// ListDeliveries list the latest deliveries of the webhook
+func (w *WebhookUsecase) ListDeliveries(webhookID string, limit int) ([]*model.WebhookDelivery, error) {
+	if _, err := w.webhookRepo.Get(webhookID); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = defaultDeliveryLimit
+	}
+	return w.deliveryRepo.List(webhookID, limit)
+}
+
+// Dispatch deliver the task event to the enabled webhooks matching it in background. The task of event
+// is got only if there are webhooks. The deliveries are saved with their payloads, the failed attempts are
+// retried by Run, and so are the attempts lost because the adaptor exits.
+func (w *WebhookUsecase) Dispatch(event *model.TaskEvent, getTask func(taskID string) (*domain.ClusterTask, error)) {
+	webhooks, err := w.webhookRepo.ListEnabled()
+	if err != nil {
+		logrus.Errorf("list webhooks failure %s", err.Error())
+		return
+	}
+	if len(webhooks) == 0 {
+		return
+	}
+	task, err := getTask(event.TaskID)
+	if err != nil {
+		logrus.Warningf("get task %s of event failure %s, the event is not delivered to webhooks", event.TaskID, err.Error())
+		return
+	}
+	for _, webhook := range webhooks {
+		if !webhookMatches(webhook, string(task.TaskType), event) {
+			continue
+		}
+		// the first attempt is claimed by this replica
+		nextAttemptAt := time.Now().Add(webhookDeliveryLease)
+		delivery := &model.WebhookDelivery{
+			DeliveryID:    uuidutil.NewUUID(),
+			WebhookID:     webhook.WebhookID,
+			TaskID:        event.TaskID,
+			EventID:       event.EventID,
+			StepType:      event.StepType,
+			EventStatus:   event.Status,
+			Status:        model.WebhookDeliveryPending,
+			NextAttemptAt: &nextAttemptAt,
+		}
+		body, _ := json.Marshal(&v1.WebhookPayload{
+			DeliveryID:   delivery.DeliveryID,
+			WebhookID:    webhook.WebhookID,
+			TaskID:       event.TaskID,
+			TaskType:     string(task.TaskType),
+			ClusterID:    task.ClusterID,
+			ProviderName: task.ProviderName,
+			Event:        event,
+			Timestamp:    time.Now().Unix(),
+		})
+		delivery.Payload = string(body)
+		if err := w.deliveryRepo.Create(delivery); err != nil {
+			logrus.Errorf("create delivery of webhook %s failure %s", webhook.WebhookID, err.Error())
+			continue
+		}
+		go w.deliver(context.Background(), webhook, delivery)
+	}
+}
+
+// Run retry the due deliveries every interval until ctx is done, the pending deliveries left by the adaptor
+// exited are resumed at the start.
+func (w *WebhookUsecase) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		w.retryDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *WebhookUsecase) retryDue(ctx context.Context) {
+	deliveries, err := w.deliveryRepo.ListDue(webhookRetryBatch)
+	if err != nil {
+		logrus.Errorf("list due webhook deliveries failure %s", err.Error())
+		return
+	}
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil {
+			return
+		}
+		claimed, err := w.deliveryRepo.Claim(delivery, time.Now().Add(webhookDeliveryLease))
+		if err != nil {
+			logrus.Errorf("claim delivery %s failure %s", delivery.DeliveryID, err.Error())
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		webhook, err := w.webhookRepo.Get(delivery.WebhookID)
+		if err != nil || !webhook.Enabled {
+			reason := "the webhook is disabled"
+			if err != nil {
+				reason = fmt.Sprintf("get webhook failure %s", err.Error())
+			}
+			delivery.Status, delivery.Error, delivery.NextAttemptAt = model.WebhookDeliveryFailure, reason, nil
+			if err := w.deliveryRepo.Update(delivery); err != nil {
+				logrus.Errorf("update delivery %s failure %s", delivery.DeliveryID, err.Error())
+			}
+			continue
+		}
+		w.deliver(ctx, webhook, delivery)
+	}
+}
+
+// deliver make an attempt to post the payload to the webhook, the next attempt is scheduled with backoff
+// if it fails, until the retries run out.
+func (w *WebhookUsecase) deliver(ctx context.Context, webhook *model.Webhook, delivery *model.WebhookDelivery) {
+	delivery.Attempts++
+	code, err := w.post(ctx, webhook, delivery.DeliveryID, []byte(delivery.Payload))
+	delivery.ResponseCode = code
+	delivery.Error = ""
+	delivery.NextAttemptAt = nil
+	switch {
+	case err == nil:
+		delivery.Status = model.WebhookDeliverySuccess
+	case delivery.Attempts <= len(w.backoff):
+		delivery.Error = err.Error()
+		nextAttemptAt := time.Now().Add(w.backoff[delivery.Attempts-1])
+		delivery.NextAttemptAt = &nextAttemptAt
+	default:
+		delivery.Error = err.Error()
+		delivery.Status = model.WebhookDeliveryFailure
+		logrus.Warningf("deliver %s to webhook %s failure %s", delivery.DeliveryID, webhook.WebhookID, err.Error())
+	}
+	if err := w.deliveryRepo.Update(delivery); err != nil {
+		logrus.Errorf("update delivery %s failure %s", delivery.DeliveryID, err.Error())
+	}
+}
+
+func (w *WebhookUsecase) post(ctx context.Context, webhook *model.Webhook, deliveryID string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, "task_event")
+	req.Header.Set(WebhookDeliveryHeader, deliveryID)
+	req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(webhook.Secret, body))
+	res, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
+
+// SignWebhookPayload returns the signature of the payload in the form of sha256=<hex digest>
+func SignWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func webhookMatches(webhook *model.Webhook, taskType string, event *model.TaskEvent) bool {
+	return filterMatches(webhook.TaskTypes, taskType) &&
+		filterMatches(webhook.StepTypes, event.StepType) &&
+		filterMatches(webhook.Statuses, event.Status)
+}
+
+// filterMatches returns true if the comma separated filter is empty or contains the value
+func filterMatches(filter, value string) bool {
+	if filter == "" {
+		return true
+	}
+	for _, f := range strings.Split(filter, ",") {
+		if f == value {
+			return true
+		}
+	}
+	return false
+}
+
+func setWebhook(webhook *model.Webhook, req *v1.WebhookReq) {
+	webhook.Name = req.Name
+	webhook.URL = req.URL
+	if req.Secret != "" {
+		webhook.Secret = req.Secret
+	}
+	webhook.TaskTypes = strings.Join(req.TaskTypes, ",")
+	webhook.StepTypes = strings.Join(req.StepTypes, ",")
+	webhook.Statuses = strings.Join(req.Statuses, ",")
+	if req.Enabled != nil {
+		webhook.Enabled = *req.Enabled
+	}
+}
+
+func webhookInfo(webhook *model.Webhook) *v1.WebhookInfo {
+	split := func(filter string) []string {
+		if filter == "" {
+			return []string{}
+		}
+		return strings.Split(filter, ",")
+	}
+	return &v1.WebhookInfo{
+		WebhookID: webhook.WebhookID,
+		Name:      webhook.Name,
+		URL:       webhook.URL,
+		TaskTypes: split(webhook.TaskTypes),
+		StepTypes: split(webhook.StepTypes),
+		Statuses:  split(webhook.Statuses),
+		Enabled:   webhook.Enabled,
+		CreatedAt: webhook.CreatedAt,
+	}
+}
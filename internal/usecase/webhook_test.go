@@ -0,0 +1,345 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "github.com/wutong-paas/cloud-adaptor/api/cloud-adaptor/v1"
+	"github.com/wutong-paas/cloud-adaptor/internal/domain"
+	"github.com/wutong-paas/cloud-adaptor/internal/model"
+	"github.com/wutong-paas/cloud-adaptor/pkg/bcode"
+)
+
+type fakeWebhookRepo struct {
+	webhooks map[string]*model.Webhook
+}
+
+func (f *fakeWebhookRepo) Create(webhook *model.Webhook) error {
+	f.webhooks[webhook.WebhookID] = webhook
+	return nil
+}
+
+func (f *fakeWebhookRepo) Update(webhook *model.Webhook) error {
+	f.webhooks[webhook.WebhookID] = webhook
+	return nil
+}
+
+func (f *fakeWebhookRepo) Get(webhookID string) (*model.Webhook, error) {
+	webhook, ok := f.webhooks[webhookID]
+	if !ok {
+		return nil, bcode.ErrWebhookNotFound
+	}
+	return webhook, nil
+}
+
+func (f *fakeWebhookRepo) List() ([]*model.Webhook, error) {
+	var webhooks []*model.Webhook
+	for _, webhook := range f.webhooks {
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, nil
+}
+
+func (f *fakeWebhookRepo) ListEnabled() ([]*model.Webhook, error) {
+	var webhooks []*model.Webhook
+	for _, webhook := range f.webhooks {
+		if webhook.Enabled {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	return webhooks, nil
+}
+
+func (f *fakeWebhookRepo) Delete(webhookID string) error {
+	delete(f.webhooks, webhookID)
+	return nil
+}
+
+type fakeWebhookDeliveryRepo struct {
+	lock       sync.Mutex
+	deliveries map[string]model.WebhookDelivery
+}
+
+func (f *fakeWebhookDeliveryRepo) Create(delivery *model.WebhookDelivery) error {
+	return f.Update(delivery)
+}
+
+func (f *fakeWebhookDeliveryRepo) Update(delivery *model.WebhookDelivery) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.deliveries[delivery.DeliveryID] = *delivery
+	return nil
+}
+
+func (f *fakeWebhookDeliveryRepo) List(webhookID string, limit int) ([]*model.WebhookDelivery, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var deliveries []*model.WebhookDelivery
+	for _, delivery := range f.deliveries {
+		if delivery.WebhookID == webhookID && len(deliveries) < limit {
+			delivery := delivery
+			deliveries = append(deliveries, &delivery)
+		}
+	}
+	return deliveries, nil
+}
+
+func (f *fakeWebhookDeliveryRepo) DeleteByWebhookID(webhookID string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for id, delivery := range f.deliveries {
+		if delivery.WebhookID == webhookID {
+			delete(f.deliveries, id)
+		}
+	}
+	return nil
+}
+
+func (f *fakeWebhookDeliveryRepo) ListDue(limit int) ([]*model.WebhookDelivery, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var deliveries []*model.WebhookDelivery
+	for _, delivery := range f.deliveries {
+		if delivery.Status == model.WebhookDeliveryPending && !delivery.NextAttemptAt.After(time.Now()) && len(deliveries) < limit {
+			delivery := delivery
+			deliveries = append(deliveries, &delivery)
+		}
+	}
+	return deliveries, nil
+}
+
+func (f *fakeWebhookDeliveryRepo) Claim(delivery *model.WebhookDelivery, until time.Time) (bool, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	old, ok := f.deliveries[delivery.DeliveryID]
+	if !ok || old.Status != model.WebhookDeliveryPending || !old.NextAttemptAt.Equal(*delivery.NextAttemptAt) {
+		return false, nil
+	}
+	old.NextAttemptAt = &until
+	f.deliveries[delivery.DeliveryID] = old
+	delivery.NextAttemptAt = &until
+	return true, nil
+}
+
+// newTestWebhookUsecase the due deliveries are retried until the test ends
+func newTestWebhookUsecase(t *testing.T) (*WebhookUsecase, *fakeWebhookDeliveryRepo) {
+	deliveryRepo := &fakeWebhookDeliveryRepo{deliveries: map[string]model.WebhookDelivery{}}
+	w := NewWebhookUsecase(&fakeWebhookRepo{webhooks: map[string]*model.Webhook{}}, deliveryRepo)
+	w.backoff = []time.Duration{10 * time.Millisecond, 10 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go w.Run(ctx, 10*time.Millisecond)
+	return w, deliveryRepo
+}
+
+func waitDeliveries(t *testing.T, w *WebhookUsecase, webhookID string, done func([]*model.WebhookDelivery) bool) []*model.WebhookDelivery {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deliveries, err := w.ListDeliveries(webhookID, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if done(deliveries) {
+			return deliveries
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting deliveries, got %+v", deliveries)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebhookDispatch(t *testing.T) {
+	var lock sync.Mutex
+	var payloads []v1.WebhookPayload
+	var attempts int
+	var secret string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(WebhookSignatureHeader) != SignWebhookPayload(secret, body) {
+			t.Errorf("unexpected signature %s", r.Header.Get(WebhookSignatureHeader))
+		}
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var payload v1.WebhookPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Error(err)
+		}
+		if r.Header.Get(WebhookDeliveryHeader) != payload.DeliveryID {
+			t.Errorf("unexpected delivery header %s", r.Header.Get(WebhookDeliveryHeader))
+		}
+		payloads = append(payloads, payload)
+	}))
+	defer server.Close()
+
+	w, _ := newTestWebhookUsecase(t)
+	webhook, err := w.CreateWebhook(&v1.WebhookReq{
+		Name:      "test",
+		URL:       server.URL,
+		TaskTypes: []string{string(domain.ClusterTaskTypeCreateKubernetes)},
+		Statuses:  []string{"success", "failure"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if webhook.Secret == "" || !webhook.Enabled {
+		t.Fatalf("want an enabled webhook with a generated secret, got %+v", webhook)
+	}
+	secret = webhook.Secret
+
+	getTask := func(taskID string) (*domain.ClusterTask, error) {
+		return &domain.ClusterTask{ClusterID: "c1", ProviderName: "rke", TaskID: taskID, TaskType: domain.ClusterTaskTypeCreateKubernetes}, nil
+	}
+	// filtered out by status
+	w.Dispatch(&model.TaskEvent{TaskID: "t1", EventID: "e1", StepType: "CreateCluster", Status: "start"}, getTask)
+	w.Dispatch(&model.TaskEvent{TaskID: "t1", EventID: "e2", StepType: "CreateCluster", Status: "success"}, getTask)
+
+	deliveries := waitDeliveries(t, w, webhook.WebhookID, func(deliveries []*model.WebhookDelivery) bool {
+		return len(deliveries) == 1 && deliveries[0].Status != model.WebhookDeliveryPending
+	})
+	if deliveries[0].Status != model.WebhookDeliverySuccess || deliveries[0].Attempts != 2 || deliveries[0].ResponseCode != http.StatusOK {
+		t.Fatalf("want a success delivery after a retry, got %+v", deliveries[0])
+	}
+	if deliveries[0].EventID != "e2" {
+		t.Fatalf("want the delivery of event e2, got %s", deliveries[0].EventID)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(payloads) != 1 || payloads[0].ClusterID != "c1" || payloads[0].Event.EventID != "e2" {
+		t.Fatalf("unexpected payloads %+v", payloads)
+	}
+}
+
+func TestWebhookDeliveryFailure(t *testing.T) {
+	var lock sync.Mutex
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		attempts++
+		lock.Unlock()
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	w, _ := newTestWebhookUsecase(t)
+	webhook, err := w.CreateWebhook(&v1.WebhookReq{Name: "test", URL: server.URL, Secret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if webhook.Secret != "" {
+		t.Fatal("want the given secret not returned")
+	}
+	w.Dispatch(&model.TaskEvent{TaskID: "t1", EventID: "e1", StepType: "CreateCluster", Status: "failure"},
+		func(taskID string) (*domain.ClusterTask, error) {
+			return &domain.ClusterTask{TaskID: taskID, TaskType: domain.ClusterTaskTypeUpdateKubernetes}, nil
+		})
+	deliveries := waitDeliveries(t, w, webhook.WebhookID, func(deliveries []*model.WebhookDelivery) bool {
+		return len(deliveries) == 1 && deliveries[0].Status == model.WebhookDeliveryFailure
+	})
+	if deliveries[0].Attempts != 3 || deliveries[0].ResponseCode != http.StatusBadGateway || deliveries[0].Error == "" {
+		t.Fatalf("want a failure delivery after the retries run out, got %+v", deliveries[0])
+	}
+
+	if err := w.DeleteWebhook(webhook.WebhookID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.ListDeliveries(webhook.WebhookID, 10); err != bcode.ErrWebhookNotFound {
+		t.Fatalf("want webhook not found, got %v", err)
+	}
+}
+
+func TestWebhookResumeDeliveries(t *testing.T) {
+	var lock sync.Mutex
+	var deliveryIDs []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		deliveryIDs = append(deliveryIDs, r.Header.Get(WebhookDeliveryHeader))
+	}))
+	defer server.Close()
+
+	deliveryRepo := &fakeWebhookDeliveryRepo{deliveries: map[string]model.WebhookDelivery{}}
+	w := NewWebhookUsecase(&fakeWebhookRepo{webhooks: map[string]*model.Webhook{}}, deliveryRepo)
+	webhook, err := w.CreateWebhook(&v1.WebhookReq{Name: "test", URL: server.URL, Secret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// left pending by the adaptor exited, the attempt in progress of the other replica is not retried
+	due, inProgress := time.Now().Add(-time.Second), time.Now().Add(time.Minute)
+	deliveryRepo.Create(&model.WebhookDelivery{DeliveryID: "d1", WebhookID: webhook.WebhookID, Status: model.WebhookDeliveryPending,
+		Attempts: 1, Payload: "{}", NextAttemptAt: &due})
+	deliveryRepo.Create(&model.WebhookDelivery{DeliveryID: "d2", WebhookID: webhook.WebhookID, Status: model.WebhookDeliveryPending,
+		Attempts: 1, Payload: "{}", NextAttemptAt: &inProgress})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx, time.Hour)
+		close(done)
+	}()
+	waitDeliveries(t, w, webhook.WebhookID, func(deliveries []*model.WebhookDelivery) bool {
+		for _, delivery := range deliveries {
+			if delivery.DeliveryID == "d1" {
+				return delivery.Status == model.WebhookDeliverySuccess && delivery.Attempts == 2
+			}
+		}
+		return false
+	})
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("want Run stopped once the context is done")
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(deliveryIDs) != 1 || deliveryIDs[0] != "d1" {
+		t.Fatalf("want only the due delivery resumed, got %v", deliveryIDs)
+	}
+}
+
+func TestFilterMatches(t *testing.T) {
+	tests := []struct {
+		filter, value string
+		want          bool
+	}{
+		{"", "start", true},
+		{"success", "success", true},
+		{"success,failure", "failure", true},
+		{"success,failure", "start", false},
+	}
+	for _, tc := range tests {
+		if got := filterMatches(tc.filter, tc.value); got != tc.want {
+			t.Errorf("filterMatches(%q, %q) = %v, want %v", tc.filter, tc.value, got, tc.want)
+		}
+	}
+}
@@ -73,4 +73,53 @@ var (
 
 	ErrWutongClusterInstalled = newByMessage(409, 7028, "wutong cluster is already installed")
 	ErrClusterTaskNotFound    = newByMessage(404, 7029, "cluster task not found")
+
+	//ErrNotSupportUpgradeKubernetes -
+	ErrNotSupportUpgradeKubernetes = newByMessage(400, 7030, "cluster can not support upgrade kubernetes")
+	//ErrKubernetesVersionNotUpgradable -
+	ErrKubernetesVersionNotUpgradable = newByMessage(400, 7031, "the target kubernetes version is not upgradable")
+	//ErrNotSupportEtcdSnapshot -
+	ErrNotSupportEtcdSnapshot = newByMessage(400, 7032, "cluster can not support etcd snapshot")
+	//ErrNotSupportRotateCertificates -
+	ErrNotSupportRotateCertificates = newByMessage(400, 7033, "cluster can not support rotate certificates")
+	//ErrNotSupportSecretsEncryption -
+	ErrNotSupportSecretsEncryption = newByMessage(400, 7034, "cluster can not support secrets encryption")
+	//ErrSecretsEncryptionEnabled -
+	ErrSecretsEncryptionEnabled = newByMessage(409, 7035, "secrets encryption is already enabled")
+	//ErrSecretsEncryptionNotEnabled -
+	ErrSecretsEncryptionNotEnabled = newByMessage(400, 7036, "secrets encryption is not enabled")
+	//ErrSecretsEncryptionCustomConfig -
+	ErrSecretsEncryptionCustomConfig = newByMessage(400, 7037, "the key of custom secrets encryption config can not be rotated")
+	//ErrNotSupportRemoveNodes -
+	ErrNotSupportRemoveNodes = newByMessage(400, 7038, "cluster can not support remove nodes")
+	//ErrClusterNodeNotFound -
+	ErrClusterNodeNotFound = newByMessage(404, 7039, "cluster node not found")
+	//ErrNotSupportDestroyCluster -
+	ErrNotSupportDestroyCluster = newByMessage(400, 7040, "cluster can not support destroy")
+	//ErrDestroyClusterNotConfirmed -
+	ErrDestroyClusterNotConfirmed = newByMessage(400, 7041, "wutong is installed on the cluster, destroy it must be confirmed")
+	//ErrNotSupportClusterState -
+	ErrNotSupportClusterState = newByMessage(400, 7042, "cluster can not support state versions")
+	//ErrClusterStateNotFound -
+	ErrClusterStateNotFound = newByMessage(404, 7043, "cluster state version not found")
+	//ErrNotSupportPreflight -
+	ErrNotSupportPreflight = newByMessage(400, 7044, "cluster can not support preflight checks")
+	//ErrDockerPackageNotFound -
+	ErrDockerPackageNotFound = newByMessage(404, 7045, "offline docker package not found")
+	//ErrNotSupportSSHKey -
+	ErrNotSupportSSHKey = newByMessage(400, 7046, "cluster can not support ssh key management")
+	//ErrInvalidBastionHost -
+	ErrInvalidBastionHost = newByMessage(400, 7047, "invalid bastion host")
+	//ErrCreateLogNotFound -
+	ErrCreateLogNotFound = newByMessage(404, 7048, "create log of cluster not found")
+	//ErrWebhookNotFound -
+	ErrWebhookNotFound = newByMessage(404, 7049, "webhook not found")
+	//ErrTaskNotRunning -
+	ErrTaskNotRunning = newByMessage(409, 7050, "the task is not running")
+	//ErrTaskTypeNotSupported -
+	ErrTaskTypeNotSupported = newByMessage(400, 7051, "the task type is not supported")
+	//ErrInitNodeHostNotAllowed -
+	ErrInitNodeHostNotAllowed = newByMessage(400, 7053, "the host requested is not allowed, please set the advertise url or the allowed hosts of adaptor")
+	//ErrDeleteClusterNotConfirmed -
+	ErrDeleteClusterNotConfirmed = newByMessage(400, 7054, "the cluster and its cloud resources are released by the deletion, it must be confirmed")
 )
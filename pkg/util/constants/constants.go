@@ -27,6 +27,8 @@ const (
 	CloudCreate = "cloud-create"
 	// CloudUpdate -
 	CloudUpdate = "cloud-update"
+	// CloudTaskEvent the topic of the task events with the nsq task backend
+	CloudTaskEvent = "cloud-task-event"
 	// Namespace is the namespace for wutong-operator and wutong components
 	Namespace = "wt-system"
 )
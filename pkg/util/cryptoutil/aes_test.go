@@ -0,0 +1,42 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cryptoutil
+
+import "testing"
+
+func TestEncryptDecrypt(t *testing.T) {
+	key := DeriveKey("secret")
+	ciphertext, err := Encrypt(key, "private key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ciphertext == "private key" {
+		t.Fatal("the plaintext is not encrypted")
+	}
+	plaintext, err := Decrypt(key, ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext != "private key" {
+		t.Errorf("want private key, got %s", plaintext)
+	}
+	if _, err := Decrypt(DeriveKey("another"), ciphertext); err == nil {
+		t.Error("expect error when decrypt with another key")
+	}
+}
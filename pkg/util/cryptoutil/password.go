@@ -0,0 +1,44 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cryptoutil
+
+import (
+	"crypto/rand"
+	"math/big"
+)
+
+const passwordLetters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// passwordPrefix makes the password have the upper and lower letters, the digits and the special characters
+// required by the cloud instances.
+const passwordPrefix = "Wt1!"
+
+// RandomPassword generates the password with n random letters and digits after the prefix. It panics if the
+// random source of system fails, which never happens on the supported platforms.
+func RandomPassword(n int) string {
+	password := make([]byte, n)
+	for i := range password {
+		index, err := rand.Int(rand.Reader, big.NewInt(int64(len(passwordLetters))))
+		if err != nil {
+			panic("read the random source of system failure: " + err.Error())
+		}
+		password[i] = passwordLetters[index.Int64()]
+	}
+	return passwordPrefix + string(password)
+}
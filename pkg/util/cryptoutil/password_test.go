@@ -0,0 +1,34 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cryptoutil
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRandomPassword(t *testing.T) {
+	password := RandomPassword(12)
+	if len(password) != len(passwordPrefix)+12 || !strings.HasPrefix(password, passwordPrefix) {
+		t.Fatalf("want 12 random letters after the prefix, got %s", password)
+	}
+	if password == RandomPassword(12) {
+		t.Error("want different passwords")
+	}
+}
@@ -0,0 +1,242 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package retryutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Policy the retry policy of an operation. The wait before the nth retry is InitialInterval*Multiplier^(n-1),
+// it is at most MaxInterval, and randomized by Jitter.
+type Policy struct {
+	// MaxAttempts the times the operation runs at most, including the first run
+	MaxAttempts     int
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Multiplier      float64
+	// Jitter the fraction of the wait randomized, the wait is in [wait*(1-Jitter), wait*(1+Jitter)]
+	Jitter float64
+	// Retryable returns true if the operation can be retried after err, IsTransient if it is nil
+	Retryable func(err error) bool
+}
+
+var (
+	// CloudAPIPolicy the policy of the queries of the cloud provider APIs
+	CloudAPIPolicy = Policy{MaxAttempts: 5, InitialInterval: 2 * time.Second, MaxInterval: 30 * time.Second, Multiplier: 2, Jitter: 0.2}
+	// CloudCreatePolicy the policy of creating the cloud resources. The creation is not idempotent, it is retried
+	// only if the request is rejected by throttling, it may be done if the request timed out.
+	CloudCreatePolicy = Policy{MaxAttempts: 5, InitialInterval: 2 * time.Second, MaxInterval: 30 * time.Second, Multiplier: 2, Jitter: 0.2,
+		Retryable: IsThrottled}
+	// KubeAPIPolicy the policy of the kubernetes API
+	KubeAPIPolicy = Policy{MaxAttempts: 4, InitialInterval: time.Second, MaxInterval: 10 * time.Second, Multiplier: 2, Jitter: 0.2}
+)
+
+// EventRetry the status of the event reporting a retry of step, the step is still running
+const EventRetry = "retry"
+
+// Notify is called before waiting for the retry after the attempt failed with err
+type Notify func(attempt int, err error, wait time.Duration)
+
+// EventNotify returns the Notify reporting each retry as the EventRetry event of step, so that the users can see
+// why the step is slow. report is the rollback func of tasks.
+func EventNotify[S ~string](step S, report func(step S, message, status string)) Notify {
+	return func(attempt int, err error, wait time.Duration) {
+		report(step, fmt.Sprintf("attempt %d failed, retry in %s: %v", attempt, wait.Round(time.Millisecond), err), EventRetry)
+	}
+}
+
+type noDelayKey struct{}
+
+// WithoutDelay returns the context in which the operations are retried at once, it is used by tests.
+func WithoutDelay(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noDelayKey{}, true)
+}
+
+// Do runs fn until it succeeds, the error is not retryable, the attempts run out or ctx is done.
+// It returns the last error of fn.
+func (p Policy) Do(ctx context.Context, notify Notify, fn func() error) error {
+	_, err := Call(ctx, p, notify, func() (struct{}, error) {
+		return struct{}{}, fn()
+	})
+	return err
+}
+
+// Call is Do for the operation returning a value
+func Call[T any](ctx context.Context, p Policy, notify Notify, fn func() (T, error)) (T, error) {
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsTransient
+	}
+	for attempt := 1; ; attempt++ {
+		res, err := fn()
+		if err == nil || attempt >= p.MaxAttempts || !retryable(err) {
+			return res, err
+		}
+		wait := p.Backoff(attempt)
+		if noDelay, _ := ctx.Value(noDelayKey{}).(bool); noDelay {
+			wait = 0
+		}
+		logrus.Warningf("attempt %d failed, retry in %s: %v", attempt, wait, err)
+		if notify != nil {
+			notify(attempt, err, wait)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return res, err
+		case <-timer.C:
+		}
+	}
+}
+
+// Backoff returns the wait before the retry after the attempt failed
+func (p Policy) Backoff(attempt int) time.Duration {
+	multiplier := math.Max(p.Multiplier, 1)
+	wait := float64(p.InitialInterval) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxInterval > 0 && wait > float64(p.MaxInterval) {
+		wait = float64(p.MaxInterval)
+	}
+	if p.Jitter > 0 {
+		wait += wait * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(wait)
+}
+
+// Always retries any error
+func Always(err error) bool {
+	return true
+}
+
+// WithCodes returns the classifier retrying the transient errors and the cloud API errors of codes, such as the
+// errors of a resource being changed by the last operation.
+func WithCodes(codes ...string) func(err error) bool {
+	return func(err error) bool {
+		if code := errorCode(err); code != "" {
+			for _, c := range codes {
+				if c == code {
+					return true
+				}
+			}
+		}
+		return IsTransient(err)
+	}
+}
+
+// throttlingCodes the codes of the cloud API errors for the requests rejected by throttling or by the busy service
+var throttlingCodes = map[string]bool{
+	// aliyun
+	"Throttling":                          true,
+	"Throttling.User":                     true,
+	"Throttling.Api":                      true,
+	"Throttling.Resource":                 true,
+	"ServiceUnavailable":                  true,
+	"ServiceBusy":                         true,
+	"OperationConflict":                   true,
+	"LastTokenProcessing":                 true,
+	"OperationFailed.LastTokenProcessing": true,
+	// tencent cloud
+	"RequestLimitExceeded":                  true,
+	"RequestLimitExceeded.UinLimitExceeded": true,
+	"ResourceUnavailable":                   true,
+}
+
+// IsThrottled returns true if the request is rejected by throttling or by the busy service, it is not done
+// and safe to be retried.
+func IsThrottled(err error) bool {
+	if err == nil {
+		return false
+	}
+	if throttlingCodes[errorCode(err)] {
+		return true
+	}
+	var status interface{ HttpStatus() int }
+	if errors.As(err, &status) && (status.HttpStatus() == http.StatusTooManyRequests || status.HttpStatus() == http.StatusServiceUnavailable) {
+		return true
+	}
+	return k8sErrors.IsTooManyRequests(err)
+}
+
+// IsTransient returns true if err may go away by retrying: the network timeouts, the refused or reset connections,
+// the throttled requests and the server errors of the cloud and kubernetes APIs.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if IsThrottled(err) {
+		return true
+	}
+	if isHostNotFound(err) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	switch errorCode(err) {
+	case "SDK.TimeoutError", "SDK.ServerUnreachable", "InternalError", "InternalError.UnknownError":
+		return true
+	}
+	var status interface{ HttpStatus() int }
+	if errors.As(err, &status) && status.HttpStatus() >= http.StatusInternalServerError {
+		return true
+	}
+	return k8sErrors.IsServerTimeout(err) || k8sErrors.IsTimeout(err) || k8sErrors.IsServiceUnavailable(err) ||
+		k8sErrors.IsInternalError(err)
+}
+
+// isHostNotFound returns true if the host of the API can not be resolved, such as a wrong endpoint or region.
+// The sdk errors do not wrap the original errors, so their messages are checked as well.
+func isHostNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsNotFound
+	}
+	return strings.Contains(err.Error(), "no such host")
+}
+
+// errorCode returns the code of the aliyun or tencent cloud API error
+func errorCode(err error) string {
+	var aliyun interface{ ErrorCode() string }
+	if errors.As(err, &aliyun) {
+		return aliyun.ErrorCode()
+	}
+	var tencent interface{ GetCode() string }
+	if errors.As(err, &tencent) {
+		return tencent.GetCode()
+	}
+	return ""
+}
@@ -0,0 +1,172 @@
+// WUTONG, Application Management Platform
+// Copyright (C) 2020-2021 Wutong Co., Ltd.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version. For any non-GPL usage of Wutong,
+// one or multiple Commercial Licenses authorized by Wutong Co., Ltd.
+// must be obtained first.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package retryutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	aliyunerrors "github.com/aliyun/alibaba-cloud-sdk-go/sdk/errors"
+	tencenterrors "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+)
+
+var testPolicy = Policy{MaxAttempts: 3, InitialInterval: time.Millisecond, MaxInterval: 4 * time.Millisecond, Multiplier: 2}
+
+func TestPolicyDo(t *testing.T) {
+	var runs, notified int
+	notify := func(attempt int, err error, wait time.Duration) { notified++ }
+	err := testPolicy.Do(context.Background(), notify, func() error {
+		runs++
+		if runs < 3 {
+			return syscall.ECONNRESET
+		}
+		return nil
+	})
+	if err != nil || runs != 3 || notified != 2 {
+		t.Fatalf("want success at the third attempt with 2 retries notified, got %v after %d runs, %d notified", err, runs, notified)
+	}
+
+	// the attempts run out
+	runs = 0
+	err = testPolicy.Do(context.Background(), nil, func() error {
+		runs++
+		return fmt.Errorf("describe cluster: %w", syscall.ECONNREFUSED)
+	})
+	if !errors.Is(err, syscall.ECONNREFUSED) || runs != 3 {
+		t.Fatalf("want the last error after 3 runs, got %v after %d runs", err, runs)
+	}
+
+	// the error not retryable fails at once
+	runs = 0
+	_ = testPolicy.Do(context.Background(), nil, func() error {
+		runs++
+		return errors.New("invalid config")
+	})
+	if runs != 1 {
+		t.Fatalf("want no retry of the permanent error, got %d runs", runs)
+	}
+
+	// the retries stop once the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	runs = 0
+	slow := testPolicy
+	slow.InitialInterval = time.Hour
+	_ = slow.Do(ctx, func(int, error, time.Duration) { cancel() }, func() error {
+		runs++
+		return syscall.ECONNRESET
+	})
+	if runs != 1 {
+		t.Fatalf("want the retry stopped by the context, got %d runs", runs)
+	}
+}
+
+func TestWithoutDelay(t *testing.T) {
+	slow := testPolicy
+	slow.InitialInterval = time.Hour
+	var waits []time.Duration
+	notify := func(attempt int, err error, wait time.Duration) { waits = append(waits, wait) }
+	_ = slow.Do(WithoutDelay(context.Background()), notify, func() error {
+		return syscall.ECONNRESET
+	})
+	if len(waits) != 2 || waits[0] != 0 || waits[1] != 0 {
+		t.Fatalf("want 2 retries without delay, got %v", waits)
+	}
+}
+
+func TestEventNotify(t *testing.T) {
+	var statuses []string
+	notify := EventNotify("CreateVPC", func(step, message, status string) {
+		statuses = append(statuses, status)
+	})
+	notify(1, syscall.ECONNRESET, time.Second)
+	if len(statuses) != 1 || statuses[0] != EventRetry {
+		t.Fatalf("want the retry reported as %s event, got %v", EventRetry, statuses)
+	}
+}
+
+func TestCall(t *testing.T) {
+	var runs int
+	value, err := Call(context.Background(), testPolicy, nil, func() (string, error) {
+		runs++
+		if runs == 1 {
+			return "", tencenterrors.NewTencentCloudSDKError("RequestLimitExceeded", "too many requests", "")
+		}
+		return "ok", nil
+	})
+	if err != nil || value != "ok" || runs != 2 {
+		t.Fatalf("want the value at the second attempt, got %q %v after %d runs", value, err, runs)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := Policy{InitialInterval: time.Second, MaxInterval: 5 * time.Second, Multiplier: 2, Jitter: 0.2}
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second} {
+		for i := 0; i < 10; i++ {
+			wait := policy.Backoff(attempt)
+			if wait < want*8/10 || wait > want*12/10 {
+				t.Fatalf("want the wait of attempt %d around %s, got %s", attempt, want, wait)
+			}
+		}
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	throttled := aliyunerrors.NewServerError(400, `{"Code": "Throttling.User", "Message": "Request was denied due to user flow control."}`, "")
+	forbidden := aliyunerrors.NewServerError(400, `{"Code": "Forbbiden", "Message": "the vpc has vswitches"}`, "")
+	tests := []struct {
+		name      string
+		err       error
+		transient bool
+		throttled bool
+	}{
+		{name: "aliyun throttling", err: throttled, transient: true, throttled: true},
+		{name: "wrapped aliyun throttling", err: fmt.Errorf("create vpc: %w", throttled), transient: true, throttled: true},
+		{name: "aliyun server error", err: aliyunerrors.NewServerError(503, `{"Code": "Unknown"}`, ""), transient: true, throttled: true},
+		{name: "aliyun client error", err: forbidden},
+		{name: "tencent throttling", err: tencenterrors.NewTencentCloudSDKError("RequestLimitExceeded", "", ""), transient: true, throttled: true},
+		{name: "wrapped tencent throttling", err: fmt.Errorf("create cluster from tencent api failure %w",
+			tencenterrors.NewTencentCloudSDKError("RequestLimitExceeded", "", "")), transient: true, throttled: true},
+		{name: "timeout", err: context.DeadlineExceeded, transient: true},
+		{name: "connection reset", err: syscall.ECONNRESET, transient: true},
+		{name: "cancelled", err: context.Canceled},
+		{name: "host not found", err: &url.Error{Op: "Get", URL: "https://ecs.cn-unknown.aliyuncs.com",
+			Err: &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "ecs.cn-unknown.aliyuncs.com", IsNotFound: true}}}},
+		{name: "sdk host not found", err: aliyunerrors.NewClientError("SDK.ServerUnreachable",
+			"dial tcp: lookup ecs.cn-unknown.aliyuncs.com: no such host", nil)},
+		{name: "dns timeout", err: &net.DNSError{Err: "i/o timeout", Name: "ecs.aliyuncs.com", IsTimeout: true}, transient: true},
+		{name: "permanent", err: errors.New("invalid config")},
+	}
+	for _, tc := range tests {
+		if got := IsTransient(tc.err); got != tc.transient {
+			t.Errorf("%s: want transient %v, got %v", tc.name, tc.transient, got)
+		}
+		if got := IsThrottled(tc.err); got != tc.throttled {
+			t.Errorf("%s: want throttled %v, got %v", tc.name, tc.throttled, got)
+		}
+	}
+	if !WithCodes("Forbbiden")(forbidden) {
+		t.Error("want the error of the given code retryable")
+	}
+}
@@ -19,6 +19,7 @@
 package ssh
 
 import (
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -27,6 +28,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
@@ -88,6 +90,67 @@ func MakeSSHKeyPair() (string, string, error) {
 	return string(EncodePrivateKey(pkey)), string(pub), nil
 }
 
+const (
+	// KeyTypeRSA the rsa key pair
+	KeyTypeRSA = "rsa"
+	// KeyTypeED25519 the ed25519 key pair
+	KeyTypeED25519 = "ed25519"
+)
+
+// MakeSSHKeyPairByType make the key pair of the given type, returns the private key in pem and the authorized key.
+func MakeSSHKeyPairByType(keyType string) (string, string, error) {
+	switch keyType {
+	case KeyTypeRSA:
+		pkey, pubkey, err := GenerateKey(4096)
+		if err != nil {
+			return "", "", err
+		}
+		pub, err := EncodeSSHKey(pubkey)
+		if err != nil {
+			return "", "", err
+		}
+		return string(EncodePrivateKey(pkey)), string(pub), nil
+	case KeyTypeED25519:
+		pubkey, pkey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return "", "", err
+		}
+		privateBytes, err := x509.MarshalPKCS8PrivateKey(pkey)
+		if err != nil {
+			return "", "", err
+		}
+		publicKey, err := ssh.NewPublicKey(pubkey)
+		if err != nil {
+			return "", "", err
+		}
+		private := pem.EncodeToMemory(&pem.Block{
+			Bytes: privateBytes,
+			Type:  "PRIVATE KEY",
+		})
+		return string(private), string(ssh.MarshalAuthorizedKey(publicKey)), nil
+	}
+	return "", "", fmt.Errorf("unsupported ssh key type %s", keyType)
+}
+
+// Fingerprint the sha256 fingerprint of the authorized key
+func Fingerprint(authorizedKey string) (string, error) {
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(authorizedKey))
+	if err != nil {
+		return "", err
+	}
+	return ssh.FingerprintSHA256(publicKey), nil
+}
+
+// AuthorizedKeyBlob the base64 key of the authorized key without the type and comment,
+// it identifies the key in the authorized_keys file.
+func AuthorizedKeyBlob(authorizedKey string) string {
+	fields := strings.Fields(authorizedKey)
+	if len(fields) < 2 {
+		return ""
+	}
+	return fields[1]
+}
+
 // GetOrMakeSSHRSA get or make ssh rsa
 func GetOrMakeSSHRSA() (string, error) {
 	home := homedir.HomeDir()
@@ -122,3 +185,20 @@ func GetOrMakeSSHRSA() (string, error) {
 	}
 	return string(pub), nil
 }
+
+// PrivateKeyPath the path of the ssh rsa used to connect the nodes
+func PrivateKeyPath() string {
+	return path.Join(homedir.HomeDir(), ".ssh", "id_rsa")
+}
+
+// GetSigner get the signer of the ssh rsa, the ssh rsa is made if not exist
+func GetSigner() (ssh.Signer, error) {
+	if _, err := GetOrMakeSSHRSA(); err != nil {
+		return nil, err
+	}
+	key, err := ioutil.ReadFile(PrivateKeyPath())
+	if err != nil {
+		return nil, fmt.Errorf("read ssh rsa file failure %s", err.Error())
+	}
+	return ssh.ParsePrivateKey(key)
+}
@@ -18,7 +18,12 @@
 
 package ssh
 
-import "testing"
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
 
 func TestGetOrMakeSSHRSA(t *testing.T) {
 	pub, err := GetOrMakeSSHRSA()
@@ -27,3 +32,32 @@ func TestGetOrMakeSSHRSA(t *testing.T) {
 	}
 	t.Log(pub)
 }
+
+func TestMakeSSHKeyPairByType(t *testing.T) {
+	for _, keyType := range []string{KeyTypeRSA, KeyTypeED25519} {
+		private, pub, err := MakeSSHKeyPairByType(keyType)
+		if err != nil {
+			t.Fatalf("make %s key pair: %v", keyType, err)
+		}
+		signer, err := ssh.ParsePrivateKey([]byte(private))
+		if err != nil {
+			t.Fatalf("parse %s private key: %v", keyType, err)
+		}
+		if got := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))); got != strings.TrimSpace(pub) {
+			t.Errorf("%s public key mismatch, want %s, got %s", keyType, pub, got)
+		}
+		fingerprint, err := Fingerprint(pub)
+		if err != nil {
+			t.Fatalf("fingerprint %s key: %v", keyType, err)
+		}
+		if !strings.HasPrefix(fingerprint, "SHA256:") {
+			t.Errorf("unexpected fingerprint %s", fingerprint)
+		}
+		if blob := AuthorizedKeyBlob(pub); blob == "" || !strings.Contains(pub, blob) {
+			t.Errorf("unexpected key blob %s", blob)
+		}
+	}
+	if _, _, err := MakeSSHKeyPairByType("dsa"); err == nil {
+		t.Error("expect error for unsupported key type")
+	}
+}
@@ -5,7 +5,8 @@ set -o nounset
 #set -o xtrace
 
 # default version, can be overridden by cmd line options
-export DOCKER_VER=19.03.5
+export DOCKER_VER=${DOCKER_VER:-19.03.5}
+export OFFLINE=${OFFLINE:-false}
 export REGISTRY_MIRROR=CN
 export CONSOLE=${CONSOLE:-false}
 export os_type=`cat /etc/os-release | grep "^ID=" | awk -F= '{print $2}' | tr -d [:punct:]`
@@ -17,7 +18,7 @@ function install_docker() {
         return 0
     }
 
-    DOCKER_URL="https://wutong-pkg.oss-cn-shanghai.aliyuncs.com/offline/docker/docker-${DOCKER_VER}.tgz"
+    DOCKER_URL=${DOCKER_URL:-"https://wutong-pkg.oss-cn-shanghai.aliyuncs.com/offline/docker/docker-${DOCKER_VER}.tgz"}
 
     sudo mkdir -p /usr/local/bin /etc/docker /opt/docker/down
     if [[ -f "/opt/docker/down/docker-${DOCKER_VER}.tgz" ]]; then
@@ -249,8 +250,8 @@ check_os
 # check kernel
 check_kernel
 
-# time sync
-time_sync
+# time sync, the time servers can not be reached offline
+$OFFLINE || time_sync
 
 # check cpu
 check_cpu